@@ -0,0 +1,341 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package authclient is a client of the http api of the auth service, which
+// is specified at /v1/openapi.json.
+package authclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
+
+	"storj.io/stargate/auth"
+)
+
+var mon = monkit.Package()
+
+// Error is the errs class of authclient errors.
+var Error = errs.Class("authclient")
+
+// maxErrorSize is how much of the body of an error response is kept.
+const maxErrorSize = 1024
+
+// StatusError is the error of a response that isn't successful, like
+// http.StatusNotFound for unknown access keys.
+type StatusError struct {
+	StatusCode int
+	Message    string
+	// RetryAfter is when the request can be retried, for responses with a
+	// Retry-After header.
+	RetryAfter time.Duration
+}
+
+// Error implements error.
+func (err *StatusError) Error() string {
+	return fmt.Sprintf("auth service responded with %d %s: %s", err.StatusCode, http.StatusText(err.StatusCode), err.Message)
+}
+
+// RegisterRequest registers an access grant.
+type RegisterRequest struct {
+	AccessGrant string            `json:"access_grant"`
+	Routes      []auth.Route      `json:"routes,omitempty"`
+	Public      bool              `json:"public"`
+	ExpiresAt   *time.Time        `json:"expires_at,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+
+	// IdempotencyKey makes Register return the access that a request with
+	// the same key registered before, so that it can be retried safely. It
+	// is ignored by RegisterBatch.
+	IdempotencyKey string `json:"-"`
+}
+
+// Credentials are the S3 credentials of a registered access.
+type Credentials struct {
+	AccessKeyID string `json:"access_key_id"`
+	SecretKey   string `json:"secret_key"`
+	Endpoint    string `json:"endpoint"`
+}
+
+// BatchResult is the result of an access of a batch: its credentials if it
+// was registered, or why it wasn't.
+type BatchResult struct {
+	Credentials
+	Error string `json:"error,omitempty"`
+}
+
+// Access is a resolved access.
+type Access struct {
+	AccessGrant string            `json:"access_grant"`
+	Routes      []auth.Route      `json:"routes,omitempty"`
+	SecretKey   string            `json:"secret_key"`
+	Public      bool              `json:"public"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// PassphraseRequest registers an access for a prefix of an access, whose
+// objects are encrypted with a key derived from a passphrase. The secret key
+// of the access authorizes the request when the client has no auth token.
+type PassphraseRequest struct {
+	SecretKey  string     `json:"secret_key,omitempty"`
+	Bucket     string     `json:"bucket"`
+	Prefix     string     `json:"prefix,omitempty"`
+	Passphrase string     `json:"passphrase"`
+	Salt       string     `json:"salt,omitempty"`
+	Public     bool       `json:"public"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+// Record is a listed record. The access key and the encrypted fields are not
+// listed, so a record is identified by its key hash.
+type Record struct {
+	KeyHash          string            `json:"key_hash"`
+	SatelliteAddress string            `json:"satellite_address"`
+	Public           bool              `json:"public"`
+	ExpiresAt        *time.Time        `json:"expires_at,omitempty"`
+	InvalidReason    string            `json:"invalid_reason,omitempty"`
+	DeletedAt        *time.Time        `json:"deleted_at,omitempty"`
+	Labels           map[string]string `json:"labels,omitempty"`
+}
+
+// ListOptions selects a page of records.
+type ListOptions struct {
+	// Cursor is the NextCursor of the previous page.
+	Cursor    string
+	Limit     int
+	Satellite string
+}
+
+// RecordPage is a page of records. NextCursor is empty on the last page.
+type RecordPage struct {
+	Records    []Record `json:"records"`
+	NextCursor string   `json:"next_cursor"`
+}
+
+// Client is a client of the auth service.
+type Client struct {
+	address string
+	token   string
+	actor   string
+	client  *http.Client
+}
+
+// New constructs a Client for the auth service at address, like
+// https://auth.example.com, which authorizes requests with the auth token.
+// The token may be empty for the requests that don't need one.
+func New(address, token string) *Client {
+	return &Client{
+		address: strings.TrimSuffix(address, "/"),
+		token:   token,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// WithActor returns a copy of the client whose requests name the actor, like
+// the operator behind an admin tool, in the history of the accesses that they
+// change.
+func (client *Client) WithActor(actor string) *Client {
+	copied := *client
+	copied.actor = actor
+	return &copied
+}
+
+// Register registers an access grant.
+func (client *Client) Register(ctx context.Context, request RegisterRequest) (_ Credentials, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	header := http.Header{}
+	if request.IdempotencyKey != "" {
+		header.Set("Idempotency-Key", request.IdempotencyKey)
+	}
+
+	var credentials Credentials
+	err = client.doHeader(ctx, http.MethodPost, "/v1/access", header, request, &credentials)
+	return credentials, err
+}
+
+// RegisterBatch registers many access grants, which are either all registered
+// or none. When partial is true, the accesses that can't be registered are
+// reported in their results instead, and the others are registered anyway.
+// The results are in the order of the requests.
+func (client *Client) RegisterBatch(ctx context.Context, requests []RegisterRequest, partial bool) (_ []BatchResult, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	request := struct {
+		Accesses []RegisterRequest `json:"accesses"`
+		Partial  bool              `json:"partial"`
+	}{requests, partial}
+	var response struct {
+		Accesses []BatchResult `json:"accesses"`
+	}
+	err = client.do(ctx, http.MethodPost, "/v1/access/batch", request, &response)
+	return response.Accesses, err
+}
+
+// Resolve returns the access of an access key.
+func (client *Client) Resolve(ctx context.Context, accessKeyID string) (_ Access, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	var access Access
+	err = client.do(ctx, http.MethodGet, accessPath(accessKeyID, ""), nil, &access)
+	return access, err
+}
+
+// Delete deletes an access, which can be restored until it is purged.
+func (client *Client) Delete(ctx context.Context, accessKeyID string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return client.do(ctx, http.MethodDelete, accessPath(accessKeyID, ""), nil, nil)
+}
+
+// Restore restores a deleted access.
+func (client *Client) Restore(ctx context.Context, accessKeyID string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return client.do(ctx, http.MethodPost, accessPath(accessKeyID, "/restore"), nil, nil)
+}
+
+// Invalidate invalidates an access for the reason.
+func (client *Client) Invalidate(ctx context.Context, accessKeyID, reason string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	request := struct {
+		Reason string `json:"reason"`
+	}{reason}
+	return client.do(ctx, http.MethodPut, accessPath(accessKeyID, "/invalid"), request, nil)
+}
+
+// InvalidateMacaroonHead invalidates every access whose api key has the
+// macaroon head, and returns how many were invalidated.
+func (client *Client) InvalidateMacaroonHead(ctx context.Context, head []byte, reason string) (_ int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	request := struct {
+		Reason string `json:"reason"`
+	}{reason}
+	var response struct {
+		Invalidated int64 `json:"invalidated"`
+	}
+	err = client.do(ctx, http.MethodPut, "/v1/macaroon/"+hex.EncodeToString(head)+"/invalid", request, &response)
+	return response.Invalidated, err
+}
+
+// History returns the events in the history of an access, oldest first.
+func (client *Client) History(ctx context.Context, accessKeyID string) (_ []auth.HistoryEvent, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	var response struct {
+		Events []auth.HistoryEvent `json:"events"`
+	}
+	err = client.do(ctx, http.MethodGet, accessPath(accessKeyID, "/history"), nil, &response)
+	return response.Events, err
+}
+
+// DerivePassphrase registers an access for a prefix of an access, whose
+// objects are encrypted with a key derived from a passphrase.
+func (client *Client) DerivePassphrase(ctx context.Context, accessKeyID string, request PassphraseRequest) (_ Credentials, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	var credentials Credentials
+	err = client.do(ctx, http.MethodPost, accessPath(accessKeyID, "/passphrase"), request, &credentials)
+	return credentials, err
+}
+
+// List returns a page of the records, including invalid and deleted ones.
+func (client *Client) List(ctx context.Context, opts ListOptions) (_ RecordPage, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	query := url.Values{}
+	if opts.Cursor != "" {
+		query.Set("cursor", opts.Cursor)
+	}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Satellite != "" {
+		query.Set("satellite", opts.Satellite)
+	}
+	path := "/v1/admin/access"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	var page RecordPage
+	err = client.do(ctx, http.MethodGet, path, nil, &page)
+	return page, err
+}
+
+// accessPath returns the path of a route of an access.
+func accessPath(accessKeyID, route string) string {
+	return "/v1/access/" + url.PathEscape(accessKeyID) + route
+}
+
+// do makes a request with the json encoding of body, if it isn't nil, and
+// decodes the response into response, if it isn't nil.
+func (client *Client) do(ctx context.Context, method, path string, body, response interface{}) error {
+	return client.doHeader(ctx, method, path, nil, body, response)
+}
+
+// doHeader is do for requests with more headers.
+func (client *Client) doHeader(ctx context.Context, method, path string, header http.Header, body, response interface{}) (err error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return Error.Wrap(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, client.address+path, reader)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if client.token != "" {
+		req.Header.Set("Authorization", "Bearer "+client.token)
+	}
+	if client.actor != "" {
+		req.Header.Set("X-Actor", client.actor)
+	}
+
+	resp, err := client.client.Do(req)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	defer func() { err = errs.Combine(err, Error.Wrap(resp.Body.Close())) }()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorSize))
+		statusErr := &StatusError{
+			StatusCode: resp.StatusCode,
+			Message:    strings.TrimSpace(string(message)),
+		}
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			statusErr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+		return Error.Wrap(statusErr)
+	}
+
+	if response == nil {
+		return nil
+	}
+	return Error.Wrap(json.NewDecoder(resp.Body).Decode(response))
+}
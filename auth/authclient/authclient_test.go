@@ -0,0 +1,91 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package authclient_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/stargate/auth"
+	"storj.io/stargate/auth/authclient"
+	"storj.io/stargate/auth/httpauth"
+	"storj.io/stargate/auth/memauth"
+)
+
+const minimalAccess = "138CV9Drxrw8ir1XpxcZhk2wnHjhzVjuSZe6yDsNiMZDP8cow9V6sHDYdwgvYoQGgqVvoMnxdWDbpBiEPW5oP7DtPJ5sZx2MVxFrUoZYFfVAgxidW"
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+
+	db := auth.NewDatabase(memauth.New())
+	server := httptest.NewServer(httpauth.New(db, "endpoint", httpauth.Tokens{"admin": httpauth.RoleAdmin}, nil))
+	defer server.Close()
+
+	admin := authclient.New(server.URL, "admin").WithActor("operator")
+	anonymous := authclient.New(server.URL, "")
+
+	credentials, err := anonymous.Register(ctx, authclient.RegisterRequest{
+		AccessGrant: minimalAccess,
+		Labels:      map[string]string{"team": "storage"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "endpoint", credentials.Endpoint)
+
+	// retries with an idempotency key register once
+	idempotent := authclient.RegisterRequest{AccessGrant: minimalAccess, IdempotencyKey: "provisioning-1"}
+	first, err := anonymous.Register(ctx, idempotent)
+	require.NoError(t, err)
+	retried, err := anonymous.Register(ctx, idempotent)
+	require.NoError(t, err)
+	require.Equal(t, first, retried)
+	require.NotEqual(t, credentials, first)
+
+	access, err := admin.Resolve(ctx, credentials.AccessKeyID)
+	require.NoError(t, err)
+	require.Equal(t, authclient.Access{
+		AccessGrant: minimalAccess,
+		SecretKey:   credentials.SecretKey,
+		Labels:      map[string]string{"team": "storage"},
+	}, access)
+
+	results, err := admin.RegisterBatch(ctx, []authclient.RegisterRequest{
+		{AccessGrant: minimalAccess},
+		{AccessGrant: minimalAccess, Routes: []auth.Route{{Bucket: ""}}},
+	}, true)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	require.NotEmpty(t, results[0].AccessKeyID)
+	require.NotEmpty(t, results[1].Error)
+
+	page, err := admin.List(ctx, authclient.ListOptions{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, page.Records, 3)
+	require.Empty(t, page.NextCursor)
+
+	require.NoError(t, admin.Invalidate(ctx, credentials.AccessKeyID, "leaked"))
+	require.NoError(t, admin.Delete(ctx, results[0].AccessKeyID))
+	require.NoError(t, admin.Restore(ctx, results[0].AccessKeyID))
+
+	history, err := admin.History(ctx, credentials.AccessKeyID)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	require.Equal(t, auth.HistoryInvalidated, history[1].Action)
+	require.Equal(t, "leaked", history[1].Reason)
+	require.Equal(t, "operator", history[1].Actor)
+
+	// errors have the status of the response
+	_, err = anonymous.Resolve(ctx, credentials.AccessKeyID)
+	var statusErr *authclient.StatusError
+	require.True(t, errors.As(err, &statusErr))
+	require.Equal(t, http.StatusUnauthorized, statusErr.StatusCode)
+
+	err = admin.Restore(ctx, credentials.AccessKeyID)
+	require.True(t, errors.As(err, &statusErr))
+	require.Equal(t, http.StatusNotFound, statusErr.StatusCode)
+}
@@ -0,0 +1,341 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package breakerauth stops calls to a KV backend while it is unhealthy.
+//
+// After enough consecutive failures the circuit breaker opens, and every call
+// fails fast with an *auth.UnavailableError instead of waiting on a database
+// that is down. Once the cooldown has passed a single call is let through to
+// probe the backend: if it succeeds the breaker closes, and if it fails the
+// breaker stays open for another cooldown.
+//
+// Every error counts as a failure except for invalid records and canceled or
+// timed out calls, which say nothing about the health of the backend.
+package breakerauth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+
+	"storj.io/stargate/auth"
+)
+
+var mon = monkit.Package()
+
+// Config configures the circuit breaker.
+type Config struct {
+	Failures int           `help:"number of consecutive database failures that stop calls to the database, or 0 to disable the circuit breaker" default:"5"`
+	Cooldown time.Duration `help:"how long calls to the database are stopped before one is let through to check whether it has recovered" default:"10s"`
+}
+
+// KV is a key/value store that stops calling another KV while it fails.
+type KV struct {
+	kv     auth.KV
+	config Config
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// New wraps kv with a circuit breaker configured by config. It returns kv
+// unchanged if the number of failures is not positive.
+func New(kv auth.KV, config Config) auth.KV {
+	if config.Failures <= 0 {
+		return kv
+	}
+	return &KV{kv: kv, config: config}
+}
+
+// allow returns nil if a call may be made to the wrapped key/value store, or
+// the error to fail it with.
+func (d *KV) allow() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.failures < d.config.Failures {
+		return nil
+	}
+
+	now := time.Now()
+	if now.Before(d.openUntil) || d.probing {
+		mon.Event("breaker_rejected")
+		retryAfter := d.openUntil.Sub(now)
+		if retryAfter <= 0 {
+			retryAfter = d.config.Cooldown
+		}
+		return &auth.UnavailableError{RetryAfter: retryAfter}
+	}
+
+	d.probing = true
+	return nil
+}
+
+// done records the result of a call to the wrapped key/value store.
+func (d *KV) done(err error) {
+	failed := err != nil && !auth.Invalid.Has(err) && !auth.Unsupported.Has(err) &&
+		!errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.probing = false
+	if !failed {
+		d.failures = 0
+		return
+	}
+
+	d.failures++
+	if d.failures >= d.config.Failures {
+		if d.failures == d.config.Failures {
+			mon.Event("breaker_opened")
+		}
+		d.openUntil = time.Now().Add(d.config.Cooldown)
+	}
+}
+
+// call calls fn if the circuit breaker allows it, and records its result.
+func (d *KV) call(fn func() error) error {
+	if err := d.allow(); err != nil {
+		return err
+	}
+	err := fn()
+	d.done(err)
+	return err
+}
+
+// Put stores the record in the wrapped key/value store.
+// It is an error if the key already exists.
+func (d *KV) Put(ctx context.Context, keyHash auth.KeyHash, record *auth.Record) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return d.call(func() error {
+		return d.kv.Put(ctx, keyHash, record)
+	})
+}
+
+// PutBatch stores all of the records in the wrapped key/value store.
+// It is an error if any of the keys already exist.
+func (d *KV) PutBatch(ctx context.Context, entries []auth.Entry) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return d.call(func() error {
+		return auth.PutBatch(ctx, d.kv, entries)
+	})
+}
+
+// Get retrieves the record from the wrapped key/value store.
+// It returns nil if the key does not exist.
+// If the record is invalid or expired, the error contains why.
+func (d *KV) Get(ctx context.Context, keyHash auth.KeyHash) (record *auth.Record, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	err = d.call(func() (err error) {
+		record, err = d.kv.Get(ctx, keyHash)
+		return err
+	})
+	return record, err
+}
+
+// GetBatch retrieves the records for all of the keys from the wrapped
+// key/value store. A record is nil if its key does not exist or if it is
+// invalid or expired.
+func (d *KV) GetBatch(ctx context.Context, keyHashes []auth.KeyHash) (records []*auth.Record, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	err = d.call(func() (err error) {
+		records, err = auth.GetBatch(ctx, d.kv, keyHashes)
+		return err
+	})
+	return records, err
+}
+
+// Delete removes the record from the wrapped key/value store.
+// It is not an error if the key does not exist.
+func (d *KV) Delete(ctx context.Context, keyHash auth.KeyHash) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return d.call(func() error {
+		return d.kv.Delete(ctx, keyHash)
+	})
+}
+
+// SoftDelete marks the record in the wrapped key/value store as deleted.
+// It is not an error if the key does not exist.
+func (d *KV) SoftDelete(ctx context.Context, keyHash auth.KeyHash) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return d.call(func() error {
+		return auth.SoftDelete(ctx, d.kv, keyHash)
+	})
+}
+
+// Restore undoes SoftDelete, and returns whether the record was deleted.
+// It is not an error if the key does not exist.
+func (d *KV) Restore(ctx context.Context, keyHash auth.KeyHash) (restored bool, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	err = d.call(func() (err error) {
+		restored, err = auth.Restore(ctx, d.kv, keyHash)
+		return err
+	})
+	return restored, err
+}
+
+// PurgeDeleted removes the records that were soft deleted before asOf from the
+// wrapped key/value store, and returns how many were removed.
+func (d *KV) PurgeDeleted(ctx context.Context, asOf time.Time) (purged int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	err = d.call(func() (err error) {
+		purged, err = auth.PurgeDeleted(ctx, d.kv, asOf)
+		return err
+	})
+	return purged, err
+}
+
+// Invalidate causes the record to become invalid.
+// It is not an error if the key does not exist.
+// It does not update the invalid reason if the record is already invalid.
+func (d *KV) Invalidate(ctx context.Context, keyHash auth.KeyHash, reason string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return d.call(func() error {
+		return d.kv.Invalidate(ctx, keyHash, reason)
+	})
+}
+
+// Update atomically changes the entry of the key in the wrapped key/value
+// store with fn, and returns whether the entry was stored.
+// It is not an error if the key does not exist.
+func (d *KV) Update(ctx context.Context, keyHash auth.KeyHash, fn func(entry *auth.Entry) (store bool, err error)) (updated bool, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	err = d.call(func() (err error) {
+		updated, err = auth.Update(ctx, d.kv, keyHash, fn)
+		return err
+	})
+	return updated, err
+}
+
+// InvalidateByMacaroonHead causes every record with the macaroon head to
+// become invalid, and returns how many were invalidated.
+func (d *KV) InvalidateByMacaroonHead(ctx context.Context, macaroonHead []byte, reason string) (invalidated int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	err = d.call(func() (err error) {
+		invalidated, err = auth.InvalidateByMacaroonHead(ctx, d.kv, macaroonHead, reason)
+		return err
+	})
+	return invalidated, err
+}
+
+// DeleteUnused removes the records that were invalidated or that expired
+// before asOf, and returns how many were removed.
+func (d *KV) DeleteUnused(ctx context.Context, asOf time.Time) (deleted int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	err = d.call(func() (err error) {
+		deleted, err = auth.DeleteUnused(ctx, d.kv, asOf)
+		return err
+	})
+	return deleted, err
+}
+
+// RecordUse adds uses to the use count of the key in the wrapped key/value
+// store.
+func (d *KV) RecordUse(ctx context.Context, keyHash auth.KeyHash, at time.Time, uses int64) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return d.call(func() error {
+		return auth.RecordUse(ctx, d.kv, keyHash, at, uses)
+	})
+}
+
+// CountActive returns how many records of the owner are active at now in the
+// wrapped key/value store.
+func (d *KV) CountActive(ctx context.Context, owner string, now time.Time) (count int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	err = d.call(func() (err error) {
+		count, err = auth.CountActive(ctx, d.kv, owner, now)
+		return err
+	})
+	return count, err
+}
+
+// AppendHistory adds the event to the history of the key in the wrapped
+// key/value store.
+func (d *KV) AppendHistory(ctx context.Context, keyHash auth.KeyHash, event auth.HistoryEvent) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return d.call(func() error {
+		return auth.AppendHistory(ctx, d.kv, keyHash, event)
+	})
+}
+
+// History returns the events of the key in the wrapped key/value store.
+func (d *KV) History(ctx context.Context, keyHash auth.KeyHash) (events []auth.HistoryEvent, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	err = d.call(func() (err error) {
+		events, err = auth.History(ctx, d.kv, keyHash)
+		return err
+	})
+	return events, err
+}
+
+// Iterate calls fn for every record in the wrapped key/value store, including
+// invalid, expired and soft deleted records. Errors returned by fn don't count
+// as failures of the wrapped key/value store.
+func (d *KV) Iterate(ctx context.Context, fn func(ctx context.Context, entry auth.Entry) error) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if err := d.allow(); err != nil {
+		return err
+	}
+
+	var fnErr error
+	err = auth.Iterate(ctx, d.kv, func(ctx context.Context, entry auth.Entry) error {
+		fnErr = fn(ctx, entry)
+		return fnErr
+	})
+	if fnErr != nil {
+		d.done(nil)
+	} else {
+		d.done(err)
+	}
+	return err
+}
+
+// List returns a page of the records in the wrapped key/value store, including
+// invalid, expired and soft deleted records.
+func (d *KV) List(ctx context.Context, after auth.KeyHash, limit int) (entries []auth.Entry, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	err = d.call(func() (err error) {
+		entries, err = auth.List(ctx, d.kv, after, limit)
+		return err
+	})
+	return entries, err
+}
+
+// HealthCheck checks the wrapped key/value store. It fails without calling it
+// while the breaker is open.
+func (d *KV) HealthCheck(ctx context.Context) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return d.call(func() error {
+		return auth.HealthCheck(ctx, d.kv)
+	})
+}
+
+// Close closes the wrapped key/value store.
+func (d *KV) Close() error {
+	return auth.Close(d.kv)
+}
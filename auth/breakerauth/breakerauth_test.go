@@ -0,0 +1,96 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package breakerauth_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/stargate/auth"
+	"storj.io/stargate/auth/breakerauth"
+	"storj.io/stargate/auth/kvtest"
+	"storj.io/stargate/auth/memauth"
+)
+
+func TestKV(t *testing.T) {
+	// the conformance tests expect errors, like for colliding puts, so the
+	// breaker must not open during them
+	kvtest.RunTests(t, func() auth.KV {
+		return breakerauth.New(memauth.New(), breakerauth.Config{Failures: 1000, Cooldown: time.Second})
+	})
+}
+
+// failingKV is a key/value store whose lookups fail while failing is set.
+type failingKV struct {
+	*memauth.KV
+	failing *bool
+	calls   *int
+}
+
+func (kv failingKV) Get(ctx context.Context, keyHash auth.KeyHash) (*auth.Record, error) {
+	*kv.calls++
+	if *kv.failing {
+		return nil, errors.New("database is down")
+	}
+	return kv.KV.Get(ctx, keyHash)
+}
+
+func TestKV_Breaker(t *testing.T) {
+	ctx := context.Background()
+	failing, calls := true, 0
+	kv := breakerauth.New(failingKV{KV: memauth.New(), failing: &failing, calls: &calls},
+		breakerauth.Config{Failures: 2, Cooldown: 50 * time.Millisecond})
+
+	unavailable := func(err error) bool {
+		var unavailable *auth.UnavailableError
+		return errors.As(err, &unavailable)
+	}
+
+	// failures up to the threshold reach the database
+	for i := 0; i < 2; i++ {
+		_, err := kv.Get(ctx, auth.KeyHash{1})
+		require.Error(t, err)
+		require.False(t, unavailable(err))
+	}
+	require.Equal(t, 2, calls)
+
+	// then calls fail fast
+	_, err := kv.Get(ctx, auth.KeyHash{1})
+	require.True(t, unavailable(err))
+	require.Equal(t, 2, calls)
+
+	// after the cooldown a failing probe opens the breaker again
+	time.Sleep(60 * time.Millisecond)
+	_, err = kv.Get(ctx, auth.KeyHash{1})
+	require.False(t, unavailable(err))
+	_, err = kv.Get(ctx, auth.KeyHash{1})
+	require.True(t, unavailable(err))
+	require.Equal(t, 3, calls)
+
+	// and a successful probe closes it
+	failing = false
+	time.Sleep(60 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		_, err = kv.Get(ctx, auth.KeyHash{1})
+		require.NoError(t, err)
+	}
+	require.Equal(t, 6, calls)
+
+	// invalid records are not failures
+	require.NoError(t, kv.Put(ctx, auth.KeyHash{2}, &auth.Record{}))
+	require.NoError(t, kv.Invalidate(ctx, auth.KeyHash{2}, "test"))
+	for i := 0; i < 3; i++ {
+		_, err = kv.Get(ctx, auth.KeyHash{2})
+		require.True(t, auth.Invalid.Has(err))
+	}
+}
+
+func TestKV_Disabled(t *testing.T) {
+	inner := memauth.New()
+	require.Equal(t, auth.KV(inner), breakerauth.New(inner, breakerauth.Config{Cooldown: time.Second}))
+}
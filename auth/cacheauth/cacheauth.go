@@ -0,0 +1,433 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package cacheauth caches the records of a KV backend in memory.
+//
+// Lookups of the same hot keys are served from a least recently used cache,
+// including lookups of keys that don't exist or whose records are invalid.
+// Changes made through the cache evict the keys they change, but changes made
+// to the backend by anyone else, like another instance of the auth service,
+// are only seen once the cached entries expire.
+package cacheauth
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+
+	"storj.io/stargate/auth"
+)
+
+var mon = monkit.Package()
+
+// Config configures the cache.
+type Config struct {
+	Size        int           `help:"maximum number of records to cache in memory, or 0 to disable the cache" default:"0"`
+	TTL         time.Duration `help:"how long cached records are used before they are looked up again" default:"1m"`
+	NegativeTTL time.Duration `help:"how long keys that don't exist or whose records are invalid are remembered before they are looked up again" default:"10s"`
+}
+
+// entry is a cached result of Get.
+type entry struct {
+	keyHash   auth.KeyHash
+	record    *auth.Record
+	err       error
+	expiresAt time.Time
+}
+
+// KV is a key/value store that caches the records of another KV.
+type KV struct {
+	kv     auth.KV
+	config Config
+
+	mu      sync.Mutex
+	entries map[auth.KeyHash]*list.Element
+	lru     *list.List // of *entry, most recently used first
+
+	// generation counts evictions. Lookups of the wrapped key/value store
+	// remember the generation they start at, and their results aren't cached
+	// if their key was evicted since, so that a lookup that races a change
+	// doesn't cache the record from before the change. Evictions are only
+	// remembered for the keys with lookups in flight.
+	generation uint64
+	inflight   map[auth.KeyHash]int
+	evictedAt  map[auth.KeyHash]uint64
+	clearedAt  uint64
+}
+
+// New wraps kv with a cache configured by config. It returns kv unchanged if
+// the size of the cache is not positive.
+func New(kv auth.KV, config Config) auth.KV {
+	if config.Size <= 0 {
+		return kv
+	}
+	return &KV{
+		kv:      kv,
+		config:  config,
+		entries: make(map[auth.KeyHash]*list.Element),
+		lru:     list.New(),
+
+		inflight:  make(map[auth.KeyHash]int),
+		evictedAt: make(map[auth.KeyHash]uint64),
+	}
+}
+
+// lookup returns the cached entry of the key, or nil if there is none.
+func (d *KV) lookup(keyHash auth.KeyHash, now time.Time) *entry {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	element, ok := d.entries[keyHash]
+	if !ok {
+		return nil
+	}
+	cached := element.Value.(*entry)
+	if !now.Before(cached.expiresAt) {
+		d.lru.Remove(element)
+		delete(d.entries, keyHash)
+		return nil
+	}
+	d.lru.MoveToFront(element)
+	return cached
+}
+
+// begin starts a lookup of the key in the wrapped key/value store, and returns
+// the generation that add needs. The lookup must be finished with add or
+// abandon.
+func (d *KV) begin(keyHash auth.KeyHash) (generation uint64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.inflight[keyHash]++
+	return d.generation
+}
+
+// finish finishes a lookup of the key that started at generation, and returns
+// whether its result may be cached, which it may not if the key was evicted
+// since. d.mu must be held.
+func (d *KV) finish(keyHash auth.KeyHash, generation uint64) (current bool) {
+	current = d.evictedAt[keyHash] <= generation && d.clearedAt <= generation
+	if d.inflight[keyHash]--; d.inflight[keyHash] <= 0 {
+		delete(d.inflight, keyHash)
+		delete(d.evictedAt, keyHash)
+	}
+	if !current {
+		mon.Event("cache_stale_lookup")
+	}
+	return current
+}
+
+// abandon finishes a lookup of the key whose result isn't cached.
+func (d *KV) abandon(keyHash auth.KeyHash, generation uint64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.finish(keyHash, generation)
+}
+
+// add finishes a lookup of the key that started at generation, and caches its
+// result unless the key was evicted since, evicting the least recently used
+// entry if the cache is full.
+func (d *KV) add(keyHash auth.KeyHash, generation uint64, record *auth.Record, err error, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.finish(keyHash, generation) {
+		return
+	}
+
+	ttl := d.config.TTL
+	if record == nil {
+		ttl = d.config.NegativeTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	cached := &entry{keyHash: keyHash, record: record, err: err, expiresAt: now.Add(ttl)}
+	if element, ok := d.entries[keyHash]; ok {
+		element.Value = cached
+		d.lru.MoveToFront(element)
+		return
+	}
+
+	d.entries[keyHash] = d.lru.PushFront(cached)
+	for d.lru.Len() > d.config.Size {
+		oldest := d.lru.Back()
+		d.lru.Remove(oldest)
+		delete(d.entries, oldest.Value.(*entry).keyHash)
+	}
+}
+
+// evict removes the keys from the cache, and keeps the lookups of them that
+// are in flight from caching their results.
+func (d *KV) evict(keyHashes ...auth.KeyHash) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.generation++
+	for _, keyHash := range keyHashes {
+		if element, ok := d.entries[keyHash]; ok {
+			d.lru.Remove(element)
+			delete(d.entries, keyHash)
+		}
+		if d.inflight[keyHash] > 0 {
+			d.evictedAt[keyHash] = d.generation
+		}
+	}
+}
+
+// evictAll empties the cache, and keeps the lookups that are in flight from
+// caching their results.
+func (d *KV) evictAll() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.generation++
+	d.clearedAt = d.generation
+	d.entries = make(map[auth.KeyHash]*list.Element)
+	d.lru.Init()
+}
+
+// Put stores the record in the wrapped key/value store.
+// It is an error if the key already exists.
+func (d *KV) Put(ctx context.Context, keyHash auth.KeyHash, record *auth.Record) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	defer d.evict(keyHash)
+	return d.kv.Put(ctx, keyHash, record)
+}
+
+// PutBatch stores all of the records in the wrapped key/value store.
+// It is an error if any of the keys already exist.
+func (d *KV) PutBatch(ctx context.Context, entries []auth.Entry) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	keyHashes := make([]auth.KeyHash, len(entries))
+	for i, entry := range entries {
+		keyHashes[i] = entry.KeyHash
+	}
+	defer d.evict(keyHashes...)
+	return auth.PutBatch(ctx, d.kv, entries)
+}
+
+// Get retrieves the record from the cache, or from the wrapped key/value store
+// if it is not cached.
+// It returns nil if the key does not exist.
+// If the record is invalid or expired, the error contains why.
+func (d *KV) Get(ctx context.Context, keyHash auth.KeyHash) (record *auth.Record, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	now := time.Now()
+	if cached := d.lookup(keyHash, now); cached != nil {
+		mon.Event("cache_hit")
+		if cached.record != nil && cached.record.Expired(now) {
+			return nil, auth.ErrExpired(cached.record)
+		}
+		return cached.record, cached.err
+	}
+	mon.Event("cache_miss")
+
+	generation := d.begin(keyHash)
+	record, err = d.kv.Get(ctx, keyHash)
+	switch {
+	case err == nil:
+		d.add(keyHash, generation, record, nil, now)
+	case auth.Invalid.Has(err):
+		// invalid records are cached like missing ones
+		d.add(keyHash, generation, nil, err, now)
+	default:
+		d.abandon(keyHash, generation)
+	}
+	return record, err
+}
+
+// GetBatch retrieves the records for all of the keys from the cache, and the
+// ones that are not cached from the wrapped key/value store. A record is nil if
+// its key does not exist or if it is invalid or expired.
+func (d *KV) GetBatch(ctx context.Context, keyHashes []auth.KeyHash) (records []*auth.Record, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	now := time.Now()
+	records = make([]*auth.Record, len(keyHashes))
+
+	var missing []int
+	for i, keyHash := range keyHashes {
+		cached := d.lookup(keyHash, now)
+		if cached == nil {
+			missing = append(missing, i)
+			continue
+		}
+		if cached.record != nil && !cached.record.Expired(now) {
+			records[i] = cached.record
+		}
+	}
+	mon.IntVal("cache_batch_misses").Observe(int64(len(missing)))
+	if len(missing) == 0 {
+		return records, nil
+	}
+
+	missingHashes := make([]auth.KeyHash, len(missing))
+	generations := make([]uint64, len(missing))
+	for j, i := range missing {
+		missingHashes[j] = keyHashes[i]
+		generations[j] = d.begin(keyHashes[i])
+	}
+	fetched, err := auth.GetBatch(ctx, d.kv, missingHashes)
+	for j := range missing {
+		// a nil record may be missing or invalid, and Get has to tell
+		// them apart, so only records are cached
+		if err == nil && fetched[j] != nil {
+			d.add(missingHashes[j], generations[j], fetched[j], nil, now)
+			continue
+		}
+		d.abandon(missingHashes[j], generations[j])
+	}
+	if err != nil {
+		return nil, err
+	}
+	for j, i := range missing {
+		records[i] = fetched[j]
+	}
+	return records, nil
+}
+
+// Delete removes the record from the wrapped key/value store.
+// It is not an error if the key does not exist.
+func (d *KV) Delete(ctx context.Context, keyHash auth.KeyHash) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	defer d.evict(keyHash)
+	return d.kv.Delete(ctx, keyHash)
+}
+
+// SoftDelete marks the record in the wrapped key/value store as deleted.
+// It is not an error if the key does not exist.
+func (d *KV) SoftDelete(ctx context.Context, keyHash auth.KeyHash) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	defer d.evict(keyHash)
+	return auth.SoftDelete(ctx, d.kv, keyHash)
+}
+
+// Restore undoes SoftDelete, and returns whether the record was deleted.
+// It is not an error if the key does not exist.
+func (d *KV) Restore(ctx context.Context, keyHash auth.KeyHash) (restored bool, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	defer d.evict(keyHash)
+	return auth.Restore(ctx, d.kv, keyHash)
+}
+
+// PurgeDeleted removes the records that were soft deleted before asOf from the
+// wrapped key/value store, and returns how many were removed.
+func (d *KV) PurgeDeleted(ctx context.Context, asOf time.Time) (purged int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	// soft deleted records are already cached as missing
+	return auth.PurgeDeleted(ctx, d.kv, asOf)
+}
+
+// Invalidate causes the record to become invalid.
+// It is not an error if the key does not exist.
+// It does not update the invalid reason if the record is already invalid.
+func (d *KV) Invalidate(ctx context.Context, keyHash auth.KeyHash, reason string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	defer d.evict(keyHash)
+	return d.kv.Invalidate(ctx, keyHash, reason)
+}
+
+// Update atomically changes the entry of the key in the wrapped key/value
+// store with fn, and returns whether the entry was stored.
+// It is not an error if the key does not exist.
+func (d *KV) Update(ctx context.Context, keyHash auth.KeyHash, fn func(entry *auth.Entry) (store bool, err error)) (updated bool, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	defer d.evict(keyHash)
+	return auth.Update(ctx, d.kv, keyHash, fn)
+}
+
+// InvalidateByMacaroonHead causes every record with the macaroon head to
+// become invalid, and returns how many were invalidated. The whole cache is
+// emptied, because the keys of the records are not known.
+func (d *KV) InvalidateByMacaroonHead(ctx context.Context, macaroonHead []byte, reason string) (invalidated int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	defer d.evictAll()
+	return auth.InvalidateByMacaroonHead(ctx, d.kv, macaroonHead, reason)
+}
+
+// DeleteUnused removes the records that were invalidated or that expired
+// before asOf, and returns how many were removed. The whole cache is emptied,
+// because the keys of the records are not known.
+func (d *KV) DeleteUnused(ctx context.Context, asOf time.Time) (deleted int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	defer d.evictAll()
+	return auth.DeleteUnused(ctx, d.kv, asOf)
+}
+
+// RecordUse adds uses to the use count of the key in the wrapped key/value
+// store. The key is evicted, so that its use isn't read from the cache.
+func (d *KV) RecordUse(ctx context.Context, keyHash auth.KeyHash, at time.Time, uses int64) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	defer d.evict(keyHash)
+	return auth.RecordUse(ctx, d.kv, keyHash, at, uses)
+}
+
+// CountActive returns how many records of the owner are active at now in the
+// wrapped key/value store. Counts are not cached.
+func (d *KV) CountActive(ctx context.Context, owner string, now time.Time) (count int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.CountActive(ctx, d.kv, owner, now)
+}
+
+// AppendHistory adds the event to the history of the key in the wrapped
+// key/value store. Histories are not cached.
+func (d *KV) AppendHistory(ctx context.Context, keyHash auth.KeyHash, event auth.HistoryEvent) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.AppendHistory(ctx, d.kv, keyHash, event)
+}
+
+// History returns the events of the key in the wrapped key/value store.
+func (d *KV) History(ctx context.Context, keyHash auth.KeyHash) (events []auth.HistoryEvent, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.History(ctx, d.kv, keyHash)
+}
+
+// Iterate calls fn for every record in the wrapped key/value store, including
+// invalid, expired and soft deleted records. Iterating bypasses the cache.
+func (d *KV) Iterate(ctx context.Context, fn func(ctx context.Context, entry auth.Entry) error) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.Iterate(ctx, d.kv, fn)
+}
+
+// List returns a page of the records in the wrapped key/value store, including
+// invalid, expired and soft deleted records. Listing bypasses the cache.
+func (d *KV) List(ctx context.Context, after auth.KeyHash, limit int) (entries []auth.Entry, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.List(ctx, d.kv, after, limit)
+}
+
+// HealthCheck checks the wrapped key/value store, bypassing the cache.
+func (d *KV) HealthCheck(ctx context.Context) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.HealthCheck(ctx, d.kv)
+}
+
+// Close empties the cache and closes the wrapped key/value store.
+func (d *KV) Close() error {
+	d.evictAll()
+	return auth.Close(d.kv)
+}
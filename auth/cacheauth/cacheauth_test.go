@@ -0,0 +1,147 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package cacheauth_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/stargate/auth"
+	"storj.io/stargate/auth/cacheauth"
+	"storj.io/stargate/auth/kvtest"
+	"storj.io/stargate/auth/memauth"
+)
+
+func TestKV(t *testing.T) {
+	kvtest.RunTests(t, func() auth.KV {
+		return cacheauth.New(memauth.New(), cacheauth.Config{Size: 100, TTL: time.Hour, NegativeTTL: time.Hour})
+	})
+}
+
+func TestKV_Disabled(t *testing.T) {
+	inner := memauth.New()
+	require.Equal(t, auth.KV(inner), cacheauth.New(inner, cacheauth.Config{TTL: time.Hour}))
+}
+
+func TestKV_Cache(t *testing.T) {
+	ctx := context.Background()
+	inner := memauth.New()
+	kv := cacheauth.New(inner, cacheauth.Config{Size: 2, TTL: time.Hour, NegativeTTL: time.Hour})
+
+	record := &auth.Record{MacaroonHead: []byte("head"), EncryptedAccessGrant: []byte("grant")}
+	for _, keyHash := range []auth.KeyHash{{1}, {2}, {3}} {
+		require.NoError(t, inner.Put(ctx, keyHash, record))
+	}
+
+	get := func(keyHash auth.KeyHash) *auth.Record {
+		record, err := kv.Get(ctx, keyHash)
+		require.NoError(t, err)
+		return record
+	}
+
+	// cached records are served after the backend changes
+	require.NotNil(t, get(auth.KeyHash{1}))
+	require.NoError(t, inner.Delete(ctx, auth.KeyHash{1}))
+	require.NotNil(t, get(auth.KeyHash{1}))
+
+	// and so are missing keys
+	require.Nil(t, get(auth.KeyHash{4}))
+	require.NoError(t, inner.Put(ctx, auth.KeyHash{4}, record))
+	require.Nil(t, get(auth.KeyHash{4}))
+
+	// the least recently used key is evicted
+	require.NotNil(t, get(auth.KeyHash{2}))
+	require.Nil(t, get(auth.KeyHash{1}))
+
+	// changes through the cache evict the key
+	require.NotNil(t, get(auth.KeyHash{3}))
+	require.NoError(t, kv.Invalidate(ctx, auth.KeyHash{3}, "test"))
+	_, err := kv.Get(ctx, auth.KeyHash{3})
+	require.True(t, auth.Invalid.Has(err))
+
+	require.NotNil(t, get(auth.KeyHash{2}))
+	_, err = auth.InvalidateByMacaroonHead(ctx, kv, record.MacaroonHead, "test")
+	require.NoError(t, err)
+	_, err = kv.Get(ctx, auth.KeyHash{2})
+	require.True(t, auth.Invalid.Has(err))
+
+	// batches are served from the cache too
+	require.NoError(t, inner.Put(ctx, auth.KeyHash{5}, record))
+	records, err := auth.GetBatch(ctx, kv, []auth.KeyHash{{5}, {6}})
+	require.NoError(t, err)
+	require.NotNil(t, records[0])
+	require.NoError(t, inner.Delete(ctx, auth.KeyHash{5}))
+	require.NotNil(t, get(auth.KeyHash{5}))
+}
+
+func TestKV_TTL(t *testing.T) {
+	ctx := context.Background()
+	inner := memauth.New()
+	kv := cacheauth.New(inner, cacheauth.Config{Size: 10, TTL: 10 * time.Millisecond})
+
+	require.NoError(t, inner.Put(ctx, auth.KeyHash{1}, &auth.Record{}))
+	record, err := kv.Get(ctx, auth.KeyHash{1})
+	require.NoError(t, err)
+	require.NotNil(t, record)
+
+	require.NoError(t, inner.Delete(ctx, auth.KeyHash{1}))
+	time.Sleep(20 * time.Millisecond)
+
+	record, err = kv.Get(ctx, auth.KeyHash{1})
+	require.NoError(t, err)
+	require.Nil(t, record)
+
+	// without a negative ttl, missing keys are not cached
+	require.NoError(t, inner.Put(ctx, auth.KeyHash{1}, &auth.Record{}))
+	record, err = kv.Get(ctx, auth.KeyHash{1})
+	require.NoError(t, err)
+	require.NotNil(t, record)
+}
+
+// slowGet is a key/value store whose lookups wait for release after they read
+// the record, like a lookup whose response is delayed.
+type slowGet struct {
+	auth.KV
+	read    chan struct{}
+	release chan struct{}
+}
+
+func (kv *slowGet) Get(ctx context.Context, keyHash auth.KeyHash) (*auth.Record, error) {
+	record, err := kv.KV.Get(ctx, keyHash)
+	kv.read <- struct{}{}
+	<-kv.release
+	return record, err
+}
+
+func TestKV_RacingChange(t *testing.T) {
+	ctx := context.Background()
+	inner := memauth.New()
+	slow := &slowGet{KV: inner, read: make(chan struct{}), release: make(chan struct{})}
+	kv := cacheauth.New(slow, cacheauth.Config{Size: 10, TTL: time.Hour, NegativeTTL: time.Hour})
+
+	require.NoError(t, inner.Put(ctx, auth.KeyHash{1}, &auth.Record{}))
+
+	// a lookup reads the record before it is invalidated, but returns after
+	done := make(chan error)
+	go func() {
+		_, err := kv.Get(ctx, auth.KeyHash{1})
+		done <- err
+	}()
+	<-slow.read
+	require.NoError(t, kv.Invalidate(ctx, auth.KeyHash{1}, "test"))
+	close(slow.release)
+	require.NoError(t, <-done)
+
+	// the record from before the change isn't cached
+	go func() { <-slow.read }()
+	_, err := kv.Get(ctx, auth.KeyHash{1})
+	require.True(t, auth.Invalid.Has(err), "expected an invalid error, got %v", err)
+
+	// and the lookup after it is
+	_, err = kv.Get(ctx, auth.KeyHash{1})
+	require.True(t, auth.Invalid.Has(err), "expected an invalid error, got %v", err)
+}
@@ -7,10 +7,16 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/json"
+	"sync"
+	"time"
 
+	"github.com/btcsuite/btcutil/base58"
 	"github.com/zeebo/errs"
 
 	"storj.io/common/encryption"
+	"storj.io/common/macaroon"
+	"storj.io/common/pb"
 	"storj.io/common/storj"
 	"storj.io/uplink"
 )
@@ -18,6 +24,10 @@ import (
 // NotFound is returned when a record is not found.
 var NotFound = errs.Class("not found")
 
+// AccessGrantError is the class of errors for access grants that can't be
+// parsed.
+var AccessGrantError = errs.Class("access grant")
+
 // EncryptionKey is an encryption key that an access/secret are encrypted with.
 type EncryptionKey [32]byte
 
@@ -28,7 +38,11 @@ func (k EncryptionKey) Hash() KeyHash {
 
 // Database wraps a key/value store and uses it to store encrypted accesses and secrets.
 type Database struct {
-	kv KV
+	kv             KV
+	usage          *UsageTracker
+	quotas         QuotaConfig
+	quotaLocks     [quotaLockStripes]sync.Mutex
+	derivationKeys *DerivationKeys
 }
 
 // NewDatabase constructs a Database.
@@ -36,85 +50,495 @@ func NewDatabase(kv KV) *Database {
 	return &Database{kv: kv}
 }
 
-// Put encrypts the access grant with the key and stores it in a key/value store under the
-// hash of the encryption key.
-func (db *Database) Put(ctx context.Context, key EncryptionKey, accessGrant string, public bool) (
-	secretKey []byte, err error) {
+// KV returns the key/value store that the database wraps.
+func (db *Database) KV() KV {
+	return db.kv
+}
+
+// SetUsageTracker sets the tracker that Resolve counts the uses of keys with.
+func (db *Database) SetUsageTracker(usage *UsageTracker) {
+	db.usage = usage
+}
+
+// Put encrypts the access grant and routes with the key and stores them in a key/value store
+// under the hash of the encryption key. If expiresAt is not nil, the access stops being valid then.
+// The labels are stored unencrypted.
+func (db *Database) Put(ctx context.Context, key EncryptionKey, accessGrant string, routes []Route, public bool, expiresAt *time.Time,
+	labels map[string]string) (secretKey []byte, err error) {
 	defer mon.Task()(&ctx)(&err)
 
-	access, err := uplink.ParseAccess(accessGrant)
+	return db.PutOwned(ctx, "", key, accessGrant, routes, public, expiresAt, labels)
+}
+
+// PutOwned is like Put, but the access is registered by the owner, like
+// TokenOwner, and counts towards its quota. It fails with QuotaExceeded if the
+// owner has as many active records as its quota already. The records of an
+// owner are counted and stored under a lock of the process, so concurrent
+// registrations can't exceed its quota, but registrations on other instances
+// that share the key/value store can, by up to one record per instance.
+func (db *Database) PutOwned(ctx context.Context, owner string, key EncryptionKey, accessGrant string, routes []Route, public bool,
+	expiresAt *time.Time, labels map[string]string) (secretKey []byte, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	record, secretKey, err := db.newRecord(key, accessGrant, routes, public, expiresAt, labels)
 	if err != nil {
 		return nil, err
 	}
-	_ = access // TODO: use access below
+	record.Owner = owner
 
-	secretKey = make([]byte, 32)
-	if _, err := rand.Read(secretKey); err != nil {
+	defer db.lockQuotas(owner)()
+	if remaining, err := db.remainingQuota(ctx, owner); err != nil {
 		return nil, err
+	} else if remaining == 0 {
+		return nil, db.quotaError(owner)
+	}
+
+	if err := db.kv.Put(ctx, key.Hash(), record); err != nil {
+		return nil, errs.Wrap(err)
+	}
+
+	return secretKey, err
+}
+
+// PutRequest is a single access grant to store with PutBatch.
+type PutRequest struct {
+	Key         EncryptionKey
+	AccessGrant string
+	Routes      []Route
+	Public      bool
+	ExpiresAt   *time.Time
+	Labels      map[string]string
+	// Owner is who registers the access, whose quota it counts towards.
+	Owner string
+}
+
+// PutBatch is like PutOwned for many access grants, but stores them with a single call
+// to the key/value store. The returned secret keys are in the same order as the requests.
+// None are stored if any owner would exceed its quota.
+func (db *Database) PutBatch(ctx context.Context, requests []PutRequest) (secretKeys [][]byte, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	defer db.lockQuotas(requestOwners(requests)...)()
+
+	entries := make([]Entry, 0, len(requests))
+	secretKeys = make([][]byte, 0, len(requests))
+	remaining := make(map[string]int64)
+	for _, request := range requests {
+		record, secretKey, err := db.newRecord(request.Key, request.AccessGrant, request.Routes, request.Public, request.ExpiresAt, request.Labels)
+		if err != nil {
+			return nil, err
+		}
+		record.Owner = request.Owner
+		if ok, err := db.withinQuota(ctx, remaining, request.Owner); err != nil {
+			return nil, err
+		} else if !ok {
+			return nil, db.quotaError(request.Owner)
+		}
+		entries = append(entries, Entry{KeyHash: request.Key.Hash(), Record: record})
+		secretKeys = append(secretKeys, secretKey)
+	}
+
+	if err := PutBatch(ctx, db.kv, entries); err != nil {
+		return nil, errs.Wrap(err)
+	}
+
+	return secretKeys, nil
+}
+
+// PutBatchPartial is like PutBatch, but a request that can't be stored, like
+// one with an invalid access grant or beyond the quota of its owner, doesn't
+// stop the others from being stored.
+// The returned secret keys and errors are in the same order as the requests,
+// and only one of them is set for every request. err is only returned when
+// the key/value store fails, and then none of the requests are stored by
+// backends that support transactions.
+func (db *Database) PutBatchPartial(ctx context.Context, requests []PutRequest) (secretKeys [][]byte, errors []error, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	defer db.lockQuotas(requestOwners(requests)...)()
+
+	entries := make([]Entry, 0, len(requests))
+	secretKeys = make([][]byte, len(requests))
+	errors = make([]error, len(requests))
+	remaining := make(map[string]int64)
+	for i, request := range requests {
+		record, secretKey, err := db.newRecord(request.Key, request.AccessGrant, request.Routes, request.Public, request.ExpiresAt, request.Labels)
+		if err != nil {
+			errors[i] = err
+			continue
+		}
+		record.Owner = request.Owner
+		if ok, err := db.withinQuota(ctx, remaining, request.Owner); err != nil {
+			return nil, nil, err
+		} else if !ok {
+			errors[i] = db.quotaError(request.Owner)
+			continue
+		}
+		entries = append(entries, Entry{KeyHash: request.Key.Hash(), Record: record})
+		secretKeys[i] = secretKey
+	}
+
+	if len(entries) > 0 {
+		if err := PutBatch(ctx, db.kv, entries); err != nil {
+			return nil, nil, errs.Wrap(err)
+		}
+	}
+
+	return secretKeys, errors, nil
+}
+
+// requestOwners returns the owners of the requests.
+func requestOwners(requests []PutRequest) []string {
+	owners := make([]string, 0, len(requests))
+	for _, request := range requests {
+		owners = append(owners, request.Owner)
+	}
+	return owners
+}
+
+// newRecord generates or derives a secret key and builds the record that stores
+// it, or its hash if it is derived, and the access grant and routes encrypted
+// with the key.
+func (db *Database) newRecord(key EncryptionKey, accessGrant string, routes []Route, public bool, expiresAt *time.Time, labels map[string]string) (record *Record, secretKey []byte, err error) {
+	parsed, err := parseAccessGrant(accessGrant, time.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+	expiresAt = parsed.expiration(expiresAt)
+
+	if err := ValidateRoutes(routes); err != nil {
+		return nil, nil, err
+	}
+	if err := ValidateLabels(labels); err != nil {
+		return nil, nil, err
+	}
+	if len(labels) == 0 {
+		labels = nil
+	}
+	payload, err := encodePayload(accessGrant, routes)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	storjKey := storj.Key(key)
 	nonce := &storj.Nonce{}
 
-	encryptedSecretKey, err := encryption.Encrypt(secretKey, storj.EncAESGCM, &storjKey, nonce)
-	if err != nil {
-		return nil, err
+	var encryptedSecretKey []byte
+	if db.derivationKeys != nil {
+		secretKey, encryptedSecretKey = db.newSecretKey(key.Hash())
+	} else {
+		secretKey = make([]byte, 32)
+		if _, err := rand.Read(secretKey); err != nil {
+			return nil, nil, err
+		}
+
+		encryptedSecretKey, err = encryption.Encrypt(secretKey, storj.EncAESGCM, &storjKey, nonce)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 
 	if _, err := encryption.Increment(nonce, 1); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	encryptedAccessGrant, err := encryption.Encrypt([]byte(accessGrant), storj.EncAESGCM, &storjKey, nonce)
+	encryptedAccessGrant, err := encryption.Encrypt(payload, storj.EncAESGCM, &storjKey, nonce)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	record := &Record{
-		SatelliteAddress:     "TODO",         // TODO: extend something to read this
-		MacaroonHead:         []byte("TODO"), // TODO: extend something to read this
+	record = &Record{
+		SatelliteAddress:     parsed.satelliteAddress,
+		MacaroonHead:         parsed.macaroonHead,
 		EncryptedSecretKey:   encryptedSecretKey,
 		EncryptedAccessGrant: encryptedAccessGrant,
 		Public:               public,
+		ExpiresAt:            expiresAt,
+		Labels:               labels,
 	}
 
-	if err := db.kv.Put(ctx, key.Hash(), record); err != nil {
+	return record, secretKey, nil
+}
+
+// ValidateAccessGrant returns an AccessGrantError that explains why the access
+// grant can't be registered, or nil if it can.
+func ValidateAccessGrant(accessGrant string) error {
+	_, err := parseAccessGrant(accessGrant, time.Now())
+	return err
+}
+
+// Expiration returns when the access of the access grant expires, if it is
+// registered to expire at expiresAt.
+func Expiration(accessGrant string, expiresAt *time.Time) (*time.Time, error) {
+	parsed, err := parseAccessGrant(accessGrant, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return parsed.expiration(expiresAt), nil
+}
+
+// parsedAccessGrant is what the records store of their access grants, so
+// that they can be found and checked without decrypting the access grant.
+type parsedAccessGrant struct {
+	satelliteAddress string
+	// macaroonHead is the head of the api key, which every api key that is
+	// derived from the same api key, like with restrictions, has too.
+	macaroonHead []byte
+	// expiresAt is the earliest expiration of the caveats of the api key, or
+	// nil if they don't expire.
+	expiresAt *time.Time
+}
+
+// parseAccessGrant parses the access grant, which must not have expired at
+// now.
+func parseAccessGrant(accessGrant string, now time.Time) (parsed parsedAccessGrant, err error) {
+	if accessGrant == "" {
+		return parsed, AccessGrantError.New("access_grant is required")
+	}
+	if _, err := uplink.ParseAccess(accessGrant); err != nil {
+		return parsed, AccessGrantError.New("invalid access grant: %v", err)
+	}
+	scope, err := parseScope(accessGrant)
+	if err != nil {
+		return parsed, AccessGrantError.New("invalid access grant: %v", err)
+	}
+	apiKey, err := macaroon.ParseRawAPIKey(scope.ApiKey)
+	if err != nil {
+		return parsed, AccessGrantError.New("invalid api key of access grant: %v", err)
+	}
+	expiresAt, err := caveatExpiration(apiKey)
+	if err != nil {
+		return parsed, AccessGrantError.New("invalid caveats of access grant: %v", err)
+	}
+	if expiresAt != nil && !now.Before(*expiresAt) {
+		return parsed, AccessGrantError.New("access grant expired at %s", expiresAt.UTC().Format(time.RFC3339))
+	}
+	return parsedAccessGrant{
+		satelliteAddress: scope.SatelliteAddr,
+		macaroonHead:     apiKey.Head(),
+		expiresAt:        expiresAt,
+	}, nil
+}
+
+// expiration returns when a record of the access grant that is registered to
+// expire at expiresAt expires. The satellite rejects the access grant after
+// its caveats expire, so the record expires then too if that is earlier.
+func (parsed parsedAccessGrant) expiration(expiresAt *time.Time) *time.Time {
+	if parsed.expiresAt != nil && (expiresAt == nil || parsed.expiresAt.Before(*expiresAt)) {
+		return parsed.expiresAt
+	}
+	return expiresAt
+}
+
+// parseScope returns the serialized scope of the access grant, which uplink
+// doesn't expose the satellite address and api key of.
+func parseScope(accessGrant string) (*pb.Scope, error) {
+	data, version, err := base58.CheckDecode(accessGrant)
+	if err != nil || version != 0 {
+		return nil, errs.New("invalid access grant format")
+	}
+
+	scope := new(pb.Scope)
+	if err := pb.Unmarshal(data, scope); err != nil {
 		return nil, errs.Wrap(err)
 	}
+	return scope, nil
+}
 
-	return secretKey, err
+// caveatExpiration returns the earliest expiration of the caveats of the api
+// key, after which the satellite rejects it, or nil if none of them expire.
+func caveatExpiration(apiKey *macaroon.APIKey) (expiresAt *time.Time, err error) {
+	mac, err := macaroon.ParseMacaroon(apiKey.SerializeRaw())
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+	for _, data := range mac.Caveats() {
+		var caveat macaroon.Caveat
+		if err := pb.Unmarshal(data, &caveat); err != nil {
+			return nil, errs.Wrap(err)
+		}
+		if caveat.NotAfter != nil && (expiresAt == nil || caveat.NotAfter.Before(*expiresAt)) {
+			notAfter := *caveat.NotAfter
+			expiresAt = &notAfter
+		}
+	}
+	return expiresAt, nil
+}
+
+// routedPayload is what EncryptedAccessGrant stores for accesses with routes.
+// Accesses without routes store just the access grant, which never starts with
+// a '{' because it is base58 encoded.
+type routedPayload struct {
+	AccessGrant string  `json:"access_grant"`
+	Routes      []Route `json:"routes"`
+}
+
+// encodePayload returns the plaintext of EncryptedAccessGrant.
+func encodePayload(accessGrant string, routes []Route) ([]byte, error) {
+	if len(routes) == 0 {
+		return []byte(accessGrant), nil
+	}
+	payload, err := json.Marshal(routedPayload{AccessGrant: accessGrant, Routes: routes})
+	return payload, errs.Wrap(err)
+}
+
+// decodePayload reverses encodePayload.
+func decodePayload(payload []byte) (accessGrant string, routes []Route, err error) {
+	if len(payload) == 0 || payload[0] != '{' {
+		return string(payload), nil, nil
+	}
+	var routed routedPayload
+	if err := json.Unmarshal(payload, &routed); err != nil {
+		return "", nil, errs.Wrap(err)
+	}
+	return routed.AccessGrant, routed.Routes, nil
 }
 
-// Get retrieves an access grant and secret key from the key/value store, looked up by the
-// hash of the key and decrypted.
-func (db *Database) Get(ctx context.Context, key EncryptionKey) (accessGrant string, public bool, secretKey []byte, err error) {
+// Get retrieves an access grant, its routes, labels and secret key from the key/value store,
+// looked up by the hash of the key and decrypted.
+func (db *Database) Get(ctx context.Context, key EncryptionKey) (accessGrant string, routes []Route, public bool, labels map[string]string,
+	secretKey []byte, err error) {
 	defer mon.Task()(&ctx)(&err)
 
+	access, err := db.get(ctx, key)
+	if err != nil {
+		return "", nil, false, nil, nil, err
+	}
+	return access.AccessGrant, access.Routes, access.Public, access.Labels, access.SecretKey, nil
+}
+
+// Access is a decrypted access, and when it was last used.
+type Access struct {
+	AccessGrant string
+	Routes      []Route
+	Public      bool
+	Labels      map[string]string
+	SecretKey   []byte
+	ExpiresAt   *time.Time
+
+	// SecretKeyHashed is whether only the hash of the secret key is stored,
+	// because the secret key is derived.
+	SecretKeyHashed bool
+
+	// LastUsedAt and UseCount are the uses before the access was resolved,
+	// including those that the usage tracker hasn't written yet.
+	LastUsedAt *time.Time
+	UseCount   int64
+}
+
+// Lookup is like Get, but returns the access with when it expires and when it
+// was last used. Unlike Resolve, it doesn't count a use.
+func (db *Database) Lookup(ctx context.Context, key EncryptionKey) (access *Access, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	access, err = db.get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	db.addPendingUses(key.Hash(), access)
+	return access, nil
+}
+
+// Resolve is Get for the clients that use the access, like gateways. The use is
+// counted by the usage tracker.
+func (db *Database) Resolve(ctx context.Context, key EncryptionKey) (access *Access, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	access, err = db.get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	db.addPendingUses(key.Hash(), access)
+	db.usage.Use(key.Hash())
+	return access, nil
+}
+
+// addPendingUses adds the uses of the key that the usage tracker hasn't
+// written yet to the access.
+func (db *Database) addPendingUses(keyHash KeyHash, access *Access) {
+	if at, uses := db.usage.Pending(keyHash); uses > 0 {
+		access.UseCount += uses
+		if access.LastUsedAt == nil || at.After(*access.LastUsedAt) {
+			access.LastUsedAt = &at
+		}
+	}
+}
+
+// Deleted returns whether the access of the key is soft deleted, which Get
+// can't tell apart from an access that doesn't exist.
+func (db *Database) Deleted(ctx context.Context, key EncryptionKey) (deleted bool, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	_, err = Update(ctx, db.kv, key.Hash(), func(entry *Entry) (bool, error) {
+		deleted = entry.DeletedAt != nil
+		return false, nil
+	})
+	if Unsupported.Has(err) {
+		// nothing is soft deleted in key/value stores without soft deletes
+		return false, nil
+	}
+	return deleted, errs.Wrap(err)
+}
+
+// get retrieves the access of the key from the key/value store and decrypts it.
+func (db *Database) get(ctx context.Context, key EncryptionKey) (access *Access, err error) {
 	record, err := db.kv.Get(ctx, key.Hash())
 	if err != nil {
-		return "", false, nil, errs.Wrap(err)
+		return nil, errs.Wrap(err)
 	} else if record == nil {
-		return "", false, nil, NotFound.New("key hash: %x", key.Hash())
+		return nil, NotFound.New("key hash: %x", key.Hash())
 	}
 
 	nonce := &storj.Nonce{}
 
 	storjKey := storj.Key(key)
-	secretKey, err = encryption.Decrypt(record.EncryptedSecretKey, storj.EncAESGCM, &storjKey, nonce)
+	var secretKey []byte
+	if record.SecretKeyHashed() {
+		secretKey, err = db.derivedSecretKey(key.Hash(), record)
+	} else {
+		secretKey, err = encryption.Decrypt(record.EncryptedSecretKey, storj.EncAESGCM, &storjKey, nonce)
+	}
 	if err != nil {
-		return "", false, nil, errs.Wrap(err)
+		return nil, errs.Wrap(err)
 	}
 
 	if _, err := encryption.Increment(nonce, 1); err != nil {
-		return "", false, nil, errs.Wrap(err)
+		return nil, errs.Wrap(err)
 	}
 
-	ag, err := encryption.Decrypt(record.EncryptedAccessGrant, storj.EncAESGCM, &storjKey, nonce)
+	payload, err := encryption.Decrypt(record.EncryptedAccessGrant, storj.EncAESGCM, &storjKey, nonce)
 	if err != nil {
-		return "", false, nil, errs.Wrap(err)
+		return nil, errs.Wrap(err)
 	}
 
-	return string(ag), record.Public, secretKey, nil
+	accessGrant, routes, err := decodePayload(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// records that were registered before their expiration was read from the
+	// caveats of their access grants don't expire with them, so the caveats
+	// are checked here too
+	if scope, err := parseScope(accessGrant); err == nil {
+		if apiKey, err := macaroon.ParseRawAPIKey(scope.ApiKey); err == nil {
+			if expiresAt, err := caveatExpiration(apiKey); err == nil && expiresAt != nil && !time.Now().Before(*expiresAt) {
+				return nil, Invalid.New("access grant expired at %s", expiresAt.UTC().Format(time.RFC3339))
+			}
+		}
+	}
+
+	return &Access{
+		AccessGrant: accessGrant,
+		Routes:      routes,
+		Public:      record.Public,
+		Labels:      record.Labels,
+		SecretKey:   secretKey,
+		ExpiresAt:   record.ExpiresAt,
+		LastUsedAt:  record.LastUsedAt,
+		UseCount:    record.UseCount,
+
+		SecretKeyHashed: record.SecretKeyHashed(),
+	}, nil
 }
 
 // Delete removes any access grant information from the key/value store, looked up by the
@@ -125,9 +549,96 @@ func (db *Database) Delete(ctx context.Context, key EncryptionKey) (err error) {
 	return errs.Wrap(db.kv.Delete(ctx, key.Hash()))
 }
 
-// Invalidate causes the access to become invalid.
-func (db *Database) Invalidate(ctx context.Context, key EncryptionKey, reason string) (err error) {
+// SoftDelete marks the access as deleted, so that it can't be used but can
+// still be restored until it is purged. Key/value stores without soft deletes
+// delete the access right away.
+func (db *Database) SoftDelete(ctx context.Context, key EncryptionKey) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	err = SoftDelete(ctx, db.kv, key.Hash())
+	if Unsupported.Has(err) {
+		err = db.kv.Delete(ctx, key.Hash())
+	}
+	return errs.Wrap(err)
+}
+
+// Restore undoes SoftDelete, and returns whether the access was deleted.
+func (db *Database) Restore(ctx context.Context, key EncryptionKey) (restored bool, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	restored, err = Restore(ctx, db.kv, key.Hash())
+	if Unsupported.Has(err) {
+		// nothing is soft deleted in key/value stores without soft deletes
+		return false, nil
+	}
+	return restored, errs.Wrap(err)
+}
+
+// Invalidate causes the access to become invalid, and returns whether it was
+// valid before. An access that is already invalid keeps its invalid reason.
+func (db *Database) Invalidate(ctx context.Context, key EncryptionKey, reason string) (invalidated bool, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if reason == "" {
+		// an entry without an invalid reason is valid
+		reason = "invalidated"
+	}
+
+	invalidated, err = Update(ctx, db.kv, key.Hash(), func(entry *Entry) (bool, error) {
+		if entry.InvalidReason != "" {
+			return false, nil
+		}
+		entry.InvalidReason = reason
+		return true, nil
+	})
+	if Unsupported.Has(err) {
+		invalidated, err = db.invalidate(ctx, key.Hash(), reason)
+	}
+	return invalidated, errs.Wrap(err)
+}
+
+// invalidate invalidates the record in a key/value store without atomic
+// updates, and returns whether it was valid before. Whether it was valid is
+// looked up first, so it races with other changes to the record.
+func (db *Database) invalidate(ctx context.Context, keyHash KeyHash, reason string) (invalidated bool, err error) {
+	record, err := db.kv.Get(ctx, keyHash)
+	switch {
+	case Invalid.Has(err):
+		return false, nil
+	case err != nil:
+		return false, err
+	case record == nil:
+		return false, nil
+	}
+	return true, db.kv.Invalidate(ctx, keyHash, reason)
+}
+
+// AppendHistory adds the event to the history of the access. Accesses in
+// key/value stores without histories have no history, so it does nothing.
+func (db *Database) AppendHistory(ctx context.Context, key EncryptionKey, event HistoryEvent) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	err = AppendHistory(ctx, db.kv, key.Hash(), event)
+	if Unsupported.Has(err) {
+		return nil
+	}
+	return errs.Wrap(err)
+}
+
+// History returns the events of the access in the order they happened.
+func (db *Database) History(ctx context.Context, key EncryptionKey) (events []HistoryEvent, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	events, err = History(ctx, db.kv, key.Hash())
+	return events, errs.Wrap(err)
+}
+
+// InvalidateByMacaroonHead causes every access whose api key has the macaroon
+// head to become invalid, like when the api key is revoked on the satellite.
+// It returns how many accesses were invalidated.
+func (db *Database) InvalidateByMacaroonHead(ctx context.Context, macaroonHead []byte, reason string) (invalidated int64, err error) {
 	defer mon.Task()(&ctx)(&err)
 
-	return errs.Wrap(db.kv.Invalidate(ctx, key.Hash(), reason))
+	invalidated, err = InvalidateByMacaroonHead(ctx, db.kv, macaroonHead, reason)
+	return invalidated, errs.Wrap(err)
 }
@@ -0,0 +1,113 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package awskms wraps data keys with a key in AWS Key Management Service.
+package awskms
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
+
+	"storj.io/stargate/auth/envelopeauth"
+)
+
+var mon = monkit.Package()
+
+// Error is the error class for this package.
+var Error = errs.Class("aws kms")
+
+func init() {
+	envelopeauth.RegisterKeyWrapper("awskms", openURL)
+}
+
+// API is the part of the AWS KMS client that KeyWrapper uses.
+type API interface {
+	EncryptWithContext(ctx aws.Context, input *kms.EncryptInput, opts ...request.Option) (*kms.EncryptOutput, error)
+	DecryptWithContext(ctx aws.Context, input *kms.DecryptInput, opts ...request.Option) (*kms.DecryptOutput, error)
+}
+
+// KeyWrapper wraps data keys with an AWS KMS key.
+type KeyWrapper struct {
+	client API
+	keyID  string
+}
+
+// New constructs a KeyWrapper for the key with keyID, which is a key id, key
+// arn, alias name or alias arn.
+func New(client API, keyID string) *KeyWrapper {
+	return &KeyWrapper{
+		client: client,
+		keyID:  keyID,
+	}
+}
+
+// openURL opens a KeyWrapper for a url of the form awskms://<key id>?region=<region>,
+// awskms://alias/<alias name>?region=<region> or awskms:///<arn>. Credentials are
+// found the same way as the AWS CLI finds them.
+func openURL(ctx context.Context, keyManagerURL string) (envelopeauth.KeyWrapper, error) {
+	keyID, region, err := parseURL(keyManagerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	options := session.Options{SharedConfigState: session.SharedConfigEnable}
+	if region != "" {
+		options.Config.Region = aws.String(region)
+	}
+	sess, err := session.NewSessionWithOptions(options)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	return New(kms.New(sess), keyID), nil
+}
+
+// parseURL returns the key id and region of an awskms:// url.
+func parseURL(keyManagerURL string) (keyID, region string, err error) {
+	parsed, err := url.Parse(keyManagerURL)
+	if err != nil {
+		return "", "", Error.Wrap(err)
+	}
+
+	keyID = strings.TrimPrefix(parsed.Host+parsed.Path, "/")
+	if keyID == "" {
+		return "", "", Error.New("url must be of the form awskms://<key id>?region=<region>")
+	}
+	return keyID, parsed.Query().Get("region"), nil
+}
+
+// WrapKey encrypts the data key with the KMS key.
+func (w *KeyWrapper) WrapKey(ctx context.Context, dataKey []byte) (wrapped []byte, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	output, err := w.client.EncryptWithContext(ctx, &kms.EncryptInput{
+		KeyId:     aws.String(w.keyID),
+		Plaintext: dataKey,
+	})
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	return output.CiphertextBlob, nil
+}
+
+// UnwrapKey decrypts a data key encrypted by WrapKey.
+func (w *KeyWrapper) UnwrapKey(ctx context.Context, wrapped []byte) (dataKey []byte, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	output, err := w.client.DecryptWithContext(ctx, &kms.DecryptInput{
+		KeyId:          aws.String(w.keyID),
+		CiphertextBlob: wrapped,
+	})
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	return output.Plaintext, nil
+}
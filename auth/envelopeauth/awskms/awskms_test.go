@@ -0,0 +1,57 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package awskms
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseURL(t *testing.T) {
+	for _, tc := range []struct {
+		url, keyID, region string
+	}{
+		{"awskms://1234abcd-12ab-34cd-56ef-1234567890ab?region=us-east-1", "1234abcd-12ab-34cd-56ef-1234567890ab", "us-east-1"},
+		{"awskms://alias/records", "alias/records", ""},
+		{"awskms:///arn:aws:kms:us-east-1:111122223333:key/1234abcd?region=us-east-1", "arn:aws:kms:us-east-1:111122223333:key/1234abcd", "us-east-1"},
+	} {
+		keyID, region, err := parseURL(tc.url)
+		require.NoError(t, err, tc.url)
+		require.Equal(t, tc.keyID, keyID, tc.url)
+		require.Equal(t, tc.region, region, tc.url)
+	}
+
+	_, _, err := parseURL("awskms://")
+	require.Error(t, err)
+}
+
+// fakeKMS "encrypts" by prefixing the key id.
+type fakeKMS struct{}
+
+func (fakeKMS) EncryptWithContext(ctx aws.Context, input *kms.EncryptInput, opts ...request.Option) (*kms.EncryptOutput, error) {
+	return &kms.EncryptOutput{CiphertextBlob: append([]byte(aws.StringValue(input.KeyId)+":"), input.Plaintext...)}, nil
+}
+
+func (fakeKMS) DecryptWithContext(ctx aws.Context, input *kms.DecryptInput, opts ...request.Option) (*kms.DecryptOutput, error) {
+	return &kms.DecryptOutput{Plaintext: bytes.TrimPrefix(input.CiphertextBlob, []byte(aws.StringValue(input.KeyId)+":"))}, nil
+}
+
+func TestKeyWrapper(t *testing.T) {
+	ctx := context.Background()
+	wrapper := New(fakeKMS{}, "alias/records")
+
+	wrapped, err := wrapper.WrapKey(ctx, []byte("data key"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("alias/records:data key"), wrapped)
+
+	dataKey, err := wrapper.UnwrapKey(ctx, wrapped)
+	require.NoError(t, err)
+	require.Equal(t, []byte("data key"), dataKey)
+}
@@ -0,0 +1,486 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package envelopeauth encrypts records before they reach a KV backend.
+//
+// Every record is encrypted with its own random data key, and the data key is
+// stored next to the record encrypted with a master key. Only the expiration
+// is left in the clear, because backends need it to expire and delete records,
+// together with a hash of the macaroon head, which backends index to invalidate
+// records by macaroon head, and the labels, which records are filtered by.
+package envelopeauth
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"reflect"
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
+
+	"storj.io/stargate/auth"
+)
+
+var mon = monkit.Package()
+
+// Error is the error class for this package.
+var Error = errs.Class("envelope")
+
+// envelopeVersion is the first byte of every envelope.
+const envelopeVersion = 1
+
+const dataKeySize = 32
+
+// KeyWrapper encrypts and decrypts data keys with a master key. It is the
+// extension point for keeping the master key elsewhere, like in a KMS.
+type KeyWrapper interface {
+	// WrapKey encrypts the data key with the master key.
+	WrapKey(ctx context.Context, dataKey []byte) (wrapped []byte, err error)
+	// UnwrapKey decrypts a data key encrypted by WrapKey.
+	UnwrapKey(ctx context.Context, wrapped []byte) (dataKey []byte, err error)
+}
+
+// KV is a key/value store that encrypts records before storing them in another KV.
+type KV struct {
+	kv      auth.KV
+	wrapper KeyWrapper
+}
+
+// New wraps kv so that records are encrypted with data keys wrapped by wrapper.
+func New(kv auth.KV, wrapper KeyWrapper) *KV {
+	return &KV{
+		kv:      kv,
+		wrapper: wrapper,
+	}
+}
+
+// Put encrypts the record and stores it in the wrapped key/value store.
+// It is an error if the key already exists.
+func (d *KV) Put(ctx context.Context, keyHash auth.KeyHash, record *auth.Record) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	sealed, err := d.seal(ctx, keyHash, record)
+	if err != nil {
+		return err
+	}
+	return d.kv.Put(ctx, keyHash, sealed)
+}
+
+// PutBatch encrypts all of the records and stores them in the wrapped key/value store.
+// It is an error if any of the keys already exist.
+func (d *KV) PutBatch(ctx context.Context, entries []auth.Entry) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	sealed := make([]auth.Entry, len(entries))
+	for i, entry := range entries {
+		record, err := d.seal(ctx, entry.KeyHash, entry.Record)
+		if err != nil {
+			return err
+		}
+		sealed[i] = auth.Entry{KeyHash: entry.KeyHash, Record: record}
+	}
+	return auth.PutBatch(ctx, d.kv, sealed)
+}
+
+// Get retrieves the record from the wrapped key/value store and decrypts it.
+// It returns nil if the key does not exist.
+// If the record is invalid or expired, the error contains why.
+func (d *KV) Get(ctx context.Context, keyHash auth.KeyHash) (record *auth.Record, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	record, err = d.kv.Get(ctx, keyHash)
+	if err != nil || record == nil {
+		return nil, err
+	}
+	return d.open(ctx, keyHash, record)
+}
+
+// GetBatch retrieves the records for all of the keys from the wrapped key/value
+// store and decrypts them. A record is nil if its key does not exist or if it is
+// invalid or expired.
+func (d *KV) GetBatch(ctx context.Context, keyHashes []auth.KeyHash) (records []*auth.Record, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	records, err = auth.GetBatch(ctx, d.kv, keyHashes)
+	if err != nil {
+		return nil, err
+	}
+	for i, record := range records {
+		if record == nil {
+			continue
+		}
+		if records[i], err = d.open(ctx, keyHashes[i], record); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// Delete removes the record from the wrapped key/value store.
+// It is not an error if the key does not exist.
+func (d *KV) Delete(ctx context.Context, keyHash auth.KeyHash) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return d.kv.Delete(ctx, keyHash)
+}
+
+// SoftDelete marks the record in the wrapped key/value store as deleted.
+// It is not an error if the key does not exist.
+func (d *KV) SoftDelete(ctx context.Context, keyHash auth.KeyHash) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.SoftDelete(ctx, d.kv, keyHash)
+}
+
+// Restore undoes SoftDelete, and returns whether the record was deleted.
+// It is not an error if the key does not exist.
+func (d *KV) Restore(ctx context.Context, keyHash auth.KeyHash) (restored bool, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.Restore(ctx, d.kv, keyHash)
+}
+
+// PurgeDeleted removes the records that were soft deleted before asOf from the
+// wrapped key/value store, and returns how many were removed.
+func (d *KV) PurgeDeleted(ctx context.Context, asOf time.Time) (purged int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.PurgeDeleted(ctx, d.kv, asOf)
+}
+
+// Invalidate causes the record to become invalid.
+// It is not an error if the key does not exist.
+// It does not update the invalid reason if the record is already invalid.
+func (d *KV) Invalidate(ctx context.Context, keyHash auth.KeyHash, reason string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return d.kv.Invalidate(ctx, keyHash, reason)
+}
+
+// Update atomically changes the entry of the key with fn, which is called with
+// the decrypted record. The record is encrypted again if fn changes it.
+// It is not an error if the key does not exist.
+func (d *KV) Update(ctx context.Context, keyHash auth.KeyHash, fn func(entry *auth.Entry) (store bool, err error)) (updated bool, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.Update(ctx, d.kv, keyHash, func(entry *auth.Entry) (bool, error) {
+		record, err := d.open(ctx, keyHash, entry.Record)
+		if err != nil {
+			return false, err
+		}
+		opened := *record
+
+		plain := *entry
+		plain.Record = record
+		if store, err := fn(&plain); err != nil || !store {
+			return false, err
+		}
+		if plain.Record == nil {
+			return false, Error.New("record is required")
+		}
+
+		if !reflect.DeepEqual(*plain.Record, opened) {
+			if entry.Record, err = d.seal(ctx, keyHash, plain.Record); err != nil {
+				return false, err
+			}
+		}
+		entry.InvalidReason = plain.InvalidReason
+		entry.DeletedAt = plain.DeletedAt
+		return true, nil
+	})
+}
+
+// InvalidateByMacaroonHead causes every record with the macaroon head to
+// become invalid, and returns how many were invalidated. Records that are
+// already invalid keep their invalid reason and are not counted.
+func (d *KV) InvalidateByMacaroonHead(ctx context.Context, macaroonHead []byte, reason string) (invalidated int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.InvalidateByMacaroonHead(ctx, d.kv, hashMacaroonHead(macaroonHead), reason)
+}
+
+// DeleteUnused removes the records that were invalidated or that expired
+// before asOf, and returns how many were removed.
+func (d *KV) DeleteUnused(ctx context.Context, asOf time.Time) (deleted int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.DeleteUnused(ctx, d.kv, asOf)
+}
+
+// RecordUse adds uses to the use count of the key in the wrapped key/value
+// store. Uses are not encrypted.
+func (d *KV) RecordUse(ctx context.Context, keyHash auth.KeyHash, at time.Time, uses int64) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.RecordUse(ctx, d.kv, keyHash, at, uses)
+}
+
+// CountActive returns how many records of the owner are active at now in the
+// wrapped key/value store. Owners are not encrypted.
+func (d *KV) CountActive(ctx context.Context, owner string, now time.Time) (count int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.CountActive(ctx, d.kv, owner, now)
+}
+
+// AppendHistory adds the event to the history of the key in the wrapped
+// key/value store. Events are not encrypted.
+func (d *KV) AppendHistory(ctx context.Context, keyHash auth.KeyHash, event auth.HistoryEvent) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.AppendHistory(ctx, d.kv, keyHash, event)
+}
+
+// History returns the events of the key in the wrapped key/value store.
+func (d *KV) History(ctx context.Context, keyHash auth.KeyHash) (events []auth.HistoryEvent, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.History(ctx, d.kv, keyHash)
+}
+
+// Iterate calls fn for every record in the wrapped key/value store with the
+// record decrypted, including invalid, expired and soft deleted records.
+func (d *KV) Iterate(ctx context.Context, fn func(ctx context.Context, entry auth.Entry) error) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.Iterate(ctx, d.kv, func(ctx context.Context, entry auth.Entry) (err error) {
+		entry.Record, err = d.open(ctx, entry.KeyHash, entry.Record)
+		if err != nil {
+			return err
+		}
+		return fn(ctx, entry)
+	})
+}
+
+// List returns a page of the records in the wrapped key/value store with the
+// records decrypted, including invalid, expired and soft deleted records.
+func (d *KV) List(ctx context.Context, after auth.KeyHash, limit int) (entries []auth.Entry, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	entries, err = auth.List(ctx, d.kv, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		entries[i].Record, err = d.open(ctx, entries[i].KeyHash, entries[i].Record)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// HealthCheck checks the wrapped key/value store, and that the key wrapper can
+// wrap and unwrap data keys.
+func (d *KV) HealthCheck(ctx context.Context) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if err := auth.HealthCheck(ctx, d.kv); err != nil {
+		return err
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return Error.Wrap(err)
+	}
+	wrapped, err := d.wrapper.WrapKey(ctx, dataKey)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	unwrapped, err := d.wrapper.UnwrapKey(ctx, wrapped)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	if !bytes.Equal(unwrapped, dataKey) {
+		return Error.New("unwrapped data key doesn't match")
+	}
+	return nil
+}
+
+// Close closes the wrapped key/value store.
+func (d *KV) Close() error {
+	return auth.Close(d.kv)
+}
+
+// seal returns a record that stores the envelope of record in
+// EncryptedAccessGrant. The key hash is authenticated with the envelope so that
+// envelopes can't be moved between keys.
+//
+// The envelope is
+//
+//	version || uvarint(len(wrapped key)) || wrapped key || nonce || ciphertext
+func (d *KV) seal(ctx context.Context, keyHash auth.KeyHash, record *auth.Record) (_ *auth.Record, err error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	wrapped, err := d.wrapper.WrapKey(ctx, dataKey)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	envelope := []byte{envelopeVersion}
+	envelope = appendBytes(envelope, wrapped)
+	envelope = append(envelope, nonce...)
+	envelope = aead.Seal(envelope, nonce, marshalRecord(record), keyHash[:])
+
+	return &auth.Record{
+		MacaroonHead:         hashMacaroonHead(record.MacaroonHead),
+		EncryptedSecretKey:   []byte{},
+		EncryptedAccessGrant: envelope,
+		ExpiresAt:            record.ExpiresAt,
+		Labels:               record.Labels,
+		LastUsedAt:           record.LastUsedAt,
+		UseCount:             record.UseCount,
+		Owner:                record.Owner,
+	}, nil
+}
+
+// hashMacaroonHead returns what sealed records store instead of the macaroon
+// head, so that the wrapped key/value store can find records by macaroon head
+// without learning it.
+func hashMacaroonHead(macaroonHead []byte) []byte {
+	hash := sha256.Sum256(macaroonHead)
+	return hash[:]
+}
+
+// IsSealed returns whether the record, as stored in the wrapped key/value
+// store, is sealed. Records that are not sealed have an encrypted secret key.
+func IsSealed(record *auth.Record) bool {
+	envelope := record.EncryptedAccessGrant
+	return len(record.EncryptedSecretKey) == 0 && len(envelope) > 0 && envelope[0] == envelopeVersion
+}
+
+// Open decrypts a sealed record of the key, like one returned by Iterate of
+// the wrapped key/value store. Records whose envelope is damaged or belongs to
+// another key fail with auth.Undecryptable, while failures to unwrap the data
+// key, like when the key manager is unavailable, don't.
+func (d *KV) Open(ctx context.Context, keyHash auth.KeyHash, sealed *auth.Record) (_ *auth.Record, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return d.open(ctx, keyHash, sealed)
+}
+
+// open reverses seal.
+func (d *KV) open(ctx context.Context, keyHash auth.KeyHash, sealed *auth.Record) (_ *auth.Record, err error) {
+	envelope := sealed.EncryptedAccessGrant
+	if len(envelope) == 0 || envelope[0] != envelopeVersion {
+		return nil, auth.Undecryptable.Wrap(Error.New("unsupported envelope"))
+	}
+
+	wrapped, rest, err := readBytes(envelope[1:])
+	if err != nil {
+		return nil, auth.Undecryptable.Wrap(err)
+	}
+
+	dataKey, err := d.wrapper.UnwrapKey(ctx, wrapped)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, auth.Undecryptable.Wrap(err)
+	}
+	if len(rest) < aead.NonceSize() {
+		return nil, auth.Undecryptable.Wrap(Error.New("envelope too short"))
+	}
+	nonce, ciphertext := rest[:aead.NonceSize()], rest[aead.NonceSize():]
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, keyHash[:])
+	if err != nil {
+		return nil, auth.Undecryptable.Wrap(Error.New("unable to decrypt record: %v", err))
+	}
+
+	record, err := unmarshalRecord(plaintext)
+	if err != nil {
+		return nil, auth.Undecryptable.Wrap(err)
+	}
+	record.ExpiresAt = sealed.ExpiresAt
+	record.Labels = sealed.Labels
+	record.LastUsedAt = sealed.LastUsedAt
+	record.UseCount = sealed.UseCount
+	record.Owner = sealed.Owner
+	return record, nil
+}
+
+// marshalRecord encodes the fields of the record that are encrypted.
+func marshalRecord(record *auth.Record) []byte {
+	var data []byte
+	data = appendBytes(data, []byte(record.SatelliteAddress))
+	data = appendBytes(data, record.MacaroonHead)
+	data = appendBytes(data, record.EncryptedSecretKey)
+	data = appendBytes(data, record.EncryptedAccessGrant)
+	if record.Public {
+		data = append(data, 1)
+	} else {
+		data = append(data, 0)
+	}
+	return data
+}
+
+// unmarshalRecord decodes the fields encoded by marshalRecord.
+func unmarshalRecord(data []byte) (record *auth.Record, err error) {
+	record = new(auth.Record)
+
+	var satelliteAddress []byte
+	for _, field := range []*[]byte{
+		&satelliteAddress,
+		&record.MacaroonHead,
+		&record.EncryptedSecretKey,
+		&record.EncryptedAccessGrant,
+	} {
+		if *field, data, err = readBytes(data); err != nil {
+			return nil, err
+		}
+	}
+	if len(data) != 1 {
+		return nil, Error.New("malformed record")
+	}
+
+	record.SatelliteAddress = string(satelliteAddress)
+	record.Public = data[0] == 1
+	return record, nil
+}
+
+// appendBytes appends the length prefixed value to data.
+func appendBytes(data, value []byte) []byte {
+	var length [binary.MaxVarintLen64]byte
+	data = append(data, length[:binary.PutUvarint(length[:], uint64(len(value)))]...)
+	return append(data, value...)
+}
+
+// readBytes reads a value appended by appendBytes and returns the remaining data.
+func readBytes(data []byte) (value, rest []byte, err error) {
+	length, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < length {
+		return nil, nil, Error.New("malformed envelope")
+	}
+	data = data[n:]
+	return data[:length:length], data[length:], nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	return aead, Error.Wrap(err)
+}
@@ -0,0 +1,87 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package envelopeauth_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/stargate/auth"
+	"storj.io/stargate/auth/envelopeauth"
+	"storj.io/stargate/auth/kvtest"
+	"storj.io/stargate/auth/memauth"
+)
+
+func newWrapper(t *testing.T, b byte) envelopeauth.KeyWrapper {
+	wrapper, err := envelopeauth.NewLocalKeyWrapper(bytes.Repeat([]byte{b}, envelopeauth.MasterKeySize))
+	require.NoError(t, err)
+	return wrapper
+}
+
+func TestKV(t *testing.T) {
+	wrapper := newWrapper(t, 1)
+	kvtest.RunTests(t, func() auth.KV { return envelopeauth.New(memauth.New(), wrapper) })
+}
+
+func TestKV_Envelope(t *testing.T) {
+	ctx := context.Background()
+	inner := memauth.New()
+	kv := envelopeauth.New(inner, newWrapper(t, 1))
+
+	record := &auth.Record{
+		SatelliteAddress:     "satellite.example.test:7777",
+		MacaroonHead:         []byte("macaroon head"),
+		EncryptedSecretKey:   []byte("secret key"),
+		EncryptedAccessGrant: []byte("access grant"),
+		Public:               true,
+	}
+	require.NoError(t, kv.Put(ctx, auth.KeyHash{1}, record))
+
+	// nothing but the envelope and the hash of the macaroon head reach the backend
+	sealed, err := inner.Get(ctx, auth.KeyHash{1})
+	require.NoError(t, err)
+	require.Empty(t, sealed.SatelliteAddress)
+	macaroonHeadHash := sha256.Sum256(record.MacaroonHead)
+	require.Equal(t, macaroonHeadHash[:], sealed.MacaroonHead)
+	require.Empty(t, sealed.EncryptedSecretKey)
+	require.False(t, sealed.Public)
+	for _, plain := range []string{"satellite", "macaroon head", "secret key", "access grant"} {
+		require.NotContains(t, string(sealed.EncryptedAccessGrant), plain)
+	}
+
+	opened, err := kv.Get(ctx, auth.KeyHash{1})
+	require.NoError(t, err)
+	require.Equal(t, record, opened)
+
+	// an envelope moved to another key does not decrypt
+	require.NoError(t, inner.Put(ctx, auth.KeyHash{2}, sealed))
+	_, err = kv.Get(ctx, auth.KeyHash{2})
+	require.True(t, auth.Undecryptable.Has(err))
+
+	// the wrong master key does not decrypt, but the record isn't damaged
+	_, err = envelopeauth.New(inner, newWrapper(t, 2)).Get(ctx, auth.KeyHash{1})
+	require.Error(t, err)
+	require.False(t, auth.Undecryptable.Has(err))
+
+	require.True(t, envelopeauth.IsSealed(sealed))
+	require.False(t, envelopeauth.IsSealed(record))
+	opened, err = kv.Open(ctx, auth.KeyHash{1}, sealed)
+	require.NoError(t, err)
+	require.Equal(t, record, opened)
+}
+
+func TestLocalKeyWrapper(t *testing.T) {
+	_, err := envelopeauth.NewLocalKeyWrapper(make([]byte, 16))
+	require.Error(t, err)
+
+	_, err = envelopeauth.ParseLocalKeyWrapper("not base64!")
+	require.Error(t, err)
+
+	_, err = envelopeauth.ParseLocalKeyWrapper("AQIDBAUGBwgJCgsMDQ4PEBESExQVFhcYGRobHB0eHyA=")
+	require.NoError(t, err)
+}
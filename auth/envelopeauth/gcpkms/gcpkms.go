@@ -0,0 +1,107 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package gcpkms wraps data keys with a key in Google Cloud Key Management Service.
+package gcpkms
+
+import (
+	"context"
+	"strings"
+
+	kms "cloud.google.com/go/kms/apiv1"
+	"github.com/googleapis/gax-go/v2"
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
+	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+
+	"storj.io/stargate/auth/envelopeauth"
+)
+
+var mon = monkit.Package()
+
+// Error is the error class for this package.
+var Error = errs.Class("gcp kms")
+
+func init() {
+	envelopeauth.RegisterKeyWrapper("gcpkms", openURL)
+}
+
+// Client is the part of the Cloud KMS client that KeyWrapper uses.
+type Client interface {
+	Encrypt(ctx context.Context, req *kmspb.EncryptRequest, opts ...gax.CallOption) (*kmspb.EncryptResponse, error)
+	Decrypt(ctx context.Context, req *kmspb.DecryptRequest, opts ...gax.CallOption) (*kmspb.DecryptResponse, error)
+}
+
+// KeyWrapper wraps data keys with a Cloud KMS key.
+type KeyWrapper struct {
+	client Client
+	name   string
+}
+
+// New constructs a KeyWrapper for the key with the resource name
+// projects/<project>/locations/<location>/keyRings/<key ring>/cryptoKeys/<key>.
+func New(client Client, name string) *KeyWrapper {
+	return &KeyWrapper{
+		client: client,
+		name:   name,
+	}
+}
+
+// openURL opens a KeyWrapper for a url of the form gcpkms://<key resource name>.
+// Credentials are found with Application Default Credentials.
+func openURL(ctx context.Context, keyManagerURL string) (envelopeauth.KeyWrapper, error) {
+	name, err := parseURL(keyManagerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := kms.NewKeyManagementClient(ctx)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	return New(client, name), nil
+}
+
+// parseURL returns the key resource name of a gcpkms:// url.
+func parseURL(keyManagerURL string) (name string, err error) {
+	const prefix = "gcpkms://"
+	if len(keyManagerURL) < len(prefix) || !strings.EqualFold(keyManagerURL[:len(prefix)], prefix) {
+		return "", Error.New("url must start with %s", prefix)
+	}
+
+	name = keyManagerURL[len(prefix):]
+	parts := strings.Split(name, "/")
+	if len(parts) != 8 || parts[0] != "projects" || parts[2] != "locations" ||
+		parts[4] != "keyRings" || parts[6] != "cryptoKeys" {
+		return "", Error.New("url must be of the form gcpkms://projects/<project>/locations/<location>/keyRings/<key ring>/cryptoKeys/<key>")
+	}
+	return name, nil
+}
+
+// WrapKey encrypts the data key with the KMS key.
+func (w *KeyWrapper) WrapKey(ctx context.Context, dataKey []byte) (wrapped []byte, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	response, err := w.client.Encrypt(ctx, &kmspb.EncryptRequest{
+		Name:      w.name,
+		Plaintext: dataKey,
+	})
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	return response.Ciphertext, nil
+}
+
+// UnwrapKey decrypts a data key encrypted by WrapKey.
+func (w *KeyWrapper) UnwrapKey(ctx context.Context, wrapped []byte) (dataKey []byte, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	response, err := w.client.Decrypt(ctx, &kmspb.DecryptRequest{
+		Name:       w.name,
+		Ciphertext: wrapped,
+	})
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	return response.Plaintext, nil
+}
@@ -0,0 +1,53 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package gcpkms
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/googleapis/gax-go/v2"
+	"github.com/stretchr/testify/require"
+	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+)
+
+func TestParseURL(t *testing.T) {
+	name, err := parseURL("gcpkms://projects/p/locations/global/keyRings/r/cryptoKeys/k")
+	require.NoError(t, err)
+	require.Equal(t, "projects/p/locations/global/keyRings/r/cryptoKeys/k", name)
+
+	for _, invalid := range []string{
+		"gcpkms://",
+		"gcpkms://projects/p/locations/global/keyRings/r",
+		"gcpkms://projects/p/locations/global/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1",
+		"awskms://projects/p/locations/global/keyRings/r/cryptoKeys/k",
+	} {
+		_, err := parseURL(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+// fakeClient "encrypts" by prefixing the key name.
+type fakeClient struct{}
+
+func (fakeClient) Encrypt(ctx context.Context, req *kmspb.EncryptRequest, opts ...gax.CallOption) (*kmspb.EncryptResponse, error) {
+	return &kmspb.EncryptResponse{Ciphertext: append([]byte(req.Name+":"), req.Plaintext...)}, nil
+}
+
+func (fakeClient) Decrypt(ctx context.Context, req *kmspb.DecryptRequest, opts ...gax.CallOption) (*kmspb.DecryptResponse, error) {
+	return &kmspb.DecryptResponse{Plaintext: bytes.TrimPrefix(req.Ciphertext, []byte(req.Name+":"))}, nil
+}
+
+func TestKeyWrapper(t *testing.T) {
+	ctx := context.Background()
+	wrapper := New(fakeClient{}, "projects/p/locations/global/keyRings/r/cryptoKeys/k")
+
+	wrapped, err := wrapper.WrapKey(ctx, []byte("data key"))
+	require.NoError(t, err)
+
+	dataKey, err := wrapper.UnwrapKey(ctx, wrapped)
+	require.NoError(t, err)
+	require.Equal(t, []byte("data key"), dataKey)
+}
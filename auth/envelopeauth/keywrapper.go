@@ -0,0 +1,64 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package envelopeauth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+)
+
+// MasterKeySize is the size of the master key of a LocalKeyWrapper.
+const MasterKeySize = 32
+
+// LocalKeyWrapper wraps data keys with AES-GCM using a master key held in memory.
+type LocalKeyWrapper struct {
+	masterKey []byte
+}
+
+// NewLocalKeyWrapper constructs a LocalKeyWrapper for a MasterKeySize byte master key.
+func NewLocalKeyWrapper(masterKey []byte) (*LocalKeyWrapper, error) {
+	if len(masterKey) != MasterKeySize {
+		return nil, Error.New("master key must be %d bytes, got %d", MasterKeySize, len(masterKey))
+	}
+	return &LocalKeyWrapper{masterKey: append([]byte(nil), masterKey...)}, nil
+}
+
+// ParseLocalKeyWrapper constructs a LocalKeyWrapper for a base64 encoded master key.
+func ParseLocalKeyWrapper(masterKey string) (*LocalKeyWrapper, error) {
+	key, err := base64.StdEncoding.DecodeString(masterKey)
+	if err != nil {
+		return nil, Error.New("master key is not valid base64: %v", err)
+	}
+	return NewLocalKeyWrapper(key)
+}
+
+// WrapKey encrypts the data key with the master key.
+func (w *LocalKeyWrapper) WrapKey(ctx context.Context, dataKey []byte) (wrapped []byte, err error) {
+	aead, err := newAEAD(w.masterKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, Error.Wrap(err)
+	}
+	return aead.Seal(nonce, nonce, dataKey, nil), nil
+}
+
+// UnwrapKey decrypts a data key encrypted by WrapKey.
+func (w *LocalKeyWrapper) UnwrapKey(ctx context.Context, wrapped []byte) (dataKey []byte, err error) {
+	aead, err := newAEAD(w.masterKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, Error.New("wrapped key too short")
+	}
+	dataKey, err = aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, Error.New("unable to unwrap data key: wrong master key?")
+	}
+	return dataKey, nil
+}
@@ -0,0 +1,72 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package envelopeauth
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// KeyWrapperOpener opens a KeyWrapper for a key manager url with a registered scheme.
+type KeyWrapperOpener func(ctx context.Context, keyManagerURL string) (KeyWrapper, error)
+
+var registry = struct {
+	mu      sync.Mutex
+	openers map[string]KeyWrapperOpener
+}{
+	openers: make(map[string]KeyWrapperOpener),
+}
+
+// RegisterKeyWrapper makes a key manager available to OpenKeyWrapper for urls
+// with the given scheme. It is intended to be called from the init function of
+// the package implementing the KeyWrapper, and it panics if the scheme is
+// registered twice.
+func RegisterKeyWrapper(scheme string, opener KeyWrapperOpener) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	scheme = strings.ToLower(scheme)
+	if _, ok := registry.openers[scheme]; ok {
+		panic("envelopeauth: KeyWrapper registered twice for scheme " + scheme)
+	}
+	registry.openers[scheme] = opener
+}
+
+// RegisteredSchemes returns the sorted list of schemes that OpenKeyWrapper can open.
+func RegisteredSchemes() []string {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	schemes := make([]string, 0, len(registry.openers))
+	for scheme := range registry.openers {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// OpenKeyWrapper opens a KeyWrapper using the opener registered for the scheme
+// of keyManagerURL.
+func OpenKeyWrapper(ctx context.Context, keyManagerURL string) (_ KeyWrapper, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	parsed, err := url.Parse(keyManagerURL)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	registry.mu.Lock()
+	opener, ok := registry.openers[strings.ToLower(parsed.Scheme)]
+	registry.mu.Unlock()
+
+	if !ok {
+		return nil, Error.New("unsupported key manager scheme %q: must be one of %v",
+			parsed.Scheme, RegisteredSchemes())
+	}
+
+	return opener(ctx, keyManagerURL)
+}
@@ -0,0 +1,181 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package vaulttransit wraps data keys with the transit secrets engine of
+// HashiCorp Vault, so that the master key never leaves Vault.
+//
+// Keys are rotated in Vault: data keys are wrapped with the latest version of
+// the transit key, and data keys wrapped with older versions keep unwrapping
+// as long as Vault allows decryption with those versions.
+package vaulttransit
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
+
+	"storj.io/stargate/auth/envelopeauth"
+)
+
+var mon = monkit.Package()
+
+// Error is the error class for this package.
+var Error = errs.Class("vault transit")
+
+const (
+	// TokenEnv is the environment variable that holds the Vault token.
+	TokenEnv = "VAULT_TOKEN"
+	// NamespaceEnv is the environment variable that holds the optional Vault namespace.
+	NamespaceEnv = "VAULT_NAMESPACE"
+
+	maxResponseSize = 1 << 20
+)
+
+func init() {
+	envelopeauth.RegisterKeyWrapper("vault", openURL)
+	envelopeauth.RegisterKeyWrapper("vault+http", openURL)
+}
+
+// KeyWrapper wraps data keys with a Vault transit key.
+type KeyWrapper struct {
+	address   string
+	mount     string
+	key       string
+	token     string
+	namespace string
+	client    *http.Client
+}
+
+// New constructs a KeyWrapper for the transit key named key in the transit
+// engine mounted at mount of the Vault at address, like https://vault:8200.
+func New(address, mount, key, token string) *KeyWrapper {
+	return &KeyWrapper{
+		address: strings.TrimRight(address, "/"),
+		mount:   strings.Trim(mount, "/"),
+		key:     key,
+		token:   token,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// openURL opens a KeyWrapper for a url of the form
+// vault://<host>[:port]/<mount>/<key>, or vault+http:// for Vault without TLS.
+// The token is read from VAULT_TOKEN so that it is never stored in the config.
+func openURL(ctx context.Context, keyManagerURL string) (envelopeauth.KeyWrapper, error) {
+	parsed, err := url.Parse(keyManagerURL)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	scheme := "https"
+	if strings.EqualFold(parsed.Scheme, "vault+http") {
+		scheme = "http"
+	}
+
+	mount, key := path.Split(strings.Trim(parsed.Path, "/"))
+	if parsed.Host == "" || mount == "" || key == "" {
+		return nil, Error.New("url must be of the form vault://host:port/mount/key")
+	}
+
+	token, ok := os.LookupEnv(TokenEnv)
+	if !ok || token == "" {
+		return nil, Error.New("%s must be set", TokenEnv)
+	}
+
+	wrapper := New(scheme+"://"+parsed.Host, mount, key, token)
+	wrapper.namespace = os.Getenv(NamespaceEnv)
+	return wrapper, nil
+}
+
+// WrapKey encrypts the data key with the transit key.
+func (w *KeyWrapper) WrapKey(ctx context.Context, dataKey []byte) (wrapped []byte, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	var response struct {
+		Data struct {
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+	}
+	err = w.do(ctx, "encrypt", map[string]string{
+		"plaintext": base64.StdEncoding.EncodeToString(dataKey),
+	}, &response)
+	if err != nil {
+		return nil, err
+	}
+	if response.Data.Ciphertext == "" {
+		return nil, Error.New("vault returned no ciphertext")
+	}
+	return []byte(response.Data.Ciphertext), nil
+}
+
+// UnwrapKey decrypts a data key encrypted by WrapKey.
+func (w *KeyWrapper) UnwrapKey(ctx context.Context, wrapped []byte) (dataKey []byte, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	var response struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+	}
+	err = w.do(ctx, "decrypt", map[string]string{
+		"ciphertext": string(wrapped),
+	}, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err = base64.StdEncoding.DecodeString(response.Data.Plaintext)
+	return dataKey, Error.Wrap(err)
+}
+
+// do calls the transit operation for the key and decodes the response.
+func (w *KeyWrapper) do(ctx context.Context, operation string, request, response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	endpoint := w.address + "/v1/" + w.mount + "/" + operation + "/" + url.PathEscape(w.key)
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", w.token)
+	if w.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", w.namespace)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	data, err := ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, maxResponseSize))
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var vaultErr struct {
+			Errors []string `json:"errors"`
+		}
+		_ = json.Unmarshal(data, &vaultErr)
+		return Error.New("%s %s: %s %v", operation, w.key, resp.Status, vaultErr.Errors)
+	}
+
+	return Error.Wrap(json.Unmarshal(data, response))
+}
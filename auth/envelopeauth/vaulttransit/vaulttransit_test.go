@@ -0,0 +1,100 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package vaulttransit_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/stargate/auth/envelopeauth"
+	"storj.io/stargate/auth/envelopeauth/vaulttransit"
+)
+
+// fakeTransit is a transit engine that "encrypts" by prefixing the key version.
+func fakeTransit(t *testing.T, version *string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors": ["permission denied"]}`))
+			return
+		}
+
+		var request map[string]string
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&request))
+
+		var data map[string]string
+		switch req.URL.Path {
+		case "/v1/transit/encrypt/records":
+			data = map[string]string{"ciphertext": "vault:" + *version + ":" + request["plaintext"]}
+		case "/v1/transit/decrypt/records":
+			parts := strings.SplitN(request["ciphertext"], ":", 3)
+			require.Len(t, parts, 3)
+			data = map[string]string{"plaintext": parts[2]}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	})
+}
+
+func TestKeyWrapper(t *testing.T) {
+	ctx := context.Background()
+
+	version := "v1"
+	server := httptest.NewServer(fakeTransit(t, &version))
+	defer server.Close()
+
+	require.NoError(t, os.Setenv(vaulttransit.TokenEnv, "token"))
+	defer func() { _ = os.Unsetenv(vaulttransit.TokenEnv) }()
+
+	wrapper, err := envelopeauth.OpenKeyWrapper(ctx, strings.Replace(server.URL, "http://", "vault+http://", 1)+"/transit/records")
+	require.NoError(t, err)
+
+	wrapped, err := wrapper.WrapKey(ctx, []byte("data key"))
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(wrapped), "vault:v1:"))
+
+	// keys wrapped before a rotation still unwrap
+	version = "v2"
+	dataKey, err := wrapper.UnwrapKey(ctx, wrapped)
+	require.NoError(t, err)
+	require.Equal(t, []byte("data key"), dataKey)
+
+	wrapped, err = wrapper.WrapKey(ctx, []byte("data key"))
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(wrapped), "vault:v2:"))
+
+	// vault errors are returned
+	_, err = vaulttransit.New(server.URL, "transit", "records", "wrong").WrapKey(ctx, []byte("data key"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "permission denied")
+}
+
+func TestOpenURL(t *testing.T) {
+	ctx := context.Background()
+
+	require.NoError(t, os.Unsetenv(vaulttransit.TokenEnv))
+	_, err := envelopeauth.OpenKeyWrapper(ctx, "vault://vault:8200/transit/records")
+	require.Error(t, err)
+
+	require.NoError(t, os.Setenv(vaulttransit.TokenEnv, "token"))
+	defer func() { _ = os.Unsetenv(vaulttransit.TokenEnv) }()
+
+	_, err = envelopeauth.OpenKeyWrapper(ctx, "vault://vault:8200/transit/records")
+	require.NoError(t, err)
+
+	_, err = envelopeauth.OpenKeyWrapper(ctx, "vault://vault:8200/records")
+	require.Error(t, err)
+
+	_, err = envelopeauth.OpenKeyWrapper(ctx, "unknown://vault:8200/transit/records")
+	require.Error(t, err)
+}
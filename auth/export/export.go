@@ -0,0 +1,337 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package export writes and reads auth records in plain formats, so that they
+// can be audited against or seeded from an external system of record.
+//
+// In the jsonl format every line is a json object:
+//
+//	{
+//	  "key_hash": "<hex of the 32 byte key hash>",
+//	  "satellite_address": "<node id>@<host>:<port>",
+//	  "macaroon_head": "<base64>",
+//	  "encrypted_secret_key": "<base64>",
+//	  "encrypted_access_grant": "<base64>",
+//	  "public": false,
+//	  "expires_at": "<RFC 3339 time, omitted if the record doesn't expire>",
+//	  "invalid_reason": "<omitted unless the record is invalid>",
+//	  "labels": {"<key>": "<value>", omitted if the record has no labels}
+//	}
+//
+// The csv format has a header row with the same field names in the same
+// order, and the same encoding of values, except for labels which are a json
+// object. An empty expires_at means the record doesn't expire, an empty
+// invalid_reason that it is valid, and empty labels that there are none. Files
+// exported before records had labels have no labels column.
+//
+// The records are exported as they are stored, so the secret key and access
+// grant stay encrypted with the access key that only the client knows.
+package export
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
+
+	"storj.io/stargate/auth"
+)
+
+var mon = monkit.Package()
+
+// Error is the error class for this package.
+var Error = errs.Class("export")
+
+// Format is a format that records are exported in.
+type Format string
+
+const (
+	// JSONLines is one json object per line.
+	JSONLines Format = "jsonl"
+	// CSV is comma separated values with a header row.
+	CSV Format = "csv"
+)
+
+// ParseFormat returns the format with the given name.
+func ParseFormat(name string) (Format, error) {
+	switch Format(name) {
+	case JSONLines, CSV:
+		return Format(name), nil
+	default:
+		return "", Error.New("unknown format %q: must be %q or %q", name, JSONLines, CSV)
+	}
+}
+
+// ContentType returns the media type of the format.
+func (format Format) ContentType() string {
+	if format == CSV {
+		return "text/csv"
+	}
+	return "application/x-ndjson"
+}
+
+// csvHeader is the header row of the csv format.
+var csvHeader = []string{
+	"key_hash",
+	"satellite_address",
+	"macaroon_head",
+	"encrypted_secret_key",
+	"encrypted_access_grant",
+	"public",
+	"expires_at",
+	"invalid_reason",
+	"labels",
+}
+
+// record is the encoding of an auth.Entry.
+type record struct {
+	KeyHash              string            `json:"key_hash"`
+	SatelliteAddress     string            `json:"satellite_address"`
+	MacaroonHead         []byte            `json:"macaroon_head"`
+	EncryptedSecretKey   []byte            `json:"encrypted_secret_key"`
+	EncryptedAccessGrant []byte            `json:"encrypted_access_grant"`
+	Public               bool              `json:"public"`
+	ExpiresAt            *time.Time        `json:"expires_at,omitempty"`
+	InvalidReason        string            `json:"invalid_reason,omitempty"`
+	Labels               map[string]string `json:"labels,omitempty"`
+}
+
+// Write writes every record of source to w in format, and returns how many
+// records were written.
+func Write(ctx context.Context, w io.Writer, source auth.Source, format Format) (count int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	bw := bufio.NewWriter(w)
+	defer func() { err = errs.Combine(err, Error.Wrap(bw.Flush())) }()
+
+	var write func(record) error
+
+	switch format {
+	case JSONLines:
+		enc := json.NewEncoder(bw)
+		write = func(r record) error { return enc.Encode(r) }
+	case CSV:
+		cw := csv.NewWriter(bw)
+		if err := cw.Write(csvHeader); err != nil {
+			return 0, Error.Wrap(err)
+		}
+		write = func(r record) error {
+			expiresAt := ""
+			if r.ExpiresAt != nil {
+				expiresAt = r.ExpiresAt.UTC().Format(time.RFC3339Nano)
+			}
+			labels := ""
+			if len(r.Labels) > 0 {
+				data, err := json.Marshal(r.Labels)
+				if err != nil {
+					return err
+				}
+				labels = string(data)
+			}
+			return cw.Write([]string{
+				r.KeyHash,
+				r.SatelliteAddress,
+				base64.StdEncoding.EncodeToString(r.MacaroonHead),
+				base64.StdEncoding.EncodeToString(r.EncryptedSecretKey),
+				base64.StdEncoding.EncodeToString(r.EncryptedAccessGrant),
+				strconv.FormatBool(r.Public),
+				expiresAt,
+				r.InvalidReason,
+				labels,
+			})
+		}
+		defer func() {
+			cw.Flush()
+			err = errs.Combine(err, Error.Wrap(cw.Error()))
+		}()
+	default:
+		return 0, Error.New("unknown format %q", format)
+	}
+
+	err = source.Iterate(ctx, func(ctx context.Context, entry auth.Entry) error {
+		count++
+		return Error.Wrap(write(encode(entry)))
+	})
+	return count, err
+}
+
+// Read parses the records in r in format and calls fn for every one of them.
+// Records are parsed as they are read, so fn is called for the records before
+// a malformed one.
+func Read(ctx context.Context, r io.Reader, format Format, fn func(ctx context.Context, entry auth.Entry) error) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	switch format {
+	case JSONLines:
+		dec := json.NewDecoder(r)
+		dec.DisallowUnknownFields()
+		for line := 1; ; line++ {
+			var rec record
+			if err := dec.Decode(&rec); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return Error.New("record %d: %v", line, err)
+			}
+			if err := emit(ctx, rec, line, fn); err != nil {
+				return err
+			}
+		}
+	case CSV:
+		// every row has as many columns as the header
+		cr := csv.NewReader(r)
+		header, err := cr.Read()
+		if err != nil {
+			return Error.New("header: %v", err)
+		}
+		// the labels column is optional
+		if len(header) != len(csvHeader) && len(header) != len(csvHeader)-1 {
+			return Error.New("header: %d columns instead of %d", len(header), len(csvHeader))
+		}
+		for i := range header {
+			if header[i] != csvHeader[i] {
+				return Error.New("header: column %d is %q instead of %q", i+1, header[i], csvHeader[i])
+			}
+		}
+		for line := 1; ; line++ {
+			row, err := cr.Read()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return Error.New("record %d: %v", line, err)
+			}
+			rec, err := parseRow(row)
+			if err != nil {
+				return Error.New("record %d: %v", line, err)
+			}
+			if err := emit(ctx, rec, line, fn); err != nil {
+				return err
+			}
+		}
+	default:
+		return Error.New("unknown format %q", format)
+	}
+}
+
+// File is an exported file that can be iterated over any number of times, so
+// that it can be the source of an auth.Migrator.
+type File struct {
+	path   string
+	format Format
+}
+
+// Open returns the exported file at path in format.
+func Open(path string, format Format) *File {
+	return &File{path: path, format: format}
+}
+
+// Iterate calls fn for every record in the file.
+func (file *File) Iterate(ctx context.Context, fn func(ctx context.Context, entry auth.Entry) error) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	f, err := os.Open(file.path)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	defer func() { err = errs.Combine(err, Error.Wrap(f.Close())) }()
+
+	return Read(ctx, f, file.format, fn)
+}
+
+// emit decodes rec and calls fn with it.
+func emit(ctx context.Context, rec record, line int, fn func(ctx context.Context, entry auth.Entry) error) error {
+	entry, err := decode(rec)
+	if err != nil {
+		return Error.New("record %d: %v", line, err)
+	}
+	return fn(ctx, entry)
+}
+
+// parseRow parses a csv row into a record.
+func parseRow(row []string) (rec record, err error) {
+	rec.KeyHash = row[0]
+	rec.SatelliteAddress = row[1]
+	for i, dest := range []*[]byte{&rec.MacaroonHead, &rec.EncryptedSecretKey, &rec.EncryptedAccessGrant} {
+		if *dest, err = base64.StdEncoding.DecodeString(row[2+i]); err != nil {
+			return record{}, errs.New("%s: %v", csvHeader[2+i], err)
+		}
+	}
+	if rec.Public, err = strconv.ParseBool(row[5]); err != nil {
+		return record{}, errs.New("public: %v", err)
+	}
+	if row[6] != "" {
+		expiresAt, err := time.Parse(time.RFC3339Nano, row[6])
+		if err != nil {
+			return record{}, errs.New("expires_at: %v", err)
+		}
+		rec.ExpiresAt = &expiresAt
+	}
+	rec.InvalidReason = row[7]
+	if len(row) > 8 && row[8] != "" {
+		if err := json.Unmarshal([]byte(row[8]), &rec.Labels); err != nil {
+			return record{}, errs.New("labels: %v", err)
+		}
+	}
+	return rec, nil
+}
+
+func encode(entry auth.Entry) record {
+	return record{
+		KeyHash:              hex.EncodeToString(entry.KeyHash[:]),
+		SatelliteAddress:     entry.Record.SatelliteAddress,
+		MacaroonHead:         entry.Record.MacaroonHead,
+		EncryptedSecretKey:   entry.Record.EncryptedSecretKey,
+		EncryptedAccessGrant: entry.Record.EncryptedAccessGrant,
+		Public:               entry.Record.Public,
+		ExpiresAt:            entry.Record.ExpiresAt,
+		InvalidReason:        entry.InvalidReason,
+		Labels:               entry.Record.Labels,
+	}
+}
+
+func decode(rec record) (auth.Entry, error) {
+	var keyHash auth.KeyHash
+	decoded, err := hex.DecodeString(rec.KeyHash)
+	if err != nil {
+		return auth.Entry{}, errs.New("key_hash: %v", err)
+	}
+	if len(decoded) != len(keyHash) {
+		return auth.Entry{}, errs.New("key_hash: must be %d bytes, got %d", len(keyHash), len(decoded))
+	}
+	copy(keyHash[:], decoded)
+
+	if rec.EncryptedAccessGrant == nil {
+		return auth.Entry{}, errs.New("encrypted_access_grant is missing")
+	}
+
+	return auth.Entry{
+		KeyHash: keyHash,
+		Record: &auth.Record{
+			SatelliteAddress:     rec.SatelliteAddress,
+			MacaroonHead:         nonNil(rec.MacaroonHead),
+			EncryptedSecretKey:   nonNil(rec.EncryptedSecretKey),
+			EncryptedAccessGrant: rec.EncryptedAccessGrant,
+			Public:               rec.Public,
+			ExpiresAt:            rec.ExpiresAt,
+			Labels:               rec.Labels,
+		},
+		InvalidReason: rec.InvalidReason,
+	}, nil
+}
+
+// nonNil returns an empty slice instead of nil, since the sql backends don't
+// accept null for blobs.
+func nonNil(data []byte) []byte {
+	if data == nil {
+		return []byte{}
+	}
+	return data
+}
@@ -0,0 +1,129 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package export_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"storj.io/stargate/auth"
+	"storj.io/stargate/auth/export"
+	"storj.io/stargate/auth/memauth"
+)
+
+func newKV(ctx context.Context, t *testing.T) *memauth.KV {
+	kv := memauth.New()
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 6, time.UTC)
+
+	require.NoError(t, kv.Put(ctx, auth.KeyHash{1}, &auth.Record{
+		SatelliteAddress:     "satellite.example.test:7777",
+		MacaroonHead:         []byte{1, 2, 3},
+		EncryptedSecretKey:   []byte("secret"),
+		EncryptedAccessGrant: []byte("grant, with \"quotes\"\nand newlines"),
+		Public:               true,
+		ExpiresAt:            &expires,
+		Labels:               map[string]string{"team": "storage", "ticket": "OPS-1"},
+	}))
+	require.NoError(t, kv.Put(ctx, auth.KeyHash{2}, &auth.Record{
+		SatelliteAddress:     "satellite.example.test:7777",
+		MacaroonHead:         []byte{},
+		EncryptedSecretKey:   []byte{},
+		EncryptedAccessGrant: []byte("grant"),
+	}))
+	require.NoError(t, kv.Invalidate(ctx, auth.KeyHash{2}, "revoked"))
+	return kv
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range []export.Format{export.JSONLines, export.CSV} {
+		format := format
+		t.Run(string(format), func(t *testing.T) {
+			ctx := context.Background()
+			kv := newKV(ctx, t)
+
+			var buf bytes.Buffer
+			count, err := export.Write(ctx, &buf, kv, format)
+			require.NoError(t, err)
+			require.EqualValues(t, 2, count)
+			require.Contains(t, buf.String(), "0200000000000000000000000000000000000000000000000000000000000000")
+
+			imported := memauth.New()
+			migrator := auth.NewMigrator(zaptest.NewLogger(t), sourceFunc(func(ctx context.Context, fn func(context.Context, auth.Entry) error) error {
+				return export.Read(ctx, bytes.NewReader(buf.Bytes()), format, fn)
+			}), imported, auth.MigrateConfig{BatchSize: 10, Verify: true})
+
+			stats, err := migrator.Migrate(ctx)
+			require.NoError(t, err)
+			require.EqualValues(t, 2, stats.Copied)
+			require.EqualValues(t, 1, stats.Invalidated)
+			require.Zero(t, stats.Mismatched)
+
+			record, err := imported.Get(ctx, auth.KeyHash{1})
+			require.NoError(t, err)
+			require.True(t, record.Public)
+			require.Equal(t, time.Date(2030, 1, 2, 3, 4, 5, 6, time.UTC), record.ExpiresAt.UTC())
+			require.Equal(t, map[string]string{"team": "storage", "ticket": "OPS-1"}, record.Labels)
+
+			_, err = imported.Get(ctx, auth.KeyHash{2})
+			require.True(t, auth.Invalid.Has(err))
+			require.Contains(t, err.Error(), "revoked")
+		})
+	}
+}
+
+func TestReadWithoutLabels(t *testing.T) {
+	ctx := context.Background()
+
+	// files exported before records had labels have no labels column
+	data := "key_hash,satellite_address,macaroon_head,encrypted_secret_key,encrypted_access_grant,public,expires_at,invalid_reason\n" +
+		strings.Repeat("0", 64) + ",sat,,,Z3JhbnQ=,true,,\n"
+
+	var entries []auth.Entry
+	err := export.Read(ctx, strings.NewReader(data), export.CSV, func(ctx context.Context, entry auth.Entry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, []byte("grant"), entries[0].Record.EncryptedAccessGrant)
+	require.Nil(t, entries[0].Record.Labels)
+}
+
+func TestMalformed(t *testing.T) {
+	ctx := context.Background()
+	ignore := func(ctx context.Context, entry auth.Entry) error { return nil }
+
+	for _, test := range []struct {
+		format export.Format
+		data   string
+	}{
+		{export.JSONLines, `{"key_hash":"01","encrypted_access_grant":""}`},
+		{export.JSONLines, `{"key_hash":"` + strings.Repeat("0", 64) + `"}`},
+		{export.JSONLines, `{"key_hash":"` + strings.Repeat("0", 64) + `","encrypted_access_grant":"","unknown":1}`},
+		{export.JSONLines, `not json`},
+		{export.CSV, "wrong,header,row,with,eight,columns,in,total\n"},
+		{export.CSV, "key_hash,satellite_address,macaroon_head,encrypted_secret_key,encrypted_access_grant,public,expires_at,invalid_reason\n" +
+			strings.Repeat("0", 64) + ",sat,,,,maybe,,\n"},
+		{export.CSV, "key_hash,satellite_address,macaroon_head,encrypted_secret_key,encrypted_access_grant,public,expires_at,invalid_reason,labels\n" +
+			strings.Repeat("0", 64) + ",sat,,,Z3JhbnQ=,true,,,not json\n"},
+	} {
+		err := export.Read(ctx, strings.NewReader(test.data), test.format, ignore)
+		require.Error(t, err, test.data)
+	}
+
+	_, err := export.ParseFormat("xml")
+	require.Error(t, err)
+}
+
+type sourceFunc func(ctx context.Context, fn func(context.Context, auth.Entry) error) error
+
+func (f sourceFunc) Iterate(ctx context.Context, fn func(context.Context, auth.Entry) error) error {
+	return f(ctx, fn)
+}
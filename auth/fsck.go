@@ -0,0 +1,183 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package auth
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// encryptedSecretKeySize is the size of a secret key that is encrypted with
+// AES-GCM, which adds a 16 byte tag.
+const encryptedSecretKeySize = 32 + 16
+
+// CheckConfig configures what a Checker looks for.
+type CheckConfig struct {
+	Satellites string `help:"comma separated satellite addresses that records may point at; not checked when empty" default:""`
+	Repair     bool   `help:"invalidate the records that have problems" default:"false"`
+}
+
+// The kinds of problems that a Checker finds.
+const (
+	ProblemCorrupt          = "corrupt"
+	ProblemUndecryptable    = "undecryptable"
+	ProblemUnknownSatellite = "unknown satellite"
+)
+
+// unrecordedSatellite is the satellite address of records that were
+// registered before satellite addresses were read from their access grants.
+const unrecordedSatellite = "TODO"
+
+// Problem is a record that a Checker found a problem with.
+type Problem struct {
+	KeyHash  KeyHash `json:"-"`
+	Kind     string  `json:"kind"`
+	Detail   string  `json:"detail"`
+	Repaired bool    `json:"repaired"`
+}
+
+// MarshalJSON adds the hex encoded key hash to the problem.
+func (p Problem) MarshalJSON() ([]byte, error) {
+	type problem Problem
+	return json.Marshal(struct {
+		KeyHash string `json:"key_hash"`
+		problem
+	}{hex.EncodeToString(p.KeyHash[:]), problem(p)})
+}
+
+// CheckStats counts what a check found.
+type CheckStats struct {
+	Checked  int64 `json:"checked"`
+	Problems int64 `json:"problems"`
+	Repaired int64 `json:"repaired"`
+}
+
+// Checker checks the consistency of the records of a KV, like after a
+// migration, and optionally repairs them.
+//
+// Records are repaired by invalidating them, so that clients get an error
+// instead of an access that doesn't work, and so that the sweeper eventually
+// deletes them. Records that are already invalid or soft deleted are reported
+// but not repaired.
+type Checker struct {
+	log    *zap.Logger
+	kv     KV
+	config CheckConfig
+
+	// Open returns the decrypted record of a key, for key/value stores that
+	// keep records sealed, like envelopeauth. Records that Open fails for with
+	// an Undecryptable error are problems, and other errors stop the check.
+	Open func(ctx context.Context, keyHash KeyHash, record *Record) (*Record, error)
+
+	// Problem is called for every problem that is found, before it is
+	// repaired.
+	Problem func(problem Problem)
+}
+
+// NewChecker constructs a Checker of the records of kv, which has to be a
+// ListingKV.
+func NewChecker(log *zap.Logger, kv KV, config CheckConfig) *Checker {
+	return &Checker{
+		log:    log,
+		kv:     kv,
+		config: config,
+	}
+}
+
+// Check checks every record and repairs the ones with problems if configured
+// to. Problems are repaired after every record was checked, so that the KV
+// isn't changed while it is iterated.
+func (c *Checker) Check(ctx context.Context) (stats CheckStats, problems []Problem, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	satellites := make(map[string]bool)
+	for _, satellite := range strings.Split(c.config.Satellites, ",") {
+		if satellite = strings.TrimSpace(satellite); satellite != "" {
+			satellites[satellite] = true
+		}
+	}
+
+	var repairable []int
+	err = Iterate(ctx, c.kv, func(ctx context.Context, entry Entry) error {
+		stats.Checked++
+
+		kind, detail, err := c.check(ctx, entry, satellites)
+		if err != nil {
+			return err
+		}
+		if kind == "" {
+			return nil
+		}
+
+		problem := Problem{KeyHash: entry.KeyHash, Kind: kind, Detail: detail}
+		if c.Problem != nil {
+			c.Problem(problem)
+		}
+		if entry.InvalidReason == "" && entry.DeletedAt == nil {
+			repairable = append(repairable, len(problems))
+		}
+		problems = append(problems, problem)
+		stats.Problems++
+		return nil
+	})
+	if err != nil || !c.config.Repair {
+		return stats, problems, err
+	}
+
+	for _, i := range repairable {
+		problem := &problems[i]
+		reason := fmt.Sprintf("fsck: %s: %s", problem.Kind, problem.Detail)
+		if err := c.kv.Invalidate(ctx, problem.KeyHash, reason); err != nil {
+			return stats, problems, err
+		}
+		c.log.Info("invalidated record", zap.String("problem", problem.Kind), zap.String("detail", problem.Detail))
+		problem.Repaired = true
+		stats.Repaired++
+	}
+	return stats, problems, nil
+}
+
+// check returns the kind and detail of the problem of the entry, if it has
+// one.
+func (c *Checker) check(ctx context.Context, entry Entry, satellites map[string]bool) (kind, detail string, err error) {
+	record := entry.Record
+	if record == nil {
+		return ProblemCorrupt, "record is missing", nil
+	}
+
+	if c.Open != nil {
+		record, err = c.Open(ctx, entry.KeyHash, record)
+		if Undecryptable.Has(err) {
+			return ProblemUndecryptable, err.Error(), nil
+		}
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	switch {
+	case len(record.MacaroonHead) == 0:
+		return ProblemCorrupt, "macaroon head is missing", nil
+	case len(record.EncryptedAccessGrant) == 0:
+		return ProblemCorrupt, "encrypted access grant is missing", nil
+	case len(record.EncryptedSecretKey) != encryptedSecretKeySize && !record.SecretKeyHashed():
+		return ProblemCorrupt, fmt.Sprintf("encrypted secret key has %d bytes instead of %d",
+			len(record.EncryptedSecretKey), encryptedSecretKeySize), nil
+	case record.ExpiresAt != nil && record.ExpiresAt.IsZero():
+		return ProblemCorrupt, "expiration is the zero time", nil
+	}
+	if err := ValidateLabels(record.Labels); err != nil {
+		return ProblemCorrupt, err.Error(), nil
+	}
+
+	if len(satellites) > 0 && record.SatelliteAddress != unrecordedSatellite && !satellites[record.SatelliteAddress] {
+		return ProblemUnknownSatellite, fmt.Sprintf("satellite %q is not known", record.SatelliteAddress), nil
+	}
+	return "", "", nil
+}
@@ -0,0 +1,103 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package auth_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"storj.io/stargate/auth"
+	"storj.io/stargate/auth/memauth"
+)
+
+func TestChecker(t *testing.T) {
+	ctx := context.Background()
+	kv := memauth.New()
+
+	good := func(satellite string) *auth.Record {
+		return &auth.Record{
+			SatelliteAddress:     satellite,
+			MacaroonHead:         []byte("head"),
+			EncryptedSecretKey:   make([]byte, 48),
+			EncryptedAccessGrant: []byte("grant"),
+		}
+	}
+	corrupt := good("known:7777")
+	corrupt.MacaroonHead = nil
+	truncated := good("known:7777")
+	truncated.EncryptedSecretKey = truncated.EncryptedSecretKey[:10]
+
+	require.NoError(t, kv.Put(ctx, auth.KeyHash{1}, good("known:7777")))
+	require.NoError(t, kv.Put(ctx, auth.KeyHash{2}, good("TODO")))
+	require.NoError(t, kv.Put(ctx, auth.KeyHash{3}, corrupt))
+	require.NoError(t, kv.Put(ctx, auth.KeyHash{4}, truncated))
+	require.NoError(t, kv.Put(ctx, auth.KeyHash{5}, good("unknown:7777")))
+	require.NoError(t, kv.Put(ctx, auth.KeyHash{6}, good("known:7777")))
+	require.NoError(t, kv.Put(ctx, auth.KeyHash{7}, corrupt))
+	require.NoError(t, kv.Invalidate(ctx, auth.KeyHash{7}, "revoked"))
+
+	open := func(ctx context.Context, keyHash auth.KeyHash, record *auth.Record) (*auth.Record, error) {
+		if keyHash == (auth.KeyHash{6}) {
+			return nil, auth.Undecryptable.New("damaged")
+		}
+		return record, nil
+	}
+
+	check := func(repair bool) (auth.CheckStats, map[auth.KeyHash]auth.Problem) {
+		checker := auth.NewChecker(zaptest.NewLogger(t), kv, auth.CheckConfig{Satellites: "known:7777", Repair: repair})
+		checker.Open = open
+		stats, problems, err := checker.Check(ctx)
+		require.NoError(t, err)
+
+		byKey := make(map[auth.KeyHash]auth.Problem)
+		for _, problem := range problems {
+			byKey[problem.KeyHash] = problem
+		}
+		return stats, byKey
+	}
+
+	stats, problems := check(false)
+	require.Equal(t, auth.CheckStats{Checked: 7, Problems: 5}, stats)
+	require.Equal(t, auth.ProblemCorrupt, problems[auth.KeyHash{3}].Kind)
+	require.Equal(t, auth.ProblemCorrupt, problems[auth.KeyHash{4}].Kind)
+	require.Equal(t, auth.ProblemUnknownSatellite, problems[auth.KeyHash{5}].Kind)
+	require.Equal(t, auth.ProblemUndecryptable, problems[auth.KeyHash{6}].Kind)
+	require.Equal(t, auth.ProblemCorrupt, problems[auth.KeyHash{7}].Kind)
+
+	// nothing is changed without repair
+	_, err := kv.Get(ctx, auth.KeyHash{3})
+	require.NoError(t, err)
+
+	stats, problems = check(true)
+	require.Equal(t, auth.CheckStats{Checked: 7, Problems: 5, Repaired: 4}, stats)
+	require.False(t, problems[auth.KeyHash{7}].Repaired, "invalid records are not repaired")
+	for _, keyHash := range []auth.KeyHash{{3}, {4}, {5}, {6}} {
+		require.True(t, problems[keyHash].Repaired)
+		_, err := kv.Get(ctx, keyHash)
+		require.True(t, auth.Invalid.Has(err))
+	}
+	for _, keyHash := range []auth.KeyHash{{1}, {2}} {
+		record, err := kv.Get(ctx, keyHash)
+		require.NoError(t, err)
+		require.NotNil(t, record)
+	}
+
+	data, err := json.Marshal(problems[auth.KeyHash{5}])
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"key_hash":"05000000`)
+	require.Contains(t, string(data), `"kind":"unknown satellite"`)
+
+	// errors that are not about the record stop the check
+	checker := auth.NewChecker(zaptest.NewLogger(t), kv, auth.CheckConfig{})
+	checker.Open = func(ctx context.Context, keyHash auth.KeyHash, record *auth.Record) (*auth.Record, error) {
+		return nil, errors.New("key manager unavailable")
+	}
+	_, _, err = checker.Check(ctx)
+	require.Error(t, err)
+}
@@ -0,0 +1,41 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package httpauth
+
+import (
+	"context"
+	"net/http"
+)
+
+// adminListenerKey marks the context of requests that the handler of Admin
+// serves.
+type adminListenerKey struct{}
+
+// SeparateAdmin makes the administrative routes, like deleting, invalidating,
+// listing, exporting and metrics, only served by the handler of Admin, which
+// is meant to listen on an address that isn't public. Resources then only
+// serve registration, resolution and health checks.
+func (res *Resources) SeparateAdmin(separate bool) {
+	res.separateAdmin = separate
+}
+
+// Admin returns the handler of the administrative listener. It serves all of
+// the routes, so that admin tools only need its address.
+func (res *Resources) Admin() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		res.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), adminListenerKey{}, true)))
+	})
+}
+
+// admin wraps the handler of an administrative route so that it is not found
+// on the public listener, if the admin routes are separated.
+func (res *Resources) admin(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if res.separateAdmin && req.Context().Value(adminListenerKey{}) == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		handler.ServeHTTP(w, req)
+	})
+}
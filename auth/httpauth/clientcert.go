@@ -0,0 +1,43 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package httpauth
+
+import (
+	"net/http"
+)
+
+// RequireClientCertificates makes the destructive routes, which delete,
+// invalidate or overwrite accesses, require a client certificate that the TLS
+// listener verified, in addition to the auth token. Registering accesses and
+// reading them keep working with the auth token alone.
+func (res *Resources) RequireClientCertificates(require bool) {
+	res.requireClientCert = require
+}
+
+// destructive wraps the handler of a destructive route so that requests
+// without a verified client certificate are rejected, if client certificates
+// are required.
+func (res *Resources) destructive(handler http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if res.requireClientCert && clientCertificateName(req) == "" {
+			http.Error(w, "client certificate required", http.StatusForbidden)
+			return
+		}
+		handler(w, req)
+	})
+}
+
+// clientCertificateName returns the common name of the verified client
+// certificate of the request, or an empty string if it has none.
+func clientCertificateName(req *http.Request) string {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	name := req.TLS.VerifiedChains[0][0].Subject.CommonName
+	if name == "" {
+		// certificates without a common name are still verified
+		return "client certificate"
+	}
+	return name
+}
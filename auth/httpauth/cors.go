@@ -0,0 +1,109 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package httpauth
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig configures which web pages may call the access routes from the
+// browser, like the satellite web UI.
+type CORSConfig struct {
+	AllowedOrigins string        `help:"comma separated origins of web pages that may call the /v1/access routes from the browser, like https://us1.storj.io, or * for every origin; cors is disabled when empty" default:""`
+	AllowedHeaders string        `help:"comma separated request headers that the web pages may send" default:"Authorization,Content-Type,Idempotency-Key,X-Actor,X-Request-Id"`
+	AllowedMethods string        `help:"comma separated methods that the web pages may use" default:"GET,POST,PUT,DELETE"`
+	MaxAge         time.Duration `help:"how long browsers may cache the result of a preflight request" default:"10m0s"`
+}
+
+// corsPolicy is a parsed CORSConfig.
+type corsPolicy struct {
+	anyOrigin bool
+	origins   map[string]bool
+	headers   string
+	methods   string
+	maxAge    string
+}
+
+// SetCORS allows the web pages of config to call the access routes from the
+// browser, and answers their preflight requests.
+func (res *Resources) SetCORS(config CORSConfig) {
+	origins := make(map[string]bool)
+	anyOrigin := false
+	for _, origin := range splitList(config.AllowedOrigins) {
+		if origin == "*" {
+			anyOrigin = true
+		}
+		origins[strings.TrimSuffix(origin, "/")] = true
+	}
+	if len(origins) == 0 {
+		res.cors = nil
+		return
+	}
+	res.cors = &corsPolicy{
+		anyOrigin: anyOrigin,
+		origins:   origins,
+		headers:   strings.Join(splitList(config.AllowedHeaders), ", "),
+		methods:   strings.Join(splitList(config.AllowedMethods), ", "),
+		maxAge:    strconv.Itoa(int(config.MaxAge / time.Second)),
+	}
+}
+
+// splitList splits a comma separated list, without empty entries.
+func splitList(s string) []string {
+	var list []string
+	for _, entry := range strings.Split(s, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+// handleCORS adds the cors headers to responses of the access routes for
+// allowed origins. It answers preflight requests itself, and returns whether
+// the request still has to be served.
+func (res *Resources) handleCORS(w http.ResponseWriter, req *http.Request) bool {
+	if res.cors == nil {
+		return true
+	}
+	if path := req.URL.Path; path != "/v1/access" && !strings.HasPrefix(path, "/v1/access/") {
+		return true
+	}
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	preflight := req.Method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != ""
+	header := w.Header()
+	header.Add("Vary", "Origin")
+	if !res.cors.anyOrigin && !res.cors.origins[origin] {
+		if preflight {
+			http.Error(w, "origin not allowed", http.StatusForbidden)
+			return false
+		}
+		return true
+	}
+
+	if res.cors.anyOrigin {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	header.Set("Access-Control-Expose-Headers", requestIDHeader)
+	if !preflight {
+		return true
+	}
+
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+	header.Set("Access-Control-Allow-Methods", res.cors.methods)
+	header.Set("Access-Control-Allow-Headers", res.cors.headers)
+	header.Set("Access-Control-Max-Age", res.cors.maxAge)
+	w.WriteHeader(http.StatusNoContent)
+	return false
+}
@@ -0,0 +1,95 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package httpauth
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/btcsuite/btcutil/base58"
+	"github.com/zeebo/errs"
+
+	"storj.io/stargate/auth"
+)
+
+const (
+	// maxRequestSize is the maximum size of a request body for a single access.
+	// Access grants are a few kilobytes at most.
+	maxRequestSize = 64 << 10
+
+	// maxBatchRequestSize is the maximum size of a batch request body.
+	maxBatchRequestSize = 64 << 20
+
+	// maxJSONDepth is the maximum nesting of objects and arrays in a request body.
+	// None of the requests nest deeper than 3.
+	maxJSONDepth = 8
+)
+
+// errBadRequest is the class of errors caused by malformed requests.
+var errBadRequest = errs.Class("bad request")
+
+// decodeJSON decodes the body of req into v. The body is rejected if it is
+// larger than limit bytes or if it nests deeper than maxJSONDepth, so that
+// hostile bodies can't exhaust memory or the stack.
+func decodeJSON(w http.ResponseWriter, req *http.Request, limit int64, v interface{}) error {
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, limit))
+	if err != nil {
+		return errBadRequest.Wrap(err)
+	}
+	if err := checkJSONDepth(data, maxJSONDepth); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errBadRequest.Wrap(err)
+	}
+	return nil
+}
+
+// checkJSONDepth returns an error if objects and arrays in data nest deeper
+// than maxDepth. It does not otherwise validate data.
+func checkJSONDepth(data []byte, maxDepth int) error {
+	depth := 0
+	inString, escaped := false, false
+	for _, b := range data {
+		switch {
+		case escaped:
+			escaped = false
+		case inString:
+			switch b {
+			case '\\':
+				escaped = true
+			case '"':
+				inString = false
+			}
+		case b == '"':
+			inString = true
+		case b == '{' || b == '[':
+			depth++
+			if depth > maxDepth {
+				return errBadRequest.New("request body nested too deeply: the maximum is %d", maxDepth)
+			}
+		case b == '}' || b == ']':
+			depth--
+		}
+	}
+	return nil
+}
+
+// parseAccessKeyID decodes an access key id into the encryption key it encodes.
+func parseAccessKeyID(accessKeyID string) (key auth.EncryptionKey, err error) {
+	encryptionKeyBytes, version, err := base58.CheckDecode(accessKeyID)
+	if err != nil {
+		return key, errBadRequest.Wrap(err)
+	}
+	if len(encryptionKeyBytes) != len(key) {
+		return key, errBadRequest.New("invalid access key id length")
+	}
+	if version != auth.VersionAccessKeyID {
+		return key, errBadRequest.New("unexpected decoded version")
+	}
+
+	copy(key[:], encryptionKeyBytes)
+	return key, nil
+}
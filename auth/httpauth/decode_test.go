@@ -0,0 +1,56 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package httpauth
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcutil/base58"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/stargate/auth"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	decode := func(body string, limit int64) error {
+		var v interface{}
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		return decodeJSON(httptest.NewRecorder(), req, limit, &v)
+	}
+
+	require.NoError(t, decode(`{"accesses": [{"access_grant": "grant"}]}`, 100))
+
+	// bodies over the limit are rejected
+	require.Error(t, decode(`{"access_grant": "`+strings.Repeat("a", 100)+`"}`, 100))
+
+	// deeply nested bodies are rejected, but brackets in strings don't count
+	require.Error(t, decode(strings.Repeat("[", maxJSONDepth+1)+strings.Repeat("]", maxJSONDepth+1), 1000))
+	require.NoError(t, decode(`{"reason": "`+strings.Repeat(`[{\"`, 100)+`"}`, 1000))
+
+	// malformed bodies are rejected
+	require.Error(t, decode(`{"reason": `, 100))
+	require.Error(t, decode(`{} {}`, 100))
+	require.Error(t, decode(``, 100))
+}
+
+func TestParseAccessKeyID(t *testing.T) {
+	var key auth.EncryptionKey
+	key[0] = 1
+
+	parsed, err := parseAccessKeyID(base58.CheckEncode(key[:], auth.VersionAccessKeyID))
+	require.NoError(t, err)
+	require.Equal(t, key, parsed)
+
+	for _, invalid := range []string{
+		"",
+		"not base58!",
+		base58.CheckEncode(key[:], auth.VersionSecretKey),
+		base58.CheckEncode(key[:10], auth.VersionAccessKeyID),
+	} {
+		_, err := parseAccessKeyID(invalid)
+		require.Error(t, err, invalid)
+	}
+}
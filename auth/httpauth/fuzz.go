@@ -0,0 +1,68 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+//go:build gofuzz
+// +build gofuzz
+
+package httpauth
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/btcsuite/btcutil/base58"
+
+	"storj.io/stargate/auth"
+	"storj.io/stargate/auth/memauth"
+)
+
+// The fuzz targets are built with go-fuzz, for example
+//
+//	go-fuzz-build -func FuzzResources storj.io/stargate/auth/httpauth
+//	go-fuzz -bin httpauth-fuzz.zip -workdir testdata/fuzz
+
+// FuzzResources sends data as the body of every endpoint that reads a body.
+func FuzzResources(data []byte) int {
+	res := New(auth.NewDatabase(memauth.New()), "endpoint", Tokens{"authToken": RoleAdmin}, nil)
+
+	interesting := 0
+	for _, endpoint := range []struct{ method, path string }{
+		{"POST", "/v1/access"},
+		{"POST", "/v1/access/batch"},
+		{"PUT", "/v1/access/" + base58.CheckEncode(make([]byte, 32), auth.VersionAccessKeyID) + "/invalid"},
+	} {
+		req := httptest.NewRequest(endpoint.method, endpoint.path, bytes.NewReader(data))
+		req.Header.Set("Authorization", "Bearer authToken")
+		rec := httptest.NewRecorder()
+		res.ServeHTTP(rec, req)
+		if rec.Code == http.StatusOK {
+			interesting = 1
+		}
+	}
+	return interesting
+}
+
+// FuzzDecodeJSON decodes data as the batch request, which is the most complex one.
+func FuzzDecodeJSON(data []byte) int {
+	var request struct {
+		Accesses []struct {
+			AccessGrant string `json:"access_grant"`
+			Public      bool   `json:"public"`
+		} `json:"accesses"`
+	}
+
+	req := httptest.NewRequest("POST", "/", bytes.NewReader(data))
+	if err := decodeJSON(httptest.NewRecorder(), req, maxBatchRequestSize, &request); err != nil {
+		return 0
+	}
+	return 1
+}
+
+// FuzzAccessKeyID parses data as an access key id from a url.
+func FuzzAccessKeyID(data []byte) int {
+	if _, err := parseAccessKeyID(string(data)); err != nil {
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,54 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package httpauth
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"go.uber.org/zap"
+
+	"storj.io/stargate/auth"
+	"storj.io/stargate/internal/metrics"
+)
+
+// readyTimeout is how long the checks of a readiness probe may take.
+const readyTimeout = 5 * time.Second
+
+// healthz answers liveness probes, which only check that the process serves
+// requests.
+func (res *Resources) healthz(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// readyz answers readiness probes, which check that the key/value store can be
+// reached and, for encrypted records, that their keys can be unwrapped, so
+// that traffic isn't routed to instances that can't serve it. Why a check
+// failed is logged instead of returned, because probes are unauthenticated.
+func (res *Resources) readyz(w http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), readyTimeout)
+	defer cancel()
+
+	if err := auth.HealthCheck(ctx, res.db.KV()); err != nil {
+		zap.L().Warn("not ready", zap.Error(err))
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// getMetrics responds with a snapshot of the metrics of the auth service, or
+// with their definitions if the request has the definitions query parameter.
+func (res *Resources) getMetrics(w http.ResponseWriter, req *http.Request) {
+	if !res.requestAllowed(req, RoleRead) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	metrics.Handler(monkit.Default).ServeHTTP(w, req)
+}
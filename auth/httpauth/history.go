@@ -0,0 +1,193 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package httpauth
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+
+	"storj.io/stargate/auth"
+)
+
+// v2Event is an event of the history of a record.
+type v2Event struct {
+	KeyHash  string    `json:"key_hash"`
+	Action   string    `json:"action"`
+	At       time.Time `json:"at"`
+	Actor    string    `json:"actor,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+	SourceIP string    `json:"source_ip,omitempty"`
+}
+
+// v2EventFilter selects the events of a history query.
+type v2EventFilter struct {
+	action string
+	since  time.Time
+	until  time.Time
+}
+
+// requestEventFilter returns the event filter of the query parameters of the
+// request.
+func requestEventFilter(req *http.Request) (filter v2EventFilter, err error) {
+	query := req.URL.Query()
+	switch filter.action = query.Get("action"); filter.action {
+	case "", auth.HistoryCreated, auth.HistoryInvalidated, auth.HistoryDeleted, auth.HistoryRestored:
+	default:
+		return filter, errBadRequest.New("action must be %s, %s, %s or %s",
+			auth.HistoryCreated, auth.HistoryInvalidated, auth.HistoryDeleted, auth.HistoryRestored)
+	}
+	for name, t := range map[string]*time.Time{"since": &filter.since, "until": &filter.until} {
+		if value := query.Get(name); value != "" {
+			if *t, err = time.Parse(time.RFC3339, value); err != nil {
+				return filter, errBadRequest.New("%s must be like 2006-01-02T15:04:05Z", name)
+			}
+		}
+	}
+	return filter, nil
+}
+
+// matches returns whether the filter selects the event, which is selected
+// when it happened at or after since and before until.
+func (filter v2EventFilter) matches(event auth.HistoryEvent) bool {
+	if filter.action != "" && event.Action != filter.action {
+		return false
+	}
+	if !filter.since.IsZero() && event.At.Before(filter.since) {
+		return false
+	}
+	if !filter.until.IsZero() && !event.At.Before(filter.until) {
+		return false
+	}
+	return true
+}
+
+// appendEvents appends the events of the history of the key hash that the
+// filter selects.
+func (filter v2EventFilter) appendEvents(events []v2Event, keyHash auth.KeyHash, history []auth.HistoryEvent) []v2Event {
+	for _, event := range history {
+		if !filter.matches(event) {
+			continue
+		}
+		events = append(events, v2Event{
+			KeyHash:  hex.EncodeToString(keyHash[:]),
+			Action:   event.Action,
+			At:       event.At,
+			Actor:    event.Actor,
+			Reason:   event.Reason,
+			SourceIP: event.SourceIP,
+		})
+	}
+	return events
+}
+
+// listHistoryV2 returns a page of the events of the histories of the records
+// for auditing, filtered by the action, since and until query parameters. The
+// key_hash or access_key_id query parameters select the history of a single
+// record, which is returned in one page. Otherwise the events are in the
+// order of the key hashes of their records, and then in the order that they
+// happened. A page ends after all of the events of a record, so it may have
+// more events than the limit, and the cursor is opaque like the one of
+// GET /v2/access.
+func (res *Resources) listHistoryV2(w http.ResponseWriter, req *http.Request) {
+	if !res.requestAllowed(req, RoleRead) {
+		writeV2Error(w, http.StatusUnauthorized, codeUnauthorized, "unauthorized")
+		return
+	}
+	filter, err := requestEventFilter(req)
+	if err != nil {
+		writeV2Error(w, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+
+	query := req.URL.Query()
+
+	response := struct {
+		Events     []v2Event `json:"events"`
+		NextCursor string    `json:"next_cursor"`
+	}{Events: []v2Event{}}
+
+	var keyHash *auth.KeyHash
+	if value := query.Get("key_hash"); value != "" {
+		var parsed auth.KeyHash
+		decoded, err := hex.DecodeString(value)
+		if err != nil || len(decoded) != len(parsed) {
+			writeV2Error(w, http.StatusBadRequest, codeBadRequest, "invalid key_hash")
+			return
+		}
+		copy(parsed[:], decoded)
+		keyHash = &parsed
+	} else if value := query.Get("access_key_id"); value != "" {
+		key, err := parseAccessKeyID(value)
+		if err != nil {
+			writeV2Error(w, http.StatusBadRequest, codeBadRequest, err.Error())
+			return
+		}
+		parsed := key.Hash()
+		keyHash = &parsed
+	}
+
+	if keyHash != nil {
+		history, err := auth.History(req.Context(), res.db.KV(), *keyHash)
+		if err != nil {
+			v2DatabaseError(w, err)
+			return
+		}
+		response.Events = filter.appendEvents(response.Events, *keyHash, history)
+		writeV2JSON(w, req, response)
+		return
+	}
+
+	var after auth.KeyHash
+	if cursor := query.Get("cursor"); cursor != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil || len(decoded) != len(after) {
+			writeV2Error(w, http.StatusBadRequest, codeBadRequest, "invalid cursor")
+			return
+		}
+		copy(after[:], decoded)
+	}
+
+	limit := defaultListLimit
+	if value := query.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxListLimit {
+			writeV2Error(w, http.StatusBadRequest, codeBadRequest, "limit must be between 1 and "+strconv.Itoa(maxListLimit))
+			return
+		}
+	}
+
+	// the records are read in pages as large as the limit, so a page of
+	// events reads at most as many histories as a list of records does
+	for pages := 0; pages < maxListPages && len(response.Events) < limit; pages++ {
+		entries, err := auth.List(req.Context(), res.db.KV(), after, limit)
+		if err != nil {
+			v2DatabaseError(w, err)
+			return
+		}
+
+		for _, entry := range entries {
+			history, err := auth.History(req.Context(), res.db.KV(), entry.KeyHash)
+			if err != nil {
+				v2DatabaseError(w, err)
+				return
+			}
+			response.Events = filter.appendEvents(response.Events, entry.KeyHash, history)
+			after = entry.KeyHash
+			if len(response.Events) >= limit {
+				break
+			}
+		}
+
+		if len(entries) < limit && len(response.Events) < limit {
+			response.NextCursor = ""
+			break
+		}
+		response.NextCursor = base64.RawURLEncoding.EncodeToString(after[:])
+	}
+
+	writeV2JSON(w, req, response)
+}
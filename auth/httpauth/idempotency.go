@@ -0,0 +1,76 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package httpauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"reflect"
+	"time"
+
+	"github.com/zeebo/errs"
+
+	"storj.io/stargate/auth"
+)
+
+// idempotencyHeader has a key that the client picks for a registration, so
+// that retrying it returns the access that the first attempt registered
+// instead of registering another one.
+const idempotencyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLength is the maximum length of an idempotency key.
+const maxIdempotencyKeyLength = 255
+
+// minIdempotencySecretSize is the minimum size of the secret that access key
+// ids are derived from idempotency keys with.
+const minIdempotencySecretSize = 32
+
+// SetIdempotencySecret sets the secret that the access key ids of
+// registrations with idempotency keys are derived with. Every instance of the
+// auth service needs the same secret to recognize retries to the others.
+// Without it, a random secret recognizes retries to the same instance until
+// it restarts.
+func (res *Resources) SetIdempotencySecret(secret []byte) error {
+	if len(secret) < minIdempotencySecretSize {
+		return errs.New("idempotency secret has %d bytes instead of at least %d", len(secret), minIdempotencySecretSize)
+	}
+	res.idempotencySecret = secret
+	return nil
+}
+
+// idempotentKey returns the encryption key of the registration of the access
+// grant with the idempotency key. The key is derived instead of stored, so
+// that retries are recognized by every instance of the auth service with the
+// secret. The secret is mixed in so that those who have the access grant and
+// the idempotency key, but didn't register it, can't tell the access key id.
+func idempotentKey(secret []byte, accessGrant, idempotencyKey string) (key auth.EncryptionKey) {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write([]byte("idempotency key\x00" + accessGrant + "\x00" + idempotencyKey))
+	copy(key[:], mac.Sum(nil))
+	return key
+}
+
+// sameRegistration returns whether a registered access has the routes, the
+// visibility, the expiration and the labels of a registration. Expirations
+// are compared to the second, since backends store them with less precision.
+func sameRegistration(access *auth.Access, routes []auth.Route, public bool, expiresAt *time.Time, labels map[string]string) bool {
+	if access.Public != public || len(access.Routes) != len(routes) || len(access.Labels) != len(labels) {
+		return false
+	}
+	if (access.ExpiresAt == nil) != (expiresAt == nil) {
+		return false
+	}
+	if expiresAt != nil && !access.ExpiresAt.Truncate(time.Second).Equal(expiresAt.Truncate(time.Second)) {
+		return false
+	}
+	if len(routes) > 0 && !reflect.DeepEqual(access.Routes, routes) {
+		return false
+	}
+	for name, value := range labels {
+		if actual, ok := access.Labels[name]; !ok || actual != value {
+			return false
+		}
+	}
+	return true
+}
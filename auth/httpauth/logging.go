@@ -0,0 +1,160 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package httpauth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// requestIDHeader has the id of a request. A valid id of the client is kept,
+// so that requests can be followed through proxies, and every response has
+// the id, so that users can quote it.
+const requestIDHeader = "X-Request-Id"
+
+// maxRequestIDLength is the maximum length of request ids of clients.
+const maxRequestIDLength = 128
+
+// SetLogger logs every request to log, with its id, method, route, status,
+// latency and who made it. Health checks are logged at the debug level.
+func (res *Resources) SetLogger(log *zap.Logger) {
+	res.log = log
+}
+
+// requestIDKey is the context key of the id of a request.
+type requestIDKey struct{}
+
+// RequestID returns the id of the request of ctx, or an empty string if it
+// has none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// logRequest assigns an id to the request and starts to log it. The returned
+// response writer and request must be used to serve it, and done must be
+// called once it has been served.
+func (res *Resources) logRequest(w http.ResponseWriter, req *http.Request) (_ http.ResponseWriter, _ *http.Request, done func()) {
+	id := req.Header.Get(requestIDHeader)
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	w.Header().Set(requestIDHeader, id)
+	ctx := context.WithValue(req.Context(), requestIDKey{}, id)
+
+	if res.log == nil {
+		return w, req.WithContext(ctx), func() {}
+	}
+
+	start := time.Now()
+	path := req.URL.Path
+	route := new(route)
+	status := &statusWriter{ResponseWriter: w}
+	req = req.WithContext(withRoute(ctx, route))
+
+	return status, req, func() {
+		level := zapcore.InfoLevel
+		switch path {
+		case "/healthz", "/readyz":
+			level = zapcore.DebugLevel
+		}
+		entry := res.log.Check(level, "request")
+		if entry == nil {
+			return
+		}
+
+		fields := []zap.Field{
+			zap.String("request_id", id),
+			zap.String("method", req.Method),
+			zap.String("route", route.String()),
+			zap.Int("status", status.Status()),
+			zap.Duration("latency", time.Since(start)),
+			zap.String("client_ip", res.clientIP(req)),
+		}
+		if role := res.requestRole(req); role != "" {
+			fields = append(fields, zap.String("role", string(role)))
+		}
+		if actor := req.Header.Get(actorHeader); actor != "" {
+			fields = append(fields, zap.String("actor", actor))
+		}
+		if name := clientCertificateName(req); name != "" {
+			fields = append(fields, zap.String("client_certificate", name))
+		}
+		entry.Write(fields...)
+	}
+}
+
+// validRequestID returns whether id is a request id of a client that can be
+// kept: it must be short and printable, so that it can't forge log lines.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random request id.
+func newRequestID() string {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(id[:])
+}
+
+// route is the route that a request is dispatched to, like
+// /v1/access/*/history, so that access key ids are not logged.
+type route struct {
+	parts []string
+}
+
+// String returns the route.
+func (r *route) String() string {
+	if len(r.parts) == 0 {
+		return "/"
+	}
+	return strings.Join(r.parts, "")
+}
+
+// statusWriter records the status of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter.
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Status returns the status of the response.
+func (w *statusWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
@@ -47,6 +47,7 @@ func (d Dir) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	dir, rem := shift(req.URL.Path)
 	if h, ok := d[dir]; ok {
 		req.URL.Path = rem
+		appendRoute(req.Context(), dir)
 		h.ServeHTTP(w, req)
 	} else if h, ok := d["*"]; ok {
 		h.ServeHTTP(w, req)
@@ -70,6 +71,7 @@ func (a *Arg) Capture(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		if dir, rem := shift(req.URL.Path); dir != "" {
 			req.URL.Path = rem
+			appendRoute(req.Context(), "/*")
 			h.ServeHTTP(w, req.WithContext(addArgument(req.Context(), a, trim(dir))))
 		} else {
 			w.WriteHeader(http.StatusNotFound)
@@ -95,3 +97,20 @@ func addArgument(ctx context.Context, a *Arg, val string) context.Context {
 	}
 	return context.WithValue(ctx, argumentsKey{}, map[*Arg]string{a: val})
 }
+
+//
+// the route is recorded on the context for logging, without the captured
+// path components
+//
+
+type routeKey struct{}
+
+func withRoute(ctx context.Context, r *route) context.Context {
+	return context.WithValue(ctx, routeKey{}, r)
+}
+
+func appendRoute(ctx context.Context, part string) {
+	if r, ok := ctx.Value(routeKey{}).(*route); ok {
+		r.parts = append(r.parts, part)
+	}
+}
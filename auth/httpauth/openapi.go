@@ -0,0 +1,534 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package httpauth
+
+import (
+	"io"
+	"net/http"
+)
+
+// getOpenAPI responds with the OpenAPI specification of the routes. It needs
+// no auth token, since it documents the api rather than exposing data.
+func (res *Resources) getOpenAPI(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = io.WriteString(w, OpenAPISpec)
+}
+
+// OpenAPISpec is the OpenAPI specification of the /v1 and /v2 routes of
+// Resources, which is served at /v1/openapi.json. The authclient package is a client of it.
+const OpenAPISpec = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "Stargate auth service",
+    "description": "Registers access grants for the gateway, and resolves the access keys that the gateway is given to them. Errors are plain text with the status code; 429 and 503 responses have a Retry-After header. When the auth service has an admin address, only registration, resolution and health checks are served on its public address, and the other routes are not found there.",
+    "version": "1"
+  },
+  "security": [{"token": []}],
+  "paths": {
+    "/v1/access": {
+      "post": {
+        "summary": "Register an access grant",
+        "description": "Anyone can register an access grant, so no auth token is needed.",
+        "operationId": "register",
+        "security": [],
+        "parameters": [
+          {"name": "Idempotency-Key", "in": "header", "description": "A key that the client picks, so that retrying the request returns the access that the first attempt registered.", "schema": {"type": "string", "maxLength": 255}}
+        ],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/RegisterRequest"}}}},
+        "responses": {
+          "200": {"description": "The registered access.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/RegisterResponse"}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "403": {"$ref": "#/components/responses/QuotaExceeded"},
+          "409": {"description": "The access of the idempotency key was invalidated, expired or deleted.", "content": {"text/plain": {"schema": {"type": "string"}}}},
+          "422": {"description": "The idempotency key was used to register the access grant with other routes, visibility, expiration or labels.", "content": {"text/plain": {"schema": {"type": "string"}}}},
+          "503": {"$ref": "#/components/responses/Unavailable"}
+        }
+      }
+    },
+    "/v1/access/batch": {
+      "post": {
+        "summary": "Register many access grants",
+        "description": "The batch is stored either completely or not at all, unless it is partial: then invalid accesses are reported with their errors and the others are stored anyway. Needs the register role.",
+        "operationId": "registerBatch",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/BatchRequest"}}}},
+        "responses": {
+          "200": {"description": "The registered accesses, in the order of the request.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/BatchResponse"}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/QuotaExceeded"},
+          "503": {"$ref": "#/components/responses/Unavailable"}
+        }
+      }
+    },
+    "/v1/access/{accessKeyId}": {
+      "parameters": [{"$ref": "#/components/parameters/AccessKeyID"}],
+      "get": {
+        "summary": "Resolve an access key",
+        "description": "Needs the read role. Failed lookups are rate limited by client ip and access key.",
+        "operationId": "resolve",
+        "responses": {
+          "200": {"description": "The access.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Access"}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"},
+          "500": {"$ref": "#/components/responses/Failed"},
+          "503": {"$ref": "#/components/responses/Unavailable"}
+        }
+      },
+      "delete": {
+        "summary": "Delete an access",
+        "description": "The access can be restored until it is purged after the grace period. Needs the admin role.",
+        "operationId": "delete",
+        "responses": {
+          "200": {"$ref": "#/components/responses/Empty"},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "500": {"$ref": "#/components/responses/Failed"},
+          "503": {"$ref": "#/components/responses/Unavailable"}
+        }
+      }
+    },
+    "/v1/access/{accessKeyId}/invalid": {
+      "parameters": [{"$ref": "#/components/parameters/AccessKeyID"}],
+      "put": {
+        "summary": "Invalidate an access",
+        "description": "Needs the admin role.",
+        "operationId": "invalidate",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/InvalidateRequest"}}}},
+        "responses": {
+          "200": {"$ref": "#/components/responses/Empty"},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "500": {"$ref": "#/components/responses/Failed"},
+          "503": {"$ref": "#/components/responses/Unavailable"}
+        }
+      }
+    },
+    "/v1/access/{accessKeyId}/restore": {
+      "parameters": [{"$ref": "#/components/parameters/AccessKeyID"}],
+      "post": {
+        "summary": "Restore a deleted access",
+        "description": "Needs the admin role.",
+        "operationId": "restore",
+        "responses": {
+          "200": {"$ref": "#/components/responses/Empty"},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "404": {"description": "The access isn't deleted, or was purged.", "content": {"text/plain": {"schema": {"type": "string"}}}},
+          "503": {"$ref": "#/components/responses/Unavailable"}
+        }
+      }
+    },
+    "/v1/access/{accessKeyId}/history": {
+      "parameters": [{"$ref": "#/components/parameters/AccessKeyID"}],
+      "get": {
+        "summary": "Get the history of an access",
+        "description": "Histories outlive their accesses. Needs the read role.",
+        "operationId": "history",
+        "responses": {
+          "200": {"description": "The events of the access, oldest first.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/History"}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "501": {"$ref": "#/components/responses/NotImplemented"},
+          "503": {"$ref": "#/components/responses/Unavailable"}
+        }
+      }
+    },
+    "/v1/access/{accessKeyId}/passphrase": {
+      "parameters": [{"$ref": "#/components/parameters/AccessKeyID"}],
+      "post": {
+        "summary": "Register an access for a prefix encrypted with a passphrase",
+        "description": "Needs the read role, or the secret key of the access in the request. Without an auth token, the registered access counts towards the quota of the owner of the access.",
+        "operationId": "derivePassphrase",
+        "security": [{"token": []}, {}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/PassphraseRequest"}}}},
+        "responses": {
+          "200": {"description": "The registered access.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/RegisterResponse"}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/QuotaExceeded"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"},
+          "503": {"$ref": "#/components/responses/Unavailable"}
+        }
+      }
+    },
+    "/v1/admin/access": {
+      "get": {
+        "summary": "List records",
+        "description": "Lists the records in the order of their key hashes, including invalid and deleted records. A filtered page may have fewer records than the limit before the last one. Needs the read role.",
+        "operationId": "list",
+        "parameters": [
+          {"name": "cursor", "in": "query", "description": "The next_cursor of the previous page.", "schema": {"type": "string"}},
+          {"name": "limit", "in": "query", "schema": {"type": "integer", "minimum": 1, "maximum": 1000, "default": 100}},
+          {"name": "satellite", "in": "query", "description": "Selects the records of a satellite address.", "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "200": {"description": "A page of records.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/RecordList"}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "501": {"$ref": "#/components/responses/NotImplemented"},
+          "503": {"$ref": "#/components/responses/Unavailable"}
+        }
+      }
+    },
+    "/v1/macaroon/{head}/invalid": {
+      "parameters": [{"name": "head", "in": "path", "required": true, "description": "The hex encoded head of a macaroon.", "schema": {"type": "string"}}],
+      "put": {
+        "summary": "Invalidate every access of an api key",
+        "description": "Needs the admin role.",
+        "operationId": "invalidateMacaroonHead",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/InvalidateRequest"}}}},
+        "responses": {
+          "200": {"description": "How many accesses were invalidated.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/InvalidatedCount"}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "501": {"$ref": "#/components/responses/NotImplemented"},
+          "503": {"$ref": "#/components/responses/Unavailable"}
+        }
+      }
+    },
+    "/v1/records": {
+      "parameters": [{"name": "format", "in": "query", "description": "The export format.", "schema": {"type": "string", "enum": ["jsonl", "csv"], "default": "jsonl"}}],
+      "get": {
+        "summary": "Export records",
+        "description": "Streams every record, or the records with all of the labels. Needs the read role.",
+        "operationId": "exportRecords",
+        "parameters": [{"name": "label", "in": "query", "description": "A label like key=value.", "schema": {"type": "array", "items": {"type": "string"}}, "explode": true}],
+        "responses": {
+          "200": {"description": "The records in the export format."},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "501": {"$ref": "#/components/responses/NotImplemented"}
+        }
+      },
+      "post": {
+        "summary": "Import records",
+        "description": "Stores the records of the body, which is in the export format, and skips records that already exist. Needs the admin role.",
+        "operationId": "importRecords",
+        "requestBody": {"required": true, "content": {"application/octet-stream": {"schema": {"type": "string", "format": "binary"}}}},
+        "responses": {
+          "200": {"description": "What was imported.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/MigrateStats"}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"}
+        }
+      }
+    },
+    "/v1/metrics": {
+      "get": {
+        "summary": "Get metrics",
+        "description": "A snapshot of the metrics, or their definitions with the definitions query parameter. Needs the read role.",
+        "operationId": "metrics",
+        "responses": {
+          "200": {"description": "The metrics."},
+          "401": {"$ref": "#/components/responses/Unauthorized"}
+        }
+      }
+    },
+    "/v2/access": {
+      "get": {
+        "summary": "List records",
+        "description": "Lists the records in the order of their key hashes, including invalid, expired and deleted records, selected by the filters. A filtered page may have fewer records than the limit before the last one. Needs the read role.",
+        "operationId": "listV2",
+        "parameters": [
+          {"name": "cursor", "in": "query", "description": "The opaque next_cursor of the previous page.", "schema": {"type": "string"}},
+          {"name": "limit", "in": "query", "schema": {"type": "integer", "minimum": 1, "maximum": 1000, "default": 100}},
+          {"name": "satellite", "in": "query", "description": "Selects the records of a satellite address.", "schema": {"type": "string"}},
+          {"name": "public", "in": "query", "description": "Selects public or private records.", "schema": {"type": "boolean"}},
+          {"name": "state", "in": "query", "description": "Selects the records in a state.", "schema": {"$ref": "#/components/schemas/State"}},
+          {"name": "unused_since", "in": "query", "description": "Selects the records that weren't used since then, including those that were never used, to find stale accesses.", "schema": {"type": "string", "format": "date-time"}},
+          {"name": "label", "in": "query", "description": "Selects the records with a label like key=value.", "schema": {"type": "array", "items": {"type": "string"}}, "explode": true},
+          {"$ref": "#/components/parameters/Fields"}
+        ],
+        "responses": {
+          "200": {"description": "A page of records.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/V2RecordList"}}}},
+          "400": {"$ref": "#/components/responses/V2Error"},
+          "401": {"$ref": "#/components/responses/V2Error"},
+          "501": {"$ref": "#/components/responses/V2Error"},
+          "503": {"$ref": "#/components/responses/V2Error"}
+        }
+      }
+    },
+    "/v2/access/{accessKeyId}": {
+      "parameters": [{"$ref": "#/components/parameters/AccessKeyID"}],
+      "get": {
+        "summary": "Resolve an access key",
+        "description": "Needs the read role. Failed lookups are rate limited by client ip and access key.",
+        "operationId": "resolveV2",
+        "parameters": [{"$ref": "#/components/parameters/Fields"}],
+        "responses": {
+          "200": {"description": "The access.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/V2Access"}}}},
+          "400": {"$ref": "#/components/responses/V2Error"},
+          "401": {"$ref": "#/components/responses/V2Error"},
+          "404": {"$ref": "#/components/responses/V2Error"},
+          "410": {"$ref": "#/components/responses/V2Error"},
+          "429": {"$ref": "#/components/responses/V2Error"},
+          "503": {"$ref": "#/components/responses/V2Error"}
+        }
+      }
+    },
+    "/v2/history": {
+      "get": {
+        "summary": "Query the history of records",
+        "description": "Lists the events of the histories of the records for auditing, in the order of the key hashes of their records and then in the order that they happened. With key_hash or access_key_id, the history of a single record is returned in one page. A page ends after all of the events of a record, so it may have more events than the limit. Responses are compressed with gzip for clients that accept it. Needs the read role.",
+        "operationId": "historyV2",
+        "parameters": [
+          {"name": "cursor", "in": "query", "description": "The opaque next_cursor of the previous page.", "schema": {"type": "string"}},
+          {"name": "limit", "in": "query", "schema": {"type": "integer", "minimum": 1, "maximum": 1000, "default": 100}},
+          {"name": "key_hash", "in": "query", "description": "Selects the history of the record with the hex encoded key hash.", "schema": {"type": "string"}},
+          {"name": "access_key_id", "in": "query", "description": "Selects the history of the record of the access key.", "schema": {"type": "string"}},
+          {"name": "action", "in": "query", "description": "Selects the events of an action.", "schema": {"type": "string", "enum": ["created", "invalidated", "deleted", "restored"]}},
+          {"name": "since", "in": "query", "description": "Selects the events at or after the time.", "schema": {"type": "string", "format": "date-time"}},
+          {"name": "until", "in": "query", "description": "Selects the events before the time.", "schema": {"type": "string", "format": "date-time"}}
+        ],
+        "responses": {
+          "200": {"description": "A page of events.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/V2EventList"}}}},
+          "400": {"$ref": "#/components/responses/V2Error"},
+          "401": {"$ref": "#/components/responses/V2Error"},
+          "503": {"$ref": "#/components/responses/V2Error"}
+        }
+      }
+    },
+    "/v1/openapi.json": {
+      "get": {
+        "summary": "Get this specification",
+        "operationId": "openapi",
+        "security": [],
+        "responses": {
+          "200": {"description": "The OpenAPI specification.", "content": {"application/json": {}}}
+        }
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "token": {"type": "http", "scheme": "bearer", "description": "An auth token, whose role is register, read or admin."}
+    },
+    "parameters": {
+      "AccessKeyID": {"name": "accessKeyId", "in": "path", "required": true, "schema": {"type": "string"}},
+      "Fields": {"name": "fields", "in": "query", "description": "Comma separated fields that the response has, instead of all of them.", "schema": {"type": "string"}}
+    },
+    "responses": {
+      "Empty": {"description": "Success.", "content": {"application/json": {"schema": {"type": "object"}}}},
+      "BadRequest": {"description": "The request is invalid.", "content": {"text/plain": {"schema": {"type": "string"}}}},
+      "Unauthorized": {"description": "The auth token is missing or doesn't have the role.", "content": {"text/plain": {"schema": {"type": "string"}}}},
+      "Failed": {"description": "The request failed, which includes access keys that don't exist or are invalid.", "content": {"text/plain": {"schema": {"type": "string"}}}},
+      "TooManyRequests": {"description": "Too many failed attempts or requests; retry after the Retry-After header.", "content": {"text/plain": {"schema": {"type": "string"}}}},
+      "NotImplemented": {"description": "The key/value store of the auth service can't do this.", "content": {"text/plain": {"schema": {"type": "string"}}}},
+      "QuotaExceeded": {"description": "The owner of the request, which is its auth token or else its client IP, reached its quota of active accesses.", "content": {"text/plain": {"schema": {"type": "string"}}}},
+      "Unavailable": {"description": "The database is unavailable; retry after the Retry-After header.", "content": {"text/plain": {"schema": {"type": "string"}}}},
+      "V2Error": {"description": "The request failed; 429 and 503 responses have a Retry-After header.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/V2Error"}}}}
+    },
+    "schemas": {
+      "Route": {
+        "type": "object",
+        "description": "Sends the requests for the objects of the bucket, or of the prefix in the bucket, to the access grant of the route. Lists of a prefix above the prefix of a route use the access grant of the access key, so routes don't restrict what the access grant of the access key can reach.",
+        "required": ["bucket", "access_grant"],
+        "properties": {
+          "bucket": {"type": "string"},
+          "prefix": {"type": "string"},
+          "access_grant": {"type": "string"}
+        }
+      },
+      "Labels": {"type": "object", "additionalProperties": {"type": "string"}},
+      "RegisterRequest": {
+        "type": "object",
+        "required": ["access_grant"],
+        "properties": {
+          "access_grant": {"type": "string"},
+          "routes": {"type": "array", "items": {"$ref": "#/components/schemas/Route"}},
+          "public": {"type": "boolean"},
+          "expires_at": {"type": "string", "format": "date-time", "nullable": true},
+          "labels": {"$ref": "#/components/schemas/Labels"}
+        }
+      },
+      "RegisterResponse": {
+        "type": "object",
+        "properties": {
+          "access_key_id": {"type": "string"},
+          "secret_key": {"type": "string"},
+          "endpoint": {"type": "string"}
+        }
+      },
+      "BatchRequest": {
+        "type": "object",
+        "required": ["accesses"],
+        "properties": {
+          "accesses": {"type": "array", "maxItems": 10000, "items": {"$ref": "#/components/schemas/RegisterRequest"}},
+          "partial": {"type": "boolean"}
+        }
+      },
+      "BatchResponse": {
+        "type": "object",
+        "properties": {
+          "accesses": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "properties": {
+                "access_key_id": {"type": "string"},
+                "secret_key": {"type": "string"},
+                "endpoint": {"type": "string"},
+                "error": {"type": "string"}
+              }
+            }
+          }
+        }
+      },
+      "Access": {
+        "type": "object",
+        "properties": {
+          "access_grant": {"type": "string"},
+          "routes": {"type": "array", "items": {"$ref": "#/components/schemas/Route"}},
+          "secret_key": {"type": "string", "description": "Missing if the secret key is derived."},
+          "public": {"type": "boolean"},
+          "labels": {"$ref": "#/components/schemas/Labels"},
+          "last_used_at": {"type": "string", "format": "date-time", "description": "When the access was last resolved, which is written to the database about once a minute. Missing if it never was."},
+          "use_count": {"type": "integer", "format": "int64", "description": "How often the access was resolved."}
+        }
+      },
+      "InvalidateRequest": {
+        "type": "object",
+        "properties": {
+          "reason": {"type": "string"}
+        }
+      },
+      "InvalidatedCount": {
+        "type": "object",
+        "properties": {
+          "invalidated": {"type": "integer", "format": "int64"}
+        }
+      },
+      "HistoryEvent": {
+        "type": "object",
+        "properties": {
+          "action": {"type": "string", "enum": ["created", "invalidated", "deleted", "restored"]},
+          "at": {"type": "string", "format": "date-time"},
+          "actor": {"type": "string"},
+          "reason": {"type": "string"},
+          "source_ip": {"type": "string"}
+        }
+      },
+      "History": {
+        "type": "object",
+        "properties": {
+          "events": {"type": "array", "items": {"$ref": "#/components/schemas/HistoryEvent"}}
+        }
+      },
+      "PassphraseRequest": {
+        "type": "object",
+        "required": ["bucket", "passphrase"],
+        "properties": {
+          "secret_key": {"type": "string"},
+          "bucket": {"type": "string"},
+          "prefix": {"type": "string"},
+          "passphrase": {"type": "string"},
+          "salt": {"type": "string"},
+          "public": {"type": "boolean"},
+          "expires_at": {"type": "string", "format": "date-time", "nullable": true}
+        }
+      },
+      "ListedRecord": {
+        "type": "object",
+        "properties": {
+          "key_hash": {"type": "string"},
+          "satellite_address": {"type": "string"},
+          "public": {"type": "boolean"},
+          "expires_at": {"type": "string", "format": "date-time"},
+          "invalid_reason": {"type": "string"},
+          "deleted_at": {"type": "string", "format": "date-time"},
+          "labels": {"$ref": "#/components/schemas/Labels"},
+          "last_used_at": {"type": "string", "format": "date-time", "description": "When the access was last resolved, which is written to the database about once a minute. Missing if it never was."},
+          "use_count": {"type": "integer", "format": "int64", "description": "How often the access was resolved."}
+        }
+      },
+      "RecordList": {
+        "type": "object",
+        "properties": {
+          "records": {"type": "array", "items": {"$ref": "#/components/schemas/ListedRecord"}},
+          "next_cursor": {"type": "string", "description": "Empty on the last page."}
+        }
+      },
+      "State": {"type": "string", "enum": ["valid", "invalid", "expired", "deleted"]},
+      "V2Error": {
+        "type": "object",
+        "properties": {
+          "error": {
+            "type": "object",
+            "properties": {
+              "code": {"type": "string", "enum": ["bad_request", "unauthorized", "not_found", "invalid", "too_many_requests", "unavailable", "not_implemented", "internal"]},
+              "message": {"type": "string"}
+            }
+          }
+        }
+      },
+      "V2Access": {
+        "type": "object",
+        "properties": {
+          "access_key_id": {"type": "string"},
+          "access_grant": {"type": "string"},
+          "routes": {"type": "array", "nullable": true, "items": {"$ref": "#/components/schemas/Route"}},
+          "secret_key": {"type": "string", "description": "Missing if the secret key is derived."},
+          "public": {"type": "boolean"},
+          "labels": {"allOf": [{"$ref": "#/components/schemas/Labels"}], "nullable": true},
+          "last_used_at": {"type": "string", "format": "date-time", "nullable": true, "description": "When the access was last resolved, which is written to the database about once a minute. Null if it never was."},
+          "use_count": {"type": "integer", "format": "int64", "description": "How often the access was resolved."}
+        }
+      },
+      "V2Record": {
+        "type": "object",
+        "properties": {
+          "key_hash": {"type": "string"},
+          "satellite_address": {"type": "string"},
+          "public": {"type": "boolean"},
+          "state": {"$ref": "#/components/schemas/State"},
+          "expires_at": {"type": "string", "format": "date-time", "nullable": true},
+          "invalid_reason": {"type": "string"},
+          "deleted_at": {"type": "string", "format": "date-time", "nullable": true},
+          "labels": {"allOf": [{"$ref": "#/components/schemas/Labels"}], "nullable": true},
+          "last_used_at": {"type": "string", "format": "date-time", "nullable": true, "description": "When the access was last resolved, which is written to the database about once a minute. Null if it never was."},
+          "use_count": {"type": "integer", "format": "int64", "description": "How often the access was resolved."}
+        }
+      },
+      "V2RecordList": {
+        "type": "object",
+        "properties": {
+          "records": {"type": "array", "items": {"$ref": "#/components/schemas/V2Record"}},
+          "next_cursor": {"type": "string", "description": "Empty on the last page."}
+        }
+      },
+      "V2EventList": {
+        "type": "object",
+        "properties": {
+          "events": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "properties": {
+                "key_hash": {"type": "string"},
+                "action": {"type": "string"},
+                "at": {"type": "string", "format": "date-time"},
+                "actor": {"type": "string"},
+                "reason": {"type": "string"},
+                "source_ip": {"type": "string"}
+              }
+            }
+          },
+          "next_cursor": {"type": "string", "description": "Empty on the last page."}
+        }
+      },
+      "MigrateStats": {
+        "type": "object",
+        "properties": {
+          "copied": {"type": "integer", "format": "int64"},
+          "skipped": {"type": "integer", "format": "int64"},
+          "invalidated": {"type": "integer", "format": "int64"},
+          "deleted": {"type": "integer", "format": "int64"},
+          "verified": {"type": "integer", "format": "int64"},
+          "mismatched": {"type": "integer", "format": "int64"}
+        }
+      }
+    }
+  }
+}
+`
@@ -0,0 +1,62 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package httpauth
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"storj.io/stargate/internal/ratelimit"
+)
+
+// SetRateLimiters limits how fast requests can be made with every known auth
+// token by tokens, and from every client ip by ips. Either may be nil to not
+// limit them. Health checks aren't limited.
+func (res *Resources) SetRateLimiters(tokens, ips *ratelimit.Limiter) {
+	res.tokenLimiter = tokens
+	res.ipLimiter = ips
+}
+
+// rateLimit returns whether the request is within the rate limits, before it
+// gets to the database. If it isn't, it responds with 429 Too Many Requests.
+func (res *Resources) rateLimit(w http.ResponseWriter, req *http.Request) bool {
+	switch req.URL.Path {
+	case "/healthz", "/readyz":
+		return true
+	}
+
+	retryAfter, ok := res.rateLimitIP(req)
+	if ok && res.requestRole(req) != "" {
+		// only known tokens are tracked, so that made up ones can't take
+		// up the entries of the limiter
+		retryAfter, ok = res.tokenLimiter.Allow(strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "))
+	}
+	if ok {
+		return true
+	}
+
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, "too many requests", http.StatusTooManyRequests)
+	return false
+}
+
+// rateLimitIP limits the client ip of the request, like limiterKeys: the ips
+// of authorized callers, like the gateway, aren't limited, only those of the
+// clients that they forward as a trusted proxy.
+func (res *Resources) rateLimitIP(req *http.Request) (time.Duration, bool) {
+	if res.requestRole(req) == "" {
+		return res.ipLimiter.Allow(res.clientIP(req))
+	}
+	if ip, ok := res.proxies.ForwardedIP(req.RemoteAddr, strings.Join(req.Header["X-Forwarded-For"], ",")); ok {
+		return res.ipLimiter.Allow(ip)
+	}
+	return 0, true
+}
@@ -0,0 +1,243 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package httpauth
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"storj.io/stargate/auth"
+	"storj.io/stargate/auth/export"
+)
+
+// maxImportSize is the maximum size of the body of an import request.
+const maxImportSize = 1 << 30
+
+const (
+	// defaultListLimit is how many records are listed when the request has no
+	// limit.
+	defaultListLimit = 100
+
+	// maxListLimit is the maximum number of records in a list response.
+	maxListLimit = 1000
+
+	// maxListPages is how many pages of records a filtered list request reads
+	// at most, so that a filter that matches few records can't read the whole
+	// database in one request.
+	maxListPages = 10
+)
+
+// requestFormat returns the export format of the format query parameter,
+// which defaults to json lines.
+func requestFormat(req *http.Request) (export.Format, error) {
+	name := req.URL.Query().Get("format")
+	if name == "" {
+		return export.JSONLines, nil
+	}
+	return export.ParseFormat(name)
+}
+
+// requestLabels returns the labels of the label query parameters, which are
+// like team=storage.
+func requestLabels(req *http.Request) (map[string]string, error) {
+	values := req.URL.Query()["label"]
+	if len(values) == 0 {
+		return nil, nil
+	}
+	labels := make(map[string]string, len(values))
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 {
+			return nil, auth.LabelError.New("label %q must be like key=value", value)
+		}
+		labels[parts[0]] = parts[1]
+	}
+	return labels, nil
+}
+
+// exportRecords streams every record in the format of the export package. The
+// label query parameters select the records that have all of those labels.
+func (res *Resources) exportRecords(w http.ResponseWriter, req *http.Request) {
+	if !res.requestAllowed(req, RoleRead) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	format, err := requestFormat(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	selector, err := requestLabels(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	listing, ok := res.db.KV().(auth.ListingKV)
+	if !ok {
+		http.Error(w, "the key/value store can't list records", http.StatusNotImplemented)
+		return
+	}
+
+	var source auth.Source = listing
+	if len(selector) > 0 {
+		source = readerSource(func(ctx context.Context, fn func(context.Context, auth.Entry) error) error {
+			return listing.Iterate(ctx, func(ctx context.Context, entry auth.Entry) error {
+				if !auth.MatchLabels(entry.Record.Labels, selector) {
+					return nil
+				}
+				return fn(ctx, entry)
+			})
+		})
+	}
+
+	// the status is sent before the records, so a failure midway can only be
+	// noticed by the truncated response
+	w.Header().Set("Content-Type", format.ContentType())
+	if _, err := export.Write(req.Context(), w, source, format); err != nil {
+		zap.L().Error("unable to export records", zap.Error(err))
+	}
+}
+
+// importRecords stores every record of the body, which is in the format of the
+// export package. Records that already exist are skipped.
+func (res *Resources) importRecords(w http.ResponseWriter, req *http.Request) {
+	if !res.requestAllowed(req, RoleAdmin) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	format, err := requestFormat(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	body := http.MaxBytesReader(w, req.Body, maxImportSize)
+	source := readerSource(func(ctx context.Context, fn func(context.Context, auth.Entry) error) error {
+		return export.Read(ctx, body, format, fn)
+	})
+
+	// the body can only be read once, so the import isn't verified
+	migrator := auth.NewMigrator(zap.L(), source, res.db.KV(), auth.MigrateConfig{BatchSize: 100})
+	stats, err := migrator.Migrate(req.Context())
+	if err != nil {
+		status := http.StatusInternalServerError
+		if export.Error.Has(err) {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(stats)
+}
+
+// readerSource adapts a function to an auth.Source.
+type readerSource func(ctx context.Context, fn func(context.Context, auth.Entry) error) error
+
+// Iterate implements auth.Source.
+func (f readerSource) Iterate(ctx context.Context, fn func(context.Context, auth.Entry) error) error {
+	return f(ctx, fn)
+}
+
+// listedRecord is a record in a list response. The access key and the
+// encrypted fields are not returned, so a record is identified by its key hash.
+type listedRecord struct {
+	KeyHash          string            `json:"key_hash"`
+	SatelliteAddress string            `json:"satellite_address"`
+	Public           bool              `json:"public"`
+	ExpiresAt        *time.Time        `json:"expires_at,omitempty"`
+	InvalidReason    string            `json:"invalid_reason,omitempty"`
+	DeletedAt        *time.Time        `json:"deleted_at,omitempty"`
+	Labels           map[string]string `json:"labels,omitempty"`
+	LastUsedAt       *time.Time        `json:"last_used_at,omitempty"`
+	UseCount         int64             `json:"use_count"`
+}
+
+// listAccess returns a page of the records in the order of their key hashes,
+// including invalid and soft deleted records. The cursor query parameter is
+// the next_cursor of the previous page, and the satellite query parameter
+// selects the records of a satellite address. The last page has an empty
+// next_cursor. A filtered page may have fewer records than the limit before
+// the last one.
+func (res *Resources) listAccess(w http.ResponseWriter, req *http.Request) {
+	if !res.requestAllowed(req, RoleRead) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	query := req.URL.Query()
+
+	var after auth.KeyHash
+	if cursor := query.Get("cursor"); cursor != "" {
+		decoded, err := hex.DecodeString(cursor)
+		if err != nil || len(decoded) != len(after) {
+			http.Error(w, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+		copy(after[:], decoded)
+	}
+
+	limit := defaultListLimit
+	if value := query.Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxListLimit {
+			http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxListLimit), http.StatusBadRequest)
+			return
+		}
+	}
+	satellite := query.Get("satellite")
+
+	response := struct {
+		Records    []listedRecord `json:"records"`
+		NextCursor string         `json:"next_cursor"`
+	}{Records: []listedRecord{}}
+
+	for pages := 0; pages < maxListPages && len(response.Records) < limit; pages++ {
+		requested := limit - len(response.Records)
+		entries, err := auth.List(req.Context(), res.db.KV(), after, requested)
+		if err != nil {
+			databaseError(w, err, err.Error())
+			return
+		}
+
+		for _, entry := range entries {
+			if satellite != "" && entry.Record.SatelliteAddress != satellite {
+				continue
+			}
+			response.Records = append(response.Records, listedRecord{
+				KeyHash:          hex.EncodeToString(entry.KeyHash[:]),
+				SatelliteAddress: entry.Record.SatelliteAddress,
+				Public:           entry.Record.Public,
+				ExpiresAt:        entry.Record.ExpiresAt,
+				InvalidReason:    entry.InvalidReason,
+				DeletedAt:        entry.DeletedAt,
+				Labels:           entry.Record.Labels,
+				LastUsedAt:       entry.Record.LastUsedAt,
+				UseCount:         entry.Record.UseCount,
+			})
+		}
+
+		if len(entries) < requested {
+			response.NextCursor = ""
+			break
+		}
+		after = entries[len(entries)-1].KeyHash
+		response.NextCursor = hex.EncodeToString(after[:])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(response)
+}
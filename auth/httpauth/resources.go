@@ -6,54 +6,177 @@ package httpauth
 import (
 	"crypto/rand"
 	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/btcsuite/btcutil/base58"
+	"go.uber.org/zap"
 
 	"storj.io/stargate/auth"
+	"storj.io/stargate/internal/bruteforce"
+	"storj.io/stargate/internal/ratelimit"
+	"storj.io/stargate/internal/trustedproxy"
 )
 
+// maxBatchSize is the maximum number of access grants in a single batch request.
+const maxBatchSize = 10000
+
+// actorHeader names who makes an authorized request, like the operator behind
+// an admin tool, for the history of the accesses that the request changes.
+const actorHeader = "X-Actor"
+
 // Resources wrap a database and expose methods over HTTP.
 type Resources struct {
-	db        *auth.Database
-	endpoint  string
-	authToken string
+	db       *auth.Database
+	endpoint string
+	limiter  *bruteforce.Limiter
+	log      *zap.Logger
+
+	tokenLimiter *ratelimit.Limiter
+	ipLimiter    *ratelimit.Limiter
+
+	tokensMu      sync.RWMutex
+	tokens        Tokens
+	previous      Tokens
+	previousUntil time.Time
+
+	requireClientCert bool
+	separateAdmin     bool
+	cors              *corsPolicy
+	proxies           *trustedproxy.Proxies
+	idempotencySecret []byte
 
 	handler http.Handler
 	id      *Arg
+	head    *Arg
 }
 
-// New constructs Resources for some database.
-func New(db *auth.Database, endpoint, authToken string) *Resources {
+// New constructs Resources for some database. Requests are authorized by the
+// roles of tokens. Failed access key lookups are counted by limiter, which may
+// be nil to disable brute-force protection.
+func New(db *auth.Database, endpoint string, tokens Tokens, limiter *bruteforce.Limiter) *Resources {
 	res := &Resources{
-		db:        db,
-		endpoint:  endpoint,
-		authToken: authToken,
+		db:       db,
+		endpoint: endpoint,
+		tokens:   tokens,
+		limiter:  limiter,
 
-		id: new(Arg),
+		id:   new(Arg),
+		head: new(Arg),
+	}
+
+	secret := make([]byte, minIdempotencySecretSize)
+	if _, err := rand.Read(secret); err == nil {
+		res.idempotencySecret = secret
 	}
 
 	res.handler = Dir{
+		"/healthz": Dir{
+			"": Method{
+				"GET": http.HandlerFunc(res.healthz),
+			},
+		},
+		"/readyz": Dir{
+			"": Method{
+				"GET": http.HandlerFunc(res.readyz),
+			},
+		},
 		"/v1": Dir{
+			"/openapi.json": Dir{
+				"": Method{
+					"GET": http.HandlerFunc(res.getOpenAPI),
+				},
+			},
+			"/metrics": Dir{
+				"": Method{
+					"GET": res.admin(http.HandlerFunc(res.getMetrics)),
+				},
+			},
+			"/records": Dir{
+				"": Method{
+					"GET":  res.admin(http.HandlerFunc(res.exportRecords)),
+					"POST": res.admin(res.destructive(res.importRecords)),
+				},
+			},
 			"/access": Dir{
 				"": Method{
 					"POST": http.HandlerFunc(res.newAccess),
 				},
+				"/batch": Dir{
+					"": Method{
+						"POST": http.HandlerFunc(res.newAccessBatch),
+					},
+				},
 				"*": res.id.Capture(Dir{
 					"": Method{
 						"GET":    http.HandlerFunc(res.getAccess),
-						"DELETE": http.HandlerFunc(res.deleteAccess),
+						"DELETE": res.admin(res.destructive(res.deleteAccess)),
+					},
+					"/invalid": Dir{
+						"": Method{
+							"PUT": res.admin(res.destructive(res.invalidateAccess)),
+						},
+					},
+					"/restore": Dir{
+						"": Method{
+							"POST": res.admin(http.HandlerFunc(res.restoreAccess)),
+						},
+					},
+					"/history": Dir{
+						"": Method{
+							"GET": res.admin(http.HandlerFunc(res.getHistory)),
+						},
+					},
+					"/passphrase": Dir{
+						"": Method{
+							"POST": http.HandlerFunc(res.derivePassphraseAccess),
+						},
+					},
+				}),
+			},
+			"/admin": Dir{
+				"/access": Dir{
+					"": Method{
+						"GET": res.admin(http.HandlerFunc(res.listAccess)),
 					},
+				},
+			},
+			"/macaroon": Dir{
+				"*": res.head.Capture(Dir{
 					"/invalid": Dir{
 						"": Method{
-							"PUT": http.HandlerFunc(res.invalidateAccess),
+							"PUT": res.admin(res.destructive(res.invalidateMacaroonHead)),
 						},
 					},
 				}),
 			},
 		},
+		"/v2": Dir{
+			"/access": Dir{
+				"": Method{
+					"GET": res.admin(http.HandlerFunc(res.listAccessV2)),
+				},
+				"*": res.id.Capture(Dir{
+					"": Method{
+						"GET": http.HandlerFunc(res.getAccessV2),
+					},
+				}),
+			},
+			"/history": Dir{
+				"": Method{
+					"GET": res.admin(http.HandlerFunc(res.listHistoryV2)),
+				},
+			},
+		},
 	}
 
 	return res
@@ -61,30 +184,111 @@ func New(db *auth.Database, endpoint, authToken string) *Resources {
 
 // ServeHTTP makes Resources an http.Handler.
 func (res *Resources) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w, req, done := res.logRequest(w, req)
+	defer done()
+
+	if !res.handleCORS(w, req) {
+		return
+	}
+	if !res.rateLimit(w, req) {
+		return
+	}
 	res.handler.ServeHTTP(w, req)
 }
 
+// newAccess registers an access. Requests with an Idempotency-Key header are
+// registered once: retrying them returns the same access.
 func (res *Resources) newAccess(w http.ResponseWriter, req *http.Request) {
 	var request struct {
-		AccessGrant string `json:"access_grant"`
-		Public      bool   `json:"public"`
+		AccessGrant string            `json:"access_grant"`
+		Routes      []auth.Route      `json:"routes"`
+		Public      bool              `json:"public"`
+		ExpiresAt   *time.Time        `json:"expires_at"`
+		Labels      map[string]string `json:"labels"`
 	}
 
-	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := decodeJSON(w, req, maxRequestSize, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := auth.ValidateAccessGrant(request.AccessGrant); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if expired(request.ExpiresAt) {
+		http.Error(w, "expires_at must be in the future", http.StatusBadRequest)
+		return
+	}
+	if err := auth.ValidateRoutes(request.Routes); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := auth.ValidateLabels(request.Labels); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	var key auth.EncryptionKey
-	if _, err := rand.Read(key[:]); err != nil {
+	var secretKey []byte
+	if idempotencyKey := req.Header.Get(idempotencyHeader); idempotencyKey != "" {
+		if len(idempotencyKey) > maxIdempotencyKeyLength {
+			http.Error(w, fmt.Sprintf("%s is too long: the maximum is %d", idempotencyHeader, maxIdempotencyKeyLength), http.StatusBadRequest)
+			return
+		}
+
+		if res.idempotencySecret == nil {
+			http.Error(w, idempotencyHeader+" is not supported without a secret", http.StatusNotImplemented)
+			return
+		}
+
+		// a retry returns the access that was registered before
+		key = idempotentKey(res.idempotencySecret, request.AccessGrant, idempotencyKey)
+		registered, err := res.db.Lookup(req.Context(), key)
+		switch {
+		case err == nil:
+			// the registered access expires with the caveats of the access
+			// grant, which the requested expiration is compared with too
+			expiresAt, err := auth.Expiration(request.AccessGrant, request.ExpiresAt)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if !sameRegistration(registered, request.Routes, request.Public, expiresAt, request.Labels) {
+				http.Error(w, idempotencyHeader+" was used for a different registration", http.StatusUnprocessableEntity)
+				return
+			}
+			secretKey = registered.SecretKey
+		case auth.Invalid.Has(err):
+			http.Error(w, "the access of the "+idempotencyHeader+" is no longer valid", http.StatusConflict)
+			return
+		case !auth.NotFound.Has(err):
+			databaseError(w, err, "error looking up request in database")
+			return
+		}
+	} else if _, err := rand.Read(key[:]); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	secretKey, err := res.db.Put(req.Context(), key, request.AccessGrant, request.Public)
-	if err != nil {
-		http.Error(w, "error storing request in database", http.StatusInternalServerError)
-		return
+	if secretKey == nil {
+		var err error
+		secretKey, err = res.db.PutOwned(req.Context(), res.requestOwner(req), key,
+			request.AccessGrant, request.Routes, request.Public, request.ExpiresAt, request.Labels)
+		if err != nil {
+			// the access of a retry may have been deleted since it was
+			// registered, which Get doesn't tell apart from a missing one
+			if req.Header.Get(idempotencyHeader) != "" {
+				if deleted, deletedErr := res.db.Deleted(req.Context(), key); deletedErr == nil && deleted {
+					http.Error(w, "the access of the "+idempotencyHeader+" was deleted", http.StatusConflict)
+					return
+				}
+			}
+			databaseError(w, err, "error storing request in database")
+			return
+		}
+		if !res.appendHistory(w, req, key, auth.HistoryCreated, "") {
+			return
+		}
 	}
 
 	var response struct {
@@ -101,79 +305,349 @@ func (res *Resources) newAccess(w http.ResponseWriter, req *http.Request) {
 	_ = json.NewEncoder(w).Encode(response)
 }
 
-func (res *Resources) requestAuthorized(req *http.Request) bool {
-	auth := req.Header.Get("Authorization")
-	return subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+res.authToken)) == 1
-}
-
-func (res *Resources) getAccess(w http.ResponseWriter, req *http.Request) {
-	if !res.requestAuthorized(req) {
+// newAccessBatch registers many accesses at once. By default the batch is
+// stored either completely or not at all. When the request is partial, the
+// accesses that are invalid are reported in the results with their errors,
+// and the others are stored anyway.
+func (res *Resources) newAccessBatch(w http.ResponseWriter, req *http.Request) {
+	if !res.requestAllowed(req, RoleRegister) {
 		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	encryptionKeyBytes, version, err := base58.CheckDecode(res.id.Value(req.Context()))
-	if err != nil {
+	var request struct {
+		Accesses []struct {
+			AccessGrant string            `json:"access_grant"`
+			Routes      []auth.Route      `json:"routes"`
+			Public      bool              `json:"public"`
+			ExpiresAt   *time.Time        `json:"expires_at"`
+			Labels      map[string]string `json:"labels"`
+		} `json:"accesses"`
+		Partial bool `json:"partial"`
+	}
+
+	if err := decodeJSON(w, req, maxBatchRequestSize, &request); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if len(encryptionKeyBytes) != len(auth.EncryptionKey{}) {
-		http.Error(w, "invalid access key id length", http.StatusBadRequest)
+	if len(request.Accesses) > maxBatchSize {
+		http.Error(w, fmt.Sprintf("too many accesses: the maximum is %d", maxBatchSize), http.StatusBadRequest)
 		return
 	}
-	if version != auth.VersionAccessKeyID {
-		http.Error(w, "unexpected decoded version", http.StatusBadRequest)
+
+	// failures are the errors of the accesses that aren't stored in a partial
+	// batch, indexed like the request
+	failures := make([]error, len(request.Accesses))
+
+	var putRequests []auth.PutRequest
+	var indexes []int
+	owner := res.requestOwner(req)
+	for i, access := range request.Accesses {
+		if err := validateAccess(access.AccessGrant, access.ExpiresAt, access.Routes, access.Labels); err != nil {
+			if !request.Partial {
+				http.Error(w, fmt.Sprintf("access %d: %v", i, err), http.StatusBadRequest)
+				return
+			}
+			failures[i] = err
+			continue
+		}
+
+		putRequest := auth.PutRequest{
+			AccessGrant: access.AccessGrant,
+			Routes:      access.Routes,
+			Public:      access.Public,
+			ExpiresAt:   access.ExpiresAt,
+			Labels:      access.Labels,
+			Owner:       owner,
+		}
+		if _, err := rand.Read(putRequest.Key[:]); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		putRequests = append(putRequests, putRequest)
+		indexes = append(indexes, i)
+	}
+
+	var secretKeys [][]byte
+	var err error
+	if request.Partial {
+		var putErrors []error
+		secretKeys, putErrors, err = res.db.PutBatchPartial(req.Context(), putRequests)
+		for k, putErr := range putErrors {
+			failures[indexes[k]] = putErr
+		}
+	} else {
+		secretKeys, err = res.db.PutBatch(req.Context(), putRequests)
+	}
+	if err != nil {
+		databaseError(w, err, "error storing request in database")
 		return
 	}
+	for k, putRequest := range putRequests {
+		if failures[indexes[k]] != nil {
+			continue
+		}
+		if !res.appendHistory(w, req, putRequest.Key, auth.HistoryCreated, "") {
+			return
+		}
+	}
 
-	var key auth.EncryptionKey
-	copy(key[:], encryptionKeyBytes)
+	type accessResponse struct {
+		AccessKeyID string `json:"access_key_id,omitempty"`
+		SecretKey   string `json:"secret_key,omitempty"`
+		Endpoint    string `json:"endpoint,omitempty"`
+		Error       string `json:"error,omitempty"`
+	}
+
+	var response struct {
+		Accesses []accessResponse `json:"accesses"`
+	}
+
+	response.Accesses = make([]accessResponse, len(request.Accesses))
+	for i, failure := range failures {
+		if failure != nil {
+			response.Accesses[i].Error = failure.Error()
+		}
+	}
+	for k, putRequest := range putRequests {
+		if secretKeys[k] == nil {
+			continue
+		}
+		response.Accesses[indexes[k]] = accessResponse{
+			AccessKeyID: base58.CheckEncode(putRequest.Key[:], auth.VersionAccessKeyID),
+			SecretKey:   base58.CheckEncode(secretKeys[k], auth.VersionSecretKey),
+			Endpoint:    res.endpoint,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+// validateAccess returns why an access of a batch can't be registered.
+func validateAccess(accessGrant string, expiresAt *time.Time, routes []auth.Route, labels map[string]string) error {
+	if err := auth.ValidateAccessGrant(accessGrant); err != nil {
+		return err
+	}
+	if expired(expiresAt) {
+		return errors.New("expires_at must be in the future")
+	}
+	if err := auth.ValidateRoutes(routes); err != nil {
+		return err
+	}
+	return auth.ValidateLabels(labels)
+}
+
+// expired returns whether an optional expiration has already passed.
+func expired(expiresAt *time.Time) bool {
+	return expiresAt != nil && !time.Now().Before(*expiresAt)
+}
+
+// lookupFailed records a failed access key lookup for the limiter keys. The
+// responses to unauthorized requests are delayed by the tarpit of the limiter,
+// so that guessing access keys is slow even before the client is banned.
+// Authorized callers, like the gateway, aren't delayed, since that would hold
+// up the caller for every one of its clients instead of the one guessing.
+func (res *Resources) lookupFailed(req *http.Request, keys []string) {
+	res.limiter.Failure(keys...)
+	if res.requestRole(req) != "" {
+		return
+	}
+
+	if tarpit := res.limiter.Tarpit(); tarpit > 0 {
+		timer := time.NewTimer(tarpit)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+		}
+	}
+}
+
+// SetTrustedProxies makes the X-Forwarded-For addresses that the proxies add
+// the ips of clients, for rate limits, quotas and history. Without trusted
+// proxies, the ips of clients are the remote addresses of their connections.
+func (res *Resources) SetTrustedProxies(proxies *trustedproxy.Proxies) {
+	res.proxies = proxies
+}
+
+// clientIP returns the ip of the client that the request is made for.
+func (res *Resources) clientIP(req *http.Request) string {
+	return res.proxies.ClientIP(req.RemoteAddr, strings.Join(req.Header["X-Forwarded-For"], ","))
+}
+
+// limiterKeys returns the keys that failed lookups of the access key are
+// counted for by the limiter. Authorized callers, like the gateway, look up
+// access keys for their own clients, so their ips are never banned; the
+// clients that they forward as a trusted proxy are counted instead.
+func (res *Resources) limiterKeys(req *http.Request, accessKeyID string) []string {
+	keys := []string{"key:" + accessKeyID}
+	if res.requestRole(req) == "" {
+		return append(keys, "ip:"+res.clientIP(req))
+	}
+	if ip, ok := res.proxies.ForwardedIP(req.RemoteAddr, strings.Join(req.Header["X-Forwarded-For"], ",")); ok {
+		keys = append(keys, "ip:"+ip)
+	}
+	return keys
+}
+
+// appendHistory adds the action of the request to the history of the access.
+// If that fails, it responds with an error and returns false.
+func (res *Resources) appendHistory(w http.ResponseWriter, req *http.Request, key auth.EncryptionKey, action, reason string) bool {
+	event := auth.HistoryEvent{
+		Action:   action,
+		Reason:   reason,
+		SourceIP: res.clientIP(req),
+	}
+	if role := res.requestRole(req); role != "" {
+		event.Actor = req.Header.Get(actorHeader)
+		if event.Actor == "" {
+			event.Actor = clientCertificateName(req)
+		}
+		if event.Actor == "" {
+			event.Actor = string(role)
+		}
+	}
+
+	if err := res.db.AppendHistory(req.Context(), key, event); err != nil {
+		databaseError(w, err, "error storing history in database")
+		return false
+	}
+	return true
+}
+
+// databaseError responds with the message for an error of the database. If the
+// database is unavailable, it asks the client to retry later instead, what
+// the database can't do is not implemented, and registrations beyond the
+// quota of their owner are forbidden.
+func databaseError(w http.ResponseWriter, err error, message string) {
+	if auth.QuotaExceeded.Has(err) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	var unavailable *auth.UnavailableError
+	if errors.As(err, &unavailable) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(unavailable.RetryAfter.Seconds()))))
+		http.Error(w, "database unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if auth.Unsupported.Has(err) {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	http.Error(w, message, http.StatusInternalServerError)
+}
+
+func (res *Resources) getAccess(w http.ResponseWriter, req *http.Request) {
+	if !res.requestAllowed(req, RoleRead) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	accessKeyID := res.id.Value(req.Context())
+	limiterKeys := res.limiterKeys(req, accessKeyID)
+	if retryAfter, ok := res.limiter.Allowed(limiterKeys...); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		http.Error(w, "too many failed attempts", http.StatusTooManyRequests)
+		return
+	}
 
-	accessGrant, public, secretKey, err := res.db.Get(req.Context(), key)
+	key, err := parseAccessKeyID(accessKeyID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		res.lookupFailed(req, limiterKeys)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	access, err := res.db.Resolve(req.Context(), key)
+	if err != nil {
+		if auth.NotFound.Has(err) || auth.Invalid.Has(err) {
+			res.lookupFailed(req, limiterKeys)
+		}
+		databaseError(w, err, err.Error())
 		return
 	}
 
 	var response struct {
-		AccessGrant string `json:"access_grant"`
-		SecretKey   string `json:"secret_key"`
-		Public      bool   `json:"public"`
+		AccessGrant string            `json:"access_grant"`
+		Routes      []auth.Route      `json:"routes,omitempty"`
+		SecretKey   string            `json:"secret_key,omitempty"`
+		Public      bool              `json:"public"`
+		Labels      map[string]string `json:"labels,omitempty"`
+		LastUsedAt  *time.Time        `json:"last_used_at,omitempty"`
+		UseCount    int64             `json:"use_count"`
 	}
 
-	response.AccessGrant = accessGrant
-	response.SecretKey = base58.CheckEncode(secretKey, auth.VersionSecretKey)
-	response.Public = public
+	response.AccessGrant = access.AccessGrant
+	response.Routes = access.Routes
+	response.SecretKey = res.resolvedSecretKey(access)
+	response.Public = access.Public
+	response.Labels = access.Labels
+	response.LastUsedAt = access.LastUsedAt
+	response.UseCount = access.UseCount
 
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(response)
 }
 
+// resolvedSecretKey returns the secret key to respond to resolves of access
+// with. Derived secret keys are left out, so that the tokens that resolve
+// accesses don't give out the secret keys that signatures are checked with.
+func (res *Resources) resolvedSecretKey(access *auth.Access) string {
+	if access.SecretKeyHashed || res.db.DerivesSecretKeys() {
+		return ""
+	}
+	return base58.CheckEncode(access.SecretKey, auth.VersionSecretKey)
+}
+
+// deleteAccess soft deletes the access, so that it can be restored until the
+// sweeper purges it after the grace period.
 func (res *Resources) deleteAccess(w http.ResponseWriter, req *http.Request) {
-	if !res.requestAuthorized(req) {
+	if !res.requestAllowed(req, RoleAdmin) {
 		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	encryptionKeyBytes, version, err := base58.CheckDecode(res.id.Value(req.Context()))
+	key, err := parseAccessKeyID(res.id.Value(req.Context()))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if len(encryptionKeyBytes) != len(auth.EncryptionKey{}) {
-		http.Error(w, "invalid access key id length", http.StatusBadRequest)
+
+	if err := res.db.SoftDelete(req.Context(), key); err != nil {
+		databaseError(w, err, err.Error())
 		return
 	}
-	if version != auth.VersionAccessKeyID {
-		http.Error(w, "unexpected decoded version", http.StatusBadRequest)
+	if !res.appendHistory(w, req, key, auth.HistoryDeleted, "") {
 		return
 	}
 
-	var key auth.EncryptionKey
-	copy(key[:], encryptionKeyBytes)
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = io.WriteString(w, "{}")
+}
 
-	if err := res.db.Delete(req.Context(), key); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+// restoreAccess undoes the deletion of an access that hasn't been purged yet.
+func (res *Resources) restoreAccess(w http.ResponseWriter, req *http.Request) {
+	if !res.requestAllowed(req, RoleAdmin) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	key, err := parseAccessKeyID(res.id.Value(req.Context()))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	restored, err := res.db.Restore(req.Context(), key)
+	if err != nil {
+		databaseError(w, err, err.Error())
+		return
+	} else if !restored {
+		http.Error(w, "no deleted access to restore", http.StatusNotFound)
+		return
+	}
+	if !res.appendHistory(w, req, key, auth.HistoryRestored, "") {
 		return
 	}
 
@@ -182,42 +656,223 @@ func (res *Resources) deleteAccess(w http.ResponseWriter, req *http.Request) {
 }
 
 func (res *Resources) invalidateAccess(w http.ResponseWriter, req *http.Request) {
-	if !res.requestAuthorized(req) {
+	if !res.requestAllowed(req, RoleAdmin) {
 		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	encryptionKeyBytes, version, err := base58.CheckDecode(res.id.Value(req.Context()))
+	key, err := parseAccessKeyID(res.id.Value(req.Context()))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if len(encryptionKeyBytes) != len(auth.EncryptionKey{}) {
-		http.Error(w, "invalid access key id length", http.StatusBadRequest)
+
+	var request struct {
+		Reason string `json:"reason"`
+	}
+
+	if err := decodeJSON(w, req, maxRequestSize, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	invalidated, err := res.db.Invalidate(req.Context(), key, request.Reason)
+	if err != nil {
+		databaseError(w, err, err.Error())
 		return
 	}
-	if version != auth.VersionAccessKeyID {
-		http.Error(w, "unexpected decoded version", http.StatusBadRequest)
+	// invalidating again is not an event in the history
+	if invalidated && !res.appendHistory(w, req, key, auth.HistoryInvalidated, request.Reason) {
 		return
 	}
 
-	var key auth.EncryptionKey
-	copy(key[:], encryptionKeyBytes)
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = io.WriteString(w, "{}")
+}
+
+// getHistory returns the events in the history of the access, so that the
+// lifecycle of a credential can be reviewed. Histories outlive their accesses.
+func (res *Resources) getHistory(w http.ResponseWriter, req *http.Request) {
+	if !res.requestAllowed(req, RoleRead) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	key, err := parseAccessKeyID(res.id.Value(req.Context()))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var response struct {
+		Events []auth.HistoryEvent `json:"events"`
+	}
+
+	response.Events, err = res.db.History(req.Context(), key)
+	if err != nil {
+		databaseError(w, err, err.Error())
+		return
+	}
+	if response.Events == nil {
+		response.Events = []auth.HistoryEvent{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+// derivedOwner returns the owner of the accesses that the request derives from
+// the access of key. Requests with an auth token derive them for the token,
+// and requests that authenticate with the secret key of the access derive
+// them for the owner of the access, so that deriving doesn't register more
+// accesses than its owner could. Accesses without an owner, like ones that
+// admin tokens registered, derive them for the client ip of the request.
+func (res *Resources) derivedOwner(req *http.Request, key auth.EncryptionKey) (string, error) {
+	if res.requestRole(req) != "" {
+		return res.requestOwner(req), nil
+	}
+	owner, err := res.db.Owner(req.Context(), key)
+	if err != nil || owner != "" {
+		return owner, err
+	}
+	return res.requestOwner(req), nil
+}
+
+// derivePassphraseAccess registers a new access for a prefix of the access,
+// whose objects are encrypted with a key derived from a passphrase. Besides
+// authorized requests, it accepts requests with the secret key of the access,
+// so that its owner can make encryption domains per folder.
+func (res *Resources) derivePassphraseAccess(w http.ResponseWriter, req *http.Request) {
+	accessKeyID := res.id.Value(req.Context())
+	limiterKeys := res.limiterKeys(req, accessKeyID)
+	if retryAfter, ok := res.limiter.Allowed(limiterKeys...); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		http.Error(w, "too many failed attempts", http.StatusTooManyRequests)
+		return
+	}
+
+	key, err := parseAccessKeyID(accessKeyID)
+	if err != nil {
+		res.lookupFailed(req, limiterKeys)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	var request struct {
-		Reason string `json:"reason"`
+		SecretKey  string     `json:"secret_key"`
+		Bucket     string     `json:"bucket"`
+		Prefix     string     `json:"prefix"`
+		Passphrase string     `json:"passphrase"`
+		Salt       string     `json:"salt"`
+		Public     bool       `json:"public"`
+		ExpiresAt  *time.Time `json:"expires_at"`
 	}
 
-	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := decodeJSON(w, req, maxRequestSize, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if expired(request.ExpiresAt) {
+		http.Error(w, "expires_at must be in the future", http.StatusBadRequest)
 		return
 	}
 
-	if err := res.db.Invalidate(req.Context(), key, request.Reason); err != nil {
+	accessGrant, _, _, labels, secretKey, err := res.db.Get(req.Context(), key)
+	if err != nil {
+		if auth.NotFound.Has(err) || auth.Invalid.Has(err) {
+			res.lookupFailed(req, limiterKeys)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		databaseError(w, err, err.Error())
+		return
+	}
+	if !res.requestAllowed(req, RoleRead) {
+		expected := base58.CheckEncode(secretKey, auth.VersionSecretKey)
+		if subtle.ConstantTimeCompare([]byte(request.SecretKey), []byte(expected)) != 1 {
+			res.lookupFailed(req, limiterKeys)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	derived, err := auth.DerivePassphraseAccess(accessGrant, request.Bucket, request.Prefix, request.Passphrase, []byte(request.Salt))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var derivedKey auth.EncryptionKey
+	if _, err := rand.Read(derivedKey[:]); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	owner, err := res.derivedOwner(req, key)
+	if err != nil {
+		databaseError(w, err, err.Error())
+		return
+	}
+
+	// the derived access has the labels of the access it was derived from
+	derivedSecretKey, err := res.db.PutOwned(req.Context(), owner, derivedKey, derived, nil, request.Public, request.ExpiresAt, labels)
+	if err != nil {
+		databaseError(w, err, "error storing request in database")
+		return
+	}
+	if !res.appendHistory(w, req, derivedKey, auth.HistoryCreated, "derived from "+accessKeyID) {
+		return
+	}
+
+	var response struct {
+		AccessKeyID string `json:"access_key_id"`
+		SecretKey   string `json:"secret_key"`
+		Endpoint    string `json:"endpoint"`
+	}
+
+	response.AccessKeyID = base58.CheckEncode(derivedKey[:], auth.VersionAccessKeyID)
+	response.SecretKey = base58.CheckEncode(derivedSecretKey, auth.VersionSecretKey)
+	response.Endpoint = res.endpoint
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+// invalidateMacaroonHead invalidates every access whose api key has the hex
+// encoded macaroon head, so that revoking an api key on the satellite can
+// revoke every access key derived from it at once.
+func (res *Resources) invalidateMacaroonHead(w http.ResponseWriter, req *http.Request) {
+	if !res.requestAllowed(req, RoleAdmin) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	head, err := hex.DecodeString(res.head.Value(req.Context()))
+	if err != nil || len(head) == 0 {
+		http.Error(w, "invalid macaroon head", http.StatusBadRequest)
+		return
+	}
+
+	var request struct {
+		Reason string `json:"reason"`
+	}
+
+	if err := decodeJSON(w, req, maxRequestSize, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	invalidated, err := res.db.InvalidateByMacaroonHead(req.Context(), head, request.Reason)
+	if err != nil {
+		databaseError(w, err, err.Error())
+		return
+	}
+
+	var response struct {
+		Invalidated int64 `json:"invalidated"`
+	}
+	response.Invalidated = invalidated
+
 	w.Header().Set("Content-Type", "application/json")
-	_, _ = io.WriteString(w, "{}")
+	_ = json.NewEncoder(w).Encode(response)
 }
@@ -4,46 +4,84 @@
 package httpauth
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/btcsuite/btcutil/base58"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
 
+	"storj.io/common/pb"
 	"storj.io/stargate/auth"
 	"storj.io/stargate/auth/memauth"
+	"storj.io/stargate/internal/bruteforce"
+	"storj.io/stargate/internal/ratelimit"
+	"storj.io/stargate/internal/trustedproxy"
+	"storj.io/uplink"
 )
 
 const minimalAccess = "138CV9Drxrw8ir1XpxcZhk2wnHjhzVjuSZe6yDsNiMZDP8cow9V6sHDYdwgvYoQGgqVvoMnxdWDbpBiEPW5oP7DtPJ5sZx2MVxFrUoZYFfVAgxidW"
 
+// keyedAccess is minimalAccess with a default encryption key, which accesses
+// derived with passphrases need.
+const keyedAccess = "158UtbHepmd91LJ69RLkjN8YHXzTGL1mXfqsrhabjxCbCUXterk9ECv1LqxNQhr5g8Vas6cfz8wFRRjLnwzuwKVHB9Xez6MzFQR2mdCT9cRDL8fDvpXo9b36b7acPrARioni6VAgW3uxyrxMNNpK88hAxn8ozLnUGC"
+
 func TestResources_URLs(t *testing.T) {
 	check := func(method, path string) bool {
 		rec := httptest.NewRecorder()
 		req := httptest.NewRequest(method, path, nil)
 		req.Header.Set("Authorization", "Bearer authToken")
-		New(nil, "endpoint", "authToken").ServeHTTP(rec, req)
+		New(nil, "endpoint", Tokens{"authToken": RoleAdmin}, nil).ServeHTTP(rec, req)
 		return rec.Code != http.StatusNotFound && rec.Code != http.StatusMethodNotAllowed
 	}
 
 	// check valid paths
 	require.True(t, check("POST", "/v1/access"))
+	require.True(t, check("POST", "/v1/access/batch"))
 	require.True(t, check("GET", "/v1/access/someid"))
 	require.True(t, check("PUT", "/v1/access/someid/invalid"))
 	require.True(t, check("DELETE", "/v1/access/someid"))
+	require.True(t, check("POST", "/v1/access/someid/restore"))
+	require.True(t, check("GET", "/v1/access/someid/history"))
+	require.True(t, check("POST", "/v1/access/someid/passphrase"))
+	require.True(t, check("PUT", "/v1/macaroon/somehead/invalid"))
+	require.True(t, check("GET", "/healthz"))
 
 	// check invalid methods
 	require.False(t, check("PATCH", "/v1/access"))
 	require.False(t, check("PATCH", "/v1/access/someid"))
 	require.False(t, check("PATCH", "/v1/access/someid/invalid"))
+	require.False(t, check("GET", "/v1/access/someid/restore"))
+	require.False(t, check("POST", "/v1/access/someid/history"))
+	require.False(t, check("GET", "/v1/access/someid/passphrase"))
+	require.False(t, check("GET", "/v1/macaroon/somehead/invalid"))
 
 	// check suffix doesn't match
 	require.False(t, check("POST", "/v1/access/extra"))
+	require.False(t, check("POST", "/v1/access/batch/extra"))
 	require.False(t, check("GET", "/v1/access/someid/extra"))
 	require.False(t, check("PUT", "/v1/access/someid/invalid/extra"))
 	require.False(t, check("DELETE", "/v1/access/someid/extra"))
+	require.False(t, check("POST", "/v1/access/someid/restore/extra"))
+	require.False(t, check("PUT", "/v1/macaroon/somehead"))
+	require.False(t, check("PUT", "/v1/macaroon/somehead/invalid/extra"))
 
 	// check misspelling doesn't match
 	require.False(t, check("POST", "/v1/access_"))
@@ -59,6 +97,36 @@ func TestResources_URLs(t *testing.T) {
 	require.False(t, check("DELETE", "/v1/access/someid/"))
 }
 
+func TestResources_OpenAPI(t *testing.T) {
+	res := New(auth.NewDatabase(memauth.New()), "endpoint", Tokens{"authToken": RoleAdmin}, nil)
+
+	rec := httptest.NewRecorder()
+	res.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/openapi.json", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &spec))
+	require.NotEmpty(t, spec.Paths)
+
+	// every documented route is served
+	for path, operations := range spec.Paths {
+		for method := range operations {
+			if method == "parameters" {
+				continue
+			}
+			concrete := strings.NewReplacer("{accessKeyId}", "someid", "{head}", "somehead").Replace(path)
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(strings.ToUpper(method), concrete, nil)
+			req.Header.Set("Authorization", "Bearer authToken")
+			res.ServeHTTP(rec, req)
+			require.NotEqual(t, http.StatusNotFound, rec.Code, "%s %s", method, path)
+			require.NotEqual(t, http.StatusMethodNotAllowed, rec.Code, "%s %s", method, path)
+		}
+	}
+}
+
 func TestResources_CRUD(t *testing.T) {
 	exec := func(res http.Handler, method, path, body string) (map[string]interface{}, bool) {
 		rec := httptest.NewRecorder()
@@ -74,7 +142,7 @@ func TestResources_CRUD(t *testing.T) {
 	}
 
 	t.Run("CRUD", func(t *testing.T) {
-		res := New(auth.NewDatabase(memauth.New()), "endpoint", "authToken")
+		res := New(auth.NewDatabase(memauth.New()), "endpoint", Tokens{"authToken": RoleAdmin}, nil)
 
 		// create an access
 		createRequest := fmt.Sprintf(`{"access_grant": %q}`, minimalAccess)
@@ -99,8 +167,72 @@ func TestResources_CRUD(t *testing.T) {
 		require.False(t, ok)
 	})
 
+	t.Run("Restore", func(t *testing.T) {
+		res := New(auth.NewDatabase(memauth.New()), "endpoint", Tokens{"authToken": RoleAdmin}, nil)
+
+		createRequest := fmt.Sprintf(`{"access_grant": %q}`, minimalAccess)
+		createResult, ok := exec(res, "POST", "/v1/access", createRequest)
+		require.True(t, ok)
+		url := fmt.Sprintf("/v1/access/%s", createResult["access_key_id"])
+
+		// only deleted accesses can be restored
+		_, ok = exec(res, "POST", url+"/restore", ``)
+		require.False(t, ok)
+
+		_, ok = exec(res, "DELETE", url, ``)
+		require.True(t, ok)
+		_, ok = exec(res, "GET", url, ``)
+		require.False(t, ok)
+
+		// a restored access can be retrieved again
+		restoreResult, ok := exec(res, "POST", url+"/restore", ``)
+		require.True(t, ok)
+		require.Equal(t, map[string]interface{}{}, restoreResult)
+
+		fetchResult, ok := exec(res, "GET", url, ``)
+		require.True(t, ok)
+		require.Equal(t, minimalAccess, fetchResult["access_grant"])
+		require.Equal(t, createResult["secret_key"], fetchResult["secret_key"])
+	})
+
+	t.Run("History", func(t *testing.T) {
+		res := New(auth.NewDatabase(memauth.New()), "endpoint", Tokens{"authToken": RoleAdmin}, nil)
+		trustTestProxy(t, res)
+
+		createRequest := fmt.Sprintf(`{"access_grant": %q}`, minimalAccess)
+		createResult, ok := exec(res, "POST", "/v1/access", createRequest)
+		require.True(t, ok)
+		url := fmt.Sprintf("/v1/access/%s", createResult["access_key_id"])
+
+		_, ok = exec(res, "PUT", url+"/invalid", `{"reason": "leaked"}`)
+		require.True(t, ok)
+
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("DELETE", url, nil)
+		req.Header.Set("Authorization", "Bearer authToken")
+		req.Header.Set("X-Actor", "alice")
+		req.Header.Set("X-Forwarded-For", "10.0.0.1")
+		res.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		historyResult, ok := exec(res, "GET", url+"/history", ``)
+		require.True(t, ok)
+		events := historyResult["events"].([]interface{})
+		require.Len(t, events, 3)
+
+		var actions []interface{}
+		for _, event := range events {
+			actions = append(actions, event.(map[string]interface{})["action"])
+		}
+		require.Equal(t, []interface{}{"created", "invalidated", "deleted"}, actions)
+		require.Equal(t, "admin", events[1].(map[string]interface{})["actor"])
+		require.Equal(t, "leaked", events[1].(map[string]interface{})["reason"])
+		require.Equal(t, "alice", events[2].(map[string]interface{})["actor"])
+		require.Equal(t, "10.0.0.1", events[2].(map[string]interface{})["source_ip"])
+	})
+
 	t.Run("Invalidate", func(t *testing.T) {
-		res := New(auth.NewDatabase(memauth.New()), "endpoint", "authToken")
+		res := New(auth.NewDatabase(memauth.New()), "endpoint", Tokens{"authToken": RoleAdmin}, nil)
 
 		// create an access
 		createRequest := fmt.Sprintf(`{"access_grant": %q}`, minimalAccess)
@@ -122,10 +254,185 @@ func TestResources_CRUD(t *testing.T) {
 		// retrieve fails now
 		_, ok = exec(res, "GET", url, ``)
 		require.False(t, ok)
+
+		// invalidating again succeeds, but isn't recorded in the history
+		_, ok = exec(res, "PUT", url+"/invalid", `{"reason": "again"}`)
+		require.True(t, ok)
+
+		historyResult, ok := exec(res, "GET", url+"/history", ``)
+		require.True(t, ok)
+		events := historyResult["events"].([]interface{})
+		require.Len(t, events, 2)
+		require.Equal(t, "test", events[1].(map[string]interface{})["reason"])
+	})
+
+	t.Run("InvalidateByMacaroonHead", func(t *testing.T) {
+		kv := memauth.New()
+		res := New(auth.NewDatabase(kv), "endpoint", Tokens{"authToken": RoleAdmin}, nil)
+
+		// create two accesses with the same api key
+		var urls []string
+		for i := 0; i < 2; i++ {
+			createRequest := fmt.Sprintf(`{"access_grant": %q}`, minimalAccess)
+			createResult, ok := exec(res, "POST", "/v1/access", createRequest)
+			require.True(t, ok)
+			urls = append(urls, fmt.Sprintf("/v1/access/%s", createResult["access_key_id"]))
+		}
+
+		var head []byte
+		require.NoError(t, kv.Iterate(context.Background(), func(ctx context.Context, entry auth.Entry) error {
+			head = entry.Record.MacaroonHead
+			return nil
+		}))
+		require.NotEmpty(t, head)
+
+		// invalidate both accesses at once
+		invalidateURL := fmt.Sprintf("/v1/macaroon/%x/invalid", head)
+		invalidateResult, ok := exec(res, "PUT", invalidateURL, `{"reason": "revoked"}`)
+		require.True(t, ok)
+		require.Equal(t, map[string]interface{}{"invalidated": float64(2)}, invalidateResult)
+
+		// retrieves fail now
+		for _, url := range urls {
+			_, ok = exec(res, "GET", url, ``)
+			require.False(t, ok)
+		}
+
+		// a malformed macaroon head is rejected
+		_, ok = exec(res, "PUT", "/v1/macaroon/not-hex/invalid", `{"reason": "revoked"}`)
+		require.False(t, ok)
+	})
+
+	t.Run("Routes", func(t *testing.T) {
+		res := New(auth.NewDatabase(memauth.New()), "endpoint", Tokens{"authToken": RoleAdmin}, nil)
+
+		// create an access with a route
+		createRequest := fmt.Sprintf(`{"access_grant": %q, "routes": [{"bucket": "logs", "prefix": "app/", "access_grant": %q}]}`,
+			minimalAccess, minimalAccess)
+		createResult, ok := exec(res, "POST", "/v1/access", createRequest)
+		require.True(t, ok)
+		url := fmt.Sprintf("/v1/access/%s", createResult["access_key_id"])
+
+		// the routes are returned with the access
+		fetchResult, ok := exec(res, "GET", url, ``)
+		require.True(t, ok)
+		require.Equal(t, []interface{}{map[string]interface{}{
+			"bucket":       "logs",
+			"prefix":       "app/",
+			"access_grant": minimalAccess,
+		}}, fetchResult["routes"])
+
+		// routes without a valid access grant are rejected
+		createRequest = fmt.Sprintf(`{"access_grant": %q, "routes": [{"bucket": "logs", "access_grant": "invalid"}]}`, minimalAccess)
+		_, ok = exec(res, "POST", "/v1/access", createRequest)
+		require.False(t, ok)
+	})
+
+	t.Run("AccessGrant", func(t *testing.T) {
+		res := New(auth.NewDatabase(memauth.New()), "endpoint", Tokens{"authToken": RoleAdmin}, nil)
+
+		// malformed access grants are rejected with why
+		for _, accessGrant := range []string{"", "invalid", "1" + minimalAccess} {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", "/v1/access", strings.NewReader(fmt.Sprintf(`{"access_grant": %q}`, accessGrant)))
+			req.Header.Set("Authorization", "Bearer authToken")
+			res.ServeHTTP(rec, req)
+			require.Equal(t, http.StatusBadRequest, rec.Code, accessGrant)
+			require.Contains(t, rec.Body.String(), "access grant", accessGrant)
+		}
+
+		// the satellite address is read from the access grant
+		_, ok := exec(res, "POST", "/v1/access", fmt.Sprintf(`{"access_grant": %q}`, minimalAccess))
+		require.True(t, ok)
+		data, _, err := base58.CheckDecode(minimalAccess)
+		require.NoError(t, err)
+		var scope pb.Scope
+		require.NoError(t, pb.Unmarshal(data, &scope))
+		listResult, ok := exec(res, "GET", "/v1/admin/access", ``)
+		require.True(t, ok)
+		records := listResult["records"].([]interface{})
+		require.Len(t, records, 1)
+		require.Equal(t, scope.SatelliteAddr, records[0].(map[string]interface{})["satellite_address"])
+	})
+
+	t.Run("Batch", func(t *testing.T) {
+		res := New(auth.NewDatabase(memauth.New()), "endpoint", Tokens{"authToken": RoleAdmin}, nil)
+
+		// create many accesses at once
+		createRequest := fmt.Sprintf(`{"accesses": [{"access_grant": %q}, {"access_grant": %q, "public": true}]}`,
+			minimalAccess, minimalAccess)
+		createResult, ok := exec(res, "POST", "/v1/access/batch", createRequest)
+		require.True(t, ok)
+		accesses := createResult["accesses"].([]interface{})
+		require.Len(t, accesses, 2)
+
+		// every access can be retrieved with its own secret key
+		for i, access := range accesses {
+			access := access.(map[string]interface{})
+			require.Equal(t, "endpoint", access["endpoint"])
+
+			fetchResult, ok := exec(res, "GET", fmt.Sprintf("/v1/access/%s", access["access_key_id"]), ``)
+			require.True(t, ok)
+			require.Equal(t, minimalAccess, fetchResult["access_grant"])
+			require.Equal(t, access["secret_key"], fetchResult["secret_key"])
+			require.Equal(t, i == 1, fetchResult["public"])
+		}
+
+		// a batch with an invalid access grant stores nothing
+		_, ok = exec(res, "POST", "/v1/access/batch", `{"accesses": [{"access_grant": "invalid"}]}`)
+		require.False(t, ok)
+
+		// a partial batch stores the valid accesses and reports the others
+		createRequest = fmt.Sprintf(`{"partial": true, "accesses": [{"access_grant": "invalid"}, {"access_grant": %q}, {"access_grant": %q, "labels": {"Team": "x"}}]}`,
+			minimalAccess, minimalAccess)
+		createResult, ok = exec(res, "POST", "/v1/access/batch", createRequest)
+		require.True(t, ok)
+		accesses = createResult["accesses"].([]interface{})
+		require.Len(t, accesses, 3)
+
+		for i, access := range accesses {
+			access := access.(map[string]interface{})
+			if i != 1 {
+				require.NotEmpty(t, access["error"])
+				require.Nil(t, access["access_key_id"])
+				continue
+			}
+			require.Nil(t, access["error"])
+
+			fetchResult, ok := exec(res, "GET", fmt.Sprintf("/v1/access/%s", access["access_key_id"]), ``)
+			require.True(t, ok)
+			require.Equal(t, access["secret_key"], fetchResult["secret_key"])
+		}
+	})
+
+	t.Run("Expiration", func(t *testing.T) {
+		res := New(auth.NewDatabase(memauth.New()), "endpoint", Tokens{"authToken": RoleAdmin}, nil)
+
+		// create an access that expires in the future
+		expiresAt := time.Now().Add(time.Hour).Format(time.RFC3339)
+		createRequest := fmt.Sprintf(`{"access_grant": %q, "expires_at": %q}`, minimalAccess, expiresAt)
+		createResult, ok := exec(res, "POST", "/v1/access", createRequest)
+		require.True(t, ok)
+		url := fmt.Sprintf("/v1/access/%s", createResult["access_key_id"])
+
+		// retrieve an access
+		fetchResult, ok := exec(res, "GET", url, ``)
+		require.True(t, ok)
+		require.Equal(t, minimalAccess, fetchResult["access_grant"])
+
+		// an access that has already expired is rejected
+		expiresAt = time.Now().Add(-time.Hour).Format(time.RFC3339)
+		createRequest = fmt.Sprintf(`{"access_grant": %q, "expires_at": %q}`, minimalAccess, expiresAt)
+		_, ok = exec(res, "POST", "/v1/access", createRequest)
+		require.False(t, ok)
+
+		createRequest = fmt.Sprintf(`{"accesses": [{"access_grant": %q, "expires_at": %q}]}`, minimalAccess, expiresAt)
+		_, ok = exec(res, "POST", "/v1/access/batch", createRequest)
+		require.False(t, ok)
 	})
 
 	t.Run("Public", func(t *testing.T) {
-		res := New(auth.NewDatabase(memauth.New()), "endpoint", "authToken")
+		res := New(auth.NewDatabase(memauth.New()), "endpoint", Tokens{"authToken": RoleAdmin}, nil)
 
 		// create a public access
 		createRequest := fmt.Sprintf(`{"access_grant": %q, "public": true}`, minimalAccess)
@@ -142,8 +449,193 @@ func TestResources_CRUD(t *testing.T) {
 	})
 }
 
+func TestResources_Idempotency(t *testing.T) {
+	res := New(auth.NewDatabase(memauth.New()), "endpoint", Tokens{"authToken": RoleAdmin}, nil)
+
+	register := func(idempotencyKey, body string) (int, map[string]string) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/v1/access", strings.NewReader(body))
+		if idempotencyKey != "" {
+			req.Header.Set("Idempotency-Key", idempotencyKey)
+		}
+		res.ServeHTTP(rec, req)
+		var out map[string]string
+		if rec.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &out))
+		}
+		return rec.Code, out
+	}
+	body := fmt.Sprintf(`{"access_grant": %q, "labels": {"team": "storage"}}`, minimalAccess)
+
+	code, first := register("retry", body)
+	require.Equal(t, http.StatusOK, code)
+	code, retried := register("retry", body)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, first, retried)
+
+	// without the key or with another key, the access is registered again
+	code, other := register("", body)
+	require.Equal(t, http.StatusOK, code)
+	require.NotEqual(t, first["access_key_id"], other["access_key_id"])
+	code, other = register("other", body)
+	require.Equal(t, http.StatusOK, code)
+	require.NotEqual(t, first["access_key_id"], other["access_key_id"])
+
+	// the key can't be reused for another registration
+	code, _ = register("retry", fmt.Sprintf(`{"access_grant": %q, "labels": {"team": "compute"}}`, minimalAccess))
+	require.Equal(t, http.StatusUnprocessableEntity, code)
+	code, _ = register(strings.Repeat("k", 256), body)
+	require.Equal(t, http.StatusBadRequest, code)
+
+	// or with another expiration
+	expiresAt := time.Now().Add(time.Hour).UTC().Format(time.RFC3339Nano)
+	expiring := fmt.Sprintf(`{"access_grant": %q, "expires_at": %q}`, minimalAccess, expiresAt)
+	code, _ = register("expiring", expiring)
+	require.Equal(t, http.StatusOK, code)
+	code, _ = register("expiring", expiring)
+	require.Equal(t, http.StatusOK, code)
+	code, _ = register("expiring", fmt.Sprintf(`{"access_grant": %q}`, minimalAccess))
+	require.Equal(t, http.StatusUnprocessableEntity, code)
+
+	admin := func(method, path string) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(`{}`))
+		req.Header.Set("Authorization", "Bearer authToken")
+		res.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	// retries of accesses that are no longer valid conflict
+	admin("PUT", "/v1/access/"+first["access_key_id"]+"/invalid")
+	code, _ = register("retry", body)
+	require.Equal(t, http.StatusConflict, code)
+
+	code, deleted := register("deleted", body)
+	require.Equal(t, http.StatusOK, code)
+	admin("DELETE", "/v1/access/"+deleted["access_key_id"])
+	code, _ = register("deleted", body)
+	require.Equal(t, http.StatusConflict, code)
+}
+
+func TestResources_IdempotencySecret(t *testing.T) {
+	db := auth.NewDatabase(memauth.New())
+	register := func(res *Resources) string {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/v1/access", strings.NewReader(fmt.Sprintf(`{"access_grant": %q}`, minimalAccess)))
+		req.Header.Set("Idempotency-Key", "retry")
+		res.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var out map[string]string
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &out))
+		return out["access_key_id"]
+	}
+
+	// instances with the same secret recognize retries to each other
+	secret := bytes.Repeat([]byte{1}, 32)
+	first, second := New(db, "endpoint", nil, nil), New(db, "endpoint", nil, nil)
+	require.NoError(t, first.SetIdempotencySecret(secret))
+	require.NoError(t, second.SetIdempotencySecret(secret))
+	accessKeyID := register(first)
+	require.Equal(t, accessKeyID, register(second))
+
+	// the access key id can't be derived from the access grant and the
+	// idempotency key without the secret
+	mac := hmac.New(sha256.New, []byte(minimalAccess))
+	_, _ = mac.Write([]byte("idempotency key\x00retry"))
+	require.NotEqual(t, base58.CheckEncode(mac.Sum(nil), auth.VersionAccessKeyID), accessKeyID)
+
+	// instances with their own random secrets don't
+	require.NotEqual(t, accessKeyID, register(New(db, "endpoint", nil, nil)))
+
+	require.Error(t, first.SetIdempotencySecret([]byte("short")))
+}
+
+func TestResources_DerivedSecretKeys(t *testing.T) {
+	exec := func(res http.Handler, method, path, body string) map[string]interface{} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer authToken")
+		res.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var out map[string]interface{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &out))
+		return out
+	}
+
+	db := auth.NewDatabase(memauth.New())
+	res := New(db, "endpoint", Tokens{"authToken": RoleAdmin}, nil)
+	encrypted := exec(res, "POST", "/v1/access", fmt.Sprintf(`{"access_grant": %q}`, minimalAccess))
+
+	keys, err := auth.ParseDerivationKeys(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)))
+	require.NoError(t, err)
+	db.SetSecretKeyDerivation(keys)
+
+	// registering still responds with derived secret keys
+	derived := exec(res, "POST", "/v1/access", fmt.Sprintf(`{"access_grant": %q}`, minimalAccess))
+	require.NotEmpty(t, derived["secret_key"])
+
+	// but resolves leave out the secret keys of any access
+	for _, created := range []map[string]interface{}{encrypted, derived} {
+		for _, path := range []string{"/v1/access/%s", "/v2/access/%s"} {
+			resolved := exec(res, "GET", fmt.Sprintf(path, created["access_key_id"]), ``)
+			require.Equal(t, minimalAccess, resolved["access_grant"])
+			require.NotContains(t, resolved, "secret_key", path)
+		}
+	}
+}
+
+func TestResources_CaveatExpiration(t *testing.T) {
+	res := New(auth.NewDatabase(memauth.New()), "endpoint", Tokens{"authToken": RoleAdmin}, nil)
+
+	restricted := func(notAfter time.Time) string {
+		access, err := uplink.ParseAccess(minimalAccess)
+		require.NoError(t, err)
+		shared, err := access.Share(uplink.Permission{AllowDownload: true, NotAfter: notAfter})
+		require.NoError(t, err)
+		serialized, err := shared.Serialize()
+		require.NoError(t, err)
+		return serialized
+	}
+	register := func(body string) (int, map[string]string) {
+		rec := httptest.NewRecorder()
+		res.ServeHTTP(rec, httptest.NewRequest("POST", "/v1/access", strings.NewReader(body)))
+		var out map[string]string
+		if rec.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &out))
+		}
+		return rec.Code, out
+	}
+	expiresAt := func(accessKeyID string) *time.Time {
+		var key auth.EncryptionKey
+		data, version, err := base58.CheckDecode(accessKeyID)
+		require.NoError(t, err)
+		require.Equal(t, auth.VersionAccessKeyID, version)
+		copy(key[:], data)
+		access, err := res.db.Lookup(context.Background(), key)
+		require.NoError(t, err)
+		return access.ExpiresAt
+	}
+
+	// the access expires with the caveats of the access grant
+	notAfter := time.Now().Add(time.Hour).Truncate(time.Second)
+	code, out := register(fmt.Sprintf(`{"access_grant": %q}`, restricted(notAfter)))
+	require.Equal(t, http.StatusOK, code)
+	require.NotNil(t, expiresAt(out["access_key_id"]))
+	require.True(t, notAfter.Equal(*expiresAt(out["access_key_id"])))
+
+	// unless the client asked for an earlier expiration
+	earlier := notAfter.Add(-time.Minute)
+	code, out = register(fmt.Sprintf(`{"access_grant": %q, "expires_at": %q}`, restricted(notAfter), earlier.Format(time.RFC3339)))
+	require.Equal(t, http.StatusOK, code)
+	require.True(t, earlier.Equal(*expiresAt(out["access_key_id"])))
+
+	// access grants that have already expired aren't registered
+	code, _ = register(fmt.Sprintf(`{"access_grant": %q}`, restricted(time.Now().Add(-time.Hour))))
+	require.Equal(t, http.StatusBadRequest, code)
+}
+
 func TestResources_Authorization(t *testing.T) {
-	res := New(auth.NewDatabase(memauth.New()), "endpoint", "authToken")
+	res := New(auth.NewDatabase(memauth.New()), "endpoint", Tokens{"authToken": RoleAdmin}, nil)
 
 	// create an access grant and base url
 	createRequest := fmt.Sprintf(`{"access_grant": %q}`, minimalAccess)
@@ -162,7 +654,1079 @@ func TestResources_Authorization(t *testing.T) {
 	}
 
 	// check that these requests are unauthorized
+	check("POST", "/v1/access/batch")
 	check("GET", baseURL)
 	check("PUT", baseURL+"/invalid")
 	check("DELETE", baseURL)
+	check("POST", baseURL+"/restore")
+	check("GET", baseURL+"/history")
+}
+
+func TestResources_BruteForce(t *testing.T) {
+	limiter := bruteforce.New(bruteforce.Config{
+		Threshold:      2,
+		BanDuration:    time.Minute,
+		MaxBanDuration: time.Hour,
+		ForgetAfter:    time.Hour,
+		MaxEntries:     100,
+	})
+	res := New(auth.NewDatabase(memauth.New()), "endpoint", Tokens{"authToken": RoleAdmin}, limiter)
+	trustTestProxy(t, res)
+
+	get := func(accessKeyID, clientIP string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/v1/access/"+accessKeyID, nil)
+		req.Header.Set("Authorization", "Bearer authToken")
+		req.Header.Set("X-Forwarded-For", clientIP)
+		res.ServeHTTP(rec, req)
+		return rec
+	}
+
+	missing := base58.CheckEncode(make([]byte, 32), auth.VersionAccessKeyID)
+
+	// failures up to the threshold are answered normally
+	require.Equal(t, http.StatusBadRequest, get("invalid", "10.0.0.1").Code)
+	require.Equal(t, http.StatusInternalServerError, get(missing, "10.0.0.1").Code)
+	require.Equal(t, http.StatusInternalServerError, get(missing, "10.0.0.1").Code)
+
+	// then the client is banned
+	rec := get(missing, "10.0.0.1")
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	require.Equal(t, "60", rec.Header().Get("Retry-After"))
+
+	// other clients are unaffected until the key they try is banned too
+	require.Equal(t, http.StatusBadRequest, get("invalid", "10.0.0.2").Code)
+	require.Equal(t, http.StatusInternalServerError, get(missing, "10.0.0.2").Code)
+	require.Equal(t, http.StatusTooManyRequests, get(missing, "10.0.0.3").Code)
+
+	// authorized callers that forward no client, like a gateway that looks
+	// up the access keys its clients send, are never banned themselves
+	for i := 0; i < 5; i++ {
+		require.Equal(t, http.StatusBadRequest, get(fmt.Sprintf("invalid%d", i), "").Code)
+	}
+}
+
+func TestResources_Records(t *testing.T) {
+	exec := func(res http.Handler, method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer authToken")
+		res.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, format := range []string{"jsonl", "csv"} {
+		source := New(auth.NewDatabase(memauth.New()), "endpoint", Tokens{"authToken": RoleAdmin}, nil)
+		rec := exec(source, "POST", "/v1/access", fmt.Sprintf(`{"access_grant": %q}`, minimalAccess))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var created map[string]interface{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+
+		exported := exec(source, "GET", "/v1/records?format="+format, "")
+		require.Equal(t, http.StatusOK, exported.Code)
+		require.NotContains(t, exported.Body.String(), minimalAccess)
+
+		// the exported records work after importing them into another database
+		destination := New(auth.NewDatabase(memauth.New()), "endpoint", Tokens{"authToken": RoleAdmin}, nil)
+		rec = exec(destination, "POST", "/v1/records?format="+format, exported.Body.String())
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var stats auth.MigrateStats
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &stats))
+		require.EqualValues(t, 1, stats.Copied)
+
+		rec = exec(destination, "GET", "/v1/access/"+created["access_key_id"].(string), "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Contains(t, rec.Body.String(), minimalAccess)
+	}
+
+	res := New(auth.NewDatabase(memauth.New()), "endpoint", Tokens{"authToken": RoleAdmin}, nil)
+	require.Equal(t, http.StatusBadRequest, exec(res, "GET", "/v1/records?format=xml", "").Code)
+	require.Equal(t, http.StatusBadRequest, exec(res, "POST", "/v1/records", "not json").Code)
+
+	rec := httptest.NewRecorder()
+	res.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/records", nil))
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestResources_ListAccess(t *testing.T) {
+	exec := func(res http.Handler, method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer authToken")
+		res.ServeHTTP(rec, req)
+		return rec
+	}
+
+	type page struct {
+		Records []struct {
+			KeyHash          string `json:"key_hash"`
+			SatelliteAddress string `json:"satellite_address"`
+			InvalidReason    string `json:"invalid_reason"`
+		} `json:"records"`
+		NextCursor string `json:"next_cursor"`
+	}
+
+	res := New(auth.NewDatabase(memauth.New()), "endpoint", Tokens{"authToken": RoleAdmin}, nil)
+	list := func(query string) (p page) {
+		rec := exec(res, "GET", "/v1/admin/access"+query, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &p))
+		return p
+	}
+
+	// an empty database has an empty last page
+	empty := list("")
+	require.Empty(t, empty.Records)
+	require.Empty(t, empty.NextCursor)
+
+	var accessKeyIDs []string
+	for i := 0; i < 5; i++ {
+		rec := exec(res, "POST", "/v1/access", fmt.Sprintf(`{"access_grant": %q}`, minimalAccess))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var created map[string]interface{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+		accessKeyIDs = append(accessKeyIDs, created["access_key_id"].(string))
+	}
+	rec := exec(res, "PUT", "/v1/access/"+accessKeyIDs[0]+"/invalid", `{"reason": "leaked"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// paging sees every record once, including invalid ones
+	seen := make(map[string]bool)
+	var invalid int
+	cursor := ""
+	for pages := 0; ; pages++ {
+		require.True(t, pages < 5, "too many pages")
+		p := list("?limit=2&cursor=" + cursor)
+		require.True(t, len(p.Records) <= 2)
+		for _, record := range p.Records {
+			require.False(t, seen[record.KeyHash], "record listed twice")
+			seen[record.KeyHash] = true
+			if record.InvalidReason != "" {
+				invalid++
+			}
+		}
+		if p.NextCursor == "" {
+			break
+		}
+		cursor = p.NextCursor
+	}
+	require.Len(t, seen, 5)
+	require.Equal(t, 1, invalid)
+
+	// the records can be filtered by satellite
+	all := list("")
+	require.Len(t, all.Records, 5)
+	satellite := all.Records[0].SatelliteAddress
+	require.Len(t, list("?satellite="+satellite).Records, 5)
+	require.Len(t, list("?satellite=other.example.test:7777").Records, 0)
+
+	// invalid parameters are rejected
+	require.Equal(t, http.StatusBadRequest, exec(res, "GET", "/v1/admin/access?cursor=zz", "").Code)
+	require.Equal(t, http.StatusBadRequest, exec(res, "GET", "/v1/admin/access?cursor=00", "").Code)
+	require.Equal(t, http.StatusBadRequest, exec(res, "GET", "/v1/admin/access?limit=0", "").Code)
+	require.Equal(t, http.StatusBadRequest, exec(res, "GET", "/v1/admin/access?limit=100000", "").Code)
+
+	rec = httptest.NewRecorder()
+	res.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/admin/access", nil))
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestResources_V2(t *testing.T) {
+	exec := func(res http.Handler, method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer authToken")
+		res.ServeHTTP(rec, req)
+		return rec
+	}
+	type v2ErrorResponse struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	requireError := func(rec *httptest.ResponseRecorder, status int, code string) {
+		require.Equal(t, status, rec.Code, rec.Body.String())
+		var response v2ErrorResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		require.Equal(t, code, response.Error.Code)
+		require.NotEmpty(t, response.Error.Message)
+	}
+
+	res := New(auth.NewDatabase(memauth.New()), "endpoint", Tokens{"authToken": RoleAdmin}, nil)
+
+	var accessKeyIDs []string
+	for i, body := range []string{
+		fmt.Sprintf(`{"access_grant": %q, "public": true, "labels": {"team": "storage"}}`, minimalAccess),
+		fmt.Sprintf(`{"access_grant": %q, "labels": {"team": "storage"}}`, minimalAccess),
+		fmt.Sprintf(`{"access_grant": %q}`, minimalAccess),
+	} {
+		rec := exec(res, "POST", "/v1/access", body)
+		require.Equal(t, http.StatusOK, rec.Code, i)
+		var created map[string]string
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+		accessKeyIDs = append(accessKeyIDs, created["access_key_id"])
+	}
+	require.Equal(t, http.StatusOK, exec(res, "PUT", "/v1/access/"+accessKeyIDs[1]+"/invalid", `{"reason": "leaked"}`).Code)
+
+	list := func(query string) (records []map[string]interface{}) {
+		rec := exec(res, "GET", "/v2/access"+query, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var page struct {
+			Records    []map[string]interface{} `json:"records"`
+			NextCursor string                   `json:"next_cursor"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+		require.Empty(t, page.NextCursor)
+		return page.Records
+	}
+
+	require.Len(t, list(""), 3)
+	require.Len(t, list("?label=team=storage"), 2)
+	require.Len(t, list("?public=true"), 1)
+	require.Len(t, list("?public=false&state=valid"), 1)
+	invalid := list("?state=invalid&fields=state,invalid_reason")
+	require.Equal(t, []map[string]interface{}{{"state": "invalid", "invalid_reason": "leaked"}}, invalid)
+
+	// paging with opaque cursors
+	rec := exec(res, "GET", "/v2/access?limit=2", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var page struct {
+		Records    []map[string]interface{} `json:"records"`
+		NextCursor string                   `json:"next_cursor"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+	require.Len(t, page.Records, 2)
+	require.NotEmpty(t, page.NextCursor)
+	require.Len(t, list("?cursor="+page.NextCursor), 1)
+
+	requireError(exec(res, "GET", "/v2/access?state=lost", ""), http.StatusBadRequest, "bad_request")
+	requireError(exec(res, "GET", "/v2/access?public=maybe", ""), http.StatusBadRequest, "bad_request")
+	requireError(exec(res, "GET", "/v2/access?fields=access_grant", ""), http.StatusBadRequest, "bad_request")
+	requireError(exec(res, "GET", "/v2/access?cursor=!", ""), http.StatusBadRequest, "bad_request")
+	requireError(exec(New(nil, "endpoint", nil, nil), "GET", "/v2/access", ""), http.StatusUnauthorized, "unauthorized")
+
+	// resolving leaves out the fields that aren't requested
+	rec = exec(res, "GET", "/v2/access/"+accessKeyIDs[0]+"?fields=public,labels", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var access map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &access))
+	require.Equal(t, map[string]interface{}{"public": true, "labels": map[string]interface{}{"team": "storage"}}, access)
+
+	requireError(exec(res, "GET", "/v2/access/"+accessKeyIDs[1], ""), http.StatusGone, "invalid")
+	var missing auth.EncryptionKey
+	requireError(exec(res, "GET", "/v2/access/"+base58.CheckEncode(missing[:], auth.VersionAccessKeyID), ""), http.StatusNotFound, "not_found")
+	requireError(exec(res, "GET", "/v2/access/invalid", ""), http.StatusBadRequest, "bad_request")
+}
+
+func TestResources_Usage(t *testing.T) {
+	exec := func(res http.Handler, method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer authToken")
+		res.ServeHTTP(rec, req)
+		return rec
+	}
+
+	kv := memauth.New()
+	db := auth.NewDatabase(kv)
+	tracker := auth.NewUsageTracker(zap.NewNop(), kv, auth.UsageConfig{Interval: time.Hour})
+	db.SetUsageTracker(tracker)
+	res := New(db, "endpoint", Tokens{"authToken": RoleAdmin}, nil)
+
+	var accessKeyIDs []string
+	for i := 0; i < 2; i++ {
+		rec := exec(res, "POST", "/v1/access", fmt.Sprintf(`{"access_grant": %q}`, minimalAccess))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var created map[string]string
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+		accessKeyIDs = append(accessKeyIDs, created["access_key_id"])
+	}
+
+	type usage struct {
+		LastUsedAt *time.Time `json:"last_used_at"`
+		UseCount   int64      `json:"use_count"`
+	}
+	resolve := func(path string) (resolved usage) {
+		rec := exec(res, "GET", path, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resolved))
+		return resolved
+	}
+
+	// a resolve returns the uses before it, including those that haven't
+	// been written yet
+	before := time.Now()
+	require.Equal(t, usage{}, resolve("/v1/access/"+accessKeyIDs[0]))
+	resolved := resolve("/v2/access/" + accessKeyIDs[0] + "?fields=last_used_at,use_count")
+	require.EqualValues(t, 1, resolved.UseCount)
+	require.NotNil(t, resolved.LastUsedAt)
+	require.False(t, resolved.LastUsedAt.Before(before.Truncate(time.Second)))
+
+	require.Equal(t, 1, tracker.Flush(context.Background()))
+	require.EqualValues(t, 2, resolve("/v1/access/"+accessKeyIDs[0]).UseCount)
+
+	list := func(path string) (records []usage) {
+		rec := exec(res, "GET", path, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var page struct {
+			Records []usage `json:"records"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+		return page.Records
+	}
+
+	// the lists have the written uses, and stale accesses can be found
+	var total int64
+	for _, record := range list("/v1/admin/access") {
+		total += record.UseCount
+	}
+	require.EqualValues(t, 2, total)
+
+	stale := list("/v2/access?unused_since=" + before.Add(-time.Minute).UTC().Format(time.RFC3339))
+	require.Len(t, stale, 1)
+	require.Nil(t, stale[0].LastUsedAt)
+	require.Len(t, list("/v2/access?unused_since="+before.Add(time.Hour).UTC().Format(time.RFC3339)), 2)
+
+	rec := exec(res, "GET", "/v2/access?unused_since=yesterday", "")
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestResources_V2History(t *testing.T) {
+	exec := func(res http.Handler, path string, header http.Header) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", path, nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		req.Header.Set("Authorization", "Bearer authToken")
+		res.ServeHTTP(rec, req)
+		return rec
+	}
+	type eventPage struct {
+		Events []struct {
+			KeyHash string `json:"key_hash"`
+			Action  string `json:"action"`
+			Reason  string `json:"reason"`
+		} `json:"events"`
+		NextCursor string `json:"next_cursor"`
+	}
+	query := func(res http.Handler, path string) (page eventPage) {
+		rec := exec(res, path, nil)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+		return page
+	}
+
+	res := New(auth.NewDatabase(memauth.New()), "endpoint", Tokens{"authToken": RoleAdmin}, nil)
+
+	var accessKeyIDs []string
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/v1/access", strings.NewReader(fmt.Sprintf(`{"access_grant": %q}`, minimalAccess)))
+		req.Header.Set("Authorization", "Bearer authToken")
+		res.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+		var created map[string]string
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+		accessKeyIDs = append(accessKeyIDs, created["access_key_id"])
+	}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("PUT", "/v1/access/"+accessKeyIDs[1]+"/invalid", strings.NewReader(`{"reason": "leaked"}`))
+	req.Header.Set("Authorization", "Bearer authToken")
+	res.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// every event of every record
+	all := query(res, "/v2/history")
+	require.Len(t, all.Events, 4)
+	require.Empty(t, all.NextCursor)
+
+	// paging ends after the events of a record
+	page := query(res, "/v2/history?limit=1")
+	require.NotEmpty(t, page.Events)
+	require.NotEmpty(t, page.NextCursor)
+	events := len(page.Events)
+	for page.NextCursor != "" {
+		page = query(res, "/v2/history?limit=1&cursor="+page.NextCursor)
+		events += len(page.Events)
+	}
+	require.Equal(t, 4, events)
+
+	// filters
+	invalidated := query(res, "/v2/history?action=invalidated")
+	require.Len(t, invalidated.Events, 1)
+	require.Equal(t, "leaked", invalidated.Events[0].Reason)
+	require.Len(t, query(res, "/v2/history?access_key_id="+accessKeyIDs[1]).Events, 2)
+	require.Len(t, query(res, "/v2/history?key_hash="+invalidated.Events[0].KeyHash).Events, 2)
+	require.Empty(t, query(res, "/v2/history?since="+time.Now().Add(time.Hour).UTC().Format(time.RFC3339)).Events)
+	require.Len(t, query(res, "/v2/history?until="+time.Now().Add(time.Hour).UTC().Format(time.RFC3339)).Events, 4)
+
+	for _, path := range []string{
+		"/v2/history?action=lost",
+		"/v2/history?since=yesterday",
+		"/v2/history?key_hash=00",
+		"/v2/history?limit=0",
+		"/v2/history?cursor=!",
+	} {
+		require.Equal(t, http.StatusBadRequest, exec(res, path, nil).Code, path)
+	}
+
+	// responses are compressed for clients that accept gzip
+	rec = exec(res, "/v2/history", http.Header{"Accept-Encoding": {"gzip, deflate"}})
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+	reader, err := gzip.NewReader(rec.Body)
+	require.NoError(t, err)
+	var compressed eventPage
+	require.NoError(t, json.NewDecoder(reader).Decode(&compressed))
+	require.Equal(t, all, compressed)
+}
+
+func TestResources_Labels(t *testing.T) {
+	exec := func(res http.Handler, method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer authToken")
+		res.ServeHTTP(rec, req)
+		return rec
+	}
+
+	res := New(auth.NewDatabase(memauth.New()), "endpoint", Tokens{"authToken": RoleAdmin}, nil)
+
+	create := func(labels string) string {
+		rec := exec(res, "POST", "/v1/access", fmt.Sprintf(`{"access_grant": %q, "labels": %s}`, minimalAccess, labels))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var created map[string]interface{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+		return created["access_key_id"].(string)
+	}
+
+	storage := create(`{"team": "storage", "environment": "production"}`)
+	create(`{"team": "gateway"}`)
+	unlabeled := create(`null`)
+
+	// labels are returned on fetch
+	rec := exec(res, "GET", "/v1/access/"+storage, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var fetched map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &fetched))
+	require.Equal(t, map[string]interface{}{"team": "storage", "environment": "production"}, fetched["labels"])
+
+	rec = exec(res, "GET", "/v1/access/"+unlabeled, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NotContains(t, rec.Body.String(), "labels")
+
+	// invalid labels are rejected
+	rec = exec(res, "POST", "/v1/access", fmt.Sprintf(`{"access_grant": %q, "labels": {"Team": "x"}}`, minimalAccess))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// the records can be filtered by labels
+	count := func(query string) int {
+		rec := exec(res, "GET", "/v1/records"+query, "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		return strings.Count(rec.Body.String(), "\n")
+	}
+	require.Equal(t, 3, count(""))
+	require.Equal(t, 1, count("?label=team=storage"))
+	require.Equal(t, 1, count("?label=team=storage&label=environment=production"))
+	require.Equal(t, 0, count("?label=team=storage&label=environment=staging"))
+	require.Equal(t, 1, count("?label=team=gateway"))
+	require.Equal(t, http.StatusBadRequest, exec(res, "GET", "/v1/records?label=team", "").Code)
+}
+
+// unavailableKV is a key/value store whose lookups fail as if it were
+// temporarily unavailable.
+type unavailableKV struct {
+	*memauth.KV
+}
+
+func (kv unavailableKV) Get(ctx context.Context, keyHash auth.KeyHash) (*auth.Record, error) {
+	return nil, &auth.UnavailableError{RetryAfter: 1500 * time.Millisecond}
+}
+
+func TestResources_Unavailable(t *testing.T) {
+	res := New(auth.NewDatabase(unavailableKV{memauth.New()}), "endpoint", Tokens{"authToken": RoleAdmin}, nil)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/v1/access/"+base58.CheckEncode(make([]byte, 32), auth.VersionAccessKeyID), nil)
+	req.Header.Set("Authorization", "Bearer authToken")
+	res.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	require.Equal(t, "2", rec.Header().Get("Retry-After"))
+}
+
+// coreKV only has the methods of auth.KV, and none of the optional
+// capabilities of the key/value store it embeds.
+type coreKV struct {
+	auth.KV
+}
+
+func TestResources_Unsupported(t *testing.T) {
+	res := New(auth.NewDatabase(coreKV{memauth.New()}), "endpoint", Tokens{"authToken": RoleAdmin}, nil)
+	exec := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer authToken")
+		res.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// accesses are created without a history
+	rec := exec("POST", "/v1/access", fmt.Sprintf(`{"access_grant": %q}`, minimalAccess))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var created map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+
+	// what the key/value store can't do is not implemented
+	require.Equal(t, http.StatusNotImplemented, exec("GET", "/v1/access/"+created["access_key_id"].(string)+"/history", "").Code)
+	require.Equal(t, http.StatusNotImplemented, exec("GET", "/v1/records", "").Code)
+	require.Equal(t, http.StatusNotImplemented, exec("GET", "/v1/admin/access", "").Code)
+	require.Equal(t, http.StatusNotImplemented, exec("PUT", "/v1/macaroon/00/invalid", "{}").Code)
+}
+
+func TestResources_Passphrase(t *testing.T) {
+	res := New(auth.NewDatabase(memauth.New()), "endpoint", Tokens{"authToken": RoleAdmin}, nil)
+
+	exec := func(method, path, body string, authorized bool) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if authorized {
+			req.Header.Set("Authorization", "Bearer authToken")
+		}
+		res.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := exec("POST", "/v1/access", fmt.Sprintf(`{"access_grant": %q}`, keyedAccess), false)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var created map[string]string
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	url := "/v1/access/" + created["access_key_id"] + "/passphrase"
+
+	// the secret key of the access is required
+	body := `{"secret_key": %q, "bucket": "photos", "prefix": "private/", "passphrase": "folder passphrase"}`
+	require.Equal(t, http.StatusUnauthorized, exec("POST", url, fmt.Sprintf(body, "wrong"), false).Code)
+
+	rec = exec("POST", url, fmt.Sprintf(body, created["secret_key"]), false)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var derived map[string]string
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &derived))
+	require.NotEqual(t, created["access_key_id"], derived["access_key_id"])
+
+	expected, err := auth.DerivePassphraseAccess(keyedAccess, "photos", "private/", "folder passphrase", nil)
+	require.NoError(t, err)
+	rec = exec("GET", "/v1/access/"+derived["access_key_id"], "", true)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), expected)
+
+	// invalid derivations are rejected
+	body = `{"secret_key": %q, "bucket": "photos", "prefix": "private", "passphrase": "folder passphrase"}`
+	require.Equal(t, http.StatusBadRequest, exec("POST", url, fmt.Sprintf(body, created["secret_key"]), false).Code)
+
+	missing := base58.CheckEncode(make([]byte, 32), auth.VersionAccessKeyID)
+	require.Equal(t, http.StatusUnauthorized, exec("POST", "/v1/access/"+missing+"/passphrase", `{}`, true).Code)
+}
+
+func TestResources_PassphraseQuota(t *testing.T) {
+	db := auth.NewDatabase(memauth.New())
+	db.SetQuotas(auth.QuotaConfig{PerSource: 2})
+	res := New(db, "endpoint", Tokens{"authToken": RoleAdmin}, nil)
+
+	exec := func(path, body, remoteAddr string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.RemoteAddr = remoteAddr
+		res.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := exec("/v1/access", fmt.Sprintf(`{"access_grant": %q}`, keyedAccess), "192.0.2.1:1234")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var created map[string]string
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	url := "/v1/access/" + created["access_key_id"] + "/passphrase"
+	body := fmt.Sprintf(`{"secret_key": %q, "bucket": "photos", "prefix": "private/", "passphrase": "folder passphrase"}`, created["secret_key"])
+
+	// derived accesses count towards the quota of the owner of the access,
+	// whatever ip they are derived from
+	require.Equal(t, http.StatusOK, exec(url, body, "192.0.2.2:1234").Code)
+	rec = exec(url, body, "192.0.2.3:1234")
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	require.Contains(t, rec.Body.String(), "quota")
+}
+
+// unhealthyKV is a key/value store whose health checks fail.
+type unhealthyKV struct {
+	*memauth.KV
+}
+
+func (kv unhealthyKV) HealthCheck(ctx context.Context) error {
+	return errors.New("database unreachable")
+}
+
+func TestResources_Health(t *testing.T) {
+	get := func(res http.Handler, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		res.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec
+	}
+
+	healthy := New(auth.NewDatabase(memauth.New()), "endpoint", Tokens{"authToken": RoleAdmin}, nil)
+	require.Equal(t, http.StatusOK, get(healthy, "/healthz").Code)
+	require.Equal(t, http.StatusOK, get(healthy, "/readyz").Code)
+
+	// an instance that can't reach its database is alive but not ready, and
+	// doesn't tell unauthenticated probes why
+	unhealthy := New(auth.NewDatabase(unhealthyKV{memauth.New()}), "endpoint", Tokens{"authToken": RoleAdmin}, nil)
+	require.Equal(t, http.StatusOK, get(unhealthy, "/healthz").Code)
+	rec := get(unhealthy, "/readyz")
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	require.NotContains(t, rec.Body.String(), "unreachable")
+}
+
+func TestResources_Metrics(t *testing.T) {
+	res := New(auth.NewDatabase(memauth.New()), "endpoint", Tokens{"authToken": RoleAdmin}, nil)
+
+	rec := httptest.NewRecorder()
+	res.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/metrics", nil))
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	for path, field := range map[string]string{
+		"/v1/metrics":             "samples",
+		"/v1/metrics?definitions": "definitions",
+	} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer authToken")
+		rec := httptest.NewRecorder()
+		res.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, path)
+
+		var body map[string]json.RawMessage
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		require.Contains(t, body, field, path)
+	}
+}
+
+func TestResources_ClientCertificates(t *testing.T) {
+	res := New(auth.NewDatabase(memauth.New()), "endpoint", Tokens{"authToken": RoleAdmin}, nil)
+	res.RequireClientCertificates(true)
+
+	exec := func(method, path, body string, cert *x509.Certificate) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer authToken")
+		if cert != nil {
+			req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		}
+		res.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// registration and reads keep working with the auth token alone
+	rec := exec("POST", "/v1/access", fmt.Sprintf(`{"access_grant": %q}`, minimalAccess), nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var created map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	url := fmt.Sprintf("/v1/access/%s", created["access_key_id"])
+	require.Equal(t, http.StatusOK, exec("GET", url, "", nil).Code)
+
+	// destructive routes need a verified client certificate
+	require.Equal(t, http.StatusForbidden, exec("PUT", url+"/invalid", `{"reason": "leaked"}`, nil).Code)
+	require.Equal(t, http.StatusForbidden, exec("DELETE", url, "", nil).Code)
+	require.Equal(t, http.StatusForbidden, exec("POST", "/v1/records", "", nil).Code)
+
+	// which names the actor in the history
+	ops := &x509.Certificate{Subject: pkix.Name{CommonName: "ops"}}
+	require.Equal(t, http.StatusOK, exec("DELETE", url, "", ops).Code)
+
+	var history struct {
+		Events []auth.HistoryEvent `json:"events"`
+	}
+	rec = exec("GET", url+"/history", "", nil)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &history))
+	require.Len(t, history.Events, 2)
+	require.Equal(t, "ops", history.Events[1].Actor)
+}
+
+func TestResources_Roles(t *testing.T) {
+	tokens, err := ParseTokens("ui=register, reader=read,ops=admin")
+	require.NoError(t, err)
+	res := New(auth.NewDatabase(memauth.New()), "endpoint", tokens, nil)
+
+	exec := func(token, method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		res.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// register tokens can register, but not read or delete
+	batch := fmt.Sprintf(`{"accesses": [{"access_grant": %q}]}`, minimalAccess)
+	rec := exec("ui", "POST", "/v1/access/batch", batch)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var created struct {
+		Accesses []struct {
+			AccessKeyID string `json:"access_key_id"`
+		} `json:"accesses"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	require.Len(t, created.Accesses, 1)
+	url := "/v1/access/" + created.Accesses[0].AccessKeyID
+
+	require.Equal(t, http.StatusUnauthorized, exec("ui", "GET", url, "").Code)
+	require.Equal(t, http.StatusUnauthorized, exec("ui", "DELETE", url, "").Code)
+
+	// read tokens can read, but not register or delete
+	require.Equal(t, http.StatusOK, exec("reader", "GET", url, "").Code)
+	require.Equal(t, http.StatusOK, exec("reader", "GET", url+"/history", "").Code)
+	require.Equal(t, http.StatusUnauthorized, exec("reader", "POST", "/v1/access/batch", batch).Code)
+	require.Equal(t, http.StatusUnauthorized, exec("reader", "PUT", url+"/invalid", `{"reason": "leaked"}`).Code)
+	require.Equal(t, http.StatusUnauthorized, exec("reader", "DELETE", url, "").Code)
+
+	// admin tokens can do everything, and unknown tokens nothing
+	require.Equal(t, http.StatusUnauthorized, exec("unknown", "GET", url, "").Code)
+	require.Equal(t, http.StatusOK, exec("ops", "POST", "/v1/access/batch", batch).Code)
+	require.Equal(t, http.StatusOK, exec("ops", "DELETE", url, "").Code)
+
+	// the history names the roles of the tokens
+	var history struct {
+		Events []auth.HistoryEvent `json:"events"`
+	}
+	require.NoError(t, json.Unmarshal(exec("reader", "GET", url+"/history", "").Body.Bytes(), &history))
+	require.Len(t, history.Events, 2)
+	require.Equal(t, "register", history.Events[0].Actor)
+	require.Equal(t, "admin", history.Events[1].Actor)
+}
+
+func TestParseTokens(t *testing.T) {
+	tokens, err := ParseTokens("")
+	require.NoError(t, err)
+	require.Empty(t, tokens)
+
+	// tokens may contain =
+	tokens, err = ParseTokens("abc==admin")
+	require.NoError(t, err)
+	require.Equal(t, Tokens{"abc=": RoleAdmin}, tokens)
+
+	// as in files, with a token per line
+	tokens, err = ParseTokens("a=read\nb=admin\n")
+	require.NoError(t, err)
+	require.Equal(t, Tokens{"a": RoleRead, "b": RoleAdmin}, tokens)
+
+	for _, invalid := range []string{"abc", "=admin", "abc=root", "abc="} {
+		_, err := ParseTokens(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestResources_SetTokens(t *testing.T) {
+	res := New(auth.NewDatabase(memauth.New()), "endpoint", Tokens{"old": RoleAdmin}, nil)
+
+	status := func(token string) int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/v1/metrics", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		res.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	require.Equal(t, http.StatusOK, status("old"))
+	require.Equal(t, http.StatusUnauthorized, status("new"))
+
+	// both tokens work during the overlap
+	res.SetTokens(Tokens{"new": RoleRead}, time.Hour)
+	require.Equal(t, http.StatusOK, status("new"))
+	require.Equal(t, http.StatusOK, status("old"))
+
+	// also when the tokens are replaced again meanwhile, and the roles of
+	// the new tokens take precedence
+	res.SetTokens(Tokens{"new": RoleRead, "other": RoleRegister}, time.Hour)
+	require.Equal(t, http.StatusOK, status("old"))
+	require.Equal(t, http.StatusUnauthorized, status("other"))
+
+	// and only the new ones after it
+	res.SetTokens(Tokens{"newer": RoleRead}, 0)
+	require.Equal(t, http.StatusOK, status("newer"))
+	require.Equal(t, http.StatusUnauthorized, status("new"))
+	require.Equal(t, http.StatusUnauthorized, status("old"))
+}
+
+func TestResources_RateLimit(t *testing.T) {
+	res := New(auth.NewDatabase(memauth.New()), "endpoint", Tokens{"a": RoleAdmin, "b": RoleAdmin}, nil)
+	res.SetRateLimiters(
+		ratelimit.New(ratelimit.Config{Rate: 0.001, Burst: 2, MaxEntries: 10}),
+		ratelimit.New(ratelimit.Config{Rate: 0.001, Burst: 1, MaxEntries: 10}))
+	trustTestProxy(t, res)
+
+	exec := func(path, token, ip string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("X-Forwarded-For", ip)
+		res.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// every client ip may make a request
+	require.Equal(t, http.StatusOK, exec("/v1/metrics", "a", "10.0.0.1").Code)
+	rec := exec("/v1/metrics", "b", "10.0.0.1")
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	require.NotEmpty(t, rec.Header().Get("Retry-After"))
+
+	// and every token two
+	require.Equal(t, http.StatusOK, exec("/v1/metrics", "a", "10.0.0.2").Code)
+	require.Equal(t, http.StatusTooManyRequests, exec("/v1/metrics", "a", "10.0.0.3").Code)
+	require.Equal(t, http.StatusOK, exec("/v1/metrics", "b", "10.0.0.4").Code)
+
+	// health checks aren't limited
+	require.Equal(t, http.StatusOK, exec("/healthz", "a", "10.0.0.1").Code)
+
+	// the ips of authorized callers aren't limited, only those of the
+	// clients that they forward, and made up tokens take up no entries
+	res = New(auth.NewDatabase(memauth.New()), "endpoint", Tokens{"a": RoleAdmin}, nil)
+	res.SetRateLimiters(
+		ratelimit.New(ratelimit.Config{Rate: 0.001, Burst: 2, MaxEntries: 1}),
+		ratelimit.New(ratelimit.Config{Rate: 0.001, Burst: 1, MaxEntries: 10}))
+	trustTestProxy(t, res)
+	for i := 0; i < 3; i++ {
+		require.Equal(t, http.StatusUnauthorized, exec("/v1/metrics", fmt.Sprintf("junk%d", i), fmt.Sprintf("10.0.1.%d", i)).Code)
+	}
+	require.Equal(t, http.StatusOK, exec("/v1/metrics", "a", "").Code)
+	require.Equal(t, http.StatusOK, exec("/v1/metrics", "a", "").Code)
+	require.Equal(t, http.StatusTooManyRequests, exec("/v1/metrics", "a", "").Code)
+}
+
+func TestResources_Tarpit(t *testing.T) {
+	const tarpit = 50 * time.Millisecond
+	limiter := bruteforce.New(bruteforce.Config{
+		Threshold:      100,
+		BanDuration:    time.Minute,
+		MaxBanDuration: time.Hour,
+		ForgetAfter:    time.Hour,
+		MaxEntries:     100,
+		Tarpit:         tarpit,
+	})
+	res := New(auth.NewDatabase(memauth.New()), "endpoint", Tokens{"authToken": RoleAdmin}, limiter)
+
+	exec := func(method, path, authorization string) (int, time.Duration) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(`{"secret_key": "wrong"}`))
+		req.Header.Set("Authorization", authorization)
+		start := time.Now()
+		res.ServeHTTP(rec, req)
+		return rec.Code, time.Since(start)
+	}
+
+	missing := base58.CheckEncode(make([]byte, 32), auth.VersionAccessKeyID)
+
+	// failed lookups of unauthorized clients are delayed
+	code, elapsed := exec("POST", "/v1/access/"+missing+"/passphrase", "")
+	require.Equal(t, http.StatusUnauthorized, code)
+	require.True(t, elapsed >= tarpit, elapsed)
+
+	// but not those of authorized callers, like the gateway, which look up
+	// access keys for all of their clients
+	code, elapsed = exec("GET", "/v1/access/"+missing, "Bearer authToken")
+	require.Equal(t, http.StatusInternalServerError, code)
+	require.True(t, elapsed < tarpit, elapsed)
+
+	// nor requests that are rejected before the lookup
+	code, _ = exec("GET", "/v1/access/invalid", "")
+	require.Equal(t, http.StatusUnauthorized, code)
+}
+
+func TestResources_RequestLogging(t *testing.T) {
+	observed, logs := observer.New(zapcore.DebugLevel)
+	res := New(auth.NewDatabase(memauth.New()), "endpoint", Tokens{"authToken": RoleRead}, nil)
+	res.SetLogger(zap.New(observed))
+
+	exec := func(method, path, requestID string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer authToken")
+		req.Header.Set(actorHeader, "alice")
+		if requestID != "" {
+			req.Header.Set(requestIDHeader, requestID)
+		}
+		res.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// the id of the client is kept, and the route doesn't have the access key id
+	rec := exec("GET", "/v1/access/someid/history", "req-1")
+	require.Equal(t, "req-1", rec.Header().Get(requestIDHeader))
+	entries := logs.TakeAll()
+	require.Len(t, entries, 1)
+	fields := entries[0].ContextMap()
+	require.Equal(t, "req-1", fields["request_id"])
+	require.Equal(t, "GET", fields["method"])
+	require.Equal(t, "/v1/access/*/history", fields["route"])
+	require.EqualValues(t, rec.Code, fields["status"])
+	require.Equal(t, "read", fields["role"])
+	require.Equal(t, "alice", fields["actor"])
+	require.NotContains(t, fmt.Sprint(fields), "authToken")
+
+	// invalid ids are replaced
+	rec = exec("DELETE", "/v1/access/someid", "forged\nline")
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	id := rec.Header().Get(requestIDHeader)
+	require.Len(t, id, 32)
+	entries = logs.TakeAll()
+	require.Len(t, entries, 1)
+	require.Equal(t, id, entries[0].ContextMap()["request_id"])
+	require.EqualValues(t, http.StatusUnauthorized, entries[0].ContextMap()["status"])
+
+	// health checks are logged at the debug level
+	require.NotEmpty(t, exec("GET", "/healthz", "").Header().Get(requestIDHeader))
+	entries = logs.TakeAll()
+	require.Len(t, entries, 1)
+	require.Equal(t, zapcore.DebugLevel, entries[0].Level)
+	require.Equal(t, "/healthz", entries[0].ContextMap()["route"])
+}
+
+func TestResources_CORS(t *testing.T) {
+	res := New(auth.NewDatabase(memauth.New()), "endpoint", Tokens{"authToken": RoleAdmin}, nil)
+	exec := func(method, path, origin string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer authToken")
+		req.Header.Set("Origin", origin)
+		if method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", "POST")
+		}
+		res.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// disabled by default
+	rec := exec("OPTIONS", "/v1/access", "https://ui.test")
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	require.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+
+	res.SetCORS(CORSConfig{
+		AllowedOrigins: "https://ui.test/, https://other.test",
+		AllowedHeaders: "Authorization,Content-Type",
+		AllowedMethods: "GET,POST",
+		MaxAge:         time.Minute,
+	})
+
+	// preflight requests of allowed origins are answered
+	rec = exec("OPTIONS", "/v1/access", "https://ui.test")
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Equal(t, "https://ui.test", rec.Header().Get("Access-Control-Allow-Origin"))
+	require.Equal(t, "GET, POST", rec.Header().Get("Access-Control-Allow-Methods"))
+	require.Equal(t, "Authorization, Content-Type", rec.Header().Get("Access-Control-Allow-Headers"))
+	require.Equal(t, "60", rec.Header().Get("Access-Control-Max-Age"))
+
+	// and of other origins are not
+	rec = exec("OPTIONS", "/v1/access/someid", "https://evil.test")
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	require.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+
+	// requests of allowed origins can read the response
+	rec = exec("GET", "/v1/access/someid", "https://other.test")
+	require.Equal(t, "https://other.test", rec.Header().Get("Access-Control-Allow-Origin"))
+	require.Equal(t, requestIDHeader, rec.Header().Get("Access-Control-Expose-Headers"))
+	require.Equal(t, "Origin", rec.Header().Get("Vary"))
+
+	// only the access routes have cors
+	rec = exec("GET", "/v1/metrics", "https://ui.test")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+
+	res.SetCORS(CORSConfig{AllowedOrigins: "*"})
+	rec = exec("GET", "/v1/access/someid", "https://any.test")
+	require.Equal(t, "*", rec.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestResources_Quota(t *testing.T) {
+	db := auth.NewDatabase(memauth.New())
+	db.SetQuotas(auth.QuotaConfig{PerToken: 1, PerSource: 1})
+	res := New(db, "endpoint", Tokens{"adminToken": RoleAdmin, "registerToken": RoleRegister}, nil)
+
+	register := func(token, remoteAddr string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/v1/access", strings.NewReader(fmt.Sprintf(`{"access_grant": %q}`, minimalAccess)))
+		req.RemoteAddr = remoteAddr
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// clients without a token have a quota per ip
+	require.Equal(t, http.StatusOK, register("", "192.0.2.1:1234").Code)
+	rec := register("", "192.0.2.1:5678")
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	require.Contains(t, rec.Body.String(), "quota")
+	require.Equal(t, http.StatusOK, register("", "192.0.2.2:1234").Code)
+
+	// clients can't spoof their ip to get another quota
+	spoofed := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/v1/access", strings.NewReader(fmt.Sprintf(`{"access_grant": %q}`, minimalAccess)))
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	res.ServeHTTP(spoofed, req)
+	require.Equal(t, http.StatusForbidden, spoofed.Code)
+
+	// tokens have a quota per token, whatever their ip
+	require.Equal(t, http.StatusOK, register("registerToken", "192.0.2.1:1234").Code)
+	require.Equal(t, http.StatusForbidden, register("registerToken", "192.0.2.3:1234").Code)
+
+	// admin tokens have no quota
+	for i := 0; i < 3; i++ {
+		require.Equal(t, http.StatusOK, register("adminToken", "192.0.2.1:1234").Code)
+	}
+}
+
+// trustTestProxy trusts the X-Forwarded-For addresses of the requests that
+// httptest makes, which come from 192.0.2.1.
+func trustTestProxy(t *testing.T, res *Resources) {
+	proxies, err := trustedproxy.Parse("192.0.2.1")
+	require.NoError(t, err)
+	res.SetTrustedProxies(proxies)
+}
+
+func TestResources_SeparateAdmin(t *testing.T) {
+	res := New(auth.NewDatabase(memauth.New()), "endpoint", Tokens{"authToken": RoleAdmin}, nil)
+	res.SeparateAdmin(true)
+
+	exec := func(handler http.Handler, method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer authToken")
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// registration and resolution are public
+	rec := exec(res, "POST", "/v1/access", fmt.Sprintf(`{"access_grant": %q}`, minimalAccess))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var created map[string]string
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	accessKeyID := created["access_key_id"]
+
+	require.Equal(t, http.StatusOK, exec(res, "GET", "/v1/access/"+accessKeyID, "").Code)
+	require.Equal(t, http.StatusOK, exec(res, "GET", "/healthz", "").Code)
+
+	// the admin routes are only served by the admin handler
+	for _, route := range []struct{ method, path string }{
+		{"GET", "/v1/metrics"},
+		{"GET", "/v1/admin/access"},
+		{"GET", "/v2/access"},
+		{"PUT", "/v1/access/" + accessKeyID + "/invalid"},
+		{"DELETE", "/v1/access/" + accessKeyID},
+	} {
+		require.Equal(t, http.StatusNotFound, exec(res, route.method, route.path, `{"reason": "test"}`).Code, route.path)
+	}
+	require.Equal(t, http.StatusOK, exec(res.Admin(), "GET", "/v1/admin/access", "").Code)
+	require.Equal(t, http.StatusOK, exec(res.Admin(), "GET", "/v1/access/"+accessKeyID, "").Code)
+	require.Equal(t, http.StatusOK, exec(res.Admin(), "DELETE", "/v1/access/"+accessKeyID, "").Code)
+
+	// without separation every route is public
+	res.SeparateAdmin(false)
+	require.Equal(t, http.StatusOK, exec(res, "GET", "/v1/admin/access", "").Code)
 }
@@ -0,0 +1,158 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package httpauth
+
+import (
+	"crypto/subtle"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/zeebo/errs"
+
+	"storj.io/stargate/auth"
+)
+
+// Role is what the holder of an auth token is permitted to do.
+type Role string
+
+const (
+	// RoleRegister may register accesses, also in batches, like the
+	// satellite UI does.
+	RoleRegister Role = "register"
+	// RoleRead may read accesses and their history, export and list the
+	// records, and read the metrics.
+	RoleRead Role = "read"
+	// RoleAdmin may do everything, including deleting, invalidating and
+	// restoring accesses and importing records.
+	RoleAdmin Role = "admin"
+)
+
+// Tokens maps auth tokens to their roles.
+type Tokens map[string]Role
+
+// ParseTokens parses auth tokens with their roles, like
+// token1=register,token2=admin. Tokens are separated by commas or newlines.
+func ParseTokens(s string) (Tokens, error) {
+	tokens := make(Tokens)
+	for _, entry := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' }) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		eq := strings.LastIndexByte(entry, '=')
+		if eq <= 0 {
+			return nil, errs.New("invalid auth token: expected token=role")
+		}
+		role := Role(entry[eq+1:])
+		switch role {
+		case RoleRegister, RoleRead, RoleAdmin:
+		default:
+			return nil, errs.New("invalid role %q: must be register, read or admin", role)
+		}
+		tokens[entry[:eq]] = role
+	}
+	return tokens, nil
+}
+
+// ReadTokensFile reads auth tokens with their roles from a file, with one
+// token=role per line.
+func ReadTokensFile(path string) (Tokens, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+	return ParseTokens(string(data))
+}
+
+// SetTokens replaces the auth tokens, like when they are rotated. The
+// previous tokens keep their roles for overlap, so that clients can switch to
+// the new tokens meanwhile. The roles of tokens take precedence over the
+// previous ones. Tokens that are still in their overlap when the tokens are
+// replaced again stay valid until the new overlap ends.
+func (res *Resources) SetTokens(tokens Tokens, overlap time.Duration) {
+	res.tokensMu.Lock()
+	defer res.tokensMu.Unlock()
+
+	now := time.Now()
+	previous := make(Tokens)
+	if now.Before(res.previousUntil) {
+		for token, role := range res.previous {
+			previous[token] = role
+		}
+	}
+	for token, role := range res.tokens {
+		previous[token] = role
+	}
+
+	res.previous, res.previousUntil = previous, now.Add(overlap)
+	res.tokens = tokens
+}
+
+// requestRole returns the role of the auth token of the request, or an empty
+// role if it has none or an unknown one.
+func (res *Resources) requestRole(req *http.Request) Role {
+	return res.Role(req.Header.Get("Authorization"))
+}
+
+// requestOwner returns the owner of the records that the request registers,
+// which is its auth token, or its client ip if it has none. Admin tokens own
+// nothing, so that they have no quota.
+func (res *Resources) requestOwner(req *http.Request) string {
+	switch role := res.requestRole(req); role {
+	case RoleAdmin:
+		return ""
+	case "":
+		return auth.SourceOwner(res.clientIP(req))
+	default:
+		return auth.TokenOwner(strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "))
+	}
+}
+
+// Role returns the role of the auth token of an Authorization header, like
+// "Bearer token", or an empty role if it has none or an unknown one. Every
+// token is compared in constant time, so that the time doesn't tell which
+// tokens exist.
+func (res *Resources) Role(authorization string) Role {
+	header := []byte(authorization)
+
+	res.tokensMu.RLock()
+	defer res.tokensMu.RUnlock()
+
+	var role Role
+	if time.Now().Before(res.previousUntil) {
+		role = matchToken(header, res.previous)
+	}
+	if current := matchToken(header, res.tokens); current != "" {
+		role = current
+	}
+	return role
+}
+
+// matchToken returns the role of the token of the Authorization header in
+// tokens, or an empty role if there is none.
+func matchToken(header []byte, tokens Tokens) Role {
+	var role Role
+	for token, tokenRole := range tokens {
+		if token == "" {
+			continue
+		}
+		if subtle.ConstantTimeCompare(header, []byte("Bearer "+token)) == 1 {
+			role = tokenRole
+		}
+	}
+	return role
+}
+
+// requestAllowed returns whether the auth token of the request has the role,
+// which admin tokens always have.
+func (res *Resources) requestAllowed(req *http.Request, role Role) bool {
+	switch res.requestRole(req) {
+	case role, RoleAdmin:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,396 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package httpauth
+
+import (
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcutil/base58"
+
+	"storj.io/stargate/auth"
+)
+
+// The /v2 routes are for operational tooling. Unlike /v1, their errors are
+// json objects with a stable code, lists can be filtered, responses can be
+// limited to some of their fields with the fields query parameter, and they
+// are compressed for clients that accept gzip.
+
+// codes of the errors of the /v2 routes.
+const (
+	codeBadRequest      = "bad_request"
+	codeUnauthorized    = "unauthorized"
+	codeNotFound        = "not_found"
+	codeInvalid         = "invalid"
+	codeTooManyRequests = "too_many_requests"
+	codeUnavailable     = "unavailable"
+	codeNotImplemented  = "not_implemented"
+	codeInternal        = "internal"
+)
+
+// states of records in /v2 lists.
+const (
+	stateValid   = "valid"
+	stateInvalid = "invalid"
+	stateExpired = "expired"
+	stateDeleted = "deleted"
+)
+
+// v2Error is the error object of the /v2 routes.
+type v2Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeV2Error responds with an error object.
+func writeV2Error(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(struct {
+		Error v2Error `json:"error"`
+	}{v2Error{Code: code, Message: message}})
+}
+
+// writeV2RetryAfter responds with an error object that asks the client to
+// retry after some time.
+func writeV2RetryAfter(w http.ResponseWriter, retryAfter time.Duration, status int, code, message string) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	writeV2Error(w, status, code, message)
+}
+
+// v2DatabaseError responds with the error object of an error of the database.
+// Unlike /v1, accesses that don't exist or are invalid aren't internal errors.
+func v2DatabaseError(w http.ResponseWriter, err error) {
+	var unavailable *auth.UnavailableError
+	switch {
+	case errors.As(err, &unavailable):
+		writeV2RetryAfter(w, unavailable.RetryAfter, http.StatusServiceUnavailable, codeUnavailable, "database unavailable")
+	case auth.NotFound.Has(err):
+		writeV2Error(w, http.StatusNotFound, codeNotFound, "access not found")
+	case auth.Invalid.Has(err):
+		writeV2Error(w, http.StatusGone, codeInvalid, err.Error())
+	case auth.Unsupported.Has(err):
+		writeV2Error(w, http.StatusNotImplemented, codeNotImplemented, err.Error())
+	default:
+		writeV2Error(w, http.StatusInternalServerError, codeInternal, "database error")
+	}
+}
+
+// writeV2JSON responds with v, compressed with gzip if the client accepts it,
+// since lists of records and events are large and repetitive.
+func writeV2JSON(w http.ResponseWriter, req *http.Request, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(req) {
+		_ = json.NewEncoder(w).Encode(v)
+		return
+	}
+
+	w.Header().Set("Content-Encoding", "gzip")
+	compressed := gzip.NewWriter(w)
+	_ = json.NewEncoder(compressed).Encode(v)
+	_ = compressed.Close()
+}
+
+// acceptsGzip returns whether the Accept-Encoding of the request has gzip.
+func acceptsGzip(req *http.Request) bool {
+	for _, encoding := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		encoding = strings.TrimSpace(strings.Split(encoding, ";")[0])
+		if encoding == "gzip" || encoding == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// requestFields returns the fields of the fields query parameter, which must
+// be json fields of the type of item, or nil if the request has none.
+func requestFields(req *http.Request, item interface{}) ([]string, error) {
+	fields := splitList(req.URL.Query().Get("fields"))
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	known := make(map[string]bool)
+	t := reflect.TypeOf(item)
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		known[name] = true
+	}
+	for _, field := range fields {
+		if !known[field] {
+			return nil, errBadRequest.New("unknown field %q", field)
+		}
+	}
+	return fields, nil
+}
+
+// selectFields returns v with only the fields, or v if fields is nil.
+func selectFields(v interface{}, fields []string) (interface{}, error) {
+	if fields == nil {
+		return v, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+	selected := make(map[string]json.RawMessage, len(fields))
+	for _, field := range fields {
+		if value, ok := all[field]; ok {
+			selected[field] = value
+		}
+	}
+	return selected, nil
+}
+
+// v2Access is a resolved access.
+type v2Access struct {
+	AccessKeyID string            `json:"access_key_id"`
+	AccessGrant string            `json:"access_grant"`
+	Routes      []auth.Route      `json:"routes"`
+	SecretKey   string            `json:"secret_key,omitempty"`
+	Public      bool              `json:"public"`
+	Labels      map[string]string `json:"labels"`
+	LastUsedAt  *time.Time        `json:"last_used_at"`
+	UseCount    int64             `json:"use_count"`
+}
+
+// getAccessV2 resolves an access key, like GET /v1/access/{id}. With the
+// fields query parameter, tooling can leave out the secrets.
+func (res *Resources) getAccessV2(w http.ResponseWriter, req *http.Request) {
+	if !res.requestAllowed(req, RoleRead) {
+		writeV2Error(w, http.StatusUnauthorized, codeUnauthorized, "unauthorized")
+		return
+	}
+	fields, err := requestFields(req, v2Access{})
+	if err != nil {
+		writeV2Error(w, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+
+	accessKeyID := res.id.Value(req.Context())
+	limiterKeys := res.limiterKeys(req, accessKeyID)
+	if retryAfter, ok := res.limiter.Allowed(limiterKeys...); !ok {
+		writeV2RetryAfter(w, retryAfter, http.StatusTooManyRequests, codeTooManyRequests, "too many failed attempts")
+		return
+	}
+
+	key, err := parseAccessKeyID(accessKeyID)
+	if err != nil {
+		res.lookupFailed(req, limiterKeys)
+		writeV2Error(w, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+
+	access, err := res.db.Resolve(req.Context(), key)
+	if err != nil {
+		if auth.NotFound.Has(err) || auth.Invalid.Has(err) {
+			res.lookupFailed(req, limiterKeys)
+		}
+		v2DatabaseError(w, err)
+		return
+	}
+
+	response, err := selectFields(v2Access{
+		AccessKeyID: base58.CheckEncode(key[:], auth.VersionAccessKeyID),
+		AccessGrant: access.AccessGrant,
+		Routes:      access.Routes,
+		SecretKey:   res.resolvedSecretKey(access),
+		Public:      access.Public,
+		Labels:      access.Labels,
+		LastUsedAt:  access.LastUsedAt,
+		UseCount:    access.UseCount,
+	}, fields)
+	if err != nil {
+		writeV2Error(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	writeV2JSON(w, req, response)
+}
+
+// v2Record is a listed record.
+type v2Record struct {
+	KeyHash          string            `json:"key_hash"`
+	SatelliteAddress string            `json:"satellite_address"`
+	Public           bool              `json:"public"`
+	State            string            `json:"state"`
+	ExpiresAt        *time.Time        `json:"expires_at"`
+	InvalidReason    string            `json:"invalid_reason"`
+	DeletedAt        *time.Time        `json:"deleted_at"`
+	Labels           map[string]string `json:"labels"`
+	LastUsedAt       *time.Time        `json:"last_used_at"`
+	UseCount         int64             `json:"use_count"`
+}
+
+// recordState returns the state of the record of an entry at now.
+func recordState(entry auth.Entry, now time.Time) string {
+	switch {
+	case entry.DeletedAt != nil:
+		return stateDeleted
+	case entry.InvalidReason != "":
+		return stateInvalid
+	case entry.Record.Expired(now):
+		return stateExpired
+	default:
+		return stateValid
+	}
+}
+
+// v2Filter selects the records of a list.
+type v2Filter struct {
+	satellite string
+	public    *bool
+	state     string
+	labels    map[string]string
+	// unusedSince selects the records that weren't used since then, which
+	// includes the records that were never used.
+	unusedSince time.Time
+}
+
+// requestFilter returns the filter of the query parameters of the request.
+func requestFilter(req *http.Request) (filter v2Filter, err error) {
+	query := req.URL.Query()
+	filter.satellite = query.Get("satellite")
+	if value := query.Get("public"); value != "" {
+		public, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, errBadRequest.New("public must be true or false")
+		}
+		filter.public = &public
+	}
+	switch filter.state = query.Get("state"); filter.state {
+	case "", stateValid, stateInvalid, stateExpired, stateDeleted:
+	default:
+		return filter, errBadRequest.New("state must be %s, %s, %s or %s", stateValid, stateInvalid, stateExpired, stateDeleted)
+	}
+	if value := query.Get("unused_since"); value != "" {
+		if filter.unusedSince, err = time.Parse(time.RFC3339, value); err != nil {
+			return filter, errBadRequest.New("unused_since must be like 2006-01-02T15:04:05Z")
+		}
+	}
+	filter.labels, err = requestLabels(req)
+	return filter, err
+}
+
+// matches returns whether the filter selects the record of the entry in the
+// state.
+func (filter v2Filter) matches(entry auth.Entry, state string) bool {
+	if filter.satellite != "" && entry.Record.SatelliteAddress != filter.satellite {
+		return false
+	}
+	if filter.public != nil && entry.Record.Public != *filter.public {
+		return false
+	}
+	if filter.state != "" && state != filter.state {
+		return false
+	}
+	if lastUsedAt := entry.Record.LastUsedAt; !filter.unusedSince.IsZero() && lastUsedAt != nil && !lastUsedAt.Before(filter.unusedSince) {
+		return false
+	}
+	return auth.MatchLabels(entry.Record.Labels, filter.labels)
+}
+
+// listAccessV2 returns a page of the records in the order of their key
+// hashes, like GET /v1/admin/access, filtered by the satellite, public,
+// state, unused_since and label query parameters. The cursor is opaque: it is
+// the next_cursor of the previous page, which is empty on the last page. A
+// filtered page may have fewer records than the limit before the last one.
+func (res *Resources) listAccessV2(w http.ResponseWriter, req *http.Request) {
+	if !res.requestAllowed(req, RoleRead) {
+		writeV2Error(w, http.StatusUnauthorized, codeUnauthorized, "unauthorized")
+		return
+	}
+	fields, err := requestFields(req, v2Record{})
+	if err != nil {
+		writeV2Error(w, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+	filter, err := requestFilter(req)
+	if err != nil {
+		writeV2Error(w, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+
+	query := req.URL.Query()
+
+	var after auth.KeyHash
+	if cursor := query.Get("cursor"); cursor != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil || len(decoded) != len(after) {
+			writeV2Error(w, http.StatusBadRequest, codeBadRequest, "invalid cursor")
+			return
+		}
+		copy(after[:], decoded)
+	}
+
+	limit := defaultListLimit
+	if value := query.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxListLimit {
+			writeV2Error(w, http.StatusBadRequest, codeBadRequest, "limit must be between 1 and "+strconv.Itoa(maxListLimit))
+			return
+		}
+	}
+
+	response := struct {
+		Records    []interface{} `json:"records"`
+		NextCursor string        `json:"next_cursor"`
+	}{Records: []interface{}{}}
+
+	now := time.Now()
+	for pages := 0; pages < maxListPages && len(response.Records) < limit; pages++ {
+		requested := limit - len(response.Records)
+		entries, err := auth.List(req.Context(), res.db.KV(), after, requested)
+		if err != nil {
+			v2DatabaseError(w, err)
+			return
+		}
+
+		for _, entry := range entries {
+			state := recordState(entry, now)
+			if !filter.matches(entry, state) {
+				continue
+			}
+			record, err := selectFields(v2Record{
+				KeyHash:          hex.EncodeToString(entry.KeyHash[:]),
+				SatelliteAddress: entry.Record.SatelliteAddress,
+				Public:           entry.Record.Public,
+				State:            state,
+				ExpiresAt:        entry.Record.ExpiresAt,
+				InvalidReason:    entry.InvalidReason,
+				DeletedAt:        entry.DeletedAt,
+				Labels:           entry.Record.Labels,
+				LastUsedAt:       entry.Record.LastUsedAt,
+				UseCount:         entry.Record.UseCount,
+			}, fields)
+			if err != nil {
+				writeV2Error(w, http.StatusInternalServerError, codeInternal, err.Error())
+				return
+			}
+			response.Records = append(response.Records, record)
+		}
+
+		if len(entries) < requested {
+			response.NextCursor = ""
+			break
+		}
+		after = entries[len(entries)-1].KeyHash
+		response.NextCursor = base64.RawURLEncoding.EncodeToString(after[:])
+	}
+
+	writeV2JSON(w, req, response)
+}
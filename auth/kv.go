@@ -5,6 +5,9 @@ package auth
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"time"
 
 	"github.com/zeebo/errs"
 )
@@ -12,19 +15,98 @@ import (
 // Invalid is the class of error that is returned for invalid records.
 var Invalid = errs.Class("invalid")
 
+// Undecryptable is the class of error for records whose encryption is
+// damaged, as opposed to keys that are unavailable.
+var Undecryptable = errs.Class("undecryptable")
+
+// Unsupported is the class of error for optional capabilities of key/value
+// stores that a key/value store doesn't have.
+var Unsupported = errs.Class("unsupported")
+
+// UnavailableError is returned by key/value stores that are temporarily
+// unavailable, like when a circuit breaker stops calls to an unhealthy
+// database.
+type UnavailableError struct {
+	// RetryAfter is how long until the key/value store may be available again.
+	RetryAfter time.Duration
+}
+
+// Error implements error.
+func (err *UnavailableError) Error() string {
+	return fmt.Sprintf("key/value store unavailable: retry after %s", err.RetryAfter)
+}
+
 // Record is a key/value store record.
 type Record struct {
 	SatelliteAddress     string
 	MacaroonHead         []byte // 32 bytes probably
-	EncryptedSecretKey   []byte
+	EncryptedSecretKey   []byte // or the hash of a derived secret key, see SecretKeyHashed
 	EncryptedAccessGrant []byte
-	Public               bool // if true, knowledge of secret key is not required
+	Public               bool              // if true, knowledge of secret key is not required
+	CreatedAt            *time.Time        // when the record was stored, if the key/value store keeps it; Put ignores it
+	ExpiresAt            *time.Time        // if set, the record is invalid from this time on
+	Labels               map[string]string // user supplied labels like a team, nil if there are none
+	LastUsedAt           *time.Time        // when the key was last resolved, nil if it never was
+	UseCount             int64             // how often the key was resolved
+	Owner                string            // who registered the record for quotas, like TokenOwner, or empty
+}
+
+// Expired returns whether the record has an expiration that has passed at now.
+func (r *Record) Expired(now time.Time) bool {
+	return r.ExpiresAt != nil && !now.Before(*r.ExpiresAt)
+}
+
+// ErrExpired returns the Invalid error that Get returns for expired records.
+func ErrExpired(record *Record) error {
+	return Invalid.New("expired at %s", record.ExpiresAt.UTC().Format(time.RFC3339))
 }
 
 // KeyHash is the key portion of the key/value store.
 type KeyHash [32]byte
 
+// Entry is a record together with the key it is stored under.
+type Entry struct {
+	KeyHash KeyHash
+	Record  *Record
+
+	// InvalidReason is set by Iterate for records that have been invalidated.
+	// PutBatch ignores it.
+	InvalidReason string
+
+	// DeletedAt is set by Iterate for records that have been soft deleted.
+	// PutBatch ignores it.
+	DeletedAt *time.Time
+}
+
+// The actions of history events.
+const (
+	HistoryCreated     = "created"
+	HistoryInvalidated = "invalidated"
+	HistoryDeleted     = "deleted"
+	HistoryRestored    = "restored"
+)
+
+// HistoryEvent is a change to the record of a key, like its creation or
+// invalidation, together with who made it and from where.
+type HistoryEvent struct {
+	Action   string    `json:"action"`
+	At       time.Time `json:"at"`
+	Actor    string    `json:"actor,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+	SourceIP string    `json:"source_ip,omitempty"`
+}
+
 // KV is an abstract key/value store of KeyHash to Records.
+//
+// Key/value stores that hold resources, like database connections, are
+// io.Closers, and are released with Close.
+//
+// Key/value stores may have optional capabilities, like BatchKV, which are
+// separate interfaces whose names end in KV. They are used through the
+// functions of the same names as their methods, which fall back to the
+// methods of KV, or fail with Unsupported errors, for stores without them.
+// Wrappers of other stores implement every capability with these functions,
+// so that they have the capabilities of the stores they wrap.
 type KV interface {
 	// Put stores the record in the key/value store.
 	// It is an error if the key already exists.
@@ -32,7 +114,7 @@ type KV interface {
 
 	// Get retrieves the record from the key/value store.
 	// It returns nil if the key does not exist.
-	// If the record is invalid, the error contains why.
+	// If the record is invalid or expired, the error contains why.
 	Get(ctx context.Context, keyHash KeyHash) (record *Record, err error)
 
 	// Delete removes the record from the key/value store.
@@ -44,3 +126,294 @@ type KV interface {
 	// It does not update the invalid reason if the record is already invalid.
 	Invalidate(ctx context.Context, keyHash KeyHash, reason string) error
 }
+
+// Close releases the resources held by kv, if it is an io.Closer.
+func Close(kv KV) error {
+	if closer, ok := kv.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// BatchKV is a KV that stores and retrieves several records at once.
+type BatchKV interface {
+	// PutBatch stores all of the records in the key/value store.
+	// It is an error if any of the keys already exist. Backends that support
+	// transactions store either all or none of the records.
+	PutBatch(ctx context.Context, entries []Entry) (err error)
+
+	// GetBatch retrieves the records for all of the keys from the key/value store.
+	// The returned records are in the same order as the keys, and a record is nil
+	// if its key does not exist or if it is invalid or expired.
+	GetBatch(ctx context.Context, keyHashes []KeyHash) (records []*Record, err error)
+}
+
+// PutBatch calls PutBatch of kv if it is a BatchKV, and stores the records
+// one at a time with PutBatchSequentially otherwise.
+func PutBatch(ctx context.Context, kv KV, entries []Entry) error {
+	if batch, ok := kv.(BatchKV); ok {
+		return batch.PutBatch(ctx, entries)
+	}
+	return PutBatchSequentially(ctx, kv, entries)
+}
+
+// GetBatch calls GetBatch of kv if it is a BatchKV, and retrieves the
+// records one at a time with GetBatchSequentially otherwise.
+func GetBatch(ctx context.Context, kv KV, keyHashes []KeyHash) ([]*Record, error) {
+	if batch, ok := kv.(BatchKV); ok {
+		return batch.GetBatch(ctx, keyHashes)
+	}
+	return GetBatchSequentially(ctx, kv, keyHashes)
+}
+
+// SweepingKV is a KV that deletes the records that are no longer used at
+// once, which the Sweeper needs.
+type SweepingKV interface {
+	// DeleteUnused removes the records that were invalidated or that expired
+	// before asOf, and returns how many were removed.
+	DeleteUnused(ctx context.Context, asOf time.Time) (deleted int64, err error)
+}
+
+// DeleteUnused calls DeleteUnused of kv if it is a SweepingKV.
+func DeleteUnused(ctx context.Context, kv KV, asOf time.Time) (deleted int64, err error) {
+	sweeping, ok := kv.(SweepingKV)
+	if !ok {
+		return 0, Unsupported.New("the key/value store can't delete unused records")
+	}
+	return sweeping.DeleteUnused(ctx, asOf)
+}
+
+// ListingKV is a KV whose records can be walked, like to migrate or check
+// them.
+type ListingKV interface {
+	// Iterate calls fn for every record in the key/value store in no particular
+	// order, including invalid, expired and soft deleted records. Records stored
+	// or deleted while iterating may or may not be seen. Iteration stops at the
+	// first error returned by fn, and Iterate returns that error.
+	Iterate(ctx context.Context, fn func(ctx context.Context, entry Entry) error) (err error)
+
+	// List returns up to limit records with key hashes after the given key hash
+	// in the order of their key hashes, including invalid, expired and soft
+	// deleted records, so that the records can be paged through. Listing after
+	// the zero key hash starts at the first record, and a page with fewer than
+	// limit records is the last one.
+	List(ctx context.Context, after KeyHash, limit int) (entries []Entry, err error)
+}
+
+// Iterate calls Iterate of kv if it is a ListingKV.
+func Iterate(ctx context.Context, kv KV, fn func(ctx context.Context, entry Entry) error) error {
+	listing, ok := kv.(ListingKV)
+	if !ok {
+		return Unsupported.New("the key/value store can't list records")
+	}
+	return listing.Iterate(ctx, fn)
+}
+
+// List calls List of kv if it is a ListingKV.
+func List(ctx context.Context, kv KV, after KeyHash, limit int) (entries []Entry, err error) {
+	listing, ok := kv.(ListingKV)
+	if !ok {
+		return nil, Unsupported.New("the key/value store can't list records")
+	}
+	return listing.List(ctx, after, limit)
+}
+
+// RevokingKV is a KV that invalidates every record of a macaroon head at
+// once, like when its API key is revoked.
+type RevokingKV interface {
+	// InvalidateByMacaroonHead causes every record with the macaroon head to
+	// become invalid, and returns how many were invalidated. Records that are
+	// already invalid keep their invalid reason and are not counted.
+	InvalidateByMacaroonHead(ctx context.Context, macaroonHead []byte, reason string) (invalidated int64, err error)
+}
+
+// InvalidateByMacaroonHead calls InvalidateByMacaroonHead of kv if it is a RevokingKV.
+func InvalidateByMacaroonHead(ctx context.Context, kv KV, macaroonHead []byte, reason string) (invalidated int64, err error) {
+	revoking, ok := kv.(RevokingKV)
+	if !ok {
+		return 0, Unsupported.New("the key/value store can't invalidate records by macaroon head")
+	}
+	return revoking.InvalidateByMacaroonHead(ctx, macaroonHead, reason)
+}
+
+// SoftDeletingKV is a KV that keeps deleted records for a while, so that
+// they can be restored.
+type SoftDeletingKV interface {
+	// SoftDelete marks the record as deleted, after which Get and GetBatch treat
+	// it as if it does not exist until it is restored or purged.
+	// It is not an error if the key does not exist.
+	// It does not update the deletion time if the record is already deleted.
+	SoftDelete(ctx context.Context, keyHash KeyHash) error
+
+	// Restore undoes SoftDelete, and returns whether the record was deleted.
+	// It is not an error if the key does not exist.
+	Restore(ctx context.Context, keyHash KeyHash) (restored bool, err error)
+
+	// PurgeDeleted removes the records that were soft deleted before asOf, and
+	// returns how many were removed.
+	PurgeDeleted(ctx context.Context, asOf time.Time) (purged int64, err error)
+}
+
+// SoftDelete calls SoftDelete of kv if it is a SoftDeletingKV.
+func SoftDelete(ctx context.Context, kv KV, keyHash KeyHash) error {
+	softDeleting, ok := kv.(SoftDeletingKV)
+	if !ok {
+		return Unsupported.New("the key/value store can't soft delete records")
+	}
+	return softDeleting.SoftDelete(ctx, keyHash)
+}
+
+// Restore calls Restore of kv if it is a SoftDeletingKV.
+func Restore(ctx context.Context, kv KV, keyHash KeyHash) (restored bool, err error) {
+	softDeleting, ok := kv.(SoftDeletingKV)
+	if !ok {
+		return false, Unsupported.New("the key/value store can't soft delete records")
+	}
+	return softDeleting.Restore(ctx, keyHash)
+}
+
+// PurgeDeleted calls PurgeDeleted of kv if it is a SoftDeletingKV.
+func PurgeDeleted(ctx context.Context, kv KV, asOf time.Time) (purged int64, err error) {
+	softDeleting, ok := kv.(SoftDeletingKV)
+	if !ok {
+		return 0, Unsupported.New("the key/value store can't soft delete records")
+	}
+	return softDeleting.PurgeDeleted(ctx, asOf)
+}
+
+// HistoryKV is a KV that keeps the histories of keys.
+type HistoryKV interface {
+	// AppendHistory adds the event to the history of the key. The key/value
+	// store sets the time of the event. Histories are append only, and they
+	// are kept when the record of their key is deleted.
+	AppendHistory(ctx context.Context, keyHash KeyHash, event HistoryEvent) error
+
+	// History returns the events of the key in the order they were appended.
+	// It returns no events if the key has no history.
+	History(ctx context.Context, keyHash KeyHash) (events []HistoryEvent, err error)
+}
+
+// AppendHistory calls AppendHistory of kv if it is a HistoryKV.
+func AppendHistory(ctx context.Context, kv KV, keyHash KeyHash, event HistoryEvent) error {
+	history, ok := kv.(HistoryKV)
+	if !ok {
+		return Unsupported.New("the key/value store doesn't keep histories")
+	}
+	return history.AppendHistory(ctx, keyHash, event)
+}
+
+// History calls History of kv if it is a HistoryKV.
+func History(ctx context.Context, kv KV, keyHash KeyHash) (events []HistoryEvent, err error) {
+	history, ok := kv.(HistoryKV)
+	if !ok {
+		return nil, Unsupported.New("the key/value store doesn't keep histories")
+	}
+	return history.History(ctx, keyHash)
+}
+
+// HealthCheckingKV is a KV that can check whether it can be used.
+type HealthCheckingKV interface {
+	// HealthCheck returns an error if the key/value store can't be used, like
+	// when its database can't be reached or it lacks permissions.
+	HealthCheck(ctx context.Context) (err error)
+}
+
+// HealthCheck calls HealthCheck of kv if it is a HealthCheckingKV, and gets
+// a record otherwise, which fails like other calls if kv can't be used.
+func HealthCheck(ctx context.Context, kv KV) error {
+	if checking, ok := kv.(HealthCheckingKV); ok {
+		return checking.HealthCheck(ctx)
+	}
+	_, err := kv.Get(ctx, KeyHash{})
+	if Invalid.Has(err) {
+		return nil
+	}
+	return err
+}
+
+// UpdatingKV is a KV that changes records atomically.
+type UpdatingKV interface {
+	// Update atomically changes the entry of the key, so that fields can be
+	// changed without racing other changes. fn is called with a copy of the
+	// current entry, including invalid and soft deleted records, and the entry
+	// it leaves is stored if it returns true, unless the entry changed since
+	// it was read. Then fn is called again with the changed entry, so it may be
+	// called more than once. Update returns whether the entry was stored.
+	// It is not an error if the key does not exist, in which case fn is not
+	// called. The key hash of the entry can't be changed.
+	Update(ctx context.Context, keyHash KeyHash, fn func(entry *Entry) (store bool, err error)) (updated bool, err error)
+}
+
+// Update calls Update of kv if it is a UpdatingKV.
+func Update(ctx context.Context, kv KV, keyHash KeyHash, fn func(entry *Entry) (store bool, err error)) (updated bool, err error) {
+	updating, ok := kv.(UpdatingKV)
+	if !ok {
+		return false, Unsupported.New("the key/value store can't update records")
+	}
+	return updating.Update(ctx, keyHash, fn)
+}
+
+// UsageKV is a KV that counts the uses of keys.
+type UsageKV interface {
+	// RecordUse adds uses to the use count of the key, and sets when it was
+	// last used to at, unless it was used later. Only RecordUse is meant to
+	// change the use of records, and backends may ignore the use of records
+	// that are put or updated. It is not an error if the key does not exist.
+	RecordUse(ctx context.Context, keyHash KeyHash, at time.Time, uses int64) error
+}
+
+// RecordUse calls RecordUse of kv if it is a UsageKV.
+func RecordUse(ctx context.Context, kv KV, keyHash KeyHash, at time.Time, uses int64) error {
+	usage, ok := kv.(UsageKV)
+	if !ok {
+		return Unsupported.New("the key/value store doesn't count uses")
+	}
+	return usage.RecordUse(ctx, keyHash, at, uses)
+}
+
+// CountingKV is a KV that counts the records of owners, which quotas need.
+type CountingKV interface {
+	// CountActive returns how many records of the owner are active at now,
+	// which are the records that are neither invalid, soft deleted nor
+	// expired.
+	CountActive(ctx context.Context, owner string, now time.Time) (count int64, err error)
+}
+
+// CountActive calls CountActive of kv if it is a CountingKV.
+func CountActive(ctx context.Context, kv KV, owner string, now time.Time) (count int64, err error) {
+	counting, ok := kv.(CountingKV)
+	if !ok {
+		return 0, Unsupported.New("the key/value store can't count the records of owners")
+	}
+	return counting.CountActive(ctx, owner, now)
+}
+
+// PutBatchSequentially implements PutBatch by calling Put for every entry. It is
+// a fallback for backends without native batch support, and it is not atomic:
+// the records stored before an error are kept.
+func PutBatchSequentially(ctx context.Context, kv KV, entries []Entry) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	for _, entry := range entries {
+		if err := kv.Put(ctx, entry.KeyHash, entry.Record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetBatchSequentially implements GetBatch by calling Get for every key. It is
+// a fallback for backends without native batch support.
+func GetBatchSequentially(ctx context.Context, kv KV, keyHashes []KeyHash) (records []*Record, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	records = make([]*Record, len(keyHashes))
+	for i, keyHash := range keyHashes {
+		record, err := kv.Get(ctx, keyHash)
+		if err != nil && !Invalid.Has(err) {
+			return nil, err
+		}
+		records[i] = record
+	}
+	return records, nil
+}
@@ -0,0 +1,104 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package auth_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"storj.io/stargate/auth"
+	"storj.io/stargate/auth/memauth"
+)
+
+// coreKV only has the methods of auth.KV, and none of the optional
+// capabilities of the key/value store it embeds.
+type coreKV struct {
+	auth.KV
+}
+
+func TestFallbacks(t *testing.T) {
+	ctx := context.Background()
+	kv := coreKV{memauth.New()}
+
+	// batches are stored and retrieved one record at a time
+	entries := []auth.Entry{
+		{KeyHash: auth.KeyHash{1}, Record: &auth.Record{SatelliteAddress: "first"}},
+		{KeyHash: auth.KeyHash{2}, Record: &auth.Record{SatelliteAddress: "second"}},
+	}
+	require.NoError(t, auth.PutBatch(ctx, kv, entries))
+	records, err := auth.GetBatch(ctx, kv, []auth.KeyHash{{2}, {3}, {1}})
+	require.NoError(t, err)
+	require.Equal(t, []*auth.Record{entries[1].Record, nil, entries[0].Record}, records)
+
+	// the health check gets a record
+	require.NoError(t, auth.HealthCheck(ctx, kv))
+
+	require.NoError(t, auth.Close(kv))
+}
+
+func TestUnsupported(t *testing.T) {
+	ctx := context.Background()
+	kv := coreKV{memauth.New()}
+
+	_, err := auth.DeleteUnused(ctx, kv, time.Now())
+	require.True(t, auth.Unsupported.Has(err), "expected an unsupported error, got %v", err)
+
+	// the sweeper stops instead of failing at every interval
+	sweeper := auth.NewSweeper(zaptest.NewLogger(t), kv, auth.SweeperConfig{Interval: time.Hour})
+	require.NoError(t, sweeper.Run(ctx))
+
+	err = auth.Iterate(ctx, kv, func(ctx context.Context, entry auth.Entry) error { return nil })
+	require.True(t, auth.Unsupported.Has(err), "expected an unsupported error, got %v", err)
+	_, err = auth.List(ctx, kv, auth.KeyHash{}, 10)
+	require.True(t, auth.Unsupported.Has(err), "expected an unsupported error, got %v", err)
+	_, err = auth.InvalidateByMacaroonHead(ctx, kv, []byte("head"), "revoked")
+	require.True(t, auth.Unsupported.Has(err), "expected an unsupported error, got %v", err)
+	err = auth.SoftDelete(ctx, kv, auth.KeyHash{})
+	require.True(t, auth.Unsupported.Has(err), "expected an unsupported error, got %v", err)
+
+	// the usage tracker stops counting uses after its first flush
+	err = auth.RecordUse(ctx, kv, auth.KeyHash{}, time.Now(), 1)
+	require.True(t, auth.Unsupported.Has(err), "expected an unsupported error, got %v", err)
+	tracker := auth.NewUsageTracker(zaptest.NewLogger(t), kv, auth.UsageConfig{Interval: time.Hour})
+	tracker.Use(auth.KeyHash{1})
+	require.Zero(t, tracker.Flush(ctx))
+	tracker.Use(auth.KeyHash{1})
+	_, uses := tracker.Pending(auth.KeyHash{1})
+	require.Zero(t, uses)
+}
+
+func TestDatabase_CoreKV(t *testing.T) {
+	ctx := context.Background()
+	kv := coreKV{memauth.New()}
+	db := auth.NewDatabase(kv)
+
+	_, err := db.Put(ctx, auth.EncryptionKey{1}, minimalAccess, nil, false, nil, nil)
+	require.NoError(t, err)
+
+	// accesses have no history, but appending to it isn't an error
+	require.NoError(t, db.AppendHistory(ctx, auth.EncryptionKey{1}, auth.HistoryEvent{Action: auth.HistoryCreated}))
+	_, err = db.History(ctx, auth.EncryptionKey{1})
+	require.True(t, auth.Unsupported.Has(err), "expected an unsupported error, got %v", err)
+
+	// whether accesses were valid is looked up before invalidating them
+	invalidated, err := db.Invalidate(ctx, auth.EncryptionKey{1}, "invalid")
+	require.NoError(t, err)
+	require.True(t, invalidated)
+	invalidated, err = db.Invalidate(ctx, auth.EncryptionKey{1}, "again")
+	require.NoError(t, err)
+	require.False(t, invalidated)
+
+	// accesses are deleted right away, so there is nothing to restore
+	require.NoError(t, db.SoftDelete(ctx, auth.EncryptionKey{1}))
+	record, err := kv.Get(ctx, auth.EncryptionKey{1}.Hash())
+	require.NoError(t, err)
+	require.Nil(t, record)
+	restored, err := db.Restore(ctx, auth.EncryptionKey{1})
+	require.NoError(t, err)
+	require.False(t, restored)
+}
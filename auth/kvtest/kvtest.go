@@ -0,0 +1,795 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package kvtest is a conformance test suite for implementations of auth.KV.
+package kvtest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/stargate/auth"
+)
+
+// RunTests runs the conformance tests against KVs constructed with newKV.
+// Every subtest gets its own KV, which is closed at the end of the subtest.
+// The tests of optional capabilities, like auth.SweepingKV, are skipped for
+// KVs without them.
+func RunTests(t *testing.T, newKV func() auth.KV) {
+	for _, test := range []struct {
+		name string
+		run  func(ctx context.Context, t *testing.T, kv auth.KV)
+	}{
+		{"PutGet", testPutGet},
+		{"PutCollision", testPutCollision},
+		{"GetMissing", testGetMissing},
+		{"Delete", testDelete},
+		{"SoftDelete", testSoftDelete},
+		{"Invalidate", testInvalidate},
+		{"Update", testUpdate},
+		{"InvalidateByMacaroonHead", testInvalidateByMacaroonHead},
+		{"Expiration", testExpiration},
+		{"DeleteUnused", testDeleteUnused},
+		{"RecordUse", testRecordUse},
+		{"CountActive", testCountActive},
+		{"PutBatch", testPutBatch},
+		{"GetBatch", testGetBatch},
+		{"History", testHistory},
+		{"Iterate", testIterate},
+		{"List", testList},
+		{"Concurrent", testConcurrent},
+		{"HealthCheck", testHealthCheck},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			kv := newKV()
+			defer func() { require.NoError(t, auth.Close(kv)) }()
+
+			test.run(context.Background(), t, kv)
+		})
+	}
+}
+
+// randomKeyHash returns a random key hash so that tests never collide.
+func randomKeyHash(t *testing.T) (keyHash auth.KeyHash) {
+	_, err := rand.Read(keyHash[:])
+	require.NoError(t, err)
+	return keyHash
+}
+
+// randomRecord returns a record with random contents.
+func randomRecord(t *testing.T) *auth.Record {
+	random := func(n int) []byte {
+		data := make([]byte, n)
+		_, err := rand.Read(data)
+		require.NoError(t, err)
+		return data
+	}
+
+	return &auth.Record{
+		SatelliteAddress:     "satellite.example.test:7777",
+		MacaroonHead:         random(32),
+		EncryptedSecretKey:   random(48),
+		EncryptedAccessGrant: random(128),
+		Public:               true,
+		Labels:               map[string]string{"team": "storage", "ticket": "OPS-1"},
+	}
+}
+
+func requireRecord(t *testing.T, expected, actual *auth.Record) {
+	require.NotNil(t, actual)
+	require.Equal(t, expected.SatelliteAddress, actual.SatelliteAddress)
+	require.Equal(t, expected.MacaroonHead, actual.MacaroonHead)
+	require.Equal(t, expected.EncryptedSecretKey, actual.EncryptedSecretKey)
+	require.Equal(t, expected.EncryptedAccessGrant, actual.EncryptedAccessGrant)
+	require.Equal(t, expected.Public, actual.Public)
+	require.Equal(t, expected.Labels, actual.Labels)
+	if expected.ExpiresAt == nil {
+		require.Nil(t, actual.ExpiresAt)
+	} else {
+		require.NotNil(t, actual.ExpiresAt)
+		require.WithinDuration(t, *expected.ExpiresAt, *actual.ExpiresAt, time.Second)
+	}
+}
+
+func testPutGet(ctx context.Context, t *testing.T, kv auth.KV) {
+	keyHash, record := randomKeyHash(t), randomRecord(t)
+
+	require.NoError(t, kv.Put(ctx, keyHash, record))
+
+	fetched, err := kv.Get(ctx, keyHash)
+	require.NoError(t, err)
+	requireRecord(t, record, fetched)
+}
+
+func testPutCollision(ctx context.Context, t *testing.T, kv auth.KV) {
+	keyHash, record := randomKeyHash(t), randomRecord(t)
+
+	require.NoError(t, kv.Put(ctx, keyHash, record))
+	require.Error(t, kv.Put(ctx, keyHash, randomRecord(t)))
+
+	// the original record is untouched
+	fetched, err := kv.Get(ctx, keyHash)
+	require.NoError(t, err)
+	requireRecord(t, record, fetched)
+}
+
+func testGetMissing(ctx context.Context, t *testing.T, kv auth.KV) {
+	fetched, err := kv.Get(ctx, randomKeyHash(t))
+	require.NoError(t, err)
+	require.Nil(t, fetched)
+}
+
+func testDelete(ctx context.Context, t *testing.T, kv auth.KV) {
+	keyHash := randomKeyHash(t)
+
+	// deleting a missing key is not an error
+	require.NoError(t, kv.Delete(ctx, keyHash))
+
+	require.NoError(t, kv.Put(ctx, keyHash, randomRecord(t)))
+	require.NoError(t, kv.Delete(ctx, keyHash))
+
+	fetched, err := kv.Get(ctx, keyHash)
+	require.NoError(t, err)
+	require.Nil(t, fetched)
+
+	// the key can be reused after it is deleted
+	require.NoError(t, kv.Put(ctx, keyHash, randomRecord(t)))
+}
+
+func testSoftDelete(ctx context.Context, t *testing.T, kv auth.KV) {
+	if _, ok := kv.(auth.SoftDeletingKV); !ok {
+		t.Skip("not a SoftDeletingKV")
+	}
+
+	keyHash, other, missing := randomKeyHash(t), randomKeyHash(t), randomKeyHash(t)
+	record := randomRecord(t)
+
+	// soft deleting or restoring a missing key is not an error
+	require.NoError(t, auth.SoftDelete(ctx, kv, missing))
+	restored, err := auth.Restore(ctx, kv, missing)
+	require.NoError(t, err)
+	require.False(t, restored)
+
+	require.NoError(t, kv.Put(ctx, keyHash, record))
+	require.NoError(t, kv.Put(ctx, other, randomRecord(t)))
+	require.NoError(t, auth.SoftDelete(ctx, kv, keyHash))
+	require.NoError(t, auth.SoftDelete(ctx, kv, keyHash))
+
+	// soft deleted records look missing but still occupy their key
+	fetched, err := kv.Get(ctx, keyHash)
+	require.NoError(t, err)
+	require.Nil(t, fetched)
+	records, err := auth.GetBatch(ctx, kv, []auth.KeyHash{keyHash})
+	require.NoError(t, err)
+	require.Nil(t, records[0])
+	require.Error(t, kv.Put(ctx, keyHash, randomRecord(t)))
+
+	// but updates see that they are deleted
+	if _, ok := kv.(auth.UpdatingKV); ok {
+		updated, err := auth.Update(ctx, kv, keyHash, func(entry *auth.Entry) (bool, error) {
+			require.NotNil(t, entry.DeletedAt)
+			return false, nil
+		})
+		require.NoError(t, err)
+		require.False(t, updated)
+	}
+
+	restored, err = auth.Restore(ctx, kv, keyHash)
+	require.NoError(t, err)
+	require.True(t, restored)
+	restored, err = auth.Restore(ctx, kv, keyHash)
+	require.NoError(t, err)
+	require.False(t, restored)
+
+	fetched, err = kv.Get(ctx, keyHash)
+	require.NoError(t, err)
+	requireRecord(t, record, fetched)
+
+	// only records deleted before asOf are purged
+	require.NoError(t, auth.SoftDelete(ctx, kv, keyHash))
+	purged, err := auth.PurgeDeleted(ctx, kv, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.EqualValues(t, 0, purged)
+
+	purged, err = auth.PurgeDeleted(ctx, kv, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.EqualValues(t, 1, purged)
+
+	restored, err = auth.Restore(ctx, kv, keyHash)
+	require.NoError(t, err)
+	require.False(t, restored)
+	require.NoError(t, kv.Put(ctx, keyHash, randomRecord(t)))
+
+	fetched, err = kv.Get(ctx, other)
+	require.NoError(t, err)
+	require.NotNil(t, fetched)
+}
+
+func testInvalidate(ctx context.Context, t *testing.T, kv auth.KV) {
+	keyHash := randomKeyHash(t)
+
+	// invalidating a missing key is not an error
+	require.NoError(t, kv.Invalidate(ctx, randomKeyHash(t), "missing"))
+
+	require.NoError(t, kv.Put(ctx, keyHash, randomRecord(t)))
+	require.NoError(t, kv.Invalidate(ctx, keyHash, "first"))
+
+	fetched, err := kv.Get(ctx, keyHash)
+	require.True(t, auth.Invalid.Has(err), "expected an invalid error, got %v", err)
+	require.Contains(t, err.Error(), "first")
+	require.Nil(t, fetched)
+
+	// invalidating again is not an error and keeps the first reason
+	require.NoError(t, kv.Invalidate(ctx, keyHash, "second"))
+
+	_, err = kv.Get(ctx, keyHash)
+	require.True(t, auth.Invalid.Has(err), "expected an invalid error, got %v", err)
+	require.Contains(t, err.Error(), "first")
+	require.NotContains(t, err.Error(), "second")
+}
+
+func testUpdate(ctx context.Context, t *testing.T, kv auth.KV) {
+	if _, ok := kv.(auth.UpdatingKV); !ok {
+		t.Skip("not a UpdatingKV")
+	}
+
+	keyHash, record := randomKeyHash(t), randomRecord(t)
+
+	// updating a missing key does not call fn
+	updated, err := auth.Update(ctx, kv, randomKeyHash(t), func(entry *auth.Entry) (bool, error) {
+		t.Error("fn called for a missing key")
+		return true, nil
+	})
+	require.NoError(t, err)
+	require.False(t, updated)
+
+	require.NoError(t, kv.Put(ctx, keyHash, record))
+
+	// nothing is stored unless fn returns true
+	updated, err = auth.Update(ctx, kv, keyHash, func(entry *auth.Entry) (bool, error) {
+		require.Equal(t, keyHash, entry.KeyHash)
+		requireRecord(t, record, entry.Record)
+		entry.Record.Public = !entry.Record.Public
+		entry.Record.Labels["team"] = "changed"
+		return false, nil
+	})
+	require.NoError(t, err)
+	require.False(t, updated)
+
+	fetched, err := kv.Get(ctx, keyHash)
+	require.NoError(t, err)
+	requireRecord(t, record, fetched)
+
+	// the entry is stored if fn returns true
+	updated, err = auth.Update(ctx, kv, keyHash, func(entry *auth.Entry) (bool, error) {
+		entry.Record.Public = !entry.Record.Public
+		entry.Record.Labels = map[string]string{"team": "gateway"}
+		return true, nil
+	})
+	require.NoError(t, err)
+	require.True(t, updated)
+
+	fetched, err = kv.Get(ctx, keyHash)
+	require.NoError(t, err)
+	require.Equal(t, !record.Public, fetched.Public)
+	require.Equal(t, map[string]string{"team": "gateway"}, fetched.Labels)
+	require.Equal(t, record.EncryptedAccessGrant, fetched.EncryptedAccessGrant)
+
+	// invalid records are passed to fn
+	updated, err = auth.Update(ctx, kv, keyHash, func(entry *auth.Entry) (bool, error) {
+		require.Empty(t, entry.InvalidReason)
+		entry.InvalidReason = "updated"
+		return true, nil
+	})
+	require.NoError(t, err)
+	require.True(t, updated)
+
+	_, err = kv.Get(ctx, keyHash)
+	require.True(t, auth.Invalid.Has(err), "expected an invalid error, got %v", err)
+	require.Contains(t, err.Error(), "updated")
+
+	updated, err = auth.Update(ctx, kv, keyHash, func(entry *auth.Entry) (bool, error) {
+		require.Equal(t, "updated", entry.InvalidReason)
+		return false, nil
+	})
+	require.NoError(t, err)
+	require.False(t, updated)
+}
+
+func testInvalidateByMacaroonHead(ctx context.Context, t *testing.T, kv auth.KV) {
+	if _, ok := kv.(auth.RevokingKV); !ok {
+		t.Skip("not a RevokingKV")
+	}
+
+	// invalidating a missing macaroon head is not an error
+	invalidated, err := auth.InvalidateByMacaroonHead(ctx, kv, randomRecord(t).MacaroonHead, "missing")
+	require.NoError(t, err)
+	require.EqualValues(t, 0, invalidated)
+
+	revoked, other := randomRecord(t).MacaroonHead, randomRecord(t).MacaroonHead
+
+	var derived []auth.KeyHash
+	for i := 0; i < 3; i++ {
+		keyHash, record := randomKeyHash(t), randomRecord(t)
+		record.MacaroonHead = revoked
+		require.NoError(t, kv.Put(ctx, keyHash, record))
+		derived = append(derived, keyHash)
+	}
+	require.NoError(t, kv.Invalidate(ctx, derived[0], "first"))
+
+	unrelated, unrelatedRecord := randomKeyHash(t), randomRecord(t)
+	unrelatedRecord.MacaroonHead = other
+	require.NoError(t, kv.Put(ctx, unrelated, unrelatedRecord))
+
+	// records that are already invalid are not counted and keep their reason
+	invalidated, err = auth.InvalidateByMacaroonHead(ctx, kv, revoked, "revoked")
+	require.NoError(t, err)
+	require.EqualValues(t, 2, invalidated)
+
+	_, err = kv.Get(ctx, derived[0])
+	require.True(t, auth.Invalid.Has(err), "expected an invalid error, got %v", err)
+	require.Contains(t, err.Error(), "first")
+	for _, keyHash := range derived[1:] {
+		_, err = kv.Get(ctx, keyHash)
+		require.True(t, auth.Invalid.Has(err), "expected an invalid error, got %v", err)
+		require.Contains(t, err.Error(), "revoked")
+	}
+
+	fetched, err := kv.Get(ctx, unrelated)
+	require.NoError(t, err)
+	requireRecord(t, unrelatedRecord, fetched)
+
+	// invalidating again finds nothing left to invalidate
+	invalidated, err = auth.InvalidateByMacaroonHead(ctx, kv, revoked, "again")
+	require.NoError(t, err)
+	require.EqualValues(t, 0, invalidated)
+}
+
+func testExpiration(ctx context.Context, t *testing.T, kv auth.KV) {
+	future, past := time.Now().Add(time.Hour), time.Now().Add(-time.Hour)
+
+	live, expired := randomKeyHash(t), randomKeyHash(t)
+	liveRecord, expiredRecord := randomRecord(t), randomRecord(t)
+	liveRecord.ExpiresAt = &future
+	expiredRecord.ExpiresAt = &past
+
+	require.NoError(t, kv.Put(ctx, live, liveRecord))
+	require.NoError(t, kv.Put(ctx, expired, expiredRecord))
+
+	fetched, err := kv.Get(ctx, live)
+	require.NoError(t, err)
+	requireRecord(t, liveRecord, fetched)
+
+	fetched, err = kv.Get(ctx, expired)
+	require.True(t, auth.Invalid.Has(err), "expected an invalid error, got %v", err)
+	require.Nil(t, fetched)
+
+	records, err := auth.GetBatch(ctx, kv, []auth.KeyHash{live, expired})
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	requireRecord(t, liveRecord, records[0])
+	require.Nil(t, records[1])
+
+	// expired records still occupy their key until they are deleted
+	require.Error(t, kv.Put(ctx, expired, randomRecord(t)))
+	require.NoError(t, kv.Delete(ctx, expired))
+	require.NoError(t, kv.Put(ctx, expired, randomRecord(t)))
+}
+
+func testDeleteUnused(ctx context.Context, t *testing.T, kv auth.KV) {
+	if _, ok := kv.(auth.SweepingKV); !ok {
+		t.Skip("not a SweepingKV")
+	}
+
+	now := time.Now()
+	future, past := now.Add(time.Hour), now.Add(-2*time.Hour)
+
+	live, expired, invalid := randomKeyHash(t), randomKeyHash(t), randomKeyHash(t)
+	liveRecord, expiredRecord := randomRecord(t), randomRecord(t)
+	liveRecord.ExpiresAt = &future
+	expiredRecord.ExpiresAt = &past
+
+	require.NoError(t, kv.Put(ctx, live, liveRecord))
+	require.NoError(t, kv.Put(ctx, expired, expiredRecord))
+	require.NoError(t, kv.Put(ctx, invalid, randomRecord(t)))
+	require.NoError(t, kv.Invalidate(ctx, invalid, "invalid"))
+
+	// only the record that expired before asOf is deleted
+	deleted, err := auth.DeleteUnused(ctx, kv, now.Add(-time.Hour))
+	require.NoError(t, err)
+	require.EqualValues(t, 1, deleted)
+	require.NoError(t, kv.Put(ctx, expired, randomRecord(t)))
+
+	// the record invalidated before asOf is deleted as well
+	deleted, err = auth.DeleteUnused(ctx, kv, now.Add(time.Minute))
+	require.NoError(t, err)
+	require.EqualValues(t, 1, deleted)
+	require.NoError(t, kv.Put(ctx, invalid, randomRecord(t)))
+
+	fetched, err := kv.Get(ctx, live)
+	require.NoError(t, err)
+	requireRecord(t, liveRecord, fetched)
+}
+
+func testRecordUse(ctx context.Context, t *testing.T, kv auth.KV) {
+	if _, ok := kv.(auth.UsageKV); !ok {
+		t.Skip("not a UsageKV")
+	}
+
+	keyHash, record := randomKeyHash(t), randomRecord(t)
+	record.Owner = "token:owner"
+	require.NoError(t, kv.Put(ctx, keyHash, record))
+
+	fetched, err := kv.Get(ctx, keyHash)
+	require.NoError(t, err)
+	require.Nil(t, fetched.LastUsedAt)
+	require.Zero(t, fetched.UseCount)
+
+	// uses add up, and the last use is the latest one even when uses are
+	// recorded out of order
+	now := time.Now()
+	require.NoError(t, auth.RecordUse(ctx, kv, keyHash, now, 2))
+	require.NoError(t, auth.RecordUse(ctx, kv, keyHash, now.Add(-time.Hour), 3))
+
+	fetched, err = kv.Get(ctx, keyHash)
+	require.NoError(t, err)
+	requireRecord(t, record, fetched)
+	require.EqualValues(t, 5, fetched.UseCount)
+	require.NotNil(t, fetched.LastUsedAt)
+	require.WithinDuration(t, now, *fetched.LastUsedAt, time.Second)
+
+	// listings have the uses and the owner too
+	if _, ok := kv.(auth.ListingKV); ok {
+		entries, err := auth.List(ctx, kv, auth.KeyHash{}, 10)
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		require.EqualValues(t, 5, entries[0].Record.UseCount)
+		require.Equal(t, "token:owner", entries[0].Record.Owner)
+	}
+
+	// updates see the usage and the owner, and keep them
+	if _, ok := kv.(auth.UpdatingKV); ok {
+		updated, err := auth.Update(ctx, kv, keyHash, func(entry *auth.Entry) (bool, error) {
+			require.EqualValues(t, 5, entry.Record.UseCount)
+			require.NotNil(t, entry.Record.LastUsedAt)
+			require.Equal(t, "token:owner", entry.Record.Owner)
+			entry.Record.Public = !entry.Record.Public
+			return true, nil
+		})
+		require.NoError(t, err)
+		require.True(t, updated)
+
+		fetched, err = kv.Get(ctx, keyHash)
+		require.NoError(t, err)
+		require.EqualValues(t, 5, fetched.UseCount)
+		require.Equal(t, "token:owner", fetched.Owner)
+	}
+
+	// it is not an error if the key does not exist
+	require.NoError(t, auth.RecordUse(ctx, kv, randomKeyHash(t), now, 1))
+}
+
+func testCountActive(ctx context.Context, t *testing.T, kv auth.KV) {
+	if _, ok := kv.(auth.CountingKV); !ok {
+		t.Skip("not a CountingKV")
+	}
+
+	past := time.Now().Add(-time.Hour)
+
+	owned := func() (auth.KeyHash, *auth.Record) {
+		keyHash, record := randomKeyHash(t), randomRecord(t)
+		record.Owner = "token:owner"
+		require.NoError(t, kv.Put(ctx, keyHash, record))
+		return keyHash, record
+	}
+
+	owned()
+	invalid, _ := owned()
+	deleted, _ := owned()
+	expired, expiredRecord := randomKeyHash(t), randomRecord(t)
+	expiredRecord.Owner = "token:owner"
+	expiredRecord.ExpiresAt = &past
+	require.NoError(t, kv.Put(ctx, expired, expiredRecord))
+	require.NoError(t, kv.Put(ctx, randomKeyHash(t), randomRecord(t)))
+
+	count, err := auth.CountActive(ctx, kv, "token:owner", time.Now())
+	require.NoError(t, err)
+	require.EqualValues(t, 3, count)
+
+	// invalid, soft deleted and expired records are not active
+	require.NoError(t, kv.Invalidate(ctx, invalid, "invalid"))
+	active := int64(2)
+	if _, ok := kv.(auth.SoftDeletingKV); ok {
+		require.NoError(t, auth.SoftDelete(ctx, kv, deleted))
+		active--
+	}
+
+	count, err = auth.CountActive(ctx, kv, "token:owner", time.Now())
+	require.NoError(t, err)
+	require.Equal(t, active, count)
+
+	count, err = auth.CountActive(ctx, kv, "", time.Now())
+	require.NoError(t, err)
+	require.EqualValues(t, 1, count)
+
+	count, err = auth.CountActive(ctx, kv, "ip:192.0.2.1", time.Now())
+	require.NoError(t, err)
+	require.Zero(t, count)
+}
+
+func testPutBatch(ctx context.Context, t *testing.T, kv auth.KV) {
+	entries := make([]auth.Entry, 10)
+	for i := range entries {
+		entries[i] = auth.Entry{KeyHash: randomKeyHash(t), Record: randomRecord(t)}
+	}
+
+	require.NoError(t, auth.PutBatch(ctx, kv, entries))
+	require.NoError(t, auth.PutBatch(ctx, kv, nil))
+
+	for _, entry := range entries {
+		fetched, err := kv.Get(ctx, entry.KeyHash)
+		require.NoError(t, err)
+		requireRecord(t, entry.Record, fetched)
+	}
+
+	// a batch containing an existing key fails
+	collision := []auth.Entry{
+		{KeyHash: randomKeyHash(t), Record: randomRecord(t)},
+		entries[0],
+	}
+	require.Error(t, auth.PutBatch(ctx, kv, collision))
+}
+
+func testGetBatch(ctx context.Context, t *testing.T, kv auth.KV) {
+	present, invalid, missing := randomKeyHash(t), randomKeyHash(t), randomKeyHash(t)
+	record := randomRecord(t)
+
+	require.NoError(t, kv.Put(ctx, present, record))
+	require.NoError(t, kv.Put(ctx, invalid, randomRecord(t)))
+	require.NoError(t, kv.Invalidate(ctx, invalid, "invalid"))
+
+	records, err := auth.GetBatch(ctx, kv, []auth.KeyHash{missing, present, invalid, present})
+	require.NoError(t, err)
+	require.Len(t, records, 4)
+	require.Nil(t, records[0])
+	requireRecord(t, record, records[1])
+	require.Nil(t, records[2])
+	requireRecord(t, record, records[3])
+
+	records, err = auth.GetBatch(ctx, kv, nil)
+	require.NoError(t, err)
+	require.Len(t, records, 0)
+}
+
+func testHistory(ctx context.Context, t *testing.T, kv auth.KV) {
+	if _, ok := kv.(auth.HistoryKV); !ok {
+		t.Skip("not a HistoryKV")
+	}
+
+	keyHash, other := randomKeyHash(t), randomKeyHash(t)
+
+	events, err := auth.History(ctx, kv, keyHash)
+	require.NoError(t, err)
+	require.Empty(t, events)
+
+	expected := []auth.HistoryEvent{
+		{Action: auth.HistoryCreated, SourceIP: "10.0.0.1"},
+		{Action: auth.HistoryInvalidated, Actor: "admin", Reason: "leaked", SourceIP: "10.0.0.2"},
+		{Action: auth.HistoryDeleted, Actor: "admin"},
+	}
+	require.NoError(t, kv.Put(ctx, keyHash, randomRecord(t)))
+	for _, event := range expected {
+		require.NoError(t, auth.AppendHistory(ctx, kv, keyHash, event))
+	}
+	require.NoError(t, auth.AppendHistory(ctx, kv, other, auth.HistoryEvent{Action: auth.HistoryCreated}))
+
+	// the history outlives the record
+	require.NoError(t, kv.Delete(ctx, keyHash))
+
+	events, err = auth.History(ctx, kv, keyHash)
+	require.NoError(t, err)
+	require.Len(t, events, len(expected))
+	for i, event := range events {
+		require.WithinDuration(t, time.Now(), event.At, time.Minute)
+		if i > 0 {
+			require.False(t, event.At.Before(events[i-1].At), "events out of order")
+		}
+		event.At = time.Time{}
+		require.Equal(t, expected[i], event)
+	}
+}
+
+func testIterate(ctx context.Context, t *testing.T, kv auth.KV) {
+	if _, ok := kv.(auth.SoftDeletingKV); !ok {
+		t.Skip("not a SoftDeletingKV")
+	}
+	if _, ok := kv.(auth.ListingKV); !ok {
+		t.Skip("not a ListingKV")
+	}
+
+	past := time.Now().Add(-time.Hour)
+
+	expected := make(map[auth.KeyHash]*auth.Record)
+	for i := 0; i < 10; i++ {
+		keyHash, record := randomKeyHash(t), randomRecord(t)
+		if i == 0 {
+			record.ExpiresAt = &past
+		}
+		require.NoError(t, kv.Put(ctx, keyHash, record))
+		expected[keyHash] = record
+	}
+
+	invalid := randomKeyHash(t)
+	expected[invalid] = randomRecord(t)
+	require.NoError(t, kv.Put(ctx, invalid, expected[invalid]))
+	require.NoError(t, kv.Invalidate(ctx, invalid, "invalid"))
+
+	deleted := randomKeyHash(t)
+	expected[deleted] = randomRecord(t)
+	require.NoError(t, kv.Put(ctx, deleted, expected[deleted]))
+	require.NoError(t, auth.SoftDelete(ctx, kv, deleted))
+
+	// every record is seen exactly once, including invalid, expired and
+	// soft deleted ones
+	seen := make(map[auth.KeyHash]bool)
+	require.NoError(t, auth.Iterate(ctx, kv, func(ctx context.Context, entry auth.Entry) error {
+		require.False(t, seen[entry.KeyHash], "record seen twice")
+		seen[entry.KeyHash] = true
+
+		requireRecord(t, expected[entry.KeyHash], entry.Record)
+		if entry.KeyHash == invalid {
+			require.Equal(t, "invalid", entry.InvalidReason)
+		} else {
+			require.Empty(t, entry.InvalidReason)
+		}
+		require.Equal(t, entry.KeyHash == deleted, entry.DeletedAt != nil)
+		return nil
+	}))
+	require.Len(t, seen, len(expected))
+
+	// iteration stops at the first error
+	stop := errors.New("stop")
+	calls := 0
+	err := auth.Iterate(ctx, kv, func(ctx context.Context, entry auth.Entry) error {
+		calls++
+		return stop
+	})
+	require.True(t, errors.Is(err, stop), "expected the error of fn, got %v", err)
+	require.Equal(t, 1, calls)
+}
+
+func testList(ctx context.Context, t *testing.T, kv auth.KV) {
+	if _, ok := kv.(auth.ListingKV); !ok {
+		t.Skip("not a ListingKV")
+	}
+	if _, ok := kv.(auth.SoftDeletingKV); !ok {
+		t.Skip("not a SoftDeletingKV")
+	}
+
+	entries, err := auth.List(ctx, kv, auth.KeyHash{}, 10)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	expected := make(map[auth.KeyHash]*auth.Record)
+	for i := 0; i < 7; i++ {
+		keyHash, record := randomKeyHash(t), randomRecord(t)
+		require.NoError(t, kv.Put(ctx, keyHash, record))
+		expected[keyHash] = record
+	}
+
+	invalid := randomKeyHash(t)
+	expected[invalid] = randomRecord(t)
+	require.NoError(t, kv.Put(ctx, invalid, expected[invalid]))
+	require.NoError(t, kv.Invalidate(ctx, invalid, "invalid"))
+
+	deleted := randomKeyHash(t)
+	expected[deleted] = randomRecord(t)
+	require.NoError(t, kv.Put(ctx, deleted, expected[deleted]))
+	require.NoError(t, auth.SoftDelete(ctx, kv, deleted))
+
+	// paging through the records sees every record exactly once in the order
+	// of their key hashes, including invalid and soft deleted ones
+	var listed []auth.Entry
+	var after auth.KeyHash
+	for {
+		page, err := auth.List(ctx, kv, after, 4)
+		require.NoError(t, err)
+		require.True(t, len(page) <= 4, "page has %d records", len(page))
+		listed = append(listed, page...)
+		if len(page) < 4 {
+			break
+		}
+		after = page[len(page)-1].KeyHash
+	}
+	require.Len(t, listed, len(expected))
+
+	for i, entry := range listed {
+		if i > 0 {
+			require.True(t, bytes.Compare(listed[i-1].KeyHash[:], entry.KeyHash[:]) < 0, "records out of order")
+		}
+		requireRecord(t, expected[entry.KeyHash], entry.Record)
+		if entry.KeyHash == invalid {
+			require.Equal(t, "invalid", entry.InvalidReason)
+		} else {
+			require.Empty(t, entry.InvalidReason)
+		}
+		require.Equal(t, entry.KeyHash == deleted, entry.DeletedAt != nil)
+	}
+
+	// listing after the last record returns nothing
+	entries, err = auth.List(ctx, kv, listed[len(listed)-1].KeyHash, 4)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func testConcurrent(ctx context.Context, t *testing.T, kv auth.KV) {
+	const workers = 10
+
+	// every worker races to store a record under the same key, and exactly one wins
+	keyHash := randomKeyHash(t)
+	records := make([]*auth.Record, workers)
+	errors := make([]error, workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		records[i] = randomRecord(t)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errors[i] = kv.Put(ctx, keyHash, records[i])
+		}(i)
+	}
+	wg.Wait()
+
+	winner := -1
+	for i, err := range errors {
+		if err == nil {
+			require.Equal(t, -1, winner, "more than one concurrent put succeeded")
+			winner = i
+		}
+	}
+	require.NotEqual(t, -1, winner, "no concurrent put succeeded")
+
+	fetched, err := kv.Get(ctx, keyHash)
+	require.NoError(t, err)
+	requireRecord(t, records[winner], fetched)
+
+	// concurrent reads, invalidations and deletes of distinct keys don't interfere
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			keyHash, record := randomKeyHash(t), randomRecord(t)
+			if err := kv.Put(ctx, keyHash, record); err != nil {
+				t.Error(err)
+				return
+			}
+			if fetched, err := kv.Get(ctx, keyHash); err != nil || fetched == nil {
+				t.Error("record missing after put", err)
+			}
+			if err := kv.Invalidate(ctx, keyHash, "concurrent"); err != nil {
+				t.Error(err)
+			}
+			if err := kv.Delete(ctx, keyHash); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func testHealthCheck(ctx context.Context, t *testing.T, kv auth.KV) {
+	require.NoError(t, auth.HealthCheck(ctx, kv))
+
+	require.NoError(t, kv.Put(ctx, randomKeyHash(t), randomRecord(t)))
+	require.NoError(t, auth.HealthCheck(ctx, kv))
+}
@@ -0,0 +1,62 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package auth
+
+import (
+	"github.com/zeebo/errs"
+)
+
+// LabelError is the class of errors for invalid labels.
+var LabelError = errs.Class("label")
+
+// Limits of the labels of an access, which are stored unencrypted with its
+// record, so that accesses can be attributed to owners like teams.
+const (
+	MaxLabels           = 16
+	MaxLabelKeyLength   = 63
+	MaxLabelValueLength = 255
+)
+
+// ValidateLabels checks that there are at most MaxLabels labels, and that
+// their keys are lowercase letters, digits, '-', '_' and '.', starting with a
+// letter, and that their values aren't longer than MaxLabelValueLength bytes.
+func ValidateLabels(labels map[string]string) error {
+	if len(labels) > MaxLabels {
+		return LabelError.New("at most %d labels are allowed, got %d", MaxLabels, len(labels))
+	}
+	for key, value := range labels {
+		if !validLabelKey(key) {
+			return LabelError.New("label key %q must be 1 to %d lowercase letters, digits, '-', '_' and '.', starting with a letter", key, MaxLabelKeyLength)
+		}
+		if len(value) > MaxLabelValueLength {
+			return LabelError.New("label %q has a value longer than %d bytes", key, MaxLabelValueLength)
+		}
+	}
+	return nil
+}
+
+// validLabelKey returns whether key is a valid label key.
+func validLabelKey(key string) bool {
+	if len(key) == 0 || len(key) > MaxLabelKeyLength || key[0] < 'a' || key[0] > 'z' {
+		return false
+	}
+	for _, c := range []byte(key) {
+		switch {
+		case 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// MatchLabels returns whether labels has every label of selector.
+func MatchLabels(labels, selector map[string]string) bool {
+	for key, value := range selector {
+		if actual, ok := labels[key]; !ok || actual != value {
+			return false
+		}
+	}
+	return true
+}
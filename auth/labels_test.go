@@ -0,0 +1,54 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package auth_test
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/stargate/auth"
+)
+
+func TestValidateLabels(t *testing.T) {
+	require.NoError(t, auth.ValidateLabels(nil))
+	require.NoError(t, auth.ValidateLabels(map[string]string{
+		"team":        "storage",
+		"environment": "",
+		"ticket.id":   "OPS-1",
+		"cost_center": strings.Repeat("x", auth.MaxLabelValueLength),
+	}))
+
+	tooMany := make(map[string]string)
+	for i := 0; i <= auth.MaxLabels; i++ {
+		tooMany["label"+strconv.Itoa(i)] = "value"
+	}
+
+	for _, labels := range []map[string]string{
+		tooMany,
+		{"": "empty key"},
+		{"Team": "uppercase"},
+		{"1team": "leading digit"},
+		{"team name": "space"},
+		{strings.Repeat("k", auth.MaxLabelKeyLength+1): "long key"},
+		{"team": strings.Repeat("x", auth.MaxLabelValueLength+1)},
+	} {
+		err := auth.ValidateLabels(labels)
+		require.Error(t, err, labels)
+		require.True(t, auth.LabelError.Has(err))
+	}
+}
+
+func TestMatchLabels(t *testing.T) {
+	labels := map[string]string{"team": "storage", "environment": "production"}
+
+	require.True(t, auth.MatchLabels(labels, nil))
+	require.True(t, auth.MatchLabels(labels, map[string]string{"team": "storage"}))
+	require.True(t, auth.MatchLabels(labels, labels))
+	require.False(t, auth.MatchLabels(labels, map[string]string{"team": "gateway"}))
+	require.False(t, auth.MatchLabels(labels, map[string]string{"owner": "storage"}))
+	require.False(t, auth.MatchLabels(nil, map[string]string{"team": "storage"}))
+}
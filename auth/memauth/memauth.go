@@ -4,8 +4,12 @@
 package memauth
 
 import (
+	"bytes"
 	"context"
+	"net/url"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/spacemonkeygo/monkit/v3"
 	"github.com/zeebo/errs"
@@ -15,21 +19,88 @@ import (
 
 var mon = monkit.Package()
 
+func init() {
+	auth.RegisterKV("memory", func(ctx context.Context, databaseURL string) (auth.KV, error) {
+		return OpenURL(databaseURL)
+	})
+}
+
+// OpenURL constructs the KV of a database url. memory:// is a KV that is lost
+// on restart, and memory:///path/to/records.json?interval=1m a PersistentKV
+// that is kept in the snapshot file at the path.
+func OpenURL(databaseURL string) (auth.KV, error) {
+	parsed, err := url.Parse(databaseURL)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	if parsed.Path == "" || parsed.Path == "/" {
+		return New(), nil
+	}
+
+	interval := DefaultSnapshotInterval
+	if value := parsed.Query().Get("interval"); value != "" {
+		interval, err = time.ParseDuration(value)
+		if err != nil || interval <= 0 {
+			return nil, Error.New("invalid snapshot interval %q", value)
+		}
+	}
+	return Open(parsed.Path, interval)
+}
+
 // KV is a key/value store backed by an in memory map.
 type KV struct {
 	mu      sync.Mutex
 	entries map[auth.KeyHash]*auth.Record
-	invalid map[auth.KeyHash]string
+	invalid map[auth.KeyHash]invalidation
+	deleted map[auth.KeyHash]time.Time
+	heads   map[string]map[auth.KeyHash]struct{} // keys by macaroon head
+	history map[auth.KeyHash][]auth.HistoryEvent
+}
+
+// invalidation records why and when a record was invalidated.
+type invalidation struct {
+	reason string
+	at     time.Time
 }
 
 // New constructs a KV.
 func New() *KV {
 	return &KV{
 		entries: make(map[auth.KeyHash]*auth.Record),
-		invalid: make(map[auth.KeyHash]string),
+		invalid: make(map[auth.KeyHash]invalidation),
+		deleted: make(map[auth.KeyHash]time.Time),
+		heads:   make(map[string]map[auth.KeyHash]struct{}),
+		history: make(map[auth.KeyHash][]auth.HistoryEvent),
 	}
 }
 
+// store adds the record to the entries and to the macaroon head index.
+// It must be called with the mutex held.
+func (d *KV) store(keyHash auth.KeyHash, record *auth.Record) {
+	d.entries[keyHash] = record
+
+	keys, ok := d.heads[string(record.MacaroonHead)]
+	if !ok {
+		keys = make(map[auth.KeyHash]struct{})
+		d.heads[string(record.MacaroonHead)] = keys
+	}
+	keys[keyHash] = struct{}{}
+}
+
+// remove removes the record from the entries, from the macaroon head index and
+// from the soft deleted records. It must be called with the mutex held.
+func (d *KV) remove(keyHash auth.KeyHash) {
+	if record, ok := d.entries[keyHash]; ok {
+		keys := d.heads[string(record.MacaroonHead)]
+		delete(keys, keyHash)
+		if len(keys) == 0 {
+			delete(d.heads, string(record.MacaroonHead))
+		}
+	}
+	delete(d.entries, keyHash)
+	delete(d.deleted, keyHash)
+}
+
 // Put stores the record in the key/value store.
 // It is an error if the key already exists.
 func (d *KV) Put(ctx context.Context, keyHash auth.KeyHash, record *auth.Record) (err error) {
@@ -42,7 +113,32 @@ func (d *KV) Put(ctx context.Context, keyHash auth.KeyHash, record *auth.Record)
 		return errs.New("record already exists")
 	}
 
-	d.entries[keyHash] = record
+	d.store(keyHash, record)
+	return nil
+}
+
+// PutBatch stores all of the records in the key/value store.
+// It is an error if any of the keys already exist, in which case none are stored.
+func (d *KV) PutBatch(ctx context.Context, entries []auth.Entry) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	seen := make(map[auth.KeyHash]struct{}, len(entries))
+	for _, entry := range entries {
+		if _, ok := d.entries[entry.KeyHash]; ok {
+			return errs.New("record already exists")
+		}
+		if _, ok := seen[entry.KeyHash]; ok {
+			return errs.New("record already exists")
+		}
+		seen[entry.KeyHash] = struct{}{}
+	}
+
+	for _, entry := range entries {
+		d.store(entry.KeyHash, entry.Record)
+	}
 	return nil
 }
 
@@ -54,11 +150,42 @@ func (d *KV) Get(ctx context.Context, keyHash auth.KeyHash) (record *auth.Record
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	if reason, ok := d.invalid[keyHash]; ok {
-		return nil, auth.Invalid.New("%s", reason)
+	if _, ok := d.deleted[keyHash]; ok {
+		return nil, nil
+	}
+	if invalid, ok := d.invalid[keyHash]; ok {
+		return nil, auth.Invalid.New("%s", invalid.reason)
 	}
 
-	return d.entries[keyHash], nil
+	record = d.entries[keyHash]
+	if record != nil && record.Expired(time.Now()) {
+		return nil, auth.ErrExpired(record)
+	}
+	return record, nil
+}
+
+// GetBatch retrieves the records for all of the keys from the key/value store.
+// A record is nil if its key does not exist or if it is invalid or expired.
+func (d *KV) GetBatch(ctx context.Context, keyHashes []auth.KeyHash) (records []*auth.Record, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	records = make([]*auth.Record, len(keyHashes))
+	for i, keyHash := range keyHashes {
+		if _, ok := d.deleted[keyHash]; ok {
+			continue
+		}
+		if _, ok := d.invalid[keyHash]; ok {
+			continue
+		}
+		if record := d.entries[keyHash]; record != nil && !record.Expired(now) {
+			records[i] = record
+		}
+	}
+	return records, nil
 }
 
 // Delete removes the record from the key/value store.
@@ -69,11 +196,60 @@ func (d *KV) Delete(ctx context.Context, keyHash auth.KeyHash) (err error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	delete(d.entries, keyHash)
+	d.remove(keyHash)
 	delete(d.invalid, keyHash)
 	return nil
 }
 
+// SoftDelete marks the record as deleted until it is restored or purged.
+// It is not an error if the key does not exist.
+// It does not update the deletion time if the record is already deleted.
+func (d *KV) SoftDelete(ctx context.Context, keyHash auth.KeyHash) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.entries[keyHash]; !ok {
+		return nil
+	}
+	if _, ok := d.deleted[keyHash]; !ok {
+		d.deleted[keyHash] = time.Now()
+	}
+	return nil
+}
+
+// Restore undoes SoftDelete, and returns whether the record was deleted.
+// It is not an error if the key does not exist.
+func (d *KV) Restore(ctx context.Context, keyHash auth.KeyHash) (restored bool, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, restored = d.deleted[keyHash]
+	delete(d.deleted, keyHash)
+	return restored, nil
+}
+
+// PurgeDeleted removes the records that were soft deleted before asOf, and
+// returns how many were removed.
+func (d *KV) PurgeDeleted(ctx context.Context, asOf time.Time) (purged int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for keyHash, deletedAt := range d.deleted {
+		if deletedAt.Before(asOf) {
+			d.remove(keyHash)
+			delete(d.invalid, keyHash)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
 // Invalidate causes the record to become invalid.
 // It is not an error if the key does not exist.
 // It does not update the invalid reason if the record is already invalid.
@@ -83,9 +259,245 @@ func (d *KV) Invalidate(ctx context.Context, keyHash auth.KeyHash, reason string
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
+	if _, ok := d.entries[keyHash]; !ok {
+		return nil
+	}
 	if _, ok := d.invalid[keyHash]; !ok {
-		d.invalid[keyHash] = reason
+		d.invalid[keyHash] = invalidation{reason: reason, at: time.Now()}
+	}
+
+	return nil
+}
+
+// Update atomically changes the entry of the key with fn, and returns whether
+// the entry was stored. fn is called with the mutex held, so it is called once.
+// It is not an error if the key does not exist.
+func (d *KV) Update(ctx context.Context, keyHash auth.KeyHash, fn func(entry *auth.Entry) (store bool, err error)) (updated bool, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	current, ok := d.entries[keyHash]
+	if !ok {
+		return false, nil
+	}
+
+	// fn may change the labels even if it doesn't store them
+	record := *current
+	if current.Labels != nil {
+		record.Labels = make(map[string]string, len(current.Labels))
+		for k, v := range current.Labels {
+			record.Labels[k] = v
+		}
 	}
+	entry := auth.Entry{KeyHash: keyHash, Record: &record, InvalidReason: d.invalid[keyHash].reason}
+	if deletedAt, ok := d.deleted[keyHash]; ok {
+		entry.DeletedAt = &deletedAt
+	}
+	if store, err := fn(&entry); err != nil || !store {
+		return false, err
+	}
+	if entry.Record == nil {
+		return false, errs.New("record is required")
+	}
+
+	d.remove(keyHash)
+	d.store(keyHash, entry.Record)
+	switch {
+	case entry.InvalidReason == "":
+		delete(d.invalid, keyHash)
+	case entry.InvalidReason != d.invalid[keyHash].reason:
+		d.invalid[keyHash] = invalidation{reason: entry.InvalidReason, at: time.Now()}
+	}
+	if entry.DeletedAt != nil {
+		d.deleted[keyHash] = *entry.DeletedAt
+	}
+	return true, nil
+}
+
+// InvalidateByMacaroonHead causes every record with the macaroon head to
+// become invalid, and returns how many were invalidated. Records that are
+// already invalid keep their invalid reason and are not counted.
+func (d *KV) InvalidateByMacaroonHead(ctx context.Context, macaroonHead []byte, reason string) (invalidated int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	for keyHash := range d.heads[string(macaroonHead)] {
+		if _, ok := d.invalid[keyHash]; !ok {
+			d.invalid[keyHash] = invalidation{reason: reason, at: now}
+			invalidated++
+		}
+	}
+	return invalidated, nil
+}
+
+// DeleteUnused removes the records that were invalidated or that expired
+// before asOf, and returns how many were removed.
+func (d *KV) DeleteUnused(ctx context.Context, asOf time.Time) (deleted int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for keyHash, record := range d.entries {
+		if record.ExpiresAt != nil && record.ExpiresAt.Before(asOf) {
+			d.remove(keyHash)
+			delete(d.invalid, keyHash)
+			deleted++
+		}
+	}
+	for keyHash, invalid := range d.invalid {
+		if invalid.at.Before(asOf) {
+			if _, ok := d.entries[keyHash]; ok {
+				d.remove(keyHash)
+				deleted++
+			}
+			delete(d.invalid, keyHash)
+		}
+	}
+	return deleted, nil
+}
+
+// RecordUse adds uses to the use count of the key, and sets when it was last
+// used to at, unless it was used later. The record is replaced by a changed
+// copy, since the records that Get returned may still be read.
+// It is not an error if the key does not exist.
+func (d *KV) RecordUse(ctx context.Context, keyHash auth.KeyHash, at time.Time, uses int64) (err error) {
+	defer mon.Task()(&ctx)(&err)
 
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	current, ok := d.entries[keyHash]
+	if !ok {
+		return nil
+	}
+	record := *current
+	record.UseCount += uses
+	if record.LastUsedAt == nil || at.After(*record.LastUsedAt) {
+		record.LastUsedAt = &at
+	}
+	d.entries[keyHash] = &record
 	return nil
 }
+
+// CountActive returns how many records of the owner are active at now.
+func (d *KV) CountActive(ctx context.Context, owner string, now time.Time) (count int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for keyHash, record := range d.entries {
+		if record.Owner != owner || record.Expired(now) {
+			continue
+		}
+		if _, ok := d.invalid[keyHash]; ok {
+			continue
+		}
+		if _, ok := d.deleted[keyHash]; ok {
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
+
+// AppendHistory adds the event to the history of the key.
+func (d *KV) AppendHistory(ctx context.Context, keyHash auth.KeyHash, event auth.HistoryEvent) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	event.At = time.Now()
+	d.history[keyHash] = append(d.history[keyHash], event)
+	return nil
+}
+
+// History returns the events of the key in the order they were appended.
+func (d *KV) History(ctx context.Context, keyHash auth.KeyHash) (events []auth.HistoryEvent, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]auth.HistoryEvent(nil), d.history[keyHash]...), nil
+}
+
+// Iterate calls fn for every record in the key/value store, including invalid,
+// expired and soft deleted records. It iterates over a snapshot of the records,
+// so fn may modify the key/value store.
+func (d *KV) Iterate(ctx context.Context, fn func(ctx context.Context, entry auth.Entry) error) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	d.mu.Lock()
+	entries := make([]auth.Entry, 0, len(d.entries))
+	for keyHash := range d.entries {
+		entries = append(entries, d.entryLocked(keyHash))
+	}
+	d.mu.Unlock()
+
+	for _, entry := range entries {
+		if err := fn(ctx, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// List returns up to limit records with key hashes after the given key hash
+// in the order of their key hashes, including invalid, expired and soft
+// deleted records.
+func (d *KV) List(ctx context.Context, after auth.KeyHash, limit int) (entries []auth.Entry, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if limit <= 0 {
+		return nil, nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	keyHashes := make([]auth.KeyHash, 0, len(d.entries))
+	for keyHash := range d.entries {
+		if bytes.Compare(keyHash[:], after[:]) > 0 {
+			keyHashes = append(keyHashes, keyHash)
+		}
+	}
+	sort.Slice(keyHashes, func(i, j int) bool {
+		return bytes.Compare(keyHashes[i][:], keyHashes[j][:]) < 0
+	})
+	if len(keyHashes) > limit {
+		keyHashes = keyHashes[:limit]
+	}
+
+	for _, keyHash := range keyHashes {
+		entries = append(entries, d.entryLocked(keyHash))
+	}
+	return entries, nil
+}
+
+// entryLocked returns the entry of the stored key. It must be called with the
+// mutex held.
+func (d *KV) entryLocked(keyHash auth.KeyHash) auth.Entry {
+	entry := auth.Entry{
+		KeyHash:       keyHash,
+		Record:        d.entries[keyHash],
+		InvalidReason: d.invalid[keyHash].reason,
+	}
+	if deletedAt, ok := d.deleted[keyHash]; ok {
+		entry.DeletedAt = &deletedAt
+	}
+	return entry
+}
+
+// HealthCheck always succeeds.
+func (d *KV) HealthCheck(ctx context.Context) (err error) { return nil }
+
+// Close releases any resources held by the key/value store.
+func (d *KV) Close() error { return nil }
@@ -0,0 +1,108 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package memauth_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/stargate/auth"
+	"storj.io/stargate/auth/kvtest"
+	"storj.io/stargate/auth/memauth"
+)
+
+func TestKV(t *testing.T) {
+	kvtest.RunTests(t, func() auth.KV { return memauth.New() })
+}
+
+func TestPersistentKV(t *testing.T) {
+	dir, err := ioutil.TempDir("", "memauth")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	var n int
+	kvtest.RunTests(t, func() auth.KV {
+		n++
+		kv, err := memauth.Open(filepath.Join(dir, strconv.Itoa(n)+".json"), time.Hour)
+		require.NoError(t, err)
+		return kv
+	})
+}
+
+func TestPersistentKVRestart(t *testing.T) {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "memauth")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	databaseURL := "memory://" + filepath.ToSlash(filepath.Join(dir, "records.json")) + "?interval=1h"
+	kv, err := auth.OpenKV(ctx, databaseURL)
+	require.NoError(t, err)
+	require.IsType(t, &memauth.PersistentKV{}, kv)
+
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	record := &auth.Record{
+		SatelliteAddress:     "satellite",
+		MacaroonHead:         []byte("head"),
+		EncryptedSecretKey:   []byte("secret"),
+		EncryptedAccessGrant: []byte("grant"),
+		ExpiresAt:            &expiresAt,
+		Labels:               map[string]string{"team": "storage"},
+	}
+	valid, invalid, deleted := auth.KeyHash{1}, auth.KeyHash{2}, auth.KeyHash{3}
+	for _, keyHash := range []auth.KeyHash{valid, invalid, deleted} {
+		require.NoError(t, kv.Put(ctx, keyHash, record))
+	}
+	require.NoError(t, kv.Invalidate(ctx, invalid, "revoked"))
+	require.NoError(t, auth.SoftDelete(ctx, kv, deleted))
+	require.NoError(t, auth.AppendHistory(ctx, kv, valid, auth.HistoryEvent{Action: auth.HistoryCreated}))
+	require.NoError(t, auth.Close(kv))
+
+	kv, err = auth.OpenKV(ctx, databaseURL)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, auth.Close(kv)) }()
+
+	got, err := kv.Get(ctx, valid)
+	require.NoError(t, err)
+	require.Equal(t, record.SatelliteAddress, got.SatelliteAddress)
+	require.Equal(t, record.EncryptedAccessGrant, got.EncryptedAccessGrant)
+	require.Equal(t, record.Labels, got.Labels)
+	require.True(t, expiresAt.Equal(*got.ExpiresAt))
+
+	_, err = kv.Get(ctx, invalid)
+	require.True(t, auth.Invalid.Has(err))
+
+	got, err = kv.Get(ctx, deleted)
+	require.NoError(t, err)
+	require.Nil(t, got)
+	restored, err := auth.Restore(ctx, kv, deleted)
+	require.NoError(t, err)
+	require.True(t, restored)
+
+	events, err := auth.History(ctx, kv, valid)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, auth.HistoryCreated, events[0].Action)
+
+	invalidated, err := auth.InvalidateByMacaroonHead(ctx, kv, []byte("head"), "revoked")
+	require.NoError(t, err)
+	require.EqualValues(t, 2, invalidated)
+}
+
+func TestOpenURL(t *testing.T) {
+	kv, err := memauth.OpenURL("memory://")
+	require.NoError(t, err)
+	require.IsType(t, &memauth.KV{}, kv)
+
+	_, err = memauth.OpenURL("memory:///records.json?interval=soon")
+	require.Error(t, err)
+}
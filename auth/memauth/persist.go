@@ -0,0 +1,238 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package memauth
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+
+	"storj.io/stargate/auth"
+)
+
+// Error is the error class for this package.
+var Error = errs.Class("memauth")
+
+// DefaultSnapshotInterval is how often records are written to the snapshot
+// file when the database url has no interval.
+const DefaultSnapshotInterval = time.Minute
+
+// persistedEntry is an entry with everything the KV knows about its key, as
+// it is kept in the snapshot file.
+type persistedEntry struct {
+	KeyHash              []byte              `json:"key_hash"`
+	SatelliteAddress     string              `json:"satellite_address"`
+	MacaroonHead         []byte              `json:"macaroon_head"`
+	EncryptedSecretKey   []byte              `json:"encrypted_secret_key"`
+	EncryptedAccessGrant []byte              `json:"encrypted_access_grant"`
+	Public               bool                `json:"public"`
+	ExpiresAt            *time.Time          `json:"expires_at,omitempty"`
+	InvalidReason        string              `json:"invalid_reason,omitempty"`
+	InvalidAt            *time.Time          `json:"invalid_at,omitempty"`
+	DeletedAt            *time.Time          `json:"deleted_at,omitempty"`
+	Labels               map[string]string   `json:"labels,omitempty"`
+	LastUsedAt           *time.Time          `json:"last_used_at,omitempty"`
+	UseCount             int64               `json:"use_count,omitempty"`
+	Owner                string              `json:"owner,omitempty"`
+	History              []auth.HistoryEvent `json:"history,omitempty"`
+}
+
+// PersistentKV is a KV that is kept in a snapshot file, so that its records
+// survive restarts. It is meant for development and tiny deployments, where a
+// database is overkill. Changes since the last snapshot are lost on a crash.
+type PersistentKV struct {
+	*KV
+
+	path string
+	stop chan struct{}
+	done chan struct{}
+
+	mu    sync.Mutex // serializes writes of the snapshot file
+	saved []byte
+}
+
+// Open constructs a PersistentKV with the records of the snapshot file at
+// path, if it exists. The records are written to the file every interval, when
+// they changed, and when the KV is closed.
+func Open(path string, interval time.Duration) (*PersistentKV, error) {
+	d := &PersistentKV{
+		KV:   New(),
+		path: path,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, Error.Wrap(err)
+	default:
+		if err := d.load(data); err != nil {
+			return nil, Error.New("invalid snapshot file %q: %v", path, err)
+		}
+		d.saved = data
+	}
+
+	go d.run(interval)
+	return d, nil
+}
+
+// run writes the snapshot file every interval until the KV is closed.
+func (d *PersistentKV) run(interval time.Duration) {
+	defer close(d.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+			if err := d.Save(); err != nil {
+				zap.L().Named("memauth").Error("unable to write snapshot", zap.String("path", d.path), zap.Error(err))
+			}
+		}
+	}
+}
+
+// load adds the entries of a snapshot file. It must be called before the KV is
+// shared.
+func (d *PersistentKV) load(data []byte) error {
+	var entries []persistedEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		var keyHash auth.KeyHash
+		if len(entry.KeyHash) != len(keyHash) {
+			return errs.New("key hash has %d bytes", len(entry.KeyHash))
+		}
+		copy(keyHash[:], entry.KeyHash)
+
+		d.store(keyHash, &auth.Record{
+			SatelliteAddress:     entry.SatelliteAddress,
+			MacaroonHead:         entry.MacaroonHead,
+			EncryptedSecretKey:   entry.EncryptedSecretKey,
+			EncryptedAccessGrant: entry.EncryptedAccessGrant,
+			Public:               entry.Public,
+			ExpiresAt:            entry.ExpiresAt,
+			Labels:               entry.Labels,
+			LastUsedAt:           entry.LastUsedAt,
+			UseCount:             entry.UseCount,
+			Owner:                entry.Owner,
+		})
+		if entry.InvalidReason != "" {
+			invalid := invalidation{reason: entry.InvalidReason}
+			if entry.InvalidAt != nil {
+				invalid.at = *entry.InvalidAt
+			}
+			d.invalid[keyHash] = invalid
+		}
+		if entry.DeletedAt != nil {
+			d.deleted[keyHash] = *entry.DeletedAt
+		}
+		if len(entry.History) > 0 {
+			d.history[keyHash] = entry.History
+		}
+	}
+	return nil
+}
+
+// marshal encodes every entry of the KV.
+func (d *PersistentKV) marshal() ([]byte, error) {
+	d.KV.mu.Lock()
+	entries := make([]persistedEntry, 0, len(d.entries))
+	for keyHash, record := range d.entries {
+		keyHash := keyHash
+		entry := persistedEntry{
+			KeyHash:              keyHash[:],
+			SatelliteAddress:     record.SatelliteAddress,
+			MacaroonHead:         record.MacaroonHead,
+			EncryptedSecretKey:   record.EncryptedSecretKey,
+			EncryptedAccessGrant: record.EncryptedAccessGrant,
+			Public:               record.Public,
+			ExpiresAt:            record.ExpiresAt,
+			Labels:               record.Labels,
+			LastUsedAt:           record.LastUsedAt,
+			UseCount:             record.UseCount,
+			Owner:                record.Owner,
+			History:              d.history[keyHash],
+		}
+		if invalid, ok := d.invalid[keyHash]; ok {
+			at := invalid.at
+			entry.InvalidReason, entry.InvalidAt = invalid.reason, &at
+		}
+		if deletedAt, ok := d.deleted[keyHash]; ok {
+			entry.DeletedAt = &deletedAt
+		}
+		entries = append(entries, entry)
+	}
+	d.KV.mu.Unlock()
+
+	// the map is iterated in random order, so entries are sorted to encode the
+	// same records the same
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].KeyHash, entries[j].KeyHash) < 0
+	})
+	return json.Marshal(entries)
+}
+
+// Save writes the records to the snapshot file if they changed since it was
+// last written. The file is replaced atomically, so that it is never partially
+// written.
+func (d *PersistentKV) Save() (err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	data, err := d.marshal()
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	if bytes.Equal(data, d.saved) {
+		return nil
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(d.path), "."+filepath.Base(d.path)+".*")
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	err = errs.Combine(err, tmp.Close())
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	if err := os.Rename(tmp.Name(), d.path); err != nil {
+		return Error.Wrap(err)
+	}
+
+	d.saved = data
+	return nil
+}
+
+// Close stops the periodic snapshots and writes the records a last time.
+func (d *PersistentKV) Close() error {
+	close(d.stop)
+	<-d.done
+	return d.Save()
+}
@@ -0,0 +1,257 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package metricsauth records metrics of the calls to a KV backend.
+//
+// Every call is counted and timed per operation and backend, failed calls are
+// counted by the class of their error, and lookups of keys that don't exist
+// are counted, so that the latency of the database can be told apart from the
+// latency of the rest of the auth service.
+package metricsauth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+
+	"storj.io/stargate/auth"
+	"storj.io/stargate/internal/tagged"
+)
+
+var mon = monkit.Package()
+
+// KV is a key/value store that records metrics of the calls to another KV.
+type KV struct {
+	kv      auth.KV
+	backend string
+}
+
+// New wraps kv so that the metrics of its calls are tagged with backend, like
+// the scheme of its database url.
+func New(kv auth.KV, backend string) *KV {
+	return &KV{kv: kv, backend: backend}
+}
+
+// errorClass returns the class of err for the metrics.
+func errorClass(err error) string {
+	switch {
+	case auth.Invalid.Has(err):
+		return "invalid"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	default:
+		return "other"
+	}
+}
+
+// observe records a call of the operation that started at start.
+func (d *KV) observe(operation string, start time.Time, err error) {
+	tags := []monkit.SeriesTag{
+		monkit.NewSeriesTag("operation", operation),
+		monkit.NewSeriesTag("backend", d.backend),
+	}
+
+	tagged.Counter(mon, "kv_calls", tags...).Inc(1)
+	tagged.FloatVal(mon, "kv_seconds", tags...).Observe(time.Since(start).Seconds())
+	if err != nil {
+		tagged.Counter(mon, "kv_errors", append(tags, monkit.NewSeriesTag("class", errorClass(err)))...).Inc(1)
+	}
+}
+
+// notFound counts lookups of keys that don't exist.
+func (d *KV) notFound(operation string, count int64) {
+	if count > 0 {
+		tagged.Counter(mon, "kv_not_found",
+			monkit.NewSeriesTag("operation", operation),
+			monkit.NewSeriesTag("backend", d.backend)).Inc(count)
+	}
+}
+
+// Put stores the record in the wrapped key/value store.
+// It is an error if the key already exists.
+func (d *KV) Put(ctx context.Context, keyHash auth.KeyHash, record *auth.Record) (err error) {
+	defer mon.Task()(&ctx)(&err)
+	defer func(start time.Time) { d.observe("put", start, err) }(time.Now())
+
+	return d.kv.Put(ctx, keyHash, record)
+}
+
+// PutBatch stores the records of the entries in the wrapped key/value store.
+func (d *KV) PutBatch(ctx context.Context, entries []auth.Entry) (err error) {
+	defer mon.Task()(&ctx)(&err)
+	defer func(start time.Time) { d.observe("put_batch", start, err) }(time.Now())
+
+	return auth.PutBatch(ctx, d.kv, entries)
+}
+
+// Get retrieves the record from the wrapped key/value store.
+// It returns nil if the key does not exist.
+func (d *KV) Get(ctx context.Context, keyHash auth.KeyHash) (record *auth.Record, err error) {
+	defer mon.Task()(&ctx)(&err)
+	defer func(start time.Time) { d.observe("get", start, err) }(time.Now())
+
+	record, err = d.kv.Get(ctx, keyHash)
+	if record == nil && err == nil {
+		d.notFound("get", 1)
+	}
+	return record, err
+}
+
+// GetBatch retrieves the records for all of the keys from the wrapped
+// key/value store.
+func (d *KV) GetBatch(ctx context.Context, keyHashes []auth.KeyHash) (records []*auth.Record, err error) {
+	defer mon.Task()(&ctx)(&err)
+	defer func(start time.Time) { d.observe("get_batch", start, err) }(time.Now())
+
+	records, err = auth.GetBatch(ctx, d.kv, keyHashes)
+	if err == nil {
+		// invalid and expired records are nil too
+		var missing int64
+		for _, record := range records {
+			if record == nil {
+				missing++
+			}
+		}
+		d.notFound("get_batch", missing)
+	}
+	return records, err
+}
+
+// Delete removes the record from the wrapped key/value store.
+// It is not an error if the key does not exist.
+func (d *KV) Delete(ctx context.Context, keyHash auth.KeyHash) (err error) {
+	defer mon.Task()(&ctx)(&err)
+	defer func(start time.Time) { d.observe("delete", start, err) }(time.Now())
+
+	return d.kv.Delete(ctx, keyHash)
+}
+
+// SoftDelete marks the record as deleted in the wrapped key/value store.
+// It is not an error if the key does not exist.
+func (d *KV) SoftDelete(ctx context.Context, keyHash auth.KeyHash) (err error) {
+	defer mon.Task()(&ctx)(&err)
+	defer func(start time.Time) { d.observe("soft_delete", start, err) }(time.Now())
+
+	return auth.SoftDelete(ctx, d.kv, keyHash)
+}
+
+// Restore undoes SoftDelete, and returns whether the record was deleted.
+// It is not an error if the key does not exist.
+func (d *KV) Restore(ctx context.Context, keyHash auth.KeyHash) (restored bool, err error) {
+	defer mon.Task()(&ctx)(&err)
+	defer func(start time.Time) { d.observe("restore", start, err) }(time.Now())
+
+	return auth.Restore(ctx, d.kv, keyHash)
+}
+
+// PurgeDeleted removes the records that were soft deleted before asOf from
+// the wrapped key/value store, and returns how many were removed.
+func (d *KV) PurgeDeleted(ctx context.Context, asOf time.Time) (purged int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+	defer func(start time.Time) { d.observe("purge_deleted", start, err) }(time.Now())
+
+	return auth.PurgeDeleted(ctx, d.kv, asOf)
+}
+
+// Invalidate causes the record to become invalid.
+// It is not an error if the key does not exist.
+func (d *KV) Invalidate(ctx context.Context, keyHash auth.KeyHash, reason string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+	defer func(start time.Time) { d.observe("invalidate", start, err) }(time.Now())
+
+	return d.kv.Invalidate(ctx, keyHash, reason)
+}
+
+// Update atomically changes the entry of the key in the wrapped key/value
+// store with fn, and returns whether the entry was stored.
+// It is not an error if the key does not exist.
+func (d *KV) Update(ctx context.Context, keyHash auth.KeyHash, fn func(entry *auth.Entry) (store bool, err error)) (updated bool, err error) {
+	defer mon.Task()(&ctx)(&err)
+	defer func(start time.Time) { d.observe("update", start, err) }(time.Now())
+
+	return auth.Update(ctx, d.kv, keyHash, fn)
+}
+
+// InvalidateByMacaroonHead causes every record with the macaroon head to
+// become invalid, and returns how many were invalidated.
+func (d *KV) InvalidateByMacaroonHead(ctx context.Context, macaroonHead []byte, reason string) (invalidated int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+	defer func(start time.Time) { d.observe("invalidate_by_macaroon_head", start, err) }(time.Now())
+
+	return auth.InvalidateByMacaroonHead(ctx, d.kv, macaroonHead, reason)
+}
+
+// DeleteUnused removes the records that were invalidated or that expired
+// before asOf from the wrapped key/value store.
+func (d *KV) DeleteUnused(ctx context.Context, asOf time.Time) (deleted int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+	defer func(start time.Time) { d.observe("delete_unused", start, err) }(time.Now())
+
+	return auth.DeleteUnused(ctx, d.kv, asOf)
+}
+
+// RecordUse adds uses to the use count of the key.
+func (d *KV) RecordUse(ctx context.Context, keyHash auth.KeyHash, at time.Time, uses int64) (err error) {
+	defer mon.Task()(&ctx)(&err)
+	defer func(start time.Time) { d.observe("record_use", start, err) }(time.Now())
+
+	return auth.RecordUse(ctx, d.kv, keyHash, at, uses)
+}
+
+// CountActive returns how many records of the owner are active at now.
+func (d *KV) CountActive(ctx context.Context, owner string, now time.Time) (count int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+	defer func(start time.Time) { d.observe("count_active", start, err) }(time.Now())
+
+	return auth.CountActive(ctx, d.kv, owner, now)
+}
+
+// AppendHistory adds the event to the history of the key.
+func (d *KV) AppendHistory(ctx context.Context, keyHash auth.KeyHash, event auth.HistoryEvent) (err error) {
+	defer mon.Task()(&ctx)(&err)
+	defer func(start time.Time) { d.observe("append_history", start, err) }(time.Now())
+
+	return auth.AppendHistory(ctx, d.kv, keyHash, event)
+}
+
+// History returns the events of the key in the order they were appended.
+func (d *KV) History(ctx context.Context, keyHash auth.KeyHash) (events []auth.HistoryEvent, err error) {
+	defer mon.Task()(&ctx)(&err)
+	defer func(start time.Time) { d.observe("history", start, err) }(time.Now())
+
+	return auth.History(ctx, d.kv, keyHash)
+}
+
+// Iterate calls fn for every record in the wrapped key/value store. The time
+// of the iteration includes the time spent in fn.
+func (d *KV) Iterate(ctx context.Context, fn func(ctx context.Context, entry auth.Entry) error) (err error) {
+	defer mon.Task()(&ctx)(&err)
+	defer func(start time.Time) { d.observe("iterate", start, err) }(time.Now())
+
+	return auth.Iterate(ctx, d.kv, fn)
+}
+
+// List returns a page of the records in the wrapped key/value store.
+func (d *KV) List(ctx context.Context, after auth.KeyHash, limit int) (entries []auth.Entry, err error) {
+	defer mon.Task()(&ctx)(&err)
+	defer func(start time.Time) { d.observe("list", start, err) }(time.Now())
+
+	return auth.List(ctx, d.kv, after, limit)
+}
+
+// HealthCheck returns an error if the wrapped key/value store can't be used.
+func (d *KV) HealthCheck(ctx context.Context) (err error) {
+	defer mon.Task()(&ctx)(&err)
+	defer func(start time.Time) { d.observe("health_check", start, err) }(time.Now())
+
+	return auth.HealthCheck(ctx, d.kv)
+}
+
+// Close closes the wrapped key/value store.
+func (d *KV) Close() error {
+	return auth.Close(d.kv)
+}
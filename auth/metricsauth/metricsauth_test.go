@@ -0,0 +1,19 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package metricsauth_test
+
+import (
+	"testing"
+
+	"storj.io/stargate/auth"
+	"storj.io/stargate/auth/kvtest"
+	"storj.io/stargate/auth/memauth"
+	"storj.io/stargate/auth/metricsauth"
+)
+
+func TestKV(t *testing.T) {
+	kvtest.RunTests(t, func() auth.KV {
+		return metricsauth.New(memauth.New(), "memory")
+	})
+}
@@ -0,0 +1,295 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package auth
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+)
+
+// MigrateConfig configures how a Migrator copies records.
+type MigrateConfig struct {
+	BatchSize int           `help:"number of records to store at once" default:"100"`
+	RateLimit int           `help:"maximum number of records to copy or verify per second; 0 is unlimited" default:"0"`
+	Progress  time.Duration `help:"how often to report progress" default:"10s"`
+	Verify    bool          `help:"verify that every record was copied after copying" default:"true"`
+}
+
+// MigrateStats counts what a migration did.
+type MigrateStats struct {
+	Copied      int64 `json:"copied"`
+	Skipped     int64 `json:"skipped"`
+	Invalidated int64 `json:"invalidated"`
+	Deleted     int64 `json:"deleted"`
+	Verified    int64 `json:"verified"`
+	Mismatched  int64 `json:"mismatched"`
+}
+
+// Source is where a Migrator reads records from. Every ListingKV is a Source,
+// and KVSource makes one of other KVs.
+type Source interface {
+	// Iterate calls fn for every record, including invalid, expired and soft
+	// deleted records.
+	Iterate(ctx context.Context, fn func(ctx context.Context, entry Entry) error) (err error)
+}
+
+// KVSource returns the Source of the records of kv, which fails with an
+// Unsupported error if kv isn't a ListingKV.
+func KVSource(kv KV) Source {
+	return kvSource{kv: kv}
+}
+
+// kvSource is the Source of the records of a KV.
+type kvSource struct {
+	kv KV
+}
+
+// Iterate implements Source.
+func (source kvSource) Iterate(ctx context.Context, fn func(ctx context.Context, entry Entry) error) error {
+	return Iterate(ctx, source.kv, fn)
+}
+
+// Migrator copies every record from a Source, usually another KV, to a KV.
+//
+// Records that already exist in the destination are skipped, so a migration
+// can be run again to copy the records that were stored in the source while
+// it ran. To migrate without downtime, migrate once, switch the auth service to
+// the destination and migrate again.
+type Migrator struct {
+	log    *zap.Logger
+	from   Source
+	to     KV
+	config MigrateConfig
+
+	// Progress is called with the current stats every config.Progress, and
+	// once more when a pass is done.
+	Progress func(stats MigrateStats)
+
+	stats        MigrateStats
+	lastProgress time.Time
+	throttle     time.Time
+}
+
+// NewMigrator constructs a Migrator that copies records from a Source to a KV.
+func NewMigrator(log *zap.Logger, from Source, to KV, config MigrateConfig) *Migrator {
+	if config.BatchSize <= 0 {
+		config.BatchSize = 1
+	}
+	return &Migrator{
+		log:    log,
+		from:   from,
+		to:     to,
+		config: config,
+	}
+}
+
+// Migrate copies every record and verifies the copies if configured to. It
+// returns an error if any copy doesn't match its source record.
+func (m *Migrator) Migrate(ctx context.Context) (stats MigrateStats, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	m.stats = MigrateStats{}
+	if err := m.Copy(ctx); err != nil {
+		return m.stats, err
+	}
+	if m.config.Verify {
+		if err := m.Verify(ctx); err != nil {
+			return m.stats, err
+		}
+	}
+	return m.stats, nil
+}
+
+// Copy copies every record that doesn't exist in the destination yet, and
+// invalidates and soft deletes the copies of invalid and soft deleted records.
+func (m *Migrator) Copy(ctx context.Context) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	batch := make([]Entry, 0, m.config.BatchSize)
+	err = m.from.Iterate(ctx, func(ctx context.Context, entry Entry) error {
+		batch = append(batch, entry)
+		if len(batch) < m.config.BatchSize {
+			return nil
+		}
+		err := m.copyBatch(ctx, batch)
+		batch = batch[:0]
+		return err
+	})
+	if err == nil && len(batch) > 0 {
+		err = m.copyBatch(ctx, batch)
+	}
+	m.report(true)
+	return err
+}
+
+// copyBatch stores a batch of records in the destination. If the batch can't
+// be stored at once, the records are stored one by one so that the records that
+// already exist can be skipped.
+func (m *Migrator) copyBatch(ctx context.Context, batch []Entry) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if err := m.wait(ctx, len(batch)); err != nil {
+		return err
+	}
+
+	if err := PutBatch(ctx, m.to, batch); err == nil {
+		m.stats.Copied += int64(len(batch))
+	} else {
+		for _, entry := range batch {
+			if err := m.copyEntry(ctx, entry); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, entry := range batch {
+		if entry.InvalidReason != "" {
+			if err := m.to.Invalidate(ctx, entry.KeyHash, entry.InvalidReason); err != nil {
+				return err
+			}
+			m.stats.Invalidated++
+		}
+		if entry.DeletedAt != nil {
+			if err := SoftDelete(ctx, m.to, entry.KeyHash); err != nil {
+				return err
+			}
+			m.stats.Deleted++
+		}
+	}
+
+	m.report(false)
+	return nil
+}
+
+// copyEntry stores a single record in the destination unless it already exists.
+func (m *Migrator) copyEntry(ctx context.Context, entry Entry) error {
+	putErr := m.to.Put(ctx, entry.KeyHash, entry.Record)
+	if putErr == nil {
+		m.stats.Copied++
+		return nil
+	}
+
+	// soft deleted records look missing, so a copy that was deleted in the
+	// destination after a previous migration can't be told apart from a put
+	// that failed for another reason. Verify reports the latter.
+	existing, err := m.to.Get(ctx, entry.KeyHash)
+	if existing == nil && err != nil && !Invalid.Has(err) {
+		return errs.Combine(putErr, err)
+	}
+	m.stats.Skipped++
+	return nil
+}
+
+// Verify checks that every record in the source has an identical copy in the
+// destination. Mismatches are logged and counted, and Verify returns an error
+// if there are any.
+func (m *Migrator) Verify(ctx context.Context) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	now := time.Now()
+	err = m.from.Iterate(ctx, func(ctx context.Context, entry Entry) error {
+		if err := m.wait(ctx, 1); err != nil {
+			return err
+		}
+
+		copied, err := m.to.Get(ctx, entry.KeyHash)
+		if err != nil && !Invalid.Has(err) {
+			return err
+		}
+
+		var problem string
+		switch {
+		case entry.DeletedAt != nil:
+			if copied != nil || Invalid.Has(err) {
+				problem = "copy of deleted record is not deleted"
+			}
+		case entry.InvalidReason != "" || entry.Record.Expired(now):
+			if !Invalid.Has(err) {
+				problem = "copy of invalid record is valid"
+			}
+		case copied == nil:
+			problem = "record is missing"
+		case !sameRecord(entry.Record, copied):
+			problem = "record differs"
+		}
+
+		m.stats.Verified++
+		if problem != "" {
+			m.stats.Mismatched++
+			m.log.Error("verification failed", zap.String("problem", problem))
+		}
+		m.report(false)
+		return nil
+	})
+	m.report(true)
+	if err != nil {
+		return err
+	}
+
+	if m.stats.Mismatched > 0 {
+		return errs.New("%d of %d records do not match", m.stats.Mismatched, m.stats.Verified)
+	}
+	return nil
+}
+
+// wait throttles the migration to the configured rate limit before n more
+// records are processed.
+func (m *Migrator) wait(ctx context.Context, n int) error {
+	if m.config.RateLimit <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	if m.throttle.Before(now) {
+		m.throttle = now
+	}
+	delay := m.throttle.Sub(now)
+	m.throttle = m.throttle.Add(time.Duration(n) * time.Second / time.Duration(m.config.RateLimit))
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// report calls Progress if it is time to, or if force is set.
+func (m *Migrator) report(force bool) {
+	if m.Progress == nil {
+		return
+	}
+	now := time.Now()
+	if !force && now.Sub(m.lastProgress) < m.config.Progress {
+		return
+	}
+	m.lastProgress = now
+	m.Progress(m.stats)
+}
+
+// sameRecord returns whether both records have the same contents.
+func sameRecord(a, b *Record) bool {
+	if a.SatelliteAddress != b.SatelliteAddress ||
+		!bytes.Equal(a.MacaroonHead, b.MacaroonHead) ||
+		!bytes.Equal(a.EncryptedSecretKey, b.EncryptedSecretKey) ||
+		!bytes.Equal(a.EncryptedAccessGrant, b.EncryptedAccessGrant) ||
+		a.Public != b.Public ||
+		len(a.Labels) != len(b.Labels) || !MatchLabels(a.Labels, b.Labels) {
+		return false
+	}
+	if a.ExpiresAt == nil || b.ExpiresAt == nil {
+		return a.ExpiresAt == nil && b.ExpiresAt == nil
+	}
+	// backends store timestamps with different precision
+	return a.ExpiresAt.Sub(*b.ExpiresAt).Truncate(time.Millisecond) == 0
+}
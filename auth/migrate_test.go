@@ -0,0 +1,113 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package auth_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"storj.io/stargate/auth"
+	"storj.io/stargate/auth/memauth"
+)
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	from, to := memauth.New(), memauth.New()
+
+	past := time.Now().Add(-time.Hour)
+	for i := byte(0); i < 25; i++ {
+		record := &auth.Record{SatelliteAddress: "sat", MacaroonHead: []byte{i}}
+		if i == 0 {
+			record.ExpiresAt = &past
+		}
+		require.NoError(t, from.Put(ctx, auth.KeyHash{i}, record))
+	}
+	require.NoError(t, from.Invalidate(ctx, auth.KeyHash{1}, "revoked"))
+	require.NoError(t, from.SoftDelete(ctx, auth.KeyHash{3}))
+
+	// a record that was already copied is skipped
+	require.NoError(t, to.Put(ctx, auth.KeyHash{2}, &auth.Record{SatelliteAddress: "sat", MacaroonHead: []byte{2}}))
+
+	var reports int
+	migrator := auth.NewMigrator(zaptest.NewLogger(t), from, to, auth.MigrateConfig{
+		BatchSize: 10,
+		Verify:    true,
+	})
+	migrator.Progress = func(auth.MigrateStats) { reports++ }
+
+	stats, err := migrator.Migrate(ctx)
+	require.NoError(t, err)
+	require.Equal(t, auth.MigrateStats{
+		Copied:      24,
+		Skipped:     1,
+		Invalidated: 1,
+		Deleted:     1,
+		Verified:    25,
+	}, stats)
+	require.NotZero(t, reports)
+
+	_, err = to.Get(ctx, auth.KeyHash{1})
+	require.True(t, auth.Invalid.Has(err))
+	require.Contains(t, err.Error(), "revoked")
+
+	record, err := to.Get(ctx, auth.KeyHash{3})
+	require.NoError(t, err)
+	require.Nil(t, record)
+	restored, err := to.Restore(ctx, auth.KeyHash{3})
+	require.NoError(t, err)
+	require.True(t, restored)
+	require.NoError(t, to.SoftDelete(ctx, auth.KeyHash{3}))
+
+	record, err = to.Get(ctx, auth.KeyHash{24})
+	require.NoError(t, err)
+	require.Equal(t, []byte{24}, record.MacaroonHead)
+
+	// migrating again copies nothing
+	stats, err = migrator.Migrate(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, 0, stats.Copied)
+	require.EqualValues(t, 25, stats.Skipped)
+}
+
+func TestMigratorVerify(t *testing.T) {
+	ctx := context.Background()
+	from, to := memauth.New(), memauth.New()
+
+	require.NoError(t, from.Put(ctx, auth.KeyHash{1}, &auth.Record{SatelliteAddress: "sat"}))
+	require.NoError(t, from.Put(ctx, auth.KeyHash{2}, &auth.Record{SatelliteAddress: "sat"}))
+	require.NoError(t, to.Put(ctx, auth.KeyHash{1}, &auth.Record{SatelliteAddress: "other"}))
+
+	migrator := auth.NewMigrator(zaptest.NewLogger(t), from, to, auth.MigrateConfig{BatchSize: 10})
+	require.NoError(t, migrator.Copy(ctx))
+
+	// the record that existed with different contents fails verification
+	err := migrator.Verify(ctx)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "1 of 2")
+}
+
+func TestMigratorRateLimit(t *testing.T) {
+	ctx := context.Background()
+	from, to := memauth.New(), memauth.New()
+
+	for i := byte(0); i < 20; i++ {
+		require.NoError(t, from.Put(ctx, auth.KeyHash{i}, &auth.Record{}))
+	}
+
+	migrator := auth.NewMigrator(zaptest.NewLogger(t), from, to, auth.MigrateConfig{
+		BatchSize: 5,
+		RateLimit: 50,
+	})
+
+	start := time.Now()
+	_, err := migrator.Migrate(ctx)
+	require.NoError(t, err)
+
+	// the first batch isn't delayed, the three that follow are
+	require.True(t, time.Since(start) >= 250*time.Millisecond, "migration was not throttled")
+}
@@ -0,0 +1,60 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package auth
+
+import (
+	"strings"
+
+	"github.com/zeebo/errs"
+
+	"storj.io/uplink"
+)
+
+// PassphraseError is the class of errors for invalid passphrase derivations.
+var PassphraseError = errs.Class("passphrase")
+
+// DerivePassphraseAccess returns an access grant for the objects under the
+// prefix in the bucket, which encrypts them with a key derived from the
+// passphrase and salt instead of the encryption key of accessGrant. Every
+// folder can have its own passphrase this way, without the uplink cli.
+//
+// The prefix must be empty or end with a /, and the same passphrase and salt
+// always derive the same key. An empty salt defaults to the bucket and prefix.
+func DerivePassphraseAccess(accessGrant, bucket, prefix, passphrase string, salt []byte) (string, error) {
+	if bucket == "" {
+		return "", PassphraseError.New("bucket is required")
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		return "", PassphraseError.New("prefix must end with a /")
+	}
+	if passphrase == "" {
+		return "", PassphraseError.New("passphrase is required")
+	}
+	if len(salt) == 0 {
+		salt = []byte(bucket + "/" + prefix)
+	}
+
+	access, err := uplink.ParseAccess(accessGrant)
+	if err != nil {
+		return "", PassphraseError.Wrap(err)
+	}
+	scoped, err := access.Share(uplink.FullPermission(), uplink.SharePrefix{Bucket: bucket, Prefix: prefix})
+	if err != nil {
+		return "", PassphraseError.Wrap(err)
+	}
+
+	// the key is overridden at the prefix that the restricted access grants.
+	// Overriding it before restricting would lose it, since sharing derives
+	// the key of the prefix from the key of its parent.
+	key, err := uplink.DeriveEncryptionKey(passphrase, salt)
+	if err != nil {
+		return "", PassphraseError.Wrap(err)
+	}
+	if err := scoped.OverrideEncryptionKey(bucket, prefix, key); err != nil {
+		return "", PassphraseError.Wrap(err)
+	}
+
+	serialized, err := scoped.Serialize()
+	return serialized, PassphraseError.Wrap(err)
+}
@@ -0,0 +1,45 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package auth_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/stargate/auth"
+)
+
+// keyedAccess is minimalAccess with a default encryption key, which the
+// paths of prefixes are encrypted with.
+const keyedAccess = "158UtbHepmd91LJ69RLkjN8YHXzTGL1mXfqsrhabjxCbCUXterk9ECv1LqxNQhr5g8Vas6cfz8wFRRjLnwzuwKVHB9Xez6MzFQR2mdCT9cRDL8fDvpXo9b36b7acPrARioni6VAgW3uxyrxMNNpK88hAxn8ozLnUGC"
+
+func TestDerivePassphraseAccess(t *testing.T) {
+	derived, err := auth.DerivePassphraseAccess(keyedAccess, "photos", "private/", "passphrase", nil)
+	require.NoError(t, err)
+	require.NotEqual(t, keyedAccess, derived)
+
+	// the same passphrase and salt derive the same access
+	again, err := auth.DerivePassphraseAccess(keyedAccess, "photos", "private/", "passphrase", []byte("photos/private/"))
+	require.NoError(t, err)
+	require.Equal(t, derived, again)
+
+	other, err := auth.DerivePassphraseAccess(keyedAccess, "photos", "private/", "other passphrase", nil)
+	require.NoError(t, err)
+	require.NotEqual(t, derived, other)
+
+	for _, invalid := range []struct {
+		accessGrant, bucket, prefix, passphrase string
+	}{
+		{keyedAccess, "", "private/", "passphrase"},
+		{keyedAccess, "photos", "private", "passphrase"},
+		{keyedAccess, "photos", "private/", ""},
+		{"invalid", "photos", "private/", "passphrase"},
+		// without an encryption key, the path of the prefix can't be encrypted
+		{minimalAccess, "photos", "private/", "passphrase"},
+	} {
+		_, err := auth.DerivePassphraseAccess(invalid.accessGrant, invalid.bucket, invalid.prefix, invalid.passphrase, nil)
+		require.True(t, auth.PassphraseError.Has(err), invalid)
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zeebo/errs"
+)
+
+// QuotaExceeded is the class of errors for registrations beyond the quota of
+// their owner.
+var QuotaExceeded = errs.Class("quota exceeded")
+
+const (
+	tokenOwnerPrefix  = "token:"
+	sourceOwnerPrefix = "ip:"
+)
+
+// quotaLockStripes is how many locks the registrations of owners with quotas
+// are spread over.
+const quotaLockStripes = 64
+
+// QuotaConfig configures how many active records every owner may have
+// registered, so that a single client can't flood a shared deployment.
+//
+// Quotas are enforced per process: registrations that race on different
+// instances that share a database may exceed a quota by up to one record per
+// instance.
+type QuotaConfig struct {
+	PerToken  int `help:"most active accesses that every auth token may have registered, or 0 for no limit; admin tokens have no limit; best-effort across instances that share a database" default:"0"`
+	PerSource int `help:"most active accesses that every client ip may have registered without an auth token, or 0 for no limit; best-effort across instances that share a database" default:"0"`
+}
+
+// limit returns the quota of the owner, or 0 if it has none.
+func (config QuotaConfig) limit(owner string) int {
+	switch {
+	case strings.HasPrefix(owner, tokenOwnerPrefix):
+		return config.PerToken
+	case strings.HasPrefix(owner, sourceOwnerPrefix):
+		return config.PerSource
+	}
+	return 0
+}
+
+// TokenOwner returns the owner of the records that an auth token registers.
+// It is a hash of the token, so that the token isn't stored with the records.
+func TokenOwner(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return tokenOwnerPrefix + hex.EncodeToString(hash[:16])
+}
+
+// SourceOwner returns the owner of the records that a client ip registers
+// without an auth token.
+func SourceOwner(ip string) string {
+	return sourceOwnerPrefix + ip
+}
+
+// Owner returns the owner of the access of the key, which is empty if it has
+// none, like accesses registered with admin tokens.
+func (db *Database) Owner(ctx context.Context, key EncryptionKey) (owner string, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	record, err := db.kv.Get(ctx, key.Hash())
+	if err != nil {
+		return "", errs.Wrap(err)
+	} else if record == nil {
+		return "", NotFound.New("key hash: %x", key.Hash())
+	}
+	return record.Owner, nil
+}
+
+// SetQuotas limits how many active records every owner may have registered.
+// Registrations of owners with a quota fail with an Unsupported error if the
+// key/value store can't count the records of owners.
+func (db *Database) SetQuotas(config QuotaConfig) {
+	db.quotas = config
+}
+
+// lockQuotas locks the quotas of the owners that have one, so that their
+// records can be counted and stored without racing other registrations of
+// the process. It returns the func that unlocks them.
+func (db *Database) lockQuotas(owners ...string) (unlock func()) {
+	var stripes []int
+	seen := make(map[int]bool)
+	for _, owner := range owners {
+		if db.quotas.limit(owner) <= 0 {
+			continue
+		}
+		hash := fnv.New32a()
+		_, _ = hash.Write([]byte(owner))
+		if stripe := int(hash.Sum32() % quotaLockStripes); !seen[stripe] {
+			seen[stripe] = true
+			stripes = append(stripes, stripe)
+		}
+	}
+	// the stripes are locked in order, so that batches can't deadlock
+	sort.Ints(stripes)
+	for _, stripe := range stripes {
+		db.quotaLocks[stripe].Lock()
+	}
+	return func() {
+		for i := len(stripes) - 1; i >= 0; i-- {
+			db.quotaLocks[stripes[i]].Unlock()
+		}
+	}
+}
+
+// remainingQuota returns how many more records the owner may register, or -1
+// if it may register any number of them. The quota of the owner must be
+// locked with lockQuotas until the records are stored.
+func (db *Database) remainingQuota(ctx context.Context, owner string) (remaining int64, err error) {
+	limit := db.quotas.limit(owner)
+	if limit <= 0 {
+		return -1, nil
+	}
+
+	count, err := CountActive(ctx, db.kv, owner, time.Now())
+	if err != nil {
+		return 0, errs.Wrap(err)
+	}
+	if remaining = int64(limit) - count; remaining < 0 {
+		remaining = 0
+	}
+	return remaining, nil
+}
+
+// withinQuota returns whether the owner may register another record of a
+// batch, and counts the record if so. remaining has the remaining quotas of
+// the owners of the batch, which are read when they are first needed.
+func (db *Database) withinQuota(ctx context.Context, remaining map[string]int64, owner string) (ok bool, err error) {
+	left, ok := remaining[owner]
+	if !ok {
+		if left, err = db.remainingQuota(ctx, owner); err != nil {
+			return false, err
+		}
+	}
+	if left == 0 {
+		remaining[owner] = 0
+		return false, nil
+	}
+	if left > 0 {
+		left--
+	}
+	remaining[owner] = left
+	return true, nil
+}
+
+// quotaError returns the error of a registration beyond the quota of the
+// owner.
+func (db *Database) quotaError(owner string) error {
+	mon.Event("quota_exceeded")
+	return QuotaExceeded.New("the quota of %d active accesses is reached", db.quotas.limit(owner))
+}
@@ -0,0 +1,106 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package auth_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/stargate/auth"
+	"storj.io/stargate/auth/memauth"
+)
+
+func TestDatabase_Quotas(t *testing.T) {
+	ctx := context.Background()
+	db := auth.NewDatabase(memauth.New())
+	db.SetQuotas(auth.QuotaConfig{PerToken: 2, PerSource: 1})
+
+	token := auth.TokenOwner("secret-token")
+	require.NotContains(t, token, "secret-token")
+
+	for i := byte(0); i < 2; i++ {
+		_, err := db.PutOwned(ctx, token, auth.EncryptionKey{i}, minimalAccess, nil, false, nil, nil)
+		require.NoError(t, err)
+	}
+	_, err := db.PutOwned(ctx, token, auth.EncryptionKey{2}, minimalAccess, nil, false, nil, nil)
+	require.True(t, auth.QuotaExceeded.Has(err), "expected a quota error, got %v", err)
+
+	// other owners and records without an owner have their own quotas
+	source := auth.SourceOwner("192.0.2.1")
+	_, err = db.PutOwned(ctx, source, auth.EncryptionKey{3}, minimalAccess, nil, false, nil, nil)
+	require.NoError(t, err)
+	_, err = db.Put(ctx, auth.EncryptionKey{4}, minimalAccess, nil, false, nil, nil)
+	require.NoError(t, err)
+
+	// invalid records don't count towards the quota
+	invalidated, err := db.Invalidate(ctx, auth.EncryptionKey{0}, "revoked")
+	require.NoError(t, err)
+	require.True(t, invalidated)
+	_, err = db.PutOwned(ctx, token, auth.EncryptionKey{2}, minimalAccess, nil, false, nil, nil)
+	require.NoError(t, err)
+
+	// a batch is stored completely or not at all
+	_, err = db.PutBatch(ctx, []auth.PutRequest{
+		{Key: auth.EncryptionKey{5}, AccessGrant: minimalAccess},
+		{Key: auth.EncryptionKey{6}, AccessGrant: minimalAccess, Owner: source},
+	})
+	require.True(t, auth.QuotaExceeded.Has(err), "expected a quota error, got %v", err)
+	_, _, _, _, _, err = db.Get(ctx, auth.EncryptionKey{5})
+	require.True(t, auth.NotFound.Has(err), "expected a not found error, got %v", err)
+
+	// but a partial batch stores the requests within the quota
+	secretKeys, errors, err := db.PutBatchPartial(ctx, []auth.PutRequest{
+		{Key: auth.EncryptionKey{5}, AccessGrant: minimalAccess},
+		{Key: auth.EncryptionKey{6}, AccessGrant: minimalAccess, Owner: source},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, secretKeys[0])
+	require.NoError(t, errors[0])
+	require.Nil(t, secretKeys[1])
+	require.True(t, auth.QuotaExceeded.Has(errors[1]), "expected a quota error, got %v", errors[1])
+}
+
+// slowCount counts records slowly, so that registrations race.
+type slowCount struct {
+	auth.KV
+}
+
+func (kv slowCount) CountActive(ctx context.Context, owner string, now time.Time) (int64, error) {
+	count, err := auth.CountActive(ctx, kv.KV, owner, now)
+	time.Sleep(10 * time.Millisecond)
+	return count, err
+}
+
+func TestDatabase_QuotasConcurrent(t *testing.T) {
+	ctx := context.Background()
+	db := auth.NewDatabase(slowCount{memauth.New()})
+	db.SetQuotas(auth.QuotaConfig{PerToken: 3})
+
+	owner := auth.TokenOwner("token")
+	errs := make([]error, 20)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = db.PutOwned(ctx, owner, auth.EncryptionKey{byte(i)}, minimalAccess, nil, false, nil, nil)
+		}(i)
+	}
+	wg.Wait()
+
+	// racing registrations don't exceed the quota
+	var stored int
+	for _, err := range errs {
+		if err == nil {
+			stored++
+		} else {
+			require.True(t, auth.QuotaExceeded.Has(err), "expected a quota error, got %v", err)
+		}
+	}
+	require.Equal(t, 3, stored)
+}
@@ -0,0 +1,72 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package auth
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/zeebo/errs"
+)
+
+// KVOpener opens a KV for a database url with a registered scheme.
+type KVOpener func(ctx context.Context, databaseURL string) (KV, error)
+
+var registry = struct {
+	mu      sync.Mutex
+	openers map[string]KVOpener
+}{
+	openers: make(map[string]KVOpener),
+}
+
+// RegisterKV makes a KV implementation available to OpenKV for database urls
+// with the given scheme. It is intended to be called from the init function of
+// the package implementing the KV, and it panics if the scheme is registered twice.
+func RegisterKV(scheme string, opener KVOpener) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	scheme = strings.ToLower(scheme)
+	if _, ok := registry.openers[scheme]; ok {
+		panic("auth: KV registered twice for scheme " + scheme)
+	}
+	registry.openers[scheme] = opener
+}
+
+// RegisteredSchemes returns the sorted list of schemes that OpenKV can open.
+func RegisteredSchemes() []string {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	schemes := make([]string, 0, len(registry.openers))
+	for scheme := range registry.openers {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// OpenKV opens a KV using the opener registered for the scheme of databaseURL.
+func OpenKV(ctx context.Context, databaseURL string) (_ KV, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	parsed, err := url.Parse(databaseURL)
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+
+	registry.mu.Lock()
+	opener, ok := registry.openers[strings.ToLower(parsed.Scheme)]
+	registry.mu.Unlock()
+
+	if !ok {
+		return nil, errs.New("unsupported database scheme %q: must be one of %v",
+			parsed.Scheme, RegisteredSchemes())
+	}
+
+	return opener(ctx, databaseURL)
+}
@@ -0,0 +1,34 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package auth_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/stargate/auth"
+	"storj.io/stargate/auth/memauth"
+)
+
+func TestOpenKV(t *testing.T) {
+	ctx := context.Background()
+
+	kv, err := auth.OpenKV(ctx, "memory://")
+	require.NoError(t, err)
+	require.IsType(t, &memauth.KV{}, kv)
+	require.NoError(t, auth.Close(kv))
+
+	// schemes are case insensitive
+	kv, err = auth.OpenKV(ctx, "MEMORY://")
+	require.NoError(t, err)
+	require.NoError(t, auth.Close(kv))
+
+	_, err = auth.OpenKV(ctx, "unknown://")
+	require.Error(t, err)
+
+	require.Contains(t, auth.RegisteredSchemes(), "memory")
+	require.Panics(t, func() { auth.RegisterKV("memory", nil) })
+}
@@ -0,0 +1,229 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package replicaauth
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/zeebo/errs"
+
+	"storj.io/stargate/auth"
+)
+
+// Operation kinds.
+const (
+	opPut            = "put"
+	opDelete         = "delete"
+	opSoftDelete     = "soft_delete"
+	opRestore        = "restore"
+	opInvalidate     = "invalidate"
+	opInvalidateHead = "invalidate_head"
+	opDeleteUnused   = "delete_unused"
+	opPurgeDeleted   = "purge_deleted"
+	opAppendHistory  = "append_history"
+	opUpdate         = "update"
+)
+
+// maxLineSize bounds the memory used to read an operation.
+const maxLineSize = 1 << 20
+
+// operation is a change that has to be replicated, or the acknowledgement
+// that the change with the sequence number Ack was replicated.
+type operation struct {
+	Seq uint64 `json:"seq,omitempty"`
+	Ack uint64 `json:"ack,omitempty"`
+
+	Kind         string             `json:"kind,omitempty"`
+	KeyHash      *auth.KeyHash      `json:"key_hash,omitempty"`
+	Record       *auth.Record       `json:"record,omitempty"`
+	Reason       string             `json:"reason,omitempty"`
+	MacaroonHead []byte             `json:"macaroon_head,omitempty"`
+	AsOf         *time.Time         `json:"as_of,omitempty"`
+	Event        *auth.HistoryEvent `json:"event,omitempty"`
+	DeletedAt    *time.Time         `json:"deleted_at,omitempty"`
+}
+
+// queue is a durable queue of operations. It is a file of json lines that
+// only grows, where every operation is appended when it is pushed and its
+// acknowledgement is appended when it is popped. The file is truncated
+// whenever the queue becomes empty.
+type queue struct {
+	mu      sync.Mutex
+	file    *os.File
+	seq     uint64
+	pending []operation
+}
+
+// openQueue opens the queue at path, with the operations that weren't popped
+// before it was closed. The file is replaced by one with only those
+// operations.
+func openQueue(path string) (_ *queue, err error) {
+	q := &queue{}
+	if err := q.load(path); err != nil {
+		return nil, err
+	}
+
+	var lines []byte
+	for _, op := range q.pending {
+		line, err := json.Marshal(op)
+		if err != nil {
+			return nil, Error.Wrap(err)
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+
+	tmp, err := os.OpenFile(path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	_, err = tmp.Write(lines)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	err = errs.Combine(err, tmp.Close())
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	q.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	return q, nil
+}
+
+// load reads the operations that weren't acknowledged from the file at path,
+// if it exists.
+func (q *queue) load(path string) (err error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	defer func() { err = errs.Combine(err, Error.Wrap(file.Close())) }()
+
+	acked := make(map[uint64]bool)
+	var ops []operation
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, maxLineSize)
+	for scanner.Scan() {
+		var op operation
+		if err := json.Unmarshal(scanner.Bytes(), &op); err != nil {
+			// the last line is partial if the process crashed while writing
+			// it, and then its change wasn't acknowledged to the client
+			break
+		}
+		if op.Ack != 0 {
+			acked[op.Ack] = true
+			continue
+		}
+		if op.Seq > q.seq {
+			q.seq = op.Seq
+		}
+		ops = append(ops, op)
+	}
+	if err := scanner.Err(); err != nil {
+		return Error.Wrap(err)
+	}
+
+	for _, op := range ops {
+		if !acked[op.Seq] {
+			q.pending = append(q.pending, op)
+		}
+	}
+	return nil
+}
+
+// push durably appends the operations to the queue.
+func (q *queue) push(ops ...operation) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var lines []byte
+	for i := range ops {
+		q.seq++
+		ops[i].Seq = q.seq
+		line, err := json.Marshal(ops[i])
+		if err != nil {
+			return Error.Wrap(err)
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+	if err := q.write(lines); err != nil {
+		return err
+	}
+
+	q.pending = append(q.pending, ops...)
+	return nil
+}
+
+// peek returns the oldest operation.
+func (q *queue) peek() (op operation, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.pending) == 0 {
+		return operation{}, false
+	}
+	return q.pending[0], true
+}
+
+// pop durably removes the oldest operation, which must be op.
+func (q *queue) pop(op operation) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.pending) == 0 || q.pending[0].Seq != op.Seq {
+		return Error.New("operation %d is not the oldest", op.Seq)
+	}
+
+	if len(q.pending) == 1 {
+		if err := q.file.Truncate(0); err != nil {
+			return Error.Wrap(err)
+		}
+	} else {
+		line, err := json.Marshal(operation{Ack: op.Seq})
+		if err != nil {
+			return Error.Wrap(err)
+		}
+		if err := q.write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+
+	q.pending = q.pending[1:]
+	return nil
+}
+
+// len returns the number of operations in the queue.
+func (q *queue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.pending)
+}
+
+// write appends the lines to the file and syncs it. It must be called with
+// the mutex held.
+func (q *queue) write(lines []byte) error {
+	if _, err := q.file.Write(lines); err != nil {
+		return Error.Wrap(err)
+	}
+	return Error.Wrap(q.file.Sync())
+}
+
+// close closes the file of the queue.
+func (q *queue) close() error {
+	return Error.Wrap(q.file.Close())
+}
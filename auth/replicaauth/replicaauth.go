@@ -0,0 +1,438 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package replicaauth replicates the changes to a KV to a secondary KV, like a
+// warm standby database in another region.
+//
+// Changes are made in the primary KV first, and are then appended to a durable
+// queue that is replayed to the secondary KV in the background, so that an
+// unavailable secondary doesn't slow down or fail requests and changes aren't
+// lost across restarts.
+package replicaauth
+
+import (
+	"context"
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+
+	"storj.io/stargate/auth"
+)
+
+var mon = monkit.Package()
+
+// Error is the error class for this package.
+var Error = errs.Class("replication")
+
+// Config configures replication to a secondary database.
+type Config struct {
+	SecondaryURL  string        `help:"url of a database that changes are asynchronously replicated to; disabled when empty" default:""`
+	QueuePath     string        `help:"file that keeps the changes that haven't been replicated yet across restarts" default:"$CONFDIR/replication.queue"`
+	RetryInterval time.Duration `help:"how long to wait before replicating again after the secondary database fails" default:"5s"`
+}
+
+// KV is a key/value store that replicates its changes to a secondary key/value
+// store.
+//
+// Reads are only made from the primary key/value store. Changes that are
+// replayed after a crash may be replicated twice, which is harmless for
+// everything but history events, which may be duplicated.
+type KV struct {
+	kv        auth.KV
+	log       *zap.Logger
+	secondary auth.KV
+	queue     *queue
+	config    Config
+	wake      chan struct{}
+}
+
+// New constructs a KV that replicates the changes to kv to secondary once Run
+// is called. It takes ownership of secondary.
+func New(log *zap.Logger, kv, secondary auth.KV, config Config) (*KV, error) {
+	if config.QueuePath == "" {
+		return nil, Error.New("queue path is required")
+	}
+	queue, err := openQueue(config.QueuePath)
+	if err != nil {
+		return nil, err
+	}
+	if pending := queue.len(); pending > 0 {
+		log.Info("resuming replication", zap.Int("pending", pending))
+	}
+
+	return &KV{
+		kv:        kv,
+		log:       log,
+		secondary: secondary,
+		queue:     queue,
+		config:    config,
+		wake:      make(chan struct{}, 1),
+	}, nil
+}
+
+// Run replicates the queued changes until ctx is canceled.
+func (d *KV) Run(ctx context.Context) error {
+	for {
+		if err := d.Replicate(ctx); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			d.log.Warn("unable to replicate", zap.Error(err), zap.Int("pending", d.queue.len()))
+
+			timer := time.NewTimer(d.config.RetryInterval)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-d.wake:
+		}
+	}
+}
+
+// Replicate applies every queued change to the secondary key/value store in
+// order, and stops at the first change that fails.
+func (d *KV) Replicate(ctx context.Context) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	for {
+		mon.IntVal("replication_pending").Observe(int64(d.queue.len()))
+
+		op, ok := d.queue.peek()
+		if !ok {
+			return nil
+		}
+		// changes that the secondary can't make, like appending to histories
+		// that it doesn't keep, are skipped
+		if err := d.apply(ctx, op); err != nil && !auth.Unsupported.Has(err) {
+			mon.Event("replication_failed")
+			return err
+		}
+		if err := d.queue.pop(op); err != nil {
+			return err
+		}
+	}
+}
+
+// apply applies the change to the secondary key/value store.
+func (d *KV) apply(ctx context.Context, op operation) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	switch op.Kind {
+	case opPut:
+		err := d.secondary.Put(ctx, *op.KeyHash, op.Record)
+		if err == nil {
+			return nil
+		}
+		// the record may have been replicated before a crash
+		record, getErr := d.secondary.Get(ctx, *op.KeyHash)
+		if record != nil || auth.Invalid.Has(getErr) {
+			return nil
+		}
+		return err
+	case opDelete:
+		return d.secondary.Delete(ctx, *op.KeyHash)
+	case opSoftDelete:
+		err := auth.SoftDelete(ctx, d.secondary, *op.KeyHash)
+		if auth.Unsupported.Has(err) {
+			// secondaries without soft deletes delete the record right away
+			return d.secondary.Delete(ctx, *op.KeyHash)
+		}
+		return err
+	case opRestore:
+		_, err := auth.Restore(ctx, d.secondary, *op.KeyHash)
+		return err
+	case opInvalidate:
+		return d.secondary.Invalidate(ctx, *op.KeyHash, op.Reason)
+	case opInvalidateHead:
+		_, err := auth.InvalidateByMacaroonHead(ctx, d.secondary, op.MacaroonHead, op.Reason)
+		return err
+	case opDeleteUnused:
+		_, err := auth.DeleteUnused(ctx, d.secondary, *op.AsOf)
+		return err
+	case opPurgeDeleted:
+		_, err := auth.PurgeDeleted(ctx, d.secondary, *op.AsOf)
+		return err
+	case opAppendHistory:
+		return auth.AppendHistory(ctx, d.secondary, *op.KeyHash, *op.Event)
+	case opUpdate:
+		_, err := auth.Update(ctx, d.secondary, *op.KeyHash, func(entry *auth.Entry) (bool, error) {
+			entry.Record = op.Record
+			entry.InvalidReason = op.Reason
+			entry.DeletedAt = op.DeletedAt
+			return true, nil
+		})
+		if auth.Unsupported.Has(err) {
+			return d.replace(ctx, op)
+		}
+		return err
+	default:
+		return Error.New("unknown operation %q", op.Kind)
+	}
+}
+
+// replace replaces the entry of an update in a secondary without atomic
+// updates one change at a time.
+func (d *KV) replace(ctx context.Context, op operation) error {
+	if err := d.secondary.Delete(ctx, *op.KeyHash); err != nil {
+		return err
+	}
+	if err := d.secondary.Put(ctx, *op.KeyHash, op.Record); err != nil {
+		return err
+	}
+	if op.Reason != "" {
+		if err := d.secondary.Invalidate(ctx, *op.KeyHash, op.Reason); err != nil {
+			return err
+		}
+	}
+	if op.DeletedAt != nil {
+		return d.apply(ctx, operation{Kind: opSoftDelete, KeyHash: op.KeyHash})
+	}
+	return nil
+}
+
+// enqueue queues the changes, which were made in the primary key/value store,
+// for replication. Since they were made, failures are logged instead of
+// returned, and the secondary key/value store has to be resynchronized.
+func (d *KV) enqueue(ops ...operation) {
+	if err := d.queue.push(ops...); err != nil {
+		mon.Event("replication_queue_failed")
+		d.log.Error("unable to queue changes for replication", zap.Error(err))
+		return
+	}
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Put stores the record in the key/value store.
+// It is an error if the key already exists.
+func (d *KV) Put(ctx context.Context, keyHash auth.KeyHash, record *auth.Record) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if err := d.kv.Put(ctx, keyHash, record); err != nil {
+		return err
+	}
+	d.enqueue(operation{Kind: opPut, KeyHash: &keyHash, Record: record})
+	return nil
+}
+
+// PutBatch stores all of the records in the key/value store.
+// It is an error if any of the keys already exist.
+func (d *KV) PutBatch(ctx context.Context, entries []auth.Entry) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if err := auth.PutBatch(ctx, d.kv, entries); err != nil {
+		return err
+	}
+	ops := make([]operation, len(entries))
+	for i := range entries {
+		ops[i] = operation{Kind: opPut, KeyHash: &entries[i].KeyHash, Record: entries[i].Record}
+	}
+	d.enqueue(ops...)
+	return nil
+}
+
+// Get retrieves the record from the primary key/value store.
+// It returns nil if the key does not exist.
+// If the record is invalid or expired, the error contains why.
+func (d *KV) Get(ctx context.Context, keyHash auth.KeyHash) (record *auth.Record, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return d.kv.Get(ctx, keyHash)
+}
+
+// GetBatch retrieves the records for all of the keys from the primary
+// key/value store. A record is nil if its key does not exist or if it is
+// invalid or expired.
+func (d *KV) GetBatch(ctx context.Context, keyHashes []auth.KeyHash) (records []*auth.Record, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.GetBatch(ctx, d.kv, keyHashes)
+}
+
+// Delete removes the record from the key/value store.
+// It is not an error if the key does not exist.
+func (d *KV) Delete(ctx context.Context, keyHash auth.KeyHash) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if err := d.kv.Delete(ctx, keyHash); err != nil {
+		return err
+	}
+	d.enqueue(operation{Kind: opDelete, KeyHash: &keyHash})
+	return nil
+}
+
+// SoftDelete marks the record as deleted.
+// It is not an error if the key does not exist.
+func (d *KV) SoftDelete(ctx context.Context, keyHash auth.KeyHash) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if err := auth.SoftDelete(ctx, d.kv, keyHash); err != nil {
+		return err
+	}
+	d.enqueue(operation{Kind: opSoftDelete, KeyHash: &keyHash})
+	return nil
+}
+
+// Restore undoes SoftDelete, and returns whether the record was deleted.
+// It is not an error if the key does not exist.
+func (d *KV) Restore(ctx context.Context, keyHash auth.KeyHash) (restored bool, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	restored, err = auth.Restore(ctx, d.kv, keyHash)
+	if err == nil && restored {
+		d.enqueue(operation{Kind: opRestore, KeyHash: &keyHash})
+	}
+	return restored, err
+}
+
+// PurgeDeleted removes the records that were soft deleted before asOf, and
+// returns how many were removed.
+func (d *KV) PurgeDeleted(ctx context.Context, asOf time.Time) (purged int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	purged, err = auth.PurgeDeleted(ctx, d.kv, asOf)
+	if err == nil {
+		d.enqueue(operation{Kind: opPurgeDeleted, AsOf: &asOf})
+	}
+	return purged, err
+}
+
+// Invalidate causes the record to become invalid.
+// It is not an error if the key does not exist.
+// It does not update the invalid reason if the record is already invalid.
+func (d *KV) Invalidate(ctx context.Context, keyHash auth.KeyHash, reason string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if err := d.kv.Invalidate(ctx, keyHash, reason); err != nil {
+		return err
+	}
+	d.enqueue(operation{Kind: opInvalidate, KeyHash: &keyHash, Reason: reason})
+	return nil
+}
+
+// Update atomically changes the entry of the key with fn, and returns whether
+// the entry was stored. The stored entry is replicated as a whole.
+// It is not an error if the key does not exist.
+func (d *KV) Update(ctx context.Context, keyHash auth.KeyHash, fn func(entry *auth.Entry) (store bool, err error)) (updated bool, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	var stored auth.Entry
+	updated, err = auth.Update(ctx, d.kv, keyHash, func(entry *auth.Entry) (bool, error) {
+		store, err := fn(entry)
+		stored = *entry
+		return store, err
+	})
+	if err == nil && updated {
+		d.enqueue(operation{
+			Kind:      opUpdate,
+			KeyHash:   &keyHash,
+			Record:    stored.Record,
+			Reason:    stored.InvalidReason,
+			DeletedAt: stored.DeletedAt,
+		})
+	}
+	return updated, err
+}
+
+// InvalidateByMacaroonHead causes every record with the macaroon head to
+// become invalid, and returns how many were invalidated.
+func (d *KV) InvalidateByMacaroonHead(ctx context.Context, macaroonHead []byte, reason string) (invalidated int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	invalidated, err = auth.InvalidateByMacaroonHead(ctx, d.kv, macaroonHead, reason)
+	if err == nil && invalidated > 0 {
+		d.enqueue(operation{Kind: opInvalidateHead, MacaroonHead: macaroonHead, Reason: reason})
+	}
+	return invalidated, err
+}
+
+// DeleteUnused removes the records that were invalidated or that expired
+// before asOf, and returns how many were removed.
+func (d *KV) DeleteUnused(ctx context.Context, asOf time.Time) (deleted int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	deleted, err = auth.DeleteUnused(ctx, d.kv, asOf)
+	if err == nil {
+		d.enqueue(operation{Kind: opDeleteUnused, AsOf: &asOf})
+	}
+	return deleted, err
+}
+
+// RecordUse adds uses to the use count of the key in the primary key/value
+// store. Uses are not replicated, since they are only statistics and they
+// would fill the queue with a change for every key that is used.
+func (d *KV) RecordUse(ctx context.Context, keyHash auth.KeyHash, at time.Time, uses int64) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.RecordUse(ctx, d.kv, keyHash, at, uses)
+}
+
+// CountActive returns how many records of the owner are active at now in the
+// primary key/value store.
+func (d *KV) CountActive(ctx context.Context, owner string, now time.Time) (count int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.CountActive(ctx, d.kv, owner, now)
+}
+
+// AppendHistory adds the event to the history of the key.
+func (d *KV) AppendHistory(ctx context.Context, keyHash auth.KeyHash, event auth.HistoryEvent) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if err := auth.AppendHistory(ctx, d.kv, keyHash, event); err != nil {
+		return err
+	}
+	d.enqueue(operation{Kind: opAppendHistory, KeyHash: &keyHash, Event: &event})
+	return nil
+}
+
+// History returns the events of the key from the primary key/value store, in
+// the order they were appended.
+func (d *KV) History(ctx context.Context, keyHash auth.KeyHash) (events []auth.HistoryEvent, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.History(ctx, d.kv, keyHash)
+}
+
+// Iterate calls fn for every record in the primary key/value store, including
+// invalid, expired and soft deleted records.
+func (d *KV) Iterate(ctx context.Context, fn func(ctx context.Context, entry auth.Entry) error) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.Iterate(ctx, d.kv, fn)
+}
+
+// List returns a page of the records in the primary key/value store,
+// including invalid, expired and soft deleted records.
+func (d *KV) List(ctx context.Context, after auth.KeyHash, limit int) (entries []auth.Entry, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.List(ctx, d.kv, after, limit)
+}
+
+// HealthCheck checks the primary key/value store. Changes are queued while the
+// secondary key/value store is unavailable, so it doesn't affect the health.
+func (d *KV) HealthCheck(ctx context.Context) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.HealthCheck(ctx, d.kv)
+}
+
+// Close closes both key/value stores and the queue. Changes that haven't been
+// replicated yet are replicated when a KV is constructed with the same queue.
+func (d *KV) Close() error {
+	return errs.Combine(auth.Close(d.kv), auth.Close(d.secondary), d.queue.close())
+}
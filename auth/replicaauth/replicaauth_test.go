@@ -0,0 +1,153 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package replicaauth_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"storj.io/stargate/auth"
+	"storj.io/stargate/auth/kvtest"
+	"storj.io/stargate/auth/memauth"
+	"storj.io/stargate/auth/replicaauth"
+)
+
+func TestKV(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replicaauth")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	n := 0
+	kvtest.RunTests(t, func() auth.KV {
+		n++
+		config := replicaauth.Config{QueuePath: filepath.Join(dir, strconv.Itoa(n)), RetryInterval: time.Second}
+		kv, err := replicaauth.New(zaptest.NewLogger(t), memauth.New(), memauth.New(), config)
+		require.NoError(t, err)
+		return kv
+	})
+}
+
+// failingKV fails every change while failing is set.
+type failingKV struct {
+	*memauth.KV
+	failing bool
+}
+
+func (kv *failingKV) Put(ctx context.Context, keyHash auth.KeyHash, record *auth.Record) error {
+	if kv.failing {
+		return errors.New("unavailable")
+	}
+	return kv.KV.Put(ctx, keyHash, record)
+}
+
+func (kv *failingKV) Invalidate(ctx context.Context, keyHash auth.KeyHash, reason string) error {
+	if kv.failing {
+		return errors.New("unavailable")
+	}
+	return kv.KV.Invalidate(ctx, keyHash, reason)
+}
+
+func TestKV_Replicate(t *testing.T) {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "replicaauth")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	config := replicaauth.Config{QueuePath: filepath.Join(dir, "queue"), RetryInterval: time.Second}
+	record := &auth.Record{MacaroonHead: []byte("head"), EncryptedAccessGrant: []byte("grant")}
+
+	primary, secondary := memauth.New(), &failingKV{KV: memauth.New(), failing: true}
+	kv, err := replicaauth.New(zaptest.NewLogger(t), primary, secondary, config)
+	require.NoError(t, err)
+
+	// changes are queued while the secondary fails
+	require.NoError(t, kv.Put(ctx, auth.KeyHash{1}, record))
+	require.NoError(t, kv.PutBatch(ctx, []auth.Entry{{KeyHash: auth.KeyHash{2}, Record: record}}))
+	require.NoError(t, kv.Invalidate(ctx, auth.KeyHash{1}, "invalidated"))
+	require.Error(t, kv.Replicate(ctx))
+	require.NoError(t, kv.Close())
+
+	got, err := secondary.Get(ctx, auth.KeyHash{2})
+	require.NoError(t, err)
+	require.Nil(t, got)
+
+	// and replayed in order after a restart
+	secondary.failing = false
+	kv, err = replicaauth.New(zaptest.NewLogger(t), primary, secondary, config)
+	require.NoError(t, err)
+	require.NoError(t, kv.Replicate(ctx))
+
+	_, err = secondary.Get(ctx, auth.KeyHash{1})
+	require.True(t, auth.Invalid.Has(err))
+	got, err = secondary.Get(ctx, auth.KeyHash{2})
+	require.NoError(t, err)
+	require.Equal(t, record, got)
+
+	// the queue is empty afterwards
+	require.NoError(t, kv.Close())
+	kv, err = replicaauth.New(zaptest.NewLogger(t), primary, secondary, config)
+	require.NoError(t, err)
+	require.NoError(t, kv.Delete(ctx, auth.KeyHash{2}))
+	require.NoError(t, kv.Replicate(ctx))
+	got, err = secondary.Get(ctx, auth.KeyHash{2})
+	require.NoError(t, err)
+	require.Nil(t, got)
+	require.NoError(t, kv.Close())
+}
+
+// coreKV only has the methods of auth.KV, and none of the optional
+// capabilities of the key/value store it embeds.
+type coreKV struct {
+	auth.KV
+}
+
+func TestKV_CoreSecondary(t *testing.T) {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "replicaauth")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	config := replicaauth.Config{QueuePath: filepath.Join(dir, "queue"), RetryInterval: time.Second}
+	record := &auth.Record{MacaroonHead: []byte("head"), EncryptedAccessGrant: []byte("grant")}
+
+	secondary := memauth.New()
+	kv, err := replicaauth.New(zaptest.NewLogger(t), memauth.New(), coreKV{secondary}, config)
+	require.NoError(t, err)
+
+	// histories are skipped, and soft deletes delete the record right away
+	require.NoError(t, kv.Put(ctx, auth.KeyHash{1}, record))
+	require.NoError(t, kv.AppendHistory(ctx, auth.KeyHash{1}, auth.HistoryEvent{Action: auth.HistoryCreated}))
+	require.NoError(t, kv.SoftDelete(ctx, auth.KeyHash{1}))
+
+	// updates replace the entry one change at a time
+	require.NoError(t, kv.Put(ctx, auth.KeyHash{2}, record))
+	_, err = kv.Update(ctx, auth.KeyHash{2}, func(entry *auth.Entry) (bool, error) {
+		entry.InvalidReason = "invalidated"
+		return true, nil
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, kv.Replicate(ctx))
+
+	got, err := secondary.Get(ctx, auth.KeyHash{1})
+	require.NoError(t, err)
+	require.Nil(t, got)
+	events, err := secondary.History(ctx, auth.KeyHash{1})
+	require.NoError(t, err)
+	require.Empty(t, events)
+	_, err = secondary.Get(ctx, auth.KeyHash{2})
+	require.True(t, auth.Invalid.Has(err), "expected an invalid error, got %v", err)
+	require.NoError(t, kv.Close())
+}
@@ -0,0 +1,329 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package retryauth retries calls to a KV backend that fail transiently.
+//
+// Calls that failed without being applied, like transactions that were
+// aborted by a serialization failure, are retried for every operation. Calls
+// that failed because the connection broke may or may not have been applied,
+// so they are only retried for the operations that can be repeated without
+// changing their result. Spanner retries aborted transactions by itself, so
+// its errors are never retried here.
+package retryauth
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"math/rand"
+	"syscall"
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+
+	"storj.io/stargate/auth"
+)
+
+var mon = monkit.Package()
+
+// Config configures the retries.
+type Config struct {
+	Attempts       int           `help:"maximum number of attempts of every database call that fails transiently, or 1 to disable retries" default:"3"`
+	InitialBackoff time.Duration `help:"maximum time to wait before the first retry, doubled for every further retry" default:"50ms"`
+	MaxBackoff     time.Duration `help:"maximum time to wait before any retry" default:"1s"`
+}
+
+// KV is a key/value store that retries the calls to another KV that fail
+// transiently.
+type KV struct {
+	kv     auth.KV
+	config Config
+}
+
+// New wraps kv so that calls are retried as configured by config. It returns
+// kv unchanged if there is at most one attempt.
+func New(kv auth.KV, config Config) auth.KV {
+	if config.Attempts <= 1 {
+		return kv
+	}
+	return &KV{kv: kv, config: config}
+}
+
+// notApplied returns whether err means that the call failed without changing
+// anything, so that it can always be retried.
+func notApplied(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+
+	var state interface{ SQLState() string }
+	if errors.As(err, &state) {
+		switch state.SQLState() {
+		case "40001", // serialization_failure, which cockroach uses to restart transactions
+			"40P01": // deadlock_detected
+			return true
+		}
+	}
+	return false
+}
+
+// brokenConnection returns whether err means that the connection broke during
+// the call, so that it may or may not have been applied.
+func brokenConnection(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// retry calls fn until it succeeds, fails with an error that isn't retriable,
+// or the attempts run out. Broken connections are only retried if idempotent.
+func (d *KV) retry(ctx context.Context, idempotent bool, fn func() error) error {
+	backoff := d.config.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= d.config.Attempts {
+			return err
+		}
+		if !notApplied(err) && !(idempotent && brokenConnection(err)) {
+			return err
+		}
+		mon.Event("kv_retry")
+
+		// full jitter keeps the retries of many clients from lining up
+		var wait time.Duration
+		if backoff > 0 {
+			wait = time.Duration(rand.Int63n(int64(backoff)))
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		if backoff *= 2; backoff > d.config.MaxBackoff {
+			backoff = d.config.MaxBackoff
+		}
+	}
+}
+
+// Put stores the record in the wrapped key/value store.
+// It is an error if the key already exists.
+func (d *KV) Put(ctx context.Context, keyHash auth.KeyHash, record *auth.Record) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return d.retry(ctx, false, func() error {
+		return d.kv.Put(ctx, keyHash, record)
+	})
+}
+
+// PutBatch stores all of the records in the wrapped key/value store.
+// It is an error if any of the keys already exist.
+func (d *KV) PutBatch(ctx context.Context, entries []auth.Entry) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return d.retry(ctx, false, func() error {
+		return auth.PutBatch(ctx, d.kv, entries)
+	})
+}
+
+// Get retrieves the record from the wrapped key/value store.
+// It returns nil if the key does not exist.
+// If the record is invalid or expired, the error contains why.
+func (d *KV) Get(ctx context.Context, keyHash auth.KeyHash) (record *auth.Record, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	err = d.retry(ctx, true, func() (err error) {
+		record, err = d.kv.Get(ctx, keyHash)
+		return err
+	})
+	return record, err
+}
+
+// GetBatch retrieves the records for all of the keys from the wrapped
+// key/value store. A record is nil if its key does not exist or if it is
+// invalid or expired.
+func (d *KV) GetBatch(ctx context.Context, keyHashes []auth.KeyHash) (records []*auth.Record, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	err = d.retry(ctx, true, func() (err error) {
+		records, err = auth.GetBatch(ctx, d.kv, keyHashes)
+		return err
+	})
+	return records, err
+}
+
+// Delete removes the record from the wrapped key/value store.
+// It is not an error if the key does not exist.
+func (d *KV) Delete(ctx context.Context, keyHash auth.KeyHash) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return d.retry(ctx, true, func() error {
+		return d.kv.Delete(ctx, keyHash)
+	})
+}
+
+// SoftDelete marks the record in the wrapped key/value store as deleted.
+// It is not an error if the key does not exist.
+func (d *KV) SoftDelete(ctx context.Context, keyHash auth.KeyHash) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return d.retry(ctx, true, func() error {
+		return auth.SoftDelete(ctx, d.kv, keyHash)
+	})
+}
+
+// Restore undoes SoftDelete, and returns whether the record was deleted.
+// It is not an error if the key does not exist.
+func (d *KV) Restore(ctx context.Context, keyHash auth.KeyHash) (restored bool, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	// a repeated restore would report that nothing was deleted
+	err = d.retry(ctx, false, func() (err error) {
+		restored, err = auth.Restore(ctx, d.kv, keyHash)
+		return err
+	})
+	return restored, err
+}
+
+// PurgeDeleted removes the records that were soft deleted before asOf from the
+// wrapped key/value store, and returns how many were removed.
+func (d *KV) PurgeDeleted(ctx context.Context, asOf time.Time) (purged int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	err = d.retry(ctx, false, func() (err error) {
+		purged, err = auth.PurgeDeleted(ctx, d.kv, asOf)
+		return err
+	})
+	return purged, err
+}
+
+// Invalidate causes the record to become invalid.
+// It is not an error if the key does not exist.
+// It does not update the invalid reason if the record is already invalid.
+func (d *KV) Invalidate(ctx context.Context, keyHash auth.KeyHash, reason string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return d.retry(ctx, true, func() error {
+		return d.kv.Invalidate(ctx, keyHash, reason)
+	})
+}
+
+// Update atomically changes the entry of the key in the wrapped key/value
+// store with fn, and returns whether the entry was stored. fn may already be
+// called more than once, so retries are fine.
+// It is not an error if the key does not exist.
+func (d *KV) Update(ctx context.Context, keyHash auth.KeyHash, fn func(entry *auth.Entry) (store bool, err error)) (updated bool, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	err = d.retry(ctx, false, func() (err error) {
+		updated, err = auth.Update(ctx, d.kv, keyHash, fn)
+		return err
+	})
+	return updated, err
+}
+
+// InvalidateByMacaroonHead causes every record with the macaroon head to
+// become invalid, and returns how many were invalidated.
+func (d *KV) InvalidateByMacaroonHead(ctx context.Context, macaroonHead []byte, reason string) (invalidated int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	err = d.retry(ctx, false, func() (err error) {
+		invalidated, err = auth.InvalidateByMacaroonHead(ctx, d.kv, macaroonHead, reason)
+		return err
+	})
+	return invalidated, err
+}
+
+// DeleteUnused removes the records that were invalidated or that expired
+// before asOf, and returns how many were removed.
+func (d *KV) DeleteUnused(ctx context.Context, asOf time.Time) (deleted int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	err = d.retry(ctx, false, func() (err error) {
+		deleted, err = auth.DeleteUnused(ctx, d.kv, asOf)
+		return err
+	})
+	return deleted, err
+}
+
+// RecordUse adds uses to the use count of the key in the wrapped key/value
+// store. Broken connections are not retried, since the uses may have been
+// counted already.
+func (d *KV) RecordUse(ctx context.Context, keyHash auth.KeyHash, at time.Time, uses int64) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return d.retry(ctx, false, func() error {
+		return auth.RecordUse(ctx, d.kv, keyHash, at, uses)
+	})
+}
+
+// CountActive returns how many records of the owner are active at now in the
+// wrapped key/value store.
+func (d *KV) CountActive(ctx context.Context, owner string, now time.Time) (count int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	err = d.retry(ctx, true, func() (err error) {
+		count, err = auth.CountActive(ctx, d.kv, owner, now)
+		return err
+	})
+	return count, err
+}
+
+// AppendHistory adds the event to the history of the key in the wrapped
+// key/value store.
+func (d *KV) AppendHistory(ctx context.Context, keyHash auth.KeyHash, event auth.HistoryEvent) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return d.retry(ctx, false, func() error {
+		return auth.AppendHistory(ctx, d.kv, keyHash, event)
+	})
+}
+
+// History returns the events of the key in the wrapped key/value store.
+func (d *KV) History(ctx context.Context, keyHash auth.KeyHash) (events []auth.HistoryEvent, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	err = d.retry(ctx, true, func() (err error) {
+		events, err = auth.History(ctx, d.kv, keyHash)
+		return err
+	})
+	return events, err
+}
+
+// Iterate calls fn for every record in the wrapped key/value store, including
+// invalid, expired and soft deleted records. It is not retried, because fn may
+// already have been called for some of the records.
+func (d *KV) Iterate(ctx context.Context, fn func(ctx context.Context, entry auth.Entry) error) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.Iterate(ctx, d.kv, fn)
+}
+
+// List returns a page of the records in the wrapped key/value store, including
+// invalid, expired and soft deleted records.
+func (d *KV) List(ctx context.Context, after auth.KeyHash, limit int) (entries []auth.Entry, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	err = d.retry(ctx, true, func() (err error) {
+		entries, err = auth.List(ctx, d.kv, after, limit)
+		return err
+	})
+	return entries, err
+}
+
+// HealthCheck checks the wrapped key/value store.
+func (d *KV) HealthCheck(ctx context.Context) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return d.retry(ctx, true, func() error {
+		return auth.HealthCheck(ctx, d.kv)
+	})
+}
+
+// Close closes the wrapped key/value store.
+func (d *KV) Close() error {
+	return auth.Close(d.kv)
+}
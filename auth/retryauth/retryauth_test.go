@@ -0,0 +1,95 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package retryauth_test
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/require"
+	"github.com/zeebo/errs"
+
+	"storj.io/stargate/auth"
+	"storj.io/stargate/auth/kvtest"
+	"storj.io/stargate/auth/memauth"
+	"storj.io/stargate/auth/retryauth"
+)
+
+var config = retryauth.Config{Attempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+func TestKV(t *testing.T) {
+	kvtest.RunTests(t, func() auth.KV { return retryauth.New(memauth.New(), config) })
+}
+
+// flakyKV is a key/value store whose calls fail with the errors before they
+// reach the wrapped key/value store.
+type flakyKV struct {
+	*memauth.KV
+	errors *[]error
+}
+
+func (kv flakyKV) fail() error {
+	if len(*kv.errors) == 0 {
+		return nil
+	}
+	err := (*kv.errors)[0]
+	*kv.errors = (*kv.errors)[1:]
+	return err
+}
+
+func (kv flakyKV) Put(ctx context.Context, keyHash auth.KeyHash, record *auth.Record) error {
+	if err := kv.fail(); err != nil {
+		return err
+	}
+	return kv.KV.Put(ctx, keyHash, record)
+}
+
+func (kv flakyKV) Get(ctx context.Context, keyHash auth.KeyHash) (*auth.Record, error) {
+	if err := kv.fail(); err != nil {
+		return nil, err
+	}
+	return kv.KV.Get(ctx, keyHash)
+}
+
+func TestKV_Retry(t *testing.T) {
+	ctx := context.Background()
+	serialization := errs.Wrap(&pgconn.PgError{Code: "40001"})
+	reset := errs.Wrap(syscall.ECONNRESET)
+
+	var errors []error
+	kv := retryauth.New(flakyKV{KV: memauth.New(), errors: &errors}, config)
+
+	// serialization failures are retried for every operation
+	errors = []error{serialization, serialization}
+	require.NoError(t, kv.Put(ctx, auth.KeyHash{1}, &auth.Record{}))
+	require.Empty(t, errors)
+
+	// but only until the attempts run out
+	errors = []error{serialization, serialization, serialization, serialization}
+	require.Error(t, kv.Put(ctx, auth.KeyHash{2}, &auth.Record{}))
+	require.Len(t, errors, 1)
+
+	// broken connections are only retried for idempotent operations
+	errors = []error{reset}
+	record, err := kv.Get(ctx, auth.KeyHash{1})
+	require.NoError(t, err)
+	require.NotNil(t, record)
+
+	errors = []error{reset}
+	require.Error(t, kv.Put(ctx, auth.KeyHash{3}, &auth.Record{}))
+
+	// other errors are not retried
+	errors = []error{errs.New("other"), nil}
+	_, err = kv.Get(ctx, auth.KeyHash{1})
+	require.Error(t, err)
+	require.Len(t, errors, 1)
+}
+
+func TestKV_Disabled(t *testing.T) {
+	inner := memauth.New()
+	require.Equal(t, auth.KV(inner), retryauth.New(inner, retryauth.Config{Attempts: 1}))
+}
@@ -0,0 +1,70 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package auth
+
+import (
+	"strings"
+
+	"github.com/zeebo/errs"
+
+	"storj.io/uplink"
+)
+
+// RouteError is the class of errors for invalid routes.
+var RouteError = errs.Class("route")
+
+// Route sends the requests for the objects of a bucket, or for the objects
+// under a prefix in a bucket, to another access grant than the one the access
+// key was registered with, like an append-only access grant for logs.
+//
+// Routes are not a way to restrict access: the access grant of a request is
+// chosen by the key or the listed prefix of the request alone, so lists of a
+// prefix above the prefix of a route, like of the whole bucket, use the
+// access grant that the access key was registered with, and see the objects
+// under the route if that access grant can. Objects that must only be reached
+// with the access grant of a route have to be outside of what the access
+// grant of the access key allows.
+type Route struct {
+	Bucket      string `json:"bucket"`
+	Prefix      string `json:"prefix,omitempty"`
+	AccessGrant string `json:"access_grant"`
+}
+
+// ValidateRoutes checks that every route has a bucket and a valid access
+// grant, and that no two routes are for the same bucket and prefix.
+func ValidateRoutes(routes []Route) error {
+	seen := make(map[Route]struct{}, len(routes))
+	for i, route := range routes {
+		if route.Bucket == "" {
+			return RouteError.New("route %d has no bucket", i)
+		}
+		if _, err := uplink.ParseAccess(route.AccessGrant); err != nil {
+			return RouteError.New("route %d has an invalid access grant: %v", i, err)
+		}
+
+		key := Route{Bucket: route.Bucket, Prefix: route.Prefix}
+		if _, ok := seen[key]; ok {
+			return RouteError.New("route %d is for the same bucket and prefix as another route", i)
+		}
+		seen[key] = struct{}{}
+	}
+	return nil
+}
+
+// MatchRoute returns the access grant of the most specific route for the key
+// in the bucket, which is the route with the longest prefix of the key. Bucket
+// operations, like listing, pass the listed prefix or an empty key, so they
+// only match the routes whose prefix is a prefix of theirs.
+func MatchRoute(routes []Route, bucket, key string) (accessGrant string, ok bool) {
+	best := -1
+	for _, route := range routes {
+		if route.Bucket != bucket || !strings.HasPrefix(key, route.Prefix) {
+			continue
+		}
+		if len(route.Prefix) > best {
+			best, accessGrant = len(route.Prefix), route.AccessGrant
+		}
+	}
+	return accessGrant, best >= 0
+}
@@ -0,0 +1,91 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package auth_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/stargate/auth"
+	"storj.io/stargate/auth/memauth"
+)
+
+const minimalAccess = "138CV9Drxrw8ir1XpxcZhk2wnHjhzVjuSZe6yDsNiMZDP8cow9V6sHDYdwgvYoQGgqVvoMnxdWDbpBiEPW5oP7DtPJ5sZx2MVxFrUoZYFfVAgxidW"
+
+func TestMatchRoute(t *testing.T) {
+	routes := []auth.Route{
+		{Bucket: "logs", AccessGrant: "bucket"},
+		{Bucket: "logs", Prefix: "app/", AccessGrant: "app"},
+		{Bucket: "logs", Prefix: "app/audit/", AccessGrant: "audit"},
+	}
+
+	for _, tt := range []struct {
+		bucket, key string
+		expected    string
+		ok          bool
+	}{
+		{"logs", "", "bucket", true},
+		{"logs", "other", "bucket", true},
+		{"logs", "app/", "app", true},
+		{"logs", "app/1.log", "app", true},
+		{"logs", "app/audit/1.log", "audit", true},
+		{"logs", "app", "bucket", true},
+		{"other", "app/1.log", "", false},
+	} {
+		accessGrant, ok := auth.MatchRoute(routes, tt.bucket, tt.key)
+		require.Equal(t, tt.ok, ok, tt)
+		require.Equal(t, tt.expected, accessGrant, tt)
+	}
+
+	_, ok := auth.MatchRoute(nil, "logs", "")
+	require.False(t, ok)
+}
+
+func TestValidateRoutes(t *testing.T) {
+	require.NoError(t, auth.ValidateRoutes(nil))
+	require.NoError(t, auth.ValidateRoutes([]auth.Route{
+		{Bucket: "logs", AccessGrant: minimalAccess},
+		{Bucket: "logs", Prefix: "app/", AccessGrant: minimalAccess},
+	}))
+
+	for _, invalid := range [][]auth.Route{
+		{{AccessGrant: minimalAccess}},
+		{{Bucket: "logs", AccessGrant: "invalid"}},
+		{{Bucket: "logs", AccessGrant: minimalAccess}, {Bucket: "logs", AccessGrant: minimalAccess}},
+	} {
+		require.True(t, auth.RouteError.Has(auth.ValidateRoutes(invalid)))
+	}
+}
+
+func TestDatabase_Routes(t *testing.T) {
+	ctx := context.Background()
+	db := auth.NewDatabase(memauth.New())
+
+	// accesses without routes are stored like before
+	var plain auth.EncryptionKey
+	_, err := db.Put(ctx, plain, minimalAccess, nil, false, nil, nil)
+	require.NoError(t, err)
+
+	accessGrant, routes, _, _, _, err := db.Get(ctx, plain)
+	require.NoError(t, err)
+	require.Equal(t, minimalAccess, accessGrant)
+	require.Empty(t, routes)
+
+	routed := auth.EncryptionKey{1}
+	expected := []auth.Route{{Bucket: "logs", Prefix: "app/", AccessGrant: minimalAccess}}
+	_, err = db.Put(ctx, routed, minimalAccess, expected, true, nil, nil)
+	require.NoError(t, err)
+
+	accessGrant, routes, public, _, _, err := db.Get(ctx, routed)
+	require.NoError(t, err)
+	require.Equal(t, minimalAccess, accessGrant)
+	require.Equal(t, expected, routes)
+	require.True(t, public)
+
+	// invalid routes are not stored
+	_, err = db.Put(ctx, auth.EncryptionKey{2}, minimalAccess, []auth.Route{{Bucket: "logs"}}, false, nil, nil)
+	require.True(t, auth.RouteError.Has(err))
+}
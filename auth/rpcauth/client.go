@@ -0,0 +1,66 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package rpcauth
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Client is a client of the gRPC auth service. Its errors are gRPC statuses,
+// like codes.NotFound for unknown access keys, which status.Code returns.
+type Client struct {
+	conn  *grpc.ClientConn
+	token string
+}
+
+// Dial connects to the auth service at address, and authorizes requests with
+// the auth token. The connection is kept and reused for every request.
+func Dial(ctx context.Context, address, token string, opts ...grpc.DialOption) (*Client, error) {
+	conn, err := grpc.DialContext(ctx, address, opts...)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	return NewClient(conn, token), nil
+}
+
+// NewClient constructs a Client that makes requests on conn, and authorizes
+// them with the auth token.
+func NewClient(conn *grpc.ClientConn, token string) *Client {
+	return &Client{conn: conn, token: token}
+}
+
+// Register registers an access.
+func (client *Client) Register(ctx context.Context, request *RegisterRequest) (_ *RegisterResponse, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	response := new(RegisterResponse)
+	if err := client.invoke(ctx, "Register", request, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// Resolve resolves an access key to its access.
+func (client *Client) Resolve(ctx context.Context, request *ResolveRequest) (_ *ResolveResponse, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	response := new(ResolveResponse)
+	if err := client.invoke(ctx, "Resolve", request, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// Close closes the connection.
+func (client *Client) Close() error {
+	return Error.Wrap(client.conn.Close())
+}
+
+func (client *Client) invoke(ctx context.Context, method string, request, response interface{}) error {
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+client.token)
+	return client.conn.Invoke(ctx, "/"+ServiceName+"/"+method, request, response, grpc.CallContentSubtype(codecName))
+}
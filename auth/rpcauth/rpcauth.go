@@ -0,0 +1,128 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package rpcauth serves the registration and resolution of accesses over
+// gRPC, for clients like the gateway that make many requests to the auth
+// service and benefit from persistent, multiplexed connections and typed
+// errors. Messages are encoded as json, like the http api, so that the service
+// needs no generated code; Client is its typed Go client.
+package rpcauth
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+
+	"storj.io/stargate/auth"
+)
+
+var mon = monkit.Package()
+
+// Error is the errs class of rpcauth errors.
+var Error = errs.Class("rpcauth")
+
+// ServiceName is the name of the gRPC service.
+const ServiceName = "stargate.auth.v1.Auth"
+
+// codecName is the content subtype of the messages, which are encoded as json.
+const codecName = "json"
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// jsonCodec encodes messages as json.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                               { return codecName }
+
+// RegisterRequest registers an access, like POST /v1/access.
+type RegisterRequest struct {
+	AccessGrant string            `json:"access_grant"`
+	Routes      []auth.Route      `json:"routes,omitempty"`
+	Public      bool              `json:"public"`
+	ExpiresAt   *time.Time        `json:"expires_at,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// RegisterResponse has the credentials of a registered access.
+type RegisterResponse struct {
+	AccessKeyID string `json:"access_key_id"`
+	SecretKey   string `json:"secret_key"`
+	Endpoint    string `json:"endpoint"`
+}
+
+// ResolveRequest resolves an access key, like GET /v1/access/{access key id}.
+type ResolveRequest struct {
+	AccessKeyID string `json:"access_key_id"`
+}
+
+// ResolveResponse is the access that an access key resolved to.
+type ResolveResponse struct {
+	AccessGrant string            `json:"access_grant"`
+	Routes      []auth.Route      `json:"routes,omitempty"`
+	SecretKey   string            `json:"secret_key,omitempty"`
+	Public      bool              `json:"public"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	LastUsedAt  *time.Time        `json:"last_used_at,omitempty"`
+	UseCount    int64             `json:"use_count"`
+}
+
+// AuthServer is the gRPC service of the auth service.
+type AuthServer interface {
+	Register(ctx context.Context, request *RegisterRequest) (*RegisterResponse, error)
+	Resolve(ctx context.Context, request *ResolveRequest) (*ResolveResponse, error)
+}
+
+// RegisterServer registers server as the auth service of s.
+func RegisterServer(s *grpc.Server, server AuthServer) {
+	s.RegisterService(&serviceDesc, server)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*AuthServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Register",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				request := new(RegisterRequest)
+				if err := dec(request); err != nil {
+					return nil, err
+				}
+				handler := func(ctx context.Context, request interface{}) (interface{}, error) {
+					return srv.(AuthServer).Register(ctx, request.(*RegisterRequest))
+				}
+				if interceptor == nil {
+					return handler(ctx, request)
+				}
+				return interceptor(ctx, request, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + ServiceName + "/Register"}, handler)
+			},
+		},
+		{
+			MethodName: "Resolve",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				request := new(ResolveRequest)
+				if err := dec(request); err != nil {
+					return nil, err
+				}
+				handler := func(ctx context.Context, request interface{}) (interface{}, error) {
+					return srv.(AuthServer).Resolve(ctx, request.(*ResolveRequest))
+				}
+				if interceptor == nil {
+					return handler(ctx, request)
+				}
+				return interceptor(ctx, request, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + ServiceName + "/Resolve"}, handler)
+			},
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "rpcauth",
+}
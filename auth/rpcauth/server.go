@@ -0,0 +1,246 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package rpcauth
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcutil/base58"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"storj.io/stargate/auth"
+	"storj.io/stargate/auth/httpauth"
+	"storj.io/stargate/internal/bruteforce"
+	"storj.io/stargate/internal/trustedproxy"
+)
+
+// RoleFunc returns the role of the auth token of an Authorization header,
+// like (*httpauth.Resources).Role does.
+type RoleFunc func(authorization string) httpauth.Role
+
+// Server serves the auth service over gRPC. Requests are authorized like the
+// ones of the http api: with an auth token in the authorization metadata,
+// like "Bearer token".
+type Server struct {
+	db       *auth.Database
+	endpoint string
+	role     RoleFunc
+	limiter  *bruteforce.Limiter
+	proxies  *trustedproxy.Proxies
+}
+
+// NewServer constructs a Server for the database. Failed access key lookups
+// are counted by limiter, which may be nil to disable brute-force protection.
+// It should be the limiter of the http api, so that both count the same
+// failures.
+func NewServer(db *auth.Database, endpoint string, role RoleFunc, limiter *bruteforce.Limiter) *Server {
+	return &Server{
+		db:       db,
+		endpoint: endpoint,
+		role:     role,
+		limiter:  limiter,
+	}
+}
+
+// Register registers an access. It needs the register role.
+func (server *Server) Register(ctx context.Context, request *RegisterRequest) (_ *RegisterResponse, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	role, err := server.authorize(ctx, httpauth.RoleRegister)
+	if err != nil {
+		return nil, err
+	}
+	if err := auth.ValidateAccessGrant(request.AccessGrant); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if request.ExpiresAt != nil && !time.Now().Before(*request.ExpiresAt) {
+		return nil, status.Error(codes.InvalidArgument, "expires_at must be in the future")
+	}
+	if err := auth.ValidateRoutes(request.Routes); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := auth.ValidateLabels(request.Labels); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	var key auth.EncryptionKey
+	if _, err := rand.Read(key[:]); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	// admin tokens own nothing, so that they have no quota, like over http
+	var owner string
+	if role != httpauth.RoleAdmin {
+		owner = auth.TokenOwner(strings.TrimPrefix(firstMetadata(ctx, "authorization"), "Bearer "))
+	}
+
+	secretKey, err := server.db.PutOwned(ctx, owner, key, request.AccessGrant, request.Routes, request.Public, request.ExpiresAt, request.Labels)
+	if err != nil {
+		return nil, databaseError(err, "error storing request in database")
+	}
+
+	event := auth.HistoryEvent{
+		Action:   auth.HistoryCreated,
+		SourceIP: server.clientIP(ctx),
+		Actor:    firstMetadata(ctx, "x-actor"),
+	}
+	if event.Actor == "" {
+		event.Actor = string(role)
+	}
+	if err := server.db.AppendHistory(ctx, key, event); err != nil {
+		return nil, databaseError(err, "error storing history in database")
+	}
+
+	return &RegisterResponse{
+		AccessKeyID: base58.CheckEncode(key[:], auth.VersionAccessKeyID),
+		SecretKey:   base58.CheckEncode(secretKey, auth.VersionSecretKey),
+		Endpoint:    server.endpoint,
+	}, nil
+}
+
+// Resolve resolves an access key to its access. It needs the read role.
+func (server *Server) Resolve(ctx context.Context, request *ResolveRequest) (_ *ResolveResponse, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if _, err := server.authorize(ctx, httpauth.RoleRead); err != nil {
+		return nil, err
+	}
+
+	limiterKeys := server.limiterKeys(ctx, request.AccessKeyID)
+	if _, ok := server.limiter.Allowed(limiterKeys...); !ok {
+		return nil, status.Error(codes.ResourceExhausted, "too many failed attempts")
+	}
+
+	key, err := parseAccessKeyID(request.AccessKeyID)
+	if err != nil {
+		server.limiter.Failure(limiterKeys...)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	access, err := server.db.Resolve(ctx, key)
+	if err != nil {
+		if auth.NotFound.Has(err) || auth.Invalid.Has(err) {
+			server.limiter.Failure(limiterKeys...)
+		}
+		switch {
+		case auth.NotFound.Has(err):
+			return nil, status.Error(codes.NotFound, err.Error())
+		case auth.Invalid.Has(err):
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+		return nil, databaseError(err, err.Error())
+	}
+
+	response := &ResolveResponse{
+		AccessGrant: access.AccessGrant,
+		Routes:      access.Routes,
+		Public:      access.Public,
+		Labels:      access.Labels,
+		LastUsedAt:  access.LastUsedAt,
+		UseCount:    access.UseCount,
+	}
+	// derived secret keys are left out, like in the http api
+	if !access.SecretKeyHashed && !server.db.DerivesSecretKeys() {
+		response.SecretKey = base58.CheckEncode(access.SecretKey, auth.VersionSecretKey)
+	}
+	return response, nil
+}
+
+// authorize returns the role of the auth token of the request if it has
+// role, which admin tokens always have.
+func (server *Server) authorize(ctx context.Context, role httpauth.Role) (httpauth.Role, error) {
+	switch actual := server.role(firstMetadata(ctx, "authorization")); actual {
+	case role, httpauth.RoleAdmin:
+		return actual, nil
+	case "":
+		return "", status.Error(codes.Unauthenticated, "unauthorized")
+	default:
+		return "", status.Error(codes.PermissionDenied, "unauthorized")
+	}
+}
+
+// databaseError returns the status for an error of the database. If the
+// database is unavailable, the client may retry later, what the database
+// can't do is unimplemented, and registrations beyond the quota of their
+// owner have exhausted it.
+func databaseError(err error, message string) error {
+	if auth.QuotaExceeded.Has(err) {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	var unavailable *auth.UnavailableError
+	if errors.As(err, &unavailable) {
+		return status.Error(codes.Unavailable, "database unavailable")
+	}
+	if auth.Unsupported.Has(err) {
+		return status.Error(codes.Unimplemented, err.Error())
+	}
+	return status.Error(codes.Internal, message)
+}
+
+// parseAccessKeyID decodes an access key id to the key of its access.
+func parseAccessKeyID(accessKeyID string) (key auth.EncryptionKey, err error) {
+	decoded, version, err := base58.CheckDecode(accessKeyID)
+	if err != nil {
+		return key, Error.Wrap(err)
+	}
+	if len(decoded) != len(key) {
+		return key, Error.New("invalid access key id length")
+	}
+	if version != auth.VersionAccessKeyID {
+		return key, Error.New("unexpected decoded version")
+	}
+	copy(key[:], decoded)
+	return key, nil
+}
+
+// firstMetadata returns the first value of the incoming metadata key, or an
+// empty string if there is none.
+func firstMetadata(ctx context.Context, key string) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// SetTrustedProxies makes the x-forwarded-for addresses that the proxies add
+// the ips of clients, like with the http api.
+func (server *Server) SetTrustedProxies(proxies *trustedproxy.Proxies) {
+	server.proxies = proxies
+}
+
+// clientIP returns the ip of the client that the request is made for.
+func (server *Server) clientIP(ctx context.Context) string {
+	remoteAddr, forwarded := connection(ctx)
+	return server.proxies.ClientIP(remoteAddr, forwarded)
+}
+
+// limiterKeys returns the keys that failed lookups of the access key are
+// counted for by the limiter. Callers are always authorized, so their own ips
+// are never banned, like with the http api; only the clients that they
+// forward as a trusted proxy are counted.
+func (server *Server) limiterKeys(ctx context.Context, accessKeyID string) []string {
+	keys := []string{"key:" + accessKeyID}
+	if ip, ok := server.proxies.ForwardedIP(connection(ctx)); ok {
+		keys = append(keys, "ip:"+ip)
+	}
+	return keys
+}
+
+// connection returns the remote address of the connection of the request, and
+// the x-forwarded-for addresses that it was sent with.
+func connection(ctx context.Context) (remoteAddr, forwarded string) {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		remoteAddr = p.Addr.String()
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	return remoteAddr, strings.Join(md.Get("x-forwarded-for"), ",")
+}
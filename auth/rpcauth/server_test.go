@@ -0,0 +1,85 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package rpcauth_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcutil/base58"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"storj.io/stargate/auth"
+	"storj.io/stargate/auth/httpauth"
+	"storj.io/stargate/auth/memauth"
+	"storj.io/stargate/auth/rpcauth"
+)
+
+const minimalAccess = "138CV9Drxrw8ir1XpxcZhk2wnHjhzVjuSZe6yDsNiMZDP8cow9V6sHDYdwgvYoQGgqVvoMnxdWDbpBiEPW5oP7DtPJ5sZx2MVxFrUoZYFfVAgxidW"
+
+func TestServer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	db := auth.NewDatabase(memauth.New())
+	res := httpauth.New(db, "endpoint", httpauth.Tokens{"register": httpauth.RoleRegister, "read": httpauth.RoleRead}, nil)
+
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	rpcauth.RegisterServer(server, rpcauth.NewServer(db, "endpoint", res.Role, nil))
+	go func() { _ = server.Serve(listener) }()
+	defer server.Stop()
+
+	dial := func(token string) *rpcauth.Client {
+		client, err := rpcauth.Dial(ctx, "bufnet", token, grpc.WithInsecure(),
+			grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }))
+		require.NoError(t, err)
+		return client
+	}
+	register, read, unknown := dial("register"), dial("read"), dial("unknown")
+	defer func() {
+		require.NoError(t, register.Close())
+		require.NoError(t, read.Close())
+		require.NoError(t, unknown.Close())
+	}()
+
+	registered, err := register.Register(ctx, &rpcauth.RegisterRequest{
+		AccessGrant: minimalAccess,
+		Public:      true,
+		Labels:      map[string]string{"team": "storage"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "endpoint", registered.Endpoint)
+
+	resolved, err := read.Resolve(ctx, &rpcauth.ResolveRequest{AccessKeyID: registered.AccessKeyID})
+	require.NoError(t, err)
+	require.Equal(t, &rpcauth.ResolveResponse{
+		AccessGrant: minimalAccess,
+		SecretKey:   registered.SecretKey,
+		Public:      true,
+		Labels:      map[string]string{"team": "storage"},
+	}, resolved)
+
+	// errors are typed
+	_, err = read.Register(ctx, &rpcauth.RegisterRequest{AccessGrant: minimalAccess})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = unknown.Resolve(ctx, &rpcauth.ResolveRequest{AccessKeyID: registered.AccessKeyID})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = read.Resolve(ctx, &rpcauth.ResolveRequest{AccessKeyID: "invalid"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = register.Register(ctx, &rpcauth.RegisterRequest{AccessGrant: minimalAccess, ExpiresAt: &time.Time{}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = register.Register(ctx, &rpcauth.RegisterRequest{AccessGrant: "invalid"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	var missing auth.EncryptionKey
+	_, err = read.Resolve(ctx, &rpcauth.ResolveRequest{AccessKeyID: base58.CheckEncode(missing[:], auth.VersionAccessKeyID)})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
@@ -0,0 +1,177 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"strconv"
+	"strings"
+
+	"github.com/zeebo/errs"
+)
+
+// minDerivationKeySize is the smallest key that secret keys are derived from.
+const minDerivationKeySize = 32
+
+// The versions of the hashes of derived secret keys, which records store in
+// place of encrypted secret keys. Version 2 hashes have the id of the
+// derivation key after the version, and version 1 hashes, which have none,
+// were derived with the derivation key with id 0.
+const (
+	secretKeyHashV1      byte = 1
+	secretKeyHashVersion byte = 2
+)
+
+// The sizes of the hashes of derived secret keys, which differ from
+// encryptedSecretKeySize.
+const (
+	secretKeyHashV1Size = 1 + sha256.Size
+	secretKeyHashSize   = 2 + sha256.Size
+)
+
+// SecretKeyHashed returns whether the record stores the hash of a secret key
+// that is derived with a derivation key of the database, instead of the
+// encrypted secret key.
+func (r *Record) SecretKeyHashed() bool {
+	switch len(r.EncryptedSecretKey) {
+	case secretKeyHashV1Size:
+		return r.EncryptedSecretKey[0] == secretKeyHashV1
+	case secretKeyHashSize:
+		return r.EncryptedSecretKey[0] == secretKeyHashVersion
+	default:
+		return false
+	}
+}
+
+// derivationKeyID returns the id of the derivation key of a record whose
+// secret key is hashed.
+func (r *Record) derivationKeyID() byte {
+	if r.EncryptedSecretKey[0] == secretKeyHashV1 {
+		return 0
+	}
+	return r.EncryptedSecretKey[1]
+}
+
+// DerivationKeys are the keys that secret keys are derived from, by their ids.
+// The secret keys of new accesses are derived from the current key, and the
+// other keys are kept so that the accesses whose secret keys were derived
+// from them keep working while the derivation key is rotated.
+type DerivationKeys struct {
+	current byte
+	keys    map[byte][]byte
+}
+
+// ParseDerivationKeys parses comma separated base64 encoded keys of at least
+// 32 bytes, which are prefixed with their ids from 0 to 255, like
+// 1:<key>,0:<previous key>. The first key is the current one, and a key
+// without an id has the id 0.
+func ParseDerivationKeys(s string) (*DerivationKeys, error) {
+	keys := &DerivationKeys{keys: make(map[byte][]byte)}
+	for i, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		id := 0
+		if colon := strings.IndexByte(entry, ':'); colon >= 0 {
+			var err error
+			if id, err = strconv.Atoi(entry[:colon]); err != nil || id < 0 || id > 255 {
+				return nil, errs.New("secret key derivation key %d has an invalid id %q", i, entry[:colon])
+			}
+			entry = entry[colon+1:]
+		}
+
+		key, err := base64.StdEncoding.DecodeString(entry)
+		if err != nil {
+			return nil, errs.New("secret key derivation key %d is not valid base64: %v", i, err)
+		}
+		if len(key) < minDerivationKeySize {
+			return nil, errs.New("secret key derivation key %d has %d bytes instead of at least %d", i, len(key), minDerivationKeySize)
+		}
+		if _, ok := keys.keys[byte(id)]; ok {
+			return nil, errs.New("secret key derivation key %d has the id %d of another key", i, id)
+		}
+		if i == 0 {
+			keys.current = byte(id)
+		}
+		keys.keys[byte(id)] = key
+	}
+	return keys, nil
+}
+
+// DeriveSecretKey returns the secret key of the access with the key hash that
+// is derived from derivationKey. Gateways that have the derivation keys
+// derive secret keys with it to check the signatures of requests, so that
+// the auth service doesn't have to send them.
+func DeriveSecretKey(derivationKey []byte, keyHash KeyHash) []byte {
+	mac := hmac.New(sha256.New, derivationKey)
+	_, _ = mac.Write([]byte("secret key"))
+	_, _ = mac.Write(keyHash[:])
+	return mac.Sum(nil)
+}
+
+// SecretKeys returns the secret keys that the access with the key may have,
+// which are derived from every derivation key, the current one first.
+func (keys *DerivationKeys) SecretKeys(key EncryptionKey) [][]byte {
+	keyHash := key.Hash()
+	secretKeys := [][]byte{DeriveSecretKey(keys.keys[keys.current], keyHash)}
+	for id, derivationKey := range keys.keys {
+		if id != keys.current {
+			secretKeys = append(secretKeys, DeriveSecretKey(derivationKey, keyHash))
+		}
+	}
+	return secretKeys
+}
+
+// SetSecretKeyDerivation makes the database derive the secret keys of new
+// accesses from the current derivation key and the key hashes of the
+// accesses, and only store hashes of them together with the id of the
+// derivation key. A leaked database then doesn't leak the secret keys, even
+// with the access key ids. Accesses whose secret keys are encrypted keep
+// working, but accesses with derived secret keys can't be resolved once
+// their derivation key is removed from keys.
+func (db *Database) SetSecretKeyDerivation(keys *DerivationKeys) {
+	db.derivationKeys = keys
+}
+
+// DerivesSecretKeys returns whether the database derives secret keys.
+func (db *Database) DerivesSecretKeys() bool {
+	return db.derivationKeys != nil
+}
+
+// hashSecretKey returns the hash of a secret key that is derived with the
+// derivation key with id, which is stored in place of the encrypted secret
+// key.
+func hashSecretKey(id byte, secretKey []byte) []byte {
+	hash := sha256.Sum256(secretKey)
+	return append([]byte{secretKeyHashVersion, id}, hash[:]...)
+}
+
+// newSecretKey returns a secret key that is derived from the current
+// derivation key, and the hash to store in place of the encrypted secret key.
+func (db *Database) newSecretKey(keyHash KeyHash) (secretKey, hash []byte) {
+	keys := db.derivationKeys
+	secretKey = DeriveSecretKey(keys.keys[keys.current], keyHash)
+	return secretKey, hashSecretKey(keys.current, secretKey)
+}
+
+// derivedSecretKey returns the secret key of a record whose secret key is
+// hashed, after checking that it matches the hash.
+func (db *Database) derivedSecretKey(keyHash KeyHash, record *Record) ([]byte, error) {
+	if db.derivationKeys == nil {
+		return nil, errs.New("the secret key of the record is derived, but there are no derivation keys")
+	}
+	id := record.derivationKeyID()
+	derivationKey, ok := db.derivationKeys.keys[id]
+	if !ok {
+		return nil, errs.New("the secret key of the record is derived with the derivation key %d, which is unknown", id)
+	}
+
+	secretKey := DeriveSecretKey(derivationKey, keyHash)
+	hash := sha256.Sum256(secretKey)
+	if subtle.ConstantTimeCompare(hash[:], record.EncryptedSecretKey[len(record.EncryptedSecretKey)-sha256.Size:]) != 1 {
+		return nil, Undecryptable.New("the secret key of the record was derived with another derivation key with the id %d", id)
+	}
+	return secretKey, nil
+}
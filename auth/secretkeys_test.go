@@ -0,0 +1,145 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package auth_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/stargate/auth"
+	"storj.io/stargate/auth/memauth"
+)
+
+// derivationKey returns a base64 encoded derivation key of size bytes.
+func derivationKey(b byte, size int) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, size))
+}
+
+// derivationKeys parses s, which must be valid.
+func derivationKeys(t *testing.T, s string) *auth.DerivationKeys {
+	keys, err := auth.ParseDerivationKeys(s)
+	require.NoError(t, err)
+	return keys
+}
+
+func TestParseDerivationKeys(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"not base64",
+		derivationKey(1, 16),
+		"256:" + derivationKey(1, 32),
+		"x:" + derivationKey(1, 32),
+		"1:" + derivationKey(1, 32) + ",1:" + derivationKey(2, 32),
+		derivationKey(1, 32) + ",0:" + derivationKey(2, 32),
+	} {
+		_, err := auth.ParseDerivationKeys(s)
+		require.Error(t, err, s)
+	}
+
+	keys := derivationKeys(t, "1:"+derivationKey(1, 32)+", "+derivationKey(2, 32))
+	secretKeys := keys.SecretKeys(auth.EncryptionKey{1})
+	require.Len(t, secretKeys, 2)
+	// the current key is first
+	require.Equal(t, auth.DeriveSecretKey(bytes.Repeat([]byte{1}, 32), auth.EncryptionKey{1}.Hash()), secretKeys[0])
+	require.Equal(t, auth.DeriveSecretKey(bytes.Repeat([]byte{2}, 32), auth.EncryptionKey{1}.Hash()), secretKeys[1])
+}
+
+func TestDatabase_SecretKeyDerivation(t *testing.T) {
+	ctx := context.Background()
+	kv := memauth.New()
+
+	// accesses registered before the derivation key was set keep working
+	encrypted := auth.EncryptionKey{1}
+	encryptedSecretKey, err := auth.NewDatabase(kv).Put(ctx, encrypted, minimalAccess, nil, false, nil, nil)
+	require.NoError(t, err)
+
+	db := auth.NewDatabase(kv)
+	db.SetSecretKeyDerivation(derivationKeys(t, derivationKey(1, 32)))
+
+	derived := auth.EncryptionKey{2}
+	secretKey, err := db.Put(ctx, derived, minimalAccess, nil, false, nil, nil)
+	require.NoError(t, err)
+
+	// only the hash of the secret key is stored
+	record, err := kv.Get(ctx, derived.Hash())
+	require.NoError(t, err)
+	require.True(t, record.SecretKeyHashed())
+	require.False(t, bytes.Contains(record.EncryptedSecretKey, secretKey))
+
+	_, _, _, _, fetched, err := db.Get(ctx, derived)
+	require.NoError(t, err)
+	require.Equal(t, secretKey, fetched)
+
+	record, err = kv.Get(ctx, encrypted.Hash())
+	require.NoError(t, err)
+	require.False(t, record.SecretKeyHashed())
+	_, _, _, _, fetched, err = db.Get(ctx, encrypted)
+	require.NoError(t, err)
+	require.Equal(t, encryptedSecretKey, fetched)
+
+	// derived secret keys can't be resolved without the same derivation key
+	_, _, _, _, _, err = auth.NewDatabase(kv).Get(ctx, derived)
+	require.Error(t, err)
+
+	other := auth.NewDatabase(kv)
+	other.SetSecretKeyDerivation(derivationKeys(t, derivationKey(2, 32)))
+	_, _, _, _, _, err = other.Get(ctx, derived)
+	require.True(t, auth.Undecryptable.Has(err), "expected an undecryptable error, got %v", err)
+}
+
+func TestDatabase_SecretKeyDerivationRotation(t *testing.T) {
+	ctx := context.Background()
+	kv := memauth.New()
+
+	db := auth.NewDatabase(kv)
+	db.SetSecretKeyDerivation(derivationKeys(t, derivationKey(1, 32)))
+	old := auth.EncryptionKey{1}
+	oldSecretKey, err := db.Put(ctx, old, minimalAccess, nil, false, nil, nil)
+	require.NoError(t, err)
+
+	// after the rotation, new accesses use the new key and old ones keep working
+	rotated := auth.NewDatabase(kv)
+	rotated.SetSecretKeyDerivation(derivationKeys(t, "1:"+derivationKey(2, 32)+",0:"+derivationKey(1, 32)))
+	current := auth.EncryptionKey{2}
+	currentSecretKey, err := rotated.Put(ctx, current, minimalAccess, nil, false, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, auth.DeriveSecretKey(bytes.Repeat([]byte{2}, 32), current.Hash()), currentSecretKey)
+
+	_, _, _, _, fetched, err := rotated.Get(ctx, old)
+	require.NoError(t, err)
+	require.Equal(t, oldSecretKey, fetched)
+	_, _, _, _, fetched, err = rotated.Get(ctx, current)
+	require.NoError(t, err)
+	require.Equal(t, currentSecretKey, fetched)
+
+	// once the old key is removed, only the new accesses resolve
+	removed := auth.NewDatabase(kv)
+	removed.SetSecretKeyDerivation(derivationKeys(t, "1:"+derivationKey(2, 32)))
+	_, _, _, _, _, err = removed.Get(ctx, old)
+	require.Error(t, err)
+	_, _, _, _, fetched, err = removed.Get(ctx, current)
+	require.NoError(t, err)
+	require.Equal(t, currentSecretKey, fetched)
+
+	// hashes without the id of the derivation key were derived with the key 0
+	legacy := auth.EncryptionKey{3}
+	legacySecretKey, err := db.Put(ctx, legacy, minimalAccess, nil, false, nil, nil)
+	require.NoError(t, err)
+	record, err := kv.Get(ctx, legacy.Hash())
+	require.NoError(t, err)
+	hash := sha256.Sum256(legacySecretKey)
+	record.EncryptedSecretKey = append([]byte{1}, hash[:]...)
+	require.True(t, record.SecretKeyHashed())
+	require.NoError(t, kv.Delete(ctx, legacy.Hash()))
+	require.NoError(t, kv.Put(ctx, legacy.Hash(), record))
+
+	_, _, _, _, fetched, err = rotated.Get(ctx, legacy)
+	require.NoError(t, err)
+	require.Equal(t, legacySecretKey, fetched)
+}
@@ -0,0 +1,646 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package shardauth spreads records over several KV backends.
+//
+// Every key is stored in the shard that owns it on a consistent hash ring, so
+// adding or removing a shard only moves the keys of the shards next to it on
+// the ring. The shard map can be changed while serving: after Reshard, records
+// are stored in their new shards, lookups fall back to the previous shards, and
+// changes are made in both until Rebalance has moved every record.
+package shardauth
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
+
+	"storj.io/stargate/auth"
+)
+
+var mon = monkit.Package()
+
+// Error is the error class for this package.
+var Error = errs.Class("shard")
+
+// virtualNodes is the number of points of every shard on the ring, which
+// evens out how many keys the shards own.
+const virtualNodes = 128
+
+func init() {
+	auth.RegisterKV("shard", openURL)
+}
+
+// openURL opens a KV for a url like shard://?a=postgres://...&b=postgres://...
+// whose query maps the names of the shards to the escaped database urls of
+// their backends.
+func openURL(ctx context.Context, databaseURL string) (_ auth.KV, err error) {
+	parsed, err := url.Parse(databaseURL)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	var shards []Shard
+	defer func() {
+		if err != nil {
+			for _, shard := range shards {
+				err = errs.Combine(err, auth.Close(shard.KV))
+			}
+		}
+	}()
+
+	for name, urls := range parsed.Query() {
+		if len(urls) != 1 {
+			return nil, Error.New("shard %q must have exactly one database url", name)
+		}
+		kv, err := auth.OpenKV(ctx, urls[0])
+		if err != nil {
+			return nil, Error.New("shard %q: %v", name, err)
+		}
+		shards = append(shards, Shard{Name: name, KV: kv})
+	}
+	return New(shards)
+}
+
+// Shard is a named backend. The name places the shard on the ring, so it must
+// stay the same when the shard map changes.
+type Shard struct {
+	Name string
+	KV   auth.KV
+}
+
+// ring maps keys to the names of the shards that own them.
+type ring struct {
+	points []uint64 // sorted
+	owners []string // owners[i] owns the keys up to points[i]
+}
+
+// newRing places every shard on a ring.
+func newRing(names []string) *ring {
+	type point struct {
+		position uint64
+		owner    string
+	}
+	var points []point
+	for _, name := range names {
+		for i := 0; i < virtualNodes; i++ {
+			hash := sha256.Sum256([]byte(name + "#" + strconv.Itoa(i)))
+			points = append(points, point{binary.BigEndian.Uint64(hash[:8]), name})
+		}
+	}
+	sort.Slice(points, func(i, k int) bool { return points[i].position < points[k].position })
+
+	r := &ring{
+		points: make([]uint64, len(points)),
+		owners: make([]string, len(points)),
+	}
+	for i, point := range points {
+		r.points[i], r.owners[i] = point.position, point.owner
+	}
+	return r
+}
+
+// owner returns the name of the shard that owns the key.
+func (r *ring) owner(keyHash auth.KeyHash) string {
+	position := binary.BigEndian.Uint64(keyHash[:8])
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= position })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[i]
+}
+
+// KV is a key/value store that spreads records over several other KVs.
+type KV struct {
+	rebalance sync.Mutex // held while rebalancing
+
+	mu       sync.RWMutex
+	shards   map[string]auth.KV // including the shards of the previous ring
+	current  *ring
+	previous *ring // set until the records are rebalanced after a Reshard
+}
+
+// New constructs a KV that spreads records over the shards.
+func New(shards []Shard) (*KV, error) {
+	if len(shards) == 0 {
+		return nil, Error.New("at least one shard is required")
+	}
+
+	kv := &KV{shards: make(map[string]auth.KV, len(shards))}
+	names := make([]string, 0, len(shards))
+	for _, shard := range shards {
+		if _, ok := kv.shards[shard.Name]; ok || shard.KV == nil {
+			return nil, Error.New("shard %q is duplicated or has no KV", shard.Name)
+		}
+		kv.shards[shard.Name] = shard.KV
+		names = append(names, shard.Name)
+	}
+	kv.current = newRing(names)
+	return kv, nil
+}
+
+// Reshard changes the shards that own the keys. The KV of a shard that
+// already exists may be nil to keep using it. Until Rebalance finishes, the
+// shards that are removed keep being used for the records that they have.
+// It is an error to reshard again before the records are rebalanced.
+func (d *KV) Reshard(shards []Shard) error {
+	if len(shards) == 0 {
+		return Error.New("at least one shard is required")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.previous != nil {
+		return Error.New("records must be rebalanced before resharding again")
+	}
+
+	seen := make(map[string]bool, len(shards))
+	names := make([]string, 0, len(shards))
+	for _, shard := range shards {
+		if seen[shard.Name] {
+			return Error.New("shard %q is duplicated", shard.Name)
+		}
+		seen[shard.Name] = true
+		if _, ok := d.shards[shard.Name]; !ok && shard.KV == nil {
+			return Error.New("new shard %q has no KV", shard.Name)
+		}
+		names = append(names, shard.Name)
+	}
+
+	for _, shard := range shards {
+		if _, ok := d.shards[shard.Name]; !ok {
+			d.shards[shard.Name] = shard.KV
+		}
+	}
+	d.previous, d.current = d.current, newRing(names)
+	mon.Event("shards_changed")
+	return nil
+}
+
+// Rebalance moves every record that isn't in the shard that owns it to that
+// shard, and then closes the shards that were removed. It can be run again if
+// it fails, and it is a no-op if the shards haven't changed since the last
+// rebalance.
+func (d *KV) Rebalance(ctx context.Context) (moved int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	d.rebalance.Lock()
+	defer d.rebalance.Unlock()
+
+	d.mu.RLock()
+	migrating := d.previous != nil
+	shards := make(map[string]auth.KV, len(d.shards))
+	for name, kv := range d.shards {
+		shards[name] = kv
+	}
+	d.mu.RUnlock()
+
+	if !migrating {
+		return 0, nil
+	}
+
+	for name, kv := range shards {
+		n, err := d.moveRecords(ctx, name, kv)
+		moved += n
+		if err != nil {
+			return moved, err
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	used := make(map[string]bool)
+	for _, owner := range d.current.owners {
+		used[owner] = true
+	}
+	for name, kv := range d.shards {
+		if !used[name] {
+			delete(d.shards, name)
+			err = errs.Combine(err, auth.Close(kv))
+		}
+	}
+	d.previous = nil
+	return moved, err
+}
+
+// moveRecords copies the records of the shard that it doesn't own to their
+// owners, and then deletes them from the shard. Records that already exist in
+// their owner were stored there since the reshard, so they are kept.
+func (d *KV) moveRecords(ctx context.Context, name string, kv auth.KV) (moved int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	var toDelete []auth.KeyHash
+	err = auth.Iterate(ctx, kv, func(ctx context.Context, entry auth.Entry) error {
+		d.mu.RLock()
+		owner := d.current.owner(entry.KeyHash)
+		destination := d.shards[owner]
+		d.mu.RUnlock()
+
+		if owner == name {
+			return nil
+		}
+
+		existing, err := destination.Get(ctx, entry.KeyHash)
+		switch {
+		case existing != nil || auth.Invalid.Has(err):
+		case err != nil:
+			return err
+		default:
+			if err := destination.Put(ctx, entry.KeyHash, entry.Record); err != nil {
+				return err
+			}
+			if entry.InvalidReason != "" {
+				if err := destination.Invalidate(ctx, entry.KeyHash, entry.InvalidReason); err != nil {
+					return err
+				}
+			}
+			if entry.DeletedAt != nil {
+				if err := auth.SoftDelete(ctx, destination, entry.KeyHash); err != nil {
+					return err
+				}
+			}
+		}
+
+		toDelete = append(toDelete, entry.KeyHash)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, keyHash := range toDelete {
+		if err := kv.Delete(ctx, keyHash); err != nil {
+			return moved, err
+		}
+		moved++
+	}
+	mon.IntVal("shard_records_moved").Observe(moved)
+	return moved, nil
+}
+
+// owners returns the shard that owns the key, and the shard that owned it
+// before the last reshard if that is another one and the records haven't been
+// rebalanced yet.
+func (d *KV) owners(keyHash auth.KeyHash) (owner, previous auth.KV) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	name := d.current.owner(keyHash)
+	owner = d.shards[name]
+	if d.previous != nil {
+		if previousName := d.previous.owner(keyHash); previousName != name {
+			previous = d.shards[previousName]
+		}
+	}
+	return owner, previous
+}
+
+// all returns every shard.
+func (d *KV) all() []auth.KV {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	shards := make([]auth.KV, 0, len(d.shards))
+	for _, kv := range d.shards {
+		shards = append(shards, kv)
+	}
+	return shards
+}
+
+// Put stores the record in the shard that owns the key.
+// It is an error if the key already exists in that shard.
+func (d *KV) Put(ctx context.Context, keyHash auth.KeyHash, record *auth.Record) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	owner, _ := d.owners(keyHash)
+	return owner.Put(ctx, keyHash, record)
+}
+
+// PutBatch stores all of the records in the shards that own their keys.
+// It is an error if any of the keys already exist. The records of a shard are
+// stored together, but a batch for several shards is not atomic.
+func (d *KV) PutBatch(ctx context.Context, entries []auth.Entry) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	batches := make(map[auth.KV][]auth.Entry)
+	for _, entry := range entries {
+		owner, _ := d.owners(entry.KeyHash)
+		batches[owner] = append(batches[owner], entry)
+	}
+	for owner, batch := range batches {
+		if err := auth.PutBatch(ctx, owner, batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get retrieves the record from the shard that owns the key, or from the
+// shard that owned it before if it hasn't been moved yet.
+// It returns nil if the key does not exist.
+// If the record is invalid or expired, the error contains why.
+func (d *KV) Get(ctx context.Context, keyHash auth.KeyHash) (record *auth.Record, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	owner, previous := d.owners(keyHash)
+	record, err = owner.Get(ctx, keyHash)
+	if record != nil || err != nil || previous == nil {
+		return record, err
+	}
+	return previous.Get(ctx, keyHash)
+}
+
+// GetBatch retrieves the records for all of the keys from the shards that own
+// them, or that owned them before. A record is nil if its key does not exist
+// or if it is invalid or expired.
+func (d *KV) GetBatch(ctx context.Context, keyHashes []auth.KeyHash) (records []*auth.Record, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	records = make([]*auth.Record, len(keyHashes))
+	fallback := make(map[auth.KV][]int)
+	batches := make(map[auth.KV][]int)
+	for i, keyHash := range keyHashes {
+		owner, _ := d.owners(keyHash)
+		batches[owner] = append(batches[owner], i)
+	}
+
+	get := func(kv auth.KV, indexes []int) error {
+		batch := make([]auth.KeyHash, len(indexes))
+		for j, i := range indexes {
+			batch[j] = keyHashes[i]
+		}
+		fetched, err := auth.GetBatch(ctx, kv, batch)
+		if err != nil {
+			return err
+		}
+		for j, i := range indexes {
+			records[i] = fetched[j]
+		}
+		return nil
+	}
+
+	for owner, indexes := range batches {
+		if err := get(owner, indexes); err != nil {
+			return nil, err
+		}
+	}
+	for i, record := range records {
+		if _, previous := d.owners(keyHashes[i]); record == nil && previous != nil {
+			fallback[previous] = append(fallback[previous], i)
+		}
+	}
+	for previous, indexes := range fallback {
+		if err := get(previous, indexes); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// Delete removes the record from the shard that owns the key, and from the
+// shard that owned it before.
+// It is not an error if the key does not exist.
+func (d *KV) Delete(ctx context.Context, keyHash auth.KeyHash) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return d.both(keyHash, func(kv auth.KV) error {
+		return kv.Delete(ctx, keyHash)
+	})
+}
+
+// SoftDelete marks the record as deleted in the shard that owns the key, and
+// in the shard that owned it before.
+// It is not an error if the key does not exist.
+func (d *KV) SoftDelete(ctx context.Context, keyHash auth.KeyHash) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return d.both(keyHash, func(kv auth.KV) error {
+		return auth.SoftDelete(ctx, kv, keyHash)
+	})
+}
+
+// Restore undoes SoftDelete, and returns whether the record was deleted.
+// It is not an error if the key does not exist.
+func (d *KV) Restore(ctx context.Context, keyHash auth.KeyHash) (restored bool, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	err = d.both(keyHash, func(kv auth.KV) error {
+		ok, err := auth.Restore(ctx, kv, keyHash)
+		restored = restored || ok
+		return err
+	})
+	return restored, err
+}
+
+// Invalidate causes the record to become invalid in the shard that owns the
+// key, and in the shard that owned it before.
+// It is not an error if the key does not exist.
+// It does not update the invalid reason if the record is already invalid.
+func (d *KV) Invalidate(ctx context.Context, keyHash auth.KeyHash, reason string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return d.both(keyHash, func(kv auth.KV) error {
+		return kv.Invalidate(ctx, keyHash, reason)
+	})
+}
+
+// Update atomically changes the entry of the key with fn in the shard that
+// owns the key, and in the shard that owned it before. It returns whether the
+// entry was stored in either.
+// It is not an error if the key does not exist.
+func (d *KV) Update(ctx context.Context, keyHash auth.KeyHash, fn func(entry *auth.Entry) (store bool, err error)) (updated bool, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	err = d.both(keyHash, func(kv auth.KV) error {
+		ok, err := auth.Update(ctx, kv, keyHash, fn)
+		updated = updated || ok
+		return err
+	})
+	return updated, err
+}
+
+// both calls fn for the shard that owns the key, and for the shard that owned
+// it before, so that changes aren't lost while records are rebalanced.
+func (d *KV) both(keyHash auth.KeyHash, fn func(kv auth.KV) error) error {
+	owner, previous := d.owners(keyHash)
+	if err := fn(owner); err != nil {
+		return err
+	}
+	if previous != nil {
+		return fn(previous)
+	}
+	return nil
+}
+
+// each calls fn for every shard and sums what it returns.
+func (d *KV) each(fn func(kv auth.KV) (int64, error)) (total int64, err error) {
+	for _, kv := range d.all() {
+		n, err := fn(kv)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// PurgeDeleted removes the records that were soft deleted before asOf from
+// every shard, and returns how many were removed.
+func (d *KV) PurgeDeleted(ctx context.Context, asOf time.Time) (purged int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return d.each(func(kv auth.KV) (int64, error) {
+		return auth.PurgeDeleted(ctx, kv, asOf)
+	})
+}
+
+// InvalidateByMacaroonHead causes every record with the macaroon head to
+// become invalid in every shard, and returns how many were invalidated.
+// Records that haven't been rebalanced yet may be counted twice.
+func (d *KV) InvalidateByMacaroonHead(ctx context.Context, macaroonHead []byte, reason string) (invalidated int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return d.each(func(kv auth.KV) (int64, error) {
+		return auth.InvalidateByMacaroonHead(ctx, kv, macaroonHead, reason)
+	})
+}
+
+// DeleteUnused removes the records that were invalidated or that expired
+// before asOf from every shard, and returns how many were removed.
+func (d *KV) DeleteUnused(ctx context.Context, asOf time.Time) (deleted int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return d.each(func(kv auth.KV) (int64, error) {
+		return auth.DeleteUnused(ctx, kv, asOf)
+	})
+}
+
+// RecordUse adds uses to the use count of the key in the shard that owns the
+// key, and in the shard that owned it before.
+func (d *KV) RecordUse(ctx context.Context, keyHash auth.KeyHash, at time.Time, uses int64) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return d.both(keyHash, func(kv auth.KV) error {
+		return auth.RecordUse(ctx, kv, keyHash, at, uses)
+	})
+}
+
+// CountActive returns how many records of the owner are active at now in
+// every shard. Records that are still in the shard that owned them before the
+// last reshard may be counted twice until they are rebalanced.
+func (d *KV) CountActive(ctx context.Context, owner string, now time.Time) (count int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return d.each(func(kv auth.KV) (int64, error) {
+		return auth.CountActive(ctx, kv, owner, now)
+	})
+}
+
+// AppendHistory adds the event to the history of the key in the shard that
+// owns the key. Histories are not moved when the shards change.
+func (d *KV) AppendHistory(ctx context.Context, keyHash auth.KeyHash, event auth.HistoryEvent) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	owner, _ := d.owners(keyHash)
+	return auth.AppendHistory(ctx, owner, keyHash, event)
+}
+
+// History returns the events of the key in every shard, in the order they were
+// appended.
+func (d *KV) History(ctx context.Context, keyHash auth.KeyHash) (events []auth.HistoryEvent, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	for _, kv := range d.all() {
+		shardEvents, err := auth.History(ctx, kv, keyHash)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, shardEvents...)
+	}
+	sort.SliceStable(events, func(i, k int) bool { return events[i].At.Before(events[k].At) })
+	return events, nil
+}
+
+// Iterate calls fn for every record in every shard, including invalid, expired
+// and soft deleted records. Records that are being rebalanced may be seen
+// twice.
+func (d *KV) Iterate(ctx context.Context, fn func(ctx context.Context, entry auth.Entry) error) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	for _, kv := range d.all() {
+		if err := auth.Iterate(ctx, kv, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// List returns a page of the records in every shard in the order of their key
+// hashes, including invalid, expired and soft deleted records. Records that
+// are being rebalanced are only returned once.
+func (d *KV) List(ctx context.Context, after auth.KeyHash, limit int) (entries []auth.Entry, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	// every shard is asked for a full page, because the page may be made of
+	// the records of any of them
+	for _, kv := range d.all() {
+		shardEntries, err := auth.List(ctx, kv, after, limit)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, shardEntries...)
+	}
+	sort.SliceStable(entries, func(i, k int) bool {
+		return bytes.Compare(entries[i].KeyHash[:], entries[k].KeyHash[:]) < 0
+	})
+
+	unique := entries[:0]
+	for i, entry := range entries {
+		if i > 0 && entry.KeyHash == entries[i-1].KeyHash {
+			continue
+		}
+		unique = append(unique, entry)
+	}
+	if len(unique) > limit {
+		unique = unique[:limit]
+	}
+	return unique, nil
+}
+
+// HealthCheck checks every shard.
+func (d *KV) HealthCheck(ctx context.Context) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	d.mu.RLock()
+	shards := make(map[string]auth.KV, len(d.shards))
+	for name, kv := range d.shards {
+		shards[name] = kv
+	}
+	d.mu.RUnlock()
+
+	for name, kv := range shards {
+		if err := auth.HealthCheck(ctx, kv); err != nil {
+			return Error.New("shard %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Close closes every shard.
+func (d *KV) Close() (err error) {
+	for _, kv := range d.all() {
+		err = errs.Combine(err, auth.Close(kv))
+	}
+	return err
+}
@@ -0,0 +1,102 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package shardauth_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/stargate/auth"
+	"storj.io/stargate/auth/kvtest"
+	"storj.io/stargate/auth/memauth"
+	"storj.io/stargate/auth/shardauth"
+)
+
+func TestKV(t *testing.T) {
+	kvtest.RunTests(t, func() auth.KV {
+		kv, err := shardauth.New([]shardauth.Shard{
+			{Name: "a", KV: memauth.New()},
+			{Name: "b", KV: memauth.New()},
+			{Name: "c", KV: memauth.New()},
+		})
+		require.NoError(t, err)
+		return kv
+	})
+}
+
+func TestKV_Open(t *testing.T) {
+	ctx := context.Background()
+
+	kv, err := auth.OpenKV(ctx, "shard://?a="+url.QueryEscape("memory://")+"&b="+url.QueryEscape("memory://"))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, auth.Close(kv)) }()
+
+	require.NoError(t, kv.Put(ctx, auth.KeyHash{1}, &auth.Record{MacaroonHead: []byte("head")}))
+	record, err := kv.Get(ctx, auth.KeyHash{1})
+	require.NoError(t, err)
+	require.NotNil(t, record)
+
+	_, err = auth.OpenKV(ctx, "shard://")
+	require.Error(t, err)
+}
+
+func TestKV_Reshard(t *testing.T) {
+	ctx := context.Background()
+	a, b, c := memauth.New(), memauth.New(), memauth.New()
+
+	kv, err := shardauth.New([]shardauth.Shard{{Name: "a", KV: a}, {Name: "b", KV: b}})
+	require.NoError(t, err)
+
+	var keyHashes []auth.KeyHash
+	for i := 0; i < 200; i++ {
+		keyHash := auth.KeyHash(sha256.Sum256([]byte(strconv.Itoa(i))))
+		keyHashes = append(keyHashes, keyHash)
+		require.NoError(t, kv.Put(ctx, keyHash, &auth.Record{MacaroonHead: []byte("head")}))
+	}
+	require.NotZero(t, count(t, a))
+	require.NotZero(t, count(t, b))
+
+	requireAll := func() {
+		records, err := kv.GetBatch(ctx, keyHashes[1:])
+		require.NoError(t, err)
+		for i, record := range records {
+			require.NotNil(t, record, i)
+		}
+		_, err = kv.Get(ctx, keyHashes[0])
+		require.True(t, auth.Invalid.Has(err))
+	}
+
+	// records are found in their previous shards until they are moved, and
+	// changes are made in both
+	require.NoError(t, kv.Reshard([]shardauth.Shard{{Name: "b"}, {Name: "c", KV: c}}))
+	require.Error(t, kv.Reshard([]shardauth.Shard{{Name: "b"}}))
+	require.NoError(t, kv.Invalidate(ctx, keyHashes[0], "invalidated"))
+	requireAll()
+
+	moved, err := kv.Rebalance(ctx)
+	require.NoError(t, err)
+	require.NotZero(t, moved)
+	requireAll()
+
+	// the removed shard is emptied and the others own every record
+	require.Zero(t, count(t, a))
+	require.Equal(t, len(keyHashes), count(t, b)+count(t, c))
+
+	moved, err = kv.Rebalance(ctx)
+	require.NoError(t, err)
+	require.Zero(t, moved)
+}
+
+func count(t *testing.T, kv auth.KV) (n int) {
+	require.NoError(t, auth.Iterate(context.Background(), kv, func(ctx context.Context, entry auth.Entry) error {
+		n++
+		return nil
+	}))
+	return n
+}
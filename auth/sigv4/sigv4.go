@@ -0,0 +1,348 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package sigv4 checks the AWS signature version 4 of requests to gateways
+// with the secret keys that the auth service derives from its secret key
+// derivation keys, so that gateways with the same derivation keys don't need
+// the auth service to store or send the secret keys.
+//
+// The signatures cover the hashes of the payloads that clients send in the
+// X-Amz-Content-Sha256 header, and gateways still have to check that the
+// payloads match them while they read them.
+package sigv4
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcutil/base58"
+	"github.com/minio/minio-go/v6/pkg/s3utils"
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
+
+	"storj.io/stargate/auth"
+)
+
+var mon = monkit.Package()
+
+var (
+	// Error is the errs class of malformed signatures.
+	Error = errs.Class("sigv4")
+
+	// Mismatch is the errs class of signatures that don't match the secret
+	// keys of their access.
+	Mismatch = errs.Class("signature mismatch")
+
+	// Skewed is the errs class of signatures that were made too long ago or in
+	// the future, or presigned requests that expired.
+	Skewed = errs.Class("request time too skewed")
+
+	// Unverifiable is the errs class of requests that aren't signed by access
+	// key ids, like anonymous requests or requests with access grants as their
+	// access keys, whose secret keys aren't derived.
+	Unverifiable = errs.Class("unverifiable")
+)
+
+const (
+	algorithm = "AWS4-HMAC-SHA256"
+
+	// timeFormat is the format of the time of signatures, and dateFormat the
+	// format of the date of their scopes.
+	timeFormat = "20060102T150405Z"
+	dateFormat = "20060102"
+
+	// maxExpires is the longest that presigned requests are valid, in seconds.
+	maxExpires = 7 * 24 * 60 * 60
+
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// emptyPayload is the hash of empty payloads.
+var emptyPayload = hex.EncodeToString(sha256.New().Sum(nil))
+
+// Verifier checks the signatures of requests with the secret keys that are
+// derived from derivation keys.
+type Verifier struct {
+	keys    *auth.DerivationKeys
+	maxSkew time.Duration
+}
+
+// NewVerifier returns a Verifier that derives secret keys from keys, and
+// rejects signatures whose time differs from the current time by more than
+// maxSkew.
+func NewVerifier(keys *auth.DerivationKeys, maxSkew time.Duration) *Verifier {
+	return &Verifier{keys: keys, maxSkew: maxSkew}
+}
+
+// signature is a parsed signature of a request.
+type signature struct {
+	accessKeyID   string
+	date          string
+	region        string
+	service       string
+	signedHeaders []string
+	signature     string
+	time          time.Time
+	// expires is how long presigned requests are valid, and 0 for requests
+	// that are signed in the Authorization header.
+	expires time.Duration
+	// query is the query of the request without the signature.
+	query url.Values
+	// payload is the hash of the payload that was signed.
+	payload string
+}
+
+// Verify checks the signature of req, which may be in the Authorization
+// header or in the query of presigned requests, and returns the access key id
+// and the encryption key of the access that signed it. Requests that aren't
+// signed by access key ids are Unverifiable.
+func (verifier *Verifier) Verify(req *http.Request) (accessKeyID string, key auth.EncryptionKey, err error) {
+	ctx := req.Context()
+	defer mon.Task()(&ctx)(&err)
+
+	sig, err := parse(req)
+	if err != nil {
+		return "", key, err
+	}
+	key, err = parseAccessKeyID(sig.accessKeyID)
+	if err != nil {
+		return "", key, Unverifiable.Wrap(err)
+	}
+
+	now := time.Now()
+	if sig.expires == 0 {
+		if now.Sub(sig.time) > verifier.maxSkew || sig.time.Sub(now) > verifier.maxSkew {
+			return "", key, Skewed.New("the request was signed at %s", sig.time.Format(time.RFC3339))
+		}
+	} else {
+		if sig.time.Sub(now) > verifier.maxSkew {
+			return "", key, Skewed.New("the request was signed at %s", sig.time.Format(time.RFC3339))
+		}
+		if now.Sub(sig.time) > sig.expires {
+			return "", key, Skewed.New("the presigned request expired at %s", sig.time.Add(sig.expires).Format(time.RFC3339))
+		}
+	}
+
+	stringToSign := sig.stringToSign(req)
+	for _, secretKey := range verifier.keys.SecretKeys(key) {
+		expected := sign(base58.CheckEncode(secretKey, auth.VersionSecretKey), sig, stringToSign)
+		if hmac.Equal([]byte(expected), []byte(sig.signature)) {
+			return sig.accessKeyID, key, nil
+		}
+	}
+	return "", key, Mismatch.New("the signature doesn't match the secret key of %s", sig.accessKeyID)
+}
+
+// parse parses the signature of req.
+func parse(req *http.Request) (*signature, error) {
+	query := req.URL.Query()
+	if query.Get("X-Amz-Algorithm") != "" {
+		return parsePresigned(query)
+	}
+
+	header := req.Header.Get("Authorization")
+	if !strings.HasPrefix(header, algorithm+" ") {
+		// requests of access key ids that are signed otherwise, like with
+		// signature version 2, can't be checked
+		accessKey := query.Get("AWSAccessKeyId")
+		if strings.HasPrefix(header, "AWS ") {
+			accessKey = strings.SplitN(strings.TrimPrefix(header, "AWS "), ":", 2)[0]
+		}
+		if _, err := parseAccessKeyID(accessKey); err == nil {
+			return nil, Error.New("access key ids can only sign requests with %s", algorithm)
+		}
+		return nil, Unverifiable.New("the request isn't signed with %s", algorithm)
+	}
+
+	var sig signature
+	var credential, signedHeaders string
+	for _, field := range strings.Split(strings.TrimPrefix(header, algorithm+" "), ",") {
+		name, value := splitField(strings.TrimSpace(field))
+		switch name {
+		case "Credential":
+			credential = value
+		case "SignedHeaders":
+			signedHeaders = value
+		case "Signature":
+			sig.signature = value
+		}
+	}
+	if credential == "" || signedHeaders == "" || sig.signature == "" {
+		return nil, Error.New("the Authorization header is missing the credential, signed headers or signature")
+	}
+
+	sig.query = query
+	sig.payload = req.Header.Get("X-Amz-Content-Sha256")
+	if sig.payload == "" {
+		sig.payload = emptyPayload
+	}
+	return &sig, sig.parseFields(credential, signedHeaders, req.Header.Get("X-Amz-Date"))
+}
+
+// parsePresigned parses the signature in the query of a presigned request.
+func parsePresigned(query url.Values) (*signature, error) {
+	if query.Get("X-Amz-Algorithm") != algorithm {
+		return nil, Error.New("the request isn't signed with %s", algorithm)
+	}
+
+	var sig signature
+	sig.signature = query.Get("X-Amz-Signature")
+	if sig.signature == "" {
+		return nil, Error.New("the presigned request has no signature")
+	}
+
+	expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil || expires < 1 || expires > maxExpires {
+		return nil, Error.New("the presigned request has an invalid expiration %q", query.Get("X-Amz-Expires"))
+	}
+	sig.expires = time.Duration(expires) * time.Second
+
+	sig.query = url.Values{}
+	for name, values := range query {
+		if name != "X-Amz-Signature" {
+			sig.query[name] = values
+		}
+	}
+	sig.payload = query.Get("X-Amz-Content-Sha256")
+	if sig.payload == "" {
+		sig.payload = unsignedPayload
+	}
+	return &sig, sig.parseFields(query.Get("X-Amz-Credential"), query.Get("X-Amz-SignedHeaders"), query.Get("X-Amz-Date"))
+}
+
+// parseFields parses the credential, the signed headers and the time of the
+// signature, which are in the same format in headers and queries.
+func (sig *signature) parseFields(credential, signedHeaders, signedAt string) (err error) {
+	// the credential is <access key id>/<date>/<region>/<service>/aws4_request
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[4] != "aws4_request" {
+		return Error.New("invalid credential %q", credential)
+	}
+	sig.accessKeyID, sig.date, sig.region, sig.service = parts[0], parts[1], parts[2], parts[3]
+
+	sig.signedHeaders = strings.Split(signedHeaders, ";")
+	if !sort.StringsAreSorted(sig.signedHeaders) {
+		return Error.New("the signed headers aren't sorted")
+	}
+	hasHost := false
+	for _, name := range sig.signedHeaders {
+		hasHost = hasHost || name == "host"
+	}
+	if !hasHost {
+		return Error.New("the host header isn't signed")
+	}
+
+	sig.time, err = time.Parse(timeFormat, signedAt)
+	if err != nil {
+		return Error.New("invalid signature time %q", signedAt)
+	}
+	if sig.time.Format(dateFormat) != sig.date {
+		return Error.New("the date of the credential doesn't match the signature time")
+	}
+	return nil
+}
+
+// stringToSign returns the string whose HMAC is the signature of req.
+func (sig *signature) stringToSign(req *http.Request) string {
+	var headers strings.Builder
+	for _, name := range sig.signedHeaders {
+		headers.WriteString(name)
+		headers.WriteByte(':')
+		headers.WriteString(headerValue(req, name))
+		headers.WriteByte('\n')
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3utils.EncodePath(req.URL.Path),
+		strings.Replace(sig.query.Encode(), "+", "%20", -1),
+		headers.String(),
+		strings.Join(sig.signedHeaders, ";"),
+		sig.payload,
+	}, "\n")
+
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	return strings.Join([]string{
+		algorithm,
+		sig.time.Format(timeFormat),
+		sig.scope(),
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+}
+
+// scope returns the scope of the signature.
+func (sig *signature) scope() string {
+	return strings.Join([]string{sig.date, sig.region, sig.service, "aws4_request"}, "/")
+}
+
+// headerValue returns the canonical value of the header with the lower case
+// name, whose values are joined with commas after their spaces are trimmed.
+// Servers move some headers out of the header map, so those are taken from
+// their fields.
+func headerValue(req *http.Request, name string) string {
+	switch name {
+	case "host":
+		if req.Host != "" {
+			return req.Host
+		}
+		return req.URL.Host
+	case "content-length":
+		if req.Header.Get("Content-Length") == "" {
+			return strconv.FormatInt(req.ContentLength, 10)
+		}
+	}
+
+	values := req.Header[http.CanonicalHeaderKey(name)]
+	trimmed := make([]string, 0, len(values))
+	for _, value := range values {
+		trimmed = append(trimmed, strings.Join(strings.Fields(value), " "))
+	}
+	return strings.Join(trimmed, ",")
+}
+
+// sign returns the signature of stringToSign with secretKey.
+func sign(secretKey string, sig *signature, stringToSign string) string {
+	key := sum([]byte("AWS4"+secretKey), sig.date)
+	key = sum(key, sig.region)
+	key = sum(key, sig.service)
+	key = sum(key, "aws4_request")
+	return hex.EncodeToString(sum(key, stringToSign))
+}
+
+// sum returns the HMAC-SHA256 of data with key.
+func sum(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// splitField splits a field of the Authorization header into its name and
+// value.
+func splitField(field string) (name, value string) {
+	i := strings.IndexByte(field, '=')
+	if i < 0 {
+		return field, ""
+	}
+	return field[:i], field[i+1:]
+}
+
+// parseAccessKeyID returns the encryption key that accessKeyID encodes.
+func parseAccessKeyID(accessKeyID string) (key auth.EncryptionKey, err error) {
+	encryptionKeyBytes, version, err := base58.CheckDecode(accessKeyID)
+	if err != nil {
+		return key, Error.New("invalid access key id: %v", err)
+	}
+	if len(encryptionKeyBytes) != len(key) || version != auth.VersionAccessKeyID {
+		return key, Error.New("invalid access key id")
+	}
+
+	copy(key[:], encryptionKeyBytes)
+	return key, nil
+}
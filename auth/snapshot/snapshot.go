@@ -0,0 +1,318 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package snapshot writes and reads encrypted backups of every record in an
+// auth.KV.
+//
+// A snapshot is
+//
+//	magic || version || salt || frame...
+//
+// where every frame is a big endian uint32 length followed by an AES-GCM
+// sealed batch of json encoded entries. The key is derived from a passphrase
+// and the salt with scrypt. The nonce of a frame is its index, with the last
+// byte set only for the final frame, so that reordered, dropped or truncated
+// frames are detected. The final frame holds the number of entries.
+package snapshot
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
+	"golang.org/x/crypto/scrypt"
+
+	"storj.io/stargate/auth"
+)
+
+var mon = monkit.Package()
+
+// Error is the error class for this package.
+var Error = errs.Class("snapshot")
+
+// PassphraseEnv is the environment variable that is checked for the snapshot
+// passphrase before prompting for it.
+const PassphraseEnv = "STORJ_SNAPSHOT_PASSPHRASE"
+
+// Version is the version of the snapshots that are written.
+const Version = 1
+
+const (
+	magic    = "STGSNAP"
+	saltSize = 16
+
+	// frameEntries is how many entries are sealed together.
+	frameEntries = 1000
+	// maxFrameSize bounds the memory used to read a frame.
+	maxFrameSize = 64 << 20
+
+	// scrypt parameters recommended for interactive logins
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// entry is the encoding of an auth.Entry in a snapshot.
+type entry struct {
+	KeyHash              []byte            `json:"key_hash"`
+	SatelliteAddress     string            `json:"satellite_address"`
+	MacaroonHead         []byte            `json:"macaroon_head"`
+	EncryptedSecretKey   []byte            `json:"encrypted_secret_key"`
+	EncryptedAccessGrant []byte            `json:"encrypted_access_grant"`
+	Public               bool              `json:"public"`
+	ExpiresAt            *time.Time        `json:"expires_at,omitempty"`
+	InvalidReason        string            `json:"invalid_reason,omitempty"`
+	DeletedAt            *time.Time        `json:"deleted_at,omitempty"`
+	Labels               map[string]string `json:"labels,omitempty"`
+	Owner                string            `json:"owner,omitempty"`
+}
+
+// frame is the plaintext of a frame.
+type frame struct {
+	Entries []entry `json:"entries,omitempty"`
+	// Count is the total number of entries and is only set in the final frame.
+	Count int64 `json:"count,omitempty"`
+}
+
+// Write writes a snapshot of every record in source to w, encrypted with
+// passphrase, and returns how many records it contains.
+//
+// Records are written as they are iterated, so records that are stored or
+// deleted while writing may or may not be part of the snapshot. Every record
+// that is part of it is complete, because records are never changed except
+// for becoming invalid or being soft deleted and restored.
+func Write(ctx context.Context, w io.Writer, source auth.Source, passphrase []byte) (count int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	header := make([]byte, 0, len(magic)+1+saltSize)
+	header = append(header, magic...)
+	header = append(header, Version)
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return 0, Error.Wrap(err)
+	}
+	header = append(header, salt...)
+
+	aead, err := newAEAD(passphrase, salt)
+	if err != nil {
+		return 0, err
+	}
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(header); err != nil {
+		return 0, Error.Wrap(err)
+	}
+
+	var index uint64
+	writeFrame := func(f frame, final bool) error {
+		plaintext, err := json.Marshal(f)
+		if err != nil {
+			return Error.Wrap(err)
+		}
+		sealed := aead.Seal(nil, nonce(aead, index, final), plaintext, header)
+		index++
+
+		var length [4]byte
+		binary.BigEndian.PutUint32(length[:], uint32(len(sealed)))
+		if _, err := bw.Write(length[:]); err != nil {
+			return Error.Wrap(err)
+		}
+		_, err = bw.Write(sealed)
+		return Error.Wrap(err)
+	}
+
+	var pending frame
+	err = source.Iterate(ctx, func(ctx context.Context, e auth.Entry) error {
+		pending.Entries = append(pending.Entries, encodeEntry(e))
+		count++
+		if len(pending.Entries) < frameEntries {
+			return nil
+		}
+		err := writeFrame(pending, false)
+		pending.Entries = pending.Entries[:0]
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	pending.Count = count
+	if err := writeFrame(pending, true); err != nil {
+		return 0, err
+	}
+	return count, Error.Wrap(bw.Flush())
+}
+
+// Read decrypts the snapshot in r with passphrase and calls fn for every record
+// in it. Every frame is authenticated before fn is called for its records, but
+// a truncated snapshot is only detected at its end, after fn has been called
+// for the records before the truncation.
+func Read(ctx context.Context, r io.Reader, passphrase []byte, fn func(ctx context.Context, entry auth.Entry) error) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	br := bufio.NewReader(r)
+	header := make([]byte, len(magic)+1+saltSize)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return Error.New("not a snapshot: %v", err)
+	}
+	if string(header[:len(magic)]) != magic {
+		return Error.New("not a snapshot")
+	}
+	if version := header[len(magic)]; version != Version {
+		return Error.New("unsupported snapshot version %d", version)
+	}
+	salt := header[len(magic)+1:]
+
+	aead, err := newAEAD(passphrase, salt)
+	if err != nil {
+		return err
+	}
+
+	var count int64
+	for index := uint64(0); ; index++ {
+		var length [4]byte
+		if _, err := io.ReadFull(br, length[:]); err != nil {
+			return Error.New("snapshot is truncated: %v", err)
+		}
+		size := binary.BigEndian.Uint32(length[:])
+		if size > maxFrameSize {
+			return Error.New("frame %d is too large", index)
+		}
+		sealed := make([]byte, size)
+		if _, err := io.ReadFull(br, sealed); err != nil {
+			return Error.New("snapshot is truncated: %v", err)
+		}
+
+		final := false
+		plaintext, err := aead.Open(nil, nonce(aead, index, false), sealed, header)
+		if err != nil {
+			final = true
+			plaintext, err = aead.Open(nil, nonce(aead, index, true), sealed, header)
+			if err != nil {
+				return Error.New("wrong passphrase or corrupted snapshot")
+			}
+		}
+
+		var f frame
+		if err := json.Unmarshal(plaintext, &f); err != nil {
+			return Error.Wrap(err)
+		}
+		for _, e := range f.Entries {
+			decoded, err := decodeEntry(e)
+			if err != nil {
+				return err
+			}
+			if err := fn(ctx, decoded); err != nil {
+				return err
+			}
+			count++
+		}
+
+		if final {
+			if f.Count != count {
+				return Error.New("snapshot has %d records but should have %d", count, f.Count)
+			}
+			if _, err := br.ReadByte(); err != io.EOF {
+				return Error.New("unexpected data after the end of the snapshot")
+			}
+			return nil
+		}
+	}
+}
+
+// File is a snapshot file that can be iterated over any number of times, so
+// that it can be the source of an auth.Migrator.
+type File struct {
+	path       string
+	passphrase []byte
+}
+
+// Open returns the snapshot file at path, encrypted with passphrase.
+func Open(path string, passphrase []byte) *File {
+	return &File{path: path, passphrase: passphrase}
+}
+
+// Iterate calls fn for every record in the snapshot file.
+func (file *File) Iterate(ctx context.Context, fn func(ctx context.Context, entry auth.Entry) error) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	f, err := os.Open(file.path)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	defer func() { err = errs.Combine(err, Error.Wrap(f.Close())) }()
+
+	return Read(ctx, f, file.passphrase, fn)
+}
+
+// nonce returns the nonce of the frame with the given index.
+func nonce(aead cipher.AEAD, index uint64, final bool) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-9:], index)
+	if final {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+func encodeEntry(e auth.Entry) entry {
+	return entry{
+		KeyHash:              e.KeyHash[:],
+		SatelliteAddress:     e.Record.SatelliteAddress,
+		MacaroonHead:         e.Record.MacaroonHead,
+		EncryptedSecretKey:   e.Record.EncryptedSecretKey,
+		EncryptedAccessGrant: e.Record.EncryptedAccessGrant,
+		Public:               e.Record.Public,
+		ExpiresAt:            e.Record.ExpiresAt,
+		InvalidReason:        e.InvalidReason,
+		DeletedAt:            e.DeletedAt,
+		Labels:               e.Record.Labels,
+		Owner:                e.Record.Owner,
+	}
+}
+
+func decodeEntry(e entry) (auth.Entry, error) {
+	var keyHash auth.KeyHash
+	if len(e.KeyHash) != len(keyHash) {
+		return auth.Entry{}, Error.New("invalid key hash length %d", len(e.KeyHash))
+	}
+	copy(keyHash[:], e.KeyHash)
+
+	return auth.Entry{
+		KeyHash: keyHash,
+		Record: &auth.Record{
+			SatelliteAddress:     e.SatelliteAddress,
+			MacaroonHead:         e.MacaroonHead,
+			EncryptedSecretKey:   e.EncryptedSecretKey,
+			EncryptedAccessGrant: e.EncryptedAccessGrant,
+			Public:               e.Public,
+			ExpiresAt:            e.ExpiresAt,
+			Labels:               e.Labels,
+			Owner:                e.Owner,
+		},
+		InvalidReason: e.InvalidReason,
+		DeletedAt:     e.DeletedAt,
+	}, nil
+}
+
+func newAEAD(passphrase, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	return aead, Error.Wrap(err)
+}
@@ -0,0 +1,167 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package snapshot_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"storj.io/stargate/auth"
+	"storj.io/stargate/auth/memauth"
+	"storj.io/stargate/auth/snapshot"
+)
+
+var passphrase = []byte("correct horse battery staple")
+
+// newKV returns a KV with enough records for several frames.
+func newKV(ctx context.Context, t *testing.T) *memauth.KV {
+	kv := memauth.New()
+	expires := time.Now().Add(time.Hour)
+	for i := 0; i < 2500; i++ {
+		var keyHash auth.KeyHash
+		binary.BigEndian.PutUint32(keyHash[:], uint32(i))
+		record := &auth.Record{
+			SatelliteAddress:     "satellite.example.test:7777",
+			MacaroonHead:         keyHash[:4],
+			EncryptedSecretKey:   []byte("secret"),
+			EncryptedAccessGrant: []byte("grant"),
+			Public:               i%2 == 0,
+		}
+		if i%3 == 0 {
+			record.ExpiresAt = &expires
+		}
+		require.NoError(t, kv.Put(ctx, keyHash, record))
+	}
+	require.NoError(t, kv.Invalidate(ctx, auth.KeyHash{}, "revoked"))
+	require.NoError(t, kv.SoftDelete(ctx, auth.KeyHash{0, 0, 0, 1}))
+	return kv
+}
+
+func collect(ctx context.Context, t *testing.T, data []byte, passphrase []byte) (map[auth.KeyHash]auth.Entry, error) {
+	entries := make(map[auth.KeyHash]auth.Entry)
+	err := snapshot.Read(ctx, bytes.NewReader(data), passphrase, func(ctx context.Context, entry auth.Entry) error {
+		require.NotContains(t, entries, entry.KeyHash)
+		entries[entry.KeyHash] = entry
+		return nil
+	})
+	return entries, err
+}
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	kv := newKV(ctx, t)
+
+	var buf bytes.Buffer
+	count, err := snapshot.Write(ctx, &buf, kv, passphrase)
+	require.NoError(t, err)
+	require.EqualValues(t, 2500, count)
+
+	// the records are encrypted
+	require.NotContains(t, buf.String(), "satellite.example.test")
+
+	entries, err := collect(ctx, t, buf.Bytes(), passphrase)
+	require.NoError(t, err)
+	require.Len(t, entries, 2500)
+
+	require.NoError(t, kv.Iterate(ctx, func(ctx context.Context, expected auth.Entry) error {
+		actual := entries[expected.KeyHash]
+		require.Equal(t, expected.InvalidReason, actual.InvalidReason)
+		require.Equal(t, expected.DeletedAt != nil, actual.DeletedAt != nil)
+		require.Equal(t, expected.Record.MacaroonHead, actual.Record.MacaroonHead)
+		require.Equal(t, expected.Record.Public, actual.Record.Public)
+		if expected.Record.ExpiresAt != nil {
+			require.True(t, expected.Record.ExpiresAt.Equal(*actual.Record.ExpiresAt))
+		} else {
+			require.Nil(t, actual.Record.ExpiresAt)
+		}
+		return nil
+	}))
+}
+
+func TestEmpty(t *testing.T) {
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	count, err := snapshot.Write(ctx, &buf, memauth.New(), passphrase)
+	require.NoError(t, err)
+	require.Zero(t, count)
+
+	entries, err := collect(ctx, t, buf.Bytes(), passphrase)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestCorruption(t *testing.T) {
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	_, err := snapshot.Write(ctx, &buf, newKV(ctx, t), passphrase)
+	require.NoError(t, err)
+	data := buf.Bytes()
+
+	_, err = collect(ctx, t, data, []byte("wrong"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "wrong passphrase")
+
+	_, err = collect(ctx, t, []byte("not a snapshot at all"), passphrase)
+	require.Error(t, err)
+
+	// a snapshot that ends after a complete frame is still detected as truncated
+	header := len("STGSNAP") + 1 + 16
+	first := header + 4 + int(binary.BigEndian.Uint32(data[header:]))
+	_, err = collect(ctx, t, data[:first], passphrase)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "truncated")
+
+	tampered := append([]byte{}, data...)
+	tampered[len(tampered)-1] ^= 1
+	_, err = collect(ctx, t, tampered, passphrase)
+	require.Error(t, err)
+
+	_, err = collect(ctx, t, append(append([]byte{}, data...), 0), passphrase)
+	require.Error(t, err)
+}
+
+func TestRestore(t *testing.T) {
+	ctx := context.Background()
+	kv := newKV(ctx, t)
+
+	dir, err := ioutil.TempDir("", "snapshot")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "auth.snap")
+	var buf bytes.Buffer
+	_, err = snapshot.Write(ctx, &buf, kv, passphrase)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(path, buf.Bytes(), 0600))
+
+	restored := memauth.New()
+	migrator := auth.NewMigrator(zaptest.NewLogger(t), snapshot.Open(path, passphrase), restored, auth.MigrateConfig{
+		BatchSize: 100,
+		Verify:    true,
+	})
+	stats, err := migrator.Migrate(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, 2500, stats.Copied)
+	require.EqualValues(t, 1, stats.Invalidated)
+	require.EqualValues(t, 1, stats.Deleted)
+	require.EqualValues(t, 2500, stats.Verified)
+
+	_, err = restored.Get(ctx, auth.KeyHash{})
+	require.True(t, auth.Invalid.Has(err))
+
+	undeleted, err := restored.Restore(ctx, auth.KeyHash{0, 0, 0, 1})
+	require.NoError(t, err)
+	require.True(t, undeleted)
+}
@@ -0,0 +1,677 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package spannerauth
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
+	"google.golang.org/grpc/codes"
+
+	"storj.io/stargate/auth"
+)
+
+var mon = monkit.Package()
+
+func init() {
+	auth.RegisterKV("spanner", openURL)
+}
+
+// Schema is the DDL for the table that KV expects to exist.
+//
+// created_at, invalid_at and deleted_at are filled in with the commit
+// timestamp of the mutation that wrote them, so they record when Spanner
+// accepted the change rather than when some node believed it happened.
+//
+// Tables created before records could be soft deleted, labeled, tracked or
+// owned need the deleted_at, labels, last_used_at, use_count and owner columns
+// added with ALTER TABLE records ADD COLUMN. Labels are stored as a json
+// object, and a null use_count counts as no uses.
+const Schema = `CREATE TABLE records (
+	encryption_key_hash BYTES(32) NOT NULL,
+	created_at TIMESTAMP NOT NULL OPTIONS (allow_commit_timestamp=true),
+	public BOOL NOT NULL,
+	satellite_address STRING(MAX) NOT NULL,
+	macaroon_head BYTES(MAX) NOT NULL,
+	expires_at TIMESTAMP,
+	encrypted_secret_key BYTES(MAX) NOT NULL,
+	encrypted_access_grant BYTES(MAX) NOT NULL,
+	invalid_reason STRING(MAX),
+	invalid_at TIMESTAMP OPTIONS (allow_commit_timestamp=true),
+	deleted_at TIMESTAMP OPTIONS (allow_commit_timestamp=true),
+	labels STRING(MAX),
+	last_used_at TIMESTAMP,
+	use_count INT64,
+	owner STRING(MAX),
+) PRIMARY KEY (encryption_key_hash)`
+
+// IndexSchema is the DDL for the index of records by macaroon head that
+// InvalidateByMacaroonHead uses. It has to be applied after Schema, as a
+// separate statement.
+const IndexSchema = `CREATE INDEX records_macaroon_head ON records (macaroon_head)`
+
+// OwnerIndexSchema is the DDL for the index of records by owner that
+// CountActive uses. It has to be applied after Schema, as a separate
+// statement.
+const OwnerIndexSchema = `CREATE INDEX records_owner ON records (owner)`
+
+// HistorySchema is the DDL for the table of history events that AppendHistory
+// and History use. Events are keyed by the commit timestamp of their append,
+// and they are not interleaved with records so that they outlive them.
+const HistorySchema = `CREATE TABLE record_events (
+	encryption_key_hash BYTES(32) NOT NULL,
+	created_at TIMESTAMP NOT NULL OPTIONS (allow_commit_timestamp=true),
+	action STRING(MAX) NOT NULL,
+	actor STRING(MAX) NOT NULL,
+	reason STRING(MAX) NOT NULL,
+	source_ip STRING(MAX) NOT NULL,
+) PRIMARY KEY (encryption_key_hash, created_at)`
+
+const table = "records"
+
+const historyTable = "record_events"
+
+var recordColumns = []string{
+	"satellite_address",
+	"macaroon_head",
+	"encrypted_secret_key",
+	"encrypted_access_grant",
+	"public",
+	"expires_at",
+	"invalid_reason",
+	"deleted_at",
+	"created_at",
+	"labels",
+	"last_used_at",
+	"use_count",
+	"owner",
+}
+
+// KV is a key/value store backed by Google Cloud Spanner.
+type KV struct {
+	client *spanner.Client
+}
+
+// New wraps the spanner client into a KV.
+func New(client *spanner.Client) *KV {
+	return &KV{
+		client: client,
+	}
+}
+
+// openURL opens a KV for a url of the form
+// spanner://projects/<project>/instances/<instance>/databases/<database>.
+func openURL(ctx context.Context, databaseURL string) (_ auth.KV, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	database := strings.TrimPrefix(databaseURL, "spanner://")
+	client, err := spanner.NewClient(ctx, database)
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+	return New(client), nil
+}
+
+// Put stores the record in the key/value store.
+// It is an error if the key already exists.
+func (d *KV) Put(ctx context.Context, keyHash auth.KeyHash, record *auth.Record) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	_, err = d.client.Apply(ctx, []*spanner.Mutation{insertRecord(keyHash, record)})
+	return errs.Wrap(err)
+}
+
+// PutBatch stores all of the records in the key/value store in a single commit.
+// It is an error if any of the keys already exist, in which case none are stored.
+func (d *KV) PutBatch(ctx context.Context, entries []auth.Entry) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	mutations := make([]*spanner.Mutation, 0, len(entries))
+	for _, entry := range entries {
+		mutations = append(mutations, insertRecord(entry.KeyHash, entry.Record))
+	}
+
+	_, err = d.client.Apply(ctx, mutations)
+	return errs.Wrap(err)
+}
+
+// insertRecord returns the mutation that inserts the record.
+func insertRecord(keyHash auth.KeyHash, record *auth.Record) *spanner.Mutation {
+	return spanner.InsertMap(table, map[string]interface{}{
+		"encryption_key_hash":    keyHash[:],
+		"created_at":             spanner.CommitTimestamp,
+		"public":                 record.Public,
+		"satellite_address":      record.SatelliteAddress,
+		"macaroon_head":          record.MacaroonHead,
+		"encrypted_secret_key":   record.EncryptedSecretKey,
+		"encrypted_access_grant": record.EncryptedAccessGrant,
+		"expires_at":             nullTime(record.ExpiresAt),
+		"labels":                 nullLabels(record.Labels),
+		"owner":                  spanner.NullString{StringVal: record.Owner, Valid: record.Owner != ""},
+	})
+}
+
+// Get retrieves the record from the key/value store.
+// It returns nil if the key does not exist or if the record is soft deleted.
+// If the record is invalid or expired, the error contains why.
+// The creation time of the record is the commit timestamp of its Put.
+func (d *KV) Get(ctx context.Context, keyHash auth.KeyHash) (record *auth.Record, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	row, err := d.client.Single().ReadRow(ctx, table, spanner.Key{keyHash[:]}, recordColumns)
+	if spanner.ErrCode(err) == codes.NotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, errs.Wrap(err)
+	}
+
+	record, invalidReason, deletedAt, err := scanRecord(row)
+	if err != nil {
+		return nil, errs.Wrap(err)
+	} else if deletedAt.Valid {
+		return nil, nil
+	} else if invalidReason.Valid {
+		return nil, auth.Invalid.New("%s", invalidReason.StringVal)
+	} else if record.Expired(time.Now()) {
+		return nil, auth.ErrExpired(record)
+	}
+
+	return record, nil
+}
+
+// GetBatch retrieves the records for all of the keys from the key/value store
+// with a single read. A record is nil if its key does not exist or if it is invalid
+// or expired.
+func (d *KV) GetBatch(ctx context.Context, keyHashes []auth.KeyHash) (records []*auth.Record, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	keys := make([]spanner.KeySet, 0, len(keyHashes))
+	for _, keyHash := range keyHashes {
+		keys = append(keys, spanner.Key{keyHash[:]})
+	}
+
+	now := time.Now()
+	found := make(map[auth.KeyHash]*auth.Record, len(keyHashes))
+	columns := append([]string{"encryption_key_hash"}, recordColumns...)
+	err = d.client.Single().Read(ctx, table, spanner.KeySets(keys...), columns).Do(func(row *spanner.Row) error {
+		var keyHash []byte
+		if err := row.Column(0, &keyHash); err != nil {
+			return err
+		}
+
+		record, invalidReason, deletedAt, err := scanRecord(row)
+		if err != nil {
+			return err
+		}
+		if !deletedAt.Valid && !invalidReason.Valid && !record.Expired(now) {
+			var kh auth.KeyHash
+			copy(kh[:], keyHash)
+			found[kh] = record
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+
+	records = make([]*auth.Record, len(keyHashes))
+	for i, keyHash := range keyHashes {
+		records[i] = found[keyHash]
+	}
+	return records, nil
+}
+
+// scanRecord reads recordColumns from the end of the row. The invalid reason and
+// the deletion time are returned separately, and are only valid if the record
+// has been invalidated or soft deleted.
+func scanRecord(row *spanner.Row) (record *auth.Record, invalidReason spanner.NullString, deletedAt spanner.NullTime, err error) {
+	offset := row.Size() - len(recordColumns)
+
+	record = new(auth.Record)
+	var createdAt time.Time
+	var expiresAt spanner.NullTime
+	var labels spanner.NullString
+	var lastUsedAt spanner.NullTime
+	var useCount spanner.NullInt64
+	var owner spanner.NullString
+	dests := []interface{}{
+		&record.SatelliteAddress,
+		&record.MacaroonHead,
+		&record.EncryptedSecretKey,
+		&record.EncryptedAccessGrant,
+		&record.Public,
+		&expiresAt,
+		&invalidReason,
+		&deletedAt,
+		&createdAt,
+		&labels,
+		&lastUsedAt,
+		&useCount,
+		&owner,
+	}
+	for i, dest := range dests {
+		if err := row.Column(offset+i, dest); err != nil {
+			return nil, spanner.NullString{}, spanner.NullTime{}, err
+		}
+	}
+	record.CreatedAt = &createdAt
+	if expiresAt.Valid {
+		record.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		record.LastUsedAt = &lastUsedAt.Time
+	}
+	record.UseCount = useCount.Int64
+	record.Owner = owner.StringVal
+	if labels.Valid {
+		if err := json.Unmarshal([]byte(labels.StringVal), &record.Labels); err != nil {
+			return nil, spanner.NullString{}, spanner.NullTime{}, err
+		}
+	}
+	return record, invalidReason, deletedAt, nil
+}
+
+// nullLabels converts labels into a spanner value, which is null if there are
+// no labels.
+func nullLabels(labels map[string]string) spanner.NullString {
+	if len(labels) == 0 {
+		return spanner.NullString{}
+	}
+	// a map of strings always marshals
+	data, _ := json.Marshal(labels)
+	return spanner.NullString{StringVal: string(data), Valid: true}
+}
+
+// nullTime converts an optional time into a spanner value.
+func nullTime(t *time.Time) spanner.NullTime {
+	if t == nil {
+		return spanner.NullTime{}
+	}
+	return spanner.NullTime{Time: *t, Valid: true}
+}
+
+// Delete removes the record from the key/value store.
+// It is not an error if the key does not exist.
+func (d *KV) Delete(ctx context.Context, keyHash auth.KeyHash) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	_, err = d.client.Apply(ctx, []*spanner.Mutation{
+		spanner.Delete(table, spanner.Key{keyHash[:]}),
+	})
+	return errs.Wrap(err)
+}
+
+// SoftDelete marks the record as deleted until it is restored or purged.
+// It is not an error if the key does not exist.
+// It does not update the deletion time if the record is already deleted.
+func (d *KV) SoftDelete(ctx context.Context, keyHash auth.KeyHash) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	_, err = d.client.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		_, err := txn.Update(ctx, spanner.Statement{
+			SQL: `UPDATE records SET deleted_at = PENDING_COMMIT_TIMESTAMP()
+				WHERE encryption_key_hash = @key_hash AND deleted_at IS NULL`,
+			Params: map[string]interface{}{
+				"key_hash": keyHash[:],
+			},
+		})
+		return err
+	})
+	return errs.Wrap(err)
+}
+
+// Restore undoes SoftDelete, and returns whether the record was deleted.
+// It is not an error if the key does not exist.
+func (d *KV) Restore(ctx context.Context, keyHash auth.KeyHash) (restored bool, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	_, err = d.client.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		count, err := txn.Update(ctx, spanner.Statement{
+			SQL: `UPDATE records SET deleted_at = NULL
+				WHERE encryption_key_hash = @key_hash AND deleted_at IS NOT NULL`,
+			Params: map[string]interface{}{
+				"key_hash": keyHash[:],
+			},
+		})
+		restored = count > 0
+		return err
+	})
+	if err != nil {
+		return false, errs.Wrap(err)
+	}
+	return restored, nil
+}
+
+// PurgeDeleted removes the records that were soft deleted before asOf, and
+// returns how many were removed. It uses partitioned DML, so the deletes are
+// not atomic as a whole.
+func (d *KV) PurgeDeleted(ctx context.Context, asOf time.Time) (purged int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	purged, err = d.client.PartitionedUpdate(ctx, spanner.Statement{
+		SQL: `DELETE FROM records
+			WHERE deleted_at IS NOT NULL AND deleted_at < @as_of`,
+		Params: map[string]interface{}{
+			"as_of": asOf,
+		},
+	})
+	return purged, errs.Wrap(err)
+}
+
+// Invalidate causes the record to become invalid.
+// It is not an error if the key does not exist.
+// It does not update the invalid reason if the record is already invalid.
+func (d *KV) Invalidate(ctx context.Context, keyHash auth.KeyHash, reason string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	_, err = d.client.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		row, err := txn.ReadRow(ctx, table, spanner.Key{keyHash[:]}, []string{"invalid_reason"})
+		if spanner.ErrCode(err) == codes.NotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var invalidReason spanner.NullString
+		if err := row.Columns(&invalidReason); err != nil {
+			return err
+		}
+		if invalidReason.Valid {
+			return nil
+		}
+
+		return txn.BufferWrite([]*spanner.Mutation{
+			spanner.UpdateMap(table, map[string]interface{}{
+				"encryption_key_hash": keyHash[:],
+				"invalid_reason":      reason,
+				"invalid_at":          spanner.CommitTimestamp,
+			}),
+		})
+	})
+	return errs.Wrap(err)
+}
+
+// Update atomically changes the entry of the key with fn in a read/write
+// transaction, and returns whether the entry was stored. fn is called again
+// when the transaction is retried.
+// It is not an error if the key does not exist.
+func (d *KV) Update(ctx context.Context, keyHash auth.KeyHash, fn func(entry *auth.Entry) (store bool, err error)) (updated bool, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	_, err = d.client.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		updated = false
+
+		row, err := txn.ReadRow(ctx, table, spanner.Key{keyHash[:]}, recordColumns)
+		if spanner.ErrCode(err) == codes.NotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		record, invalidReason, deletedAt, err := scanRecord(row)
+		if err != nil {
+			return err
+		}
+		entry := auth.Entry{KeyHash: keyHash, Record: record, InvalidReason: invalidReason.StringVal}
+		if deletedAt.Valid {
+			entry.DeletedAt = &deletedAt.Time
+		}
+
+		if store, err := fn(&entry); err != nil || !store {
+			return err
+		}
+		if entry.Record == nil {
+			return errs.New("record is required")
+		}
+
+		values := map[string]interface{}{
+			"encryption_key_hash":    keyHash[:],
+			"satellite_address":      entry.Record.SatelliteAddress,
+			"macaroon_head":          entry.Record.MacaroonHead,
+			"encrypted_secret_key":   entry.Record.EncryptedSecretKey,
+			"encrypted_access_grant": entry.Record.EncryptedAccessGrant,
+			"public":                 entry.Record.Public,
+			"expires_at":             nullTime(entry.Record.ExpiresAt),
+			"deleted_at":             nullTime(entry.DeletedAt),
+			"labels":                 nullLabels(entry.Record.Labels),
+			"owner":                  spanner.NullString{StringVal: entry.Record.Owner, Valid: entry.Record.Owner != ""},
+		}
+		switch {
+		case entry.InvalidReason == "":
+			values["invalid_reason"] = spanner.NullString{}
+			values["invalid_at"] = spanner.NullTime{}
+		case entry.InvalidReason != invalidReason.StringVal:
+			values["invalid_reason"] = entry.InvalidReason
+			values["invalid_at"] = spanner.CommitTimestamp
+		}
+
+		updated = true
+		return txn.BufferWrite([]*spanner.Mutation{spanner.UpdateMap(table, values)})
+	})
+	if err != nil {
+		return false, errs.Wrap(err)
+	}
+	return updated, nil
+}
+
+// RecordUse adds uses to the use count of the key, and sets when it was last
+// used to at, unless it was used later.
+// It is not an error if the key does not exist.
+func (d *KV) RecordUse(ctx context.Context, keyHash auth.KeyHash, at time.Time, uses int64) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	_, err = d.client.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		_, err := txn.Update(ctx, spanner.Statement{
+			SQL: `UPDATE records
+				SET use_count = IFNULL(use_count, 0) + @uses,
+					last_used_at = CASE WHEN last_used_at IS NULL OR last_used_at < @at THEN @at ELSE last_used_at END
+				WHERE encryption_key_hash = @encryption_key_hash`,
+			Params: map[string]interface{}{
+				"uses":                uses,
+				"at":                  at,
+				"encryption_key_hash": keyHash[:],
+			},
+		})
+		return err
+	})
+	return errs.Wrap(err)
+}
+
+// CountActive returns how many records of the owner are active at now.
+func (d *KV) CountActive(ctx context.Context, owner string, now time.Time) (count int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	// records without an owner have a null owner, and the owner is compared
+	// as it is otherwise, so that the index is used
+	ownerClause := "owner = @owner"
+	if owner == "" {
+		ownerClause = "(owner IS NULL OR owner = @owner)"
+	}
+
+	statement := spanner.Statement{
+		SQL: `SELECT COUNT(*) FROM records
+			WHERE ` + ownerClause + ` AND invalid_reason IS NULL AND deleted_at IS NULL
+			  AND (expires_at IS NULL OR expires_at > @now)`,
+		Params: map[string]interface{}{
+			"owner": owner,
+			"now":   now,
+		},
+	}
+	err = d.client.Single().Query(ctx, statement).Do(func(row *spanner.Row) error {
+		return row.Columns(&count)
+	})
+	return count, errs.Wrap(err)
+}
+
+// InvalidateByMacaroonHead causes every record with the macaroon head to
+// become invalid, and returns how many were invalidated. Records that are
+// already invalid keep their invalid reason and are not counted. All of the
+// records are invalidated in a single transaction.
+func (d *KV) InvalidateByMacaroonHead(ctx context.Context, macaroonHead []byte, reason string) (invalidated int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	_, err = d.client.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) (err error) {
+		invalidated, err = txn.Update(ctx, spanner.Statement{
+			SQL: `UPDATE records
+				SET invalid_reason = @reason, invalid_at = PENDING_COMMIT_TIMESTAMP()
+				WHERE macaroon_head = @macaroon_head AND invalid_reason IS NULL`,
+			Params: map[string]interface{}{
+				"reason":        reason,
+				"macaroon_head": macaroonHead,
+			},
+		})
+		return err
+	})
+	if err != nil {
+		return 0, errs.Wrap(err)
+	}
+	return invalidated, nil
+}
+
+// DeleteUnused removes the records that were invalidated or that expired
+// before asOf, and returns how many were removed. It uses partitioned DML, so
+// the deletes are not atomic as a whole.
+func (d *KV) DeleteUnused(ctx context.Context, asOf time.Time) (deleted int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	deleted, err = d.client.PartitionedUpdate(ctx, spanner.Statement{
+		SQL: `DELETE FROM records
+			WHERE (invalid_at IS NOT NULL AND invalid_at < @as_of)
+			   OR (expires_at IS NOT NULL AND expires_at < @as_of)`,
+		Params: map[string]interface{}{
+			"as_of": asOf,
+		},
+	})
+	return deleted, errs.Wrap(err)
+}
+
+// AppendHistory adds the event to the history of the key.
+func (d *KV) AppendHistory(ctx context.Context, keyHash auth.KeyHash, event auth.HistoryEvent) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	_, err = d.client.Apply(ctx, []*spanner.Mutation{
+		spanner.InsertMap(historyTable, map[string]interface{}{
+			"encryption_key_hash": keyHash[:],
+			"created_at":          spanner.CommitTimestamp,
+			"action":              event.Action,
+			"actor":               event.Actor,
+			"reason":              event.Reason,
+			"source_ip":           event.SourceIP,
+		}),
+	})
+	return errs.Wrap(err)
+}
+
+// History returns the events of the key in the order they were appended.
+func (d *KV) History(ctx context.Context, keyHash auth.KeyHash) (events []auth.HistoryEvent, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	// rows are read in primary key order, which is the order of the appends
+	columns := []string{"created_at", "action", "actor", "reason", "source_ip"}
+	keys := spanner.Key{keyHash[:]}.AsPrefix()
+	err = d.client.Single().Read(ctx, historyTable, keys, columns).Do(func(row *spanner.Row) error {
+		var event auth.HistoryEvent
+		if err := row.Columns(&event.At, &event.Action, &event.Actor, &event.Reason, &event.SourceIP); err != nil {
+			return err
+		}
+		events = append(events, event)
+		return nil
+	})
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+	return events, nil
+}
+
+// Iterate calls fn for every record in the key/value store, including invalid,
+// expired and soft deleted records. The records are streamed from a single read, so fn
+// should not take long.
+func (d *KV) Iterate(ctx context.Context, fn func(ctx context.Context, entry auth.Entry) error) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	columns := append([]string{"encryption_key_hash"}, recordColumns...)
+	err = d.client.Single().Read(ctx, table, spanner.AllKeys(), columns).Do(func(row *spanner.Row) error {
+		entry, err := scanEntry(row)
+		if err != nil {
+			return err
+		}
+		return fn(ctx, entry)
+	})
+	return errs.Wrap(err)
+}
+
+// List returns up to limit records with key hashes after the given key hash
+// in the order of their key hashes, including invalid, expired and soft
+// deleted records.
+func (d *KV) List(ctx context.Context, after auth.KeyHash, limit int) (entries []auth.Entry, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if limit <= 0 {
+		return nil, nil
+	}
+
+	// the empty end key is a prefix of every key, so the range is every key
+	// after the given one
+	keys := spanner.KeyRange{Start: spanner.Key{after[:]}, End: spanner.Key{}, Kind: spanner.OpenClosed}
+	columns := append([]string{"encryption_key_hash"}, recordColumns...)
+	err = d.client.Single().ReadWithOptions(ctx, table, keys, columns, &spanner.ReadOptions{Limit: limit}).Do(func(row *spanner.Row) error {
+		entry, err := scanEntry(row)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+	return entries, nil
+}
+
+// scanEntry reads the key hash from the start of the row and recordColumns
+// from the end of it.
+func scanEntry(row *spanner.Row) (entry auth.Entry, err error) {
+	var keyHash []byte
+	if err := row.Column(0, &keyHash); err != nil {
+		return auth.Entry{}, err
+	}
+
+	record, invalidReason, deletedAt, err := scanRecord(row)
+	if err != nil {
+		return auth.Entry{}, err
+	}
+
+	entry = auth.Entry{Record: record, InvalidReason: invalidReason.StringVal}
+	if deletedAt.Valid {
+		entry.DeletedAt = &deletedAt.Time
+	}
+	copy(entry.KeyHash[:], keyHash)
+	return entry, nil
+}
+
+// HealthCheck reads from every table, so that it fails if the database can't
+// be reached, if the tables don't exist or if the credentials can't read them.
+func (d *KV) HealthCheck(ctx context.Context) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	for _, name := range []string{table, historyTable} {
+		statement := spanner.Statement{SQL: `SELECT 1 FROM ` + name + ` LIMIT 1`}
+		err := d.client.Single().Query(ctx, statement).Do(func(row *spanner.Row) error { return nil })
+		if err != nil {
+			return errs.Wrap(err)
+		}
+	}
+	return nil
+}
+
+// Close closes the spanner client.
+func (d *KV) Close() error {
+	d.client.Close()
+	return nil
+}
@@ -0,0 +1,88 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package spannerauth_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	database "cloud.google.com/go/spanner/admin/database/apiv1"
+	"github.com/stretchr/testify/require"
+	databasepb "google.golang.org/genproto/googleapis/spanner/admin/database/v1"
+
+	"storj.io/stargate/auth"
+	"storj.io/stargate/auth/spannerauth"
+)
+
+// newKV returns a KV of a new database with the schema in the Spanner
+// emulator at SPANNER_EMULATOR_HOST together with its client, and skips the
+// test without an emulator. The instance of the database is SPANNER_INSTANCE,
+// which must exist.
+func newKV(ctx context.Context, t *testing.T) (*spannerauth.KV, *spanner.Client) {
+	if os.Getenv("SPANNER_EMULATOR_HOST") == "" {
+		t.Skip("SPANNER_EMULATOR_HOST is not set")
+	}
+	instance := os.Getenv("SPANNER_INSTANCE")
+	if instance == "" {
+		instance = "projects/stargate/instances/test"
+	}
+
+	admin, err := database.NewDatabaseAdminClient(ctx)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, admin.Close()) }()
+
+	name := fmt.Sprintf("kv%d", time.Now().UnixNano())
+	op, err := admin.CreateDatabase(ctx, &databasepb.CreateDatabaseRequest{
+		Parent:          instance,
+		CreateStatement: "CREATE DATABASE `" + name + "`",
+		ExtraStatements: []string{spannerauth.Schema},
+	})
+	require.NoError(t, err)
+	_, err = op.Wait(ctx)
+	require.NoError(t, err)
+
+	client, err := spanner.NewClient(ctx, instance+"/databases/"+name)
+	require.NoError(t, err)
+	return spannerauth.New(client), client
+}
+
+func TestKV(t *testing.T) {
+	ctx := context.Background()
+	kv, client := newKV(ctx, t)
+	defer client.Close()
+
+	keyHash := auth.KeyHash{1}
+	record := &auth.Record{
+		SatelliteAddress:     "satellite",
+		MacaroonHead:         []byte("head"),
+		EncryptedSecretKey:   []byte("secret"),
+		EncryptedAccessGrant: []byte("access"),
+		Public:               true,
+	}
+	before := time.Now()
+	require.NoError(t, kv.Put(ctx, keyHash, record))
+	require.Error(t, kv.Put(ctx, keyHash, record))
+
+	got, err := kv.Get(ctx, keyHash)
+	require.NoError(t, err)
+	require.NotNil(t, got.CreatedAt)
+	require.WithinDuration(t, before, *got.CreatedAt, time.Minute)
+	got.CreatedAt = nil
+	require.Equal(t, record, got)
+
+	require.NoError(t, kv.Invalidate(ctx, keyHash, "first"))
+	require.NoError(t, kv.Invalidate(ctx, keyHash, "second"))
+	_, err = kv.Get(ctx, keyHash)
+	require.True(t, auth.Invalid.Has(err))
+	require.Contains(t, err.Error(), "first")
+
+	require.NoError(t, kv.Delete(ctx, keyHash))
+	got, err = kv.Get(ctx, keyHash)
+	require.NoError(t, err)
+	require.Nil(t, got)
+}
@@ -273,7 +273,17 @@ func newpgxcockroach(db *DB) *pgxcockroachDB {
 }
 
 func (obj *pgxcockroachDB) Schema() string {
-	return `CREATE TABLE records (
+	return `CREATE TABLE record_events (
+	id bigserial NOT NULL,
+	encryption_key_hash bytea NOT NULL,
+	created_at timestamp with time zone NOT NULL,
+	action text NOT NULL,
+	actor text NOT NULL,
+	reason text NOT NULL,
+	source_ip text NOT NULL,
+	PRIMARY KEY ( id )
+);
+CREATE TABLE records (
 	encryption_key_hash bytea NOT NULL,
 	created_at timestamp with time zone NOT NULL,
 	public boolean NOT NULL,
@@ -284,8 +294,12 @@ func (obj *pgxcockroachDB) Schema() string {
 	encrypted_access_grant bytea NOT NULL,
 	invalid_reason text,
 	invalid_at timestamp with time zone,
+	deleted_at timestamp with time zone,
+	labels text,
 	PRIMARY KEY ( encryption_key_hash )
-);`
+);
+CREATE INDEX record_events_encryption_key_hash_index ON record_events ( encryption_key_hash );
+CREATE INDEX records_macaroon_head_index ON records ( macaroon_head );`
 }
 
 func (obj *pgxcockroachDB) wrapTx(tx *sql.Tx) txMethods {
@@ -349,7 +363,17 @@ func newsqlite3(db *DB) *sqlite3DB {
 }
 
 func (obj *sqlite3DB) Schema() string {
-	return `CREATE TABLE records (
+	return `CREATE TABLE record_events (
+	id INTEGER NOT NULL,
+	encryption_key_hash BLOB NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	action TEXT NOT NULL,
+	actor TEXT NOT NULL,
+	reason TEXT NOT NULL,
+	source_ip TEXT NOT NULL,
+	PRIMARY KEY ( id )
+);
+CREATE TABLE records (
 	encryption_key_hash BLOB NOT NULL,
 	created_at TIMESTAMP NOT NULL,
 	public INTEGER NOT NULL,
@@ -360,8 +384,12 @@ func (obj *sqlite3DB) Schema() string {
 	encrypted_access_grant BLOB NOT NULL,
 	invalid_reason TEXT,
 	invalid_at TIMESTAMP,
+	deleted_at TIMESTAMP,
+	labels TEXT,
 	PRIMARY KEY ( encryption_key_hash )
-);`
+);
+CREATE INDEX record_events_encryption_key_hash_index ON record_events ( encryption_key_hash );
+CREATE INDEX records_macaroon_head_index ON records ( macaroon_head );`
 }
 
 func (obj *sqlite3DB) wrapTx(tx *sql.Tx) txMethods {
@@ -424,6 +452,154 @@ nextval:
 	fmt.Fprint(f, "]")
 }
 
+type RecordEvent struct {
+	Id                int64
+	EncryptionKeyHash []byte
+	CreatedAt         time.Time
+	Action            string
+	Actor             string
+	Reason            string
+	SourceIp          string
+}
+
+func (RecordEvent) _Table() string { return "record_events" }
+
+type RecordEvent_Update_Fields struct {
+}
+
+type RecordEvent_Id_Field struct {
+	_set   bool
+	_null  bool
+	_value int64
+}
+
+func RecordEvent_Id(v int64) RecordEvent_Id_Field {
+	return RecordEvent_Id_Field{_set: true, _value: v}
+}
+
+func (f RecordEvent_Id_Field) value() interface{} {
+	if !f._set || f._null {
+		return nil
+	}
+	return f._value
+}
+
+func (RecordEvent_Id_Field) _Column() string { return "id" }
+
+type RecordEvent_EncryptionKeyHash_Field struct {
+	_set   bool
+	_null  bool
+	_value []byte
+}
+
+func RecordEvent_EncryptionKeyHash(v []byte) RecordEvent_EncryptionKeyHash_Field {
+	return RecordEvent_EncryptionKeyHash_Field{_set: true, _value: v}
+}
+
+func (f RecordEvent_EncryptionKeyHash_Field) value() interface{} {
+	if !f._set || f._null {
+		return nil
+	}
+	return f._value
+}
+
+func (RecordEvent_EncryptionKeyHash_Field) _Column() string { return "encryption_key_hash" }
+
+type RecordEvent_CreatedAt_Field struct {
+	_set   bool
+	_null  bool
+	_value time.Time
+}
+
+func RecordEvent_CreatedAt(v time.Time) RecordEvent_CreatedAt_Field {
+	return RecordEvent_CreatedAt_Field{_set: true, _value: v}
+}
+
+func (f RecordEvent_CreatedAt_Field) value() interface{} {
+	if !f._set || f._null {
+		return nil
+	}
+	return f._value
+}
+
+func (RecordEvent_CreatedAt_Field) _Column() string { return "created_at" }
+
+type RecordEvent_Action_Field struct {
+	_set   bool
+	_null  bool
+	_value string
+}
+
+func RecordEvent_Action(v string) RecordEvent_Action_Field {
+	return RecordEvent_Action_Field{_set: true, _value: v}
+}
+
+func (f RecordEvent_Action_Field) value() interface{} {
+	if !f._set || f._null {
+		return nil
+	}
+	return f._value
+}
+
+func (RecordEvent_Action_Field) _Column() string { return "action" }
+
+type RecordEvent_Actor_Field struct {
+	_set   bool
+	_null  bool
+	_value string
+}
+
+func RecordEvent_Actor(v string) RecordEvent_Actor_Field {
+	return RecordEvent_Actor_Field{_set: true, _value: v}
+}
+
+func (f RecordEvent_Actor_Field) value() interface{} {
+	if !f._set || f._null {
+		return nil
+	}
+	return f._value
+}
+
+func (RecordEvent_Actor_Field) _Column() string { return "actor" }
+
+type RecordEvent_Reason_Field struct {
+	_set   bool
+	_null  bool
+	_value string
+}
+
+func RecordEvent_Reason(v string) RecordEvent_Reason_Field {
+	return RecordEvent_Reason_Field{_set: true, _value: v}
+}
+
+func (f RecordEvent_Reason_Field) value() interface{} {
+	if !f._set || f._null {
+		return nil
+	}
+	return f._value
+}
+
+func (RecordEvent_Reason_Field) _Column() string { return "reason" }
+
+type RecordEvent_SourceIp_Field struct {
+	_set   bool
+	_null  bool
+	_value string
+}
+
+func RecordEvent_SourceIp(v string) RecordEvent_SourceIp_Field {
+	return RecordEvent_SourceIp_Field{_set: true, _value: v}
+}
+
+func (f RecordEvent_SourceIp_Field) value() interface{} {
+	if !f._set || f._null {
+		return nil
+	}
+	return f._value
+}
+
+func (RecordEvent_SourceIp_Field) _Column() string { return "source_ip" }
+
 type Record struct {
 	EncryptionKeyHash    []byte
 	CreatedAt            time.Time
@@ -435,6 +611,8 @@ type Record struct {
 	EncryptedAccessGrant []byte
 	InvalidReason        *string
 	InvalidAt            *time.Time
+	DeletedAt            *time.Time
+	Labels               *string
 }
 
 func (Record) _Table() string { return "records" }
@@ -443,6 +621,8 @@ type Record_Create_Fields struct {
 	ExpiresAt     Record_ExpiresAt_Field
 	InvalidReason Record_InvalidReason_Field
 	InvalidAt     Record_InvalidAt_Field
+	DeletedAt     Record_DeletedAt_Field
+	Labels        Record_Labels_Field
 }
 
 type Record_Update_Fields struct {
@@ -679,6 +859,70 @@ func (f Record_InvalidAt_Field) value() interface{} {
 
 func (Record_InvalidAt_Field) _Column() string { return "invalid_at" }
 
+type Record_DeletedAt_Field struct {
+	_set   bool
+	_null  bool
+	_value *time.Time
+}
+
+func Record_DeletedAt(v time.Time) Record_DeletedAt_Field {
+	return Record_DeletedAt_Field{_set: true, _value: &v}
+}
+
+func Record_DeletedAt_Raw(v *time.Time) Record_DeletedAt_Field {
+	if v == nil {
+		return Record_DeletedAt_Null()
+	}
+	return Record_DeletedAt(*v)
+}
+
+func Record_DeletedAt_Null() Record_DeletedAt_Field {
+	return Record_DeletedAt_Field{_set: true, _null: true}
+}
+
+func (f Record_DeletedAt_Field) isnull() bool { return !f._set || f._null || f._value == nil }
+
+func (f Record_DeletedAt_Field) value() interface{} {
+	if !f._set || f._null {
+		return nil
+	}
+	return f._value
+}
+
+func (Record_DeletedAt_Field) _Column() string { return "deleted_at" }
+
+type Record_Labels_Field struct {
+	_set   bool
+	_null  bool
+	_value *string
+}
+
+func Record_Labels(v string) Record_Labels_Field {
+	return Record_Labels_Field{_set: true, _value: &v}
+}
+
+func Record_Labels_Raw(v *string) Record_Labels_Field {
+	if v == nil {
+		return Record_Labels_Null()
+	}
+	return Record_Labels(*v)
+}
+
+func Record_Labels_Null() Record_Labels_Field {
+	return Record_Labels_Field{_set: true, _null: true}
+}
+
+func (f Record_Labels_Field) isnull() bool { return !f._set || f._null || f._value == nil }
+
+func (f Record_Labels_Field) value() interface{} {
+	if !f._set || f._null {
+		return nil
+	}
+	return f._value
+}
+
+func (Record_Labels_Field) _Column() string { return "labels" }
+
 func toUTC(t time.Time) time.Time {
 	return t.UTC()
 }
@@ -1120,11 +1364,13 @@ func (obj *pgxcockroachImpl) CreateNoReturn_Record(ctx context.Context,
 	__encrypted_access_grant_val := record_encrypted_access_grant.value()
 	__invalid_reason_val := optional.InvalidReason.value()
 	__invalid_at_val := optional.InvalidAt.value()
+	__deleted_at_val := optional.DeletedAt.value()
+	__labels_val := optional.Labels.value()
 
-	var __embed_stmt = __sqlbundle_Literal("INSERT INTO records ( encryption_key_hash, created_at, public, satellite_address, macaroon_head, expires_at, encrypted_secret_key, encrypted_access_grant, invalid_reason, invalid_at ) VALUES ( ?, ?, ?, ?, ?, ?, ?, ?, ?, ? )")
+	var __embed_stmt = __sqlbundle_Literal("INSERT INTO records ( encryption_key_hash, created_at, public, satellite_address, macaroon_head, expires_at, encrypted_secret_key, encrypted_access_grant, invalid_reason, invalid_at, deleted_at, labels ) VALUES ( ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ? )")
 
 	var __values []interface{}
-	__values = append(__values, __encryption_key_hash_val, __created_at_val, __public_val, __satellite_address_val, __macaroon_head_val, __expires_at_val, __encrypted_secret_key_val, __encrypted_access_grant_val, __invalid_reason_val, __invalid_at_val)
+	__values = append(__values, __encryption_key_hash_val, __created_at_val, __public_val, __satellite_address_val, __macaroon_head_val, __expires_at_val, __encrypted_secret_key_val, __encrypted_access_grant_val, __invalid_reason_val, __invalid_at_val, __deleted_at_val, __labels_val)
 
 	var __stmt = __sqlbundle_Render(obj.dialect, __embed_stmt)
 	obj.logStmt(__stmt, __values...)
@@ -1141,7 +1387,7 @@ func (obj *pgxcockroachImpl) Find_Record_By_EncryptionKeyHash(ctx context.Contex
 	record_encryption_key_hash Record_EncryptionKeyHash_Field) (
 	record *Record, err error) {
 
-	var __embed_stmt = __sqlbundle_Literal("SELECT records.encryption_key_hash, records.created_at, records.public, records.satellite_address, records.macaroon_head, records.expires_at, records.encrypted_secret_key, records.encrypted_access_grant, records.invalid_reason, records.invalid_at FROM records WHERE records.encryption_key_hash = ?")
+	var __embed_stmt = __sqlbundle_Literal("SELECT records.encryption_key_hash, records.created_at, records.public, records.satellite_address, records.macaroon_head, records.expires_at, records.encrypted_secret_key, records.encrypted_access_grant, records.invalid_reason, records.invalid_at, records.deleted_at, records.labels FROM records WHERE records.encryption_key_hash = ?")
 
 	var __values []interface{}
 	__values = append(__values, record_encryption_key_hash.value())
@@ -1150,7 +1396,7 @@ func (obj *pgxcockroachImpl) Find_Record_By_EncryptionKeyHash(ctx context.Contex
 	obj.logStmt(__stmt, __values...)
 
 	record = &Record{}
-	err = obj.driver.QueryRowContext(ctx, __stmt, __values...).Scan(&record.EncryptionKeyHash, &record.CreatedAt, &record.Public, &record.SatelliteAddress, &record.MacaroonHead, &record.ExpiresAt, &record.EncryptedSecretKey, &record.EncryptedAccessGrant, &record.InvalidReason, &record.InvalidAt)
+	err = obj.driver.QueryRowContext(ctx, __stmt, __values...).Scan(&record.EncryptionKeyHash, &record.CreatedAt, &record.Public, &record.SatelliteAddress, &record.MacaroonHead, &record.ExpiresAt, &record.EncryptedSecretKey, &record.EncryptedAccessGrant, &record.InvalidReason, &record.InvalidAt, &record.DeletedAt, &record.Labels)
 	if err == sql.ErrNoRows {
 		return (*Record)(nil), nil
 	}
@@ -1246,6 +1492,16 @@ func (obj *pgxcockroachImpl) deleteAll(ctx context.Context) (count int64, err er
 		return 0, obj.makeErr(err)
 	}
 
+	__count, err = __res.RowsAffected()
+	if err != nil {
+		return 0, obj.makeErr(err)
+	}
+	count += __count
+	__res, err = obj.driver.ExecContext(ctx, "DELETE FROM record_events;")
+	if err != nil {
+		return 0, obj.makeErr(err)
+	}
+
 	__count, err = __res.RowsAffected()
 	if err != nil {
 		return 0, obj.makeErr(err)
@@ -1277,11 +1533,13 @@ func (obj *sqlite3Impl) CreateNoReturn_Record(ctx context.Context,
 	__encrypted_access_grant_val := record_encrypted_access_grant.value()
 	__invalid_reason_val := optional.InvalidReason.value()
 	__invalid_at_val := optional.InvalidAt.value()
+	__deleted_at_val := optional.DeletedAt.value()
+	__labels_val := optional.Labels.value()
 
-	var __embed_stmt = __sqlbundle_Literal("INSERT INTO records ( encryption_key_hash, created_at, public, satellite_address, macaroon_head, expires_at, encrypted_secret_key, encrypted_access_grant, invalid_reason, invalid_at ) VALUES ( ?, ?, ?, ?, ?, ?, ?, ?, ?, ? )")
+	var __embed_stmt = __sqlbundle_Literal("INSERT INTO records ( encryption_key_hash, created_at, public, satellite_address, macaroon_head, expires_at, encrypted_secret_key, encrypted_access_grant, invalid_reason, invalid_at, deleted_at, labels ) VALUES ( ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ? )")
 
 	var __values []interface{}
-	__values = append(__values, __encryption_key_hash_val, __created_at_val, __public_val, __satellite_address_val, __macaroon_head_val, __expires_at_val, __encrypted_secret_key_val, __encrypted_access_grant_val, __invalid_reason_val, __invalid_at_val)
+	__values = append(__values, __encryption_key_hash_val, __created_at_val, __public_val, __satellite_address_val, __macaroon_head_val, __expires_at_val, __encrypted_secret_key_val, __encrypted_access_grant_val, __invalid_reason_val, __invalid_at_val, __deleted_at_val, __labels_val)
 
 	var __stmt = __sqlbundle_Render(obj.dialect, __embed_stmt)
 	obj.logStmt(__stmt, __values...)
@@ -1298,7 +1556,7 @@ func (obj *sqlite3Impl) Find_Record_By_EncryptionKeyHash(ctx context.Context,
 	record_encryption_key_hash Record_EncryptionKeyHash_Field) (
 	record *Record, err error) {
 
-	var __embed_stmt = __sqlbundle_Literal("SELECT records.encryption_key_hash, records.created_at, records.public, records.satellite_address, records.macaroon_head, records.expires_at, records.encrypted_secret_key, records.encrypted_access_grant, records.invalid_reason, records.invalid_at FROM records WHERE records.encryption_key_hash = ?")
+	var __embed_stmt = __sqlbundle_Literal("SELECT records.encryption_key_hash, records.created_at, records.public, records.satellite_address, records.macaroon_head, records.expires_at, records.encrypted_secret_key, records.encrypted_access_grant, records.invalid_reason, records.invalid_at, records.deleted_at, records.labels FROM records WHERE records.encryption_key_hash = ?")
 
 	var __values []interface{}
 	__values = append(__values, record_encryption_key_hash.value())
@@ -1307,7 +1565,7 @@ func (obj *sqlite3Impl) Find_Record_By_EncryptionKeyHash(ctx context.Context,
 	obj.logStmt(__stmt, __values...)
 
 	record = &Record{}
-	err = obj.driver.QueryRowContext(ctx, __stmt, __values...).Scan(&record.EncryptionKeyHash, &record.CreatedAt, &record.Public, &record.SatelliteAddress, &record.MacaroonHead, &record.ExpiresAt, &record.EncryptedSecretKey, &record.EncryptedAccessGrant, &record.InvalidReason, &record.InvalidAt)
+	err = obj.driver.QueryRowContext(ctx, __stmt, __values...).Scan(&record.EncryptionKeyHash, &record.CreatedAt, &record.Public, &record.SatelliteAddress, &record.MacaroonHead, &record.ExpiresAt, &record.EncryptedSecretKey, &record.EncryptedAccessGrant, &record.InvalidReason, &record.InvalidAt, &record.DeletedAt, &record.Labels)
 	if err == sql.ErrNoRows {
 		return (*Record)(nil), nil
 	}
@@ -1389,13 +1647,13 @@ func (obj *sqlite3Impl) getLastRecord(ctx context.Context,
 	pk int64) (
 	record *Record, err error) {
 
-	var __embed_stmt = __sqlbundle_Literal("SELECT records.encryption_key_hash, records.created_at, records.public, records.satellite_address, records.macaroon_head, records.expires_at, records.encrypted_secret_key, records.encrypted_access_grant, records.invalid_reason, records.invalid_at FROM records WHERE _rowid_ = ?")
+	var __embed_stmt = __sqlbundle_Literal("SELECT records.encryption_key_hash, records.created_at, records.public, records.satellite_address, records.macaroon_head, records.expires_at, records.encrypted_secret_key, records.encrypted_access_grant, records.invalid_reason, records.invalid_at, records.deleted_at, records.labels FROM records WHERE _rowid_ = ?")
 
 	var __stmt = __sqlbundle_Render(obj.dialect, __embed_stmt)
 	obj.logStmt(__stmt, pk)
 
 	record = &Record{}
-	err = obj.driver.QueryRowContext(ctx, __stmt, pk).Scan(&record.EncryptionKeyHash, &record.CreatedAt, &record.Public, &record.SatelliteAddress, &record.MacaroonHead, &record.ExpiresAt, &record.EncryptedSecretKey, &record.EncryptedAccessGrant, &record.InvalidReason, &record.InvalidAt)
+	err = obj.driver.QueryRowContext(ctx, __stmt, pk).Scan(&record.EncryptionKeyHash, &record.CreatedAt, &record.Public, &record.SatelliteAddress, &record.MacaroonHead, &record.ExpiresAt, &record.EncryptedSecretKey, &record.EncryptedAccessGrant, &record.InvalidReason, &record.InvalidAt, &record.DeletedAt, &record.Labels)
 	if err != nil {
 		return (*Record)(nil), obj.makeErr(err)
 	}
@@ -1426,6 +1684,16 @@ func (obj *sqlite3Impl) deleteAll(ctx context.Context) (count int64, err error)
 		return 0, obj.makeErr(err)
 	}
 
+	__count, err = __res.RowsAffected()
+	if err != nil {
+		return 0, obj.makeErr(err)
+	}
+	count += __count
+	__res, err = obj.driver.ExecContext(ctx, "DELETE FROM record_events;")
+	if err != nil {
+		return 0, obj.makeErr(err)
+	}
+
 	__count, err = __res.RowsAffected()
 	if err != nil {
 		return 0, obj.makeErr(err)
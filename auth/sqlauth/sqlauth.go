@@ -5,6 +5,11 @@ package sqlauth
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/spacemonkeygo/monkit/v3"
@@ -17,6 +22,12 @@ var mon = monkit.Package()
 
 //go:generate sh gen.sh
 
+func init() {
+	for _, scheme := range []string{"postgres", "postgresql", "cockroach", "sqlite", "sqlite3"} {
+		auth.RegisterKV(scheme, openURL)
+	}
+}
+
 // KV is a key/value store backed by a sql database.
 type KV struct {
 	db *DB
@@ -29,43 +40,284 @@ func New(db *DB) *KV {
 	}
 }
 
+// OpenKV opens the sql database with the dbx driver and source, and makes sure that
+// the schema exists.
+func OpenKV(ctx context.Context, driver, source string) (_ *KV, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	db, err := Open(driver, source)
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+
+	kv := New(db)
+	if err := kv.MigrateToLatest(ctx); err != nil {
+		return nil, errs.Combine(err, db.Close())
+	}
+
+	return kv, nil
+}
+
+// openURL opens a KV for a postgres, cockroach or sqlite database url.
+func openURL(ctx context.Context, databaseURL string) (auth.KV, error) {
+	parsed, err := url.Parse(databaseURL)
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+
+	var driver, source string
+	switch strings.ToLower(parsed.Scheme) {
+	case "postgres", "postgresql":
+		driver, source = "pgxcockroach", databaseURL
+	case "cockroach":
+		// pgx only understands the postgres scheme
+		parsed.Scheme = "postgres"
+		driver, source = "pgxcockroach", parsed.String()
+	case "sqlite", "sqlite3":
+		// sqlite:///path/to/file.db or sqlite::memory:
+		driver, source = "sqlite3", parsed.Opaque
+		if source == "" {
+			source = parsed.Path
+		}
+		if parsed.RawQuery != "" {
+			source += "?" + parsed.RawQuery
+		}
+	default:
+		return nil, errs.New("unsupported database scheme %q", parsed.Scheme)
+	}
+
+	kv, err := OpenKV(ctx, driver, source)
+	if err != nil {
+		return nil, err
+	}
+	return kv, nil
+}
+
+// MigrateToLatest creates the tables and indexes if they don't exist yet.
+func (d *KV) MigrateToLatest(ctx context.Context) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	// the dbx generated schema has no IF NOT EXISTS, but both dialects support it
+	schema := strings.Replace(d.db.Schema(), "CREATE TABLE", "CREATE TABLE IF NOT EXISTS", -1)
+	schema = strings.Replace(schema, "CREATE INDEX", "CREATE INDEX IF NOT EXISTS", -1)
+	if _, err := d.db.ExecContext(ctx, schema); err != nil {
+		return errs.Wrap(err)
+	}
+
+	// columns that were added after the tables were first created. The usage
+	// and owner columns aren't part of the dbx schema, since they are only
+	// written with raw queries.
+	for _, column := range []struct{ name, postgresType, sqliteType string }{
+		{"deleted_at", "timestamp with time zone", "TIMESTAMP"},
+		{"labels", "text", "TEXT"},
+		{"last_used_at", "timestamp with time zone", "TIMESTAMP"},
+		{"use_count", "bigint NOT NULL DEFAULT 0", "INTEGER NOT NULL DEFAULT 0"},
+		{"owner", "text", "TEXT"},
+	} {
+		if err := d.addColumn(ctx, column.name, column.postgresType, column.sqliteType); err != nil {
+			return err
+		}
+	}
+
+	_, err = d.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS records_owner_index ON records ( owner )`)
+	return errs.Wrap(err)
+}
+
+// addColumn adds the column to the records table if it doesn't have it yet,
+// like when the table was created by an older version.
+func (d *KV) addColumn(ctx context.Context, name, postgresType, sqliteType string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if _, ok := d.db.dbMethods.(*sqlite3DB); !ok {
+		_, err = d.db.ExecContext(ctx, `ALTER TABLE records ADD COLUMN IF NOT EXISTS `+name+` `+postgresType)
+		return errs.Wrap(err)
+	}
+
+	// sqlite has no IF NOT EXISTS for columns
+	var count int
+	err = d.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM pragma_table_info('records') WHERE name = ?`, name).Scan(&count)
+	if err != nil || count > 0 {
+		return errs.Wrap(err)
+	}
+	_, err = d.db.ExecContext(ctx, `ALTER TABLE records ADD COLUMN `+name+` `+sqliteType)
+	return errs.Wrap(err)
+}
+
+// HealthCheck reads from every table, so that it fails if the database can't
+// be reached, if the tables don't exist or if the user can't read them.
+func (d *KV) HealthCheck(ctx context.Context) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	for _, name := range []string{"records", "record_events"} {
+		var one int
+		err := d.db.QueryRowContext(ctx, `SELECT 1 FROM `+name+` LIMIT 1`).Scan(&one)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return errs.Wrap(err)
+		}
+	}
+	return nil
+}
+
+// Close closes the underlying database.
+func (d *KV) Close() error {
+	return errs.Wrap(d.db.Close())
+}
+
 // Put stores the record in the key/value store.
 // It is an error if the key already exists.
 func (d *KV) Put(ctx context.Context, keyHash auth.KeyHash, record *auth.Record) (err error) {
 	defer mon.Task()(&ctx)(&err)
 
-	return errs.Wrap(d.db.CreateNoReturn_Record(ctx,
+	// the owner is written by another query, so that it is stored in the
+	// same transaction as the record
+	if record.Owner != "" {
+		return d.PutBatch(ctx, []auth.Entry{{KeyHash: keyHash, Record: record}})
+	}
+	return errs.Wrap(createRecord(ctx, d.db, keyHash, record))
+}
+
+// PutBatch stores all of the records in the key/value store in a single transaction.
+// It is an error if any of the keys already exist, in which case none are stored.
+func (d *KV) PutBatch(ctx context.Context, entries []auth.Entry) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	tx, err := d.db.Open(ctx)
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	defer func() {
+		if err != nil {
+			err = errs.Combine(err, tx.Rollback())
+		} else {
+			err = errs.Wrap(tx.Commit())
+		}
+	}()
+
+	for _, entry := range entries {
+		if err := createRecord(ctx, tx, entry.KeyHash, entry.Record); err != nil {
+			return errs.Wrap(err)
+		}
+		if entry.Record.Owner == "" {
+			continue
+		}
+		_, err := tx.Tx.ExecContext(ctx, tx.Rebind(`UPDATE records SET owner = ? WHERE encryption_key_hash = ?`),
+			entry.Record.Owner, entry.KeyHash[:])
+		if err != nil {
+			return errs.Wrap(err)
+		}
+	}
+	return nil
+}
+
+// createRecord inserts the record using either the database or a transaction.
+func createRecord(ctx context.Context, methods Methods, keyHash auth.KeyHash, record *auth.Record) error {
+	labels, err := encodeLabels(record.Labels)
+	if err != nil {
+		return err
+	}
+	return methods.CreateNoReturn_Record(ctx,
 		Record_EncryptionKeyHash(keyHash[:]),
 		Record_Public(record.Public),
 		Record_SatelliteAddress(record.SatelliteAddress),
 		Record_MacaroonHead(record.MacaroonHead),
 		Record_EncryptedSecretKey(record.EncryptedSecretKey),
 		Record_EncryptedAccessGrant(record.EncryptedAccessGrant),
-		Record_Create_Fields{},
-	))
+		Record_Create_Fields{
+			ExpiresAt: Record_ExpiresAt_Raw(utc(record.ExpiresAt)),
+			Labels:    Record_Labels_Raw(labels),
+		},
+	)
 }
 
 // Get retrieves the record from the key/value store.
+// It returns nil if the key does not exist or if the record is soft deleted.
 func (d *KV) Get(ctx context.Context, keyHash auth.KeyHash) (record *auth.Record, err error) {
 	defer mon.Task()(&ctx)(&err)
 
-	dbRecord, err := d.db.Find_Record_By_EncryptionKeyHash(ctx,
-		Record_EncryptionKeyHash(keyHash[:]))
-	if err != nil {
-		return nil, errs.Wrap(err)
-	} else if dbRecord == nil {
+	// the record is read with a raw query, since the dbx model doesn't have
+	// the usage columns
+	entry, err := scanEntry(d.db.QueryRowContext(ctx, d.db.Rebind(`
+		SELECT `+entryColumns+`
+		FROM records
+		WHERE encryption_key_hash = ?
+	`), keyHash[:]).Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	} else if entry.DeletedAt != nil {
 		return nil, nil
-	} else if dbRecord.InvalidReason != nil {
-		return nil, auth.Invalid.New("%s", *dbRecord.InvalidReason)
+	} else if entry.InvalidReason != "" {
+		return nil, auth.Invalid.New("%s", entry.InvalidReason)
 	}
 
-	return &auth.Record{
-		SatelliteAddress:     dbRecord.SatelliteAddress,
-		MacaroonHead:         dbRecord.MacaroonHead,
-		EncryptedSecretKey:   dbRecord.EncryptedSecretKey,
-		EncryptedAccessGrant: dbRecord.EncryptedAccessGrant,
-		Public:               dbRecord.Public,
-	}, nil
+	if entry.Record.Expired(time.Now()) {
+		return nil, auth.ErrExpired(entry.Record)
+	}
+	return entry.Record, nil
+}
+
+// getBatchSize is how many keys GetBatch reads with a single query, which keeps
+// the queries below the parameter limits of the databases.
+const getBatchSize = 1000
+
+// GetBatch retrieves the records for all of the keys from the key/value store
+// with a query per getBatchSize keys. A record is nil if its key does not exist
+// or if it is invalid, expired or soft deleted.
+func (d *KV) GetBatch(ctx context.Context, keyHashes []auth.KeyHash) (records []*auth.Record, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	now := time.Now()
+	found := make(map[auth.KeyHash]*auth.Record, len(keyHashes))
+	for start := 0; start < len(keyHashes); start += getBatchSize {
+		end := start + getBatchSize
+		if end > len(keyHashes) {
+			end = len(keyHashes)
+		}
+		if err := d.readBatch(ctx, keyHashes[start:end], now, found); err != nil {
+			return nil, err
+		}
+	}
+
+	records = make([]*auth.Record, len(keyHashes))
+	for i, keyHash := range keyHashes {
+		records[i] = found[keyHash]
+	}
+	return records, nil
+}
+
+// readBatch reads the records of the keys that are valid and not expired at now
+// into found.
+func (d *KV) readBatch(ctx context.Context, keyHashes []auth.KeyHash, now time.Time, found map[auth.KeyHash]*auth.Record) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	args := make([]interface{}, 0, len(keyHashes))
+	for _, keyHash := range keyHashes {
+		keyHash := keyHash
+		args = append(args, keyHash[:])
+	}
+
+	rows, err := d.db.QueryContext(ctx, d.db.Rebind(`
+		SELECT `+entryColumns+`
+		FROM records
+		WHERE encryption_key_hash IN (`+strings.Repeat("?, ", len(keyHashes)-1)+`?)
+		  AND invalid_reason IS NULL AND deleted_at IS NULL
+	`), args...)
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	defer func() { err = errs.Combine(err, errs.Wrap(rows.Close())) }()
+
+	for rows.Next() {
+		entry, err := scanEntry(rows.Scan)
+		if err != nil {
+			return err
+		}
+		if !entry.Record.Expired(now) {
+			found[entry.KeyHash] = entry.Record
+		}
+	}
+	return errs.Wrap(rows.Err())
 }
 
 // Delete removes the record from the key/value store.
@@ -78,6 +330,53 @@ func (d *KV) Delete(ctx context.Context, keyHash auth.KeyHash) (err error) {
 	return errs.Wrap(err)
 }
 
+// SoftDelete marks the record as deleted until it is restored or purged.
+// It is not an error if the key does not exist.
+// It does not update the deletion time if the record is already deleted.
+func (d *KV) SoftDelete(ctx context.Context, keyHash auth.KeyHash) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	_, err = d.db.ExecContext(ctx, d.db.Rebind(`
+		UPDATE records SET deleted_at = ?
+		WHERE encryption_key_hash = ? AND deleted_at IS NULL
+	`), time.Now().UTC(), keyHash[:])
+	return errs.Wrap(err)
+}
+
+// Restore undoes SoftDelete, and returns whether the record was deleted.
+// It is not an error if the key does not exist.
+func (d *KV) Restore(ctx context.Context, keyHash auth.KeyHash) (restored bool, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	result, err := d.db.ExecContext(ctx, d.db.Rebind(`
+		UPDATE records SET deleted_at = NULL
+		WHERE encryption_key_hash = ? AND deleted_at IS NOT NULL
+	`), keyHash[:])
+	if err != nil {
+		return false, errs.Wrap(err)
+	}
+
+	restoredCount, err := result.RowsAffected()
+	return restoredCount > 0, errs.Wrap(err)
+}
+
+// PurgeDeleted removes the records that were soft deleted before asOf, and
+// returns how many were removed.
+func (d *KV) PurgeDeleted(ctx context.Context, asOf time.Time) (purged int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	result, err := d.db.ExecContext(ctx, d.db.Rebind(`
+		DELETE FROM records
+		WHERE deleted_at IS NOT NULL AND deleted_at < ?
+	`), asOf.UTC())
+	if err != nil {
+		return 0, errs.Wrap(err)
+	}
+
+	purged, err = result.RowsAffected()
+	return purged, errs.Wrap(err)
+}
+
 // Invalidate causes the record to become invalid.
 // It is not an error if the key does not exist.
 // It does not update the invalid reason if the record is already invalid.
@@ -88,6 +387,419 @@ func (d *KV) Invalidate(ctx context.Context, keyHash auth.KeyHash, reason string
 		Record_EncryptionKeyHash(keyHash[:]),
 		Record_Update_Fields{
 			InvalidReason: Record_InvalidReason(reason),
-			InvalidAt:     Record_InvalidAt(time.Now()),
+			InvalidAt:     Record_InvalidAt(time.Now().UTC()),
 		}))
 }
+
+// Update atomically changes the entry of the key with fn, and returns whether
+// the entry was stored. The entry is stored with a conditional update that
+// only applies if the row is unchanged since it was read, and fn is called
+// again with the changed row otherwise.
+// It is not an error if the key does not exist.
+func (d *KV) Update(ctx context.Context, keyHash auth.KeyHash, fn func(entry *auth.Entry) (store bool, err error)) (updated bool, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	for {
+		dbRecord, err := d.db.Find_Record_By_EncryptionKeyHash(ctx,
+			Record_EncryptionKeyHash(keyHash[:]))
+		if err != nil || dbRecord == nil {
+			return false, errs.Wrap(err)
+		}
+
+		record, err := toAuthRecord(dbRecord)
+		if err != nil {
+			return false, err
+		}
+		if err := d.scanExtra(ctx, keyHash, record); err != nil {
+			return false, err
+		}
+		readOwner := record.Owner
+
+		entry := auth.Entry{KeyHash: keyHash, Record: record, DeletedAt: dbRecord.DeletedAt}
+		if dbRecord.InvalidReason != nil {
+			entry.InvalidReason = *dbRecord.InvalidReason
+		}
+		if store, err := fn(&entry); err != nil || !store {
+			return false, err
+		}
+		if entry.Record == nil {
+			return false, errs.New("record is required")
+		}
+		labels, err := encodeLabels(entry.Record.Labels)
+		if err != nil {
+			return false, err
+		}
+
+		var invalidReason *string
+		invalidAt := dbRecord.InvalidAt
+		if entry.InvalidReason == "" {
+			invalidAt = nil
+		} else {
+			invalidReason = &entry.InvalidReason
+			if dbRecord.InvalidReason == nil || *dbRecord.InvalidReason != entry.InvalidReason {
+				now := time.Now().UTC()
+				invalidAt = &now
+			}
+		}
+
+		query := `
+			UPDATE records SET
+				satellite_address = ?, macaroon_head = ?, encrypted_secret_key = ?,
+				encrypted_access_grant = ?, public = ?, expires_at = ?,
+				invalid_reason = ?, invalid_at = ?, deleted_at = ?, labels = ?, owner = ?
+			WHERE encryption_key_hash = ?`
+		var owner *string
+		if entry.Record.Owner != "" {
+			owner = &entry.Record.Owner
+		}
+		args := []interface{}{
+			entry.Record.SatelliteAddress, entry.Record.MacaroonHead, entry.Record.EncryptedSecretKey,
+			entry.Record.EncryptedAccessGrant, entry.Record.Public, utc(entry.Record.ExpiresAt),
+			invalidReason, invalidAt, utc(entry.DeletedAt), labels, owner,
+			keyHash[:],
+		}
+		query, args = unchanged(query, args, dbRecord, readOwner)
+
+		result, err := d.db.ExecContext(ctx, d.db.Rebind(query), args...)
+		if err != nil {
+			return false, errs.Wrap(err)
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return false, errs.Wrap(err)
+		}
+		if affected > 0 {
+			return true, nil
+		}
+		mon.Event("update_conflict")
+	}
+}
+
+// unchanged adds conditions to the where clause of the query that only match
+// the row if it is still the same as dbRecord and owner. The owner is read by
+// another query than dbRecord, but since every column that Update writes is
+// compared, the row doesn't match if anything changed in between.
+func unchanged(query string, args []interface{}, dbRecord *Record, owner string) (string, []interface{}) {
+	query += ` AND satellite_address = ? AND macaroon_head = ? AND encrypted_secret_key = ?
+		AND encrypted_access_grant = ? AND public = ?`
+	args = append(args, dbRecord.SatelliteAddress, dbRecord.MacaroonHead, dbRecord.EncryptedSecretKey,
+		dbRecord.EncryptedAccessGrant, dbRecord.Public)
+
+	// records without an owner have a null owner, like in CountActive
+	if owner == "" {
+		query += ` AND (owner IS NULL OR owner = ?)`
+	} else {
+		query += ` AND owner = ?`
+	}
+	args = append(args, owner)
+
+	for _, column := range []struct {
+		name  string
+		value interface{}
+		null  bool
+	}{
+		{"expires_at", dbRecord.ExpiresAt, dbRecord.ExpiresAt == nil},
+		{"invalid_reason", dbRecord.InvalidReason, dbRecord.InvalidReason == nil},
+		{"invalid_at", dbRecord.InvalidAt, dbRecord.InvalidAt == nil},
+		{"deleted_at", dbRecord.DeletedAt, dbRecord.DeletedAt == nil},
+		{"labels", dbRecord.Labels, dbRecord.Labels == nil},
+	} {
+		if column.null {
+			query += ` AND ` + column.name + ` IS NULL`
+		} else {
+			query += ` AND ` + column.name + ` = ?`
+			args = append(args, column.value)
+		}
+	}
+	return query, args
+}
+
+// RecordUse adds uses to the use count of the key, and sets when it was last
+// used to at, unless it was used later.
+// It is not an error if the key does not exist.
+func (d *KV) RecordUse(ctx context.Context, keyHash auth.KeyHash, at time.Time, uses int64) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	// timestamps are stored in utc so that they compare correctly on sqlite,
+	// which compares them as text
+	at = at.UTC()
+	_, err = d.db.ExecContext(ctx, d.db.Rebind(`
+		UPDATE records SET
+			use_count = use_count + ?,
+			last_used_at = CASE WHEN last_used_at IS NULL OR last_used_at < ? THEN ? ELSE last_used_at END
+		WHERE encryption_key_hash = ?
+	`), uses, at, at, keyHash[:])
+	return errs.Wrap(err)
+}
+
+// CountActive returns how many records of the owner are active at now.
+func (d *KV) CountActive(ctx context.Context, owner string, now time.Time) (count int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	// records without an owner have a null owner, and the owner is compared
+	// as it is otherwise, so that the index is used
+	ownerClause := "owner = ?"
+	if owner == "" {
+		ownerClause = "(owner IS NULL OR owner = ?)"
+	}
+
+	// timestamps are stored in utc so that they compare correctly on sqlite,
+	// which compares them as text
+	err = d.db.QueryRowContext(ctx, d.db.Rebind(`
+		SELECT COUNT(*) FROM records
+		WHERE `+ownerClause+` AND invalid_reason IS NULL AND deleted_at IS NULL
+		  AND (expires_at IS NULL OR expires_at > ?)
+	`), owner, now.UTC()).Scan(&count)
+	return count, errs.Wrap(err)
+}
+
+// InvalidateByMacaroonHead causes every record with the macaroon head to
+// become invalid, and returns how many were invalidated. Records that are
+// already invalid keep their invalid reason and are not counted.
+func (d *KV) InvalidateByMacaroonHead(ctx context.Context, macaroonHead []byte, reason string) (invalidated int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	result, err := d.db.ExecContext(ctx, d.db.Rebind(`
+		UPDATE records SET invalid_reason = ?, invalid_at = ?
+		WHERE macaroon_head = ? AND invalid_reason IS NULL
+	`), reason, time.Now().UTC(), macaroonHead)
+	if err != nil {
+		return 0, errs.Wrap(err)
+	}
+
+	invalidated, err = result.RowsAffected()
+	return invalidated, errs.Wrap(err)
+}
+
+// DeleteUnused removes the records that were invalidated or that expired
+// before asOf, and returns how many were removed.
+func (d *KV) DeleteUnused(ctx context.Context, asOf time.Time) (deleted int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	// timestamps are stored in utc so that they compare correctly on sqlite,
+	// which compares them as text
+	asOf = asOf.UTC()
+	result, err := d.db.ExecContext(ctx, d.db.Rebind(`
+		DELETE FROM records
+		WHERE (invalid_at IS NOT NULL AND invalid_at < ?)
+		   OR (expires_at IS NOT NULL AND expires_at < ?)
+	`), asOf, asOf)
+	if err != nil {
+		return 0, errs.Wrap(err)
+	}
+
+	deleted, err = result.RowsAffected()
+	return deleted, errs.Wrap(err)
+}
+
+// AppendHistory adds the event to the history of the key.
+func (d *KV) AppendHistory(ctx context.Context, keyHash auth.KeyHash, event auth.HistoryEvent) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	_, err = d.db.ExecContext(ctx, d.db.Rebind(`
+		INSERT INTO record_events ( encryption_key_hash, created_at, action, actor, reason, source_ip )
+		VALUES ( ?, ?, ?, ?, ?, ? )
+	`), keyHash[:], time.Now().UTC(), event.Action, event.Actor, event.Reason, event.SourceIP)
+	return errs.Wrap(err)
+}
+
+// History returns the events of the key in the order they were appended.
+func (d *KV) History(ctx context.Context, keyHash auth.KeyHash) (events []auth.HistoryEvent, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	rows, err := d.db.QueryContext(ctx, d.db.Rebind(`
+		SELECT created_at, action, actor, reason, source_ip
+		FROM record_events
+		WHERE encryption_key_hash = ?
+		ORDER BY created_at, id
+	`), keyHash[:])
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+	defer func() { err = errs.Combine(err, errs.Wrap(rows.Close())) }()
+
+	for rows.Next() {
+		var event auth.HistoryEvent
+		if err := rows.Scan(&event.At, &event.Action, &event.Actor, &event.Reason, &event.SourceIP); err != nil {
+			return nil, errs.Wrap(err)
+		}
+		events = append(events, event)
+	}
+	return events, errs.Wrap(rows.Err())
+}
+
+// iteratePageSize is how many records Iterate reads from the database at once.
+const iteratePageSize = 1000
+
+// Iterate calls fn for every record in the key/value store, including invalid,
+// expired and soft deleted records. Records are read in pages ordered by key, and fn is
+// only called between reads so that it may use the database itself.
+func (d *KV) Iterate(ctx context.Context, fn func(ctx context.Context, entry auth.Entry) error) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	var after []byte
+	for {
+		entries, err := d.listPage(ctx, after, iteratePageSize)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			if err := fn(ctx, entry); err != nil {
+				return err
+			}
+		}
+
+		if len(entries) < iteratePageSize {
+			return nil
+		}
+		last := entries[len(entries)-1].KeyHash
+		after = last[:]
+	}
+}
+
+// List returns up to limit records with key hashes after the given key hash
+// in the order of their key hashes, including invalid, expired and soft
+// deleted records.
+func (d *KV) List(ctx context.Context, after auth.KeyHash, limit int) (entries []auth.Entry, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return d.listPage(ctx, after[:], limit)
+}
+
+// listPage reads the page of up to limit records with keys after the given
+// key, or the first page if after is nil.
+func (d *KV) listPage(ctx context.Context, after []byte, limit int) (entries []auth.Entry, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	query := `
+		SELECT ` + entryColumns + `
+		FROM records
+	`
+	args := []interface{}{}
+	if after != nil {
+		query += "WHERE encryption_key_hash > ?\n"
+		args = append(args, after)
+	}
+	query += "ORDER BY encryption_key_hash LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := d.db.QueryContext(ctx, d.db.Rebind(query), args...)
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+	defer func() { err = errs.Combine(err, errs.Wrap(rows.Close())) }()
+
+	for rows.Next() {
+		entry, err := scanEntry(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, errs.Wrap(rows.Err())
+}
+
+// entryColumns are the columns of the records table that scanEntry scans.
+const entryColumns = `encryption_key_hash, public, satellite_address, macaroon_head, expires_at,
+	encrypted_secret_key, encrypted_access_grant, invalid_reason, deleted_at, labels,
+	last_used_at, use_count, owner`
+
+// scanEntry scans the entryColumns of a row of the records table into an
+// entry. It returns sql.ErrNoRows unwrapped, so that callers can compare it.
+func scanEntry(scan func(dest ...interface{}) error) (entry auth.Entry, err error) {
+	var keyHash []byte
+	var invalidReason *string
+	var labels *string
+	var owner *string
+	record := new(auth.Record)
+	err = scan(&keyHash, &record.Public, &record.SatelliteAddress, &record.MacaroonHead,
+		&record.ExpiresAt, &record.EncryptedSecretKey, &record.EncryptedAccessGrant, &invalidReason,
+		&entry.DeletedAt, &labels, &record.LastUsedAt, &record.UseCount, &owner)
+	if errors.Is(err, sql.ErrNoRows) {
+		return entry, err
+	} else if err != nil {
+		return entry, errs.Wrap(err)
+	}
+	if record.Labels, err = decodeLabels(labels); err != nil {
+		return entry, err
+	}
+
+	if owner != nil {
+		record.Owner = *owner
+	}
+
+	entry.Record = record
+	copy(entry.KeyHash[:], keyHash)
+	if invalidReason != nil {
+		entry.InvalidReason = *invalidReason
+	}
+	return entry, nil
+}
+
+// scanExtra reads the usage and owner columns of the key into the record,
+// since they aren't part of the dbx model.
+func (d *KV) scanExtra(ctx context.Context, keyHash auth.KeyHash, record *auth.Record) (err error) {
+	var owner *string
+	err = d.db.QueryRowContext(ctx, d.db.Rebind(`
+		SELECT last_used_at, use_count, owner
+		FROM records
+		WHERE encryption_key_hash = ?
+	`), keyHash[:]).Scan(&record.LastUsedAt, &record.UseCount, &owner)
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	if owner != nil {
+		record.Owner = *owner
+	}
+	return nil
+}
+
+// toAuthRecord converts a row of the records table to a record, without the
+// usage and owner columns that scanExtra reads.
+func toAuthRecord(dbRecord *Record) (*auth.Record, error) {
+	labels, err := decodeLabels(dbRecord.Labels)
+	if err != nil {
+		return nil, err
+	}
+	return &auth.Record{
+		SatelliteAddress:     dbRecord.SatelliteAddress,
+		MacaroonHead:         dbRecord.MacaroonHead,
+		EncryptedSecretKey:   dbRecord.EncryptedSecretKey,
+		EncryptedAccessGrant: dbRecord.EncryptedAccessGrant,
+		Public:               dbRecord.Public,
+		ExpiresAt:            dbRecord.ExpiresAt,
+		Labels:               labels,
+	}, nil
+}
+
+// encodeLabels encodes labels as a json object for the labels column, which is
+// null if there are no labels.
+func encodeLabels(labels map[string]string) (*string, error) {
+	if len(labels) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(labels)
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+	encoded := string(data)
+	return &encoded, nil
+}
+
+// decodeLabels reverses encodeLabels.
+func decodeLabels(encoded *string) (labels map[string]string, err error) {
+	if encoded == nil {
+		return nil, nil
+	}
+	return labels, errs.Wrap(json.Unmarshal([]byte(*encoded), &labels))
+}
+
+// utc converts an optional time to utc.
+func utc(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
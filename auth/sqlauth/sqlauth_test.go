@@ -0,0 +1,178 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sqlauth_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/stargate/auth"
+	"storj.io/stargate/auth/kvtest"
+	"storj.io/stargate/auth/sqlauth"
+)
+
+func TestKV_SQLite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlauth")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	var n int
+	kvtest.RunTests(t, func() auth.KV {
+		n++
+		// an in memory sqlite database is per connection, so use a file instead
+		source := filepath.Join(dir, fmt.Sprintf("kv%d.db", n)) + "?_busy_timeout=5000"
+		kv, err := sqlauth.OpenKV(context.Background(), "sqlite3", source)
+		require.NoError(t, err)
+		return kv
+	})
+}
+
+func TestMigrateToLatest_DeletedAt(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "sqlauth")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	// the records table as it was before records could be soft deleted
+	source := filepath.Join(dir, "old.db")
+	db, err := sqlauth.Open("sqlite3", source)
+	require.NoError(t, err)
+	_, err = db.ExecContext(ctx, `CREATE TABLE records (
+		encryption_key_hash BLOB NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		public INTEGER NOT NULL,
+		satellite_address TEXT NOT NULL,
+		macaroon_head BLOB NOT NULL,
+		expires_at TIMESTAMP,
+		encrypted_secret_key BLOB NOT NULL,
+		encrypted_access_grant BLOB NOT NULL,
+		invalid_reason TEXT,
+		invalid_at TIMESTAMP,
+		PRIMARY KEY ( encryption_key_hash )
+	)`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	kv, err := sqlauth.OpenKV(ctx, "sqlite3", source)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, kv.Close()) }()
+
+	// migrating is idempotent
+	require.NoError(t, kv.MigrateToLatest(ctx))
+
+	keyHash := auth.KeyHash{1}
+	require.NoError(t, kv.Put(ctx, keyHash, &auth.Record{
+		MacaroonHead:         []byte("head"),
+		EncryptedSecretKey:   []byte("secret"),
+		EncryptedAccessGrant: []byte("grant"),
+	}))
+	require.NoError(t, kv.SoftDelete(ctx, keyHash))
+
+	record, err := kv.Get(ctx, keyHash)
+	require.NoError(t, err)
+	require.Nil(t, record)
+}
+
+func TestHealthCheck_MissingTables(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "sqlauth")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	db, err := sqlauth.Open("sqlite3", filepath.Join(dir, "empty.db"))
+	require.NoError(t, err)
+	kv := sqlauth.New(db)
+	defer func() { require.NoError(t, kv.Close()) }()
+
+	require.Error(t, kv.HealthCheck(ctx))
+	require.NoError(t, kv.MigrateToLatest(ctx))
+	require.NoError(t, kv.HealthCheck(ctx))
+}
+
+func TestGetBatch_ManyKeys(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "sqlauth")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	kv, err := sqlauth.OpenKV(ctx, "sqlite3", filepath.Join(dir, "batch.db"))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, kv.Close()) }()
+
+	// more keys than a single query reads, with records at both ends
+	keyHashes := make([]auth.KeyHash, 2500)
+	for i := range keyHashes {
+		keyHashes[i] = auth.KeyHash{byte(i >> 8), byte(i)}
+	}
+	first, last := keyHashes[0], keyHashes[len(keyHashes)-1]
+	for _, keyHash := range []auth.KeyHash{first, last} {
+		require.NoError(t, kv.Put(ctx, keyHash, &auth.Record{
+			MacaroonHead:         []byte("head"),
+			EncryptedSecretKey:   []byte("secret"),
+			EncryptedAccessGrant: keyHash[:2],
+		}))
+	}
+
+	records, err := kv.GetBatch(ctx, keyHashes)
+	require.NoError(t, err)
+	require.Len(t, records, len(keyHashes))
+	for i, record := range records {
+		switch keyHashes[i] {
+		case first, last:
+			require.NotNil(t, record, i)
+			require.Equal(t, keyHashes[i][:2], record.EncryptedAccessGrant, i)
+		default:
+			require.Nil(t, record, i)
+		}
+	}
+}
+
+func TestUpdate_ConcurrentOwner(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "sqlauth")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	kv, err := sqlauth.OpenKV(ctx, "sqlite3", filepath.Join(dir, "owner.db"))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, kv.Close()) }()
+
+	keyHash := auth.KeyHash{1}
+	require.NoError(t, kv.Put(ctx, keyHash, &auth.Record{
+		MacaroonHead:         []byte("head"),
+		EncryptedSecretKey:   []byte("secret"),
+		EncryptedAccessGrant: []byte("grant"),
+		Owner:                "first",
+	}))
+
+	// the owner changes while the update is in flight, so it is retried with
+	// the new owner instead of overwriting it
+	var owners []string
+	updated, err := kv.Update(ctx, keyHash, func(entry *auth.Entry) (bool, error) {
+		owners = append(owners, entry.Record.Owner)
+		if len(owners) == 1 {
+			_, err := kv.Update(ctx, keyHash, func(entry *auth.Entry) (bool, error) {
+				entry.Record.Owner = "second"
+				return true, nil
+			})
+			require.NoError(t, err)
+		}
+		entry.Record.Public = true
+		return true, nil
+	})
+	require.NoError(t, err)
+	require.True(t, updated)
+	require.Equal(t, []string{"first", "second"}, owners)
+
+	record, err := kv.Get(ctx, keyHash)
+	require.NoError(t, err)
+	require.Equal(t, "second", record.Owner)
+	require.True(t, record.Public)
+}
@@ -0,0 +1,89 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package auth
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// SweeperConfig configures how often and how aggressively the Sweeper deletes records.
+type SweeperConfig struct {
+	Interval    time.Duration `help:"how often to delete invalid, expired and deleted records; 0 disables the sweeper" default:"0s"`
+	Retention   time.Duration `help:"how long invalid and expired records are kept before they are deleted" default:"720h0m0s"`
+	GracePeriod time.Duration `help:"how long deleted records can be restored before they are purged" default:"168h0m0s"`
+}
+
+// Sweeper periodically deletes records from a KV that have been invalid or
+// expired for longer than the retention window, and purges records that have
+// been soft deleted for longer than the grace period.
+type Sweeper struct {
+	log    *zap.Logger
+	kv     KV
+	config SweeperConfig
+}
+
+// NewSweeper constructs a Sweeper.
+func NewSweeper(log *zap.Logger, kv KV, config SweeperConfig) *Sweeper {
+	return &Sweeper{
+		log:    log,
+		kv:     kv,
+		config: config,
+	}
+}
+
+// Run sweeps every interval until the context is canceled. Failed sweeps are
+// logged and retried at the next interval. Run stops right away if the KV
+// can't delete unused records.
+func (s *Sweeper) Run(ctx context.Context) error {
+	if s.config.Interval <= 0 {
+		return nil
+	}
+
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		deleted, err := s.Sweep(ctx)
+		if Unsupported.Has(err) {
+			s.log.Warn("the key/value store can't delete records; stopping the sweeper", zap.Error(err))
+			return nil
+		}
+		if err != nil {
+			s.log.Error("unable to delete unused records", zap.Error(err))
+		} else if deleted > 0 {
+			s.log.Info("deleted unused records", zap.Int64("count", deleted))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep deletes the records that have been invalid or expired for longer than
+// the retention window and the records that have been soft deleted for longer
+// than the grace period once, and returns how many were deleted.
+func (s *Sweeper) Sweep(ctx context.Context) (deleted int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	now := time.Now()
+	deleted, err = DeleteUnused(ctx, s.kv, now.Add(-s.config.Retention))
+	mon.IntVal("sweeper_deleted").Observe(deleted)
+	if err != nil {
+		return deleted, err
+	}
+
+	purged, err := PurgeDeleted(ctx, s.kv, now.Add(-s.config.GracePeriod))
+	if Unsupported.Has(err) {
+		// key/value stores without soft deletes have nothing to purge
+		return deleted, nil
+	}
+	mon.IntVal("sweeper_purged").Observe(purged)
+	return deleted + purged, err
+}
@@ -0,0 +1,67 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package auth_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"storj.io/stargate/auth"
+	"storj.io/stargate/auth/memauth"
+)
+
+func TestSweeper(t *testing.T) {
+	ctx := context.Background()
+	kv := memauth.New()
+
+	recent, old := time.Now().Add(-time.Minute), time.Now().Add(-2*time.Hour)
+	require.NoError(t, kv.Put(ctx, auth.KeyHash{1}, &auth.Record{ExpiresAt: &recent}))
+	require.NoError(t, kv.Put(ctx, auth.KeyHash{2}, &auth.Record{ExpiresAt: &old}))
+	require.NoError(t, kv.Put(ctx, auth.KeyHash{3}, &auth.Record{}))
+
+	sweeper := auth.NewSweeper(zaptest.NewLogger(t), kv, auth.SweeperConfig{
+		Interval:    time.Hour,
+		Retention:   time.Hour,
+		GracePeriod: time.Hour,
+	})
+
+	// only the record that expired longer than the retention window ago is deleted
+	deleted, err := sweeper.Sweep(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, deleted)
+
+	records, err := kv.GetBatch(ctx, []auth.KeyHash{{1}, {2}, {3}})
+	require.NoError(t, err)
+	require.Nil(t, records[0])
+	require.Nil(t, records[1])
+	require.NotNil(t, records[2])
+
+	deleted, err = sweeper.Sweep(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, 0, deleted)
+
+	// soft deleted records are kept for the grace period
+	require.NoError(t, kv.SoftDelete(ctx, auth.KeyHash{3}))
+	deleted, err = sweeper.Sweep(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, 0, deleted)
+
+	sweeper = auth.NewSweeper(zaptest.NewLogger(t), kv, auth.SweeperConfig{
+		Interval:    time.Hour,
+		Retention:   time.Hour,
+		GracePeriod: -time.Minute,
+	})
+	deleted, err = sweeper.Sweep(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, deleted)
+
+	// run stops when the context is canceled
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	require.Equal(t, context.Canceled, sweeper.Run(ctx))
+}
@@ -0,0 +1,144 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// flushTimeout is how long the last flush of a UsageTracker may take after its
+// context is canceled.
+const flushTimeout = 10 * time.Second
+
+// UsageConfig configures how the uses of access keys are tracked.
+type UsageConfig struct {
+	Interval time.Duration `help:"how often the uses of access keys are written to the database, which limits the writes to one per access key and interval; 0 disables the tracking of uses" default:"1m0s"`
+}
+
+// pendingUse is the use of a key that hasn't been written yet.
+type pendingUse struct {
+	at   time.Time
+	uses int64
+}
+
+// UsageTracker counts the uses of keys in memory, and periodically writes them
+// to a KV, so that resolving a key doesn't write to the database every time.
+// Uses that can't be written are dropped, since they are only statistics. A nil
+// UsageTracker tracks nothing, and neither does one whose KV doesn't count
+// uses once it found out.
+type UsageTracker struct {
+	log    *zap.Logger
+	kv     KV
+	config UsageConfig
+
+	mu          sync.Mutex
+	pending     map[KeyHash]pendingUse
+	unsupported bool
+}
+
+// NewUsageTracker constructs a UsageTracker.
+func NewUsageTracker(log *zap.Logger, kv KV, config UsageConfig) *UsageTracker {
+	return &UsageTracker{
+		log:     log,
+		kv:      kv,
+		config:  config,
+		pending: make(map[KeyHash]pendingUse),
+	}
+}
+
+// Use counts a use of the key now.
+func (tracker *UsageTracker) Use(keyHash KeyHash) {
+	if tracker == nil || tracker.config.Interval <= 0 {
+		return
+	}
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	if tracker.unsupported {
+		return
+	}
+	use := tracker.pending[keyHash]
+	use.at = time.Now()
+	use.uses++
+	tracker.pending[keyHash] = use
+}
+
+// Pending returns the uses of the key that haven't been written yet, and when
+// the last of them was.
+func (tracker *UsageTracker) Pending(keyHash KeyHash) (lastUsedAt time.Time, uses int64) {
+	if tracker == nil {
+		return time.Time{}, 0
+	}
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	use := tracker.pending[keyHash]
+	return use.at, use.uses
+}
+
+// Run writes the uses every interval until the context is canceled, and then
+// writes the remaining uses once more.
+func (tracker *UsageTracker) Run(ctx context.Context) error {
+	if tracker.config.Interval <= 0 {
+		return nil
+	}
+
+	ticker := time.NewTicker(tracker.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+			defer cancel()
+			tracker.Flush(flushCtx)
+			return ctx.Err()
+		case <-ticker.C:
+			tracker.Flush(ctx)
+		}
+	}
+}
+
+// Flush writes the uses that haven't been written yet, and returns how many
+// keys were written. Failures are logged and their uses are dropped.
+func (tracker *UsageTracker) Flush(ctx context.Context) (flushed int) {
+	defer mon.Task()(&ctx)(nil)
+
+	tracker.mu.Lock()
+	pending := tracker.pending
+	tracker.pending = make(map[KeyHash]pendingUse)
+	tracker.mu.Unlock()
+
+	var failed int
+	var lastErr error
+	for keyHash, use := range pending {
+		err := RecordUse(ctx, tracker.kv, keyHash, use.at, use.uses)
+		if Unsupported.Has(err) {
+			tracker.log.Warn("the key/value store doesn't count uses; stopping the usage tracker", zap.Error(err))
+			tracker.mu.Lock()
+			tracker.unsupported = true
+			tracker.pending = make(map[KeyHash]pendingUse)
+			tracker.mu.Unlock()
+			return 0
+		}
+		if err != nil {
+			failed++
+			lastErr = err
+			continue
+		}
+		flushed++
+	}
+	mon.IntVal("usage_flushed").Observe(int64(flushed))
+	if failed > 0 {
+		mon.Event("usage_flush_failed")
+		tracker.log.Warn("unable to record the uses of keys", zap.Int("failed", failed), zap.Error(lastErr))
+	}
+	return flushed
+}
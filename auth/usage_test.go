@@ -0,0 +1,64 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package auth_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"storj.io/stargate/auth"
+	"storj.io/stargate/auth/memauth"
+)
+
+func TestUsageTracker(t *testing.T) {
+	ctx := context.Background()
+	kv := memauth.New()
+	require.NoError(t, kv.Put(ctx, auth.KeyHash{1}, &auth.Record{}))
+
+	tracker := auth.NewUsageTracker(zaptest.NewLogger(t), kv, auth.UsageConfig{Interval: time.Hour})
+
+	// uses are counted in memory until they are flushed
+	before := time.Now()
+	for i := 0; i < 3; i++ {
+		tracker.Use(auth.KeyHash{1})
+	}
+	tracker.Use(auth.KeyHash{2})
+	at, uses := tracker.Pending(auth.KeyHash{1})
+	require.EqualValues(t, 3, uses)
+	require.False(t, at.Before(before))
+
+	record, err := kv.Get(ctx, auth.KeyHash{1})
+	require.NoError(t, err)
+	require.Nil(t, record.LastUsedAt)
+	require.Zero(t, record.UseCount)
+
+	// the uses of keys that don't exist are written too, and ignored
+	require.Equal(t, 2, tracker.Flush(ctx))
+	_, uses = tracker.Pending(auth.KeyHash{1})
+	require.Zero(t, uses)
+
+	record, err = kv.Get(ctx, auth.KeyHash{1})
+	require.NoError(t, err)
+	require.NotNil(t, record.LastUsedAt)
+	require.Equal(t, at, *record.LastUsedAt)
+	require.EqualValues(t, 3, record.UseCount)
+
+	require.Equal(t, 0, tracker.Flush(ctx))
+
+	// disabled and nil trackers count nothing
+	disabled := auth.NewUsageTracker(zaptest.NewLogger(t), kv, auth.UsageConfig{})
+	disabled.Use(auth.KeyHash{1})
+	_, uses = disabled.Pending(auth.KeyHash{1})
+	require.Zero(t, uses)
+	require.NoError(t, disabled.Run(ctx))
+
+	var none *auth.UsageTracker
+	none.Use(auth.KeyHash{1})
+	_, uses = none.Pending(auth.KeyHash{1})
+	require.Zero(t, uses)
+}
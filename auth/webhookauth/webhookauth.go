@@ -0,0 +1,428 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package webhookauth sends the lifecycle events of the records of a KV to
+// webhooks, like to sync revocations into a SIEM or the caches of gateways.
+//
+// Events are taken from the history events that are appended to the KV, so
+// every creation, invalidation, deletion and restoration that is recorded in a
+// history is sent, together with invalidations by macaroon head, which aren't.
+// Events are delivered in the background so that requests never wait on
+// webhooks. Every webhook has its own bounded queue, so a webhook that is slow
+// or down only delays and drops its own events. Events are not kept across
+// restarts: events that are queued when the service stops, that don't fit in
+// the queue of a webhook, or that can't be delivered after the retries, are
+// lost. Records that the sweeper purges have no events.
+package webhookauth
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+
+	"storj.io/stargate/auth"
+	"storj.io/stargate/internal/tagged"
+)
+
+var mon = monkit.Package()
+
+// Error is the error class for this package.
+var Error = errs.Class("webhook")
+
+const (
+	// TimestampHeader has the unix time at which an event was signed.
+	TimestampHeader = "X-Stargate-Timestamp"
+
+	// SignatureHeader has the signature of an event, which is sha256= and the
+	// hex encoded HMAC-SHA256 with the secret of the timestamp, a dot and the
+	// body, so that receivers can reject old events that are sent again.
+	SignatureHeader = "X-Stargate-Signature"
+)
+
+// Config configures the webhooks.
+type Config struct {
+	URLs      string        `help:"comma separated urls that receive signed json events when accesses are created, invalidated, deleted or restored; disabled when empty" default:""`
+	Secret    string        `help:"secret that the events are signed with" default:""`
+	QueueSize int           `help:"how many events may wait to be delivered to each webhook before its new events are dropped" default:"10000"`
+	Timeout   time.Duration `help:"how long a webhook may take to respond to an event" default:"10s"`
+	Attempts  int           `help:"how many times an event is sent to a webhook that fails before it is dropped" default:"3"`
+}
+
+// Event is a lifecycle event of a record.
+type Event struct {
+	// ID is unique per event, so that receivers can ignore events that are
+	// delivered twice.
+	ID string `json:"id"`
+	// Action is an action of the history events, like invalidated.
+	Action string    `json:"action"`
+	At     time.Time `json:"at"`
+	// KeyHash is the hex encoded key hash of the record, like in the list of
+	// records, which is empty for invalidations by macaroon head.
+	KeyHash string `json:"key_hash,omitempty"`
+	// MacaroonHead is the hex encoded macaroon head of an invalidation of
+	// every record of an api key, and Count is how many were invalidated.
+	MacaroonHead string `json:"macaroon_head,omitempty"`
+	Count        int64  `json:"count,omitempty"`
+	Actor        string `json:"actor,omitempty"`
+	Reason       string `json:"reason,omitempty"`
+	SourceIP     string `json:"source_ip,omitempty"`
+}
+
+// KV is a key/value store that sends the lifecycle events of the records of
+// another KV to webhooks once Run is called.
+type KV struct {
+	kv      auth.KV
+	log     *zap.Logger
+	targets []*webhook
+	secret  []byte
+	config  Config
+	client  *http.Client
+}
+
+// webhook is the url of a webhook and the queue of the events that wait to
+// be delivered to it.
+type webhook struct {
+	url   string
+	queue chan Event
+}
+
+// New wraps kv so that its lifecycle events are sent to the webhooks of
+// config.
+func New(log *zap.Logger, kv auth.KV, config Config) (*KV, error) {
+	var targets []*webhook
+	for _, entry := range strings.Split(config.URLs, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		parsed, err := url.Parse(entry)
+		if err != nil {
+			return nil, Error.Wrap(err)
+		}
+		if parsed.Scheme != "http" && parsed.Scheme != "https" {
+			return nil, Error.New("webhook url %q must be http or https", entry)
+		}
+		targets = append(targets, &webhook{
+			url:   entry,
+			queue: make(chan Event, config.QueueSize),
+		})
+	}
+	if len(targets) == 0 {
+		return nil, Error.New("no webhook urls")
+	}
+	if config.Secret == "" {
+		return nil, Error.New("webhooks need a secret to sign events with")
+	}
+	if config.Attempts <= 0 {
+		config.Attempts = 1
+	}
+
+	return &KV{
+		kv:      kv,
+		log:     log,
+		targets: targets,
+		secret:  []byte(config.Secret),
+		config:  config,
+		client:  &http.Client{Timeout: config.Timeout},
+	}, nil
+}
+
+// Run delivers queued events to every webhook until ctx is canceled, and
+// returns once all of the deliveries stopped.
+func (d *KV) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for _, target := range d.targets {
+		wg.Add(1)
+		go func(target *webhook) {
+			defer wg.Done()
+			d.run(ctx, target)
+		}(target)
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// run delivers the queued events of target until ctx is canceled.
+func (d *KV) run(ctx context.Context, target *webhook) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-target.queue:
+			if err := d.deliver(ctx, target.url, event); err != nil {
+				tagged.Counter(mon, "webhook_dropped", monkit.NewSeriesTag("url", target.url)).Inc(1)
+				d.log.Error("unable to deliver event", zap.String("url", target.url), zap.String("id", event.ID), zap.Error(err))
+			}
+		}
+	}
+}
+
+// notify queues the event for delivery to every webhook, dropping it for the
+// webhooks whose queues are full.
+func (d *KV) notify(event Event) {
+	var id [16]byte
+	_, _ = rand.Read(id[:])
+	event.ID = hex.EncodeToString(id[:])
+	if event.At.IsZero() {
+		event.At = time.Now().UTC()
+	}
+
+	for _, target := range d.targets {
+		select {
+		case target.queue <- event:
+		default:
+			tagged.Counter(mon, "webhook_queue_full", monkit.NewSeriesTag("url", target.url)).Inc(1)
+			d.log.Warn("dropping event, the queue is full", zap.String("url", target.url), zap.String("action", event.Action))
+		}
+	}
+}
+
+// deliver sends the event to target, and retries with a backoff while it
+// fails.
+func (d *KV) deliver(ctx context.Context, target string, event Event) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err = d.send(ctx, target, body)
+		if err == nil || attempt >= d.config.Attempts {
+			return err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return Error.Wrap(ctx.Err())
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// send POSTs a signed event.
+func (d *KV) send(ctx context.Context, target string, body []byte) (err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(d.secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	defer func() { err = errs.Combine(err, Error.Wrap(resp.Body.Close())) }()
+
+	if resp.StatusCode/100 != 2 {
+		return Error.New("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// Sign returns the signature of the body of an event that was signed at the
+// timestamp, which receivers compare to the signature header with
+// hmac.Equal.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write([]byte(timestamp + "."))
+	_, _ = mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Put stores the record in the wrapped key/value store.
+// It is an error if the key already exists.
+func (d *KV) Put(ctx context.Context, keyHash auth.KeyHash, record *auth.Record) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return d.kv.Put(ctx, keyHash, record)
+}
+
+// PutBatch stores the records of the entries in the wrapped key/value store.
+func (d *KV) PutBatch(ctx context.Context, entries []auth.Entry) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.PutBatch(ctx, d.kv, entries)
+}
+
+// Get retrieves the record from the wrapped key/value store.
+// It returns nil if the key does not exist.
+func (d *KV) Get(ctx context.Context, keyHash auth.KeyHash) (record *auth.Record, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return d.kv.Get(ctx, keyHash)
+}
+
+// GetBatch retrieves the records for all of the keys from the wrapped
+// key/value store.
+func (d *KV) GetBatch(ctx context.Context, keyHashes []auth.KeyHash) (records []*auth.Record, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.GetBatch(ctx, d.kv, keyHashes)
+}
+
+// Delete removes the record from the wrapped key/value store.
+// It is not an error if the key does not exist.
+func (d *KV) Delete(ctx context.Context, keyHash auth.KeyHash) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return d.kv.Delete(ctx, keyHash)
+}
+
+// SoftDelete marks the record as deleted in the wrapped key/value store.
+// It is not an error if the key does not exist.
+func (d *KV) SoftDelete(ctx context.Context, keyHash auth.KeyHash) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.SoftDelete(ctx, d.kv, keyHash)
+}
+
+// Restore undoes SoftDelete, and returns whether the record was deleted.
+// It is not an error if the key does not exist.
+func (d *KV) Restore(ctx context.Context, keyHash auth.KeyHash) (restored bool, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.Restore(ctx, d.kv, keyHash)
+}
+
+// PurgeDeleted removes the records that were soft deleted before asOf from
+// the wrapped key/value store, and returns how many were removed.
+func (d *KV) PurgeDeleted(ctx context.Context, asOf time.Time) (purged int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.PurgeDeleted(ctx, d.kv, asOf)
+}
+
+// Invalidate causes the record to become invalid.
+// It is not an error if the key does not exist.
+func (d *KV) Invalidate(ctx context.Context, keyHash auth.KeyHash, reason string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return d.kv.Invalidate(ctx, keyHash, reason)
+}
+
+// Update atomically changes the entry of the key in the wrapped key/value
+// store with fn, and returns whether the entry was stored.
+// It is not an error if the key does not exist.
+func (d *KV) Update(ctx context.Context, keyHash auth.KeyHash, fn func(entry *auth.Entry) (store bool, err error)) (updated bool, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.Update(ctx, d.kv, keyHash, fn)
+}
+
+// InvalidateByMacaroonHead causes every record with the macaroon head to
+// become invalid, and returns how many were invalidated. An event is sent if
+// any were.
+func (d *KV) InvalidateByMacaroonHead(ctx context.Context, macaroonHead []byte, reason string) (invalidated int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	invalidated, err = auth.InvalidateByMacaroonHead(ctx, d.kv, macaroonHead, reason)
+	if err == nil && invalidated > 0 {
+		d.notify(Event{
+			Action:       auth.HistoryInvalidated,
+			MacaroonHead: hex.EncodeToString(macaroonHead),
+			Count:        invalidated,
+			Reason:       reason,
+		})
+	}
+	return invalidated, err
+}
+
+// DeleteUnused removes the records that were invalidated or that expired
+// before asOf from the wrapped key/value store.
+func (d *KV) DeleteUnused(ctx context.Context, asOf time.Time) (deleted int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.DeleteUnused(ctx, d.kv, asOf)
+}
+
+// RecordUse adds uses to the use count of the key in the wrapped key/value
+// store. Uses are not sent.
+func (d *KV) RecordUse(ctx context.Context, keyHash auth.KeyHash, at time.Time, uses int64) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.RecordUse(ctx, d.kv, keyHash, at, uses)
+}
+
+// CountActive returns how many records of the owner are active at now in the
+// wrapped key/value store.
+func (d *KV) CountActive(ctx context.Context, owner string, now time.Time) (count int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.CountActive(ctx, d.kv, owner, now)
+}
+
+// AppendHistory adds the event to the history of the key, and sends it once
+// it is stored. If the wrapped key/value store doesn't keep histories, the
+// event is sent anyway and the Unsupported error is returned.
+func (d *KV) AppendHistory(ctx context.Context, keyHash auth.KeyHash, event auth.HistoryEvent) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	err = auth.AppendHistory(ctx, d.kv, keyHash, event)
+	if err != nil && !auth.Unsupported.Has(err) {
+		return err
+	}
+	d.notify(Event{
+		Action:   event.Action,
+		At:       event.At,
+		KeyHash:  hex.EncodeToString(keyHash[:]),
+		Actor:    event.Actor,
+		Reason:   event.Reason,
+		SourceIP: event.SourceIP,
+	})
+	return err
+}
+
+// History returns the events of the key in the order they were appended.
+func (d *KV) History(ctx context.Context, keyHash auth.KeyHash) (events []auth.HistoryEvent, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.History(ctx, d.kv, keyHash)
+}
+
+// Iterate calls fn for every record in the wrapped key/value store.
+func (d *KV) Iterate(ctx context.Context, fn func(ctx context.Context, entry auth.Entry) error) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.Iterate(ctx, d.kv, fn)
+}
+
+// List returns a page of the records in the wrapped key/value store.
+func (d *KV) List(ctx context.Context, after auth.KeyHash, limit int) (entries []auth.Entry, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.List(ctx, d.kv, after, limit)
+}
+
+// HealthCheck returns an error if the wrapped key/value store can't be used.
+func (d *KV) HealthCheck(ctx context.Context) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return auth.HealthCheck(ctx, d.kv)
+}
+
+// Close closes the wrapped key/value store.
+func (d *KV) Close() error {
+	return auth.Close(d.kv)
+}
@@ -0,0 +1,188 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package webhookauth_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"storj.io/stargate/auth"
+	"storj.io/stargate/auth/memauth"
+	"storj.io/stargate/auth/webhookauth"
+)
+
+func TestNew(t *testing.T) {
+	for _, config := range []webhookauth.Config{
+		{URLs: "", Secret: "secret"},
+		{URLs: "ftp://example.com", Secret: "secret"},
+		{URLs: "https://example.com", Secret: ""},
+	} {
+		_, err := webhookauth.New(zap.NewNop(), memauth.New(), config)
+		require.Error(t, err, "%+v", config)
+	}
+}
+
+func TestKV(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	events := make(chan webhookauth.Event, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(req.Body)
+		require.NoError(t, err)
+
+		expected := webhookauth.Sign([]byte("secret"), req.Header.Get(webhookauth.TimestampHeader), body)
+		if !hmac.Equal([]byte(expected), []byte(req.Header.Get(webhookauth.SignatureHeader))) {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		var event webhookauth.Event
+		require.NoError(t, json.Unmarshal(body, &event))
+		events <- event
+	}))
+	defer server.Close()
+
+	kv, err := webhookauth.New(zap.NewNop(), memauth.New(), webhookauth.Config{
+		URLs:      server.URL,
+		Secret:    "secret",
+		QueueSize: 10,
+		Timeout:   time.Second,
+		Attempts:  1,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, kv.Close()) }()
+
+	ctx, stop := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = kv.Run(ctx)
+	}()
+	defer func() { stop(); <-done }()
+
+	var keyHash auth.KeyHash
+	keyHash[0] = 1
+	require.NoError(t, kv.AppendHistory(ctx, keyHash, auth.HistoryEvent{
+		Action: auth.HistoryInvalidated,
+		Actor:  "operator",
+		Reason: "leaked",
+	}))
+
+	event := <-events
+	require.NotEmpty(t, event.ID)
+	require.False(t, event.At.IsZero())
+	require.Equal(t, auth.HistoryInvalidated, event.Action)
+	require.Equal(t, hex.EncodeToString(keyHash[:]), event.KeyHash)
+	require.Equal(t, "operator", event.Actor)
+	require.Equal(t, "leaked", event.Reason)
+
+	// invalidations by macaroon head that invalidate nothing have no events
+	invalidated, err := kv.InvalidateByMacaroonHead(ctx, []byte{1, 2, 3}, "revoked")
+	require.NoError(t, err)
+	require.Zero(t, invalidated)
+	require.NoError(t, kv.AppendHistory(ctx, keyHash, auth.HistoryEvent{Action: auth.HistoryDeleted}))
+	require.Equal(t, auth.HistoryDeleted, (<-events).Action)
+}
+
+func TestKV_SlowWebhook(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	unblock := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-unblock:
+		case <-req.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	defer close(unblock)
+
+	actions := make(chan string, 10)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var event webhookauth.Event
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&event))
+		actions <- event.Action
+	}))
+	defer fast.Close()
+
+	kv, err := webhookauth.New(zap.NewNop(), memauth.New(), webhookauth.Config{
+		URLs:      slow.URL + "," + fast.URL,
+		Secret:    "secret",
+		QueueSize: 1,
+		Timeout:   time.Minute,
+		Attempts:  1,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, kv.Close()) }()
+
+	ctx, stop := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = kv.Run(ctx)
+	}()
+	defer func() { stop(); <-done }()
+
+	// the slow webhook fills its queue, which doesn't hold up the other one
+	var keyHash auth.KeyHash
+	for _, action := range []string{auth.HistoryCreated, auth.HistoryInvalidated, auth.HistoryDeleted} {
+		require.NoError(t, kv.AppendHistory(ctx, keyHash, auth.HistoryEvent{Action: action}))
+		require.Equal(t, action, <-actions)
+	}
+}
+
+// coreKV only has the methods of auth.KV, and none of the optional
+// capabilities of the key/value store it embeds.
+type coreKV struct {
+	auth.KV
+}
+
+func TestKV_WithoutHistories(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	actions := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var event webhookauth.Event
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&event))
+		actions <- event.Action
+	}))
+	defer server.Close()
+
+	kv, err := webhookauth.New(zap.NewNop(), coreKV{memauth.New()}, webhookauth.Config{
+		URLs:      server.URL,
+		Secret:    "secret",
+		QueueSize: 10,
+		Timeout:   time.Second,
+		Attempts:  1,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, kv.Close()) }()
+
+	ctx, stop := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = kv.Run(ctx)
+	}()
+	defer func() { stop(); <-done }()
+
+	// events are sent even if the key/value store doesn't keep histories
+	var keyHash auth.KeyHash
+	err = kv.AppendHistory(ctx, keyHash, auth.HistoryEvent{Action: auth.HistoryCreated})
+	require.True(t, auth.Unsupported.Has(err), err)
+	require.Equal(t, auth.HistoryCreated, <-actions)
+}
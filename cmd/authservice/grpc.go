@@ -0,0 +1,62 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"storj.io/stargate/auth/rpcauth"
+)
+
+// serveGRPC serves the gRPC api at address until the context is canceled, and
+// then drains it like serve does. It uses TLS when tlsConfig is not nil.
+func serveGRPC(ctx context.Context, log *zap.Logger, address string, tlsConfig *tls.Config, server rpcauth.AuthServer, drainTimeout time.Duration) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return errs.Wrap(err)
+	}
+
+	var opts []grpc.ServerOption
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	grpcServer := grpc.NewServer(opts...)
+	rpcauth.RegisterServer(grpcServer, server)
+
+	log.Info("listening for incoming gRPC connections", zap.String("address", address), zap.Bool("tls", tlsConfig != nil))
+	errch := make(chan error, 1)
+	go func() { errch <- grpcServer.Serve(listener) }()
+
+	select {
+	case err := <-errch:
+		return errs.Wrap(err)
+	case <-ctx.Done():
+	}
+
+	log.Info("draining gRPC connections", zap.Duration("timeout", drainTimeout))
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+
+	timer := time.NewTimer(drainTimeout)
+	defer timer.Stop()
+	select {
+	case <-stopped:
+		log.Info("drained gRPC connections")
+	case <-timer.C:
+		log.Warn("closing gRPC connections that didn't drain in time")
+		grpcServer.Stop()
+	}
+	return nil
+}
@@ -4,17 +4,48 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/zeebo/errs"
 	"go.uber.org/zap"
 
 	"storj.io/common/fpath"
 	"storj.io/private/cfgstruct"
 	"storj.io/private/process"
 	"storj.io/stargate/auth"
+	"storj.io/stargate/auth/breakerauth"
+	"storj.io/stargate/auth/cacheauth"
+	"storj.io/stargate/auth/envelopeauth"
+	_ "storj.io/stargate/auth/envelopeauth/awskms"       // register the awskms:// key manager
+	_ "storj.io/stargate/auth/envelopeauth/gcpkms"       // register the gcpkms:// key manager
+	_ "storj.io/stargate/auth/envelopeauth/vaulttransit" // register the vault:// key manager
 	"storj.io/stargate/auth/httpauth"
-	"storj.io/stargate/auth/memauth"
+	_ "storj.io/stargate/auth/memauth" // register the memory:// KV
+	"storj.io/stargate/auth/metricsauth"
+	"storj.io/stargate/auth/replicaauth"
+	"storj.io/stargate/auth/retryauth"
+	"storj.io/stargate/auth/rpcauth"
+	_ "storj.io/stargate/auth/shardauth"   // register the shard:// KV
+	_ "storj.io/stargate/auth/spannerauth" // register the spanner:// KV
+	_ "storj.io/stargate/auth/sqlauth"     // register the postgres://, cockroach:// and sqlite:// KVs
+	"storj.io/stargate/auth/webhookauth"
+	"storj.io/stargate/internal/bruteforce"
+	"storj.io/stargate/internal/configcrypt"
+	"storj.io/stargate/internal/keychain"
+	"storj.io/stargate/internal/ratelimit"
+	"storj.io/stargate/internal/redact"
+	"storj.io/stargate/internal/tlspolicy"
+	"storj.io/stargate/internal/tracing"
+	"storj.io/stargate/internal/trustedproxy"
 )
 
 var (
@@ -36,8 +67,47 @@ var (
 // Config is the config.
 type Config struct {
 	Endpoint   string `help:"endpoint to return to clients" default:""`
-	AuthToken  string `help:"auth token to validate requests" default:""`
+	AuthToken  string `help:"auth token with the admin role to validate requests" default:""`
+	AuthTokens string `help:"comma separated auth tokens with their roles, which are register, read or admin, like ui-token=register,ops-token=admin" default:""`
+
+	AuthTokensFile     string        `help:"file with more auth tokens and their roles, one token=role per line; it is reloaded on SIGHUP and when it changes, so that tokens can be rotated without a restart" default:""`
+	AuthTokensInterval time.Duration `help:"how often the auth tokens file is checked for changes; only on SIGHUP when 0" default:"10s"`
+	AuthTokensOverlap  time.Duration `help:"how long the auth tokens that a reload of the auth tokens file replaces stay valid, so that clients can switch to the new tokens" default:"10m0s"`
+
 	ListenAddr string `help:"address to listen for incoming connections" releaseDefault:"" devDefault:"localhost:8000"`
+	GRPCAddr   string `help:"address to listen for incoming gRPC connections, which can register and resolve accesses over persistent connections; uses the tls settings of the http api; disabled when empty" default:""`
+	AdminAddr  string `help:"address to listen for the administrative routes, like deleting, invalidating, listing and metrics, which are then not served on listen-addr; it serves all routes, so admin tools only need it; uses the tls settings of the http api; disabled when empty" default:""`
+
+	TrustedProxies string `help:"comma separated networks in CIDR notation, or single ips, of the proxies whose X-Forwarded-For addresses are trusted as the ips of clients; the remote addresses of connections are used otherwise" default:""`
+
+	RequireClientCerts bool `help:"require client certificates that are verified with tls.client-ca-file for deleting, invalidating and importing accesses, in addition to the auth token" default:"false"`
+
+	DrainTimeout time.Duration `help:"how long in-flight requests are given to complete on shutdown before their connections are closed" default:"30s"`
+
+	DatabaseURL string `help:"url of the auth database; the scheme selects the backend (memory, postgres, cockroach, sqlite, spanner, shard); memory:///path/to/records.json keeps the records of the memory backend in a file" default:"memory://"`
+
+	MasterKey  string `help:"base64 encoded 32 byte key to encrypt records with before they are stored" default:""`
+	KeyManager string `help:"url of the key manager that encrypts the keys of records before they are stored, like vault://host:8200/transit/key, awskms://alias/name?region=us-east-1 or gcpkms://projects/p/locations/l/keyRings/r/cryptoKeys/k; takes precedence over master-key" default:""`
+
+	SecretKeyDerivationKey string `help:"base64 encoded key of at least 32 bytes that the secret keys of new accesses are derived from, so that the database only stores hashes of them and resolves leave them out; comma separated keys with ids, like 1:<key>,0:<previous key>, rotate it" default:""`
+	IdempotencySecret      string `help:"base64 encoded secret of at least 32 bytes that the access key ids of registrations with idempotency keys are derived with; every instance needs the same secret to recognize retries to the others, and a random one is used when empty" default:""`
+
+	Retry       retryauth.Config
+	Breaker     breakerauth.Config
+	Replication replicaauth.Config
+	Cache       cacheauth.Config
+	Webhooks    webhookauth.Config
+	Sweeper     auth.SweeperConfig
+	Usage       auth.UsageConfig
+	Quota       auth.QuotaConfig
+	TLS         tlspolicy.Config
+	BruteForce  bruteforce.Config
+	Tracing     tracing.Config
+
+	TokenRateLimit ratelimit.Config
+	IPRateLimit    ratelimit.Config
+
+	CORS httpauth.CORSConfig
 }
 
 func init() {
@@ -46,6 +116,8 @@ func init() {
 	defaults := cfgstruct.DefaultsFlag(rootCmd)
 
 	rootCmd.AddCommand(runCmd)
+	rootCmd.AddCommand(configcrypt.NewEncryptCommand())
+	rootCmd.AddCommand(keychain.NewCommand())
 	process.Bind(runCmd, &config, defaults, cfgstruct.ConfDir(confDir))
 }
 
@@ -54,12 +126,292 @@ func main() {
 }
 
 func cmdRun(cmd *cobra.Command, args []string) (err error) {
+	ctx, _ := process.Ctx(cmd)
+	defer redact.ReplaceGlobals()()
 	log := zap.L()
-	kv := memauth.New()
+
+	if err := keychain.ResolveFields(&config); err != nil {
+		return err
+	}
+	if err := configcrypt.DecryptFields(&config, configcrypt.EnvOrPrompt()); err != nil {
+		return err
+	}
+	if config.RequireClientCerts && (!config.TLS.Enabled() || config.TLS.ClientCAFile == "") {
+		return errs.New("require-client-certs needs TLS with tls.client-ca-file")
+	}
+
+	tokens, err := httpauth.ParseTokens(config.AuthTokens)
+	if err != nil {
+		return err
+	}
+	if config.AuthToken != "" {
+		tokens[config.AuthToken] = httpauth.RoleAdmin
+	}
+	static := tokens
+	tokens, err = loadTokens(static, config.AuthTokensFile)
+	if err != nil {
+		return err
+	}
+
+	stopSampler, err := tracing.Start(log.Named("tracing"), config.Tracing)
+	if err != nil {
+		return err
+	}
+	defer stopSampler()
+
+	proxies, err := trustedproxy.Parse(config.TrustedProxies)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var background sync.WaitGroup
+
+	kv, err := auth.OpenKV(ctx, config.DatabaseURL)
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	kv = metricsauth.New(kv, backendName(config.DatabaseURL))
+	// retries are made behind the breaker, so that blips don't open it
+	kv = breakerauth.New(retryauth.New(kv, config.Retry), config.Breaker)
+	if config.Replication.SecondaryURL != "" {
+		secondary, err := auth.OpenKV(ctx, config.Replication.SecondaryURL)
+		if err != nil {
+			return errs.Combine(err, auth.Close(kv))
+		}
+		secondary = metricsauth.New(secondary, backendName(config.Replication.SecondaryURL))
+		replicated, err := replicaauth.New(log.Named("replication"), kv, secondary, config.Replication)
+		if err != nil {
+			return errs.Combine(err, auth.Close(kv), auth.Close(secondary))
+		}
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			_ = replicated.Run(ctx)
+		}()
+		kv = replicated
+	}
+	if config.KeyManager != "" {
+		wrapper, err := envelopeauth.OpenKeyWrapper(ctx, config.KeyManager)
+		if err != nil {
+			return errs.Combine(err, auth.Close(kv))
+		}
+		kv = envelopeauth.New(kv, wrapper)
+	} else if config.MasterKey != "" {
+		wrapper, err := envelopeauth.ParseLocalKeyWrapper(config.MasterKey)
+		if err != nil {
+			return errs.Combine(err, auth.Close(kv))
+		}
+		kv = envelopeauth.New(kv, wrapper)
+	}
+	kv = cacheauth.New(kv, config.Cache)
+	if config.Webhooks.URLs != "" {
+		webhooks, err := webhookauth.New(log.Named("webhooks"), kv, config.Webhooks)
+		if err != nil {
+			return errs.Combine(err, auth.Close(kv))
+		}
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			_ = webhooks.Run(ctx)
+		}()
+		kv = webhooks
+	}
+	defer func() { err = errs.Combine(err, auth.Close(kv)) }()
+
+	// background work stops before the database is closed, also when the
+	// server fails instead of being shut down
+	defer background.Wait()
+	defer cancel()
+
+	// fail at startup instead of on the first request if the database or the
+	// key manager are misconfigured
+	if err := auth.HealthCheck(ctx, kv); err != nil {
+		return errs.New("auth database unhealthy: %v", err)
+	}
+
 	db := auth.NewDatabase(kv)
+	db.SetQuotas(config.Quota)
+	if config.SecretKeyDerivationKey != "" {
+		keys, err := auth.ParseDerivationKeys(config.SecretKeyDerivationKey)
+		if err != nil {
+			return err
+		}
+		db.SetSecretKeyDerivation(keys)
+	}
+
+	usage := auth.NewUsageTracker(log.Named("usage"), kv, config.Usage)
+	db.SetUsageTracker(usage)
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		_ = usage.Run(ctx)
+	}()
+
+	sweeper := auth.NewSweeper(log.Named("sweeper"), kv, config.Sweeper)
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		_ = sweeper.Run(ctx)
+	}()
+
+	limiter := bruteforce.New(config.BruteForce)
+	res := httpauth.New(db, config.Endpoint, tokens, limiter)
+	res.RequireClientCertificates(config.RequireClientCerts)
+	res.SetRateLimiters(ratelimit.New(config.TokenRateLimit), ratelimit.New(config.IPRateLimit))
+	res.SetLogger(log.Named("http"))
+	res.SetCORS(config.CORS)
+	res.SetTrustedProxies(proxies)
+	res.SeparateAdmin(config.AdminAddr != "")
+	if config.IdempotencySecret != "" {
+		secret, err := base64.StdEncoding.DecodeString(config.IdempotencySecret)
+		if err != nil {
+			return errs.New("idempotency secret is not valid base64: %v", err)
+		}
+		if err := res.SetIdempotencySecret(secret); err != nil {
+			return err
+		}
+	}
+	if config.AuthTokensFile != "" {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			reloadTokens(ctx, log.Named("tokens"), res, static, config.AuthTokensFile, config.AuthTokensInterval, config.AuthTokensOverlap)
+		}()
+	}
+
+	server := &http.Server{
+		Addr:    config.ListenAddr,
+		Handler: config.TLS.SecurityHeaders(res),
+	}
+
+	// the gRPC api is served next to the http api, with its tls config
+	serveRPC := func(tlsConfig *tls.Config) {
+		if config.GRPCAddr == "" {
+			return
+		}
+		rpc := rpcauth.NewServer(db, config.Endpoint, res.Role, limiter)
+		rpc.SetTrustedProxies(proxies)
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			if err := serveGRPC(ctx, log.Named("grpc"), config.GRPCAddr, tlsConfig, rpc, config.DrainTimeout); err != nil {
+				log.Error("gRPC listener failed", zap.Error(err))
+			}
+		}()
+	}
+
+	// the admin routes are served next to the http api too, with its tls
+	// config
+	serveAdmin := func(tlsConfig *tls.Config) {
+		if config.AdminAddr == "" {
+			return
+		}
+		admin := &http.Server{
+			Addr:      config.AdminAddr,
+			Handler:   config.TLS.SecurityHeaders(res.Admin()),
+			TLSConfig: tlsConfig,
+		}
+		listen := admin.ListenAndServe
+		if tlsConfig != nil {
+			listen = func() error { return admin.ListenAndServeTLS("", "") }
+		}
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			log.Info("listening for admin connections", zap.String("address", config.AdminAddr))
+			if err := serve(ctx, log, admin, config.DrainTimeout, listen); err != nil {
+				log.Error("admin listener failed", zap.Error(err))
+			}
+		}()
+	}
 
-	res := httpauth.New(db, config.Endpoint, config.AuthToken)
+	if !config.TLS.Enabled() {
+		serveRPC(nil)
+		serveAdmin(nil)
+		log.Info("listening for incoming connections", zap.String("address", config.ListenAddr))
+		return serve(ctx, log, server, config.DrainTimeout, server.ListenAndServe)
+	}
+
+	if config.TLS.AutocertEnabled() {
+		tlsConfig, manager, err := config.TLS.AutocertTLSConfig()
+		if err != nil {
+			return err
+		}
+		server.TLSConfig = tlsConfig
+
+		if address := config.TLS.Autocert.HTTPAddress; address != "" {
+			// other requests are redirected to https, and the listener is shut
+			// down with the others
+			challenges := &http.Server{Addr: address, Handler: manager.HTTPHandler(nil)}
+			background.Add(1)
+			go func() {
+				defer background.Done()
+				log.Info("answering ACME challenges", zap.String("address", address))
+				if err := serve(ctx, log, challenges, config.DrainTimeout, challenges.ListenAndServe); err != nil {
+					log.Error("ACME challenge listener failed", zap.Error(err))
+				}
+			}()
+		}
+	} else {
+		tlsConfig, stapler, err := config.TLS.TLSConfig(log.Named("tls"))
+		if err != nil {
+			return err
+		}
+		if stapler != nil {
+			background.Add(1)
+			go func() {
+				defer background.Done()
+				_ = stapler.Run(ctx)
+			}()
+		}
+		server.TLSConfig = tlsConfig
+	}
 
-	log.Info("listening for incoming connections", zap.String("address", config.ListenAddr))
-	return http.ListenAndServe(config.ListenAddr, res)
+	serveRPC(server.TLSConfig)
+	serveAdmin(server.TLSConfig)
+	log.Info("listening for incoming TLS connections", zap.String("address", config.ListenAddr))
+	return serve(ctx, log, server, config.DrainTimeout, func() error {
+		return server.ListenAndServeTLS("", "")
+	})
+}
+
+// serve runs the server with listen until the context is canceled, like on
+// SIGTERM, and then drains it: the listeners stop accepting connections, and
+// in-flight requests are given drainTimeout to complete before the remaining
+// connections are closed.
+func serve(ctx context.Context, log *zap.Logger, server *http.Server, drainTimeout time.Duration, listen func() error) error {
+	errch := make(chan error, 1)
+	go func() { errch <- listen() }()
+
+	select {
+	case err := <-errch:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Info("draining connections", zap.Duration("timeout", drainTimeout))
+	drainCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	if err := server.Shutdown(drainCtx); err != nil {
+		log.Warn("closing connections that didn't drain in time", zap.Error(err))
+		return errs.Wrap(server.Close())
+	}
+
+	if err := <-errch; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	log.Info("drained connections")
+	return nil
+}
+
+// backendName returns the name of the backend of a database url for metrics,
+// which is its scheme.
+func backendName(databaseURL string) string {
+	parsed, err := url.Parse(databaseURL)
+	if err != nil {
+		return "unknown"
+	}
+	return strings.ToLower(parsed.Scheme)
 }
@@ -0,0 +1,77 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+
+	"storj.io/stargate/auth/httpauth"
+)
+
+// loadTokens returns the tokens of the config with the tokens of the file at
+// path, if there is one.
+func loadTokens(static httpauth.Tokens, path string) (httpauth.Tokens, error) {
+	tokens := make(httpauth.Tokens, len(static))
+	for token, role := range static {
+		tokens[token] = role
+	}
+	if path == "" {
+		return tokens, nil
+	}
+
+	fromFile, err := httpauth.ReadTokensFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for token, role := range fromFile {
+		tokens[token] = role
+	}
+	return tokens, nil
+}
+
+// reloadTokens keeps the auth tokens of res up to date with the tokens file at
+// path until ctx is canceled. The file is reloaded on SIGHUP and when it
+// changes, which is checked every interval, and the replaced tokens stay
+// valid for overlap. The tokens of the config are always kept.
+func reloadTokens(ctx context.Context, log *zap.Logger, res *httpauth.Resources, static httpauth.Tokens, path string, interval, overlap time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var check <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		check = ticker.C
+	}
+
+	last, _ := os.Stat(path)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		case <-check:
+			info, err := os.Stat(path)
+			if err != nil || (last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size()) {
+				continue
+			}
+		}
+
+		last, _ = os.Stat(path)
+		tokens, err := loadTokens(static, path)
+		if err != nil {
+			log.Error("keeping the auth tokens, since the auth tokens file can't be loaded", zap.Error(err))
+			continue
+		}
+		res.SetTokens(tokens, overlap)
+		log.Info("reloaded auth tokens", zap.Int("tokens", len(tokens)), zap.Duration("overlap", overlap))
+	}
+}
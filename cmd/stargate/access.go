@@ -0,0 +1,152 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcutil/base58"
+	"github.com/spf13/cobra"
+	"github.com/zeebo/errs"
+
+	"storj.io/common/macaroon"
+	"storj.io/common/pb"
+	"storj.io/private/process"
+	"storj.io/stargate/internal/tracing"
+)
+
+// AccessFlags configures the access commands.
+type AccessFlags struct {
+	AuthService string        `help:"url of the auth service to register access grants with" default:""`
+	Public      bool          `help:"whether the registered access can be used without its secret key, like for sharing" default:"false"`
+	Timeout     time.Duration `help:"how long to wait for the auth service to answer" default:"30s"`
+}
+
+var (
+	accessCmd = &cobra.Command{
+		Use:   "access",
+		Short: "Register and inspect access grants",
+	}
+	accessRegisterCmd = &cobra.Command{
+		Use:   "register [ACCESS_GRANT]",
+		Short: "Register an access grant with an auth service for S3 credentials",
+		Args:  cobra.ExactArgs(1),
+		RunE:  cmdAccessRegister,
+	}
+	accessInspectCmd = &cobra.Command{
+		Use:   "inspect [ACCESS_GRANT]",
+		Short: "Print the satellite and the API key of an access grant",
+		Args:  cobra.ExactArgs(1),
+		RunE:  cmdAccessInspect,
+	}
+
+	accessCfg AccessFlags
+)
+
+func cmdAccessRegister(cmd *cobra.Command, args []string) (err error) {
+	if err := checkOutputFormat(); err != nil {
+		return err
+	}
+	if accessCfg.AuthService == "" {
+		return Error.New("the url of the auth service is required")
+	}
+	ctx, _ := process.Ctx(cmd)
+
+	request := struct {
+		AccessGrant string `json:"access_grant"`
+		Public      bool   `json:"public"`
+	}{
+		AccessGrant: args[0],
+		Public:      accessCfg.Public,
+	}
+	var response struct {
+		AccessKeyID string `json:"access_key_id"`
+		SecretKey   string `json:"secret_key"`
+		Endpoint    string `json:"endpoint"`
+	}
+	err = callAuthService(ctx, accessCfg.Timeout, http.MethodPost, strings.TrimSuffix(accessCfg.AuthService, "/")+"/v1/access", "", request, &response)
+	if err != nil {
+		return err
+	}
+
+	return printResult(fmt.Sprintf("Access key: %s\nSecret key: %s\nEndpoint:   %s",
+		response.AccessKeyID, response.SecretKey, response.Endpoint), response)
+}
+
+func cmdAccessInspect(cmd *cobra.Command, args []string) (err error) {
+	if err := checkOutputFormat(); err != nil {
+		return err
+	}
+
+	data, version, err := base58.CheckDecode(args[0])
+	if err != nil || version != 0 {
+		return Error.New("invalid access grant format")
+	}
+	var scope pb.Scope
+	if err := pb.Unmarshal(data, &scope); err != nil {
+		return Error.New("invalid access grant: %v", err)
+	}
+	apiKey, err := macaroon.ParseRawAPIKey(scope.ApiKey)
+	if err != nil {
+		return Error.New("invalid api key: %v", err)
+	}
+
+	result := struct {
+		SatelliteAddress string `json:"satellite_address"`
+		APIKey           string `json:"api_key"`
+		MacaroonHead     string `json:"macaroon_head"`
+		TenantID         string `json:"tenant_id"`
+	}{
+		SatelliteAddress: scope.SatelliteAddr,
+		APIKey:           apiKey.Serialize(),
+		MacaroonHead:     hex.EncodeToString(apiKey.Head()),
+		TenantID:         tracing.TenantID(apiKey.Head()),
+	}
+	return printResult(fmt.Sprintf("Satellite:     %s\nAPI key:       %s\nMacaroon head: %s\nTenant ID:     %s",
+		result.SatelliteAddress, result.APIKey, result.MacaroonHead, result.TenantID), result)
+}
+
+// callAuthService sends request encoded as json to the url of an auth service
+// with the auth token, if there is one, and decodes the json response into
+// response.
+func callAuthService(ctx context.Context, timeout time.Duration, method, url, authToken string, request, response interface{}) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var body bytes.Buffer
+	if request != nil {
+		if err := json.NewEncoder(&body).Encode(request); err != nil {
+			return Error.Wrap(err)
+		}
+	}
+	req, err := http.NewRequest(method, url, &body)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+authToken)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	defer func() { err = errs.Combine(err, resp.Body.Close()) }()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return Error.New("the auth service answered %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return Error.Wrap(json.NewDecoder(resp.Body).Decode(response))
+}
@@ -0,0 +1,64 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/require"
+)
+
+const minimalAccess = "138CV9Drxrw8ir1XpxcZhk2wnHjhzVjuSZe6yDsNiMZDP8cow9V6sHDYdwgvYoQGgqVvoMnxdWDbpBiEPW5oP7DtPJ5sZx2MVxFrUoZYFfVAgxidW"
+
+func TestAccessRegisterOutput(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var request map[string]interface{}
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&request))
+		require.Equal(t, "/v1/access", req.URL.Path)
+		require.Equal(t, map[string]interface{}{"access_grant": minimalAccess, "public": true}, request)
+		_, _ = w.Write([]byte(`{"access_key_id": "accesskey", "secret_key": "secretkey", "endpoint": "https://gateway.example"}`))
+	}))
+	defer server.Close()
+
+	defer func(prev AccessFlags) { accessCfg = prev }(accessCfg)
+	accessCfg = AccessFlags{AuthService: server.URL + "/", Public: true, Timeout: time.Minute}
+	register := func() error { return cmdAccessRegister(&cobra.Command{}, []string{minimalAccess}) }
+
+	out, err := runWithOutput(t, outputText, register)
+	require.NoError(t, err)
+	require.Equal(t, "Access key: accesskey\nSecret key: secretkey\nEndpoint:   https://gateway.example\n", out)
+
+	out, err = runWithOutput(t, outputJSON, register)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"access_key_id": "accesskey", "secret_key": "secretkey", "endpoint": "https://gateway.example"}`, out)
+}
+
+func TestAccessInspectOutput(t *testing.T) {
+	inspect := func() error { return cmdAccessInspect(&cobra.Command{}, []string{minimalAccess}) }
+
+	out, err := runWithOutput(t, outputJSON, inspect)
+	require.NoError(t, err)
+	var result map[string]string
+	require.NoError(t, json.Unmarshal([]byte(out), &result))
+	require.Len(t, result, 4)
+	require.Equal(t, "s", result["satellite_address"])
+	require.NotEmpty(t, result["api_key"])
+	require.NotEmpty(t, result["macaroon_head"])
+	require.NotEmpty(t, result["tenant_id"])
+
+	out, err = runWithOutput(t, outputText, inspect)
+	require.NoError(t, err)
+	require.Equal(t, "Satellite:     s\n"+
+		"API key:       "+result["api_key"]+"\n"+
+		"Macaroon head: "+result["macaroon_head"]+"\n"+
+		"Tenant ID:     "+result["tenant_id"]+"\n", out)
+
+	_, err = runWithOutput(t, outputText, func() error { return cmdAccessInspect(&cobra.Command{}, []string{"invalid"}) })
+	require.Error(t, err)
+}
@@ -0,0 +1,449 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+
+	"storj.io/private/process"
+	"storj.io/stargate/auth"
+	"storj.io/stargate/auth/envelopeauth"
+	_ "storj.io/stargate/auth/envelopeauth/awskms"       // register the awskms:// key manager
+	_ "storj.io/stargate/auth/envelopeauth/gcpkms"       // register the gcpkms:// key manager
+	_ "storj.io/stargate/auth/envelopeauth/vaulttransit" // register the vault:// key manager
+	"storj.io/stargate/auth/export"
+	_ "storj.io/stargate/auth/memauth" // register the memory:// KV
+	"storj.io/stargate/auth/snapshot"
+	_ "storj.io/stargate/auth/spannerauth" // register the spanner:// KV
+	_ "storj.io/stargate/auth/sqlauth"     // register the postgres://, cockroach:// and sqlite:// KVs
+	"storj.io/stargate/internal/configcrypt"
+	"storj.io/stargate/internal/keychain"
+)
+
+// MigrateFlags configures the auth migrate command.
+type MigrateFlags struct {
+	From string `help:"url of the database to copy records from" default:""`
+	To   string `help:"url of the database to copy records to" default:""`
+
+	auth.MigrateConfig
+}
+
+// BackupFlags configures the auth backup command.
+type BackupFlags struct {
+	Database string `help:"url of the database to back up" default:""`
+	Output   string `help:"path of the snapshot file to write" default:""`
+}
+
+// RestoreFlags configures the auth restore command.
+type RestoreFlags struct {
+	Database string `help:"url of the database to restore records into" default:""`
+	Input    string `help:"path of the snapshot file to restore" default:""`
+
+	auth.MigrateConfig
+}
+
+// ExportFlags configures the auth export command.
+type ExportFlags struct {
+	Database string `help:"url of the database to export" default:""`
+	Output   string `help:"path of the file to write, or - for stdout" default:"-"`
+	Format   string `help:"format of the records: jsonl or csv" default:"jsonl"`
+}
+
+// FsckFlags configures the auth fsck command.
+type FsckFlags struct {
+	Database   string `help:"url of the database to check" default:""`
+	MasterKey  string `help:"base64 encoded master key that the auth service encrypts records with, if it does" default:""`
+	KeyManager string `help:"url of the key manager that the auth service encrypts the keys of records with, if it does; takes precedence over master-key" default:""`
+
+	auth.CheckConfig
+}
+
+// ImportFlags configures the auth import command.
+type ImportFlags struct {
+	Database string `help:"url of the database to import records into" default:""`
+	Input    string `help:"path of the file to import" default:""`
+	Format   string `help:"format of the records: jsonl or csv" default:"jsonl"`
+
+	auth.MigrateConfig
+}
+
+var (
+	authCmd = &cobra.Command{
+		Use:   "auth",
+		Short: "Manage the auth service database",
+		Args:  cobra.NoArgs,
+	}
+	authMigrateCmd = &cobra.Command{
+		Use:   "migrate",
+		Short: "Copy every record from one auth database to another",
+		Long: `Copies every record from the --from database to the --to database, and
+then verifies the copies. Records that already exist in the destination are
+skipped, so migrating again copies only the records that were stored since.
+To migrate without downtime, migrate once, point the auth service at the new
+database and migrate again.`,
+		Args: cobra.NoArgs,
+		RunE: cmdAuthMigrate,
+	}
+
+	authBackupCmd = &cobra.Command{
+		Use:   "backup",
+		Short: "Write an encrypted snapshot of every record in an auth database",
+		Long: `Writes every record of the --database database to the --output file,
+encrypted with a passphrase that is read from ` + snapshot.PassphraseEnv + ` or
+prompted for. Records are never changed except for becoming invalid, so the
+snapshot can be taken while the auth service is running.`,
+		Args: cobra.NoArgs,
+		RunE: cmdAuthBackup,
+	}
+	authRestoreCmd = &cobra.Command{
+		Use:   "restore",
+		Short: "Restore the records of a snapshot into an auth database",
+		Long: `Stores every record of the --input snapshot in the --database database,
+and then verifies them. Records that already exist in the database are skipped.`,
+		Args: cobra.NoArgs,
+		RunE: cmdAuthRestore,
+	}
+
+	authExportCmd = &cobra.Command{
+		Use:   "export",
+		Short: "Export every record of an auth database as json lines or csv",
+		Long: `Writes every record of the --database database, with its key hash, in the
+--format format. The secret key and access grant of records stay encrypted.`,
+		Args: cobra.NoArgs,
+		RunE: cmdAuthExport,
+	}
+	authImportCmd = &cobra.Command{
+		Use:   "import",
+		Short: "Import records from json lines or csv into an auth database",
+		Long: `Stores every record of the --input file, written by export or by another
+system in the same format, in the --database database, and then verifies them.
+Records that already exist in the database are skipped.`,
+		Args: cobra.NoArgs,
+		RunE: cmdAuthImport,
+	}
+
+	authFsckCmd = &cobra.Command{
+		Use:   "fsck",
+		Short: "Check the records of an auth database for damage",
+		Long: `Checks every record of the --database database for missing or malformed
+fields, envelopes that can't be decrypted, and satellites that are not in
+--satellites. Databases of an auth service that encrypts records need the same
+--master-key or --key-manager. With --repair, valid records with problems are
+invalidated, so that they fail instead of returning a broken access, and are
+eventually deleted by the sweeper.`,
+		Args: cobra.NoArgs,
+		RunE: cmdAuthFsck,
+	}
+
+	migrateCfg MigrateFlags
+	backupCfg  BackupFlags
+	restoreCfg RestoreFlags
+	exportCfg  ExportFlags
+	importCfg  ImportFlags
+	fsckCfg    FsckFlags
+)
+
+// prepareAuthCommand validates the output format and resolves the secrets in
+// config, like database urls with passwords.
+func prepareAuthCommand(config interface{}) error {
+	if err := checkOutputFormat(); err != nil {
+		return err
+	}
+	if err := keychain.ResolveFields(config); err != nil {
+		return err
+	}
+	return configcrypt.DecryptFields(config, configcrypt.EnvOrPrompt())
+}
+
+// printProgress prints the progress of a migration to stderr, so that stdout
+// only has the result.
+func printProgress(stats auth.MigrateStats) {
+	fmt.Fprintf(os.Stderr, "copied %d, skipped %d, invalidated %d, verified %d, mismatched %d\n",
+		stats.Copied, stats.Skipped, stats.Invalidated, stats.Verified, stats.Mismatched)
+}
+
+// snapshotPassphrase reads the snapshot passphrase from the environment or
+// prompts for it. With confirm, the passphrase has to be entered twice.
+func snapshotPassphrase(confirm bool) ([]byte, error) {
+	if passphrase, ok := os.LookupEnv(snapshot.PassphraseEnv); ok {
+		return []byte(passphrase), nil
+	}
+	return configcrypt.Prompt("Enter the snapshot passphrase: ", confirm)
+}
+
+func cmdAuthMigrate(cmd *cobra.Command, args []string) (err error) {
+	if err := prepareAuthCommand(&migrateCfg); err != nil {
+		return err
+	}
+	if migrateCfg.From == "" || migrateCfg.To == "" {
+		return Error.New("both --from and --to are required")
+	}
+	if migrateCfg.From == migrateCfg.To {
+		return Error.New("--from and --to must be different databases")
+	}
+
+	ctx, _ := process.Ctx(cmd)
+
+	from, err := auth.OpenKV(ctx, migrateCfg.From)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	defer func() { err = errs.Combine(err, auth.Close(from)) }()
+
+	to, err := auth.OpenKV(ctx, migrateCfg.To)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	defer func() { err = errs.Combine(err, auth.Close(to)) }()
+
+	migrator := auth.NewMigrator(zap.L(), auth.KVSource(from), to, migrateCfg.MigrateConfig)
+	migrator.Progress = printProgress
+
+	stats, err := migrator.Migrate(ctx)
+	if err != nil {
+		return err
+	}
+
+	return printResult(fmt.Sprintf("Migrated %d records (%d already existed).",
+		stats.Copied, stats.Skipped), stats)
+}
+
+func cmdAuthBackup(cmd *cobra.Command, args []string) (err error) {
+	if err := prepareAuthCommand(&backupCfg); err != nil {
+		return err
+	}
+	if backupCfg.Database == "" || backupCfg.Output == "" {
+		return Error.New("both --database and --output are required")
+	}
+
+	passphrase, err := snapshotPassphrase(true)
+	if err != nil {
+		return err
+	}
+
+	ctx, _ := process.Ctx(cmd)
+
+	kv, err := auth.OpenKV(ctx, backupCfg.Database)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	defer func() { err = errs.Combine(err, auth.Close(kv)) }()
+
+	// write to a temporary file first so that a failed backup never replaces a
+	// previous snapshot
+	output, err := filepath.Abs(backupCfg.Output)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(output), filepath.Base(output)+".*.tmp")
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	defer func() {
+		if err != nil {
+			err = errs.Combine(err, os.Remove(tmp.Name()))
+		}
+	}()
+
+	count, err := snapshot.Write(ctx, tmp, auth.KVSource(kv), passphrase)
+	if err != nil {
+		return errs.Combine(err, tmp.Close())
+	}
+	if err := tmp.Sync(); err != nil {
+		return errs.Combine(Error.Wrap(err), tmp.Close())
+	}
+	if err := tmp.Close(); err != nil {
+		return Error.Wrap(err)
+	}
+	if err := os.Rename(tmp.Name(), output); err != nil {
+		return Error.Wrap(err)
+	}
+
+	return printResult(fmt.Sprintf("Wrote %d records to %s.", count, output), struct {
+		Records int64  `json:"records"`
+		Output  string `json:"output"`
+		Version int    `json:"version"`
+	}{
+		Records: count,
+		Output:  output,
+		Version: snapshot.Version,
+	})
+}
+
+func cmdAuthRestore(cmd *cobra.Command, args []string) (err error) {
+	if err := prepareAuthCommand(&restoreCfg); err != nil {
+		return err
+	}
+	if restoreCfg.Database == "" || restoreCfg.Input == "" {
+		return Error.New("both --database and --input are required")
+	}
+
+	passphrase, err := snapshotPassphrase(false)
+	if err != nil {
+		return err
+	}
+
+	ctx, _ := process.Ctx(cmd)
+
+	kv, err := auth.OpenKV(ctx, restoreCfg.Database)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	defer func() { err = errs.Combine(err, auth.Close(kv)) }()
+
+	migrator := auth.NewMigrator(zap.L(), snapshot.Open(restoreCfg.Input, passphrase), kv, restoreCfg.MigrateConfig)
+	migrator.Progress = printProgress
+
+	stats, err := migrator.Migrate(ctx)
+	if err != nil {
+		return err
+	}
+
+	return printResult(fmt.Sprintf("Restored %d records (%d already existed).",
+		stats.Copied, stats.Skipped), stats)
+}
+
+func cmdAuthExport(cmd *cobra.Command, args []string) (err error) {
+	if err := prepareAuthCommand(&exportCfg); err != nil {
+		return err
+	}
+	if exportCfg.Database == "" {
+		return Error.New("--database is required")
+	}
+	format, err := export.ParseFormat(exportCfg.Format)
+	if err != nil {
+		return err
+	}
+
+	ctx, _ := process.Ctx(cmd)
+
+	kv, err := auth.OpenKV(ctx, exportCfg.Database)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	defer func() { err = errs.Combine(err, auth.Close(kv)) }()
+
+	output := cmd.OutOrStdout()
+	if exportCfg.Output != "-" {
+		file, err := os.OpenFile(exportCfg.Output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return Error.Wrap(err)
+		}
+		defer func() { err = errs.Combine(err, file.Close()) }()
+		output = file
+	}
+
+	count, err := export.Write(ctx, output, auth.KVSource(kv), format)
+	if err != nil {
+		return err
+	}
+	if exportCfg.Output == "-" {
+		// stdout has the records, so the summary goes to stderr
+		fmt.Fprintf(os.Stderr, "Exported %d records.\n", count)
+		return nil
+	}
+
+	return printResult(fmt.Sprintf("Exported %d records to %s.", count, exportCfg.Output), struct {
+		Records int64  `json:"records"`
+		Output  string `json:"output"`
+	}{
+		Records: count,
+		Output:  exportCfg.Output,
+	})
+}
+
+func cmdAuthImport(cmd *cobra.Command, args []string) (err error) {
+	if err := prepareAuthCommand(&importCfg); err != nil {
+		return err
+	}
+	if importCfg.Database == "" || importCfg.Input == "" {
+		return Error.New("both --database and --input are required")
+	}
+	format, err := export.ParseFormat(importCfg.Format)
+	if err != nil {
+		return err
+	}
+
+	ctx, _ := process.Ctx(cmd)
+
+	kv, err := auth.OpenKV(ctx, importCfg.Database)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	defer func() { err = errs.Combine(err, auth.Close(kv)) }()
+
+	migrator := auth.NewMigrator(zap.L(), export.Open(importCfg.Input, format), kv, importCfg.MigrateConfig)
+	migrator.Progress = printProgress
+
+	stats, err := migrator.Migrate(ctx)
+	if err != nil {
+		return err
+	}
+
+	return printResult(fmt.Sprintf("Imported %d records (%d already existed).",
+		stats.Copied, stats.Skipped), stats)
+}
+
+func cmdAuthFsck(cmd *cobra.Command, args []string) (err error) {
+	if err := prepareAuthCommand(&fsckCfg); err != nil {
+		return err
+	}
+	if fsckCfg.Database == "" {
+		return Error.New("--database is required")
+	}
+
+	ctx, _ := process.Ctx(cmd)
+
+	kv, err := auth.OpenKV(ctx, fsckCfg.Database)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	defer func() { err = errs.Combine(err, auth.Close(kv)) }()
+
+	var wrapper envelopeauth.KeyWrapper
+	switch {
+	case fsckCfg.KeyManager != "":
+		wrapper, err = envelopeauth.OpenKeyWrapper(ctx, fsckCfg.KeyManager)
+	case fsckCfg.MasterKey != "":
+		wrapper, err = envelopeauth.ParseLocalKeyWrapper(fsckCfg.MasterKey)
+	}
+	if err != nil {
+		return err
+	}
+
+	checker := auth.NewChecker(zap.L(), kv, fsckCfg.CheckConfig)
+	if wrapper != nil {
+		checker.Open = envelopeauth.New(kv, wrapper).Open
+	} else {
+		// without the master key every sealed record would look corrupt
+		checker.Open = func(ctx context.Context, keyHash auth.KeyHash, record *auth.Record) (*auth.Record, error) {
+			if envelopeauth.IsSealed(record) {
+				return nil, Error.New("records are encrypted; --master-key or --key-manager is required")
+			}
+			return record, nil
+		}
+	}
+	checker.Problem = func(problem auth.Problem) {
+		fmt.Fprintf(os.Stderr, "%x: %s: %s\n", problem.KeyHash[:], problem.Kind, problem.Detail)
+	}
+
+	stats, problems, err := checker.Check(ctx)
+	if err != nil {
+		return err
+	}
+
+	return printResult(fmt.Sprintf("Checked %d records: %d with problems, %d repaired.",
+		stats.Checked, stats.Problems, stats.Repaired), struct {
+		auth.CheckStats
+		Records []auth.Problem `json:"records"`
+	}{stats, problems})
+}
@@ -0,0 +1,149 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"storj.io/private/process"
+	"storj.io/stargate/internal/configcrypt"
+)
+
+// AuthAdminFlags configures the auth-admin commands.
+type AuthAdminFlags struct {
+	AuthService string        `help:"url of the auth service" default:""`
+	AuthToken   string        `help:"auth token of the auth service" default:""`
+	Timeout     time.Duration `help:"how long to wait for the auth service to answer" default:"30s"`
+}
+
+var (
+	authAdminCmd = &cobra.Command{
+		Use:   "auth-admin",
+		Short: "Manage the accesses of an auth service",
+	}
+	authAdminGetCmd = &cobra.Command{
+		Use:   "get [ACCESS_KEY_ID]",
+		Short: "Print the access grant and the secret key of an access key",
+		Args:  cobra.ExactArgs(1),
+		RunE:  cmdAuthAdminGet,
+	}
+	authAdminDeleteCmd = &cobra.Command{
+		Use:   "delete [ACCESS_KEY_ID]",
+		Short: "Delete an access key",
+		Args:  cobra.ExactArgs(1),
+		RunE:  cmdAuthAdminDelete,
+	}
+	authAdminInvalidateCmd = &cobra.Command{
+		Use:   "invalidate [ACCESS_KEY_ID] [REASON]",
+		Short: "Invalidate an access key",
+		Args:  cobra.ExactArgs(2),
+		RunE:  cmdAuthAdminInvalidate,
+	}
+
+	authAdminCfg AuthAdminFlags
+)
+
+// accessURL returns the url of the access of accessKeyID in the auth service.
+func (flags AuthAdminFlags) accessURL(accessKeyID string) (string, error) {
+	if flags.AuthService == "" {
+		return "", Error.New("the url of the auth service is required")
+	}
+	return strings.TrimSuffix(flags.AuthService, "/") + "/v1/access/" + url.PathEscape(accessKeyID), nil
+}
+
+func cmdAuthAdminGet(cmd *cobra.Command, args []string) (err error) {
+	if err := checkOutputFormat(); err != nil {
+		return err
+	}
+	if err := configcrypt.DecryptFields(&authAdminCfg, configcrypt.EnvOrPrompt()); err != nil {
+		return err
+	}
+	accessURL, err := authAdminCfg.accessURL(args[0])
+	if err != nil {
+		return err
+	}
+	ctx, _ := process.Ctx(cmd)
+
+	var response struct {
+		AccessGrant string `json:"access_grant"`
+		SecretKey   string `json:"secret_key"`
+		Public      bool   `json:"public"`
+	}
+	err = callAuthService(ctx, authAdminCfg.Timeout, http.MethodGet, accessURL, authAdminCfg.AuthToken, nil, &response)
+	if err != nil {
+		return err
+	}
+
+	return printResult(fmt.Sprintf("Access grant: %s\nSecret key:   %s\nPublic:       %t",
+		response.AccessGrant, response.SecretKey, response.Public), response)
+}
+
+func cmdAuthAdminDelete(cmd *cobra.Command, args []string) (err error) {
+	if err := checkOutputFormat(); err != nil {
+		return err
+	}
+	if err := configcrypt.DecryptFields(&authAdminCfg, configcrypt.EnvOrPrompt()); err != nil {
+		return err
+	}
+	accessURL, err := authAdminCfg.accessURL(args[0])
+	if err != nil {
+		return err
+	}
+	ctx, _ := process.Ctx(cmd)
+
+	var response struct{}
+	err = callAuthService(ctx, authAdminCfg.Timeout, http.MethodDelete, accessURL, authAdminCfg.AuthToken, nil, &response)
+	if err != nil {
+		return err
+	}
+
+	return printResult("Deleted "+args[0], struct {
+		AccessKeyID string `json:"access_key_id"`
+		Deleted     bool   `json:"deleted"`
+	}{
+		AccessKeyID: args[0],
+		Deleted:     true,
+	})
+}
+
+func cmdAuthAdminInvalidate(cmd *cobra.Command, args []string) (err error) {
+	if err := checkOutputFormat(); err != nil {
+		return err
+	}
+	if err := configcrypt.DecryptFields(&authAdminCfg, configcrypt.EnvOrPrompt()); err != nil {
+		return err
+	}
+	accessURL, err := authAdminCfg.accessURL(args[0])
+	if err != nil {
+		return err
+	}
+	ctx, _ := process.Ctx(cmd)
+
+	request := struct {
+		Reason string `json:"reason"`
+	}{
+		Reason: args[1],
+	}
+	var response struct{}
+	err = callAuthService(ctx, authAdminCfg.Timeout, http.MethodPut, accessURL+"/invalid", authAdminCfg.AuthToken, request, &response)
+	if err != nil {
+		return err
+	}
+
+	return printResult("Invalidated "+args[0], struct {
+		AccessKeyID string `json:"access_key_id"`
+		Invalidated bool   `json:"invalidated"`
+		Reason      string `json:"reason"`
+	}{
+		AccessKeyID: args[0],
+		Invalidated: true,
+		Reason:      args[1],
+	})
+}
@@ -0,0 +1,82 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthAdminOutput(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer authToken" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, err := ioutil.ReadAll(req.Body)
+		require.NoError(t, err)
+
+		switch req.Method + " " + req.URL.Path {
+		case "GET /v1/access/accesskey":
+			_, _ = w.Write([]byte(`{"access_grant": "grant", "secret_key": "secretkey", "public": true}`))
+		case "DELETE /v1/access/accesskey":
+			_, _ = w.Write([]byte(`{}`))
+		case "PUT /v1/access/accesskey/invalid":
+			require.JSONEq(t, `{"reason": "leaked"}`, string(body))
+			_, _ = w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	defer func(prev AuthAdminFlags) { authAdminCfg = prev }(authAdminCfg)
+	authAdminCfg = AuthAdminFlags{AuthService: server.URL, AuthToken: "authToken", Timeout: time.Minute}
+
+	for _, tt := range []struct {
+		name string
+		run  func() error
+		text string
+		json string
+	}{
+		{
+			name: "get",
+			run:  func() error { return cmdAuthAdminGet(&cobra.Command{}, []string{"accesskey"}) },
+			text: "Access grant: grant\nSecret key:   secretkey\nPublic:       true\n",
+			json: `{"access_grant": "grant", "secret_key": "secretkey", "public": true}`,
+		},
+		{
+			name: "delete",
+			run:  func() error { return cmdAuthAdminDelete(&cobra.Command{}, []string{"accesskey"}) },
+			text: "Deleted accesskey\n",
+			json: `{"access_key_id": "accesskey", "deleted": true}`,
+		},
+		{
+			name: "invalidate",
+			run:  func() error { return cmdAuthAdminInvalidate(&cobra.Command{}, []string{"accesskey", "leaked"}) },
+			text: "Invalidated accesskey\n",
+			json: `{"access_key_id": "accesskey", "invalidated": true, "reason": "leaked"}`,
+		},
+	} {
+		out, err := runWithOutput(t, outputText, tt.run)
+		require.NoError(t, err, tt.name)
+		require.Equal(t, tt.text, out, tt.name)
+
+		out, err = runWithOutput(t, outputJSON, tt.run)
+		require.NoError(t, err, tt.name)
+		require.JSONEq(t, tt.json, out, tt.name)
+	}
+
+	// errors of the auth service fail the commands without printing results
+	authAdminCfg.AuthToken = "wrong"
+	out, err := runWithOutput(t, outputJSON, func() error { return cmdAuthAdminGet(&cobra.Command{}, []string{"accesskey"}) })
+	require.Error(t, err)
+	require.Empty(t, out)
+}
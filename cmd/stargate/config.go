@@ -0,0 +1,270 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+
+	"storj.io/private/process"
+	"storj.io/stargate/internal/configdiff"
+	"storj.io/stargate/internal/configmigrate"
+	"storj.io/stargate/miniogw"
+)
+
+// ConfigDiffFlags configures the config diff command. The admin api settings
+// are read from the same configuration file as the gateway's.
+type ConfigDiffFlags struct {
+	Admin miniogw.AdminConfig
+	File  string `help:"path of the configuration file to compare, instead of config.yaml in the config dir" default:""`
+}
+
+// ConfigMigrateFlags configures the config migrate command.
+type ConfigMigrateFlags struct {
+	File   string `help:"path of the configuration file to migrate, instead of config.yaml in the config dir" default:""`
+	DryRun bool   `help:"only list the settings that would be migrated, without rewriting the file" default:"false"`
+}
+
+// deprecatedSettings are the settings that were renamed or removed. The gateway
+// still accepts the old names in the configuration file and on the command
+// line, with a warning, and config migrate rewrites them in the file. Entries
+// are kept for at least two releases after the one that renamed the setting.
+var deprecatedSettings = []configmigrate.Rename{}
+
+// unconfigurableFlags are the flags that can't be set in the configuration
+// file, and so are not compared with it.
+var unconfigurableFlags = []string{"config-dir", "defaults", "output", "advanced", "help"}
+
+var (
+	configCmd = &cobra.Command{
+		Use:   "config",
+		Short: "Inspect and migrate the configuration of the gateway",
+		Args:  cobra.NoArgs,
+	}
+	configDiffCmd = &cobra.Command{
+		Use:   "diff",
+		Short: "Compare the configuration file with the settings of the running gateway",
+		Long: `Fetches the settings that the running gateway uses, including the ones that
+were overridden with flags or environment variables, from its admin api and
+lists every setting that differs from the configuration file, or from the
+default when the file does not have the setting. The admin api needs
+--admin.token to serve the settings. Credentials are not compared.`,
+		Args: cobra.NoArgs,
+		RunE: cmdConfigDiff,
+	}
+	configMigrateCmd = &cobra.Command{
+		Use:   "migrate",
+		Short: "Rewrite renamed and removed settings of the configuration file",
+		Long: `Rewrites the settings of the configuration file that were renamed to their
+new names, and removes the ones that were removed, keeping comments and the
+other settings as they are. The gateway accepts the old names with a warning
+until they are dropped in a later release.`,
+		Args: cobra.NoArgs,
+		RunE: cmdConfigMigrate,
+	}
+
+	configDiffCfg    ConfigDiffFlags
+	configMigrateCfg ConfigMigrateFlags
+
+	// runSettings are the settings of the gateway that the admin api serves.
+	runSettings map[string]configdiff.Setting
+)
+
+func cmdConfigDiff(cmd *cobra.Command, args []string) (err error) {
+	if err := prepareAuthCommand(&configDiffCfg); err != nil {
+		return err
+	}
+	if configDiffCfg.Admin.Address == "" || configDiffCfg.Admin.Token == "" {
+		return Error.New("both --admin.address and --admin.token are required")
+	}
+
+	file := configDiffCfg.File
+	if file == "" {
+		file = filepath.Join(confDir, "config.yaml")
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return Error.New("invalid configuration file %q: %v", file, err)
+	}
+
+	ctx, _ := process.Ctx(cmd)
+	running, err := fetchSettings(ctx, configDiffCfg.Admin)
+	if err != nil {
+		return err
+	}
+
+	drift := configdiff.Diff(configdiff.Flatten(values), running)
+
+	var text strings.Builder
+	if len(drift) == 0 {
+		fmt.Fprintf(&text, "The running gateway uses the settings of %s.", file)
+	} else {
+		fmt.Fprintf(&text, "%d settings of the running gateway differ from %s:", len(drift), file)
+		for _, d := range drift {
+			fmt.Fprintf(&text, "\n  %s: running %q, %s %q", d.Key, d.Running, d.Source, d.Configured)
+		}
+	}
+
+	return printResult(text.String(), struct {
+		File  string             `json:"file"`
+		Drift []configdiff.Drift `json:"drift"`
+	}{file, drift})
+}
+
+// fetchSettings fetches the settings of the running gateway from its admin api.
+func fetchSettings(ctx context.Context, config miniogw.AdminConfig) (_ map[string]configdiff.Setting, err error) {
+	host, port, err := net.SplitHostPort(config.Address)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+net.JoinHostPort(host, port)+"/v1/config", nil)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+config.Token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	defer func() { err = errs.Combine(err, resp.Body.Close()) }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, Error.New("admin api responded with %s", resp.Status)
+	}
+
+	var result struct {
+		Settings map[string]configdiff.Setting `json:"settings"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, Error.Wrap(err)
+	}
+	return result.Settings, nil
+}
+
+func cmdConfigMigrate(cmd *cobra.Command, args []string) (err error) {
+	if err := checkOutputFormat(); err != nil {
+		return err
+	}
+
+	file := configMigrateCfg.File
+	if file == "" {
+		file = filepath.Join(confDir, "config.yaml")
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	migrated, applied, err := configmigrate.Rewrite(data, deprecatedSettings)
+	if err != nil {
+		return Error.New("invalid configuration file %q: %v", file, err)
+	}
+	if len(applied) > 0 && !configMigrateCfg.DryRun {
+		if err := replaceFile(file, migrated, info.Mode()); err != nil {
+			return err
+		}
+	}
+
+	var text strings.Builder
+	switch {
+	case len(applied) == 0:
+		fmt.Fprintf(&text, "%s has no renamed or removed settings.", file)
+	case configMigrateCfg.DryRun:
+		fmt.Fprintf(&text, "%d settings of %s would be migrated:", len(applied), file)
+	default:
+		fmt.Fprintf(&text, "Migrated %d settings of %s:", len(applied), file)
+	}
+	for _, rename := range applied {
+		fmt.Fprintf(&text, "\n  %s", rename)
+	}
+
+	return printResult(text.String(), struct {
+		File     string                 `json:"file"`
+		DryRun   bool                   `json:"dry_run"`
+		Migrated []configmigrate.Rename `json:"migrated"`
+	}{file, configMigrateCfg.DryRun, applied})
+}
+
+// replaceFile replaces the contents of file, through a temporary file so that
+// a failed write never leaves it partially written.
+func replaceFile(file string, data []byte, mode os.FileMode) (err error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file)+".*")
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	defer func() {
+		if err != nil {
+			err = errs.Combine(err, os.Remove(tmp.Name()))
+		}
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		return errs.Combine(Error.Wrap(err), tmp.Close())
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		return errs.Combine(Error.Wrap(err), tmp.Close())
+	}
+	if err := tmp.Sync(); err != nil {
+		return errs.Combine(Error.Wrap(err), tmp.Close())
+	}
+	if err := tmp.Close(); err != nil {
+		return Error.Wrap(err)
+	}
+	return Error.Wrap(os.Rename(tmp.Name(), file))
+}
+
+// migrateSettings sets the flags of the settings that the configuration file
+// has under names that were renamed, and warns about them.
+func migrateSettings(flags *pflag.FlagSet, file string) error {
+	if len(deprecatedSettings) == 0 {
+		return nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return Error.New("invalid configuration file %q: %v", file, err)
+	}
+	return configmigrate.Apply(flags, configdiff.Flatten(values), deprecatedSettings, warnDeprecated)
+}
+
+// warnDeprecated warns that a renamed or removed setting is used.
+func warnDeprecated(rename configmigrate.Rename) {
+	zap.S().Warnf("%s; run \"stargate config migrate\" to update the configuration file", rename)
+}
@@ -0,0 +1,208 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var (
+	completionCmd = &cobra.Command{
+		Use:       "completion (bash|zsh|fish|powershell)",
+		Short:     "Generate a shell completion script",
+		Args:      cobra.ExactValidArgs(1),
+		ValidArgs: []string{"bash", "zsh", "fish", "powershell"},
+		RunE:      cmdCompletion,
+	}
+	manCmd = &cobra.Command{
+		Use:   "man",
+		Short: "Generate a man page for all commands",
+		Args:  cobra.NoArgs,
+		RunE:  cmdMan,
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(completionCmd)
+	rootCmd.AddCommand(manCmd)
+}
+
+// cmdCompletion writes a completion script for the requested shell to stdout.
+// It runs after process.Exec has registered its flags, so the script includes
+// every flag that the config structs and the process package bind.
+func cmdCompletion(cmd *cobra.Command, args []string) error {
+	root := cmd.Root()
+	switch args[0] {
+	case "bash":
+		return root.GenBashCompletion(os.Stdout)
+	case "zsh":
+		return root.GenZshCompletion(os.Stdout)
+	case "fish":
+		return genFishCompletion(root, os.Stdout)
+	case "powershell":
+		return root.GenPowerShellCompletion(os.Stdout)
+	default:
+		return Error.New("unsupported shell %q", args[0])
+	}
+}
+
+// cmdMan writes a roff formatted man page describing the whole command tree to stdout.
+func cmdMan(cmd *cobra.Command, args []string) error {
+	return genManPage(cmd.Root(), os.Stdout, time.Now())
+}
+
+// visibleFlags returns the non-hidden flags of a flag set in sorted order,
+// leaving out cobra's implicit help flag.
+func visibleFlags(flags *pflag.FlagSet) (visible []*pflag.Flag) {
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if !flag.Hidden && flag.Name != "help" {
+			visible = append(visible, flag)
+		}
+	})
+	return visible
+}
+
+// genFishCompletion writes a fish completion script for the command tree rooted at root.
+// Cobra does not provide a fish generator in the version we depend on.
+func genFishCompletion(root *cobra.Command, w io.Writer) error {
+	name := root.Name()
+	out := &errWriter{w: w}
+
+	out.printf("# fish completion for %s\n\n", name)
+	out.printf("complete -c %s -f\n", name)
+
+	for _, flag := range visibleFlags(root.PersistentFlags()) {
+		out.printf("complete -c %s%s\n", name, fishFlag(flag))
+	}
+
+	var walk func(cmd *cobra.Command, condition string)
+	walk = func(cmd *cobra.Command, condition string) {
+		for _, sub := range cmd.Commands() {
+			if !sub.IsAvailableCommand() {
+				continue
+			}
+			out.printf("complete -c %s -n %s -a %s -d %s\n",
+				name, fishQuote(condition), sub.Name(), fishQuote(sub.Short))
+
+			subCondition := "__fish_seen_subcommand_from " + sub.Name()
+			for _, flag := range visibleFlags(sub.LocalNonPersistentFlags()) {
+				out.printf("complete -c %s -n %s%s\n", name, fishQuote(subCondition), fishFlag(flag))
+			}
+			for _, arg := range sub.ValidArgs {
+				out.printf("complete -c %s -n %s -a %s\n", name, fishQuote(subCondition), arg)
+			}
+			walk(sub, subCondition)
+		}
+	}
+	walk(root, "__fish_use_subcommand")
+
+	return out.err
+}
+
+// fishFlag renders the option part of a fish complete line for a flag.
+func fishFlag(flag *pflag.Flag) string {
+	var b strings.Builder
+	b.WriteString(" -l " + flag.Name)
+	if flag.Shorthand != "" {
+		b.WriteString(" -s " + flag.Shorthand)
+	}
+	if flag.Value.Type() != "bool" {
+		b.WriteString(" -r")
+	}
+	if flag.Usage != "" {
+		b.WriteString(" -d " + fishQuote(flag.Usage))
+	}
+	return b.String()
+}
+
+// fishQuote quotes s as a single quoted fish string.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// genManPage writes a single section 1 man page for the command tree rooted at root.
+func genManPage(root *cobra.Command, w io.Writer, now time.Time) error {
+	out := &errWriter{w: w}
+
+	out.printf(".TH %s 1 %q\n", strings.ToUpper(root.Name()), now.Format("Jan 2006"))
+	out.printf(".SH NAME\n%s \\- %s\n", root.Name(), manEscape(root.Short))
+	out.printf(".SH SYNOPSIS\n.B %s\n[command] [flags]\n", root.Name())
+	if root.Long != "" {
+		out.printf(".SH DESCRIPTION\n%s\n", manEscape(root.Long))
+	}
+
+	out.printf(".SH GLOBAL OPTIONS\n")
+	manFlags(out, visibleFlags(root.PersistentFlags()))
+
+	var walk func(cmd *cobra.Command)
+	walk = func(cmd *cobra.Command) {
+		for _, sub := range cmd.Commands() {
+			if !sub.IsAvailableCommand() {
+				continue
+			}
+			out.printf(".SH %s\n", strings.ToUpper(sub.CommandPath()))
+			out.printf("%s\n", manEscape(sub.Short))
+			out.printf(".PP\n.B %s\n", manEscape(sub.UseLine()))
+			if sub.Long != "" {
+				out.printf(".PP\n%s\n", manEscape(sub.Long))
+			}
+			manFlags(out, visibleFlags(sub.LocalNonPersistentFlags()))
+			walk(sub)
+		}
+	}
+	walk(root)
+
+	return out.err
+}
+
+// manFlags writes a tagged paragraph for every flag.
+func manFlags(out *errWriter, flags []*pflag.Flag) {
+	for _, flag := range flags {
+		name := "\\-\\-" + manEscape(flag.Name)
+		if flag.Shorthand != "" {
+			name = "\\-" + flag.Shorthand + ", " + name
+		}
+		if flag.Value.Type() != "bool" {
+			name += " " + flag.Value.Type()
+		}
+		out.printf(".TP\n.B %s\n%s", name, manEscape(flag.Usage))
+		if flag.DefValue != "" && flag.DefValue != "false" {
+			out.printf(" (default %s)", manEscape(flag.DefValue))
+		}
+		out.printf("\n")
+	}
+}
+
+// manEscape escapes characters that have special meaning in roff.
+func manEscape(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `-`, `\-`).Replace(s)
+	// a leading . or ' would be interpreted as a control line
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'") {
+			lines[i] = `\&` + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// errWriter remembers the first write error so generators don't have to check every write.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) printf(format string, args ...interface{}) {
+	if ew.err != nil {
+		return
+	}
+	_, ew.err = fmt.Fprintf(ew.w, format, args...)
+}
@@ -0,0 +1,78 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/require"
+)
+
+// newDocsTree returns a command tree with persistent, local, hidden and
+// boolean flags, valid args and a hidden command.
+func newDocsTree(t *testing.T) *cobra.Command {
+	root := &cobra.Command{Use: "gateway", Short: "The gateway's root"}
+	root.PersistentFlags().String("config-dir", "/etc/gateway", "main directory for gateway configuration")
+	root.PersistentFlags().Bool("advanced", false, "print advanced flags help")
+
+	run := &cobra.Command{Use: "run", Short: "Run the gateway", Long: ".starts with a dot", Run: func(*cobra.Command, []string) {}}
+	run.Flags().StringP("server.address", "a", ":7777", "address to serve S3 api over")
+	run.Flags().Bool("debug", false, "it's for debugging")
+	run.Flags().String("secret", "", "a hidden flag")
+	require.NoError(t, run.Flags().MarkHidden("secret"))
+
+	completion := &cobra.Command{Use: "completion", Short: "Generate completion", ValidArgs: []string{"bash", "fish"}, Run: func(*cobra.Command, []string) {}}
+	hidden := &cobra.Command{Use: "hidden", Short: "A hidden command", Hidden: true, Run: func(*cobra.Command, []string) {}}
+
+	root.AddCommand(run, completion, hidden)
+	return root
+}
+
+func TestGenFishCompletion(t *testing.T) {
+	var out bytes.Buffer
+	require.NoError(t, genFishCompletion(newDocsTree(t), &out))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Equal(t, []string{
+		"# fish completion for gateway",
+		"",
+		"complete -c gateway -f",
+		"complete -c gateway -l advanced -d 'print advanced flags help'",
+		"complete -c gateway -l config-dir -r -d 'main directory for gateway configuration'",
+		"complete -c gateway -n '__fish_use_subcommand' -a completion -d 'Generate completion'",
+		"complete -c gateway -n '__fish_seen_subcommand_from completion' -a bash",
+		"complete -c gateway -n '__fish_seen_subcommand_from completion' -a fish",
+		"complete -c gateway -n '__fish_use_subcommand' -a run -d 'Run the gateway'",
+		"complete -c gateway -n '__fish_seen_subcommand_from run' -l debug -d 'it\\'s for debugging'",
+		"complete -c gateway -n '__fish_seen_subcommand_from run' -l server.address -s a -r -d 'address to serve S3 api over'",
+	}, lines)
+}
+
+func TestGenManPage(t *testing.T) {
+	var out bytes.Buffer
+	require.NoError(t, genManPage(newDocsTree(t), &out, time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)))
+	page := out.String()
+
+	require.True(t, strings.HasPrefix(page, ".TH GATEWAY 1 \"Oct 2020\"\n.SH NAME\ngateway \\- The gateway's root\n"), page)
+	require.Contains(t, page, ".SH GLOBAL OPTIONS\n.TP\n.B \\-\\-advanced\nprint advanced flags help\n")
+	require.Contains(t, page, ".TP\n.B \\-\\-config\\-dir string\nmain directory for gateway configuration (default /etc/gateway)\n")
+	require.Contains(t, page, ".SH GATEWAY RUN\nRun the gateway\n.PP\n.B gateway run [flags]\n.PP\n\\&.starts with a dot\n")
+	require.Contains(t, page, ".TP\n.B \\-a, \\-\\-server.address string\naddress to serve S3 api over (default :7777)\n")
+	require.NotContains(t, page, "secret")
+	require.NotContains(t, page, "hidden")
+}
+
+func TestDocsWriteErrors(t *testing.T) {
+	require.Error(t, genFishCompletion(newDocsTree(t), failingWriter{}))
+	require.Error(t, genManPage(newDocsTree(t), failingWriter{}, time.Now()))
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, errors.New("write failed") }
@@ -0,0 +1,222 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"golang.org/x/crypto/ssh/terminal"
+
+	"storj.io/private/cfgstruct"
+)
+
+// advancedHelp is the value of the global --advanced flag.
+var advancedHelp bool
+
+var (
+	helpCmd = &cobra.Command{
+		Use:   "help [command]",
+		Short: "Help about any command",
+		Long: `Help provides help for any command in the application.
+Use --advanced to include every flag, grouped by configuration section.`,
+		RunE: cmdHelp,
+	}
+	helpFlagsCmd = &cobra.Command{
+		Use:   "flags [pattern]",
+		Short: "Search the flags of every command",
+		Long: `Lists every flag whose name or description contains pattern, ignoring case.
+Without a pattern all flags are listed.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: cmdHelpFlags,
+	}
+)
+
+// setHelp replaces cobra's help command and usage output on root with our two tier help,
+// where only flags annotated as basic are shown unless --advanced is given.
+func setHelp(root *cobra.Command) {
+	root.PersistentFlags().BoolVar(&advancedHelp, "advanced", false, "if used in with -h, print advanced flags help")
+	cfgstruct.SetBoolAnnotation(root.PersistentFlags(), "advanced", cfgstruct.BasicHelpAnnotationName, true)
+
+	helpCmd.AddCommand(helpFlagsCmd)
+	root.SetHelpCommand(helpCmd)
+	root.SetUsageFunc(usage)
+}
+
+func cmdHelp(cmd *cobra.Command, args []string) error {
+	target, _, err := cmd.Root().Find(args)
+	if target == nil || err != nil {
+		cmd.Printf("Unknown help topic %#q\n", args)
+		return cmd.Root().Usage()
+	}
+	target.InitDefaultHelpFlag()
+	return target.Help()
+}
+
+func cmdHelpFlags(cmd *cobra.Command, args []string) error {
+	var pattern string
+	if len(args) > 0 {
+		pattern = strings.ToLower(args[0])
+	}
+	matches := func(flag *pflag.Flag) bool {
+		return strings.Contains(strings.ToLower(flag.Name), pattern) ||
+			strings.Contains(strings.ToLower(flag.Usage), pattern)
+	}
+
+	out := &errWriter{w: cmd.OutOrStdout()}
+	found := false
+
+	printMatches := func(title string, flags *pflag.FlagSet) {
+		matched := filterFlags(flags, matches)
+		if !matched.HasAvailableFlags() {
+			return
+		}
+		found = true
+		out.printf("%s:\n%s\n", title, matched.FlagUsagesWrapped(terminalWidth()))
+	}
+
+	root := cmd.Root()
+	printMatches("Global Flags", root.PersistentFlags())
+
+	var walk func(cmd *cobra.Command)
+	walk = func(cmd *cobra.Command) {
+		for _, sub := range cmd.Commands() {
+			if !sub.IsAvailableCommand() {
+				continue
+			}
+			printMatches(fmt.Sprintf("Flags for %q", sub.CommandPath()), sub.LocalNonPersistentFlags())
+			walk(sub)
+		}
+	}
+	walk(root)
+
+	if !found {
+		out.printf("No flags match %q.\n", pattern)
+	}
+	return out.err
+}
+
+// usage prints the usage of cmd. Flags are split into basic flags, which are always shown,
+// and advanced flags, which are shown grouped by their configuration section with --advanced.
+func usage(cmd *cobra.Command) error {
+	out := &errWriter{w: cmd.OutOrStderr()}
+	width := terminalWidth()
+
+	out.printf("Usage:")
+	if cmd.Runnable() {
+		out.printf("\n  %s", cmd.UseLine())
+	}
+	if cmd.HasAvailableSubCommands() {
+		out.printf("\n  %s [command]", cmd.CommandPath())
+	}
+	if len(cmd.Aliases) > 0 {
+		out.printf("\n\nAliases:\n  %s", cmd.NameAndAliases())
+	}
+	if cmd.HasExample() {
+		out.printf("\n\nExamples:\n%s", cmd.Example)
+	}
+
+	if cmd.HasAvailableSubCommands() {
+		out.printf("\n\nAvailable Commands:")
+		for _, sub := range cmd.Commands() {
+			if sub.IsAvailableCommand() || sub.Name() == "help" {
+				out.printf("\n  %-*s %s", sub.NamePadding(), sub.Name(), sub.Short)
+			}
+		}
+	}
+
+	local := filterFlags(cmd.LocalFlags(), isBasicFlag)
+	if local.HasAvailableFlags() {
+		out.printf("\n\nFlags:\n%s", strings.TrimRight(local.FlagUsagesWrapped(width), " \n"))
+	}
+	global := filterFlags(cmd.InheritedFlags(), isBasicFlag)
+	if global.HasAvailableFlags() {
+		out.printf("\n\nGlobal Flags:\n%s", strings.TrimRight(global.FlagUsagesWrapped(width), " \n"))
+	}
+
+	advanced := make(map[string]*pflag.FlagSet)
+	var hidden int
+	visit := func(flag *pflag.Flag) {
+		if flag.Hidden || isBasicFlag(flag) {
+			return
+		}
+		hidden++
+		group := flagGroup(flag)
+		if advanced[group] == nil {
+			advanced[group] = pflag.NewFlagSet(group, pflag.ContinueOnError)
+		}
+		advanced[group].AddFlag(flag)
+	}
+	cmd.LocalFlags().VisitAll(visit)
+	cmd.InheritedFlags().VisitAll(visit)
+
+	if advancedHelp {
+		groups := make([]string, 0, len(advanced))
+		for group := range advanced {
+			groups = append(groups, group)
+		}
+		sort.Strings(groups)
+
+		for _, group := range groups {
+			title := "Advanced Flags"
+			if group != "" {
+				title = fmt.Sprintf("Advanced Flags (%s)", group)
+			}
+			out.printf("\n\n%s:\n%s", title, strings.TrimRight(advanced[group].FlagUsagesWrapped(width), " \n"))
+		}
+	} else if hidden > 0 {
+		out.printf("\n\nUse --advanced to show %d more flags.", hidden)
+	}
+
+	if cmd.HasAvailableSubCommands() {
+		out.printf("\n\nUse \"%s [command] --help\" for more information about a command.", cmd.CommandPath())
+	}
+	out.printf("\n")
+
+	return out.err
+}
+
+// isBasicFlag returns whether the flag is annotated to always be shown in help.
+// Cobra's implicit help flag is always basic.
+func isBasicFlag(flag *pflag.Flag) bool {
+	if flag.Name == "help" {
+		return true
+	}
+	basic, ok := flag.Annotations[cfgstruct.BasicHelpAnnotationName]
+	return ok && len(basic) == 1 && basic[0] == "true"
+}
+
+// flagGroup returns the configuration section of a flag, which is the first
+// component of its dotted name, or "" for top level flags.
+func flagGroup(flag *pflag.Flag) string {
+	if i := strings.IndexByte(flag.Name, '.'); i > 0 {
+		return flag.Name[:i]
+	}
+	return ""
+}
+
+// filterFlags returns a flag set with the visible flags of flags that satisfy keep.
+func filterFlags(flags *pflag.FlagSet, keep func(*pflag.Flag) bool) *pflag.FlagSet {
+	filtered := pflag.NewFlagSet("", pflag.ContinueOnError)
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if !flag.Hidden && keep(flag) {
+			filtered.AddFlag(flag)
+		}
+	})
+	return filtered
+}
+
+// terminalWidth returns the width to wrap flag descriptions at, or 0 to not wrap
+// when stdout is not a terminal.
+func terminalWidth() int {
+	width, _, err := terminal.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		return 0
+	}
+	return width
+}
@@ -0,0 +1,141 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/private/cfgstruct"
+)
+
+// newHelpTree returns a command tree with basic, advanced, grouped, short and
+// hidden flags, with the two tier help.
+func newHelpTree(t *testing.T) *cobra.Command {
+	root := &cobra.Command{Use: "gateway", Short: "The gateway's root"}
+	root.PersistentFlags().String("config-dir", "/etc/gateway", "main directory for gateway configuration")
+	cfgstruct.SetBoolAnnotation(root.PersistentFlags(), "config-dir", cfgstruct.BasicHelpAnnotationName, true)
+	root.PersistentFlags().String("log.level", "info", "the minimum log level to log")
+
+	run := &cobra.Command{Use: "run", Short: "Run the gateway", Run: func(*cobra.Command, []string) {}}
+	run.Flags().StringP("server.address", "a", ":7777", "address to serve S3 api over")
+	cfgstruct.SetBoolAnnotation(run.Flags(), "server.address", cfgstruct.BasicHelpAnnotationName, true)
+	run.Flags().Duration("server.shutdown-timeout", 0, "how long to wait for requests to finish on shutdown")
+	run.Flags().Bool("debug", false, "it's for debugging")
+	run.Flags().String("secret", "", "a hidden flag")
+	require.NoError(t, run.Flags().MarkHidden("secret"))
+
+	root.AddCommand(run)
+	setHelp(root)
+	return root
+}
+
+func TestHelp(t *testing.T) {
+	defer func(prev bool) { advancedHelp = prev }(advancedHelp)
+	root := newHelpTree(t)
+
+	runHelp := `Run the gateway
+
+Usage:
+  gateway run [flags]
+
+Flags:
+  -h, --help                    help for run
+  -a, --server.address string   address to serve S3 api over (default ":7777")
+
+Global Flags:
+      --advanced            if used in with -h, print advanced flags help
+      --config-dir string   main directory for gateway configuration (default "/etc/gateway")
+
+Use --advanced to show 3 more flags.
+`
+
+	for _, tt := range []struct {
+		args     []string
+		expected string
+	}{
+		{
+			args: []string{"--help"},
+			expected: `The gateway's root
+
+Usage:
+  gateway [command]
+
+Available Commands:
+  help        Help about any command
+  run         Run the gateway
+
+Flags:
+      --advanced            if used in with -h, print advanced flags help
+      --config-dir string   main directory for gateway configuration (default "/etc/gateway")
+  -h, --help                help for gateway
+
+Use --advanced to show 1 more flags.
+
+Use "gateway [command] --help" for more information about a command.
+`,
+		},
+		{
+			args:     []string{"run", "--help"},
+			expected: runHelp,
+		},
+		{
+			args:     []string{"help", "run"},
+			expected: runHelp,
+		},
+		{
+			args: []string{"run", "--help", "--advanced"},
+			expected: `Run the gateway
+
+Usage:
+  gateway run [flags]
+
+Flags:
+  -h, --help                    help for run
+  -a, --server.address string   address to serve S3 api over (default ":7777")
+
+Global Flags:
+      --advanced            if used in with -h, print advanced flags help
+      --config-dir string   main directory for gateway configuration (default "/etc/gateway")
+
+Advanced Flags:
+      --debug   it's for debugging
+
+Advanced Flags (log):
+      --log.level string   the minimum log level to log (default "info")
+
+Advanced Flags (server):
+      --server.shutdown-timeout duration   how long to wait for requests to finish on shutdown
+`,
+		},
+		{
+			args: []string{"help", "flags", "ADDRESS"},
+			expected: `Flags for "gateway run":
+  -a, --server.address string   address to serve S3 api over (default ":7777")
+
+`,
+		},
+		{
+			args: []string{"help", "flags", "log"},
+			expected: `Global Flags:
+      --log.level string   the minimum log level to log (default "info")
+
+`,
+		},
+		{
+			args:     []string{"help", "flags", "nothing"},
+			expected: "No flags match \"nothing\".\n",
+		},
+	} {
+		advancedHelp = false
+		var out bytes.Buffer
+		root.SetOut(&out)
+		root.SetArgs(tt.args)
+		require.NoError(t, root.Execute(), tt.args)
+		require.Equal(t, tt.expected, out.String(), tt.args)
+	}
+}
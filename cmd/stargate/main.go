@@ -4,26 +4,36 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
 	"context"
-	"fmt"
 	"net"
 	"os"
 	"path/filepath"
-	"strings"
 	"time"
 
 	"github.com/minio/cli"
 	minio "github.com/minio/minio/cmd"
 	"github.com/spf13/cobra"
-	"github.com/spf13/pflag"
 	"github.com/zeebo/errs"
 	"go.uber.org/zap"
 
 	"storj.io/common/fpath"
 	"storj.io/private/cfgstruct"
 	"storj.io/private/process"
+	"storj.io/stargate/internal/admission"
+	"storj.io/stargate/internal/anomaly"
+	"storj.io/stargate/internal/billing"
+	"storj.io/stargate/internal/clockskew"
+	"storj.io/stargate/internal/configcrypt"
+	"storj.io/stargate/internal/configdiff"
+	"storj.io/stargate/internal/configmigrate"
+	"storj.io/stargate/internal/keychain"
+	"storj.io/stargate/internal/metaindex"
+	"storj.io/stargate/internal/plugin"
+	"storj.io/stargate/internal/reconcile"
+	"storj.io/stargate/internal/redact"
+	"storj.io/stargate/internal/script"
+	"storj.io/stargate/internal/slo"
+	"storj.io/stargate/internal/tracing"
 	"storj.io/stargate/internal/wizard"
 	"storj.io/stargate/miniogw"
 	"storj.io/uplink"
@@ -33,6 +43,33 @@ import (
 type GatewayFlags struct {
 	Server miniogw.ServerConfig
 	Minio  miniogw.MinioConfig
+	Admin  miniogw.AdminConfig
+	Health miniogw.HealthConfig
+	Slow   miniogw.SlowConfig
+	Timing miniogw.TimingConfig
+	Stream miniogw.StreamingConfig
+	SLO    slo.Config
+	Clock  clockskew.Config
+
+	Admission  admission.Config
+	Signatures miniogw.SignatureConfig
+
+	Buckets  miniogw.BucketsConfig
+	Quotas   miniogw.QuotaConfig
+	Naming   miniogw.NamingConfig
+	Deletes  miniogw.TombstonesConfig
+	Features miniogw.FeaturesConfig
+	Projects miniogw.ProjectsConfig
+	Routes   miniogw.RoutesConfig
+	Degraded miniogw.DegradedConfig
+
+	Anomaly   anomaly.Config
+	Reconcile reconcile.Config
+	Tracing   tracing.Config
+	Billing   billing.Config
+	Plugins   plugin.Config
+	Script    script.Config
+	Index     metaindex.Config
 
 	Config
 }
@@ -82,16 +119,58 @@ func init() {
 
 	rootCmd.AddCommand(runCmd)
 	rootCmd.AddCommand(setupCmd)
+	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(accessCmd)
+	accessCmd.AddCommand(accessRegisterCmd)
+	accessCmd.AddCommand(accessInspectCmd)
+	rootCmd.AddCommand(authAdminCmd)
+	authAdminCmd.AddCommand(authAdminGetCmd)
+	authAdminCmd.AddCommand(authAdminDeleteCmd)
+	authAdminCmd.AddCommand(authAdminInvalidateCmd)
+	rootCmd.AddCommand(configcrypt.NewEncryptCommand())
+	rootCmd.AddCommand(keychain.NewCommand())
+	rootCmd.AddCommand(authCmd)
+	authCmd.AddCommand(authMigrateCmd)
+	authCmd.AddCommand(authBackupCmd)
+	authCmd.AddCommand(authRestoreCmd)
+	authCmd.AddCommand(authExportCmd)
+	authCmd.AddCommand(authImportCmd)
+	authCmd.AddCommand(authFsckCmd)
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configDiffCmd)
+	configCmd.AddCommand(configMigrateCmd)
+	rootCmd.AddCommand(updateCmd)
+	rootCmd.AddCommand(s3checkCmd)
 	process.Bind(runCmd, &runCfg, defaults, cfgstruct.ConfDir(confDir))
 	process.Bind(setupCmd, &setupCfg, defaults, cfgstruct.ConfDir(confDir), cfgstruct.SetupMode())
-
-	rootCmd.PersistentFlags().BoolVar(new(bool), "advanced", false, "if used in with -h, print advanced flags help")
-	cfgstruct.SetBoolAnnotation(rootCmd.PersistentFlags(), "advanced", cfgstruct.BasicHelpAnnotationName, true)
+	process.Bind(statusCmd, &statusCfg, defaults, cfgstruct.ConfDir(confDir))
+	process.Bind(accessRegisterCmd, &accessCfg, defaults, cfgstruct.ConfDir(confDir))
+	for _, cmd := range []*cobra.Command{authAdminGetCmd, authAdminDeleteCmd, authAdminInvalidateCmd} {
+		process.Bind(cmd, &authAdminCfg, defaults, cfgstruct.ConfDir(confDir))
+	}
+	process.Bind(authMigrateCmd, &migrateCfg, defaults, cfgstruct.ConfDir(confDir))
+	process.Bind(authBackupCmd, &backupCfg, defaults, cfgstruct.ConfDir(confDir))
+	process.Bind(authRestoreCmd, &restoreCfg, defaults, cfgstruct.ConfDir(confDir))
+	process.Bind(authExportCmd, &exportCfg, defaults, cfgstruct.ConfDir(confDir))
+	process.Bind(authImportCmd, &importCfg, defaults, cfgstruct.ConfDir(confDir))
+	process.Bind(authFsckCmd, &fsckCfg, defaults, cfgstruct.ConfDir(confDir))
+	process.Bind(configDiffCmd, &configDiffCfg, defaults, cfgstruct.ConfDir(confDir))
+	process.Bind(configMigrateCmd, &configMigrateCfg, defaults, cfgstruct.ConfDir(confDir))
+	process.Bind(updateCmd, &updateCfg, defaults, cfgstruct.ConfDir(confDir))
+	process.Bind(s3checkCmd, &s3checkCfg, defaults, cfgstruct.ConfDir(confDir))
+
+	rootCmd.PersistentFlags().StringVar(&outputFormat, "output", outputText, "output format for command results: text or json (json implies a non-interactive setup)")
+	cfgstruct.SetBoolAnnotation(rootCmd.PersistentFlags(), "output", cfgstruct.BasicHelpAnnotationName, true)
 	cfgstruct.SetBoolAnnotation(rootCmd.PersistentFlags(), "config-dir", cfgstruct.BasicHelpAnnotationName, true)
-	setUsageFunc(rootCmd)
+	setHelp(rootCmd)
+	rootCmd.SetGlobalNormalizationFunc(configmigrate.Normalize(deprecatedSettings, warnDeprecated))
 }
 
 func cmdSetup(cmd *cobra.Command, args []string) (err error) {
+	if err := checkOutputFormat(); err != nil {
+		return err
+	}
+
 	setupDir, err := filepath.Abs(confDir)
 	if err != nil {
 		return Error.Wrap(err)
@@ -111,6 +190,27 @@ func cmdSetup(cmd *cobra.Command, args []string) (err error) {
 }
 
 func cmdRun(cmd *cobra.Command, args []string) (err error) {
+	if err := checkOutputFormat(); err != nil {
+		return err
+	}
+
+	defer redact.ReplaceGlobals()()
+
+	if err := migrateSettings(cmd.Flags(), filepath.Join(confDir, "config.yaml")); err != nil {
+		return err
+	}
+
+	// the settings are taken before secrets are resolved, so that they are
+	// the same as the values in the configuration file
+	runSettings = configdiff.Settings(cmd.Flags(), unconfigurableFlags...)
+
+	if err := keychain.ResolveFields(&runCfg); err != nil {
+		return err
+	}
+	if err := configcrypt.DecryptFields(&runCfg, configcrypt.EnvOrPrompt()); err != nil {
+		return err
+	}
+
 	address := runCfg.Server.Address
 	host, port, err := net.SplitHostPort(address)
 	if err != nil {
@@ -125,11 +225,29 @@ func cmdRun(cmd *cobra.Command, args []string) (err error) {
 	if err := process.InitMetrics(ctx, zap.L(), nil, ""); err != nil {
 		zap.S().Warn("Failed to initialize telemetry batcher: ", err)
 	}
-
-	zap.S().Info("Starting Tardigrade S3 Gateway\n\n")
-	zap.S().Infof("Endpoint: %s\n", address)
-	zap.S().Info("Access key: use your Tardigrade Access Grant\n")
-	zap.S().Info("Secret key: anything would work\n")
+	stopSampler, err := tracing.Start(zap.L().Named("tracing"), runCfg.Tracing)
+	if err != nil {
+		return err
+	}
+	defer stopSampler()
+
+	if jsonOutput() {
+		// the access key is the access grant of the client and the secret key
+		// isn't checked, so there are no credentials to report.
+		err = printResult("", struct {
+			Endpoint string `json:"endpoint"`
+		}{
+			Endpoint: address,
+		})
+		if err != nil {
+			return Error.Wrap(err)
+		}
+	} else {
+		zap.S().Info("Starting Tardigrade S3 Gateway\n\n")
+		zap.S().Infof("Endpoint: %s\n", address)
+		zap.S().Info("Access key: use your Tardigrade Access Grant\n")
+		zap.S().Info("Secret key: anything would work\n")
+	}
 
 	return runCfg.Run(ctx)
 }
@@ -158,154 +276,380 @@ func (flags GatewayFlags) Run(ctx context.Context) (err error) {
 		return err
 	}
 
+	// minio listens behind the signature checks, which serve the address
+	address := flags.Server.Address
+	if flags.Signatures.DerivationKeys != "" {
+		address = flags.Signatures.InternalAddress
+	}
+
 	minio.Main([]string{"storj", "gateway", "storj",
-		"--address", flags.Server.Address, "--config-dir", flags.Minio.Dir, "--quiet",
+		"--address", address, "--config-dir", flags.Minio.Dir, "--quiet",
 		"--compat"})
 	return errs.New("unexpected minio exit")
 }
 
 func (flags GatewayFlags) action(ctx context.Context, cliCtx *cli.Context) (err error) {
-	gw, err := flags.NewGateway(ctx)
+	var health *miniogw.Health
+	if flags.Health.Address != "" {
+		health = miniogw.NewHealth(zap.L().Named("health"), flags.Health)
+		go func() { _ = health.Run(ctx) }()
+		go func() {
+			if err := miniogw.ServeHealth(ctx, zap.L(), flags.Health.Address, health); err != nil {
+				zap.L().Error("health checks failed", zap.Error(err))
+			}
+		}()
+	}
+
+	if flags.Clock.URLs != "" {
+		checker := clockskew.New(zap.L().Named("clock"), flags.Clock)
+		go func() { _ = checker.Run(ctx) }()
+	}
+
+	if flags.Signatures.DerivationKeys != "" {
+		signatures, err := miniogw.NewSignatures(zap.L().Named("signatures"), flags.Signatures)
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := miniogw.ServeSignatures(ctx, zap.L(), flags.Server.Address, signatures); err != nil {
+				zap.L().Error("signature checks failed", zap.Error(err))
+			}
+		}()
+	}
+
+	gw, err := flags.NewGateway(ctx, health)
 	if err != nil {
 		return err
 	}
 
-	minio.StartGateway(cliCtx, miniogw.Logging(gw, zap.L()))
-	return errs.New("unexpected minio exit")
-}
+	if flags.Admission.MaxConcurrent > 0 {
+		weights, err := admission.ParseWeights(flags.Admission.Weights)
+		if err != nil {
+			return err
+		}
+		// the wrappers below see the time that requests wait, and the
+		// requests that are rejected
+		gw = miniogw.Admission(gw, admission.New(flags.Admission, weights))
+	}
 
-// NewGateway creates a new minio Gateway.
-func (flags GatewayFlags) NewGateway(ctx context.Context) (gw minio.Gateway, err error) {
-	config := flags.newUplinkConfig(ctx)
+	var index *metaindex.Index
+	if flags.Index.Path != "" {
+		index, err = metaindex.Open(ctx, flags.Index.Path)
+		if err != nil {
+			return err
+		}
+		defer func() { err = errs.Combine(err, index.Close()) }()
+		gw = miniogw.Indexing(gw, zap.L().Named("index"), index)
+	}
 
-	return miniogw.NewStorjGateway(config), nil
-}
+	// the exporter is created before the admin api, which queries its reports
+	var meter *billing.Meter
+	var exporter *billing.Exporter
+	if flags.Billing.Interval > 0 {
+		meter = billing.NewMeter()
+		exporter, err = flags.newBillingExporter(ctx, meter)
+		if err != nil {
+			return err
+		}
+	}
 
-func (flags *GatewayFlags) newUplinkConfig(ctx context.Context) uplink.Config {
-	// Transform the gateway config flags to the uplink config object
-	config := uplink.Config{}
-	config.DialTimeout = flags.Client.DialTimeout
-	return config
+	if flags.Admin.Address != "" {
+		usage := miniogw.NewUsage(flags.newUplinkConfig(ctx), flags.Admin.UsageCacheTTL)
+		admin := miniogw.NewAdmin(zap.L().Named("admin"), usage)
+		admin.SetSettings(flags.Admin.Token, runSettings)
+		if index != nil {
+			admin.SetSearch(miniogw.NewSearch(flags.newUplinkConfig(ctx), index))
+		}
+		if exporter != nil {
+			admin.SetUsageReports(exporter)
+		}
+		go func() {
+			if err := miniogw.ServeAdmin(ctx, zap.L(), flags.Admin.Address, admin); err != nil {
+				zap.L().Error("admin api failed", zap.Error(err))
+			}
+		}()
+	}
+
+	if flags.Reconcile.Interval > 0 {
+		reconciler, err := flags.newReconciler(ctx)
+		if err != nil {
+			return err
+		}
+		go func() { _ = reconciler.Run(ctx) }()
+	}
+
+	if exporter != nil {
+		go func() { _ = exporter.Run(ctx) }()
+		gw = miniogw.Metering(gw, meter)
+	}
+
+	objectives, err := slo.ParseObjectives(flags.SLO.Objectives)
+	if err != nil {
+		return err
+	}
+	if len(objectives) > 0 {
+		gw = miniogw.ServiceLevels(gw, slo.NewTracker(objectives))
+	}
+
+	gw = miniogw.Streaming(gw, flags.Stream)
+	gw = miniogw.ServerTiming(gw, flags.Timing)
+	gw = miniogw.SlowRequests(gw, zap.L().Named("slow"), flags.Slow)
+	minio.StartGateway(cliCtx, miniogw.Logging(gw, zap.L()))
+	return errs.New("unexpected minio exit")
 }
 
-// interactive creates the configuration of the gateway interactively.
-func (flags GatewayFlags) interactive(cmd *cobra.Command, setupDir string) error {
-	overrides := make(map[string]interface{})
+// NewGateway creates a new minio Gateway. The satellites of its requests are
+// reported to health, which may be nil.
+func (flags GatewayFlags) NewGateway(ctx context.Context, health *miniogw.Health) (gw minio.Gateway, err error) {
+	config := flags.newUplinkConfig(ctx)
 
-	tracingEnabled, err := wizard.PromptForTracing()
+	aliases, err := miniogw.ParseAliases(flags.Buckets.Aliases)
 	if err != nil {
-		return Error.Wrap(err)
+		return nil, err
 	}
-	if tracingEnabled {
-		overrides["tracing.enabled"] = true
-		overrides["tracing.sample"] = 0.1
-		overrides["tracing.interval"] = 30 * time.Second
+	quotas, err := miniogw.ParseQuotas(flags.Quotas.Buckets)
+	if err != nil {
+		return nil, err
 	}
 
-	err = process.SaveConfig(cmd, filepath.Join(setupDir, "config.yaml"),
-		process.SaveConfigWithOverrides(overrides),
-		process.SaveConfigRemovingDeprecated())
+	plugins, err := plugin.Load(ctx, zap.L().Named("plugins"), flags.Plugins)
 	if err != nil {
-		return Error.Wrap(err)
+		return nil, err
+	}
+	go func() {
+		<-ctx.Done()
+		_ = plugins.Close()
+	}()
+
+	gateway := miniogw.NewStorjGateway(config)
+	gateway.SetProjectsConfig(flags.Projects)
+	gateway.SetHealth(health)
+	gateway.SetPlugins(plugins)
+	if plugins.Implements(plugin.KindAuthResolver) {
+		credentials := miniogw.NewCredentials(zap.L().Named("credentials"), flags.Degraded, plugins.ResolveAccess)
+		gateway.SetCredentials(credentials)
+		health.SetCredentials(credentials)
+	}
+	if flags.Anomaly.Enabled {
+		detector, err := flags.newAnomalyDetector(ctx, plugins)
+		if err != nil {
+			return nil, err
+		}
+		gateway.SetAnomalyDetector(detector)
+	}
+	if flags.Routes.File != "" {
+		router, err := miniogw.LoadRoutes(flags.Routes.File)
+		if err != nil {
+			return nil, err
+		}
+		gateway.SetRouter(router)
 	}
 
-	fmt.Println(`
-Your S3 Gateway is configured and ready to use!
+	var policies map[string]*miniogw.NamingPolicy
+	if flags.Naming.File != "" {
+		policies, err = miniogw.LoadNamingPolicies(flags.Naming.File)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-Some things to try next:
+	hook, err := script.Load(flags.Script)
+	if err != nil {
+		return nil, err
+	}
 
-* See https://documentation.tardigrade.io/api-reference/s3-gateway for some example commands`)
+	var features *miniogw.Features
+	if flags.Features.File != "" {
+		features, err = miniogw.LoadFeatures(flags.Features.File)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	return nil
+	// immutable buckets, naming policies, tombstones, deduplication, quotas
+	// and the request script are for the buckets that aliases resolve to, and
+	// the keys that the script rewrites have to follow the naming policies;
+	// skipped uploads don't count against quotas, and only deletes that
+	// immutable buckets allow are remembered
+	intercepted := miniogw.Intercept(gateway, zap.L().Named("plugins"), plugins)
+	limited := miniogw.Quotas(intercepted, quotas, flags.Quotas.ReconcileInterval)
+	deduplicated := miniogw.Deduplicating(limited, flags.Buckets.Deduplicate, features)
+	tombstoned := miniogw.Tombstones(deduplicated, flags.Deletes)
+	restricted := miniogw.Immutable(miniogw.Naming(tombstoned, policies),
+		miniogw.ParseList(flags.Buckets.Immutable),
+		miniogw.ParseList(flags.Buckets.ImmutableAdmins))
+	scripted := miniogw.Scripted(restricted, zap.L().Named("script"), hook)
+
+	return miniogw.Aliasing(scripted, aliases), nil
 }
 
-/*	`setUsageFunc` is a bit unconventional but cobra didn't leave much room for
-	extensibility here. `cmd.SetUsageTemplate` is fairly useless for our case without
-	the ability to add to the template's function map (see: https://golang.org/pkg/text/template/#hdr-Functions).
+// newAnomalyDetector creates the anomaly detector and starts delivering its
+// alerts to the webhook, if one is configured, and to the notifier plugins.
+func (flags *GatewayFlags) newAnomalyDetector(ctx context.Context, plugins *plugin.Plugins) (*anomaly.Detector, error) {
+	log := zap.L().Named("anomaly")
 
-	Because we can't alter what `cmd.Usage` generates, we have to edit it afterwards.
-	In order to hook this function *and* get the usage string, we have to juggle the
-	`cmd.usageFunc` between our hook and `nil`, so that we can get the usage string
-	from the default usage func.
-*/
-func setUsageFunc(cmd *cobra.Command) {
-	if findBoolFlagEarly("advanced") {
-		return
+	var country anomaly.CountryLookup
+	if flags.Anomaly.GeoIPDatabase != "" {
+		geoip, err := anomaly.OpenGeoIP(flags.Anomaly.GeoIPDatabase)
+		if err != nil {
+			return nil, Error.Wrap(err)
+		}
+		go func() {
+			<-ctx.Done()
+			_ = geoip.Close()
+		}()
+		country = geoip.Country
 	}
 
-	reset := func() (set func()) {
-		original := cmd.UsageFunc()
-		cmd.SetUsageFunc(nil)
+	var notifiers notifiers
+	if flags.Anomaly.WebhookURL != "" {
+		webhook := anomaly.NewWebhook(log, flags.Anomaly.WebhookURL)
+		go func() { _ = webhook.Run(ctx) }()
+		notifiers = append(notifiers, webhook)
+	}
+	if plugins.Implements(plugin.KindNotifier) {
+		notifiers = append(notifiers, pluginNotifier{plugins: plugins})
+	}
 
-		return func() {
-			cmd.SetUsageFunc(original)
-		}
+	var notifier anomaly.Notifier
+	if len(notifiers) > 0 {
+		notifier = notifiers
 	}
+	return anomaly.NewDetector(log, flags.Anomaly, country, notifier), nil
+}
 
-	cmd.SetUsageFunc(func(cmd *cobra.Command) error {
-		set := reset()
-		usageStr := cmd.UsageString()
-		defer set()
+// notifiers delivers alerts to every notifier.
+type notifiers []anomaly.Notifier
 
-		usageScanner := bufio.NewScanner(bytes.NewBufferString(usageStr))
+// Notify implements anomaly.Notifier.
+func (notifiers notifiers) Notify(alert anomaly.Alert) {
+	for _, notifier := range notifiers {
+		notifier.Notify(alert)
+	}
+}
 
-		var basicFlags []string
-		cmd.Flags().VisitAll(func(flag *pflag.Flag) {
-			basic, ok := flag.Annotations[cfgstruct.BasicHelpAnnotationName]
-			if ok && len(basic) == 1 && basic[0] == "true" {
-				basicFlags = append(basicFlags, flag.Name)
-			}
-		})
+// pluginNotifier delivers alerts to the notifier plugins.
+type pluginNotifier struct {
+	plugins *plugin.Plugins
+}
 
-		for usageScanner.Scan() {
-			line := usageScanner.Text()
-			trimmedLine := strings.TrimSpace(line)
+// Notify implements anomaly.Notifier.
+func (notifier pluginNotifier) Notify(alert anomaly.Alert) {
+	notifier.plugins.Notify(plugin.Notification{
+		Source: "anomaly",
+		Kind:   string(alert.Kind),
+		Detail: alert.Detail,
+		Time:   alert.Time,
+		Fields: map[string]string{
+			"fingerprint": alert.Fingerprint,
+			"operation":   alert.Operation,
+			"remote_ip":   alert.RemoteIP,
+		},
+	})
+}
 
-			var flagName string
-			if _, err := fmt.Sscanf(trimmedLine, "--%s", &flagName); err != nil {
-				fmt.Println(line)
-				continue
-			}
+// newReconciler creates the reconciler of the storage usage of the configured
+// project with the satellite.
+func (flags *GatewayFlags) newReconciler(ctx context.Context) (*reconcile.Reconciler, error) {
+	config := flags.Reconcile
+	if config.AccessGrant == "" || config.ProjectID == "" || config.ConsoleAddress == "" || config.ConsoleToken == "" {
+		return nil, Error.New("reconciliation needs an access grant, project id, console address and console token")
+	}
 
-			// TODO: properly filter flags with short names
-			if !strings.HasPrefix(trimmedLine, "--") {
-				fmt.Println(line)
-			}
+	// the usage is recomputed every time, since reconciliation is infrequent
+	usage := miniogw.NewUsage(flags.newUplinkConfig(ctx), 0)
+	gateway := func(ctx context.Context) (int64, error) {
+		info, err := usage.DataUsage(ctx, config.AccessGrant)
+		return int64(info.ObjectsTotalSize), err
+	}
+	console := reconcile.NewConsole(config.ConsoleAddress, config.ConsoleToken, config.ProjectID)
 
-			for _, basicFlag := range basicFlags {
-				if basicFlag == flagName {
-					fmt.Println(line)
-				}
-			}
-		}
-		return nil
-	})
+	return reconcile.NewReconciler(zap.L().Named("reconcile"), config, gateway, console.StorageUsed), nil
 }
 
-func findBoolFlagEarly(flagName string) bool {
-	for i, arg := range os.Args {
-		arg := arg
-		argHasPrefix := func(format string, args ...interface{}) bool {
-			return strings.HasPrefix(arg, fmt.Sprintf(format, args...))
-		}
+// newBillingExporter creates the exporter of the usage that meter records,
+// which uploads the reports to the configured bucket.
+func (flags *GatewayFlags) newBillingExporter(ctx context.Context, meter *billing.Meter) (*billing.Exporter, error) {
+	config := flags.Billing
+	if config.AccessGrant == "" || config.Bucket == "" {
+		return nil, Error.New("billing export needs an access grant and a bucket")
+	}
+	access, err := uplink.ParseAccess(config.AccessGrant)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
 
-		if !argHasPrefix("--%s", flagName) {
-			continue
+	uplinkConfig := flags.newUplinkConfig(ctx)
+	upload := func(ctx context.Context, key string, data []byte) (err error) {
+		project, err := uplinkConfig.OpenProject(ctx, access)
+		if err != nil {
+			return err
 		}
+		defer func() { err = errs.Combine(err, project.Close()) }()
 
-		// NB: covers `--<flagName> false` usage
-		if i+1 != len(os.Args) {
-			next := os.Args[i+1]
-			if next == "false" {
-				return false
-			}
+		upload, err := project.UploadObject(ctx, config.Bucket, key, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := upload.Write(data); err != nil {
+			return errs.Combine(err, upload.Abort())
 		}
+		return upload.Commit()
+	}
+
+	return billing.NewExporter(zap.L().Named("billing"), config, meter, upload)
+}
 
-		if !argHasPrefix("--%s=false", flagName) {
-			return true
+func (flags *GatewayFlags) newUplinkConfig(ctx context.Context) uplink.Config {
+	// Transform the gateway config flags to the uplink config object
+	config := uplink.Config{}
+	config.DialTimeout = flags.Client.DialTimeout
+	return config
+}
+
+// interactive creates the configuration of the gateway interactively.
+func (flags GatewayFlags) interactive(cmd *cobra.Command, setupDir string) error {
+	overrides := make(map[string]interface{})
+
+	// prompts would corrupt the json document, so json output implies that
+	// the user did not consent to tracing.
+	var tracingEnabled bool
+	if !jsonOutput() {
+		var err error
+		tracingEnabled, err = wizard.PromptForTracing()
+		if err != nil {
+			return Error.Wrap(err)
 		}
 	}
-	return false
+	if tracingEnabled {
+		overrides["tracing.enabled"] = true
+		overrides["tracing.sample"] = 0.1
+		overrides["tracing.interval"] = 30 * time.Second
+	}
+
+	configFile := filepath.Join(setupDir, "config.yaml")
+	err := process.SaveConfig(cmd, configFile,
+		process.SaveConfigWithOverrides(overrides),
+		process.SaveConfigRemovingDeprecated())
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	return Error.Wrap(printResult(`
+Your S3 Gateway is configured and ready to use!
+
+Some things to try next:
+
+* See https://documentation.tardigrade.io/api-reference/s3-gateway for some example commands`,
+		struct {
+			ConfigDir      string `json:"config_dir"`
+			ConfigFile     string `json:"config_file"`
+			TracingEnabled bool   `json:"tracing_enabled"`
+		}{
+			ConfigDir:      setupDir,
+			ConfigFile:     configFile,
+			TracingEnabled: tracingEnabled,
+		}))
 }
 
 func main() {
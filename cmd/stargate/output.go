@@ -0,0 +1,52 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	// outputText is the default human readable output format.
+	outputText = "text"
+	// outputJSON is the machine readable output format.
+	outputJSON = "json"
+)
+
+// outputFormat is the value of the global --output flag.
+var outputFormat = outputText
+
+// stdout is where commands print their results, which tests replace.
+var stdout io.Writer = os.Stdout
+
+// checkOutputFormat validates the value of the --output flag.
+func checkOutputFormat() error {
+	switch outputFormat {
+	case outputText, outputJSON:
+		return nil
+	default:
+		return Error.New("invalid output format %q: must be %q or %q", outputFormat, outputText, outputJSON)
+	}
+}
+
+// jsonOutput returns true if commands should emit json instead of free-form text.
+func jsonOutput() bool {
+	return outputFormat == outputJSON
+}
+
+// printResult prints text in the text output format and v encoded as a single
+// json document in the json output format.
+func printResult(text string, v interface{}) error {
+	if !jsonOutput() {
+		_, err := fmt.Fprintln(stdout, text)
+		return err
+	}
+
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,50 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// runWithOutput runs fn with the results of commands printed to a buffer in
+// the output format, and returns what was printed.
+func runWithOutput(t *testing.T, format string, fn func() error) (string, error) {
+	prevStdout, prevFormat := stdout, outputFormat
+	defer func() { stdout, outputFormat = prevStdout, prevFormat }()
+
+	var out bytes.Buffer
+	stdout, outputFormat = &out, format
+	err := fn()
+	return out.String(), err
+}
+
+func TestPrintResult(t *testing.T) {
+	result := struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}{Name: "records", Count: 2}
+
+	out, err := runWithOutput(t, outputText, func() error { return printResult("Wrote 2 records.", result) })
+	require.NoError(t, err)
+	require.Equal(t, "Wrote 2 records.\n", out)
+
+	out, err = runWithOutput(t, outputJSON, func() error { return printResult("Wrote 2 records.", result) })
+	require.NoError(t, err)
+	require.Equal(t, "{\n  \"name\": \"records\",\n  \"count\": 2\n}\n", out)
+}
+
+func TestCheckOutputFormat(t *testing.T) {
+	defer func(prev string) { outputFormat = prev }(outputFormat)
+
+	for _, format := range []string{outputText, outputJSON} {
+		outputFormat = format
+		require.NoError(t, checkOutputFormat())
+	}
+
+	outputFormat = "yaml"
+	require.Error(t, checkOutputFormat())
+}
@@ -0,0 +1,88 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"storj.io/private/process"
+	"storj.io/stargate/internal/s3check"
+)
+
+// S3CheckFlags configures the s3check command.
+type S3CheckFlags struct {
+	Endpoint     string `help:"address of the S3 endpoint to check, like localhost:7777" default:""`
+	AccessKey    string `help:"access key for the endpoint" default:""`
+	SecretKey    string `help:"secret key for the endpoint" default:""`
+	NoSSL        bool   `help:"connect to the endpoint with http instead of https" default:"false"`
+	Categories   string `help:"comma separated categories of checks to run, or every check when empty" default:""`
+	BucketPrefix string `help:"prefix of the names of the buckets that the checks create and delete again" default:"s3check-"`
+}
+
+var (
+	s3checkCmd = &cobra.Command{
+		Use:   "s3check",
+		Short: "Check which S3 features an endpoint supports",
+		Long: `Runs a curated suite of S3 conformance checks, in the categories of the ceph
+s3-tests, against --endpoint and reports which pass. The checks run in buckets
+that are created and deleted again. The command fails if any check fails, so
+--categories can limit it to the features that a deployment relies on.
+Categories: ` + strings.Join(s3check.Categories(s3check.Checks), ", ") + `.`,
+		Args: cobra.NoArgs,
+		RunE: cmdS3Check,
+	}
+
+	s3checkCfg S3CheckFlags
+)
+
+func cmdS3Check(cmd *cobra.Command, args []string) (err error) {
+	if err := prepareAuthCommand(&s3checkCfg); err != nil {
+		return err
+	}
+	if s3checkCfg.Endpoint == "" {
+		return Error.New("--endpoint is required")
+	}
+	checks, err := s3check.Select(s3check.Checks, s3checkCfg.Categories)
+	if err != nil {
+		return err
+	}
+
+	ctx, _ := process.Ctx(cmd)
+
+	report, err := s3check.Run(ctx, s3check.Config{
+		Endpoint:     s3checkCfg.Endpoint,
+		AccessKey:    s3checkCfg.AccessKey,
+		SecretKey:    s3checkCfg.SecretKey,
+		NoSSL:        s3checkCfg.NoSSL,
+		BucketPrefix: s3checkCfg.BucketPrefix,
+	}, checks)
+	if err != nil {
+		return err
+	}
+
+	var text strings.Builder
+	for _, result := range report.Results {
+		status := "PASS"
+		if !result.Passed {
+			status = "FAIL"
+		}
+		fmt.Fprintf(&text, "%s  %-10s %s", status, result.Category, result.Name)
+		if result.Error != "" {
+			fmt.Fprintf(&text, ": %s", result.Error)
+		}
+		text.WriteString("\n")
+	}
+	fmt.Fprintf(&text, "%d of %d checks passed.", report.Passed, len(report.Results))
+
+	if err := printResult(text.String(), report); err != nil {
+		return err
+	}
+	if report.Failed > 0 {
+		return Error.New("%d of %d checks failed", report.Failed, len(report.Results))
+	}
+	return nil
+}
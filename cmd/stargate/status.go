@@ -0,0 +1,98 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/zeebo/errs"
+
+	"storj.io/private/process"
+	"storj.io/stargate/miniogw"
+)
+
+// StatusFlags configures the status command.
+type StatusFlags struct {
+	Server  miniogw.ServerConfig
+	Timeout time.Duration `help:"how long to wait for the gateway to answer" default:"10s"`
+}
+
+var (
+	statusCmd = &cobra.Command{
+		Use:   "status",
+		Short: "Check whether the configured gateway is running",
+		Args:  cobra.NoArgs,
+		RunE:  cmdStatus,
+	}
+
+	statusCfg StatusFlags
+)
+
+func cmdStatus(cmd *cobra.Command, args []string) (err error) {
+	if err := checkOutputFormat(); err != nil {
+		return err
+	}
+	ctx, _ := process.Ctx(cmd)
+
+	host, port, err := net.SplitHostPort(statusCfg.Server.Address)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	endpoint := "http://" + net.JoinHostPort(host, port)
+
+	result := struct {
+		Endpoint string `json:"endpoint"`
+		Live     bool   `json:"live"`
+		Error    string `json:"error,omitempty"`
+	}{
+		Endpoint: endpoint,
+	}
+	liveErr := checkLive(ctx, endpoint, statusCfg.Timeout)
+	if liveErr != nil {
+		result.Error = liveErr.Error()
+	}
+	result.Live = liveErr == nil
+
+	text := fmt.Sprintf("Endpoint: %s\nLive:     %t", result.Endpoint, result.Live)
+	if liveErr != nil {
+		text += "\nError:    " + result.Error
+	}
+	if err := printResult(text, result); err != nil {
+		return Error.Wrap(err)
+	}
+	if liveErr != nil {
+		return Error.New("the gateway at %s is not live", endpoint)
+	}
+	return nil
+}
+
+// checkLive returns an error unless the minio liveness endpoint of the gateway
+// at endpoint answers in time.
+func checkLive(ctx context.Context, endpoint string, timeout time.Duration) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodGet, endpoint+"/minio/health/live", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer func() { err = errs.Combine(err, resp.Body.Close()) }()
+
+	if resp.StatusCode != http.StatusOK {
+		return errs.New("liveness check answered %s", resp.Status)
+	}
+	return nil
+}
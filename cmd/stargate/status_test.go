@@ -0,0 +1,49 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusOutput(t *testing.T) {
+	live := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/minio/health/live" || !live {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	defer func(prev StatusFlags) { statusCfg = prev }(statusCfg)
+	statusCfg.Server.Address = strings.TrimPrefix(server.URL, "http://")
+	statusCfg.Timeout = time.Minute
+	status := func() error { return cmdStatus(&cobra.Command{}, nil) }
+
+	out, err := runWithOutput(t, outputText, status)
+	require.NoError(t, err)
+	require.Equal(t, "Endpoint: "+server.URL+"\nLive:     true\n", out)
+
+	out, err = runWithOutput(t, outputJSON, status)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"endpoint": "`+server.URL+`", "live": true}`, out)
+
+	// the result is printed before the command fails for gateways that
+	// aren't live
+	live = false
+	out, err = runWithOutput(t, outputText, status)
+	require.Error(t, err)
+	require.Equal(t, "Endpoint: "+server.URL+"\nLive:     false\nError:    liveness check answered 503 Service Unavailable\n", out)
+
+	out, err = runWithOutput(t, outputJSON, status)
+	require.Error(t, err)
+	require.JSONEq(t, `{"endpoint": "`+server.URL+`", "live": false, "error": "liveness check answered 503 Service Unavailable"}`, out)
+}
@@ -0,0 +1,105 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"storj.io/private/process"
+	"storj.io/private/version"
+	"storj.io/stargate/internal/selfupdate"
+)
+
+// UpdateFlags configures the update command.
+type UpdateFlags struct {
+	Release  selfupdate.Config
+	Check    bool `help:"only check whether a newer release is available" default:"false"`
+	Rollback bool `help:"restore the binary that the last update replaced" default:"false"`
+}
+
+var (
+	updateCmd = &cobra.Command{
+		Use:   "update",
+		Short: "Update the gateway binary to the latest release",
+		Long: `Fetches the manifest of the latest release from --release.manifest-url and
+verifies its signature with --release.public-key. When the release is newer,
+the binary for this platform is downloaded, verified against the manifest and
+swapped with the running binary, which is kept so that --rollback can restore
+it. The gateway has to be restarted to run the new binary.`,
+		Args: cobra.NoArgs,
+		RunE: cmdUpdate,
+	}
+
+	updateCfg UpdateFlags
+)
+
+func cmdUpdate(cmd *cobra.Command, args []string) (err error) {
+	if err := checkOutputFormat(); err != nil {
+		return err
+	}
+
+	path, err := os.Executable()
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	if path, err = filepath.EvalSymlinks(path); err != nil {
+		return Error.Wrap(err)
+	}
+
+	if updateCfg.Rollback {
+		if err := selfupdate.Rollback(path); err != nil {
+			return err
+		}
+		return printResult(fmt.Sprintf("Restored the previous binary at %s.", path), struct {
+			Path string `json:"path"`
+		}{path})
+	}
+
+	ctx, _ := process.Ctx(cmd)
+
+	updater, err := selfupdate.NewUpdater(updateCfg.Release, http.DefaultClient)
+	if err != nil {
+		return err
+	}
+	latest, err := updater.Latest(ctx)
+	if err != nil {
+		return err
+	}
+
+	current := version.Build.Version.String()
+	result := struct {
+		Current   string `json:"current"`
+		Latest    string `json:"latest"`
+		Available bool   `json:"available"`
+		Installed bool   `json:"installed"`
+	}{
+		Current:   current,
+		Latest:    latest.Version,
+		Available: selfupdate.Newer(current, latest.Version),
+	}
+
+	if !result.Available {
+		return printResult(fmt.Sprintf("%s is the latest release.", current), result)
+	}
+	if updateCfg.Check {
+		return printResult(fmt.Sprintf("%s is available (running %s).", latest.Version, current), result)
+	}
+
+	binary, err := latest.Binary(selfupdate.Platform())
+	if err != nil {
+		return err
+	}
+	if err := updater.Install(ctx, binary, path); err != nil {
+		return err
+	}
+	result.Installed = true
+
+	return printResult(fmt.Sprintf("Updated %s from %s to %s. Restart the gateway to run it.",
+		path, current, latest.Version), result)
+}
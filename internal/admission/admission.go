@@ -0,0 +1,239 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package admission limits how many requests are served at once, and
+// schedules the requests that wait fairly across tenants.
+package admission
+
+import (
+	"container/heap"
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
+
+	"storj.io/stargate/internal/tagged"
+)
+
+var (
+	mon = monkit.Package()
+
+	// Error is the errs class of admission errors.
+	Error = errs.Class("admission")
+
+	// ErrQueueFull is returned when too many requests wait already.
+	ErrQueueFull = Error.New("queue full")
+
+	// ErrQueueTimeout is returned when a request waited for too long.
+	ErrQueueTimeout = Error.New("queue timeout")
+)
+
+// Config configures admission.
+type Config struct {
+	MaxConcurrent int           `help:"how many requests are served at once; requests beyond that wait in a queue that is served fairly across access keys; disabled when 0" default:"0"`
+	MaxQueued     int           `help:"how many requests may wait in the queue before further requests are rejected" default:"1000"`
+	QueueTimeout  time.Duration `help:"how long a request may wait in the queue before it is rejected" default:"30s"`
+	Weights       string        `help:"comma separated weights of tenants, like 0011223344556677=4 for the access key with that fingerprint; tenants weigh 1 by default" default:""`
+}
+
+// ParseWeights parses comma separated weights of tenants, like a=4,b=0.5.
+func ParseWeights(s string) (map[string]float64, error) {
+	weights := make(map[string]float64)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		eq := strings.IndexByte(entry, '=')
+		if eq < 0 {
+			return nil, Error.New("invalid weight %q: expected tenant=weight", entry)
+		}
+		tenant := strings.TrimSpace(entry[:eq])
+		weight, err := strconv.ParseFloat(strings.TrimSpace(entry[eq+1:]), 64)
+		if err != nil || weight <= 0 || tenant == "" {
+			return nil, Error.New("invalid weight %q: expected tenant=weight with a positive weight", entry)
+		}
+		weights[tenant] = weight
+	}
+	return weights, nil
+}
+
+// waiter is a request that waits in the queue.
+type waiter struct {
+	tenant string
+	start  float64
+	finish float64
+	seq    uint64
+	index  int
+
+	admitted bool
+	ready    chan struct{}
+}
+
+// Queue admits up to a maximum of concurrent requests. When that many are
+// served, requests wait and are admitted in the order of weighted fair
+// queueing: every tenant gets a share of the admissions in proportion to its
+// weight, so that a burst of one tenant only delays its own requests.
+//
+// Every request costs the same, since how long a request is served isn't
+// known when it is admitted.
+type Queue struct {
+	config  Config
+	weights map[string]float64
+
+	mu      sync.Mutex
+	active  int
+	virtual float64
+	finish  map[string]float64
+	waiting waiters
+	seq     uint64
+}
+
+// New constructs a Queue with the limits of config and the weights of
+// tenants.
+func New(config Config, weights map[string]float64) *Queue {
+	return &Queue{
+		config:  config,
+		weights: weights,
+		finish:  make(map[string]float64),
+	}
+}
+
+// weight returns the weight of the tenant.
+func (queue *Queue) weight(tenant string) float64 {
+	if weight, ok := queue.weights[tenant]; ok {
+		return weight
+	}
+	return 1
+}
+
+// Acquire waits until a request of the tenant is admitted, and returns a func
+// that must be called when the request is done. It fails when the queue is
+// full, when the request waited for the queue timeout or when ctx is
+// canceled.
+func (queue *Queue) Acquire(ctx context.Context, tenant string) (release func(), err error) {
+	queue.mu.Lock()
+	if queue.active < queue.config.MaxConcurrent && len(queue.waiting) == 0 {
+		queue.active++
+		queue.mu.Unlock()
+		return queue.release, nil
+	}
+	if len(queue.waiting) >= queue.config.MaxQueued {
+		queue.mu.Unlock()
+		tagged.Counter(mon, "admission_rejected", monkit.NewSeriesTag("reason", "full")).Inc(1)
+		return nil, ErrQueueFull
+	}
+
+	start := queue.virtual
+	if finish := queue.finish[tenant]; finish > start {
+		start = finish
+	}
+	queue.seq++
+	w := &waiter{
+		tenant: tenant,
+		start:  start,
+		finish: start + 1/queue.weight(tenant),
+		seq:    queue.seq,
+		ready:  make(chan struct{}),
+	}
+	queue.finish[tenant] = w.finish
+	heap.Push(&queue.waiting, w)
+	queue.mu.Unlock()
+
+	waited := time.Now()
+	defer func() { mon.FloatVal("admission_wait_seconds").Observe(time.Since(waited).Seconds()) }()
+
+	var timeout <-chan time.Time
+	if queue.config.QueueTimeout > 0 {
+		timer := time.NewTimer(queue.config.QueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-w.ready:
+		return queue.release, nil
+	case <-timeout:
+		err = ErrQueueTimeout
+		tagged.Counter(mon, "admission_rejected", monkit.NewSeriesTag("reason", "timeout")).Inc(1)
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	queue.mu.Lock()
+	admitted := w.admitted
+	if !admitted {
+		heap.Remove(&queue.waiting, w.index)
+	}
+	queue.mu.Unlock()
+
+	// the request was admitted while it gave up
+	if admitted {
+		queue.release()
+	}
+	return nil, err
+}
+
+// release hands the slot of a request that is done to the waiting request
+// with the earliest finish tag, or frees it.
+func (queue *Queue) release() {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	if len(queue.waiting) == 0 {
+		queue.active--
+		// no tenant is behind the others while nothing waits
+		queue.virtual = 0
+		queue.finish = make(map[string]float64)
+		return
+	}
+
+	w := heap.Pop(&queue.waiting).(*waiter)
+	queue.virtual = w.start
+	w.admitted = true
+	close(w.ready)
+}
+
+// Stats returns how many requests are served and wait.
+func (queue *Queue) Stats() (active, waiting int) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	return queue.active, len(queue.waiting)
+}
+
+// waiters is a heap of waiters ordered by finish tag, and by arrival for equal
+// finish tags.
+type waiters []*waiter
+
+func (h waiters) Len() int { return len(h) }
+
+func (h waiters) Less(i, k int) bool {
+	if h[i].finish != h[k].finish {
+		return h[i].finish < h[k].finish
+	}
+	return h[i].seq < h[k].seq
+}
+
+func (h waiters) Swap(i, k int) {
+	h[i], h[k] = h[k], h[i]
+	h[i].index = i
+	h[k].index = k
+}
+
+func (h *waiters) Push(x interface{}) {
+	w := x.(*waiter)
+	w.index = len(*h)
+	*h = append(*h, w)
+}
+
+func (h *waiters) Pop() interface{} {
+	old := *h
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return w
+}
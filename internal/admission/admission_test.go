@@ -0,0 +1,132 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package admission_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/stargate/internal/admission"
+)
+
+// order holds the only slot of queue while the requests of tenants wait in
+// their order, and returns the order in which they are admitted.
+func order(t *testing.T, queue *admission.Queue, tenants []string) []string {
+	ctx := context.Background()
+
+	release, err := queue.Acquire(ctx, "holder")
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	var admitted []string
+	var wg sync.WaitGroup
+	for i, tenant := range tenants {
+		tenant := tenant
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := queue.Acquire(ctx, tenant)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			admitted = append(admitted, tenant)
+			mu.Unlock()
+			release()
+		}()
+
+		// wait until the request waits, so that the requests arrive in order
+		for {
+			if _, waiting := queue.Stats(); waiting == i+1 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	release()
+	wg.Wait()
+	return admitted
+}
+
+func TestQueue_Fair(t *testing.T) {
+	queue := admission.New(admission.Config{MaxConcurrent: 1, MaxQueued: 100}, nil)
+
+	// a burst of a doesn't delay b behind all of it
+	admitted := order(t, queue, []string{"a", "a", "a", "a", "a", "a", "b", "b"})
+	require.Equal(t, []string{"a", "b", "a", "b", "a", "a", "a", "a"}, admitted)
+
+	active, waiting := queue.Stats()
+	require.Zero(t, active)
+	require.Zero(t, waiting)
+}
+
+func TestQueue_Weights(t *testing.T) {
+	weights, err := admission.ParseWeights("a=2, b=1")
+	require.NoError(t, err)
+	queue := admission.New(admission.Config{MaxConcurrent: 1, MaxQueued: 100}, weights)
+
+	// a gets twice the admissions of b
+	admitted := order(t, queue, []string{"b", "b", "b", "a", "a", "a", "a"})
+	require.Equal(t, []string{"a", "b", "a", "a", "b", "a", "b"}, admitted)
+}
+
+func TestQueue_Rejected(t *testing.T) {
+	ctx := context.Background()
+
+	queue := admission.New(admission.Config{MaxConcurrent: 1, MaxQueued: 1, QueueTimeout: 10 * time.Millisecond}, nil)
+	release, err := queue.Acquire(ctx, "a")
+	require.NoError(t, err)
+
+	_, err = queue.Acquire(ctx, "a")
+	require.Equal(t, admission.ErrQueueTimeout, err)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = queue.Acquire(canceled, "a")
+	require.True(t, errors.Is(err, context.Canceled), err)
+	release()
+
+	// a request that waits fills the queue
+	queue = admission.New(admission.Config{MaxConcurrent: 1, MaxQueued: 1}, nil)
+	release, err = queue.Acquire(ctx, "a")
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		release, err := queue.Acquire(ctx, "b")
+		if err == nil {
+			release()
+		}
+	}()
+	for {
+		if _, waiting := queue.Stats(); waiting == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	_, err = queue.Acquire(ctx, "c")
+	require.Equal(t, admission.ErrQueueFull, err)
+
+	release()
+	<-done
+}
+
+func TestParseWeights(t *testing.T) {
+	weights, err := admission.ParseWeights("")
+	require.NoError(t, err)
+	require.Empty(t, weights)
+
+	for _, invalid := range []string{"a", "a=0", "a=-1", "=2", "a=x"} {
+		_, err := admission.ParseWeights(invalid)
+		require.Error(t, err, invalid)
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package anomaly watches how access keys are used and raises alerts for
+// unusual usage that may mean a credential was compromised.
+package anomaly
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"go.uber.org/zap"
+
+	"storj.io/stargate/internal/tagged"
+)
+
+var mon = monkit.Package()
+
+// Config configures the anomaly Detector.
+type Config struct {
+	Enabled bool `help:"raise alerts for unusual use of access keys" default:"false"`
+
+	NewCountry    bool   `help:"alert when an access key is used from a country it wasn't used from before" default:"true"`
+	GeoIPDatabase string `help:"path to a MaxMind GeoIP2 or GeoLite2 country database used to find the country of clients" default:""`
+
+	VolumeSpike       bool          `help:"alert when the requests of an access key spike" default:"true"`
+	VolumeWindow      time.Duration `help:"length of the windows whose request counts are compared to detect spikes" default:"1h0m0s"`
+	VolumeSpikeFactor float64       `help:"how many times more requests than in the previous window count as a spike" default:"10"`
+	VolumeMinimum     int64         `help:"minimum number of requests in a window to count as a spike" default:"1000"`
+
+	FirstDelete bool `help:"alert the first time an access key deletes something" default:"true"`
+
+	WebhookURL string `help:"url to POST alerts to as JSON; alerts are only logged when empty" default:""`
+	MaxKeys    int    `help:"maximum number of access keys that are tracked at once" default:"100000"`
+}
+
+// Kind is the kind of an alert.
+type Kind string
+
+const (
+	// KindNewCountry is raised when an access key is used from a new country.
+	KindNewCountry Kind = "new_country"
+	// KindVolumeSpike is raised when the request volume of an access key spikes.
+	KindVolumeSpike Kind = "volume_spike"
+	// KindFirstDelete is raised the first time an access key deletes something.
+	KindFirstDelete Kind = "first_delete"
+)
+
+// Event is a single use of an access key.
+type Event struct {
+	AccessKey string
+	Operation string
+	RemoteIP  net.IP
+	Time      time.Time
+}
+
+// Alert is an unusual use of an access key. The access key itself is never
+// part of an alert, only a fingerprint of it.
+type Alert struct {
+	Kind        Kind      `json:"kind"`
+	Fingerprint string    `json:"fingerprint"`
+	Operation   string    `json:"operation"`
+	RemoteIP    string    `json:"remote_ip,omitempty"`
+	Detail      string    `json:"detail"`
+	Time        time.Time `json:"time"`
+}
+
+// CountryLookup returns the ISO country code of ip, or "" if it is unknown.
+type CountryLookup func(ip net.IP) string
+
+// Notifier delivers alerts.
+type Notifier interface {
+	Notify(alert Alert)
+}
+
+// Detector tracks the usage of every access key and raises alerts to a
+// Notifier for unusual usage.
+type Detector struct {
+	log      *zap.Logger
+	config   Config
+	country  CountryLookup
+	notifier Notifier
+
+	mu   sync.Mutex
+	keys map[string]*usage
+}
+
+// usage is what is known about the usage of an access key.
+type usage struct {
+	lastSeen  time.Time
+	countries map[string]struct{}
+	deleted   bool
+
+	windowStart    time.Time
+	current        int64
+	previous       int64
+	spikeAlerted   bool
+	previousFilled bool
+}
+
+// NewDetector constructs a Detector. country may be nil if countries are unknown.
+func NewDetector(log *zap.Logger, config Config, country CountryLookup, notifier Notifier) *Detector {
+	return &Detector{
+		log:      log,
+		config:   config,
+		country:  country,
+		notifier: notifier,
+		keys:     make(map[string]*usage),
+	}
+}
+
+// Fingerprint returns the identifier of an access key that is used in alerts.
+func Fingerprint(accessKey string) string {
+	sum := sha256.Sum256([]byte(accessKey))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Observe records the event and raises alerts for it if it is unusual.
+func (d *Detector) Observe(ctx context.Context, event Event) {
+	if event.AccessKey == "" {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	var country string
+	if d.config.NewCountry && d.country != nil && event.RemoteIP != nil {
+		country = d.country(event.RemoteIP)
+	}
+
+	alerts := d.observe(event, country)
+	for _, alert := range alerts {
+		tagged.Counter(mon, "anomaly_alerts", monkit.NewSeriesTag("kind", string(alert.Kind))).Inc(1)
+		d.log.Warn("unusual access key usage",
+			zap.String("kind", string(alert.Kind)),
+			zap.String("fingerprint", alert.Fingerprint),
+			zap.String("operation", alert.Operation),
+			zap.String("remote ip", alert.RemoteIP),
+			zap.String("detail", alert.Detail))
+		if d.notifier != nil {
+			d.notifier.Notify(alert)
+		}
+	}
+}
+
+// observe updates the usage of the access key and returns the alerts for event.
+func (d *Detector) observe(event Event, country string) (alerts []Alert) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	fingerprint := Fingerprint(event.AccessKey)
+	u, ok := d.keys[fingerprint]
+	if !ok {
+		if len(d.keys) >= d.config.MaxKeys {
+			d.prune(event.Time)
+			if len(d.keys) >= d.config.MaxKeys {
+				mon.Counter("anomaly_untracked").Inc(1)
+				return nil
+			}
+		}
+		u = &usage{
+			countries:   make(map[string]struct{}),
+			windowStart: event.Time,
+		}
+		d.keys[fingerprint] = u
+	}
+	u.lastSeen = event.Time
+
+	alert := func(kind Kind, detail string) {
+		alerts = append(alerts, Alert{
+			Kind:        kind,
+			Fingerprint: fingerprint,
+			Operation:   event.Operation,
+			RemoteIP:    ipString(event.RemoteIP),
+			Detail:      detail,
+			Time:        event.Time,
+		})
+	}
+
+	if country != "" {
+		if _, seen := u.countries[country]; !seen {
+			// the first country is the baseline
+			if len(u.countries) > 0 {
+				alert(KindNewCountry, "first use from "+country)
+			}
+			u.countries[country] = struct{}{}
+		}
+	}
+
+	if d.config.FirstDelete && isDelete(event.Operation) && !u.deleted {
+		u.deleted = true
+		alert(KindFirstDelete, "first delete operation")
+	}
+
+	if d.config.VolumeSpike && d.config.VolumeWindow > 0 {
+		for !event.Time.Before(u.windowStart.Add(d.config.VolumeWindow)) {
+			u.previous, u.current = u.current, 0
+			u.previousFilled = true
+			u.spikeAlerted = false
+			u.windowStart = u.windowStart.Add(d.config.VolumeWindow)
+			if u.previous == 0 {
+				// skip ahead over idle windows
+				elapsed := event.Time.Sub(u.windowStart)
+				u.windowStart = u.windowStart.Add(elapsed - elapsed%d.config.VolumeWindow)
+			}
+		}
+		u.current++
+
+		// spikes are only detected once there is a full window to compare against
+		if u.previousFilled && !u.spikeAlerted && u.current >= d.config.VolumeMinimum &&
+			float64(u.current) > d.config.VolumeSpikeFactor*float64(u.previous) {
+			u.spikeAlerted = true
+			alert(KindVolumeSpike, "request volume spiked compared to the previous window")
+		}
+	}
+
+	return alerts
+}
+
+// prune forgets access keys that haven't been used for two volume windows.
+func (d *Detector) prune(now time.Time) {
+	idle := 2 * d.config.VolumeWindow
+	if idle <= 0 {
+		idle = 2 * time.Hour
+	}
+	for fingerprint, u := range d.keys {
+		if now.Sub(u.lastSeen) > idle {
+			delete(d.keys, fingerprint)
+		}
+	}
+}
+
+// isDelete returns whether an S3 operation name deletes something.
+func isDelete(operation string) bool {
+	return strings.HasPrefix(operation, "Delete") || operation == "AbortMultipartUpload"
+}
+
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
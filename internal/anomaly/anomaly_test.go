@@ -0,0 +1,167 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package anomaly_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"storj.io/stargate/internal/anomaly"
+)
+
+type recorder struct {
+	mu     sync.Mutex
+	alerts []anomaly.Alert
+}
+
+func (r *recorder) Notify(alert anomaly.Alert) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.alerts = append(r.alerts, alert)
+}
+
+func (r *recorder) kinds() (kinds []anomaly.Kind) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, alert := range r.alerts {
+		kinds = append(kinds, alert.Kind)
+	}
+	return kinds
+}
+
+func testConfig() anomaly.Config {
+	return anomaly.Config{
+		Enabled:           true,
+		NewCountry:        true,
+		VolumeSpike:       true,
+		VolumeWindow:      time.Hour,
+		VolumeSpikeFactor: 10,
+		VolumeMinimum:     20,
+		FirstDelete:       true,
+		MaxKeys:           100,
+	}
+}
+
+func TestNewCountry(t *testing.T) {
+	ctx := context.Background()
+	countries := map[string]string{"1.1.1.1": "US", "2.2.2.2": "US", "3.3.3.3": "DE"}
+	lookup := func(ip net.IP) string { return countries[ip.String()] }
+
+	notifier := &recorder{}
+	detector := anomaly.NewDetector(zaptest.NewLogger(t), testConfig(), lookup, notifier)
+
+	now := time.Now()
+	for _, ip := range []string{"1.1.1.1", "2.2.2.2", "3.3.3.3", "3.3.3.3", "1.1.1.1"} {
+		detector.Observe(ctx, anomaly.Event{AccessKey: "key", Operation: "GetObject", RemoteIP: net.ParseIP(ip), Time: now})
+	}
+	require.Equal(t, []anomaly.Kind{anomaly.KindNewCountry}, notifier.kinds())
+
+	alert := notifier.alerts[0]
+	require.Equal(t, anomaly.Fingerprint("key"), alert.Fingerprint)
+	require.Equal(t, "3.3.3.3", alert.RemoteIP)
+	require.Contains(t, alert.Detail, "DE")
+
+	// countries are tracked per access key
+	detector.Observe(ctx, anomaly.Event{AccessKey: "other", RemoteIP: net.ParseIP("3.3.3.3"), Time: now})
+	require.Len(t, notifier.kinds(), 1)
+}
+
+func TestFirstDelete(t *testing.T) {
+	ctx := context.Background()
+	notifier := &recorder{}
+	detector := anomaly.NewDetector(zaptest.NewLogger(t), testConfig(), nil, notifier)
+
+	now := time.Now()
+	for _, operation := range []string{"PutObject", "DeleteObject", "DeleteBucket", "GetObject"} {
+		detector.Observe(ctx, anomaly.Event{AccessKey: "key", Operation: operation, Time: now})
+	}
+	require.Equal(t, []anomaly.Kind{anomaly.KindFirstDelete}, notifier.kinds())
+	require.Equal(t, "DeleteObject", notifier.alerts[0].Operation)
+}
+
+func TestVolumeSpike(t *testing.T) {
+	ctx := context.Background()
+	notifier := &recorder{}
+	detector := anomaly.NewDetector(zaptest.NewLogger(t), testConfig(), nil, notifier)
+
+	start := time.Now()
+	observe := func(at time.Time, n int) {
+		for i := 0; i < n; i++ {
+			detector.Observe(ctx, anomaly.Event{AccessKey: "key", Operation: "GetObject", Time: at})
+		}
+	}
+
+	// there is no previous window to compare the first one against
+	observe(start, 100)
+	require.Empty(t, notifier.kinds())
+
+	// steady usage is not a spike
+	observe(start.Add(time.Hour), 100)
+	require.Empty(t, notifier.kinds())
+
+	observe(start.Add(2*time.Hour), 5)
+	require.Empty(t, notifier.kinds())
+
+	// a spike is only alerted once per window
+	observe(start.Add(3*time.Hour), 100)
+	require.Equal(t, []anomaly.Kind{anomaly.KindVolumeSpike}, notifier.kinds())
+}
+
+func TestMaxKeys(t *testing.T) {
+	ctx := context.Background()
+	config := testConfig()
+	config.MaxKeys = 1
+
+	notifier := &recorder{}
+	detector := anomaly.NewDetector(zaptest.NewLogger(t), config, nil, notifier)
+
+	now := time.Now()
+	detector.Observe(ctx, anomaly.Event{AccessKey: "first", Operation: "DeleteObject", Time: now})
+	detector.Observe(ctx, anomaly.Event{AccessKey: "second", Operation: "DeleteObject", Time: now})
+	require.Len(t, notifier.kinds(), 1)
+
+	// idle keys are forgotten to make room
+	detector.Observe(ctx, anomaly.Event{AccessKey: "second", Operation: "DeleteObject", Time: now.Add(3 * time.Hour)})
+	require.Len(t, notifier.kinds(), 2)
+}
+
+func TestWebhook(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	received := make(chan anomaly.Alert, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert anomaly.Alert
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		received <- alert
+	}))
+	defer server.Close()
+
+	webhook := anomaly.NewWebhook(zaptest.NewLogger(t), server.URL)
+	go func() { _ = webhook.Run(ctx) }()
+
+	detector := anomaly.NewDetector(zaptest.NewLogger(t), testConfig(), nil, webhook)
+	detector.Observe(ctx, anomaly.Event{AccessKey: "key", Operation: "DeleteObject", Time: time.Now()})
+
+	select {
+	case alert := <-received:
+		require.Equal(t, anomaly.KindFirstDelete, alert.Kind)
+		require.Equal(t, anomaly.Fingerprint("key"), alert.Fingerprint)
+		require.NotContains(t, alert.Fingerprint, "key")
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook did not receive the alert")
+	}
+}
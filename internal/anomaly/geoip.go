@@ -0,0 +1,43 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package anomaly
+
+import (
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/zeebo/errs"
+)
+
+// GeoIP finds the country of clients with a MaxMind country database.
+type GeoIP struct {
+	reader *maxminddb.Reader
+}
+
+// OpenGeoIP opens the MaxMind database at path.
+func OpenGeoIP(path string) (*GeoIP, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+	return &GeoIP{reader: reader}, nil
+}
+
+// Country returns the ISO country code of ip, or "" if it is unknown.
+func (geoip *GeoIP) Country(ip net.IP) string {
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+	if err := geoip.reader.Lookup(ip, &record); err != nil {
+		return ""
+	}
+	return record.Country.ISOCode
+}
+
+// Close closes the database.
+func (geoip *GeoIP) Close() error {
+	return errs.Wrap(geoip.reader.Close())
+}
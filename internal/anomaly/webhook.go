@@ -0,0 +1,88 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package anomaly
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+)
+
+// webhookQueueSize is how many alerts may wait to be delivered before new
+// alerts are dropped.
+const webhookQueueSize = 1000
+
+// Webhook is a Notifier that POSTs alerts as JSON to a url. Alerts are
+// delivered in the background by Run so that requests never wait on them.
+type Webhook struct {
+	log    *zap.Logger
+	url    string
+	client *http.Client
+	queue  chan Alert
+}
+
+// NewWebhook constructs a Webhook that POSTs alerts to url.
+func NewWebhook(log *zap.Logger, url string) *Webhook {
+	return &Webhook{
+		log:    log,
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan Alert, webhookQueueSize),
+	}
+}
+
+// Notify queues alert for delivery, dropping it if the queue is full.
+func (webhook *Webhook) Notify(alert Alert) {
+	select {
+	case webhook.queue <- alert:
+	default:
+		mon.Counter("anomaly_webhook_dropped").Inc(1)
+	}
+}
+
+// Run delivers queued alerts until ctx is canceled.
+func (webhook *Webhook) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case alert := <-webhook.queue:
+			if err := webhook.send(ctx, alert); err != nil {
+				webhook.log.Error("unable to deliver alert", zap.Error(err))
+			}
+		}
+	}
+}
+
+// send POSTs a single alert.
+func (webhook *Webhook) send(ctx context.Context, alert Alert) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return errs.Wrap(err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.url, bytes.NewReader(body))
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := webhook.client.Do(req)
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	defer func() { err = errs.Combine(err, resp.Body.Close()) }()
+
+	if resp.StatusCode/100 != 2 {
+		return errs.New("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package billing meters how access keys use the gateway and periodically
+// exports the usage as reports for billing pipelines.
+//
+// Access keys are never part of a report, only their fingerprints, because
+// without an auth service an access key is the access grant itself.
+package billing
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
+
+	"storj.io/stargate/internal/anomaly"
+)
+
+var mon = monkit.Package()
+
+// Error is the error class for this package.
+var Error = errs.Class("billing")
+
+// Config configures the export of usage for billing.
+type Config struct {
+	Interval    time.Duration `help:"how often the usage of access keys is exported for billing; 0 disables the export" default:"0s"`
+	Formats     string        `help:"comma separated formats of the exported reports: csv, or cur for reports like AWS cost and usage reports" default:"csv"`
+	AccessGrant string        `help:"access grant that the reports are uploaded with" default:""`
+	Bucket      string        `help:"bucket that the reports are uploaded to" default:""`
+	Prefix      string        `help:"prefix of the keys of the uploaded reports" default:"billing/"`
+	Retain      int           `help:"how many of the last reports are kept in memory to be queried from the admin api" default:"168"`
+}
+
+// Usage is how an access key used a bucket with an operation during the period
+// of a report.
+type Usage struct {
+	Fingerprint string
+	Bucket      string
+	Operation   string
+
+	Requests int64
+	BytesIn  int64
+	BytesOut int64
+}
+
+// Report is the usage of every access key during a period.
+type Report struct {
+	Start time.Time
+	End   time.Time
+	Usage []Usage
+}
+
+// usageKey is what usage is metered by.
+type usageKey struct {
+	fingerprint string
+	bucket      string
+	operation   string
+}
+
+// Meter meters the usage of access keys since it was last collected.
+type Meter struct {
+	mu    sync.Mutex
+	start time.Time
+	usage map[usageKey]*Usage
+}
+
+// NewMeter constructs a Meter whose first report starts now.
+func NewMeter() *Meter {
+	return &Meter{
+		start: time.Now(),
+		usage: make(map[usageKey]*Usage),
+	}
+}
+
+// Record records a request of the operation on the bucket with the access key,
+// and how many bytes it uploaded and downloaded.
+func (meter *Meter) Record(accessKey, bucket, operation string, bytesIn, bytesOut int64) {
+	key := usageKey{
+		fingerprint: anomaly.Fingerprint(accessKey),
+		bucket:      bucket,
+		operation:   operation,
+	}
+
+	meter.mu.Lock()
+	defer meter.mu.Unlock()
+
+	usage, ok := meter.usage[key]
+	if !ok {
+		usage = &Usage{Fingerprint: key.fingerprint, Bucket: bucket, Operation: operation}
+		meter.usage[key] = usage
+	}
+	usage.Requests++
+	usage.BytesIn += bytesIn
+	usage.BytesOut += bytesOut
+}
+
+// Collect returns the report of the usage since the last collection until
+// now, and starts the next report.
+func (meter *Meter) Collect(now time.Time) Report {
+	meter.mu.Lock()
+	report := Report{Start: meter.start, End: now}
+	usage := meter.usage
+	meter.start = now
+	meter.usage = make(map[usageKey]*Usage)
+	meter.mu.Unlock()
+
+	report.Usage = make([]Usage, 0, len(usage))
+	for _, u := range usage {
+		report.Usage = append(report.Usage, *u)
+	}
+	sort.Slice(report.Usage, func(i, k int) bool {
+		a, b := report.Usage[i], report.Usage[k]
+		if a.Fingerprint != b.Fingerprint {
+			return a.Fingerprint < b.Fingerprint
+		}
+		if a.Bucket != b.Bucket {
+			return a.Bucket < b.Bucket
+		}
+		return a.Operation < b.Operation
+	})
+	return report
+}
+
+// ParseFormats parses comma separated names of formats.
+func ParseFormats(s string) (formats []Format, err error) {
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		switch Format(name) {
+		case CSV, CUR:
+			formats = append(formats, Format(name))
+		default:
+			return nil, Error.New("unknown format %q", name)
+		}
+	}
+	if len(formats) == 0 {
+		return nil, Error.New("no formats")
+	}
+	return formats, nil
+}
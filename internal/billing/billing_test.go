@@ -0,0 +1,202 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package billing_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"storj.io/stargate/internal/anomaly"
+	"storj.io/stargate/internal/billing"
+)
+
+func TestMeter(t *testing.T) {
+	meter := billing.NewMeter()
+	meter.Record("key-a", "photos", "PutObject", 100, 0)
+	meter.Record("key-a", "photos", "PutObject", 50, 0)
+	meter.Record("key-a", "photos", "GetObject", 0, 30)
+	meter.Record("key-b", "logs", "PutObject", 10, 0)
+
+	now := time.Now()
+	report := meter.Collect(now)
+	require.Equal(t, now, report.End)
+	require.Len(t, report.Usage, 3)
+
+	// access keys are only reported by their fingerprints
+	for _, usage := range report.Usage {
+		require.NotContains(t, usage.Fingerprint, "key-")
+	}
+
+	var put billing.Usage
+	for _, usage := range report.Usage {
+		if usage.Fingerprint == anomaly.Fingerprint("key-a") && usage.Operation == "PutObject" {
+			put = usage
+		}
+	}
+	require.Equal(t, int64(2), put.Requests)
+	require.Equal(t, int64(150), put.BytesIn)
+
+	// the next report starts where the last one ended
+	next := meter.Collect(now.Add(time.Hour))
+	require.Equal(t, now, next.Start)
+	require.Empty(t, next.Usage)
+}
+
+func TestWrite(t *testing.T) {
+	start := time.Date(2020, 10, 16, 12, 0, 0, 0, time.UTC)
+	report := billing.Report{
+		Start: start,
+		End:   start.Add(time.Hour),
+		Usage: []billing.Usage{
+			{Fingerprint: "0011223344556677", Bucket: "photos", Operation: "PutObject", Requests: 2, BytesIn: 150},
+		},
+	}
+
+	read := func(format billing.Format) [][]string {
+		var buf bytes.Buffer
+		require.NoError(t, billing.Write(&buf, report, format))
+		rows, err := csv.NewReader(&buf).ReadAll()
+		require.NoError(t, err)
+		return rows
+	}
+
+	rows := read(billing.CSV)
+	require.Len(t, rows, 2)
+	require.Equal(t, []string{
+		"2020-10-16T12:00:00Z", "2020-10-16T13:00:00Z", "0011223344556677", "photos", "PutObject", "2", "150", "0",
+	}, rows[1])
+
+	// a line item for every kind of usage that isn't zero
+	rows = read(billing.CUR)
+	require.Len(t, rows, 3)
+	require.Equal(t, "identity/LineItemId", rows[0][0])
+	for _, row := range rows[1:] {
+		require.Equal(t, "2020-10-16T12:00:00Z/2020-10-16T13:00:00Z", row[1])
+		require.Equal(t, "2020-10-01T00:00:00Z", row[2])
+		require.Equal(t, "2020-11-01T00:00:00Z", row[3])
+		require.Equal(t, "photos", row[10])
+	}
+	require.Equal(t, []string{"Requests", "2"}, []string{rows[1][8], rows[1][11]})
+	require.Equal(t, []string{"DataTransfer-In-Bytes", "150"}, []string{rows[2][8], rows[2][11]})
+	require.NotEqual(t, rows[1][0], rows[2][0])
+
+	// line items keep their ids when the report is written again
+	require.Equal(t, rows, read(billing.CUR))
+
+	require.Error(t, billing.Write(&bytes.Buffer{}, report, "xml"))
+}
+
+func TestParseFormats(t *testing.T) {
+	formats, err := billing.ParseFormats("csv, cur")
+	require.NoError(t, err)
+	require.Equal(t, []billing.Format{billing.CSV, billing.CUR}, formats)
+
+	_, err = billing.ParseFormats("csv,xml")
+	require.Error(t, err)
+	_, err = billing.ParseFormats("")
+	require.Error(t, err)
+}
+
+func TestExporter(t *testing.T) {
+	ctx := context.Background()
+
+	uploaded := make(map[string][]byte)
+	fail := true
+	upload := func(ctx context.Context, key string, data []byte) error {
+		if fail {
+			return errors.New("unavailable")
+		}
+		uploaded[key] = data
+		return nil
+	}
+
+	meter := billing.NewMeter()
+	config := billing.Config{Interval: time.Hour, Formats: "csv,cur", Prefix: "billing/"}
+	exporter, err := billing.NewExporter(zap.NewNop(), config, meter, upload)
+	require.NoError(t, err)
+
+	// a report that fails to upload is retried with the next one
+	now := time.Now()
+	meter.Record("key", "photos", "PutObject", 100, 0)
+	exporter.Export(ctx, now.Add(time.Hour))
+	require.Empty(t, uploaded)
+
+	fail = false
+	meter.Record("key", "photos", "GetObject", 0, 100)
+	exporter.Export(ctx, now.Add(2*time.Hour))
+	require.Len(t, uploaded, 4)
+
+	var csvReports, curReports int
+	for key, data := range uploaded {
+		require.True(t, strings.HasSuffix(key, ".csv"), key)
+		switch {
+		case strings.HasPrefix(key, "billing/csv/"):
+			csvReports++
+		case strings.HasPrefix(key, "billing/cur/"):
+			curReports++
+		}
+		require.NotContains(t, string(data), "key,")
+	}
+	require.Equal(t, 2, csvReports)
+	require.Equal(t, 2, curReports)
+}
+
+func TestExporterQuery(t *testing.T) {
+	ctx := context.Background()
+
+	upload := func(ctx context.Context, key string, data []byte) error { return nil }
+	meter := billing.NewMeter()
+	config := billing.Config{Interval: time.Hour, Formats: "csv", Retain: 2}
+	exporter, err := billing.NewExporter(zap.NewNop(), config, meter, upload)
+	require.NoError(t, err)
+
+	// only the last two reports are retained
+	now := time.Now()
+	for i := 1; i <= 3; i++ {
+		meter.Record("key", "photos", "PutObject", int64(i), 0)
+		meter.Record("key", "photos", "GetObject", 0, int64(i))
+		meter.Record("other", "photos", "GetObject", 0, int64(i))
+		exporter.Export(ctx, now.Add(time.Duration(i)*time.Hour))
+	}
+
+	var usage []billing.ReportedUsage
+	query := billing.Query{Limit: 2}
+	for {
+		page, err := exporter.Query(query)
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(page.Usage), 2)
+		usage = append(usage, page.Usage...)
+		if page.NextCursor == "" {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+	require.Len(t, usage, 6)
+	require.Equal(t, now.Add(2*time.Hour), usage[0].End)
+
+	// usage is filtered by fingerprint, operation and time
+	page, err := exporter.Query(billing.Query{
+		Fingerprint: anomaly.Fingerprint("key"),
+		Operation:   "PutObject",
+		Since:       now.Add(2 * time.Hour),
+		Limit:       10,
+	})
+	require.NoError(t, err)
+	require.Len(t, page.Usage, 1)
+	require.Equal(t, int64(3), page.Usage[0].BytesIn)
+	require.Empty(t, page.NextCursor)
+
+	_, err = exporter.Query(billing.Query{Limit: 10, Cursor: "invalid"})
+	require.Error(t, err)
+	_, err = exporter.Query(billing.Query{Limit: 0})
+	require.Error(t, err)
+}
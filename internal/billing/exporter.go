@@ -0,0 +1,128 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package billing
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// maxPending is how many reports that failed to upload are kept to be retried.
+const maxPending = 24
+
+// flushTimeout is how long the last report is given to upload on shutdown.
+const flushTimeout = 30 * time.Second
+
+// UploadFunc uploads the data of a report to the key.
+type UploadFunc func(ctx context.Context, key string, data []byte) error
+
+// Exporter periodically exports the usage of a Meter.
+type Exporter struct {
+	log     *zap.Logger
+	config  Config
+	formats []Format
+	meter   *Meter
+	upload  UploadFunc
+
+	pending []Report
+
+	mu       sync.Mutex
+	retained []Report
+}
+
+// NewExporter constructs an Exporter that uploads the reports of meter with
+// upload in the formats of config.
+func NewExporter(log *zap.Logger, config Config, meter *Meter, upload UploadFunc) (*Exporter, error) {
+	formats, err := ParseFormats(config.Formats)
+	if err != nil {
+		return nil, err
+	}
+	return &Exporter{
+		log:     log,
+		config:  config,
+		formats: formats,
+		meter:   meter,
+		upload:  upload,
+	}, nil
+}
+
+// Run exports a report every interval until the context is canceled, and
+// then exports the last one. Reports that fail to upload are retried at the
+// next interval.
+func (exporter *Exporter) Run(ctx context.Context) error {
+	if exporter.config.Interval <= 0 {
+		return nil
+	}
+
+	ticker := time.NewTicker(exporter.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+			defer cancel()
+			exporter.Export(flushCtx, time.Now())
+			return ctx.Err()
+		case now := <-ticker.C:
+			exporter.Export(ctx, now)
+		}
+	}
+}
+
+// Export collects the report of the meter until now, and uploads it with the
+// reports that failed to upload before. Failures are logged.
+func (exporter *Exporter) Export(ctx context.Context, now time.Time) {
+	report := exporter.meter.Collect(now)
+	exporter.retain(report)
+	exporter.pending = append(exporter.pending, report)
+
+	failed := exporter.pending[:0]
+	for _, report := range exporter.pending {
+		if err := exporter.exportReport(ctx, report); err != nil {
+			mon.Counter("billing_export_failures").Inc(1)
+			exporter.log.Error("unable to export usage report",
+				zap.Time("start", report.Start), zap.Time("end", report.End), zap.Error(err))
+			failed = append(failed, report)
+		}
+	}
+
+	if len(failed) > maxPending {
+		dropped := failed[:len(failed)-maxPending]
+		exporter.log.Error("dropped usage reports that failed to export too often",
+			zap.Int("reports", len(dropped)),
+			zap.Time("start", dropped[0].Start), zap.Time("end", dropped[len(dropped)-1].End))
+		failed = failed[len(dropped):]
+	}
+	exporter.pending = failed
+}
+
+// exportReport uploads the report in every format.
+func (exporter *Exporter) exportReport(ctx context.Context, report Report) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	for _, format := range exporter.formats {
+		var buf bytes.Buffer
+		if err := Write(&buf, report, format); err != nil {
+			return err
+		}
+		if err := exporter.upload(ctx, ReportKey(exporter.config.Prefix, report, format), buf.Bytes()); err != nil {
+			return Error.Wrap(err)
+		}
+	}
+	return nil
+}
+
+// ReportKey returns the key that the report is uploaded to in the format, like
+// billing/cur/20201016T120000Z-20201016T130000Z.csv.
+func ReportKey(prefix string, report Report, format Format) string {
+	const layout = "20060102T150405Z"
+	return prefix + string(format) + "/" +
+		report.Start.UTC().Format(layout) + "-" + report.End.UTC().Format(layout) +
+		format.Extension()
+}
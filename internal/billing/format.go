@@ -0,0 +1,160 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package billing
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Format is a format that reports are exported in.
+type Format string
+
+const (
+	// CSV is a row of comma separated values with a header row for every
+	// usage of a report.
+	CSV Format = "csv"
+	// CUR is comma separated values with the columns of AWS cost and usage
+	// reports, with a line item for every kind of usage, so that pipelines
+	// for those can read it.
+	CUR Format = "cur"
+)
+
+// Extension returns the file extension of the format.
+func (format Format) Extension() string {
+	return ".csv"
+}
+
+// csvHeader is the header row of CSV reports.
+var csvHeader = []string{
+	"start", "end", "access_key_fingerprint", "bucket", "operation",
+	"requests", "bytes_in", "bytes_out",
+}
+
+// curHeader is the header row of CUR reports.
+var curHeader = []string{
+	"identity/LineItemId",
+	"identity/TimeInterval",
+	"bill/BillingPeriodStartDate",
+	"bill/BillingPeriodEndDate",
+	"lineItem/LineItemType",
+	"lineItem/UsageStartDate",
+	"lineItem/UsageEndDate",
+	"lineItem/ProductCode",
+	"lineItem/UsageType",
+	"lineItem/Operation",
+	"lineItem/ResourceId",
+	"lineItem/UsageAmount",
+	"resourceTags/user:AccessKeyFingerprint",
+}
+
+// The usage types of the line items of CUR reports.
+const (
+	usageTypeRequests = "Requests"
+	usageTypeBytesIn  = "DataTransfer-In-Bytes"
+	usageTypeBytesOut = "DataTransfer-Out-Bytes"
+)
+
+// Write writes the report in the format.
+func Write(w io.Writer, report Report, format Format) (err error) {
+	out := csv.NewWriter(w)
+	switch format {
+	case CSV:
+		err = writeCSV(out, report)
+	case CUR:
+		err = writeCUR(out, report)
+	default:
+		return Error.New("unknown format %q", format)
+	}
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	out.Flush()
+	return Error.Wrap(out.Error())
+}
+
+func writeCSV(out *csv.Writer, report Report) error {
+	if err := out.Write(csvHeader); err != nil {
+		return err
+	}
+	start, end := formatTime(report.Start), formatTime(report.End)
+	for _, usage := range report.Usage {
+		err := out.Write([]string{
+			start, end, usage.Fingerprint, usage.Bucket, usage.Operation,
+			strconv.FormatInt(usage.Requests, 10),
+			strconv.FormatInt(usage.BytesIn, 10),
+			strconv.FormatInt(usage.BytesOut, 10),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeCUR(out *csv.Writer, report Report) error {
+	if err := out.Write(curHeader); err != nil {
+		return err
+	}
+
+	start, end := formatTime(report.Start), formatTime(report.End)
+	interval := start + "/" + end
+	// the billing period is the calendar month that the report starts in
+	periodStart := time.Date(report.Start.UTC().Year(), report.Start.UTC().Month(), 1, 0, 0, 0, 0, time.UTC)
+	periodEnd := periodStart.AddDate(0, 1, 0)
+
+	for _, usage := range report.Usage {
+		for _, item := range []struct {
+			usageType string
+			amount    int64
+		}{
+			{usageTypeRequests, usage.Requests},
+			{usageTypeBytesIn, usage.BytesIn},
+			{usageTypeBytesOut, usage.BytesOut},
+		} {
+			if item.amount == 0 {
+				continue
+			}
+			err := out.Write([]string{
+				lineItemID(interval, usage, item.usageType),
+				interval,
+				formatTime(periodStart),
+				formatTime(periodEnd),
+				"Usage",
+				start,
+				end,
+				"Storj",
+				item.usageType,
+				usage.Operation,
+				usage.Bucket,
+				strconv.FormatInt(item.amount, 10),
+				usage.Fingerprint,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// lineItemID returns a stable identifier of a line item, so that a report
+// that is exported again doesn't duplicate its line items.
+func lineItemID(interval string, usage Usage, usageType string) string {
+	sum := sha256.New()
+	for _, field := range []string{interval, usage.Fingerprint, usage.Bucket, usage.Operation, usageType} {
+		_, _ = sum.Write([]byte(field))
+		_, _ = sum.Write([]byte{0})
+	}
+	return hex.EncodeToString(sum.Sum(nil)[:16])
+}
+
+// formatTime formats a time of a report.
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
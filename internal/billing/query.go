@@ -0,0 +1,132 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package billing
+
+import (
+	"fmt"
+	"time"
+)
+
+// MaxQueryLimit is the maximum number of usages of a page.
+const MaxQueryLimit = 1000
+
+// Query selects the usage of the retained reports.
+type Query struct {
+	// Fingerprint, Bucket and Operation select the usage that has them, when
+	// they are set.
+	Fingerprint string
+	Bucket      string
+	Operation   string
+
+	// Since and Until select the reports that end after Since and start
+	// before Until, when they are set.
+	Since time.Time
+	Until time.Time
+
+	// Cursor is the NextCursor of the previous page.
+	Cursor string
+	// Limit is the maximum number of usages of the page, up to MaxQueryLimit.
+	Limit int
+}
+
+// ReportedUsage is the usage of a report.
+type ReportedUsage struct {
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	Fingerprint string    `json:"fingerprint"`
+	Bucket      string    `json:"bucket"`
+	Operation   string    `json:"operation"`
+	Requests    int64     `json:"requests"`
+	BytesIn     int64     `json:"bytes_in"`
+	BytesOut    int64     `json:"bytes_out"`
+}
+
+// UsagePage is a page of the usage of the retained reports, oldest first.
+// NextCursor is empty on the last page.
+type UsagePage struct {
+	Usage      []ReportedUsage `json:"usage"`
+	NextCursor string          `json:"next_cursor"`
+}
+
+// retain keeps the report to be queried, and drops the oldest reports when
+// more than the configured number are kept.
+func (exporter *Exporter) retain(report Report) {
+	if exporter.config.Retain <= 0 {
+		return
+	}
+
+	exporter.mu.Lock()
+	defer exporter.mu.Unlock()
+
+	exporter.retained = append(exporter.retained, report)
+	if excess := len(exporter.retained) - exporter.config.Retain; excess > 0 {
+		exporter.retained = append([]Report(nil), exporter.retained[excess:]...)
+	}
+}
+
+// Query returns a page of the usage of the retained reports that the query
+// selects. Usage is only retained after it is exported, so the usage of the
+// current period isn't returned.
+func (exporter *Exporter) Query(query Query) (page UsagePage, err error) {
+	if query.Limit <= 0 || query.Limit > MaxQueryLimit {
+		return page, Error.New("limit must be between 1 and %d", MaxQueryLimit)
+	}
+
+	// the cursor is the end of the report of the last usage of the previous
+	// page, and the index of that usage in the report
+	var afterEnd int64
+	afterIndex := -1
+	if query.Cursor != "" {
+		if _, err := fmt.Sscanf(query.Cursor, "%d.%d", &afterEnd, &afterIndex); err != nil || afterIndex < 0 {
+			return page, Error.New("invalid cursor")
+		}
+	}
+
+	exporter.mu.Lock()
+	reports := exporter.retained
+	exporter.mu.Unlock()
+
+	page.Usage = []ReportedUsage{}
+	for _, report := range reports {
+		end := report.End.UnixNano()
+		if query.Cursor != "" && end < afterEnd {
+			continue
+		}
+		if !query.Since.IsZero() && !report.End.After(query.Since) {
+			continue
+		}
+		if !query.Until.IsZero() && !report.Start.Before(query.Until) {
+			continue
+		}
+
+		for i, usage := range report.Usage {
+			if query.Cursor != "" && end == afterEnd && i <= afterIndex {
+				continue
+			}
+			if query.Fingerprint != "" && usage.Fingerprint != query.Fingerprint ||
+				query.Bucket != "" && usage.Bucket != query.Bucket ||
+				query.Operation != "" && usage.Operation != query.Operation {
+				continue
+			}
+
+			if len(page.Usage) == query.Limit {
+				return page, nil
+			}
+			page.Usage = append(page.Usage, ReportedUsage{
+				Start:       report.Start,
+				End:         report.End,
+				Fingerprint: usage.Fingerprint,
+				Bucket:      usage.Bucket,
+				Operation:   usage.Operation,
+				Requests:    usage.Requests,
+				BytesIn:     usage.BytesIn,
+				BytesOut:    usage.BytesOut,
+			})
+			page.NextCursor = fmt.Sprintf("%d.%d", end, i)
+		}
+	}
+
+	page.NextCursor = ""
+	return page, nil
+}
@@ -0,0 +1,156 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package bruteforce bans clients and keys that fail too often, so that secrets
+// can't be guessed by trying them one after another.
+package bruteforce
+
+import (
+	"sync"
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+)
+
+var mon = monkit.Package()
+
+// Config configures a Limiter.
+type Config struct {
+	Threshold      int           `help:"failed attempts allowed for a client or key before it is temporarily banned; 0 disables the protection" default:"10"`
+	BanDuration    time.Duration `help:"length of the first ban; every further failure doubles it" default:"1m0s"`
+	MaxBanDuration time.Duration `help:"maximum length of a ban" default:"24h0m0s"`
+	ForgetAfter    time.Duration `help:"how long after the last failure, or the end of the last ban, the failures are forgotten" default:"1h0m0s"`
+	MaxEntries     int           `help:"maximum number of clients and keys that are tracked at once" default:"100000"`
+	Tarpit         time.Duration `help:"how long responses to failed attempts of unauthorized clients are delayed, which slows down guessing before the threshold is reached; 0 disables the delay" default:"0s"`
+}
+
+// Limiter counts failures per key, like a client ip or an access key id, and
+// bans keys with escalating durations once they fail more than the threshold.
+type Limiter struct {
+	config Config
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*entry
+}
+
+type entry struct {
+	failures    int
+	lastFailure time.Time
+	bannedUntil time.Time
+}
+
+// New constructs a Limiter. It returns nil if the config disables it, and a nil
+// Limiter allows everything.
+func New(config Config) *Limiter {
+	if config.Threshold <= 0 {
+		return nil
+	}
+	return &Limiter{
+		config:  config,
+		now:     time.Now,
+		entries: make(map[string]*entry),
+	}
+}
+
+// Allowed returns whether none of the keys are banned, and if one is, how long
+// until its ban ends.
+func (l *Limiter) Allowed(keys ...string) (retryAfter time.Duration, ok bool) {
+	if l == nil {
+		return 0, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	for _, key := range keys {
+		if e, found := l.entries[key]; found && now.Before(e.bannedUntil) {
+			if wait := e.bannedUntil.Sub(now); wait > retryAfter {
+				retryAfter = wait
+			}
+		}
+	}
+	if retryAfter > 0 {
+		mon.Counter("bruteforce_rejected").Inc(1)
+		return retryAfter, false
+	}
+	return 0, true
+}
+
+// Tarpit returns how long the response to a failed attempt is delayed.
+func (l *Limiter) Tarpit() time.Duration {
+	if l == nil {
+		return 0
+	}
+	return l.config.Tarpit
+}
+
+// Failure records a failed attempt for every key, and bans the keys that
+// have now failed more than the threshold.
+func (l *Limiter) Failure(keys ...string) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	mon.Counter("bruteforce_failures").Inc(1)
+
+	now := l.now()
+	for _, key := range keys {
+		e, found := l.entries[key]
+		if found && l.forgotten(e, now) {
+			found = false
+		}
+		if !found {
+			if len(l.entries) >= l.config.MaxEntries {
+				l.prune(now)
+				if len(l.entries) >= l.config.MaxEntries {
+					mon.Counter("bruteforce_untracked").Inc(1)
+					continue
+				}
+			}
+			e = new(entry)
+			l.entries[key] = e
+		}
+
+		e.failures++
+		e.lastFailure = now
+		if excess := e.failures - l.config.Threshold; excess > 0 {
+			e.bannedUntil = now.Add(l.banDuration(excess))
+			mon.Counter("bruteforce_bans").Inc(1)
+		}
+	}
+}
+
+// banDuration returns the length of the ban after excess failures over the threshold.
+func (l *Limiter) banDuration(excess int) time.Duration {
+	duration := l.config.BanDuration
+	for i := 1; i < excess && duration < l.config.MaxBanDuration; i++ {
+		duration *= 2
+	}
+	if duration > l.config.MaxBanDuration {
+		duration = l.config.MaxBanDuration
+	}
+	return duration
+}
+
+// forgotten returns whether the failures of e are old enough to be forgotten.
+func (l *Limiter) forgotten(e *entry, now time.Time) bool {
+	last := e.lastFailure
+	if e.bannedUntil.After(last) {
+		last = e.bannedUntil
+	}
+	return now.Sub(last) >= l.config.ForgetAfter
+}
+
+// prune removes the entries whose failures are forgotten.
+func (l *Limiter) prune(now time.Time) {
+	for key, e := range l.entries {
+		if l.forgotten(e, now) {
+			delete(l.entries, key)
+		}
+	}
+}
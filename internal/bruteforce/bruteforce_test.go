@@ -0,0 +1,84 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package bruteforce
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Now()
+	limiter := New(Config{
+		Threshold:      2,
+		BanDuration:    time.Minute,
+		MaxBanDuration: 3 * time.Minute,
+		ForgetAfter:    time.Hour,
+		MaxEntries:     10,
+	})
+	limiter.now = func() time.Time { return now }
+
+	// failures up to the threshold are allowed
+	limiter.Failure("ip", "key")
+	limiter.Failure("ip")
+	_, ok := limiter.Allowed("ip", "key")
+	require.True(t, ok)
+
+	// bans escalate with every failure over the threshold, up to the maximum
+	for _, expected := range []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute, 3 * time.Minute} {
+		limiter.Failure("ip")
+		retryAfter, ok := limiter.Allowed("other", "ip")
+		require.False(t, ok)
+		require.Equal(t, expected, retryAfter)
+	}
+
+	// other keys are unaffected
+	_, ok = limiter.Allowed("key")
+	require.True(t, ok)
+
+	// bans end
+	now = now.Add(3 * time.Minute)
+	_, ok = limiter.Allowed("ip")
+	require.True(t, ok)
+
+	// and failures are forgotten eventually
+	now = now.Add(time.Hour)
+	limiter.Failure("ip")
+	_, ok = limiter.Allowed("ip")
+	require.True(t, ok)
+}
+
+func TestLimiter_MaxEntries(t *testing.T) {
+	now := time.Now()
+	limiter := New(Config{Threshold: 1, BanDuration: time.Minute, MaxBanDuration: time.Minute, ForgetAfter: time.Hour, MaxEntries: 2})
+	limiter.now = func() time.Time { return now }
+
+	limiter.Failure("a", "b")
+	limiter.Failure("c")
+	require.Len(t, limiter.entries, 2)
+
+	// forgotten entries make room for new ones
+	now = now.Add(2 * time.Hour)
+	limiter.Failure("c")
+	require.Len(t, limiter.entries, 1)
+}
+
+func TestLimiter_Disabled(t *testing.T) {
+	limiter := New(Config{})
+	require.Nil(t, limiter)
+
+	limiter.Failure("ip")
+	_, ok := limiter.Allowed("ip")
+	require.True(t, ok)
+}
+
+func TestLimiter_Tarpit(t *testing.T) {
+	var disabled *Limiter
+	require.Zero(t, disabled.Tarpit())
+
+	limiter := New(Config{Threshold: 1, MaxEntries: 1, Tarpit: time.Second})
+	require.Equal(t, time.Second, limiter.Tarpit())
+}
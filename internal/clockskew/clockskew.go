@@ -0,0 +1,195 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package clockskew compares the local clock with the Date headers of http
+// servers, like the auth service, and warns when it is off.
+//
+// S3 clients sign the time of their requests, and signatures whose time is
+// more than 15 minutes off the clock of the gateway are rejected, so a gateway
+// whose clock drifts rejects every request without an obvious reason.
+package clockskew
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+)
+
+var mon = monkit.Package()
+
+// Error is the error class for this package.
+var Error = errs.Class("clockskew")
+
+// datePrecision is the precision of Date headers, which have whole seconds.
+const datePrecision = time.Second
+
+// Config configures the comparisons of the local clock.
+type Config struct {
+	URLs     string        `help:"comma separated urls, like the one of the auth service, whose Date headers the local clock is compared with at startup and periodically; disabled when empty" default:""`
+	Interval time.Duration `help:"how often the local clock is compared" default:"1h0m0s"`
+	MaxSkew  time.Duration `help:"how far the local clock may be off before warnings are logged; signatures of S3 requests fail when it is more than 15 minutes off" default:"1m0s"`
+	Timeout  time.Duration `help:"how long a server may take to respond" default:"10s"`
+}
+
+// Result is the last comparison of the local clock with a server.
+type Result struct {
+	URL string
+	// Skew is how far the local clock is ahead of the server, or behind it
+	// when it is negative.
+	Skew time.Duration
+	// Uncertainty is how far Skew may be off, because of the round trip to
+	// the server and the precision of its Date header.
+	Uncertainty time.Duration
+	CheckedAt   time.Time
+	Err         error
+}
+
+// Exceeds returns whether the local clock is certainly off by more than max.
+func (result Result) Exceeds(max time.Duration) bool {
+	if result.Err != nil {
+		return false
+	}
+	skew := result.Skew
+	if skew < 0 {
+		skew = -skew
+	}
+	return skew-result.Uncertainty > max
+}
+
+// Checker periodically compares the local clock with the servers of its
+// config.
+type Checker struct {
+	log    *zap.Logger
+	config Config
+	urls   []string
+	client *http.Client
+
+	mu      sync.Mutex
+	results []Result
+}
+
+// New constructs a Checker that compares the local clock with the servers of
+// config.
+func New(log *zap.Logger, config Config) *Checker {
+	var urls []string
+	for _, url := range strings.Split(config.URLs, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			urls = append(urls, url)
+		}
+	}
+	return &Checker{
+		log:    log,
+		config: config,
+		urls:   urls,
+		client: &http.Client{Timeout: config.Timeout},
+	}
+}
+
+// Run compares the local clock right away, and then every interval until ctx
+// is canceled.
+func (checker *Checker) Run(ctx context.Context) error {
+	if len(checker.urls) == 0 {
+		return nil
+	}
+
+	ticker := time.NewTicker(checker.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		checker.Check(ctx)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check compares the local clock with every server, and logs a warning for
+// every server that the local clock is off from by more than the max skew.
+// Servers that can't be reached are logged at debug level, since the clock
+// is compared with them again later.
+func (checker *Checker) Check(ctx context.Context) []Result {
+	defer mon.Task()(&ctx)(nil)
+
+	results := make([]Result, 0, len(checker.urls))
+	for _, url := range checker.urls {
+		result := checker.compare(ctx, url)
+		results = append(results, result)
+
+		if result.Err != nil {
+			mon.Event("clock_skew_check_failed")
+			checker.log.Debug("unable to compare the clock", zap.String("url", url), zap.Error(result.Err))
+			continue
+		}
+
+		mon.FloatVal("clock_skew_seconds").Observe(result.Skew.Seconds())
+		if result.Exceeds(checker.config.MaxSkew) {
+			mon.Event("clock_skew_exceeded")
+			checker.log.Error("the local clock is off; signatures of S3 requests fail when it is more than 15 minutes off, so synchronize it with NTP",
+				zap.String("url", url),
+				zap.Duration("skew", result.Skew),
+				zap.Duration("uncertainty", result.Uncertainty),
+				zap.Duration("max skew", checker.config.MaxSkew))
+		}
+	}
+
+	checker.mu.Lock()
+	checker.results = results
+	checker.mu.Unlock()
+
+	return results
+}
+
+// compare compares the local clock with the Date header of the response of a
+// HEAD request to url. The server is assumed to set the header halfway
+// through the round trip.
+func (checker *Checker) compare(ctx context.Context, url string) (result Result) {
+	result.URL = url
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		result.Err = Error.Wrap(err)
+		return result
+	}
+
+	start := time.Now()
+	resp, err := checker.client.Do(req)
+	end := time.Now()
+	if err != nil {
+		result.Err = Error.Wrap(err)
+		return result
+	}
+	_ = resp.Body.Close()
+
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		result.Err = Error.New("invalid Date header %q", resp.Header.Get("Date"))
+		return result
+	}
+
+	roundTrip := end.Sub(start)
+	local := start.Add(roundTrip / 2)
+	remote := date.Add(datePrecision / 2)
+
+	result.Skew = local.Sub(remote)
+	result.Uncertainty = roundTrip/2 + datePrecision/2
+	result.CheckedAt = end
+	return result
+}
+
+// Results returns the results of the last check, in the order of the urls of
+// the config.
+func (checker *Checker) Results() []Result {
+	checker.mu.Lock()
+	defer checker.mu.Unlock()
+
+	return append([]Result(nil), checker.results...)
+}
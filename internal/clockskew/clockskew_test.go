@@ -0,0 +1,66 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package clockskew_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"storj.io/stargate/internal/clockskew"
+)
+
+func TestChecker(t *testing.T) {
+	server := func(offset time.Duration) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Date", time.Now().Add(offset).UTC().Format(http.TimeFormat))
+		}))
+	}
+	synced, ahead := server(0), server(time.Hour)
+	defer synced.Close()
+	defer ahead.Close()
+	missing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header()["Date"] = nil
+	}))
+	defer missing.Close()
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	checker := clockskew.New(zap.New(core), clockskew.Config{
+		URLs:    synced.URL + ", " + ahead.URL + "," + missing.URL,
+		MaxSkew: time.Minute,
+		Timeout: 5 * time.Second,
+	})
+
+	results := checker.Check(context.Background())
+	require.Len(t, results, 3)
+	require.Equal(t, results, checker.Results())
+
+	require.NoError(t, results[0].Err)
+	require.False(t, results[0].Exceeds(time.Minute))
+	require.True(t, results[0].Skew < 2*time.Second && results[0].Skew > -2*time.Second, results[0].Skew)
+
+	// the local clock is behind the server that is an hour ahead
+	require.NoError(t, results[1].Err)
+	require.True(t, results[1].Exceeds(time.Minute))
+	require.InDelta(t, -time.Hour.Seconds(), results[1].Skew.Seconds(), 2)
+
+	require.Error(t, results[2].Err)
+	require.False(t, results[2].Exceeds(time.Minute))
+
+	// only the skewed clock is warned about
+	var warned []interface{}
+	for _, entry := range logs.All() {
+		if entry.Level == zapcore.ErrorLevel {
+			warned = append(warned, entry.ContextMap()["url"])
+		}
+	}
+	require.Equal(t, []interface{}{ahead.URL}, warned)
+}
@@ -0,0 +1,70 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package configcrypt
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// NewEncryptCommand returns a command that encrypts a value for pasting into config.yaml.
+func NewEncryptCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "encrypt-value",
+		Short: "Encrypt a sensitive value for use in config.yaml",
+		Long: `Reads a value, like an access grant or auth token, and prints it encrypted
+with the config passphrase. The output can replace the plain value of any string
+config value in config.yaml or on the command line. The passphrase is read from
+` + PassphraseEnv + ` or prompted for.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			value, err := ReadSecret("Enter the value to encrypt: ")
+			if err != nil {
+				return err
+			}
+
+			passphrase := []byte(os.Getenv(PassphraseEnv))
+			if _, ok := os.LookupEnv(PassphraseEnv); !ok {
+				passphrase, err = Prompt("Enter the config passphrase: ", true)
+				if err != nil {
+					return err
+				}
+			}
+
+			encrypted, err := Encrypt(value, passphrase)
+			if err != nil {
+				return err
+			}
+
+			_, err = fmt.Fprintln(cmd.OutOrStdout(), encrypted)
+			return err
+		},
+	}
+}
+
+// ReadSecret reads a sensitive value after printing prompt, without echoing it, if
+// stdin is a terminal, and otherwise reads the first line of stdin.
+func ReadSecret(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if terminal.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, prompt)
+		value, err := terminal.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", Error.Wrap(err)
+		}
+		return string(value), nil
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", Error.Wrap(err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
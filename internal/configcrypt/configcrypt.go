@@ -0,0 +1,216 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package configcrypt encrypts sensitive config values, like access grants and
+// auth tokens, so that config.yaml can be backed up without leaking them.
+//
+// An encrypted value is stored in place of the plain value as
+//
+//	enc:v1:<base64 of salt || nonce || AES-GCM ciphertext>
+//
+// where the AES key is derived from a passphrase and the salt with scrypt.
+package configcrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/zeebo/errs"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// Error is the error class for this package.
+var Error = errs.Class("config encryption")
+
+// PassphraseEnv is the environment variable that is checked for the passphrase
+// before prompting for it.
+const PassphraseEnv = "STORJ_CONFIG_PASSPHRASE"
+
+const (
+	prefix   = "enc:v1:"
+	saltSize = 16
+
+	// scrypt parameters recommended for interactive logins
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// PassphraseFunc returns the passphrase that protects encrypted config values.
+// It is only called when there is something to decrypt.
+type PassphraseFunc func() ([]byte, error)
+
+// IsEncrypted returns whether value is an encrypted config value.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// Encrypt encrypts value with a key derived from passphrase.
+func Encrypt(value string, passphrase []byte) (string, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", Error.Wrap(err)
+	}
+
+	aead, err := newAEAD(passphrase, salt)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", Error.Wrap(err)
+	}
+
+	data := append(salt, nonce...)
+	data = aead.Seal(data, nonce, []byte(value), nil)
+
+	return prefix + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// Decrypt decrypts a value returned by Encrypt with the same passphrase.
+func Decrypt(value string, passphrase []byte) (string, error) {
+	if !IsEncrypted(value) {
+		return "", Error.New("value is not encrypted")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, prefix))
+	if err != nil {
+		return "", Error.Wrap(err)
+	}
+	if len(data) < saltSize {
+		return "", Error.New("encrypted value is too short")
+	}
+	salt, data := data[:saltSize], data[saltSize:]
+
+	aead, err := newAEAD(passphrase, salt)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < aead.NonceSize() {
+		return "", Error.New("encrypted value is too short")
+	}
+	nonce, data := data[:aead.NonceSize()], data[aead.NonceSize():]
+
+	plain, err := aead.Open(nil, nonce, data, nil)
+	if err != nil {
+		return "", Error.New("wrong passphrase or corrupted value")
+	}
+	return string(plain), nil
+}
+
+func newAEAD(passphrase, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	return aead, Error.Wrap(err)
+}
+
+// DecryptFields walks the struct that config points to and replaces every
+// encrypted string field with its decrypted value. The passphrase is requested
+// at most once, and only if there is an encrypted field.
+func DecryptFields(config interface{}, passphrase PassphraseFunc) error {
+	var key []byte
+	return ReplaceStrings(config, func(value string) (string, error) {
+		if !IsEncrypted(value) {
+			return value, nil
+		}
+		if key == nil {
+			var err error
+			if key, err = passphrase(); err != nil {
+				return "", Error.Wrap(err)
+			}
+		}
+		return Decrypt(value, key)
+	})
+}
+
+// ReplaceStrings walks the struct that config points to and replaces every
+// settable string field with the result of replace. Errors are prefixed with
+// the path of the field that caused them.
+func ReplaceStrings(config interface{}, replace func(value string) (string, error)) error {
+	v := reflect.ValueOf(config)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return Error.New("config must be a pointer to a struct, got %T", config)
+	}
+	return replaceValue(v.Elem(), replace)
+}
+
+func replaceValue(v reflect.Value, replace func(string) (string, error)) error {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !v.Field(i).CanSet() {
+				continue
+			}
+			if err := replaceValue(v.Field(i), replace); err != nil {
+				return fmt.Errorf("%s: %w", v.Type().Field(i).Name, err)
+			}
+		}
+	case reflect.String:
+		replaced, err := replace(v.String())
+		if err != nil {
+			return err
+		}
+		v.SetString(replaced)
+	}
+	return nil
+}
+
+// EnvOrPrompt returns a PassphraseFunc that reads the passphrase from the
+// STORJ_CONFIG_PASSPHRASE environment variable, or prompts for it on the terminal.
+func EnvOrPrompt() PassphraseFunc {
+	return func() ([]byte, error) {
+		if passphrase, ok := os.LookupEnv(PassphraseEnv); ok {
+			return []byte(passphrase), nil
+		}
+		return Prompt("Enter the config passphrase: ", false)
+	}
+}
+
+// Prompt reads a passphrase from the terminal without echoing it. With confirm,
+// the passphrase has to be entered twice.
+func Prompt(prompt string, confirm bool) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return nil, Error.New("stdin is not a terminal: set %s to provide the config passphrase", PassphraseEnv)
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	passphrase, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	if len(passphrase) == 0 {
+		return nil, Error.New("passphrase cannot be empty")
+	}
+
+	if confirm {
+		fmt.Fprint(os.Stderr, "Enter the config passphrase again: ")
+		repeated, err := terminal.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, Error.Wrap(err)
+		}
+		if !bytes.Equal(passphrase, repeated) {
+			return nil, Error.New("passphrases do not match")
+		}
+	}
+
+	return passphrase, nil
+}
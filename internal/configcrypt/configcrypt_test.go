@@ -0,0 +1,71 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package configcrypt
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	passphrase := []byte("passphrase")
+
+	encrypted, err := Encrypt("access grant", passphrase)
+	require.NoError(t, err)
+	require.True(t, IsEncrypted(encrypted))
+	require.NotContains(t, encrypted, "access grant")
+
+	decrypted, err := Decrypt(encrypted, passphrase)
+	require.NoError(t, err)
+	require.Equal(t, "access grant", decrypted)
+
+	// the wrong passphrase fails instead of returning garbage
+	_, err = Decrypt(encrypted, []byte("wrong"))
+	require.Error(t, err)
+
+	// truncated and plain values are rejected
+	_, err = Decrypt(encrypted[:len(prefix)+4], passphrase)
+	require.Error(t, err)
+	_, err = Decrypt("access grant", passphrase)
+	require.Error(t, err)
+}
+
+func TestDecryptFields(t *testing.T) {
+	passphrase := []byte("passphrase")
+
+	encrypted, err := Encrypt("secret", passphrase)
+	require.NoError(t, err)
+
+	type Nested struct {
+		Token string
+	}
+	var config struct {
+		Plain  string
+		Secret string
+		Nested Nested
+		Count  int
+	}
+	config.Plain = "plain"
+	config.Secret = encrypted
+	config.Nested.Token = encrypted
+
+	calls := 0
+	require.NoError(t, DecryptFields(&config, func() ([]byte, error) {
+		calls++
+		return passphrase, nil
+	}))
+	require.Equal(t, "plain", config.Plain)
+	require.Equal(t, "secret", config.Secret)
+	require.Equal(t, "secret", config.Nested.Token)
+	require.Equal(t, 1, calls)
+
+	// the passphrase is not requested when nothing is encrypted
+	require.NoError(t, DecryptFields(&config, func() ([]byte, error) {
+		return nil, errors.New("unexpected call")
+	}))
+
+	require.Error(t, DecryptFields(config, nil))
+}
@@ -0,0 +1,185 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package configdiff compares the settings that a running process uses with
+// its configuration file, to find drift like flags and environment variables
+// that were set for an emergency and never written back to the file.
+package configdiff
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"storj.io/stargate/internal/redact"
+)
+
+// sensitiveNames are parts of the names of settings whose values are never
+// reported.
+var sensitiveNames = []string{"secret", "token", "password", "passphrase", "access-grant"}
+
+// Setting is the value of a setting in a running process.
+type Setting struct {
+	Value   string `json:"value"`
+	Default string `json:"default"`
+
+	// Redacted is whether the value is a credential that is not reported, and
+	// so can't be compared either.
+	Redacted bool `json:"redacted,omitempty"`
+}
+
+// Settings returns the current values of flags, which include the values of
+// the configuration file and of the environment, except for the ignored flags.
+// The values of credentials are redacted.
+func Settings(flags *pflag.FlagSet, ignored ...string) map[string]Setting {
+	skip := make(map[string]bool, len(ignored))
+	for _, name := range ignored {
+		skip[name] = true
+	}
+
+	settings := make(map[string]Setting)
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if skip[flag.Name] {
+			return
+		}
+		setting := Setting{Value: flag.Value.String(), Default: flag.DefValue}
+		if isSensitive(flag.Name) || redact.String(setting.Value) != setting.Value || redact.String(setting.Default) != setting.Default {
+			setting = Setting{Value: redact.Replacement, Default: redact.Replacement, Redacted: true}
+		}
+		settings[flag.Name] = setting
+	})
+	return settings
+}
+
+// isSensitive returns whether the setting with the name is a credential.
+func isSensitive(name string) bool {
+	name = strings.ToLower(name)
+	for _, sensitive := range sensitiveNames {
+		if strings.Contains(name, sensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+// Flatten returns the values of a parsed configuration file by their dotted
+// keys, so that both
+//
+//	server:
+//	  address: :7777
+//
+// and
+//
+//	server.address: :7777
+//
+// are the setting server.address.
+func Flatten(values interface{}) map[string]string {
+	flat := make(map[string]string)
+	flatten(flat, "", values)
+	return flat
+}
+
+func flatten(flat map[string]string, prefix string, value interface{}) {
+	join := func(key interface{}) string {
+		if prefix == "" {
+			return fmt.Sprint(key)
+		}
+		return prefix + "." + fmt.Sprint(key)
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, v := range value {
+			flatten(flat, join(key), v)
+		}
+	case map[interface{}]interface{}:
+		for key, v := range value {
+			flatten(flat, join(key), v)
+		}
+	case []interface{}:
+		items := make([]string, len(value))
+		for i, item := range value {
+			items[i] = fmt.Sprint(item)
+		}
+		flat[prefix] = "[" + strings.Join(items, ",") + "]"
+	case nil:
+		flat[prefix] = ""
+	default:
+		flat[prefix] = fmt.Sprint(value)
+	}
+}
+
+// Sources of the configured values of drifted settings.
+const (
+	SourceFile    = "file"
+	SourceDefault = "default"
+)
+
+// Drift is a setting whose running value is not the configured one.
+type Drift struct {
+	Key        string `json:"key"`
+	Configured string `json:"configured"`
+	Running    string `json:"running"`
+
+	// Source is where the configured value comes from: the file, or the
+	// default when the file does not have the setting.
+	Source string `json:"source"`
+}
+
+// Diff returns the settings whose running value differs from the value in the
+// file, or from the default when the file does not have them, sorted by key.
+// Redacted settings and settings of the file that the process does not have
+// are not compared.
+func Diff(file map[string]string, running map[string]Setting) []Drift {
+	keys := make([]string, 0, len(running))
+	for key := range running {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var drift []Drift
+	for _, key := range keys {
+		setting := running[key]
+		if setting.Redacted {
+			continue
+		}
+
+		configured, source := file[key], SourceFile
+		if _, ok := file[key]; !ok {
+			configured, source = setting.Default, SourceDefault
+		}
+		if !equal(configured, setting.Value) {
+			drift = append(drift, Drift{
+				Key:        key,
+				Configured: configured,
+				Running:    setting.Value,
+				Source:     source,
+			})
+		}
+	}
+	return drift
+}
+
+// equal returns whether a and b are the same value, like 5m and 5m0s.
+func equal(a, b string) bool {
+	if a == b {
+		return true
+	}
+	if x, err := time.ParseDuration(a); err == nil {
+		y, err := time.ParseDuration(b)
+		return err == nil && x == y
+	}
+	if x, err := strconv.ParseFloat(a, 64); err == nil {
+		y, err := strconv.ParseFloat(b, 64)
+		return err == nil && x == y
+	}
+	if x, err := strconv.ParseBool(a); err == nil {
+		y, err := strconv.ParseBool(b)
+		return err == nil && x == y
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package configdiff_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/stargate/internal/configdiff"
+	"storj.io/stargate/internal/redact"
+)
+
+func TestSettings(t *testing.T) {
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.String("server.address", ":7777", "")
+	flags.Duration("client.dial-timeout", 2*time.Minute, "")
+	flags.String("reconcile.console-token", "", "")
+	flags.String("config-dir", "", "")
+
+	require.NoError(t, flags.Parse([]string{
+		"--server.address", ":8888",
+		"--reconcile.console-token", "hunter2",
+		"--config-dir", "/etc/stargate",
+	}))
+
+	settings := configdiff.Settings(flags, "config-dir")
+	require.Equal(t, map[string]configdiff.Setting{
+		"server.address":          {Value: ":8888", Default: ":7777"},
+		"client.dial-timeout":     {Value: "2m0s", Default: "2m0s"},
+		"reconcile.console-token": {Value: redact.Replacement, Default: redact.Replacement, Redacted: true},
+	}, settings)
+}
+
+func TestFlatten(t *testing.T) {
+	flat := configdiff.Flatten(map[interface{}]interface{}{
+		"server.address": ":7777",
+		"client": map[interface{}]interface{}{
+			"dial-timeout": "2m",
+		},
+		"tracing": map[string]interface{}{
+			"sample": 0.1,
+		},
+		"buckets.aliases": []interface{}{"a", "b"},
+		"minio.dir":       nil,
+	})
+	require.Equal(t, map[string]string{
+		"server.address":      ":7777",
+		"client.dial-timeout": "2m",
+		"tracing.sample":      "0.1",
+		"buckets.aliases":     "[a,b]",
+		"minio.dir":           "",
+	}, flat)
+}
+
+func TestDiff(t *testing.T) {
+	file := map[string]string{
+		"server.address":      ":7777",
+		"client.dial-timeout": "2m",
+		"tracing.sample":      "0.10",
+		"unknown.setting":     "value",
+	}
+	running := map[string]configdiff.Setting{
+		"server.address":          {Value: ":8888", Default: ":7777"},
+		"client.dial-timeout":     {Value: "2m0s", Default: "1m0s"},
+		"tracing.sample":          {Value: "0.1", Default: "0"},
+		"tracing.enabled":         {Value: "true", Default: "false"},
+		"log.level":               {Value: "info", Default: "info"},
+		"reconcile.console-token": {Value: redact.Replacement, Default: redact.Replacement, Redacted: true},
+	}
+
+	require.Equal(t, []configdiff.Drift{
+		{Key: "server.address", Configured: ":7777", Running: ":8888", Source: configdiff.SourceFile},
+		{Key: "tracing.enabled", Configured: "false", Running: "true", Source: configdiff.SourceDefault},
+	}, configdiff.Diff(file, running))
+
+	file["server.address"] = ":8888"
+	file["tracing.enabled"] = "true"
+	require.Empty(t, configdiff.Diff(file, running))
+}
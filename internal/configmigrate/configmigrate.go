@@ -0,0 +1,179 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package configmigrate maps settings that were renamed or removed to their
+// current names, so that configurations keep working across releases.
+package configmigrate
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/spf13/pflag"
+	"github.com/zeebo/errs"
+	"gopkg.in/yaml.v2"
+
+	"storj.io/stargate/internal/configdiff"
+)
+
+// Error is the errs class of configmigrate errors.
+var Error = errs.Class("configmigrate")
+
+// Rename is a setting that was renamed, or removed when New is empty.
+type Rename struct {
+	Old string `json:"old"`
+	New string `json:"new,omitempty"`
+	// Release is the release that renamed or removed the setting.
+	Release string `json:"release"`
+}
+
+// String describes the rename for warnings.
+func (rename Rename) String() string {
+	if rename.New == "" {
+		return fmt.Sprintf("setting %s was removed in %s and is ignored", rename.Old, rename.Release)
+	}
+	return fmt.Sprintf("setting %s was renamed to %s in %s", rename.Old, rename.New, rename.Release)
+}
+
+// Normalize returns a normalization func for flag sets that maps the old names
+// of renamed flags to their new names, so that command lines with the old
+// names keep working. The first use of every old name is passed to warn.
+func Normalize(renames []Rename, warn func(Rename)) func(flags *pflag.FlagSet, name string) pflag.NormalizedName {
+	byOld := make(map[string]Rename, len(renames))
+	for _, rename := range renames {
+		if rename.New != "" {
+			byOld[rename.Old] = rename
+		}
+	}
+
+	var mu sync.Mutex
+	warned := make(map[string]bool)
+	return func(flags *pflag.FlagSet, name string) pflag.NormalizedName {
+		rename, ok := byOld[name]
+		if !ok {
+			return pflag.NormalizedName(name)
+		}
+		mu.Lock()
+		if !warned[name] {
+			warned[name] = true
+			warn(rename)
+		}
+		mu.Unlock()
+		return pflag.NormalizedName(rename.New)
+	}
+}
+
+// Apply sets the flags of renamed settings that the configuration file has
+// under their old names, unless the file or the command line set them under
+// their new names. file has the values of the configuration file by their
+// dotted keys. Every renamed or removed setting of the file is passed to warn.
+func Apply(flags *pflag.FlagSet, file map[string]string, renames []Rename, warn func(Rename)) error {
+	var group errs.Group
+	for _, rename := range renames {
+		value, ok := file[rename.Old]
+		if !ok {
+			continue
+		}
+		warn(rename)
+		if rename.New == "" {
+			continue
+		}
+		if _, ok := file[rename.New]; ok {
+			continue
+		}
+		flag := flags.Lookup(rename.New)
+		if flag == nil || flag.Changed {
+			continue
+		}
+		if err := flags.Set(rename.New, value); err != nil {
+			group.Add(Error.New("setting %s from %s: %v", rename.New, rename.Old, err))
+		}
+	}
+	return group.Err()
+}
+
+// keyLine matches a line of a yaml file that sets a key.
+var keyLine = regexp.MustCompile(`^(\s*)([A-Za-z0-9_.-]+):(\s.*)?$`)
+
+// Rewrite rewrites the settings of a yaml configuration file that were renamed
+// or removed, and returns the renames that it applied. Lines are rewritten in
+// place, so that comments are kept. Renamed settings are moved to the end of
+// the file when their new names are outside the section of the old ones, and
+// settings that the file has under their old and new names keep only the new
+// one.
+func Rewrite(data []byte, renames []Rename) (_ []byte, applied []Rename, err error) {
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, nil, Error.Wrap(err)
+	}
+	existing := configdiff.Flatten(values)
+
+	byOld := make(map[string]Rename, len(renames))
+	for _, rename := range renames {
+		byOld[rename.Old] = rename
+	}
+
+	type parent struct {
+		indent int
+		key    string
+	}
+	var parents []parent
+	var output, moved bytes.Buffer
+
+	lines := strings.SplitAfter(string(data), "\n")
+	for _, line := range lines {
+		match := keyLine.FindStringSubmatch(strings.TrimRight(line, "\r\n"))
+		if match == nil {
+			output.WriteString(line)
+			continue
+		}
+		indent, key, value := len(match[1]), match[2], match[3]
+
+		for len(parents) > 0 && parents[len(parents)-1].indent >= indent {
+			parents = parents[:len(parents)-1]
+		}
+		var path []string
+		for _, p := range parents {
+			path = append(path, p.key)
+		}
+		prefix := strings.Join(path, ".")
+		full := key
+		if prefix != "" {
+			full = prefix + "." + key
+		}
+
+		if strings.TrimSpace(value) == "" {
+			parents = append(parents, parent{indent: indent, key: key})
+		}
+
+		rename, ok := byOld[full]
+		if !ok {
+			output.WriteString(line)
+			continue
+		}
+		applied = append(applied, rename)
+
+		if _, ok := existing[rename.New]; rename.New == "" || ok {
+			continue
+		}
+		switch {
+		case prefix == "":
+			output.WriteString(match[1] + rename.New + ":" + value + "\n")
+		case strings.HasPrefix(rename.New, prefix+"."):
+			output.WriteString(match[1] + strings.TrimPrefix(rename.New, prefix+".") + ":" + value + "\n")
+		default:
+			moved.WriteString(rename.New + ":" + value + "\n")
+		}
+	}
+
+	if moved.Len() > 0 {
+		if output.Len() > 0 && !bytes.HasSuffix(output.Bytes(), []byte("\n")) {
+			output.WriteString("\n")
+		}
+		output.Write(moved.Bytes())
+	}
+	return output.Bytes(), applied, nil
+}
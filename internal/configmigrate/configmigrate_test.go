@@ -0,0 +1,138 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package configmigrate_test
+
+import (
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/stargate/internal/configmigrate"
+)
+
+var renames = []configmigrate.Rename{
+	{Old: "server.addr", New: "server.address", Release: "v1.1.0"},
+	{Old: "client.timeout", New: "client.dial-timeout", Release: "v1.1.0"},
+	{Old: "minio.legacy", Release: "v1.2.0"},
+	{Old: "auth-token", New: "auth.token", Release: "v1.2.0"},
+	{Old: "minio.addr", New: "minio-address", Release: "v1.2.0"},
+}
+
+func TestNormalize(t *testing.T) {
+	var warned []configmigrate.Rename
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.SetNormalizeFunc(configmigrate.Normalize(renames, func(rename configmigrate.Rename) {
+		warned = append(warned, rename)
+	}))
+	address := flags.String("server.address", ":7777", "")
+	timeout := flags.String("client.dial-timeout", "2m", "")
+
+	require.NoError(t, flags.Parse([]string{"--server.addr", ":8888", "--server.addr", ":9999", "--client.dial-timeout", "1m"}))
+	require.Equal(t, ":9999", *address)
+	require.Equal(t, "1m", *timeout)
+	require.Equal(t, []configmigrate.Rename{renames[0]}, warned)
+}
+
+func TestApply(t *testing.T) {
+	newFlags := func() *pflag.FlagSet {
+		flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		flags.String("server.address", ":7777", "")
+		flags.String("client.dial-timeout", "2m", "")
+		flags.Int("auth.token", 0, "")
+		return flags
+	}
+
+	t.Run("old names", func(t *testing.T) {
+		var warned []configmigrate.Rename
+		flags := newFlags()
+		err := configmigrate.Apply(flags, map[string]string{
+			"server.addr":  ":8888",
+			"minio.legacy": "true",
+		}, renames, func(rename configmigrate.Rename) { warned = append(warned, rename) })
+		require.NoError(t, err)
+		require.Equal(t, ":8888", flags.Lookup("server.address").Value.String())
+		require.Equal(t, []configmigrate.Rename{renames[0], renames[2]}, warned)
+	})
+
+	t.Run("new names win", func(t *testing.T) {
+		flags := newFlags()
+		require.NoError(t, flags.Parse([]string{"--client.dial-timeout", "1m"}))
+		err := configmigrate.Apply(flags, map[string]string{
+			"server.addr":    ":8888",
+			"server.address": ":9999",
+			"client.timeout": "3m",
+		}, renames, func(configmigrate.Rename) {})
+		require.NoError(t, err)
+		require.Equal(t, ":7777", flags.Lookup("server.address").Value.String())
+		require.Equal(t, "1m", flags.Lookup("client.dial-timeout").Value.String())
+	})
+
+	t.Run("invalid value", func(t *testing.T) {
+		err := configmigrate.Apply(newFlags(), map[string]string{
+			"auth-token": "nope",
+		}, renames, func(configmigrate.Rename) {})
+		require.Error(t, err)
+	})
+}
+
+func TestRewrite(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		config  string
+		want    string
+		applied []configmigrate.Rename
+	}{
+		{
+			name:   "nothing to migrate",
+			config: "# the address\nserver.address: :7777\n",
+			want:   "# the address\nserver.address: :7777\n",
+		},
+		{
+			name:    "flat keys",
+			config:  "# the address to listen on\nserver.addr: :8888\n# removed\nminio.legacy: true\nclient.timeout: 1m\n",
+			want:    "# the address to listen on\nserver.address: :8888\n# removed\nclient.dial-timeout: 1m\n",
+			applied: []configmigrate.Rename{renames[0], renames[2], renames[1]},
+		},
+		{
+			name:    "nested keys",
+			config:  "server:\n  # the address\n  addr: :8888\n  debug: true\nclient:\n  timeout: 1m\n",
+			want:    "server:\n  # the address\n  address: :8888\n  debug: true\nclient:\n  dial-timeout: 1m\n",
+			applied: []configmigrate.Rename{renames[0], renames[1]},
+		},
+		{
+			name:    "renamed below another key",
+			config:  "auth-token: 5\nserver.address: :7777",
+			want:    "auth.token: 5\nserver.address: :7777",
+			applied: []configmigrate.Rename{renames[3]},
+		},
+		{
+			name:    "moved out of a section",
+			config:  "minio:\n  addr: :8888\n  dir: /tmp",
+			want:    "minio:\n  dir: /tmp\nminio-address: :8888\n",
+			applied: []configmigrate.Rename{renames[4]},
+		},
+		{
+			name:    "both names",
+			config:  "server.addr: :8888\nserver.address: :9999\n",
+			want:    "server.address: :9999\n",
+			applied: []configmigrate.Rename{renames[0]},
+		},
+		{
+			name:   "commented defaults",
+			config: "# server.addr: :7777\n",
+			want:   "# server.addr: :7777\n",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			migrated, applied, err := configmigrate.Rewrite([]byte(tt.config), renames)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, string(migrated))
+			require.Equal(t, tt.applied, applied)
+		})
+	}
+
+	_, _, err := configmigrate.Rewrite([]byte("server: [\n"), renames)
+	require.Error(t, err)
+}
@@ -0,0 +1,54 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package keychain
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"storj.io/stargate/internal/configcrypt"
+)
+
+// NewCommand returns a command for managing the values kept in the credential store.
+func NewCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "keychain",
+		Short: "Keep sensitive config values in the operating system's credential store",
+		Long: `Sensitive values, like access grants and auth tokens, can be kept in the
+macOS Keychain, the Windows Credential Manager or the Secret Service (libsecret)
+instead of in config.yaml. Replace the value in config.yaml with keychain:<name>
+to use the value stored under name. Any string config value can be kept there.`,
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "store <name>",
+		Short: "Store a value in the credential store",
+		Long: `Reads a value and stores it in the credential store under name, replacing
+any value already stored under it. Prints the reference to use in config.yaml.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			value, err := configcrypt.ReadSecret("Enter the value to store: ")
+			if err != nil {
+				return err
+			}
+			if err := Store(args[0], value); err != nil {
+				return err
+			}
+			_, err = fmt.Fprintln(cmd.OutOrStdout(), Reference(args[0]))
+			return err
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "remove <name>",
+		Short: "Remove a value from the credential store",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return Remove(args[0])
+		},
+	})
+
+	return cmd
+}
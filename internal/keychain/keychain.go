@@ -0,0 +1,87 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package keychain keeps sensitive config values, like access grants and auth
+// tokens, in the credential store of the operating system: the Keychain on
+// macOS, the Credential Manager on Windows and the Secret Service (libsecret)
+// on Linux desktops.
+//
+// A value in the credential store is referenced from config.yaml as
+//
+//	keychain:<name>
+//
+// and is looked up by name when the config is loaded.
+package keychain
+
+import (
+	"strings"
+
+	"github.com/zalando/go-keyring"
+	"github.com/zeebo/errs"
+
+	"storj.io/stargate/internal/configcrypt"
+)
+
+// Error is the error class for this package.
+var Error = errs.Class("keychain")
+
+// Service is the name that values are stored under in the credential store.
+const Service = "storj-gateway"
+
+const prefix = "keychain:"
+
+// IsReference returns whether value references a value in the credential store.
+func IsReference(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// Reference returns the config value that references the value stored as name.
+func Reference(name string) string {
+	return prefix + name
+}
+
+// Store saves value in the credential store under name, replacing any value
+// already stored under it.
+func Store(name, value string) error {
+	if name == "" {
+		return Error.New("name cannot be empty")
+	}
+	if err := keyring.Set(Service, name, value); err != nil {
+		return Error.New("unable to store %q in the credential store: %v", name, err)
+	}
+	return nil
+}
+
+// Lookup returns the value stored under name in the credential store.
+func Lookup(name string) (string, error) {
+	value, err := keyring.Get(Service, name)
+	if errs.Is(err, keyring.ErrNotFound) {
+		return "", Error.New("%q is not in the credential store", name)
+	} else if err != nil {
+		return "", Error.New("unable to read %q from the credential store: %v", name, err)
+	}
+	return value, nil
+}
+
+// Remove deletes the value stored under name from the credential store.
+func Remove(name string) error {
+	err := keyring.Delete(Service, name)
+	if errs.Is(err, keyring.ErrNotFound) {
+		return Error.New("%q is not in the credential store", name)
+	} else if err != nil {
+		return Error.New("unable to remove %q from the credential store: %v", name, err)
+	}
+	return nil
+}
+
+// ResolveFields walks the struct that config points to and replaces every
+// string field that references the credential store with the stored value.
+// The credential store is only accessed if there is such a field.
+func ResolveFields(config interface{}) error {
+	return configcrypt.ReplaceStrings(config, func(value string) (string, error) {
+		if !IsReference(value) {
+			return value, nil
+		}
+		return Lookup(strings.TrimPrefix(value, prefix))
+	})
+}
@@ -0,0 +1,42 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package keychain_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zalando/go-keyring"
+
+	"storj.io/stargate/internal/keychain"
+)
+
+func TestResolveFields(t *testing.T) {
+	keyring.MockInit()
+
+	require.NoError(t, keychain.Store("grant", "secret grant"))
+
+	var config struct {
+		Access struct {
+			Grant string
+		}
+		Plain string
+	}
+	config.Access.Grant = keychain.Reference("grant")
+	config.Plain = "plain"
+
+	require.NoError(t, keychain.ResolveFields(&config))
+	require.Equal(t, "secret grant", config.Access.Grant)
+	require.Equal(t, "plain", config.Plain)
+
+	require.NoError(t, keychain.Remove("grant"))
+	config.Access.Grant = keychain.Reference("grant")
+
+	err := keychain.ResolveFields(&config)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "Access: Grant")
+
+	require.Error(t, keychain.Remove("grant"))
+	require.Error(t, keychain.Store("", "value"))
+}
@@ -0,0 +1,318 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package metaindex indexes the keys, tags and user metadata of objects as
+// they are written, so that objects can be searched by them without listing
+// whole buckets. Objects are indexed per project, so that buckets with the
+// same name in other projects don't share entries.
+package metaindex
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3" // the index is a sqlite database
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
+)
+
+var mon = monkit.Package()
+
+// Error is the errs class of metaindex errors.
+var Error = errs.Class("metaindex")
+
+// Config configures the index.
+type Config struct {
+	Path string `help:"path of the sqlite database that indexes the keys, tags and user metadata of the objects that the gateway writes, so that they can be searched with the admin api; the index has the decrypted keys and metadata, and is disabled when empty" default:""`
+}
+
+// taggingKey is the user defined metadata that minio keeps the tags of an
+// object in, like k1=v1&k2=v2.
+const taggingKey = "X-Amz-Tagging"
+
+// kinds of attributes.
+const (
+	kindTag      = "tag"
+	kindMetadata = "meta"
+)
+
+// Entry is an indexed object.
+type Entry struct {
+	// Project identifies the project of the object, like a hash of the
+	// macaroon head of the API key that wrote it.
+	Project  string            `json:"-"`
+	Bucket   string            `json:"bucket"`
+	Key      string            `json:"key"`
+	Size     int64             `json:"size"`
+	Modified time.Time         `json:"modified"`
+	Tags     map[string]string `json:"tags,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// NewEntry returns the entry of an object of the project with the user defined
+// metadata of minio, which has the tags of the object too. Metadata names are
+// lower case, since S3 does not preserve their case.
+func NewEntry(project, bucket, key string, size int64, modified time.Time, userDefined map[string]string) Entry {
+	entry := Entry{Project: project, Bucket: bucket, Key: key, Size: size, Modified: modified}
+	for name, value := range userDefined {
+		if strings.EqualFold(name, taggingKey) {
+			tags, err := url.ParseQuery(value)
+			if err != nil {
+				continue
+			}
+			for tag := range tags {
+				if entry.Tags == nil {
+					entry.Tags = make(map[string]string)
+				}
+				entry.Tags[tag] = tags.Get(tag)
+			}
+			continue
+		}
+		if entry.Metadata == nil {
+			entry.Metadata = make(map[string]string)
+		}
+		entry.Metadata[strings.ToLower(name)] = value
+	}
+	return entry
+}
+
+// Query selects the objects of a bucket of the project that have all of its
+// tags and metadata.
+type Query struct {
+	Project  string
+	Bucket   string
+	Prefix   string
+	Tags     map[string]string
+	Metadata map[string]string
+	// After is the key after which the results start, for pagination.
+	After string
+	Limit int
+}
+
+// Matches returns whether the entry is selected by the query.
+func (query Query) Matches(entry Entry) bool {
+	if entry.Project != query.Project || entry.Bucket != query.Bucket || !strings.HasPrefix(entry.Key, query.Prefix) || entry.Key <= query.After {
+		return false
+	}
+	for name, value := range query.Tags {
+		if actual, ok := entry.Tags[name]; !ok || actual != value {
+			return false
+		}
+	}
+	for name, value := range query.Metadata {
+		if actual, ok := entry.Metadata[strings.ToLower(name)]; !ok || actual != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Index is an index of objects in a sqlite database.
+type Index struct {
+	db *sql.DB
+}
+
+// Open opens the index at path, and creates it if it doesn't exist.
+func Open(ctx context.Context, path string) (_ *Index, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	// sqlite serializes writes anyway
+	db.SetMaxOpenConns(1)
+
+	for _, statement := range []string{
+		`CREATE TABLE IF NOT EXISTS objects (
+			project TEXT NOT NULL,
+			bucket TEXT NOT NULL,
+			key TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			modified INTEGER NOT NULL,
+			PRIMARY KEY (project, bucket, key)
+		)`,
+		`CREATE TABLE IF NOT EXISTS attributes (
+			project TEXT NOT NULL,
+			bucket TEXT NOT NULL,
+			key TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			name TEXT NOT NULL,
+			value TEXT NOT NULL,
+			PRIMARY KEY (project, bucket, key, kind, name)
+		)`,
+		`CREATE INDEX IF NOT EXISTS attributes_value ON attributes (project, bucket, kind, name, value)`,
+	} {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return nil, errs.Combine(Error.Wrap(err), db.Close())
+		}
+	}
+	return &Index{db: db}, nil
+}
+
+// Close closes the index.
+func (index *Index) Close() error {
+	return Error.Wrap(index.db.Close())
+}
+
+// Put indexes an object, replacing what was indexed for its key before.
+func (index *Index) Put(ctx context.Context, entry Entry) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return index.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `INSERT OR REPLACE INTO objects (project, bucket, key, size, modified) VALUES (?, ?, ?, ?, ?)`,
+			entry.Project, entry.Bucket, entry.Key, entry.Size, entry.Modified.UnixNano()); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM attributes WHERE project = ? AND bucket = ? AND key = ?`,
+			entry.Project, entry.Bucket, entry.Key); err != nil {
+			return err
+		}
+		for kind, attributes := range map[string]map[string]string{kindTag: entry.Tags, kindMetadata: entry.Metadata} {
+			for name, value := range attributes {
+				if _, err := tx.ExecContext(ctx, `INSERT INTO attributes (project, bucket, key, kind, name, value) VALUES (?, ?, ?, ?, ?, ?)`,
+					entry.Project, entry.Bucket, entry.Key, kind, name, value); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Delete removes an object of the project from the index.
+func (index *Index) Delete(ctx context.Context, project, bucket, key string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return index.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM objects WHERE project = ? AND bucket = ? AND key = ?`, project, bucket, key); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `DELETE FROM attributes WHERE project = ? AND bucket = ? AND key = ?`, project, bucket, key)
+		return err
+	})
+}
+
+// DeleteBucket removes every object of a bucket of the project from the
+// index.
+func (index *Index) DeleteBucket(ctx context.Context, project, bucket string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	return index.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM objects WHERE project = ? AND bucket = ?`, project, bucket); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `DELETE FROM attributes WHERE project = ? AND bucket = ?`, project, bucket)
+		return err
+	})
+}
+
+// Search returns the indexed objects that the query selects, ordered by key.
+func (index *Index) Search(ctx context.Context, query Query) (_ []Entry, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	sqlQuery := `SELECT key, size, modified FROM objects WHERE project = ? AND bucket = ? AND key > ? AND substr(key, 1, ?) = ?`
+	args := []interface{}{query.Project, query.Bucket, query.After, len(query.Prefix), query.Prefix}
+	for _, attributes := range []struct {
+		kind   string
+		values map[string]string
+	}{{kindTag, query.Tags}, {kindMetadata, query.Metadata}} {
+		for _, name := range sortedNames(attributes.values) {
+			value := attributes.values[name]
+			if attributes.kind == kindMetadata {
+				name = strings.ToLower(name)
+			}
+			sqlQuery += ` AND EXISTS (SELECT 1 FROM attributes a WHERE a.project = objects.project AND a.bucket = objects.bucket AND a.key = objects.key AND a.kind = ? AND a.name = ? AND a.value = ?)`
+			args = append(args, attributes.kind, name, value)
+		}
+	}
+	sqlQuery += ` ORDER BY key`
+	if query.Limit > 0 {
+		sqlQuery += ` LIMIT ?`
+		args = append(args, query.Limit)
+	}
+
+	rows, err := index.db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	defer func() { err = errs.Combine(err, Error.Wrap(rows.Close())) }()
+
+	var entries []Entry
+	for rows.Next() {
+		entry := Entry{Project: query.Project, Bucket: query.Bucket}
+		var modified int64
+		if err := rows.Scan(&entry.Key, &entry.Size, &modified); err != nil {
+			return nil, Error.Wrap(err)
+		}
+		entry.Modified = time.Unix(0, modified).UTC()
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	for i := range entries {
+		if err := index.attributes(ctx, &entries[i]); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// attributes reads the tags and metadata of the entry.
+func (index *Index) attributes(ctx context.Context, entry *Entry) (err error) {
+	rows, err := index.db.QueryContext(ctx, `SELECT kind, name, value FROM attributes WHERE project = ? AND bucket = ? AND key = ?`,
+		entry.Project, entry.Bucket, entry.Key)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	defer func() { err = errs.Combine(err, Error.Wrap(rows.Close())) }()
+
+	for rows.Next() {
+		var kind, name, value string
+		if err := rows.Scan(&kind, &name, &value); err != nil {
+			return Error.Wrap(err)
+		}
+		switch kind {
+		case kindTag:
+			if entry.Tags == nil {
+				entry.Tags = make(map[string]string)
+			}
+			entry.Tags[name] = value
+		case kindMetadata:
+			if entry.Metadata == nil {
+				entry.Metadata = make(map[string]string)
+			}
+			entry.Metadata[name] = value
+		}
+	}
+	return Error.Wrap(rows.Err())
+}
+
+// inTx runs fn in a transaction, which is committed if fn succeeds.
+func (index *Index) inTx(ctx context.Context, fn func(tx *sql.Tx) error) (err error) {
+	tx, err := index.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	if err := fn(tx); err != nil {
+		return errs.Combine(Error.Wrap(err), Error.Wrap(tx.Rollback()))
+	}
+	return Error.Wrap(tx.Commit())
+}
+
+// sortedNames returns the names of values sorted, so that queries are
+// deterministic.
+func sortedNames(values map[string]string) []string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
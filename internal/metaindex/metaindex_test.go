@@ -0,0 +1,94 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package metaindex_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/stargate/internal/metaindex"
+)
+
+func TestNewEntry(t *testing.T) {
+	modified := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+	entry := metaindex.NewEntry("project", "bucket", "key", 10, modified, map[string]string{
+		"X-Amz-Meta-Project": "apollo",
+		"content-type":       "text/plain",
+		"X-Amz-Tagging":      "team=storage&env=prod",
+	})
+	require.Equal(t, metaindex.Entry{
+		Project:  "project",
+		Bucket:   "bucket",
+		Key:      "key",
+		Size:     10,
+		Modified: modified,
+		Tags:     map[string]string{"team": "storage", "env": "prod"},
+		Metadata: map[string]string{"x-amz-meta-project": "apollo", "content-type": "text/plain"},
+	}, entry)
+}
+
+func TestIndex(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "metaindex")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	index, err := metaindex.Open(ctx, filepath.Join(dir, "index.db"))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, index.Close()) }()
+
+	modified := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+	put := func(project, bucket, key, tagging, mission string) metaindex.Entry {
+		entry := metaindex.NewEntry(project, bucket, key, 1, modified, map[string]string{
+			"X-Amz-Tagging":      tagging,
+			"X-Amz-Meta-Project": mission,
+		})
+		require.NoError(t, index.Put(ctx, entry))
+		return entry
+	}
+	a := put("p1", "bucket", "logs/a", "team=storage", "apollo")
+	b := put("p1", "bucket", "logs/b", "team=storage&env=prod", "gemini")
+	c := put("p1", "bucket", "photos/c", "team=storage", "apollo")
+	put("p1", "other", "logs/a", "team=storage", "apollo")
+	// the bucket of another project with the same name
+	d := put("p2", "bucket", "logs/a", "team=storage", "mercury")
+
+	search := func(query metaindex.Query) []metaindex.Entry {
+		entries, err := index.Search(ctx, query)
+		require.NoError(t, err)
+		for _, entry := range entries {
+			require.True(t, query.Matches(entry))
+		}
+		return entries
+	}
+
+	require.Equal(t, []metaindex.Entry{a, b, c}, search(metaindex.Query{Project: "p1", Bucket: "bucket", Tags: map[string]string{"team": "storage"}}))
+	require.Equal(t, []metaindex.Entry{b}, search(metaindex.Query{Project: "p1", Bucket: "bucket", Tags: map[string]string{"team": "storage", "env": "prod"}}))
+	require.Equal(t, []metaindex.Entry{a, c}, search(metaindex.Query{Project: "p1", Bucket: "bucket", Metadata: map[string]string{"X-Amz-Meta-Project": "apollo"}}))
+	require.Equal(t, []metaindex.Entry{a}, search(metaindex.Query{Project: "p1", Bucket: "bucket", Prefix: "logs/", Metadata: map[string]string{"x-amz-meta-project": "apollo"}}))
+	require.Equal(t, []metaindex.Entry{a, b}, search(metaindex.Query{Project: "p1", Bucket: "bucket", Limit: 2}))
+	require.Equal(t, []metaindex.Entry{c}, search(metaindex.Query{Project: "p1", Bucket: "bucket", After: "logs/b"}))
+
+	// overwrites replace the tags and metadata
+	b = put("p1", "bucket", "logs/b", "team=compute", "gemini")
+	require.Equal(t, []metaindex.Entry{a, c}, search(metaindex.Query{Project: "p1", Bucket: "bucket", Tags: map[string]string{"team": "storage"}}))
+	require.Equal(t, []metaindex.Entry{b}, search(metaindex.Query{Project: "p1", Bucket: "bucket", Tags: map[string]string{"team": "compute"}}))
+
+	require.NoError(t, index.Delete(ctx, "p1", "bucket", "logs/a"))
+	require.Equal(t, []metaindex.Entry{b, c}, search(metaindex.Query{Project: "p1", Bucket: "bucket"}))
+
+	// the other project keeps its own entries
+	require.Equal(t, []metaindex.Entry{d}, search(metaindex.Query{Project: "p2", Bucket: "bucket"}))
+
+	require.NoError(t, index.DeleteBucket(ctx, "p1", "bucket"))
+	require.Empty(t, search(metaindex.Query{Project: "p1", Bucket: "bucket"}))
+	require.Len(t, search(metaindex.Query{Project: "p1", Bucket: "other"}), 1)
+	require.Equal(t, []metaindex.Entry{d}, search(metaindex.Query{Project: "p2", Bucket: "bucket"}))
+}
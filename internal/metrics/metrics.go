@@ -0,0 +1,180 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package metrics maps the internal metrics of the gateway and the auth
+// service to stable, documented names, so that dashboards and alerts don't
+// depend on monkit names that may change between releases.
+//
+// The names follow the Prometheus conventions: they are prefixed with
+// stargate_, counters end with _total and units are part of the name. A name
+// and its labels are only ever added, never changed or removed.
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+)
+
+// Kind is the kind of a metric.
+type Kind string
+
+const (
+	// Counter is a value that only increases while the process runs.
+	Counter Kind = "counter"
+	// Gauge is a value that goes up and down.
+	Gauge Kind = "gauge"
+)
+
+// Definition defines a stable metric, and the monkit series and field that it
+// is read from.
+type Definition struct {
+	Name   string   `json:"name"`
+	Help   string   `json:"help"`
+	Kind   Kind     `json:"kind"`
+	Labels []string `json:"labels,omitempty"`
+
+	Measurement string `json:"-"`
+	Field       string `json:"-"`
+}
+
+// Definitions are the stable metrics.
+var Definitions = []Definition{
+	// auth database
+	{Name: "stargate_kv_calls_total", Help: "calls to the auth database", Kind: Counter, Labels: []string{"operation", "backend"}, Measurement: "kv_calls", Field: "value"},
+	{Name: "stargate_kv_errors_total", Help: "failed calls to the auth database by the class of their error", Kind: Counter, Labels: []string{"operation", "backend", "class"}, Measurement: "kv_errors", Field: "value"},
+	{Name: "stargate_kv_not_found_total", Help: "lookups of access keys that don't exist", Kind: Counter, Labels: []string{"operation", "backend"}, Measurement: "kv_not_found", Field: "value"},
+	{Name: "stargate_kv_call_seconds_p50", Help: "median duration of recent calls to the auth database", Kind: Gauge, Labels: []string{"operation", "backend"}, Measurement: "kv_seconds", Field: "r50"},
+	{Name: "stargate_kv_call_seconds_p99", Help: "99th percentile duration of recent calls to the auth database", Kind: Gauge, Labels: []string{"operation", "backend"}, Measurement: "kv_seconds", Field: "r99"},
+	{Name: "stargate_cache_hits_total", Help: "lookups that were answered by the record cache", Kind: Counter, Measurement: "cache_hit", Field: "total"},
+	{Name: "stargate_cache_misses_total", Help: "lookups that missed the record cache", Kind: Counter, Measurement: "cache_miss", Field: "total"},
+	{Name: "stargate_breaker_opened_total", Help: "times the circuit breaker of the auth database opened", Kind: Counter, Measurement: "breaker_opened", Field: "total"},
+	{Name: "stargate_replication_pending", Help: "changes that wait to be replicated to the secondary auth database", Kind: Gauge, Measurement: "replication_pending", Field: "recent"},
+	{Name: "stargate_bruteforce_bans_total", Help: "clients or access keys that were banned for too many failed lookups", Kind: Counter, Measurement: "bruteforce_bans", Field: "value"},
+	{Name: "stargate_auth_rate_limited_total", Help: "requests to the auth service that were rejected by the rate limits of auth tokens and client ips", Kind: Counter, Measurement: "ratelimit_rejected", Field: "value"},
+
+	// gateway
+	{Name: "stargate_gateway_projects_open", Help: "projects that the gateway keeps open", Kind: Gauge, Measurement: "gateway_projects_open", Field: "recent"},
+	{Name: "stargate_gateway_slow_requests_total", Help: "requests that took longer than the slow request threshold", Kind: Counter, Labels: []string{"operation"}, Measurement: "gateway_slow_requests", Field: "value"},
+	{Name: "stargate_gateway_satellite_unreachable_total", Help: "requests that failed because the satellite couldn't be reached", Kind: Counter, Measurement: "satellite_unreachable", Field: "total"},
+	{Name: "stargate_gateway_auth_degraded_served_total", Help: "access keys that were resolved from cache in degraded mode because the auth resolvers failed", Kind: Counter, Measurement: "auth_degraded_served", Field: "total"},
+	{Name: "stargate_gateway_admission_rejected_total", Help: "requests that were rejected because the admission queue was full or they waited too long", Kind: Counter, Labels: []string{"reason"}, Measurement: "admission_rejected", Field: "value"},
+	{Name: "stargate_gateway_admission_wait_seconds_p99", Help: "99th percentile of how long recent requests waited in the admission queue", Kind: Gauge, Measurement: "admission_wait_seconds", Field: "r99"},
+	{Name: "stargate_gateway_bucket_quota_exceeded_total", Help: "uploads that were rejected by bucket quotas", Kind: Counter, Measurement: "bucket_quota_exceeded", Field: "total"},
+	{Name: "stargate_slo_burn_rate_availability", Help: "how fast the availability error budget burns in the window", Kind: Gauge, Labels: []string{"class", "window"}, Measurement: "slo_burn_rate", Field: "availability"},
+	{Name: "stargate_slo_burn_rate_latency", Help: "how fast the latency error budget burns in the window", Kind: Gauge, Labels: []string{"class", "window"}, Measurement: "slo_burn_rate", Field: "latency"},
+	{Name: "stargate_anomaly_alerts_total", Help: "alerts of unusual access key usage", Kind: Counter, Labels: []string{"kind"}, Measurement: "anomaly_alerts", Field: "value"},
+	{Name: "stargate_reconcile_difference_bytes", Help: "how many more bytes the satellite accounts for than the gateway", Kind: Gauge, Measurement: "reconcile_difference_bytes", Field: "recent"},
+	{Name: "stargate_billing_export_failures_total", Help: "usage reports that failed to export", Kind: Counter, Measurement: "billing_export_failures", Field: "value"},
+}
+
+// Sample is the value of a metric with some labels.
+type Sample struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  float64           `json:"value"`
+}
+
+// Snapshot is the value of every stable metric at a time.
+type Snapshot struct {
+	Time    time.Time `json:"time"`
+	Samples []Sample  `json:"samples"`
+}
+
+// seriesField identifies the definitions of a monkit series and field.
+type seriesField struct {
+	measurement string
+	field       string
+}
+
+// Collect reads the stable metrics from source, like monkit.Default. Series
+// that only differ by labels that aren't part of a definition, like the scope
+// of monkit, are pre-aggregated: counters are summed, and the largest value
+// of gauges is kept.
+func Collect(source monkit.StatSource, now time.Time) Snapshot {
+	definitions := make(map[seriesField][]Definition)
+	for _, definition := range Definitions {
+		key := seriesField{definition.Measurement, definition.Field}
+		definitions[key] = append(definitions[key], definition)
+	}
+
+	samples := make(map[string]*Sample)
+	source.Stats(func(key monkit.SeriesKey, field string, val float64) {
+		for _, definition := range definitions[seriesField{key.Measurement, field}] {
+			tags := key.Tags.All()
+			labels := make(map[string]string, len(definition.Labels))
+			for _, label := range definition.Labels {
+				if value, ok := tags[label]; ok {
+					labels[label] = value
+				}
+			}
+
+			id := sampleID(definition.Name, labels)
+			sample, ok := samples[id]
+			if !ok {
+				samples[id] = &Sample{Name: definition.Name, Labels: labels, Value: val}
+				continue
+			}
+			switch {
+			case definition.Kind == Counter:
+				sample.Value += val
+			case val > sample.Value:
+				sample.Value = val
+			}
+		}
+	})
+
+	snapshot := Snapshot{Time: now, Samples: make([]Sample, 0, len(samples))}
+	ids := make([]string, 0, len(samples))
+	for id := range samples {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		sample := samples[id]
+		if len(sample.Labels) == 0 {
+			sample.Labels = nil
+		}
+		snapshot.Samples = append(snapshot.Samples, *sample)
+	}
+	return snapshot
+}
+
+// sampleID identifies a metric with labels, and sorts like the metric.
+func sampleID(name string, labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var id strings.Builder
+	id.WriteString(name)
+	for _, key := range keys {
+		id.WriteString("\x00" + key + "=" + labels[key])
+	}
+	return id.String()
+}
+
+// Handler serves snapshots of the stable metrics of source as JSON. The
+// definitions are served instead when the request has the definitions query
+// parameter, so that dashboards can be built from them.
+func Handler(source monkit.StatSource) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var result interface{}
+		if _, ok := req.URL.Query()["definitions"]; ok {
+			result = struct {
+				Definitions []Definition `json:"definitions"`
+			}{Definitions}
+		} else {
+			result = Collect(source, time.Now())
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(result)
+	})
+}
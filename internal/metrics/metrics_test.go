@@ -0,0 +1,97 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package metrics_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/stargate/internal/metrics"
+)
+
+// statSource is a monkit.StatSource of fixed stats.
+type statSource func(cb func(key monkit.SeriesKey, field string, val float64))
+
+func (source statSource) Stats(cb func(key monkit.SeriesKey, field string, val float64)) {
+	source(cb)
+}
+
+func TestCollect(t *testing.T) {
+	calls := func(scope, operation string) monkit.SeriesKey {
+		return monkit.NewSeriesKey("kv_calls").
+			WithTag("scope", scope).
+			WithTag("operation", operation).
+			WithTag("backend", "sql")
+	}
+	source := statSource(func(cb func(key monkit.SeriesKey, field string, val float64)) {
+		// counters of the same labels in different scopes are summed
+		cb(calls("storj.io/stargate/auth/metricsauth", "get"), "value", 3)
+		cb(calls("storj.io/stargate/other", "get"), "value", 2)
+		cb(calls("storj.io/stargate/auth/metricsauth", "put"), "value", 1)
+
+		// gauges keep the largest value
+		cb(monkit.NewSeriesKey("gateway_projects_open").WithTag("scope", "a"), "recent", 4)
+		cb(monkit.NewSeriesKey("gateway_projects_open").WithTag("scope", "b"), "recent", 7)
+
+		// fields and series without a definition are left out
+		cb(monkit.NewSeriesKey("gateway_projects_open"), "count", 100)
+		cb(monkit.NewSeriesKey("trace_sampled"), "total", 100)
+	})
+
+	now := time.Now()
+	snapshot := metrics.Collect(source, now)
+	require.Equal(t, now, snapshot.Time)
+	require.Equal(t, []metrics.Sample{
+		{Name: "stargate_gateway_projects_open", Value: 7},
+		{Name: "stargate_kv_calls_total", Labels: map[string]string{"operation": "get", "backend": "sql"}, Value: 5},
+		{Name: "stargate_kv_calls_total", Labels: map[string]string{"operation": "put", "backend": "sql"}, Value: 1},
+	}, snapshot.Samples)
+}
+
+func TestDefinitions(t *testing.T) {
+	names := make(map[string]bool)
+	for _, definition := range metrics.Definitions {
+		require.False(t, names[definition.Name], "%s is defined twice", definition.Name)
+		names[definition.Name] = true
+
+		require.Regexp(t, `^stargate_[a-z0-9_]+$`, definition.Name)
+		require.NotEmpty(t, definition.Help, definition.Name)
+		require.NotEmpty(t, definition.Measurement, definition.Name)
+		require.NotEmpty(t, definition.Field, definition.Name)
+		if definition.Kind == metrics.Counter {
+			require.Regexp(t, `_total$`, definition.Name)
+		} else {
+			require.Equal(t, metrics.Gauge, definition.Kind, definition.Name)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	source := statSource(func(cb func(key monkit.SeriesKey, field string, val float64)) {
+		cb(monkit.NewSeriesKey("cache_hit"), "total", 2)
+	})
+	handler := metrics.Handler(source)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var snapshot metrics.Snapshot
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &snapshot))
+	require.Equal(t, []metrics.Sample{{Name: "stargate_cache_hits_total", Value: 2}}, snapshot.Samples)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/?definitions", nil))
+
+	var definitions struct {
+		Definitions []metrics.Definition `json:"definitions"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &definitions))
+	require.Len(t, definitions.Definitions, len(metrics.Definitions))
+}
@@ -0,0 +1,326 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+)
+
+var mon = monkit.Package()
+
+// Config configures the plugins of the gateway.
+type Config struct {
+	Paths   string        `help:"comma separated paths of plugin executables, which are started with the gateway" default:""`
+	Timeout time.Duration `help:"how long a call to a plugin may take" default:"5s"`
+}
+
+// notificationQueue is how many notifications are queued for a plugin before
+// new ones are dropped.
+const notificationQueue = 100
+
+// Client is a running plugin.
+type Client struct {
+	log     *zap.Logger
+	info    Info
+	timeout time.Duration
+
+	cmd    *exec.Cmd
+	client *rpc.Client
+
+	notifications chan Notification
+	done          chan struct{}
+	delivered     chan struct{}
+	stderrDone    chan struct{}
+}
+
+// Start starts the plugin executable at path and asks it what it implements.
+// The plugin is stopped when ctx is canceled or the Client is closed.
+func Start(ctx context.Context, log *zap.Logger, path string, timeout time.Duration) (_ *Client, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	cmd := exec.CommandContext(ctx, path)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	c := &Client{
+		log:           log.With(zap.String("plugin", path)),
+		timeout:       timeout,
+		cmd:           cmd,
+		client:        rpc.NewClientWithCodec(jsonrpc.NewClientCodec(stdio{Reader: stdout, Writer: stdin})),
+		notifications: make(chan Notification, notificationQueue),
+		done:          make(chan struct{}),
+		delivered:     make(chan struct{}),
+		stderrDone:    make(chan struct{}),
+	}
+	go c.logStderr(stderr)
+	go c.deliver()
+
+	if err := c.call(ctx, "Plugin.Info", Empty{}, &c.info); err != nil {
+		return nil, errs.Combine(Error.New("%s did not describe itself: %v", path, err), c.Close())
+	}
+	if c.info.ProtocolVersion != ProtocolVersion {
+		return nil, errs.Combine(Error.New("%s speaks protocol version %d instead of %d",
+			path, c.info.ProtocolVersion, ProtocolVersion), c.Close())
+	}
+	c.log = log.With(zap.String("plugin", c.info.Name))
+
+	return c, nil
+}
+
+// logStderr logs every line that the plugin writes to stderr.
+func (c *Client) logStderr(stderr io.Reader) {
+	defer close(c.stderrDone)
+
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		c.log.Info(scanner.Text())
+	}
+}
+
+// Info describes the plugin.
+func (c *Client) Info() Info { return c.info }
+
+// Implements returns whether the plugin implements the kind of extension.
+func (c *Client) Implements(kind string) bool {
+	for _, implemented := range c.info.Kinds {
+		if implemented == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// call calls the method of the plugin and waits for at most the timeout.
+func (c *Client) call(ctx context.Context, method string, args, reply interface{}) error {
+	if c.timeout > 0 {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	call := c.client.Go(method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		return Error.Wrap(callError(call.Error))
+	case <-ctx.Done():
+		return Error.Wrap(Unavailable.Wrap(ctx.Err()))
+	}
+}
+
+// callError returns the error of a call. Errors that the plugin answered with
+// are unavailable if they say so, and all other errors, like those of the
+// connection to the plugin, are.
+func callError(err error) error {
+	var answered rpc.ServerError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &answered):
+		if message := string(answered); strings.HasPrefix(message, "unavailable: ") {
+			return Unavailable.New("%s", strings.TrimPrefix(message, "unavailable: "))
+		}
+		return err
+	default:
+		return Unavailable.Wrap(err)
+	}
+}
+
+// ResolveAccess asks the plugin for the access grant of an access key.
+func (c *Client) ResolveAccess(ctx context.Context, request ResolveRequest) (response ResolveResponse, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	err = c.call(ctx, "Plugin.ResolveAccess", request, &response)
+	return response, err
+}
+
+// InterceptUpload asks the plugin whether an upload may start.
+func (c *Client) InterceptUpload(ctx context.Context, request UploadRequest) (response UploadResponse, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	err = c.call(ctx, "Plugin.InterceptUpload", request, &response)
+	return response, err
+}
+
+// Notify queues the notification for the plugin, so that slow plugins don't
+// slow down the caller. The notification is dropped when the queue is full.
+func (c *Client) Notify(notification Notification) {
+	select {
+	case c.notifications <- notification:
+	default:
+		mon.Event("plugin_notification_dropped")
+	}
+}
+
+// deliver delivers queued notifications until the Client is closed, and then
+// the notifications that are still queued.
+func (c *Client) deliver() {
+	defer close(c.delivered)
+
+	for {
+		select {
+		case notification := <-c.notifications:
+			c.notify(notification)
+		case <-c.done:
+			for {
+				select {
+				case notification := <-c.notifications:
+					c.notify(notification)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// notify delivers a notification to the plugin.
+func (c *Client) notify(notification Notification) {
+	if err := c.call(context.Background(), "Plugin.Notify", notification, &Empty{}); err != nil {
+		c.log.Warn("unable to deliver notification", zap.Error(err))
+	}
+}
+
+// Close stops the plugin.
+func (c *Client) Close() error {
+	close(c.done)
+	<-c.delivered
+	err := c.client.Close()
+
+	// plugins exit when stdin is closed, and are killed if they don't
+	grace := c.timeout
+	if grace <= 0 {
+		grace = 5 * time.Second
+	}
+	timer := time.AfterFunc(grace, func() { _ = c.cmd.Process.Kill() })
+	defer timer.Stop()
+
+	<-c.stderrDone
+	_ = c.cmd.Wait()
+	return Error.Wrap(err)
+}
+
+// Plugins are the running plugins of the gateway.
+type Plugins struct {
+	clients []*Client
+}
+
+// Load starts the plugins of config.
+func Load(ctx context.Context, log *zap.Logger, config Config) (_ *Plugins, err error) {
+	plugins := &Plugins{}
+	for _, path := range strings.Split(config.Paths, ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		client, err := Start(ctx, log, path, config.Timeout)
+		if err != nil {
+			return nil, errs.Combine(err, plugins.Close())
+		}
+		log.Info("started plugin", zap.String("name", client.Info().Name), zap.Strings("kinds", client.Info().Kinds))
+		plugins.clients = append(plugins.clients, client)
+	}
+	return plugins, nil
+}
+
+// Implements returns whether any plugin implements the kind of extension.
+func (plugins *Plugins) Implements(kind string) bool {
+	for _, client := range plugins.clients {
+		if client.Implements(kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveAccess returns the access grant of the access key from the first
+// auth resolver that resolves it, or the access key itself if none does.
+func (plugins *Plugins) ResolveAccess(ctx context.Context, request ResolveRequest) (accessGrant string, err error) {
+	for _, client := range plugins.clients {
+		if !client.Implements(KindAuthResolver) {
+			continue
+		}
+		response, err := client.ResolveAccess(ctx, request)
+		if err != nil {
+			return "", err
+		}
+		if response.Resolved {
+			return response.AccessGrant, nil
+		}
+	}
+	return request.AccessKey, nil
+}
+
+// InterceptUpload asks every upload interceptor in order whether an upload may
+// start, and returns the metadata with the changes of all of them. The first
+// interceptor that denies the upload decides.
+func (plugins *Plugins) InterceptUpload(ctx context.Context, request UploadRequest) (response UploadResponse, err error) {
+	metadata := make(map[string]string, len(request.Metadata))
+	for k, v := range request.Metadata {
+		metadata[k] = v
+	}
+
+	for _, client := range plugins.clients {
+		if !client.Implements(KindUploadInterceptor) {
+			continue
+		}
+		request.Metadata = metadata
+		intercepted, err := client.InterceptUpload(ctx, request)
+		if err != nil {
+			return UploadResponse{}, err
+		}
+		if intercepted.Deny {
+			return intercepted, nil
+		}
+		for k, v := range intercepted.Metadata {
+			if v == "" {
+				delete(metadata, k)
+			} else {
+				metadata[k] = v
+			}
+		}
+	}
+	return UploadResponse{Metadata: metadata}, nil
+}
+
+// Notify queues the notification for every notifier.
+func (plugins *Plugins) Notify(notification Notification) {
+	for _, client := range plugins.clients {
+		if client.Implements(KindNotifier) {
+			client.Notify(notification)
+		}
+	}
+}
+
+// Close stops every plugin.
+func (plugins *Plugins) Close() error {
+	var group errs.Group
+	for _, client := range plugins.clients {
+		group.Add(client.Close())
+	}
+	return group.Err()
+}
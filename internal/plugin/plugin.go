@@ -0,0 +1,209 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package plugin extends the gateway with plugins that run as subprocesses,
+// so that third parties can add behavior without patching the binary.
+//
+// A plugin is an executable that the gateway starts and talks JSON-RPC 1.0 to
+// over the stdin and stdout of the plugin, so that plugins can be written in
+// any language. Whatever the plugin writes to stderr is logged. Plugins written
+// in Go call Serve. Every plugin answers
+//
+//	Plugin.Info(Empty) Info
+//
+// with the kinds of extensions it implements, and then the methods of those:
+//
+//	Plugin.ResolveAccess(ResolveRequest) ResolveResponse  // KindAuthResolver
+//	Plugin.Notify(Notification) Empty                     // KindNotifier
+//	Plugin.InterceptUpload(UploadRequest) UploadResponse  // KindUploadInterceptor
+//
+// Auth resolvers that can't resolve access keys for now, like when the system
+// they look them up in is unreachable or fails with a server error, return
+// errors that start with "unavailable: ", like those of the Unavailable class.
+// Any other error denies the access key.
+//
+// A plugin that exits is not restarted, and calls to it fail until the
+// gateway is restarted.
+package plugin
+
+import (
+	"io"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"time"
+
+	"github.com/zeebo/errs"
+)
+
+// Error is the error class for this package.
+var Error = errs.Class("plugin")
+
+// Unavailable is the error class of plugins that can't answer for now, as
+// opposed to answering with an error. Calls to plugins that fail or time out
+// are unavailable too.
+var Unavailable = errs.Class("unavailable")
+
+// ProtocolVersion is the version of the protocol that plugins have to speak.
+const ProtocolVersion = 1
+
+// The kinds of extensions that plugins implement.
+const (
+	KindAuthResolver      = "auth-resolver"
+	KindNotifier          = "notifier"
+	KindUploadInterceptor = "upload-interceptor"
+)
+
+// Empty is the argument and result of methods that have none.
+type Empty struct{}
+
+// Info describes a plugin.
+type Info struct {
+	Name            string   `json:"name"`
+	ProtocolVersion int      `json:"protocol_version"`
+	Kinds           []string `json:"kinds"`
+}
+
+// ResolveRequest asks an auth resolver for the access grant of an access key.
+type ResolveRequest struct {
+	AccessKey string `json:"access_key"`
+	Bucket    string `json:"bucket,omitempty"`
+	Key       string `json:"key,omitempty"`
+}
+
+// ResolveResponse is the access grant that an access key resolves to. When
+// Resolved is false, the access key is used as it is.
+type ResolveResponse struct {
+	Resolved    bool   `json:"resolved"`
+	AccessGrant string `json:"access_grant,omitempty"`
+}
+
+// Notification is an event that notifiers are told about, like an alert of
+// anomaly detection.
+type Notification struct {
+	Source string            `json:"source"`
+	Kind   string            `json:"kind"`
+	Detail string            `json:"detail"`
+	Time   time.Time         `json:"time"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// UploadRequest asks an upload interceptor whether an upload may start.
+type UploadRequest struct {
+	Bucket      string            `json:"bucket"`
+	Key         string            `json:"key"`
+	Size        int64             `json:"size"` // -1 when it is not known
+	ContentType string            `json:"content_type,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// UploadResponse denies an upload or changes its metadata. Metadata with an
+// empty value is removed.
+type UploadResponse struct {
+	Deny     bool              `json:"deny"`
+	Reason   string            `json:"reason,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// AuthResolver resolves access keys to access grants, like by looking them up
+// in another system.
+type AuthResolver interface {
+	ResolveAccess(request ResolveRequest) (ResolveResponse, error)
+}
+
+// Notifier delivers notifications, like to a chat or a pager.
+type Notifier interface {
+	Notify(notification Notification) error
+}
+
+// UploadInterceptor checks uploads before they start.
+type UploadInterceptor interface {
+	InterceptUpload(request UploadRequest) (UploadResponse, error)
+}
+
+// Serve serves impl as a plugin with the name over stdin and stdout until
+// stdin is closed. impl implements any of AuthResolver, Notifier and
+// UploadInterceptor.
+func Serve(name string, impl interface{}) error {
+	return ServeConn(name, impl, stdio{Reader: os.Stdin, Writer: os.Stdout})
+}
+
+// ServeConn is like Serve, but serves over conn.
+func ServeConn(name string, impl interface{}, conn io.ReadWriteCloser) error {
+	info := Info{Name: name, ProtocolVersion: ProtocolVersion}
+	if _, ok := impl.(AuthResolver); ok {
+		info.Kinds = append(info.Kinds, KindAuthResolver)
+	}
+	if _, ok := impl.(Notifier); ok {
+		info.Kinds = append(info.Kinds, KindNotifier)
+	}
+	if _, ok := impl.(UploadInterceptor); ok {
+		info.Kinds = append(info.Kinds, KindUploadInterceptor)
+	}
+
+	server := rpc.NewServer()
+	if err := server.RegisterName("Plugin", &Service{info: info, impl: impl}); err != nil {
+		return Error.Wrap(err)
+	}
+	server.ServeCodec(jsonrpc.NewServerCodec(conn))
+	return nil
+}
+
+// Service is the rpc service of a plugin. It is only exported for net/rpc.
+type Service struct {
+	info Info
+	impl interface{}
+}
+
+// Info describes the plugin.
+func (service *Service) Info(_ Empty, info *Info) error {
+	*info = service.info
+	return nil
+}
+
+// ResolveAccess resolves an access key with the auth resolver.
+func (service *Service) ResolveAccess(request ResolveRequest, response *ResolveResponse) (err error) {
+	resolver, ok := service.impl.(AuthResolver)
+	if !ok {
+		return Error.New("%s is not an auth resolver", service.info.Name)
+	}
+	*response, err = resolver.ResolveAccess(request)
+	return err
+}
+
+// Notify delivers a notification with the notifier.
+func (service *Service) Notify(notification Notification, _ *Empty) error {
+	notifier, ok := service.impl.(Notifier)
+	if !ok {
+		return Error.New("%s is not a notifier", service.info.Name)
+	}
+	return notifier.Notify(notification)
+}
+
+// InterceptUpload checks an upload with the upload interceptor.
+func (service *Service) InterceptUpload(request UploadRequest, response *UploadResponse) (err error) {
+	interceptor, ok := service.impl.(UploadInterceptor)
+	if !ok {
+		return Error.New("%s is not an upload interceptor", service.info.Name)
+	}
+	*response, err = interceptor.InterceptUpload(request)
+	return err
+}
+
+// stdio is a connection over a pair of pipes.
+type stdio struct {
+	io.Reader
+	io.Writer
+}
+
+// Close closes both pipes.
+func (conn stdio) Close() error {
+	var group errs.Group
+	if closer, ok := conn.Reader.(io.Closer); ok {
+		group.Add(closer.Close())
+	}
+	if closer, ok := conn.Writer.(io.Closer); ok {
+		group.Add(closer.Close())
+	}
+	return group.Err()
+}
@@ -0,0 +1,120 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package plugin_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"storj.io/stargate/internal/plugin"
+)
+
+// pluginEnv makes the test binary run as the test plugin.
+const pluginEnv = "STARGATE_TEST_PLUGIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(pluginEnv) != "" {
+		if err := plugin.Serve("test", testPlugin{}); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+type testPlugin struct{}
+
+func (testPlugin) ResolveAccess(request plugin.ResolveRequest) (plugin.ResolveResponse, error) {
+	switch request.AccessKey {
+	case "alias":
+		return plugin.ResolveResponse{Resolved: true, AccessGrant: "grant-for-" + request.Bucket}, nil
+	case "revoked":
+		return plugin.ResolveResponse{}, errors.New("access key revoked")
+	case "outage":
+		return plugin.ResolveResponse{}, plugin.Unavailable.New("auth service unreachable")
+	}
+	return plugin.ResolveResponse{}, nil
+}
+
+func (testPlugin) InterceptUpload(request plugin.UploadRequest) (plugin.UploadResponse, error) {
+	if strings.HasPrefix(request.Key, "forbidden/") {
+		return plugin.UploadResponse{Deny: true, Reason: "forbidden prefix"}, nil
+	}
+	return plugin.UploadResponse{Metadata: map[string]string{"scanned": "true", "remove-me": ""}}, nil
+}
+
+func (testPlugin) Notify(notification plugin.Notification) error {
+	fmt.Fprintln(os.Stderr, "notified", notification.Kind)
+	return nil
+}
+
+func TestPlugins(t *testing.T) {
+	ctx := context.Background()
+
+	require.NoError(t, os.Setenv(pluginEnv, "1"))
+	defer func() { _ = os.Unsetenv(pluginEnv) }()
+
+	core, logs := observer.New(zap.InfoLevel)
+	plugins, err := plugin.Load(ctx, zap.New(core), plugin.Config{Paths: os.Args[0], Timeout: 10 * time.Second})
+	require.NoError(t, err)
+
+	require.True(t, plugins.Implements(plugin.KindAuthResolver))
+	require.True(t, plugins.Implements(plugin.KindNotifier))
+	require.True(t, plugins.Implements(plugin.KindUploadInterceptor))
+
+	accessGrant, err := plugins.ResolveAccess(ctx, plugin.ResolveRequest{AccessKey: "alias", Bucket: "photos"})
+	require.NoError(t, err)
+	require.Equal(t, "grant-for-photos", accessGrant)
+
+	accessGrant, err = plugins.ResolveAccess(ctx, plugin.ResolveRequest{AccessKey: "other"})
+	require.NoError(t, err)
+	require.Equal(t, "other", accessGrant)
+
+	// resolvers tell denied access keys apart from being unavailable
+	_, err = plugins.ResolveAccess(ctx, plugin.ResolveRequest{AccessKey: "revoked"})
+	require.Error(t, err)
+	require.False(t, plugin.Unavailable.Has(err))
+	_, err = plugins.ResolveAccess(ctx, plugin.ResolveRequest{AccessKey: "outage"})
+	require.True(t, plugin.Unavailable.Has(err), err)
+
+	response, err := plugins.InterceptUpload(ctx, plugin.UploadRequest{
+		Bucket:   "photos",
+		Key:      "cat.jpg",
+		Size:     -1,
+		Metadata: map[string]string{"remove-me": "value", "kept": "value"},
+	})
+	require.NoError(t, err)
+	require.False(t, response.Deny)
+	require.Equal(t, map[string]string{"scanned": "true", "kept": "value"}, response.Metadata)
+
+	response, err = plugins.InterceptUpload(ctx, plugin.UploadRequest{Bucket: "photos", Key: "forbidden/cat.jpg"})
+	require.NoError(t, err)
+	require.True(t, response.Deny)
+	require.Equal(t, "forbidden prefix", response.Reason)
+
+	plugins.Notify(plugin.Notification{Source: "test", Kind: "alert", Time: time.Now()})
+	require.NoError(t, plugins.Close())
+	require.NotZero(t, logs.FilterMessage("notified alert").Len(), "the stderr of the plugin is logged")
+
+	// calls to plugins that are gone are unavailable
+	_, err = plugins.ResolveAccess(ctx, plugin.ResolveRequest{AccessKey: "alias"})
+	require.True(t, plugin.Unavailable.Has(err), err)
+}
+
+func TestStart_NotAPlugin(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := plugin.Start(ctx, zap.NewNop(), "/nonexistent/plugin", time.Second)
+	require.Error(t, err)
+}
@@ -0,0 +1,127 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package ratelimit limits how fast clients, like auth tokens or client ips,
+// can make requests.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+)
+
+var mon = monkit.Package()
+
+// Config configures a Limiter.
+type Config struct {
+	Rate       float64 `help:"requests per second allowed for every client; 0 disables the limit" default:"0"`
+	Burst      int     `help:"requests that a client may make at once before it is limited to the rate" default:"100"`
+	MaxEntries int     `help:"maximum number of clients that are tracked at once" default:"100000"`
+}
+
+// Limiter limits the requests of every key, like a client ip or an auth
+// token, with a token bucket: a key may make Burst requests at once, and Rate
+// requests per second after that.
+type Limiter struct {
+	config Config
+	now    func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// New constructs a Limiter. It returns nil if the config disables it, and a nil
+// Limiter allows everything.
+func New(config Config) *Limiter {
+	if config.Rate <= 0 {
+		return nil
+	}
+	if config.Burst < 1 {
+		config.Burst = 1
+	}
+	return &Limiter{
+		config:  config,
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow returns whether every key may make a request now, and counts the
+// request for all of them if so. If a key may not, it returns how long until
+// it may.
+func (l *Limiter) Allow(keys ...string) (retryAfter time.Duration, ok bool) {
+	if l == nil {
+		return 0, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	allowed := make([]*bucket, 0, len(keys))
+	for _, key := range keys {
+		b := l.bucket(key, now)
+		if b == nil {
+			continue
+		}
+		if b.tokens < 1 {
+			wait := time.Duration((1 - b.tokens) / l.config.Rate * float64(time.Second))
+			if wait > retryAfter {
+				retryAfter = wait
+			}
+			continue
+		}
+		allowed = append(allowed, b)
+	}
+	if retryAfter > 0 {
+		mon.Counter("ratelimit_rejected").Inc(1)
+		return retryAfter, false
+	}
+
+	for _, b := range allowed {
+		b.tokens--
+	}
+	return 0, true
+}
+
+// bucket returns the bucket of key, refilled until now, or nil if there are
+// too many to track it. It must be called with the lock held.
+func (l *Limiter) bucket(key string, now time.Time) *bucket {
+	b, found := l.buckets[key]
+	if !found {
+		if len(l.buckets) >= l.config.MaxEntries {
+			l.prune(now)
+			if len(l.buckets) >= l.config.MaxEntries {
+				mon.Counter("ratelimit_untracked").Inc(1)
+				return nil
+			}
+		}
+		b = &bucket{tokens: float64(l.config.Burst), updated: now}
+		l.buckets[key] = b
+		return b
+	}
+
+	b.tokens += now.Sub(b.updated).Seconds() * l.config.Rate
+	if burst := float64(l.config.Burst); b.tokens > burst {
+		b.tokens = burst
+	}
+	b.updated = now
+	return b
+}
+
+// prune removes the buckets that are full again, since they are the same as
+// new ones.
+func (l *Limiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*l.config.Rate >= float64(l.config.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Now()
+	limiter := New(Config{Rate: 2, Burst: 3, MaxEntries: 10})
+	limiter.now = func() time.Time { return now }
+
+	// a burst is allowed
+	for i := 0; i < 3; i++ {
+		_, ok := limiter.Allow("ip", "token")
+		require.True(t, ok)
+	}
+
+	// and then the rate
+	retryAfter, ok := limiter.Allow("ip")
+	require.False(t, ok)
+	require.Equal(t, 500*time.Millisecond, retryAfter)
+
+	now = now.Add(500 * time.Millisecond)
+	_, ok = limiter.Allow("ip")
+	require.True(t, ok)
+
+	// a request that is limited by one key isn't counted for the others
+	now = now.Add(500 * time.Millisecond)
+	_, ok = limiter.Allow("ip", "other")
+	require.True(t, ok)
+	_, ok = limiter.Allow("token", "ip")
+	require.False(t, ok)
+	_, ok = limiter.Allow("token")
+	require.True(t, ok)
+
+	// buckets refill up to the burst
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		_, ok := limiter.Allow("ip")
+		require.True(t, ok)
+	}
+	_, ok = limiter.Allow("ip")
+	require.False(t, ok)
+}
+
+func TestLimiter_MaxEntries(t *testing.T) {
+	now := time.Now()
+	limiter := New(Config{Rate: 1, Burst: 1, MaxEntries: 2})
+	limiter.now = func() time.Time { return now }
+
+	_, ok := limiter.Allow("a", "b")
+	require.True(t, ok)
+
+	// untracked keys are allowed
+	for i := 0; i < 2; i++ {
+		_, ok = limiter.Allow("c")
+		require.True(t, ok)
+	}
+
+	// full buckets are pruned to make room
+	now = now.Add(time.Second)
+	_, ok = limiter.Allow("c")
+	require.True(t, ok)
+	_, ok = limiter.Allow("c")
+	require.False(t, ok)
+}
+
+func TestLimiter_Disabled(t *testing.T) {
+	limiter := New(Config{})
+	require.Nil(t, limiter)
+	_, ok := limiter.Allow("ip")
+	require.True(t, ok)
+}
@@ -0,0 +1,69 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package reconcile
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/zeebo/errs"
+)
+
+// Console reads project usage from the satellite web console api.
+type Console struct {
+	address   string
+	token     string
+	projectID string
+	client    *http.Client
+}
+
+// NewConsole constructs a Console for the project with the given id, which
+// authenticates with the auth token of a project member.
+func NewConsole(address, token, projectID string) *Console {
+	return &Console{
+		address:   strings.TrimSuffix(address, "/"),
+		token:     token,
+		projectID: projectID,
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// StorageUsed returns the storage in bytes that the satellite accounts for.
+// The satellite tallies storage periodically, so it lags behind uploads and
+// deletes.
+func (console *Console) StorageUsed(ctx context.Context) (_ int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	endpoint := console.address + "/api/v0/projects/" + url.PathEscape(console.projectID) + "/usage-limits"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return 0, Error.Wrap(err)
+	}
+	req.AddCookie(&http.Cookie{Name: "_tokenKey", Value: console.token})
+
+	resp, err := console.client.Do(req)
+	if err != nil {
+		return 0, Error.Wrap(err)
+	}
+	defer func() { err = errs.Combine(err, Error.Wrap(resp.Body.Close())) }()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return 0, Error.New("console responded with %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var limits struct {
+		StorageUsed int64 `json:"storageUsed"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&limits); err != nil {
+		return 0, Error.Wrap(err)
+	}
+	return limits.StorageUsed, nil
+}
@@ -0,0 +1,129 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package reconcile periodically compares the storage that the gateway
+// accounts for with the storage that the satellite bills for, and reports
+// discrepancies as metrics.
+package reconcile
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+)
+
+var mon = monkit.Package()
+
+// Error is the error class for this package.
+var Error = errs.Class("reconcile")
+
+// Config configures the Reconciler.
+type Config struct {
+	Interval  time.Duration `help:"how often to reconcile storage usage with the satellite; 0 disables reconciliation" default:"0s"`
+	Tolerance float64       `help:"relative difference between gateway and satellite storage usage that is reported as a discrepancy" default:"0.05"`
+
+	AccessGrant    string `help:"access grant whose project usage is reconciled; it has to be able to list every bucket" default:""`
+	ProjectID      string `help:"id of the project of the access grant on the satellite" default:""`
+	ConsoleAddress string `help:"url of the satellite web console, like https://us1.storj.io" default:""`
+	ConsoleToken   string `help:"auth token of a member of the project for the satellite web console" default:""`
+}
+
+// UsageFunc returns storage usage in bytes.
+type UsageFunc func(ctx context.Context) (bytes int64, err error)
+
+// Result is the outcome of a single reconciliation.
+type Result struct {
+	GatewayBytes   int64
+	SatelliteBytes int64
+}
+
+// Difference returns how many more bytes the satellite accounts for than the
+// gateway. It is negative if the gateway accounts for more.
+func (result Result) Difference() int64 {
+	return result.SatelliteBytes - result.GatewayBytes
+}
+
+// Relative returns the difference relative to the satellite usage.
+func (result Result) Relative() float64 {
+	if result.SatelliteBytes == 0 {
+		if result.GatewayBytes == 0 {
+			return 0
+		}
+		return math.Inf(1)
+	}
+	return math.Abs(float64(result.Difference())) / float64(result.SatelliteBytes)
+}
+
+// Reconciler compares gateway and satellite storage usage.
+type Reconciler struct {
+	log       *zap.Logger
+	config    Config
+	gateway   UsageFunc
+	satellite UsageFunc
+}
+
+// NewReconciler constructs a Reconciler that compares the usage returned by
+// gateway and satellite.
+func NewReconciler(log *zap.Logger, config Config, gateway, satellite UsageFunc) *Reconciler {
+	return &Reconciler{
+		log:       log,
+		config:    config,
+		gateway:   gateway,
+		satellite: satellite,
+	}
+}
+
+// Run reconciles every interval until the context is canceled. Failed
+// reconciliations are logged and retried at the next interval.
+func (r *Reconciler) Run(ctx context.Context) error {
+	if r.config.Interval <= 0 {
+		return nil
+	}
+
+	ticker := time.NewTicker(r.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.Reconcile(ctx); err != nil {
+			r.log.Error("unable to reconcile storage usage", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reconcile compares the storage usage once and reports the result as metrics.
+// A discrepancy beyond the tolerance is logged as well.
+func (r *Reconciler) Reconcile(ctx context.Context) (result Result, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	result.GatewayBytes, err = r.gateway(ctx)
+	if err != nil {
+		return Result{}, Error.New("gateway usage: %v", err)
+	}
+	result.SatelliteBytes, err = r.satellite(ctx)
+	if err != nil {
+		return Result{}, Error.New("satellite usage: %v", err)
+	}
+
+	mon.IntVal("reconcile_gateway_bytes").Observe(result.GatewayBytes)
+	mon.IntVal("reconcile_satellite_bytes").Observe(result.SatelliteBytes)
+	mon.IntVal("reconcile_difference_bytes").Observe(result.Difference())
+
+	if result.Relative() > r.config.Tolerance {
+		mon.Counter("reconcile_discrepancies").Inc(1)
+		r.log.Warn("storage usage differs from the satellite",
+			zap.Int64("gateway bytes", result.GatewayBytes),
+			zap.Int64("satellite bytes", result.SatelliteBytes),
+			zap.Int64("difference", result.Difference()))
+	}
+	return result, nil
+}
@@ -0,0 +1,81 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package reconcile_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	"go.uber.org/zap/zaptest/observer"
+
+	"storj.io/stargate/internal/reconcile"
+)
+
+func fixed(bytes int64) reconcile.UsageFunc {
+	return func(ctx context.Context) (int64, error) { return bytes, nil }
+}
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	core, logs := observer.New(zap.WarnLevel)
+	config := reconcile.Config{Tolerance: 0.1}
+
+	result, err := reconcile.NewReconciler(zap.New(core), config, fixed(950), fixed(1000)).Reconcile(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, 50, result.Difference())
+	require.Equal(t, 0, logs.Len())
+
+	result, err = reconcile.NewReconciler(zap.New(core), config, fixed(1200), fixed(1000)).Reconcile(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, -200, result.Difference())
+	require.Equal(t, 1, logs.Len())
+
+	// usage on only one side is always a discrepancy
+	_, err = reconcile.NewReconciler(zap.New(core), config, fixed(1), fixed(0)).Reconcile(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, logs.Len())
+
+	failing := func(ctx context.Context) (int64, error) { return 0, errors.New("boom") }
+	_, err = reconcile.NewReconciler(zap.New(core), config, fixed(1), failing).Reconcile(ctx)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "satellite usage")
+}
+
+func TestConsole(t *testing.T) {
+	ctx := context.Background()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("_tokenKey")
+		if err != nil || cookie.Value != "token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/api/v0/projects/project-id/usage-limits" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"storageLimit":1000000,"bandwidthLimit":1000000,"storageUsed":12345,"bandwidthUsed":1}`))
+	}))
+	defer server.Close()
+
+	used, err := reconcile.NewConsole(server.URL+"/", "token", "project-id").StorageUsed(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, 12345, used)
+
+	_, err = reconcile.NewConsole(server.URL, "wrong", "project-id").StorageUsed(ctx)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "401")
+
+	reconciler := reconcile.NewReconciler(zaptest.NewLogger(t), reconcile.Config{Tolerance: 0.05},
+		fixed(12345), reconcile.NewConsole(server.URL, "token", "project-id").StorageUsed)
+	result, err := reconciler.Reconcile(ctx)
+	require.NoError(t, err)
+	require.Zero(t, result.Difference())
+}
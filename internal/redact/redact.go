@@ -0,0 +1,167 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package redact scrubs credentials, like access grants, secret keys, auth
+// tokens and presigned url signatures, from log output.
+package redact
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"github.com/btcsuite/btcutil/base58"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"storj.io/common/macaroon"
+	"storj.io/uplink"
+)
+
+// Replacement is what redacted credentials are replaced with.
+const Replacement = "[REDACTED]"
+
+var patterns = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	// presigned url query parameters and signed header values
+	{regexp.MustCompile(`(?i)((?:X-Amz-Signature|X-Amz-Credential|X-Amz-Security-Token|AWSAccessKeyId|Signature)=)[^&\s"',]+`), "${1}" + Replacement},
+	// authorization headers of any scheme
+	{regexp.MustCompile(`(?i)(Authorization["']?\s*[:=]\s*["']?(?:Bearer|Basic|AWS4-HMAC-SHA256|AWS)?\s*)[^"'\n]+`), "${1}" + Replacement},
+	{regexp.MustCompile(`(?i)(Bearer\s+)[^\s"',]+`), "${1}" + Replacement},
+	// json or key=value pairs with a sensitive name
+	{regexp.MustCompile(`(?i)((?:access_grant|access_key_id|secret_key|auth_token|secret|token|password)["']?\s*[:=]\s*["']?)[^\s"',&}]+`), "${1}" + Replacement},
+}
+
+// base58Word matches runs of the base58 alphabet that are at least as long
+// as an access key id. They are only redacted if they decode as a credential,
+// so that node ids, hashes and bucket names stay readable.
+var base58Word = regexp.MustCompile(`\b[1-9A-HJ-NP-Za-km-z]{50,}\b`)
+
+// the base58check versions of the access key ids and secret keys of the auth
+// service.
+const (
+	versionAccessKeyID = 1
+	versionSecretKey   = 2
+)
+
+// String returns s with all credentials replaced by Replacement.
+func String(s string) string {
+	for _, pattern := range patterns {
+		s = pattern.re.ReplaceAllString(s, pattern.repl)
+	}
+	return base58Word.ReplaceAllStringFunc(s, func(word string) string {
+		if isCredential(word) {
+			return Replacement
+		}
+		return word
+	})
+}
+
+// isCredential returns whether word is an access grant, an access key id, a
+// secret key or an api key.
+func isCredential(word string) bool {
+	if _, err := uplink.ParseAccess(word); err == nil {
+		return true
+	}
+	data, version, err := base58.CheckDecode(word)
+	if err == nil && len(data) == 32 && (version == versionAccessKeyID || version == versionSecretKey) {
+		return true
+	}
+	_, err = macaroon.ParseAPIKey(word)
+	return err == nil
+}
+
+// core is a zapcore.Core that redacts the message and fields of every entry
+// before passing it on to the wrapped core.
+type core struct {
+	zapcore.Core
+}
+
+// NewCore wraps c so that credentials are redacted from everything logged
+// through it, including wrapped errors and fields added with With.
+//
+// Object and array marshalers are passed through as is.
+func NewCore(c zapcore.Core) zapcore.Core {
+	return &core{Core: c}
+}
+
+// Logger returns log with redaction added to its core.
+func Logger(log *zap.Logger) *zap.Logger {
+	return log.WithOptions(zap.WrapCore(NewCore))
+}
+
+// ReplaceGlobals redacts everything logged with the global zap loggers.
+// It returns a function to restore the previous global loggers.
+func ReplaceGlobals() func() {
+	return zap.ReplaceGlobals(Logger(zap.L()))
+}
+
+// With adds redacted fields to the wrapped core.
+func (c *core) With(fields []zapcore.Field) zapcore.Core {
+	return &core{Core: c.Core.With(Fields(fields))}
+}
+
+// Check adds c to ce if the entry is enabled.
+func (c *core) Check(entry zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return ce.AddCore(entry, c)
+	}
+	return ce
+}
+
+// Write redacts the entry and fields and writes them to the wrapped core.
+func (c *core) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	entry.Message = String(entry.Message)
+	entry.Stack = String(entry.Stack)
+	return c.Core.Write(entry, Fields(fields))
+}
+
+// Fields returns a copy of fields with credentials redacted from their values.
+// Fields that contain nothing to redact are left as they are.
+func Fields(fields []zapcore.Field) []zapcore.Field {
+	redacted := make([]zapcore.Field, len(fields))
+	for i, field := range fields {
+		redacted[i] = Field(field)
+	}
+	return redacted
+}
+
+// Field returns field with credentials redacted from its value. Values that
+// are not strings already are formatted as strings when they need redaction.
+func Field(field zapcore.Field) zapcore.Field {
+	var value string
+	switch field.Type {
+	case zapcore.StringType:
+		value = field.String
+	case zapcore.ByteStringType:
+		value = string(field.Interface.([]byte))
+	case zapcore.ErrorType:
+		err, _ := field.Interface.(error)
+		if err == nil {
+			return field
+		}
+		value = err.Error()
+	case zapcore.StringerType:
+		stringer, _ := field.Interface.(fmt.Stringer)
+		if stringer == nil {
+			return field
+		}
+		value = stringer.String()
+	case zapcore.ReflectType:
+		data, err := json.Marshal(field.Interface)
+		if err != nil {
+			value = fmt.Sprintf("%+v", field.Interface)
+		} else {
+			value = string(data)
+		}
+	default:
+		return field
+	}
+
+	if redacted := String(value); redacted != value {
+		return zap.String(field.Key, redacted)
+	}
+	return field
+}
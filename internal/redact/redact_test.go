@@ -0,0 +1,76 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package redact_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"storj.io/stargate/internal/redact"
+)
+
+const (
+	accessGrant = "138CV9Drxrw8ir1XpxcZhk2wnHjhzVjuSZe6yDsNiMZDP8cow9V6sHDYdwgvYoQGgqVvoMnxdWDbpBiEPW5oP7DtPJ5sZx2MVxFrUoZYFfVAgxidW"
+	apiKey      = "13Yqe3oHi5dcnGhMu2ru3cmePC9iEYv6nDrYMbLRh4wre1KtVA9SFwLNAuuvWwc43b9swRsrfsnrbuTHQ6TJKVt4LjGnaARN9PhxJEu"
+	accessKeyID = "2zqgdLU9hpajqS5W1CWkRLoqCoUnLSt3YGNCaig6LGBbv2usqs"
+	authSecret  = "4xU9YU464pmUWyKS8hWvusJiv3R3N2uC1Ph198WQBV5qiTAGKP"
+	secretKey   = "jxs3kzbkqo4cjjzifp6etp3hiynbtjrb5kmwthwqncgvngq2smb4u"
+	nodeID      = "12Jc6VooH5wxteNEnVgqR8hNw3Pb58bphqk1myKZpXwqsLYAt4M"
+)
+
+func TestString(t *testing.T) {
+	for _, tc := range []struct {
+		in, out string
+	}{
+		{"nothing to see here", "nothing to see here"},
+		{"grant " + accessGrant + " failed", "grant [REDACTED] failed"},
+		{"api key " + apiKey, "api key [REDACTED]"},
+		{"credentials " + accessKeyID + ":" + authSecret, "credentials [REDACTED]:[REDACTED]"},
+		// base58 values that aren't credentials are kept
+		{"dial node " + nodeID, "dial node " + nodeID},
+		{"bucket abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ123456789", "bucket abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ123456789"},
+		{"short 1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2", "short 1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2"},
+		{"Authorization: Bearer hunter2", "Authorization: Bearer [REDACTED]"},
+		{`{"access_grant":"abc","public":true}`, `{"access_grant":"[REDACTED]","public":true}`},
+		{`{"secret_key":"` + secretKey + `"}`, `{"secret_key":"[REDACTED]"}`},
+		{"auth_token=abc", "auth_token=[REDACTED]"},
+		{
+			"GET /bucket/key?X-Amz-Credential=AKIA%2F20200101&X-Amz-Signature=deadbeef&X-Amz-Expires=60",
+			"GET /bucket/key?X-Amz-Credential=[REDACTED]&X-Amz-Signature=[REDACTED]&X-Amz-Expires=60",
+		},
+	} {
+		require.Equal(t, tc.out, redact.String(tc.in), tc.in)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	observed, logs := observer.New(zapcore.DebugLevel)
+	log := redact.Logger(zap.New(observed)).With(zap.String("grant", accessGrant))
+
+	log.Info("storing "+accessGrant,
+		zap.Error(errors.New("wrapped: invalid grant "+accessGrant)),
+		zap.Stringer("stringer", stringer("token="+secretKey)),
+		zap.Int("count", 1),
+		zap.String("bucket", "photos"))
+
+	entries := logs.AllUntimed()
+	require.Len(t, entries, 1)
+	require.Equal(t, "storing [REDACTED]", entries[0].Message)
+	require.Equal(t, map[string]interface{}{
+		"grant":    "[REDACTED]",
+		"error":    "wrapped: invalid grant [REDACTED]",
+		"stringer": "token=[REDACTED]",
+		"count":    int64(1),
+		"bucket":   "photos",
+	}, entries[0].ContextMap())
+}
+
+type stringer string
+
+func (s stringer) String() string { return string(s) }
@@ -0,0 +1,407 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package s3check
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"reflect"
+
+	minio "github.com/minio/minio-go/v6"
+	"github.com/zeebo/errs"
+)
+
+// Checks are the curated conformance checks.
+var Checks = []Check{
+	{Category: "bucket", Name: "create, head and delete a bucket", Run: checkBucketLifecycle},
+	{Category: "bucket", Name: "list buckets", Run: checkListBuckets},
+	{Category: "bucket", Name: "create an existing bucket", Run: checkBucketExists},
+	{Category: "bucket", Name: "delete a bucket that isn't empty", Run: checkBucketNotEmpty},
+
+	{Category: "object", Name: "put and get an object", Run: checkPutGet},
+	{Category: "object", Name: "put and get an empty object", Run: checkEmptyObject},
+	{Category: "object", Name: "overwrite an object", Run: checkOverwrite},
+	{Category: "object", Name: "head an object", Run: checkHeadObject},
+	{Category: "object", Name: "delete an object", Run: checkDeleteObject},
+
+	{Category: "range", Name: "get a range of an object", Run: checkRange},
+	{Category: "range", Name: "get a suffix range of an object", Run: checkSuffixRange},
+
+	{Category: "metadata", Name: "user metadata", Run: checkUserMetadata},
+	{Category: "metadata", Name: "content type", Run: checkContentType},
+
+	{Category: "listing", Name: "list objects with a delimiter", Run: checkListDelimiter},
+	{Category: "listing", Name: "page through objects with markers", Run: checkListPages},
+	{Category: "listing", Name: "page through objects with continuation tokens", Run: checkListPagesV2},
+
+	{Category: "multipart", Name: "multipart upload", Run: checkMultipart},
+
+	{Category: "copy", Name: "copy an object", Run: checkCopy},
+
+	{Category: "errors", Name: "get a missing object", Run: checkMissingObject},
+	{Category: "errors", Name: "get an object of a missing bucket", Run: checkMissingBucket},
+}
+
+// put puts data as the object with key into the shared bucket.
+func (env *Env) put(ctx context.Context, key string, data []byte, opts minio.PutObjectOptions) error {
+	return putObject(ctx, env.Client, env.Bucket, key, data, opts)
+}
+
+// get gets the data of the object with key from the shared bucket.
+func (env *Env) get(ctx context.Context, key string, opts minio.GetObjectOptions) ([]byte, error) {
+	return getObject(ctx, env.Client, env.Bucket, key, opts)
+}
+
+func putObject(ctx context.Context, client *minio.Core, bucket, key string, data []byte, opts minio.PutObjectOptions) error {
+	_, err := client.Client.PutObjectWithContext(ctx, bucket, key, bytes.NewReader(data), int64(len(data)), opts)
+	return err
+}
+
+func getObject(ctx context.Context, client *minio.Core, bucket, key string, opts minio.GetObjectOptions) (_ []byte, err error) {
+	object, _, _, err := client.GetObjectWithContext(ctx, bucket, key, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = errs.Combine(err, object.Close()) }()
+	return ioutil.ReadAll(object)
+}
+
+// expectCode returns an error unless err is an S3 error with one of codes.
+func expectCode(err error, codes ...string) error {
+	if err == nil {
+		return errs.New("expected %v, got success", codes)
+	}
+	code := minio.ToErrorResponse(err).Code
+	for _, expected := range codes {
+		if code == expected {
+			return nil
+		}
+	}
+	return errs.New("expected %v, got %v", codes, err)
+}
+
+// expectData returns an error unless data is expected.
+func expectData(data, expected []byte) error {
+	if !bytes.Equal(data, expected) {
+		return errs.New("expected %q, got %q", expected, data)
+	}
+	return nil
+}
+
+func checkBucketLifecycle(ctx context.Context, env *Env) error {
+	bucket := env.Prefix + "lifecycle"
+	if err := env.Client.MakeBucket(bucket, ""); err != nil {
+		return err
+	}
+	exists, err := env.Client.BucketExists(bucket)
+	if err != nil {
+		return errs.Combine(err, env.Client.RemoveBucket(bucket))
+	}
+	if !exists {
+		return errs.Combine(errs.New("bucket doesn't exist after it was created"), env.Client.RemoveBucket(bucket))
+	}
+	if err := env.Client.RemoveBucket(bucket); err != nil {
+		return err
+	}
+	if exists, err := env.Client.BucketExists(bucket); err != nil || exists {
+		return errs.Combine(errs.New("bucket exists after it was deleted"), err)
+	}
+	return nil
+}
+
+func checkListBuckets(ctx context.Context, env *Env) error {
+	buckets, err := env.Client.ListBuckets()
+	if err != nil {
+		return err
+	}
+	for _, bucket := range buckets {
+		if bucket.Name == env.Bucket {
+			return nil
+		}
+	}
+	return errs.New("bucket %q isn't listed", env.Bucket)
+}
+
+func checkBucketExists(ctx context.Context, env *Env) error {
+	return expectCode(env.Client.MakeBucket(env.Bucket, ""), "BucketAlreadyExists", "BucketAlreadyOwnedByYou")
+}
+
+func checkBucketNotEmpty(ctx context.Context, env *Env) (err error) {
+	bucket := env.Prefix + "notempty"
+	if err := env.Client.MakeBucket(bucket, ""); err != nil {
+		return err
+	}
+	defer func() { err = errs.Combine(err, removeBucket(env.Client, bucket)) }()
+
+	if err := putObject(ctx, env.Client, bucket, "key", []byte("data"), minio.PutObjectOptions{}); err != nil {
+		return err
+	}
+	return expectCode(env.Client.RemoveBucket(bucket), "BucketNotEmpty")
+}
+
+func checkPutGet(ctx context.Context, env *Env) error {
+	data := []byte("put and get")
+	if err := env.put(ctx, "object/put-get", data, minio.PutObjectOptions{}); err != nil {
+		return err
+	}
+	got, err := env.get(ctx, "object/put-get", minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	return expectData(got, data)
+}
+
+func checkEmptyObject(ctx context.Context, env *Env) error {
+	if err := env.put(ctx, "object/empty", nil, minio.PutObjectOptions{}); err != nil {
+		return err
+	}
+	got, err := env.get(ctx, "object/empty", minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	return expectData(got, nil)
+}
+
+func checkOverwrite(ctx context.Context, env *Env) error {
+	if err := env.put(ctx, "object/overwrite", []byte("first"), minio.PutObjectOptions{}); err != nil {
+		return err
+	}
+	if err := env.put(ctx, "object/overwrite", []byte("second"), minio.PutObjectOptions{}); err != nil {
+		return err
+	}
+	got, err := env.get(ctx, "object/overwrite", minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	return expectData(got, []byte("second"))
+}
+
+func checkHeadObject(ctx context.Context, env *Env) error {
+	data := []byte("head")
+	if err := env.put(ctx, "object/head", data, minio.PutObjectOptions{}); err != nil {
+		return err
+	}
+	info, err := env.Client.StatObjectWithContext(ctx, env.Bucket, "object/head", minio.StatObjectOptions{})
+	if err != nil {
+		return err
+	}
+	if info.Size != int64(len(data)) {
+		return errs.New("expected size %d, got %d", len(data), info.Size)
+	}
+	if info.ETag == "" {
+		return errs.New("missing ETag")
+	}
+	return nil
+}
+
+func checkDeleteObject(ctx context.Context, env *Env) error {
+	if err := env.put(ctx, "object/delete", []byte("delete"), minio.PutObjectOptions{}); err != nil {
+		return err
+	}
+	if err := env.Client.RemoveObject(env.Bucket, "object/delete"); err != nil {
+		return err
+	}
+	_, err := env.Client.StatObjectWithContext(ctx, env.Bucket, "object/delete", minio.StatObjectOptions{})
+	return expectCode(err, "NoSuchKey")
+}
+
+func checkRange(ctx context.Context, env *Env) error {
+	if err := env.put(ctx, "range/object", []byte("0123456789"), minio.PutObjectOptions{}); err != nil {
+		return err
+	}
+	var opts minio.GetObjectOptions
+	if err := opts.SetRange(2, 5); err != nil {
+		return err
+	}
+	got, err := env.get(ctx, "range/object", opts)
+	if err != nil {
+		return err
+	}
+	return expectData(got, []byte("2345"))
+}
+
+func checkSuffixRange(ctx context.Context, env *Env) error {
+	if err := env.put(ctx, "range/suffix", []byte("0123456789"), minio.PutObjectOptions{}); err != nil {
+		return err
+	}
+	var opts minio.GetObjectOptions
+	if err := opts.SetRange(0, -3); err != nil {
+		return err
+	}
+	got, err := env.get(ctx, "range/suffix", opts)
+	if err != nil {
+		return err
+	}
+	return expectData(got, []byte("789"))
+}
+
+func checkUserMetadata(ctx context.Context, env *Env) error {
+	opts := minio.PutObjectOptions{UserMetadata: map[string]string{"color": "blue"}}
+	if err := env.put(ctx, "metadata/user", []byte("metadata"), opts); err != nil {
+		return err
+	}
+	info, err := env.Client.StatObjectWithContext(ctx, env.Bucket, "metadata/user", minio.StatObjectOptions{})
+	if err != nil {
+		return err
+	}
+	if color := info.Metadata.Get("X-Amz-Meta-Color"); color != "blue" {
+		return errs.New("expected user metadata color=blue, got %q", color)
+	}
+	return nil
+}
+
+func checkContentType(ctx context.Context, env *Env) error {
+	opts := minio.PutObjectOptions{ContentType: "text/plain"}
+	if err := env.put(ctx, "metadata/content-type", []byte("metadata"), opts); err != nil {
+		return err
+	}
+	info, err := env.Client.StatObjectWithContext(ctx, env.Bucket, "metadata/content-type", minio.StatObjectOptions{})
+	if err != nil {
+		return err
+	}
+	if info.ContentType != "text/plain" {
+		return errs.New("expected content type text/plain, got %q", info.ContentType)
+	}
+	return nil
+}
+
+// putKeys puts an object for every key into the shared bucket.
+func putKeys(ctx context.Context, env *Env, keys []string) error {
+	for _, key := range keys {
+		if err := env.put(ctx, key, []byte(key), minio.PutObjectOptions{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkListDelimiter(ctx context.Context, env *Env) error {
+	if err := putKeys(ctx, env, []string{"delimiter/a", "delimiter/dir/b", "delimiter/dir/c"}); err != nil {
+		return err
+	}
+	result, err := env.Client.ListObjects(env.Bucket, "delimiter/", "", "/", 1000)
+	if err != nil {
+		return err
+	}
+
+	var keys, prefixes []string
+	for _, object := range result.Contents {
+		keys = append(keys, object.Key)
+	}
+	for _, prefix := range result.CommonPrefixes {
+		prefixes = append(prefixes, prefix.Prefix)
+	}
+	if !reflect.DeepEqual(keys, []string{"delimiter/a"}) || !reflect.DeepEqual(prefixes, []string{"delimiter/dir/"}) {
+		return errs.New("expected [delimiter/a] and prefixes [delimiter/dir/], got %v and prefixes %v", keys, prefixes)
+	}
+	return nil
+}
+
+func checkListPages(ctx context.Context, env *Env) error {
+	expected := []string{"pages/a", "pages/b", "pages/c"}
+	if err := putKeys(ctx, env, expected); err != nil {
+		return err
+	}
+
+	var keys []string
+	marker := ""
+	for page := 0; page <= len(expected); page++ {
+		result, err := env.Client.ListObjects(env.Bucket, "pages/", marker, "", 1)
+		if err != nil {
+			return err
+		}
+		if len(result.Contents) > 1 {
+			return errs.New("expected at most 1 key per page, got %d", len(result.Contents))
+		}
+		for _, object := range result.Contents {
+			keys = append(keys, object.Key)
+		}
+		if !result.IsTruncated {
+			if !reflect.DeepEqual(keys, expected) {
+				return errs.New("expected %v, got %v", expected, keys)
+			}
+			return nil
+		}
+		marker = result.NextMarker
+		if marker == "" && len(result.Contents) > 0 {
+			marker = result.Contents[len(result.Contents)-1].Key
+		}
+	}
+	return errs.New("listing didn't end after %d pages", len(expected)+1)
+}
+
+func checkListPagesV2(ctx context.Context, env *Env) error {
+	expected := []string{"pagesv2/a", "pagesv2/b", "pagesv2/c"}
+	if err := putKeys(ctx, env, expected); err != nil {
+		return err
+	}
+
+	var keys []string
+	token := ""
+	for page := 0; page <= len(expected); page++ {
+		result, err := env.Client.ListObjectsV2(env.Bucket, "pagesv2/", token, false, "", 1, "")
+		if err != nil {
+			return err
+		}
+		if len(result.Contents) > 1 {
+			return errs.New("expected at most 1 key per page, got %d", len(result.Contents))
+		}
+		for _, object := range result.Contents {
+			keys = append(keys, object.Key)
+		}
+		if !result.IsTruncated {
+			if !reflect.DeepEqual(keys, expected) {
+				return errs.New("expected %v, got %v", expected, keys)
+			}
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+	return errs.New("listing didn't end after %d pages", len(expected)+1)
+}
+
+func checkMultipart(ctx context.Context, env *Env) error {
+	// two parts, since parts but the last have to be at least 5 MiB
+	const partSize = 5 << 20
+	data := bytes.Repeat([]byte("m"), partSize+1<<20)
+
+	_, err := env.Client.Client.PutObjectWithContext(ctx, env.Bucket, "multipart/object", bytes.NewReader(data), -1, minio.PutObjectOptions{PartSize: partSize})
+	if err != nil {
+		return err
+	}
+	got, err := env.get(ctx, "multipart/object", minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, data) {
+		return errs.New("expected %d bytes, got %d different ones", len(data), len(got))
+	}
+	return nil
+}
+
+func checkCopy(ctx context.Context, env *Env) error {
+	data := []byte("copy")
+	if err := env.put(ctx, "copy/source", data, minio.PutObjectOptions{}); err != nil {
+		return err
+	}
+	if _, err := env.Client.CopyObjectWithContext(ctx, env.Bucket, "copy/source", env.Bucket, "copy/destination", nil); err != nil {
+		return err
+	}
+	got, err := env.get(ctx, "copy/destination", minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	return expectData(got, data)
+}
+
+func checkMissingObject(ctx context.Context, env *Env) error {
+	_, err := env.get(ctx, "errors/missing", minio.GetObjectOptions{})
+	return expectCode(err, "NoSuchKey")
+}
+
+func checkMissingBucket(ctx context.Context, env *Env) error {
+	_, err := getObject(ctx, env.Client, env.Prefix+"missing", "key", minio.GetObjectOptions{})
+	return expectCode(err, "NoSuchBucket")
+}
@@ -0,0 +1,176 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package s3check checks which features of S3 an endpoint supports, with
+// checks in the categories of the ceph s3-tests.
+package s3check
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"time"
+
+	minio "github.com/minio/minio-go/v6"
+	"github.com/zeebo/errs"
+)
+
+// Error is the errs class of s3check errors.
+var Error = errs.Class("s3check")
+
+// Config configures the endpoint that is checked.
+type Config struct {
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	NoSSL     bool
+
+	// BucketPrefix is the prefix of the names of the buckets that the checks
+	// create, and delete again.
+	BucketPrefix string
+}
+
+// Env is what a check runs against.
+type Env struct {
+	Client *minio.Core
+	// Bucket is a bucket that the checks share. Every check uses keys of its
+	// own in it.
+	Bucket string
+	// Prefix is the prefix of the names of the buckets that checks create.
+	Prefix string
+}
+
+// Check is a conformance check.
+type Check struct {
+	Category string
+	Name     string
+	Run      func(ctx context.Context, env *Env) error
+}
+
+// Result is the result of a check.
+type Result struct {
+	Category string        `json:"category"`
+	Name     string        `json:"name"`
+	Passed   bool          `json:"passed"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Report is the result of every check that ran.
+type Report struct {
+	Endpoint string   `json:"endpoint"`
+	Passed   int      `json:"passed"`
+	Failed   int      `json:"failed"`
+	Results  []Result `json:"results"`
+}
+
+// Categories returns the categories of checks, sorted.
+func Categories(checks []Check) []string {
+	seen := make(map[string]bool)
+	var categories []string
+	for _, check := range checks {
+		if !seen[check.Category] {
+			seen[check.Category] = true
+			categories = append(categories, check.Category)
+		}
+	}
+	sort.Strings(categories)
+	return categories
+}
+
+// Select returns the checks of the comma separated categories, or every check
+// if there are none.
+func Select(checks []Check, categories string) ([]Check, error) {
+	selected := make(map[string]bool)
+	for _, category := range strings.Split(categories, ",") {
+		if category = strings.TrimSpace(category); category != "" {
+			selected[category] = true
+		}
+	}
+	if len(selected) == 0 {
+		return checks, nil
+	}
+
+	known := make(map[string]bool)
+	for _, category := range Categories(checks) {
+		known[category] = true
+	}
+	for category := range selected {
+		if !known[category] {
+			return nil, Error.New("unknown category %q: must be one of %s", category, strings.Join(Categories(checks), ", "))
+		}
+	}
+
+	var result []Check
+	for _, check := range checks {
+		if selected[check.Category] {
+			result = append(result, check)
+		}
+	}
+	return result, nil
+}
+
+// Run runs checks against the endpoint of config, in a bucket that it creates
+// and deletes again. It fails only if the checks can't run at all; failed
+// checks are in the report.
+func Run(ctx context.Context, config Config, checks []Check) (_ Report, err error) {
+	client, err := minio.NewCore(config.Endpoint, config.AccessKey, config.SecretKey, !config.NoSSL)
+	if err != nil {
+		return Report{}, Error.Wrap(err)
+	}
+
+	var suffix [4]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return Report{}, Error.Wrap(err)
+	}
+	prefix := config.BucketPrefix + hex.EncodeToString(suffix[:]) + "-"
+	env := &Env{Client: client, Bucket: prefix + "shared", Prefix: prefix}
+
+	if err := client.MakeBucket(env.Bucket, ""); err != nil {
+		return Report{}, Error.New("creating bucket %q: %v", env.Bucket, err)
+	}
+	defer func() { err = errs.Combine(err, removeBucket(client, env.Bucket)) }()
+
+	report := Report{Endpoint: config.Endpoint}
+	for _, check := range checks {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		start := time.Now()
+		checkErr := check.Run(ctx, env)
+		result := Result{
+			Category: check.Category,
+			Name:     check.Name,
+			Passed:   checkErr == nil,
+			Duration: time.Since(start),
+		}
+		if checkErr != nil {
+			result.Error = checkErr.Error()
+			report.Failed++
+		} else {
+			report.Passed++
+		}
+		report.Results = append(report.Results, result)
+	}
+	return report, nil
+}
+
+// removeBucket removes a bucket with its objects.
+func removeBucket(client *minio.Core, bucket string) error {
+	done := make(chan struct{})
+	defer close(done)
+
+	var group errs.Group
+	for object := range client.Client.ListObjectsV2(bucket, "", true, done) {
+		if object.Err != nil {
+			group.Add(object.Err)
+			break
+		}
+		group.Add(client.RemoveObject(bucket, object.Key))
+	}
+	group.Add(client.RemoveBucket(bucket))
+	return Error.Wrap(group.Err())
+}
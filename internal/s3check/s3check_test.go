@@ -0,0 +1,32 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package s3check_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/stargate/internal/s3check"
+)
+
+func TestSelect(t *testing.T) {
+	categories := s3check.Categories(s3check.Checks)
+	require.Contains(t, categories, "listing")
+	require.Contains(t, categories, "multipart")
+
+	all, err := s3check.Select(s3check.Checks, "")
+	require.NoError(t, err)
+	require.Equal(t, len(s3check.Checks), len(all))
+
+	selected, err := s3check.Select(s3check.Checks, "range, copy")
+	require.NoError(t, err)
+	require.NotEmpty(t, selected)
+	for _, check := range selected {
+		require.Contains(t, []string{"range", "copy"}, check.Category)
+	}
+
+	_, err = s3check.Select(s3check.Checks, "range,acl")
+	require.Error(t, err)
+}
@@ -0,0 +1,252 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package script runs a Lua script of the operator for the requests of
+// objects, which can deny them, rewrite their keys and change the metadata of
+// uploads, for policies that don't warrant a plugin.
+//
+// The script defines a function
+//
+//	function on_request(request)
+//	  -- request.operation, like "PutObject"
+//	  -- request.bucket, request.key
+//	  -- request.tenant, the tracing tenant id of the API key
+//	  -- request.metadata, a table of the metadata of uploads
+//	end
+//
+// which may change request.key and request.metadata, and denies the request by
+// returning false and a reason. Keys have to be rewritten the same way for
+// every operation of an object, like the parts of a multipart upload.
+//
+// Scripts run in a sandbox: only the base, string, table and math libraries
+// are open, without the functions that load code or print, and without
+// string.rep. Every call has to finish within a timeout, and the stacks of the
+// script are bounded.
+package script
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+	"github.com/zeebo/errs"
+)
+
+var mon = monkit.Package()
+
+// Error is the error class for this package.
+var Error = errs.Class("script")
+
+// The bounds of the stacks of a script. The registry holds the values that
+// functions work on, and the call stack the functions that are running.
+const (
+	callStackSize   = 64
+	registrySize    = 1024
+	registryMaxSize = 64 * 1024
+)
+
+// removedGlobals are the functions of the base library that a script may not
+// call, since they load code, write to stdout or tune the garbage collector.
+var removedGlobals = []string{
+	"collectgarbage", "dofile", "getfenv", "load", "loadfile", "loadstring",
+	"module", "newproxy", "print", "_printregs", "require", "setfenv",
+}
+
+// Config configures the request script.
+type Config struct {
+	File    string        `help:"path of a lua script with an on_request(request) function that can deny requests of objects, rewrite their keys and change the metadata of uploads; empty disables the script" default:""`
+	Timeout time.Duration `help:"how long the script may run for a request before the request fails" default:"50ms"`
+}
+
+// Request is a request of an object that the script decides on.
+type Request struct {
+	Operation string
+	Bucket    string
+	Key       string
+	Tenant    string
+	Metadata  map[string]string // the metadata of uploads, nil for other operations
+}
+
+// Decision is what the script decided for a request.
+type Decision struct {
+	Deny     bool
+	Reason   string
+	Key      string            // the key to use for the request
+	Metadata map[string]string // the metadata of uploads with the changes of the script
+}
+
+// Script is a compiled request script. It is safe for concurrent use.
+type Script struct {
+	name    string
+	proto   *lua.FunctionProto
+	timeout time.Duration
+
+	// states are the idle Lua states, which are not safe for concurrent use
+	states sync.Pool
+}
+
+// Load loads the script of config, which is nil when config has none.
+func Load(config Config) (*Script, error) {
+	if config.File == "" {
+		return nil, nil
+	}
+	source, err := ioutil.ReadFile(config.File)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	return New(config.File, string(source), config.Timeout)
+}
+
+// New compiles the source of the script with the name and checks that it
+// defines on_request.
+func New(name, source string, timeout time.Duration) (*Script, error) {
+	chunk, err := parse.Parse(strings.NewReader(source), name)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	proto, err := lua.Compile(chunk, name)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	script := &Script{name: name, proto: proto, timeout: timeout}
+	state, err := script.newState(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	script.states.Put(state)
+	return script, nil
+}
+
+// newState returns a sandboxed Lua state that ran the script.
+func (script *Script) newState(ctx context.Context) (_ *lua.LState, err error) {
+	state := lua.NewState(lua.Options{
+		SkipOpenLibs:    true,
+		CallStackSize:   callStackSize,
+		RegistrySize:    registrySize,
+		RegistryMaxSize: registryMaxSize,
+	})
+	defer func() {
+		if err != nil {
+			state.Close()
+		}
+	}()
+
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		err := state.CallByParam(lua.P{Fn: state.NewFunction(lib.open), Protect: true}, lua.LString(lib.name))
+		if err != nil {
+			return nil, Error.Wrap(err)
+		}
+	}
+	for _, name := range removedGlobals {
+		state.SetGlobal(name, lua.LNil)
+	}
+	if strs, ok := state.GetGlobal(lua.StringLibName).(*lua.LTable); ok {
+		strs.RawSetString("rep", lua.LNil)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, script.timeout)
+	defer cancel()
+	state.SetContext(ctx)
+	defer state.RemoveContext()
+
+	state.Push(state.NewFunctionFromProto(script.proto))
+	if err := state.PCall(0, 0, nil); err != nil {
+		return nil, Error.Wrap(err)
+	}
+	if _, ok := state.GetGlobal("on_request").(*lua.LFunction); !ok {
+		return nil, Error.New("%s defines no on_request function", script.name)
+	}
+	return state, nil
+}
+
+// Decide runs on_request for the request.
+func (script *Script) Decide(ctx context.Context, request Request) (_ Decision, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	state, _ := script.states.Get().(*lua.LState)
+	if state == nil {
+		if state, err = script.newState(ctx); err != nil {
+			return Decision{}, err
+		}
+	}
+
+	table := state.NewTable()
+	table.RawSetString("operation", lua.LString(request.Operation))
+	table.RawSetString("bucket", lua.LString(request.Bucket))
+	table.RawSetString("key", lua.LString(request.Key))
+	table.RawSetString("tenant", lua.LString(request.Tenant))
+	if request.Metadata != nil {
+		metadata := state.NewTable()
+		for key, value := range request.Metadata {
+			metadata.RawSetString(key, lua.LString(value))
+		}
+		table.RawSetString("metadata", metadata)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, script.timeout)
+	defer cancel()
+	state.SetContext(ctx)
+
+	err = state.CallByParam(lua.P{Fn: state.GetGlobal("on_request"), NRet: 2, Protect: true}, table)
+	if err != nil {
+		// the state may have stopped anywhere in the script
+		state.Close()
+		return Decision{}, Error.Wrap(err)
+	}
+	allowed, reason := state.Get(-2), state.Get(-1)
+	state.Pop(2)
+	state.RemoveContext()
+	script.states.Put(state)
+
+	if allowed == lua.LFalse {
+		return Decision{Deny: true, Reason: lua.LVAsString(reason)}, nil
+	}
+
+	key, ok := table.RawGetString("key").(lua.LString)
+	if !ok || key == "" {
+		return Decision{}, Error.New("%s set an invalid key for %s", script.name, request.Key)
+	}
+	decision := Decision{Key: string(key)}
+	if request.Metadata != nil {
+		decision.Metadata, err = metadataOf(table.RawGetString("metadata"))
+		if err != nil {
+			return Decision{}, Error.New("%s set invalid metadata for %s: %v", script.name, request.Key, err)
+		}
+	}
+	return decision, nil
+}
+
+// metadataOf returns the metadata in the table value, which has to map strings
+// to strings.
+func metadataOf(value lua.LValue) (map[string]string, error) {
+	table, ok := value.(*lua.LTable)
+	if !ok {
+		return nil, errs.New("metadata is a %s", value.Type())
+	}
+	metadata := make(map[string]string)
+	var invalid error
+	table.ForEach(func(key, value lua.LValue) {
+		k, kok := key.(lua.LString)
+		v, vok := value.(lua.LString)
+		if !kok || !vok {
+			invalid = errs.New("the entry for %s isn't a string", key)
+			return
+		}
+		metadata[string(k)] = string(v)
+	})
+	return metadata, invalid
+}
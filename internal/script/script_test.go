@@ -0,0 +1,131 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package script_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/stargate/internal/script"
+)
+
+const policy = `
+function on_request(request)
+  if request.bucket == "private" then
+    return false, "private bucket"
+  end
+  if request.operation == "PutObject" then
+    request.metadata["tenant"] = request.tenant
+    request.metadata["secret"] = nil
+  end
+  request.key = string.lower(request.key)
+end
+`
+
+func TestDecide(t *testing.T) {
+	ctx := context.Background()
+	hook, err := script.New("policy.lua", policy, time.Second)
+	require.NoError(t, err)
+
+	decision, err := hook.Decide(ctx, script.Request{Operation: "GetObject", Bucket: "private", Key: "a"})
+	require.NoError(t, err)
+	assert.Equal(t, script.Decision{Deny: true, Reason: "private bucket"}, decision)
+
+	decision, err = hook.Decide(ctx, script.Request{Operation: "GetObject", Bucket: "public", Key: "Dir/A"})
+	require.NoError(t, err)
+	assert.Equal(t, script.Decision{Key: "dir/a"}, decision)
+
+	decision, err = hook.Decide(ctx, script.Request{
+		Operation: "PutObject", Bucket: "public", Key: "B", Tenant: "t",
+		Metadata: map[string]string{"content-type": "text/plain", "secret": "x"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, script.Decision{Key: "b", Metadata: map[string]string{
+		"content-type": "text/plain",
+		"tenant":       "t",
+	}}, decision)
+}
+
+func TestDecideConcurrently(t *testing.T) {
+	ctx := context.Background()
+	hook, err := script.New("policy.lua", policy, time.Second)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				decision, err := hook.Decide(ctx, script.Request{Operation: "GetObject", Bucket: "public", Key: "A"})
+				assert.NoError(t, err)
+				assert.Equal(t, "a", decision.Key)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestInvalidScripts(t *testing.T) {
+	for name, source := range map[string]string{
+		"syntax error":   "function on_request(",
+		"no on_request":  "x = 1",
+		"failing chunk":  "error('broken')",
+		"endless chunk":  "while true do end function on_request() end",
+		"loads code":     "loadstring('x = 1')() function on_request() end",
+		"repeats string": "local s = string.rep('x', 10) function on_request() end",
+	} {
+		_, err := script.New(name, source, 100*time.Millisecond)
+		assert.True(t, script.Error.Has(err), name)
+	}
+}
+
+func TestFailingDecisions(t *testing.T) {
+	ctx := context.Background()
+	for name, source := range map[string]string{
+		"error":            "function on_request() error('broken') end",
+		"endless":          "function on_request() while true do end end",
+		"deep recursion":   "function on_request(r) return on_request(r) + 1 end",
+		"invalid key":      "function on_request(r) r.key = 1 end",
+		"empty key":        "function on_request(r) r.key = '' end",
+		"invalid metadata": "function on_request(r) r.metadata = {a = 1} end",
+	} {
+		hook, err := script.New(name, source, 100*time.Millisecond)
+		require.NoError(t, err, name)
+
+		_, err = hook.Decide(ctx, script.Request{Operation: "PutObject", Key: "a", Metadata: map[string]string{}})
+		assert.True(t, script.Error.Has(err), name)
+
+		// the script keeps working after failures
+		_, err = hook.Decide(ctx, script.Request{Operation: "PutObject", Key: "a", Metadata: map[string]string{}})
+		assert.True(t, script.Error.Has(err), name)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	hook, err := script.Load(script.Config{})
+	require.NoError(t, err)
+	assert.Nil(t, hook)
+
+	dir, err := ioutil.TempDir("", "script")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "policy.lua")
+	require.NoError(t, ioutil.WriteFile(path, []byte(policy), 0600))
+	hook, err = script.Load(script.Config{File: path, Timeout: time.Second})
+	require.NoError(t, err)
+	require.NotNil(t, hook)
+
+	_, err = script.Load(script.Config{File: filepath.Join(dir, "missing.lua"), Timeout: time.Second})
+	assert.True(t, script.Error.Has(err))
+}
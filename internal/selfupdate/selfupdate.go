@@ -0,0 +1,290 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package selfupdate replaces the running binary with the release for its
+// platform that a signed manifest describes.
+//
+// A release endpoint serves the manifest, which lists the binary of every
+// platform with its sha256 hash, and next to it a base64 encoded ed25519
+// signature of the manifest with the suffix .sig. Binaries are only installed
+// when the signature and the hash match.
+package selfupdate
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
+)
+
+var mon = monkit.Package()
+
+// Error is the error class for this package.
+var Error = errs.Class("selfupdate")
+
+// maxManifestSize is the largest manifest that is fetched.
+const maxManifestSize = 1 << 20
+
+// BackupSuffix is added to the path of the binary that an update replaced.
+const BackupSuffix = ".old"
+
+// Config configures where releases are found.
+type Config struct {
+	ManifestURL string `help:"url of the signed manifest of the latest release" default:""`
+	PublicKey   string `help:"base64 encoded ed25519 public key that release manifests are signed with" default:""`
+}
+
+// Manifest describes a release.
+type Manifest struct {
+	Version string `json:"version"`
+
+	// Binaries are the binaries of the release by their platform, like
+	// linux-amd64.
+	Binaries map[string]Binary `json:"binaries"`
+}
+
+// Binary is the binary of a release for a platform.
+type Binary struct {
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// Platform returns the platform of the running binary, like linux-amd64.
+func Platform() string {
+	return runtime.GOOS + "-" + runtime.GOARCH
+}
+
+// Binary returns the binary of the release for the platform.
+func (manifest *Manifest) Binary(platform string) (Binary, error) {
+	binary, ok := manifest.Binaries[platform]
+	if !ok {
+		return Binary{}, Error.New("release %s has no binary for %s", manifest.Version, platform)
+	}
+	return binary, nil
+}
+
+// VerifyManifest parses the manifest in data if signature is a valid base64
+// encoded signature of data with publicKey.
+func VerifyManifest(data, signature []byte, publicKey ed25519.PublicKey) (*Manifest, error) {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil {
+		return nil, Error.New("invalid manifest signature: %v", err)
+	}
+	if len(publicKey) != ed25519.PublicKeySize || !ed25519.Verify(publicKey, data, sig) {
+		return nil, Error.New("manifest signature does not match")
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, Error.New("invalid manifest: %v", err)
+	}
+	if manifest.Version == "" {
+		return nil, Error.New("manifest has no version")
+	}
+	return &manifest, nil
+}
+
+// Updater fetches and installs releases.
+type Updater struct {
+	config    Config
+	publicKey ed25519.PublicKey
+	client    *http.Client
+}
+
+// NewUpdater constructs an Updater that fetches releases with client.
+func NewUpdater(config Config, client *http.Client) (*Updater, error) {
+	if config.ManifestURL == "" {
+		return nil, Error.New("no manifest url configured")
+	}
+	publicKey, err := base64.StdEncoding.DecodeString(config.PublicKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return nil, Error.New("public key is not a base64 encoded ed25519 key")
+	}
+	return &Updater{
+		config:    config,
+		publicKey: publicKey,
+		client:    client,
+	}, nil
+}
+
+// Latest fetches and verifies the manifest of the latest release.
+func (updater *Updater) Latest(ctx context.Context) (_ *Manifest, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	data, err := updater.fetch(ctx, updater.config.ManifestURL)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := updater.fetch(ctx, updater.config.ManifestURL+".sig")
+	if err != nil {
+		return nil, err
+	}
+	return VerifyManifest(data, signature, updater.publicKey)
+}
+
+// fetch returns the body of a small document.
+func (updater *Updater) fetch(ctx context.Context, url string) (_ []byte, err error) {
+	body, err := updater.get(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = errs.Combine(err, body.Close()) }()
+
+	data, err := ioutil.ReadAll(io.LimitReader(body, maxManifestSize))
+	return data, Error.Wrap(err)
+}
+
+// get starts downloading url.
+func (updater *Updater) get(ctx context.Context, url string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	resp, err := updater.client.Do(req)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, Error.New("%s responded with %s", url, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// Install downloads binary and replaces the binary at path with it. The
+// replaced binary is kept with BackupSuffix, so that Rollback can restore it.
+// The binary at path is left as it is when anything fails.
+func (updater *Updater) Install(ctx context.Context, binary Binary, path string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	// the download is next to path, so that renaming it replaces path
+	// atomically
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".update-*")
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	err = updater.download(ctx, binary, tmp)
+	err = errs.Combine(err, tmp.Close())
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode()); err != nil {
+		return Error.Wrap(err)
+	}
+
+	return swap(path, tmp.Name())
+}
+
+// download writes binary to w and verifies its size and hash.
+func (updater *Updater) download(ctx context.Context, binary Binary, w io.Writer) (err error) {
+	body, err := updater.get(ctx, binary.URL)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errs.Combine(err, body.Close()) }()
+
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, hash), io.LimitReader(body, binary.Size+1))
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	if n != binary.Size {
+		return Error.New("downloaded %d bytes instead of %d", n, binary.Size)
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(sum, binary.SHA256) {
+		return Error.New("sha256 of the download is %s instead of %s", sum, binary.SHA256)
+	}
+	return nil
+}
+
+// swap replaces the file at path with the file at replacement and keeps the
+// replaced file with BackupSuffix.
+func swap(path, replacement string) error {
+	backup := path + BackupSuffix
+	if err := os.Remove(backup); err != nil && !os.IsNotExist(err) {
+		return Error.Wrap(err)
+	}
+
+	// a hard link keeps path in place until it is replaced, but it isn't
+	// supported everywhere
+	linked := os.Link(path, backup) == nil
+	if !linked {
+		if err := os.Rename(path, backup); err != nil {
+			return Error.Wrap(err)
+		}
+	}
+
+	if err := os.Rename(replacement, path); err != nil {
+		if !linked {
+			err = errs.Combine(err, os.Rename(backup, path))
+		}
+		return Error.Wrap(err)
+	}
+	return nil
+}
+
+// Rollback restores the binary at path that the last Install replaced.
+func Rollback(path string) error {
+	backup := path + BackupSuffix
+	if _, err := os.Stat(backup); err != nil {
+		if os.IsNotExist(err) {
+			return Error.New("no binary to roll back to")
+		}
+		return Error.Wrap(err)
+	}
+	return Error.Wrap(os.Rename(backup, path))
+}
+
+// Newer returns whether version latest is newer than version current, both
+// like v1.2.3. Releases are newer than pre-releases of the same version.
+func Newer(current, latest string) bool {
+	c, cpre := parseVersion(current)
+	l, lpre := parseVersion(latest)
+	for i := range c {
+		if c[i] != l[i] {
+			return l[i] > c[i]
+		}
+	}
+	return cpre && !lpre
+}
+
+// parseVersion returns the numbers of a version like v1.2.3-rc, and whether
+// it is a pre-release. Missing or invalid numbers are 0.
+func parseVersion(version string) (numbers [3]int, prerelease bool) {
+	version = strings.TrimPrefix(version, "v")
+	if i := strings.IndexByte(version, '+'); i >= 0 {
+		version = version[:i]
+	}
+	if i := strings.IndexByte(version, '-'); i >= 0 {
+		version, prerelease = version[:i], true
+	}
+	for i, part := range strings.SplitN(version, ".", 3) {
+		numbers[i], _ = strconv.Atoi(part)
+	}
+	return numbers, prerelease
+}
@@ -0,0 +1,160 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package selfupdate_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/stargate/internal/selfupdate"
+)
+
+// release serves a signed manifest of a release with a binary for the
+// platform.
+type release struct {
+	server    *httptest.Server
+	publicKey ed25519.PublicKey
+	binary    []byte
+}
+
+func newRelease(t *testing.T, platform string, binary []byte) *release {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	r := &release{publicKey: publicKey, binary: binary}
+	mux := http.NewServeMux()
+	r.server = httptest.NewServer(mux)
+
+	sum := sha256.Sum256(binary)
+	manifest, err := json.Marshal(selfupdate.Manifest{
+		Version: "v1.2.0",
+		Binaries: map[string]selfupdate.Binary{
+			platform: {
+				URL:    r.server.URL + "/stargate",
+				SHA256: hex.EncodeToString(sum[:]),
+				Size:   int64(len(binary)),
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	mux.HandleFunc("/manifest.json", func(w http.ResponseWriter, req *http.Request) {
+		_, _ = w.Write(manifest)
+	})
+	mux.HandleFunc("/manifest.json.sig", func(w http.ResponseWriter, req *http.Request) {
+		_, _ = w.Write([]byte(base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, manifest))))
+	})
+	mux.HandleFunc("/stargate", func(w http.ResponseWriter, req *http.Request) {
+		_, _ = w.Write(r.binary)
+	})
+	return r
+}
+
+func (r *release) updater(t *testing.T) *selfupdate.Updater {
+	updater, err := selfupdate.NewUpdater(selfupdate.Config{
+		ManifestURL: r.server.URL + "/manifest.json",
+		PublicKey:   base64.StdEncoding.EncodeToString(r.publicKey),
+	}, r.server.Client())
+	require.NoError(t, err)
+	return updater
+}
+
+func TestInstallAndRollback(t *testing.T) {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "selfupdate")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "stargate")
+	require.NoError(t, ioutil.WriteFile(path, []byte("old"), 0755))
+
+	r := newRelease(t, selfupdate.Platform(), []byte("new"))
+	defer r.server.Close()
+	updater := r.updater(t)
+
+	manifest, err := updater.Latest(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "v1.2.0", manifest.Version)
+	require.True(t, selfupdate.Newer("v1.1.9", manifest.Version))
+
+	binary, err := manifest.Binary(selfupdate.Platform())
+	require.NoError(t, err)
+	require.NoError(t, updater.Install(ctx, binary, path))
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "new", string(data))
+
+	require.NoError(t, selfupdate.Rollback(path))
+	data, err = ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "old", string(data))
+
+	require.Error(t, selfupdate.Rollback(path))
+
+	// a download that doesn't match the manifest is not installed
+	r.binary = []byte("bad")
+	require.Error(t, updater.Install(ctx, binary, path))
+	data, err = ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "old", string(data))
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1, "the failed download is removed")
+}
+
+func TestVerifyManifest(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	data := []byte(`{"version":"v1.2.0","binaries":{}}`)
+	signature := []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, data)))
+
+	manifest, err := selfupdate.VerifyManifest(data, signature, publicKey)
+	require.NoError(t, err)
+	require.Equal(t, "v1.2.0", manifest.Version)
+
+	_, err = selfupdate.VerifyManifest([]byte(`{"version":"v9.9.9","binaries":{}}`), signature, publicKey)
+	require.Error(t, err)
+
+	otherKey, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	_, err = selfupdate.VerifyManifest(data, signature, otherKey)
+	require.Error(t, err)
+
+	_, err = manifest.Binary("plan9-mips")
+	require.Error(t, err)
+}
+
+func TestNewer(t *testing.T) {
+	for _, test := range []struct {
+		current, latest string
+		newer           bool
+	}{
+		{"v1.2.3", "v1.2.4", true},
+		{"v1.2.3", "v1.10.0", true},
+		{"v1.2.3", "v1.2.3", false},
+		{"v1.2.3", "v1.2.2", false},
+		{"v2.0.0", "v1.9.9", false},
+		{"v1.2.3-rc", "v1.2.3", true},
+		{"v1.2.3", "v1.2.3-rc", false},
+		{"", "v0.0.1", true},
+	} {
+		require.Equal(t, test.newer, selfupdate.Newer(test.current, test.latest), "%s -> %s", test.current, test.latest)
+	}
+}
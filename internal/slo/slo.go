@@ -0,0 +1,215 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package slo tracks service level objectives for the availability and latency
+// of classes of operations, and reports how fast their error budgets burn.
+//
+// The burn rate of a window is the fraction of bad requests in the window
+// divided by the fraction that the objective allows. A burn rate of 1 spends
+// the error budget exactly over the period of the objective, so alerts are
+// usually on a high burn rate in both a short and a long window.
+package slo
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
+)
+
+// Error is the error class for this package.
+var Error = errs.Class("slo")
+
+// Windows are the windows over which burn rates are reported.
+var Windows = []time.Duration{5 * time.Minute, 30 * time.Minute, time.Hour, 6 * time.Hour}
+
+// bucketSize is the resolution of the windows.
+const bucketSize = time.Minute
+
+// Config configures the service level objectives.
+type Config struct {
+	Objectives string `help:"comma separated objectives like read=0.999/1s@0.99, which is the class of operations, the fraction of requests that succeed, and the latency that a fraction of requests are faster than; empty disables tracking" default:"read=0.999/1s@0.99,write=0.999/5s@0.99,list=0.999/2s@0.99,delete=0.999/1s@0.99"`
+}
+
+// Objective is the service level objective of a class of operations.
+type Objective struct {
+	Class string
+
+	// Availability is the fraction of requests that succeed.
+	Availability float64
+
+	// Latency is the duration that the LatencyTarget fraction of requests are
+	// faster than.
+	Latency       time.Duration
+	LatencyTarget float64
+}
+
+// ParseObjectives parses comma separated objectives like
+//
+//	read=0.999/1s@0.99,write=0.999/5s@0.99
+//
+// where every objective is the class, the availability, and the latency with
+// the fraction of requests that are faster than it.
+func ParseObjectives(s string) (objectives []Objective, err error) {
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		invalid := func() ([]Objective, error) {
+			return nil, Error.New("objective %q is not like class=availability/latency@fraction", field)
+		}
+
+		eq := strings.IndexByte(field, '=')
+		slash := strings.IndexByte(field, '/')
+		at := strings.IndexByte(field, '@')
+		if eq <= 0 || slash < eq || at < slash {
+			return invalid()
+		}
+
+		objective := Objective{Class: field[:eq]}
+		if objective.Availability, err = strconv.ParseFloat(field[eq+1:slash], 64); err != nil {
+			return invalid()
+		}
+		if objective.Latency, err = time.ParseDuration(field[slash+1 : at]); err != nil || objective.Latency <= 0 {
+			return invalid()
+		}
+		if objective.LatencyTarget, err = strconv.ParseFloat(field[at+1:], 64); err != nil {
+			return invalid()
+		}
+		if !isFraction(objective.Availability) || !isFraction(objective.LatencyTarget) {
+			return nil, Error.New("objective %q has a fraction that is not in (0, 1)", field)
+		}
+		objectives = append(objectives, objective)
+	}
+	return objectives, nil
+}
+
+// isFraction returns whether f is a fraction with an error budget.
+func isFraction(f float64) bool { return f > 0 && f < 1 }
+
+// bucket counts the requests of a minute.
+type bucket struct {
+	minute int64
+	total  int64
+	failed int64
+	slow   int64
+}
+
+// class tracks the requests of a class of operations.
+type class struct {
+	objective Objective
+	buckets   []bucket
+}
+
+// Tracker tracks requests against service level objectives. It is a
+// monkit.StatSource that reports the burn rates of every objective.
+type Tracker struct {
+	mu      sync.Mutex
+	classes map[string]*class
+}
+
+// NewTracker constructs a Tracker for the objectives.
+func NewTracker(objectives []Objective) *Tracker {
+	longest := Windows[len(Windows)-1]
+
+	tracker := &Tracker{classes: make(map[string]*class)}
+	for _, objective := range objectives {
+		tracker.classes[objective.Class] = &class{
+			objective: objective,
+			buckets:   make([]bucket, longest/bucketSize),
+		}
+	}
+	return tracker
+}
+
+// Record records a request of class that finished at the time, and whether it
+// failed. Requests of classes without an objective are ignored.
+func (tracker *Tracker) Record(className string, finished time.Time, duration time.Duration, failed bool) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	class, ok := tracker.classes[className]
+	if !ok {
+		return
+	}
+
+	minute := finished.Unix() / int64(bucketSize/time.Second)
+	b := &class.buckets[minute%int64(len(class.buckets))]
+	if b.minute != minute {
+		*b = bucket{minute: minute}
+	}
+
+	b.total++
+	if failed {
+		b.failed++
+	}
+	if duration > class.objective.Latency {
+		b.slow++
+	}
+}
+
+// BurnRates returns how fast the availability and latency error budgets of
+// the class were spent in the window until now.
+func (tracker *Tracker) BurnRates(className string, window time.Duration, now time.Time) (availability, latency float64) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	class, ok := tracker.classes[className]
+	if !ok {
+		return 0, 0
+	}
+
+	current := now.Unix() / int64(bucketSize/time.Second)
+	oldest := current - int64(window/bucketSize) + 1
+
+	var total, failed, slow int64
+	for _, b := range class.buckets {
+		if b.minute >= oldest && b.minute <= current {
+			total += b.total
+			failed += b.failed
+			slow += b.slow
+		}
+	}
+	if total == 0 {
+		return 0, 0
+	}
+
+	availability = float64(failed) / float64(total) / (1 - class.objective.Availability)
+	latency = float64(slow) / float64(total) / (1 - class.objective.LatencyTarget)
+	return availability, latency
+}
+
+// Stats implements monkit.StatSource.
+func (tracker *Tracker) Stats(cb func(key monkit.SeriesKey, field string, val float64)) {
+	tracker.mu.Lock()
+	classNames := make([]string, 0, len(tracker.classes))
+	for className := range tracker.classes {
+		classNames = append(classNames, className)
+	}
+	tracker.mu.Unlock()
+
+	now := time.Now()
+	for _, className := range classNames {
+		for _, window := range Windows {
+			availability, latency := tracker.BurnRates(className, window, now)
+			key := monkit.NewSeriesKey("slo_burn_rate").
+				WithTag("class", className).
+				WithTag("window", windowName(window))
+			cb(key, "availability", availability)
+			cb(key, "latency", latency)
+		}
+	}
+}
+
+// windowName returns a short name of the window, like 5m or 6h.
+func windowName(window time.Duration) string {
+	if window%time.Hour == 0 {
+		return strconv.FormatInt(int64(window/time.Hour), 10) + "h"
+	}
+	return strconv.FormatInt(int64(window/time.Minute), 10) + "m"
+}
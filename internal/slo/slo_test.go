@@ -0,0 +1,81 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package slo_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/stargate/internal/slo"
+)
+
+func TestParseObjectives(t *testing.T) {
+	objectives, err := slo.ParseObjectives("read=0.999/1s@0.99, write=0.99/5s@0.9,")
+	require.NoError(t, err)
+	require.Equal(t, []slo.Objective{
+		{Class: "read", Availability: 0.999, Latency: time.Second, LatencyTarget: 0.99},
+		{Class: "write", Availability: 0.99, Latency: 5 * time.Second, LatencyTarget: 0.9},
+	}, objectives)
+
+	objectives, err = slo.ParseObjectives("")
+	require.NoError(t, err)
+	require.Empty(t, objectives)
+
+	for _, invalid := range []string{
+		"read",
+		"read=0.999",
+		"read=0.999/1s",
+		"=0.999/1s@0.99",
+		"read=x/1s@0.99",
+		"read=0.999/x@0.99",
+		"read=0.999/1s@x",
+		"read=1/1s@0.99",
+		"read=0.999/1s@0",
+		"read=0.999/-1s@0.99",
+	} {
+		_, err := slo.ParseObjectives(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestTracker(t *testing.T) {
+	tracker := slo.NewTracker([]slo.Objective{
+		{Class: "read", Availability: 0.99, Latency: time.Second, LatencyTarget: 0.9},
+	})
+	now := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	availability, latency := tracker.BurnRates("read", time.Hour, now)
+	require.Zero(t, availability)
+	require.Zero(t, latency)
+
+	// an hour ago, 100 fast requests succeeded
+	for i := 0; i < 100; i++ {
+		tracker.Record("read", now.Add(-50*time.Minute), time.Millisecond, false)
+	}
+	// just now, 2 of 100 requests failed and 20 were slow
+	for i := 0; i < 100; i++ {
+		tracker.Record("read", now, time.Duration(i%5)*time.Second/2, i < 2)
+	}
+	// classes without an objective are ignored
+	tracker.Record("write", now, time.Minute, true)
+
+	availability, latency = tracker.BurnRates("read", 5*time.Minute, now)
+	require.InDelta(t, 2, availability, 1e-9)
+	require.InDelta(t, 4, latency, 1e-9)
+
+	availability, latency = tracker.BurnRates("read", time.Hour, now)
+	require.InDelta(t, 1, availability, 1e-9)
+	require.InDelta(t, 2, latency, 1e-9)
+
+	availability, latency = tracker.BurnRates("write", time.Hour, now)
+	require.Zero(t, availability)
+	require.Zero(t, latency)
+
+	// the requests leave the windows
+	availability, latency = tracker.BurnRates("read", 6*time.Hour, now.Add(7*time.Hour))
+	require.Zero(t, availability)
+	require.Zero(t, latency)
+}
@@ -0,0 +1,52 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package tagged keeps monkit counters and values of series with tags, like
+// the operation of a request, which monkit scopes only make for tasks.
+package tagged
+
+import (
+	"sync"
+
+	"github.com/spacemonkeygo/monkit/v3"
+)
+
+var (
+	mu      sync.Mutex
+	sources = map[string]monkit.StatSource{}
+)
+
+// source returns the stat source of the series name with tags in scope, and
+// makes it with create and chains it to scope the first time.
+func source(scope *monkit.Scope, name string, tags []monkit.SeriesTag, create func(monkit.SeriesKey) monkit.StatSource) monkit.StatSource {
+	key := monkit.NewSeriesKey(name)
+	for _, tag := range tags {
+		key = key.WithTag(tag.Key, tag.Val)
+	}
+	id := scope.Name() + " " + key.String()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if existing, ok := sources[id]; ok {
+		return existing
+	}
+	created := create(key)
+	scope.Chain(created)
+	sources[id] = created
+	return created
+}
+
+// Counter returns the counter of the series name with tags in scope.
+func Counter(scope *monkit.Scope, name string, tags ...monkit.SeriesTag) *monkit.Counter {
+	return source(scope, name, tags, func(key monkit.SeriesKey) monkit.StatSource {
+		return monkit.NewCounter(key)
+	}).(*monkit.Counter)
+}
+
+// FloatVal returns the float value of the series name with tags in scope.
+func FloatVal(scope *monkit.Scope, name string, tags ...monkit.SeriesTag) *monkit.FloatVal {
+	return source(scope, name, tags, func(key monkit.SeriesKey) monkit.StatSource {
+		return monkit.NewFloatVal(key)
+	}).(*monkit.FloatVal)
+}
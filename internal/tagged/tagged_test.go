@@ -0,0 +1,51 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package tagged_test
+
+import (
+	"testing"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/stargate/internal/tagged"
+)
+
+// collect returns the values of the field of the series of scope, keyed by
+// their series.
+func collect(scope *monkit.Scope, field string) map[string]float64 {
+	values := map[string]float64{}
+	scope.Stats(func(key monkit.SeriesKey, f string, val float64) {
+		if f == field {
+			values[key.String()] = val
+		}
+	})
+	return values
+}
+
+func TestCounter(t *testing.T) {
+	scope := monkit.NewRegistry().ScopeNamed("test")
+
+	tagged.Counter(scope, "requests", monkit.NewSeriesTag("operation", "get")).Inc(1)
+	tagged.Counter(scope, "requests", monkit.NewSeriesTag("operation", "get")).Inc(2)
+	tagged.Counter(scope, "requests", monkit.NewSeriesTag("operation", "put")).Inc(1)
+
+	require.Equal(t, map[string]float64{
+		"requests,operation=get": 3,
+		"requests,operation=put": 1,
+	}, collect(scope, "value"))
+}
+
+func TestFloatVal(t *testing.T) {
+	scope := monkit.NewRegistry().ScopeNamed("test")
+
+	tagged.FloatVal(scope, "seconds", monkit.NewSeriesTag("operation", "get")).Observe(1)
+	tagged.FloatVal(scope, "seconds", monkit.NewSeriesTag("operation", "get")).Observe(3)
+	tagged.FloatVal(scope, "seconds", monkit.NewSeriesTag("operation", "put")).Observe(2)
+
+	require.Equal(t, map[string]float64{
+		"seconds,operation=get": 4,
+		"seconds,operation=put": 2,
+	}, collect(scope, "sum"))
+}
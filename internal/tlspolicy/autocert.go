@@ -0,0 +1,67 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package tlspolicy
+
+import (
+	"crypto/tls"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// AutocertConfig configures obtaining certificates from an ACME CA like
+// Let's Encrypt instead of loading them from files.
+type AutocertConfig struct {
+	Hosts        string `help:"comma separated host names to obtain certificates for from Let's Encrypt; takes precedence over cert-file, and implies accepting the terms of service of the CA" default:""`
+	CacheDir     string `help:"directory to keep the ACME account and the obtained certificates in" default:"$CONFDIR/acme"`
+	Email        string `help:"contact email of the ACME account, for notices about expiring certificates" default:""`
+	DirectoryURL string `help:"url of the ACME directory of the CA, like the Let's Encrypt staging environment; Let's Encrypt when empty" default:""`
+	HTTPAddress  string `help:"address to answer ACME http-01 challenges over, which must be reachable on port 80 of the hosts; without it challenges are answered with tls-alpn-01, which needs the listener on port 443" default:""`
+}
+
+// AutocertEnabled returns whether certificates are obtained from an ACME CA.
+func (config Config) AutocertEnabled() bool {
+	return config.Autocert.Hosts != ""
+}
+
+// AutocertTLSConfig returns the tls.Config for the policy with certificates
+// that are obtained and renewed from the ACME CA during handshakes. The
+// returned manager answers http-01 challenges with its HTTPHandler.
+func (config Config) AutocertTLSConfig() (_ *tls.Config, _ *autocert.Manager, err error) {
+	if !config.AutocertEnabled() {
+		return nil, nil, Error.New("no autocert hosts configured")
+	}
+	if config.OCSPStapling {
+		return nil, nil, Error.New("ocsp stapling is only supported with cert-file")
+	}
+
+	tlsConfig, err := config.baseTLSConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var hosts []string
+	for _, host := range strings.Split(config.Autocert.Hosts, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(hosts...),
+		Email:      config.Autocert.Email,
+	}
+	if config.Autocert.CacheDir != "" {
+		manager.Cache = autocert.DirCache(config.Autocert.CacheDir)
+	}
+	if config.Autocert.DirectoryURL != "" {
+		manager.Client = &acme.Client{DirectoryURL: config.Autocert.DirectoryURL}
+	}
+
+	tlsConfig.GetCertificate = manager.GetCertificate
+	tlsConfig.NextProtos = []string{"h2", "http/1.1", acme.ALPNProto}
+	return tlsConfig, manager, nil
+}
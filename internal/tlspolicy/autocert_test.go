@@ -0,0 +1,52 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package tlspolicy_test
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/acme"
+
+	"storj.io/stargate/internal/tlspolicy"
+)
+
+func TestAutocertTLSConfig(t *testing.T) {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "tlspolicy")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	config := tlspolicy.Config{
+		MinVersion: "1.2",
+		Autocert: tlspolicy.AutocertConfig{
+			Hosts:    "auth.example.test, auth2.example.test",
+			CacheDir: dir,
+		},
+	}
+	require.True(t, config.Enabled())
+
+	tlsConfig, manager, err := config.AutocertTLSConfig()
+	require.NoError(t, err)
+	require.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MinVersion)
+	require.Contains(t, tlsConfig.NextProtos, acme.ALPNProto)
+
+	// certificates are only obtained for the configured hosts
+	require.NoError(t, manager.HostPolicy(ctx, "auth2.example.test"))
+	require.Error(t, manager.HostPolicy(ctx, "other.example.test"))
+	_, err = tlsConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.example.test"})
+	require.Error(t, err)
+
+	config.OCSPStapling = true
+	_, _, err = config.AutocertTLSConfig()
+	require.Error(t, err)
+
+	_, _, err = tlspolicy.Config{MinVersion: "1.2"}.AutocertTLSConfig()
+	require.Error(t, err)
+}
@@ -0,0 +1,141 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package tlspolicy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ocsp"
+)
+
+// maxOCSPResponseSize limits how much of a responder's answer is read.
+const maxOCSPResponseSize = 1 << 20
+
+// Stapler keeps an OCSP response for a certificate fresh and staples it to the
+// certificate during TLS handshakes.
+type Stapler struct {
+	log      *zap.Logger
+	interval time.Duration
+	client   *http.Client
+	leaf     *x509.Certificate
+	issuer   *x509.Certificate
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	stapled *tls.Certificate
+	expires time.Time
+}
+
+// NewStapler constructs a Stapler for cert, which must include its issuer in the chain.
+func NewStapler(log *zap.Logger, cert tls.Certificate, interval time.Duration) (*Stapler, error) {
+	if len(cert.Certificate) < 2 {
+		return nil, Error.New("OCSP stapling requires the issuer in the certificate chain")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	issuer, err := x509.ParseCertificate(cert.Certificate[1])
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	if len(leaf.OCSPServer) == 0 {
+		return nil, Error.New("certificate does not name an OCSP responder")
+	}
+
+	return &Stapler{
+		log:      log,
+		interval: interval,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		leaf:     leaf,
+		issuer:   issuer,
+		cert:     &cert,
+	}, nil
+}
+
+// Run refreshes the staple every interval until the context is canceled.
+// Failed refreshes are logged and the previous staple is kept until it expires.
+func (s *Stapler) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Refresh(ctx); err != nil {
+			s.log.Warn("unable to refresh OCSP staple", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh fetches a new OCSP response and staples it if the certificate is good.
+func (s *Stapler) Refresh(ctx context.Context) (err error) {
+	request, err := ocsp.CreateRequest(s.leaf, s.issuer, nil)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	httpReq, err := http.NewRequest("POST", s.leaf.OCSPServer[0], bytes.NewReader(request))
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	httpReq = httpReq.WithContext(ctx)
+	httpReq.Header.Set("Content-Type", "application/ocsp-request")
+
+	httpResp, err := s.client.Do(httpReq)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	defer func() { _ = httpResp.Body.Close() }()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return Error.New("OCSP responder returned %s", httpResp.Status)
+	}
+	raw, err := ioutil.ReadAll(http.MaxBytesReader(nil, httpResp.Body, maxOCSPResponseSize))
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	response, err := ocsp.ParseResponseForCert(raw, s.leaf, s.issuer)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	if response.Status != ocsp.Good {
+		return Error.New("OCSP responder reports the certificate is not good: status %d", response.Status)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stapled := *s.cert
+	stapled.OCSPStaple = raw
+	s.stapled = &stapled
+	s.expires = response.NextUpdate
+	return nil
+}
+
+// GetCertificate returns the certificate with the current staple, or without a
+// staple if there is no current one. It is meant to be used as
+// tls.Config.GetCertificate.
+func (s *Stapler) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stapled == nil || (!s.expires.IsZero() && time.Now().After(s.expires)) {
+		return s.cert, nil
+	}
+	return s.stapled, nil
+}
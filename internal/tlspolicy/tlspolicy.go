@@ -0,0 +1,179 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package tlspolicy configures TLS and security headers of HTTPS listeners
+// according to operator policy.
+package tlspolicy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+)
+
+// Error is the error class for this package.
+var Error = errs.Class("tls policy")
+
+// Config is the TLS policy of a listener.
+type Config struct {
+	CertFile string `help:"path to the PEM encoded certificate chain; TLS is disabled when empty unless autocert hosts are configured" default:""`
+	KeyFile  string `help:"path to the PEM encoded private key of the certificate" default:""`
+
+	MinVersion   string `help:"minimum TLS version to accept: 1.0, 1.1, 1.2 or 1.3" default:"1.2"`
+	CipherSuites string `help:"comma separated names of the cipher suites to accept for TLS 1.2 and below; Go's defaults when empty" default:""`
+
+	HSTSMaxAge            time.Duration `help:"max-age of the Strict-Transport-Security header; 0 disables the header" default:"8760h0m0s"`
+	HSTSIncludeSubdomains bool          `help:"add includeSubDomains to the Strict-Transport-Security header" default:"false"`
+
+	ClientCAFile string `help:"path to the PEM encoded CA certificates that client certificates are verified with; client certificates are only requested when set" default:""`
+
+	OCSPStapling        bool          `help:"staple OCSP responses from the responder named in the certificate" default:"false"`
+	OCSPRefreshInterval time.Duration `help:"how often to fetch a new OCSP response to staple" default:"1h0m0s"`
+
+	Autocert AutocertConfig
+}
+
+// Enabled returns whether TLS is configured, with certificate files or with
+// autocert.
+func (config Config) Enabled() bool {
+	return config.CertFile != "" || config.AutocertEnabled()
+}
+
+// TLSConfig loads the certificate and returns the tls.Config for the policy.
+// If OCSP stapling is enabled, the returned Stapler must be run to keep the
+// staple fresh; otherwise it is nil.
+func (config Config) TLSConfig(log *zap.Logger) (_ *tls.Config, _ *Stapler, err error) {
+	if config.CertFile == "" {
+		return nil, nil, Error.New("no certificate configured")
+	}
+
+	tlsConfig, err := config.baseTLSConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+	if err != nil {
+		return nil, nil, Error.Wrap(err)
+	}
+	tlsConfig.Certificates = []tls.Certificate{cert}
+
+	var stapler *Stapler
+	if config.OCSPStapling {
+		stapler, err = NewStapler(log, cert, config.OCSPRefreshInterval)
+		if err != nil {
+			return nil, nil, err
+		}
+		tlsConfig.Certificates = nil
+		tlsConfig.GetCertificate = stapler.GetCertificate
+	}
+
+	return tlsConfig, stapler, nil
+}
+
+// baseTLSConfig returns the tls.Config for the versions, cipher suites and
+// client certificates of the policy, without certificates of its own. Client
+// certificates are verified if they are given, and it's up to the handlers to
+// require them.
+func (config Config) baseTLSConfig() (*tls.Config, error) {
+	minVersion, err := ParseVersion(config.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	cipherSuites, err := ParseCipherSuites(config.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:               minVersion,
+		CipherSuites:             cipherSuites,
+		PreferServerCipherSuites: true,
+	}
+
+	if config.ClientCAFile != "" {
+		data, err := ioutil.ReadFile(config.ClientCAFile)
+		if err != nil {
+			return nil, Error.Wrap(err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, Error.New("no certificates in client CA file %q", config.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return tlsConfig, nil
+}
+
+var versions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseVersion parses a TLS version like 1.2.
+func ParseVersion(version string) (uint16, error) {
+	v, ok := versions[strings.TrimSpace(version)]
+	if !ok {
+		return 0, Error.New("unknown TLS version %q: must be one of 1.0, 1.1, 1.2 or 1.3", version)
+	}
+	return v, nil
+}
+
+// ParseCipherSuites parses a comma separated list of cipher suite names, like
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Only secure cipher suites are accepted.
+// An empty list returns nil, which selects Go's defaults.
+func ParseCipherSuites(names string) (ids []uint16, err error) {
+	if strings.TrimSpace(names) == "" {
+		return nil, nil
+	}
+
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		id, ok := known[name]
+		if !ok {
+			return nil, Error.New("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// SecurityHeaders wraps next so that every response has the security headers
+// of the policy. Strict-Transport-Security is only sent on TLS connections.
+func (config Config) SecurityHeaders(next http.Handler) http.Handler {
+	hsts := ""
+	if config.HSTSMaxAge > 0 {
+		hsts = "max-age=" + strconv.FormatInt(int64(config.HSTSMaxAge/time.Second), 10)
+		if config.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		header := w.Header()
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("X-Frame-Options", "DENY")
+		header.Set("Content-Security-Policy", "default-src 'none'; frame-ancestors 'none'")
+		header.Set("Referrer-Policy", "no-referrer")
+		if hsts != "" && req.TLS != nil {
+			header.Set("Strict-Transport-Security", hsts)
+		}
+		next.ServeHTTP(w, req)
+	})
+}
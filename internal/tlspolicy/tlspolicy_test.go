@@ -0,0 +1,183 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package tlspolicy_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	"golang.org/x/crypto/ocsp"
+
+	"storj.io/stargate/internal/tlspolicy"
+)
+
+func TestParse(t *testing.T) {
+	version, err := tlspolicy.ParseVersion("1.3")
+	require.NoError(t, err)
+	require.Equal(t, uint16(tls.VersionTLS13), version)
+
+	_, err = tlspolicy.ParseVersion("1.4")
+	require.Error(t, err)
+
+	suites, err := tlspolicy.ParseCipherSuites("")
+	require.NoError(t, err)
+	require.Nil(t, suites)
+
+	suites, err = tlspolicy.ParseCipherSuites("TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384")
+	require.NoError(t, err)
+	require.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}, suites)
+
+	// insecure suites are rejected
+	_, err = tlspolicy.ParseCipherSuites("TLS_RSA_WITH_RC4_128_SHA")
+	require.Error(t, err)
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	config := tlspolicy.Config{HSTSMaxAge: 24 * time.Hour, HSTSIncludeSubdomains: true}
+	handler := config.SecurityHeaders(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "http://example.test/", nil))
+	require.Equal(t, "nosniff", rec.Header().Get("X-Content-Type-Options"))
+	require.Empty(t, rec.Header().Get("Strict-Transport-Security"))
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "https://example.test/", nil))
+	require.Equal(t, "max-age=86400; includeSubDomains", rec.Header().Get("Strict-Transport-Security"))
+}
+
+func TestTLSConfig_OCSPStapling(t *testing.T) {
+	ctx := context.Background()
+
+	caKey, caCert := newCertificate(t, nil, nil, "")
+
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(req.Body)
+		require.NoError(t, err)
+		request, err := ocsp.ParseRequest(body)
+		require.NoError(t, err)
+
+		response, err := ocsp.CreateResponse(caCert, caCert, ocsp.Response{
+			Status:       ocsp.Good,
+			SerialNumber: request.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+		}, caKey)
+		require.NoError(t, err)
+		_, _ = w.Write(response)
+	}))
+	defer responder.Close()
+
+	leafKey, leafCert := newCertificate(t, caKey, caCert, responder.URL)
+
+	dir, err := ioutil.TempDir("", "tlspolicy")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	config := tlspolicy.Config{
+		CertFile:            filepath.Join(dir, "cert.pem"),
+		KeyFile:             filepath.Join(dir, "key.pem"),
+		MinVersion:          "1.2",
+		OCSPStapling:        true,
+		OCSPRefreshInterval: time.Hour,
+	}
+	writePEM(t, config.CertFile, "CERTIFICATE", leafCert.Raw, caCert.Raw)
+	keyBytes, err := x509.MarshalECPrivateKey(leafKey.(*ecdsa.PrivateKey))
+	require.NoError(t, err)
+	writePEM(t, config.KeyFile, "EC PRIVATE KEY", keyBytes)
+
+	tlsConfig, stapler, err := config.TLSConfig(zaptest.NewLogger(t))
+	require.NoError(t, err)
+	require.NotNil(t, stapler)
+	require.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MinVersion)
+
+	cert, err := tlsConfig.GetCertificate(nil)
+	require.NoError(t, err)
+	require.Empty(t, cert.OCSPStaple)
+
+	require.NoError(t, stapler.Refresh(ctx))
+
+	cert, err = tlsConfig.GetCertificate(nil)
+	require.NoError(t, err)
+	require.NotEmpty(t, cert.OCSPStaple)
+}
+
+// newCertificate creates a certificate signed by the parent, or a self signed
+// CA certificate if parent is nil.
+func newCertificate(t *testing.T, parentKey crypto.Signer, parent *x509.Certificate, ocspServer string) (crypto.Signer, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	if ocspServer != "" {
+		template.OCSPServer = []string{ocspServer}
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return key, cert
+}
+
+func writePEM(t *testing.T, path, blockType string, blocks ...[]byte) {
+	var data []byte
+	for _, block := range blocks {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: block})...)
+	}
+	require.NoError(t, ioutil.WriteFile(path, data, 0600))
+}
+
+func TestTLSConfig_ClientCA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlspolicy")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	_, caCert := newCertificate(t, nil, nil, "")
+	config := tlspolicy.Config{
+		MinVersion:   "1.2",
+		ClientCAFile: filepath.Join(dir, "ca.pem"),
+		Autocert:     tlspolicy.AutocertConfig{Hosts: "auth.example.test"},
+	}
+	writePEM(t, config.ClientCAFile, "CERTIFICATE", caCert.Raw)
+
+	// client certificates are verified if given, and required by handlers
+	tlsConfig, _, err := config.AutocertTLSConfig()
+	require.NoError(t, err)
+	require.Equal(t, tls.VerifyClientCertIfGiven, tlsConfig.ClientAuth)
+	require.NotNil(t, tlsConfig.ClientCAs)
+
+	writePEM(t, config.ClientCAFile, "PRIVATE KEY", []byte("not a certificate"))
+	_, _, err = config.AutocertTLSConfig()
+	require.Error(t, err)
+}
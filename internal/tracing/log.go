@@ -0,0 +1,64 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package tracing
+
+import (
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"go.uber.org/zap"
+)
+
+// Config configures the sampler of a process.
+type Config struct {
+	Rules string `help:"comma separated rules of which traces to log, like failed=1,op:GetObject=0.01,tenant:<tenant id>=1,*=0.001; the first matching rule decides and empty rules disable the sampler" default:""`
+}
+
+// Start registers a sampler with the rules of config to the default registry,
+// which logs the spans of the sampled traces with log, until stop is called.
+// Without rules it does nothing.
+func Start(log *zap.Logger, config Config) (stop func(), err error) {
+	rules, err := ParseRules(config.Rules)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return func() {}, nil
+	}
+	return NewSampler(rules, NewSpanLogger(log)).Register(monkit.Default), nil
+}
+
+// spanLogger logs the spans that finish.
+type spanLogger struct {
+	log *zap.Logger
+}
+
+// NewSpanLogger returns a span observer that logs every span that finishes
+// with log.
+func NewSpanLogger(log *zap.Logger) monkit.SpanObserver {
+	return spanLogger{log: log}
+}
+
+// Start implements monkit.SpanObserver.
+func (logger spanLogger) Start(span *monkit.Span) {}
+
+// Finish implements monkit.SpanObserver.
+func (logger spanLogger) Finish(span *monkit.Span, err error, panicked bool, finish time.Time) {
+	fields := []zap.Field{
+		zap.Int64("trace", span.Trace().Id()),
+		zap.Int64("span", span.Id()),
+		zap.String("func", span.Func().FullName()),
+		zap.Duration("duration", finish.Sub(span.Start())),
+	}
+	if parent := span.Parent(); parent != nil {
+		fields = append(fields, zap.Int64("parent", parent.Id()))
+	}
+	if err != nil {
+		fields = append(fields, zap.Error(err))
+	}
+	if panicked {
+		fields = append(fields, zap.Bool("panicked", true))
+	}
+	logger.log.Info("span", fields...)
+}
@@ -0,0 +1,102 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package tracing decides which traces are sampled with rules for the
+// operation, the tenant and the outcome of a request, instead of one global
+// sample rate.
+//
+// The decision is made when a trace finishes, so that failed requests can be
+// sampled every time. Until then the spans of every trace are buffered.
+package tracing
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
+)
+
+var mon = monkit.Package()
+
+// Error is the error class for this package.
+var Error = errs.Class("tracing")
+
+// Rule samples a fraction of the traces that it matches. Empty fields match
+// everything.
+type Rule struct {
+	// Operation is the name of the function of the root span of a trace.
+	Operation string
+	// Tenant is the TenantID of the API key of the request.
+	Tenant string
+	// Failed only matches traces where a span failed.
+	Failed bool
+
+	Fraction float64
+}
+
+// matches returns whether the rule matches a trace.
+func (rule Rule) matches(operation, tenant string, failed bool) bool {
+	return (rule.Operation == "" || rule.Operation == operation) &&
+		(rule.Tenant == "" || rule.Tenant == tenant) &&
+		(!rule.Failed || failed)
+}
+
+// Rules are sampling rules, where the first rule that matches a trace decides
+// what fraction of such traces is sampled. Traces that no rule matches are not
+// sampled.
+type Rules []Rule
+
+// ParseRules parses comma separated rules like
+//
+//	failed=1,op:GetObject=0.01,tenant:TENANTID=1,*=0.001
+//
+// where every rule is a selector and the fraction of traces that it samples.
+// The selector is * or + separated conditions, which are failed, op:<name> and
+// tenant:<tenant id>.
+func ParseRules(s string) (rules Rules, err error) {
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		i := strings.LastIndexByte(field, '=')
+		if i < 0 {
+			return nil, Error.New("rule %q has no fraction", field)
+		}
+		selector := field[:i]
+		fraction, err := strconv.ParseFloat(field[i+1:], 64)
+		if err != nil || fraction < 0 || fraction > 1 {
+			return nil, Error.New("rule %q has an invalid fraction", field)
+		}
+
+		rule := Rule{Fraction: fraction}
+		if selector != "*" {
+			for _, condition := range strings.Split(selector, "+") {
+				switch {
+				case condition == "failed":
+					rule.Failed = true
+				case strings.HasPrefix(condition, "op:") && len(condition) > len("op:"):
+					rule.Operation = strings.TrimPrefix(condition, "op:")
+				case strings.HasPrefix(condition, "tenant:") && len(condition) > len("tenant:"):
+					rule.Tenant = strings.TrimPrefix(condition, "tenant:")
+				default:
+					return nil, Error.New("rule %q has an invalid condition %q", field, condition)
+				}
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Fraction returns the fraction of the traces like this one that are sampled.
+func (rules Rules) Fraction(operation, tenant string, failed bool) float64 {
+	for _, rule := range rules {
+		if rule.matches(operation, tenant, failed) {
+			return rule.Fraction
+		}
+	}
+	return 0
+}
@@ -0,0 +1,42 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package tracing_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/stargate/internal/tracing"
+)
+
+func TestParseRules(t *testing.T) {
+	rules, err := tracing.ParseRules("failed=1, op:GetObject=0.01,tenant:KEY+op:PutObject=0.5,*=0.001")
+	require.NoError(t, err)
+	require.Equal(t, tracing.Rules{
+		{Failed: true, Fraction: 1},
+		{Operation: "GetObject", Fraction: 0.01},
+		{Operation: "PutObject", Tenant: "KEY", Fraction: 0.5},
+		{Fraction: 0.001},
+	}, rules)
+
+	rules, err = tracing.ParseRules("")
+	require.NoError(t, err)
+	require.Empty(t, rules)
+
+	for _, invalid := range []string{"failed", "failed=2", "op:=1", "bucket:a=1", "*=x"} {
+		_, err := tracing.ParseRules(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestRules_Fraction(t *testing.T) {
+	rules, err := tracing.ParseRules("failed=1,tenant:KEY=1,op:GetObject=0.01")
+	require.NoError(t, err)
+
+	require.Equal(t, 1.0, rules.Fraction("GetObject", "OTHER", true))
+	require.Equal(t, 1.0, rules.Fraction("PutObject", "KEY", false))
+	require.Equal(t, 0.01, rules.Fraction("GetObject", "OTHER", false))
+	require.Equal(t, 0.0, rules.Fraction("PutObject", "OTHER", false))
+}
@@ -0,0 +1,105 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package tracing
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+)
+
+// tenantKey is the key of the tenant in the values of a trace.
+type tenantKey struct{}
+
+// TenantID returns the tenant of the requests of an API key with the
+// macaroon head, which is the hex encoded SHA-256 hash of the head, so that
+// rules don't contain secrets.
+func TenantID(macaroonHead []byte) string {
+	hash := sha256.Sum256(macaroonHead)
+	return hex.EncodeToString(hash[:])
+}
+
+// SetTenant records the tenant of the request whose trace is in ctx, for the
+// rules that match tenants.
+func SetTenant(ctx context.Context, tenant string) {
+	if span := monkit.SpanFromCtx(ctx); span != nil {
+		span.Trace().Set(tenantKey{}, tenant)
+	}
+}
+
+// maxSpans bounds the spans that are buffered for a trace. Spans after it are
+// dropped from the trace if it is sampled.
+const maxSpans = 1000
+
+// Sampler samples traces according to rules and sends the spans of the
+// sampled traces to another span observer, like the collector of a tracing
+// backend.
+type Sampler struct {
+	rules  Rules
+	target monkit.SpanObserver
+}
+
+// NewSampler constructs a Sampler.
+func NewSampler(rules Rules, target monkit.SpanObserver) *Sampler {
+	return &Sampler{rules: rules, target: target}
+}
+
+// Register observes the traces of the registry until cancel is called.
+func (sampler *Sampler) Register(registry *monkit.Registry) (cancel func()) {
+	return registry.ObserveTraces(func(trace *monkit.Trace) {
+		trace.ObserveSpans(&traceBuffer{sampler: sampler, trace: trace})
+	})
+}
+
+// finishedSpan is the finish of a span.
+type finishedSpan struct {
+	span     *monkit.Span
+	err      error
+	panicked bool
+	finish   time.Time
+}
+
+// traceBuffer keeps the finished spans of a trace until its root span
+// finishes, and then sends them to the target if the trace is sampled.
+type traceBuffer struct {
+	sampler *Sampler
+	trace   *monkit.Trace
+
+	mu     sync.Mutex
+	failed bool
+	spans  []finishedSpan
+}
+
+// Start implements monkit.SpanObserver.
+func (buffer *traceBuffer) Start(span *monkit.Span) {}
+
+// Finish implements monkit.SpanObserver.
+func (buffer *traceBuffer) Finish(span *monkit.Span, err error, panicked bool, finish time.Time) {
+	buffer.mu.Lock()
+	defer buffer.mu.Unlock()
+
+	buffer.failed = buffer.failed || err != nil || panicked
+	if len(buffer.spans) < maxSpans {
+		buffer.spans = append(buffer.spans, finishedSpan{span, err, panicked, finish})
+	}
+	if span.Parent() != nil {
+		return
+	}
+
+	tenant, _ := buffer.trace.Get(tenantKey{}).(string)
+	fraction := buffer.sampler.rules.Fraction(span.Func().ShortName(), tenant, buffer.failed)
+	if fraction > 0 && rand.Float64() < fraction {
+		mon.Event("trace_sampled")
+		for _, finished := range buffer.spans {
+			buffer.sampler.target.Start(finished.span)
+			buffer.sampler.target.Finish(finished.span, finished.err, finished.panicked, finished.finish)
+		}
+	}
+	buffer.spans = nil
+}
@@ -0,0 +1,81 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/stargate/internal/tracing"
+)
+
+// recorder records the names of the spans that finish.
+type recorder struct {
+	finished []string
+}
+
+func (r *recorder) Start(span *monkit.Span) {}
+
+func (r *recorder) Finish(span *monkit.Span, err error, panicked bool, finish time.Time) {
+	r.finished = append(r.finished, span.Func().ShortName())
+}
+
+// request runs a trace with a root span named operation and a child span,
+// which fails if failed is set.
+func request(scope *monkit.Scope, operation, tenant string, failed bool) {
+	ctx := context.Background()
+	var err error
+	defer scope.TaskNamed(operation)(&ctx)(&err)
+
+	if tenant != "" {
+		tracing.SetTenant(ctx, tenant)
+	}
+	func() {
+		var childErr error
+		defer scope.TaskNamed("child")(&ctx)(&childErr)
+		if failed {
+			childErr = errors.New("failed")
+		}
+	}()
+}
+
+func TestSampler(t *testing.T) {
+	tenant := tracing.TenantID([]byte("head"))
+	rules, err := tracing.ParseRules("failed=1,tenant:" + tenant + "=1,op:GetObject=0")
+	require.NoError(t, err)
+
+	registry := monkit.NewRegistry()
+	scope := registry.ScopeNamed("test")
+	target := &recorder{}
+	cancel := tracing.NewSampler(rules, target).Register(registry)
+
+	request(scope, "GetObject", "", false)
+	require.Empty(t, target.finished)
+
+	request(scope, "GetObject", tenant, false)
+	require.Equal(t, []string{"child", "GetObject"}, target.finished)
+
+	target.finished = nil
+	request(scope, "GetObject", tracing.TenantID([]byte("other")), true)
+	require.Equal(t, []string{"child", "GetObject"}, target.finished)
+
+	target.finished = nil
+	request(scope, "PutObject", "", false)
+	require.Empty(t, target.finished)
+
+	cancel()
+	request(scope, "GetObject", tenant, true)
+	require.Empty(t, target.finished)
+}
+
+func TestTenantID(t *testing.T) {
+	require.Len(t, tracing.TenantID([]byte("head")), 64)
+	require.Equal(t, tracing.TenantID([]byte("head")), tracing.TenantID([]byte("head")))
+	require.NotEqual(t, tracing.TenantID([]byte("head")), tracing.TenantID([]byte("other")))
+}
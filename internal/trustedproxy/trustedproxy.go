@@ -0,0 +1,117 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package trustedproxy resolves the ips of clients that connect through
+// proxies, like load balancers or the gateway.
+//
+// Clients can send any X-Forwarded-For header, so its addresses are only
+// trusted when they were added by a proxy of the configured networks.
+package trustedproxy
+
+import (
+	"net"
+	"strings"
+
+	"github.com/zeebo/errs"
+)
+
+// Error is the error class for this package.
+var Error = errs.Class("trustedproxy")
+
+// Proxies are the networks of the proxies whose forwarded addresses are
+// trusted. Nil Proxies trust no proxy.
+type Proxies struct {
+	nets []*net.IPNet
+}
+
+// Parse parses comma separated networks in CIDR notation, like 10.0.0.0/8,
+// or single ips. It returns nil Proxies if there are none.
+func Parse(s string) (*Proxies, error) {
+	var proxies Proxies
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, Error.New("invalid ip %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies.nets = append(proxies.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, Error.New("invalid network %q", entry)
+		}
+		proxies.nets = append(proxies.nets, network)
+	}
+	if len(proxies.nets) == 0 {
+		return nil, nil
+	}
+	return &proxies, nil
+}
+
+// trusted returns whether ip is the address of a trusted proxy.
+func (proxies *Proxies) trusted(ip string) bool {
+	if proxies == nil {
+		return false
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range proxies.nets {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the ip of the client of a connection from remoteAddr, with
+// the X-Forwarded-For header forwarded. The forwarded addresses are walked
+// from the last one, which the proxy that connected added, for as long as
+// they were added by trusted proxies, and the first address that wasn't
+// added by one is the client. Without a trusted proxy, it is the host of
+// remoteAddr.
+func (proxies *Proxies) ClientIP(remoteAddr, forwarded string) string {
+	ip := remoteAddr
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		ip = host
+	}
+	if !proxies.trusted(ip) || forwarded == "" {
+		return ip
+	}
+
+	addresses := strings.Split(forwarded, ",")
+	for i := len(addresses) - 1; i >= 0; i-- {
+		address := strings.TrimSpace(addresses[i])
+		if address == "" {
+			continue
+		}
+		ip = address
+		if !proxies.trusted(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// ForwardedIP returns the ip of the client that trusted proxies forwarded the
+// connection from remoteAddr for. It returns false when the connection isn't
+// from a trusted proxy, or the proxies forwarded no client that isn't one of
+// them.
+func (proxies *Proxies) ForwardedIP(remoteAddr, forwarded string) (string, bool) {
+	host := remoteAddr
+	if h, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		host = h
+	}
+	ip := proxies.ClientIP(remoteAddr, forwarded)
+	return ip, proxies.trusted(host) && !proxies.trusted(ip)
+}
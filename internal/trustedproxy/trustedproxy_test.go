@@ -0,0 +1,84 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package trustedproxy_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/stargate/internal/trustedproxy"
+)
+
+func TestParse(t *testing.T) {
+	proxies, err := trustedproxy.Parse("")
+	require.NoError(t, err)
+	require.Nil(t, proxies)
+
+	_, err = trustedproxy.Parse("10.0.0.0/8, not-an-ip")
+	require.Error(t, err)
+	_, err = trustedproxy.Parse("10.0.0.0/33")
+	require.Error(t, err)
+
+	proxies, err = trustedproxy.Parse("10.0.0.0/8, 192.0.2.1, ::1")
+	require.NoError(t, err)
+	require.NotNil(t, proxies)
+}
+
+func TestProxies_ClientIP(t *testing.T) {
+	proxies, err := trustedproxy.Parse("10.0.0.0/8, 192.0.2.1")
+	require.NoError(t, err)
+
+	for _, test := range []struct {
+		proxies    *trustedproxy.Proxies
+		remoteAddr string
+		forwarded  string
+		clientIP   string
+	}{
+		// without trusted proxies, the header is ignored
+		{nil, "203.0.113.1:1234", "198.51.100.1", "203.0.113.1"},
+		{nil, "10.0.0.1:1234", "198.51.100.1", "10.0.0.1"},
+		// clients that connect directly can't spoof their ip
+		{proxies, "203.0.113.1:1234", "198.51.100.1", "203.0.113.1"},
+		// the address that a trusted proxy added is the client
+		{proxies, "10.0.0.1:1234", "198.51.100.1", "198.51.100.1"},
+		{proxies, "192.0.2.1:1234", " 198.51.100.1 ", "198.51.100.1"},
+		{proxies, "10.0.0.1:1234", "", "10.0.0.1"},
+		// addresses that the client sent before them are ignored
+		{proxies, "10.0.0.1:1234", "203.0.113.9, 198.51.100.1", "198.51.100.1"},
+		// chains of trusted proxies are followed
+		{proxies, "10.0.0.1:1234", "198.51.100.1, 10.0.0.2", "198.51.100.1"},
+		{proxies, "10.0.0.1:1234", "10.0.0.3, 10.0.0.2", "10.0.0.3"},
+		// remote addresses without ports are used as they are
+		{proxies, "203.0.113.1", "198.51.100.1", "203.0.113.1"},
+	} {
+		require.Equal(t, test.clientIP, test.proxies.ClientIP(test.remoteAddr, test.forwarded), "%+v", test)
+	}
+}
+
+func TestProxies_ForwardedIP(t *testing.T) {
+	proxies, err := trustedproxy.Parse("10.0.0.0/8, 192.0.2.1")
+	require.NoError(t, err)
+
+	for _, test := range []struct {
+		proxies    *trustedproxy.Proxies
+		remoteAddr string
+		forwarded  string
+		clientIP   string
+		ok         bool
+	}{
+		{nil, "203.0.113.1:1234", "198.51.100.1", "", false},
+		{proxies, "203.0.113.1:1234", "198.51.100.1", "", false},
+		{proxies, "10.0.0.1:1234", "", "", false},
+		{proxies, "10.0.0.1:1234", "10.0.0.3, 10.0.0.2", "", false},
+		{proxies, "10.0.0.1:1234", "198.51.100.1", "198.51.100.1", true},
+		{proxies, "10.0.0.1:1234", "198.51.100.1, 10.0.0.2", "198.51.100.1", true},
+	} {
+		clientIP, ok := test.proxies.ForwardedIP(test.remoteAddr, test.forwarded)
+		require.Equal(t, test.ok, ok, "%+v", test)
+		if ok {
+			require.Equal(t, test.clientIP, clientIP, "%+v", test)
+		}
+	}
+}
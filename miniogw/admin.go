@@ -0,0 +1,413 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+
+	"storj.io/stargate/internal/anomaly"
+	"storj.io/stargate/internal/billing"
+	"storj.io/stargate/internal/configdiff"
+	"storj.io/stargate/internal/metaindex"
+	"storj.io/stargate/internal/metrics"
+)
+
+// AdminConfig configures the admin api of the gateway.
+type AdminConfig struct {
+	Address       string        `help:"address to serve the admin api with bucket usage statistics over; disabled when empty" default:""`
+	UsageCacheTTL time.Duration `help:"how long computed bucket usage statistics are cached" default:"5m0s"`
+	Token         string        `help:"bearer token that authorizes reading the running configuration and metrics from the admin api; disabled when empty" default:""`
+}
+
+// dataUsagePath is where minio serves data usage, so that admin clients find
+// it where they expect it.
+const dataUsagePath = "/minio/admin/v3/datausageinfo"
+
+// configPath is where the running configuration is served.
+const configPath = "/v1/config"
+
+// metricsPath is where snapshots of the stable metrics are served.
+const metricsPath = "/v1/metrics"
+
+// searchPath is where the metadata index is searched.
+const searchPath = "/v1/search"
+
+// usageReportsPath is where the usage of the exported billing reports is
+// queried.
+const usageReportsPath = "/v1/usage/reports"
+
+// defaultUsageLimit is how many usages are returned when the request has no
+// limit.
+const defaultUsageLimit = 100
+
+// Admin serves the admin api of the gateway. Requests are authorized by the
+// access grant that they carry, either as a bearer token or as the access key
+// of an S3 signature, and only see the buckets of that access grant. The
+// running configuration and the metrics are only served to requests with the
+// admin token, and so is the usage of every access key.
+type Admin struct {
+	log     *zap.Logger
+	usage   *Usage
+	search  *Search
+	reports *billing.Exporter
+
+	token    string
+	settings map[string]configdiff.Setting
+}
+
+// NewAdmin constructs an Admin that serves usage statistics from usage.
+func NewAdmin(log *zap.Logger, usage *Usage) *Admin {
+	return &Admin{
+		log:   log,
+		usage: usage,
+	}
+}
+
+// SetSettings makes the admin api serve the settings of the running gateway to
+// requests with the bearer token. Settings are not served when token is empty.
+func (admin *Admin) SetSettings(token string, settings map[string]configdiff.Setting) {
+	admin.token = token
+	admin.settings = settings
+}
+
+// SetSearch makes the admin api search the metadata index with search.
+func (admin *Admin) SetSearch(search *Search) {
+	admin.search = search
+}
+
+// SetUsageReports makes the admin api query the usage of the reports that
+// reports exported.
+func (admin *Admin) SetUsageReports(reports *billing.Exporter) {
+	admin.reports = reports
+}
+
+// ServeHTTP implements http.Handler.
+//
+// GET /minio/admin/v3/datausageinfo returns the usage of every bucket, and
+// GET /minio/admin/v3/datausageinfo?bucket=<name> the usage of a single bucket.
+// GET /v1/config returns the settings of the running gateway.
+// GET /v1/metrics returns a snapshot of the metrics of the gateway, and
+// GET /v1/metrics?definitions their definitions.
+// GET /v1/search?bucket=<name>&tag=<key>=<value>&meta=<key>=<value> searches
+// the metadata index for the objects of a bucket with all of the tags and
+// metadata, optionally with prefix, after and limit.
+// GET /v1/usage/reports returns a page of the usage of the exported billing
+// reports, optionally filtered by key (an access key), fingerprint, bucket,
+// operation, since and until, with cursor and limit.
+func (admin *Admin) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	var err error
+	defer mon.Task()(&ctx)(&err)
+
+	switch req.URL.Path {
+	case dataUsagePath, configPath, metricsPath, searchPath, usageReportsPath:
+	default:
+		http.NotFound(w, req)
+		return
+	}
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch req.URL.Path {
+	case configPath:
+		if admin.authorize(w, req) {
+			admin.serveSettings(w)
+		}
+		return
+	case metricsPath:
+		if admin.authorize(w, req) {
+			metrics.Handler(monkit.Default).ServeHTTP(w, req)
+		}
+		return
+	case usageReportsPath:
+		if admin.authorize(w, req) {
+			admin.serveUsageReports(w, req)
+		}
+		return
+	}
+
+	accessKey := requestAccessKey(req)
+	if accessKey == "" {
+		http.Error(w, "missing access key", http.StatusUnauthorized)
+		return
+	}
+
+	if req.URL.Path == searchPath {
+		admin.serveSearch(w, req, accessKey)
+		return
+	}
+
+	var result interface{}
+	if bucket := req.URL.Query().Get("bucket"); bucket != "" {
+		var usage BucketUsage
+		var computed time.Time
+		usage, computed, err = admin.usage.BucketUsage(ctx, accessKey, bucket)
+		result = DataUsage{
+			LastUpdate:       computed,
+			ObjectsCount:     usage.ObjectsCount,
+			ObjectsTotalSize: usage.Size,
+			BucketsCount:     1,
+			BucketsUsage:     map[string]BucketUsage{bucket: usage},
+		}
+	} else {
+		result, err = admin.usage.DataUsage(ctx, accessKey)
+	}
+	if err != nil {
+		admin.writeError(w, err, "unable to compute usage")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		admin.log.Debug("unable to write response", zap.Error(err))
+	}
+}
+
+// serveSearch responds with the objects that a search of the metadata index
+// finds with the access grant.
+func (admin *Admin) serveSearch(w http.ResponseWriter, req *http.Request, accessKey string) {
+	if admin.search == nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	values := req.URL.Query()
+	query := metaindex.Query{
+		Bucket: values.Get("bucket"),
+		Prefix: values.Get("prefix"),
+		After:  values.Get("after"),
+	}
+	if query.Bucket == "" {
+		http.Error(w, "missing bucket", http.StatusBadRequest)
+		return
+	}
+	if limit := values.Get("limit"); limit != "" {
+		var err error
+		if query.Limit, err = strconv.Atoi(limit); err != nil || query.Limit < 1 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	var err error
+	if query.Tags, err = parseSearchPairs(values["tag"]); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if query.Metadata, err = parseSearchPairs(values["meta"]); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := admin.search.Search(req.Context(), accessKey, query)
+	if err != nil {
+		admin.writeError(w, err, "unable to search")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		admin.log.Debug("unable to write response", zap.Error(err))
+	}
+}
+
+// serveUsageReports responds with a page of the usage of the exported billing
+// reports. Pages are compressed for clients that accept gzip, since the usage
+// of many reports is repetitive.
+func (admin *Admin) serveUsageReports(w http.ResponseWriter, req *http.Request) {
+	if admin.reports == nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	values := req.URL.Query()
+	query := billing.Query{
+		Fingerprint: values.Get("fingerprint"),
+		Bucket:      values.Get("bucket"),
+		Operation:   values.Get("operation"),
+		Cursor:      values.Get("cursor"),
+		Limit:       defaultUsageLimit,
+	}
+	if key := values.Get("key"); key != "" {
+		query.Fingerprint = anomaly.Fingerprint(key)
+	}
+	for name, t := range map[string]*time.Time{"since": &query.Since, "until": &query.Until} {
+		if value := values.Get(name); value != "" {
+			var err error
+			if *t, err = time.Parse(time.RFC3339, value); err != nil {
+				http.Error(w, "invalid "+name+": must be like 2006-01-02T15:04:05Z", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	if limit := values.Get("limit"); limit != "" {
+		var err error
+		if query.Limit, err = strconv.Atoi(limit); err != nil {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	page, err := admin.reports.Query(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	admin.writeCompressedJSON(w, req, page)
+}
+
+// writeCompressedJSON responds with v, compressed with gzip if the client
+// accepts it.
+func (admin *Admin) writeCompressedJSON(w http.ResponseWriter, req *http.Request, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(req) {
+		if err := json.NewEncoder(w).Encode(v); err != nil {
+			admin.log.Debug("unable to write response", zap.Error(err))
+		}
+		return
+	}
+
+	w.Header().Set("Content-Encoding", "gzip")
+	compressed := gzip.NewWriter(w)
+	err := json.NewEncoder(compressed).Encode(v)
+	err = errs.Combine(err, compressed.Close())
+	if err != nil {
+		admin.log.Debug("unable to write response", zap.Error(err))
+	}
+}
+
+// acceptsGzip returns whether the Accept-Encoding of the request has gzip.
+func acceptsGzip(req *http.Request) bool {
+	for _, encoding := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		encoding = strings.TrimSpace(strings.Split(encoding, ";")[0])
+		if encoding == "gzip" || encoding == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSearchPairs parses key=value pairs of a search.
+func parseSearchPairs(pairs []string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	parsed := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		eq := strings.IndexByte(pair, '=')
+		if eq <= 0 {
+			return nil, errs.New("invalid search pair %q: expected key=value", pair)
+		}
+		parsed[pair[:eq]] = pair[eq+1:]
+	}
+	return parsed, nil
+}
+
+// authorize returns whether the request has the admin token, and responds
+// otherwise.
+func (admin *Admin) authorize(w http.ResponseWriter, req *http.Request) bool {
+	if admin.token == "" {
+		http.NotFound(w, req)
+		return false
+	}
+	header := req.Header.Get("Authorization")
+	if subtle.ConstantTimeCompare([]byte(header), []byte("Bearer "+admin.token)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// serveSettings responds with the settings of the running gateway.
+func (admin *Admin) serveSettings(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(struct {
+		Settings map[string]configdiff.Setting `json:"settings"`
+	}{admin.settings})
+	if err != nil {
+		admin.log.Debug("unable to write response", zap.Error(err))
+	}
+}
+
+// writeError responds with the status that matches err, or with message if
+// the error is unexpected.
+func (admin *Admin) writeError(w http.ResponseWriter, err error, message string) {
+	var notFound minio.BucketNotFound
+	var invalid minio.BucketNameInvalid
+	switch {
+	case errors.As(err, &notFound), errors.As(err, &invalid):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, context.Canceled):
+		http.Error(w, "request canceled", http.StatusRequestTimeout)
+	default:
+		admin.log.Error(message, zap.Error(err))
+		http.Error(w, message, http.StatusInternalServerError)
+	}
+}
+
+// requestAccessKey returns the access grant of a request, which is either the
+// bearer token or the access key of an AWS signature version 4.
+func requestAccessKey(req *http.Request) string {
+	header := req.Header.Get("Authorization")
+	if token := strings.TrimPrefix(header, "Bearer "); token != header {
+		return strings.TrimSpace(token)
+	}
+
+	const credential = "Credential="
+	i := strings.Index(header, credential)
+	if !strings.HasPrefix(header, "AWS4-HMAC-SHA256") || i < 0 {
+		return ""
+	}
+	accessKey := header[i+len(credential):]
+	if end := strings.IndexByte(accessKey, '/'); end >= 0 {
+		return accessKey[:end]
+	}
+	return ""
+}
+
+// ServeAdmin serves the admin api on address until ctx is canceled.
+func ServeAdmin(ctx context.Context, log *zap.Logger, address string, admin *Admin) (err error) {
+	return serve(ctx, log, "admin api", address, admin)
+}
+
+// ServeHealth serves the health checks on address until ctx is canceled.
+func ServeHealth(ctx context.Context, log *zap.Logger, address string, health *Health) (err error) {
+	return serve(ctx, log, "health checks", address, health)
+}
+
+// serve serves handler on address until ctx is canceled.
+func serve(ctx context.Context, log *zap.Logger, name, address string, handler http.Handler) (err error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return errs.Wrap(err)
+	}
+
+	server := &http.Server{Handler: handler}
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+
+	log.Info("serving "+name, zap.Stringer("address", listener.Addr()))
+	err = server.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return errs.Wrap(err)
+}
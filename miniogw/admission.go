@@ -0,0 +1,212 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/pkg/auth"
+
+	"storj.io/stargate/internal/admission"
+	"storj.io/stargate/internal/anomaly"
+)
+
+type gatewayAdmission struct {
+	minio.Gateway
+	queue *admission.Queue
+}
+
+// Admission returns a wrapper of minio.Gateway that only serves as many
+// requests at once as queue admits. Requests wait their turn fairly across
+// access keys, which are the tenants of queue by their fingerprints. Requests
+// that aren't admitted fail with a 503 so that clients slow down. Downloads
+// keep their slot until their reader is closed.
+func Admission(gateway minio.Gateway, queue *admission.Queue) minio.Gateway {
+	if queue == nil {
+		return gateway
+	}
+	return &gatewayAdmission{Gateway: gateway, queue: queue}
+}
+
+func (gateway *gatewayAdmission) NewGatewayLayer(creds auth.Credentials) (minio.ObjectLayer, error) {
+	layer, err := gateway.Gateway.NewGatewayLayer(creds)
+	return &layerAdmission{ObjectLayer: layer, queue: gateway.queue}, err
+}
+
+// layerAdmission admits the requests of the embedded layer.
+type layerAdmission struct {
+	minio.ObjectLayer
+	queue *admission.Queue
+}
+
+// acquire waits until the request of ctx is admitted. The returned func must
+// be called when the request is done.
+func (layer *layerAdmission) acquire(ctx context.Context) (release func(), err error) {
+	release, err = layer.queue.Acquire(ctx, anomaly.Fingerprint(getAccessKey(ctx)))
+	if admission.Error.Has(err) {
+		return nil, minio.OperationTimedOut{}
+	}
+	return release, err
+}
+
+func (layer *layerAdmission) DeleteBucket(ctx context.Context, bucket string, forceDelete bool) error {
+	release, err := layer.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return layer.ObjectLayer.DeleteBucket(ctx, bucket, forceDelete)
+}
+
+func (layer *layerAdmission) DeleteObject(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	release, err := layer.acquire(ctx)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	defer release()
+	return layer.ObjectLayer.DeleteObject(ctx, bucket, object, opts)
+}
+
+func (layer *layerAdmission) DeleteObjects(ctx context.Context, bucket string, objects []minio.ObjectToDelete, opts minio.ObjectOptions) ([]minio.DeletedObject, []error) {
+	release, err := layer.acquire(ctx)
+	if err != nil {
+		errs := make([]error, len(objects))
+		for i := range errs {
+			errs[i] = err
+		}
+		return make([]minio.DeletedObject, len(objects)), errs
+	}
+	defer release()
+	return layer.ObjectLayer.DeleteObjects(ctx, bucket, objects, opts)
+}
+
+func (layer *layerAdmission) GetBucketInfo(ctx context.Context, bucket string) (minio.BucketInfo, error) {
+	release, err := layer.acquire(ctx)
+	if err != nil {
+		return minio.BucketInfo{}, err
+	}
+	defer release()
+	return layer.ObjectLayer.GetBucketInfo(ctx, bucket)
+}
+
+// GetObjectNInfo keeps the slot of the download until its reader is closed,
+// since the object data is read after it returns.
+func (layer *layerAdmission) GetObjectNInfo(ctx context.Context, bucket, object string, rangeSpec *minio.HTTPRangeSpec, header http.Header, lockType minio.LockType, opts minio.ObjectOptions) (*minio.GetObjectReader, error) {
+	release, err := layer.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := layer.ObjectLayer.GetObjectNInfo(ctx, bucket, object, rangeSpec, header, lockType, opts)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	// the preconditions of opts were checked by the embedded layer
+	return minio.NewGetObjectReaderFromReader(reader, reader.ObjInfo, minio.ObjectOptions{}, func() {
+		_ = reader.Close()
+		release()
+	})
+}
+
+func (layer *layerAdmission) GetObject(ctx context.Context, bucket, object string, startOffset int64, length int64, writer io.Writer, etag string, opts minio.ObjectOptions) error {
+	release, err := layer.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return layer.ObjectLayer.GetObject(ctx, bucket, object, startOffset, length, writer, etag, opts)
+}
+
+func (layer *layerAdmission) GetObjectInfo(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	release, err := layer.acquire(ctx)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	defer release()
+	return layer.ObjectLayer.GetObjectInfo(ctx, bucket, object, opts)
+}
+
+func (layer *layerAdmission) ListBuckets(ctx context.Context) ([]minio.BucketInfo, error) {
+	release, err := layer.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return layer.ObjectLayer.ListBuckets(ctx)
+}
+
+func (layer *layerAdmission) ListObjects(ctx context.Context, bucket, prefix, marker, delimiter string, maxKeys int) (minio.ListObjectsInfo, error) {
+	release, err := layer.acquire(ctx)
+	if err != nil {
+		return minio.ListObjectsInfo{}, err
+	}
+	defer release()
+	return layer.ObjectLayer.ListObjects(ctx, bucket, prefix, marker, delimiter, maxKeys)
+}
+
+func (layer *layerAdmission) ListObjectsV2(ctx context.Context, bucket, prefix, continuationToken, delimiter string, maxKeys int, fetchOwner bool, startAfter string) (minio.ListObjectsV2Info, error) {
+	release, err := layer.acquire(ctx)
+	if err != nil {
+		return minio.ListObjectsV2Info{}, err
+	}
+	defer release()
+	return layer.ObjectLayer.ListObjectsV2(ctx, bucket, prefix, continuationToken, delimiter, maxKeys, fetchOwner, startAfter)
+}
+
+func (layer *layerAdmission) MakeBucketWithLocation(ctx context.Context, bucket string, opts minio.BucketOptions) error {
+	release, err := layer.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return layer.ObjectLayer.MakeBucketWithLocation(ctx, bucket, opts)
+}
+
+func (layer *layerAdmission) PutObject(ctx context.Context, bucket, object string, data *minio.PutObjReader, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	release, err := layer.acquire(ctx)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	defer release()
+	return layer.ObjectLayer.PutObject(ctx, bucket, object, data, opts)
+}
+
+func (layer *layerAdmission) CopyObject(ctx context.Context, srcBucket, srcObject, destBucket, destObject string, srcInfo minio.ObjectInfo, srcOpts, destOpts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	release, err := layer.acquire(ctx)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	defer release()
+	return layer.ObjectLayer.CopyObject(ctx, srcBucket, srcObject, destBucket, destObject, srcInfo, srcOpts, destOpts)
+}
+
+func (layer *layerAdmission) NewMultipartUpload(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (string, error) {
+	release, err := layer.acquire(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	return layer.ObjectLayer.NewMultipartUpload(ctx, bucket, object, opts)
+}
+
+func (layer *layerAdmission) PutObjectPart(ctx context.Context, bucket, object, uploadID string, partID int, data *minio.PutObjReader, opts minio.ObjectOptions) (minio.PartInfo, error) {
+	release, err := layer.acquire(ctx)
+	if err != nil {
+		return minio.PartInfo{}, err
+	}
+	defer release()
+	return layer.ObjectLayer.PutObjectPart(ctx, bucket, object, uploadID, partID, data, opts)
+}
+
+func (layer *layerAdmission) CompleteMultipartUpload(ctx context.Context, bucket, object, uploadID string, uploadedParts []minio.CompletePart, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	release, err := layer.acquire(ctx)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	defer release()
+	return layer.ObjectLayer.CompleteMultipartUpload(ctx, bucket, object, uploadID, uploadedParts, opts)
+}
@@ -0,0 +1,427 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/pkg/auth"
+	"github.com/minio/minio/pkg/bucket/policy"
+	"github.com/zeebo/errs"
+)
+
+// AliasError is the class of errors for invalid bucket aliases.
+var AliasError = errs.Class("bucket alias")
+
+// BucketsConfig configures how clients see buckets.
+type BucketsConfig struct {
+	Aliases string `help:"comma separated bucket aliases, like alias=bucket or alias=bucket/prefix; clients use an alias like a bucket, and its objects are stored in the bucket under the prefix" default:""`
+
+	Immutable       string `help:"comma separated buckets in which objects can be uploaded but not overwritten or deleted, like for tamper-evident logs" default:""`
+	ImmutableAdmins string `help:"comma separated access keys that can still overwrite and delete objects in immutable buckets" default:""`
+
+	Deduplicate bool `help:"skip uploads with a Content-MD5 when the object at the key already has that etag, size and metadata, so that unchanged files aren't stored again" default:"false"`
+}
+
+// Alias is the bucket, and the prefix in that bucket, that a client-visible
+// bucket name maps to.
+type Alias struct {
+	Bucket string
+	Prefix string
+}
+
+// ParseAliases parses comma separated aliases of the form alias=bucket or
+// alias=bucket/prefix.
+func ParseAliases(s string) (map[string]Alias, error) {
+	aliases := make(map[string]Alias)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, AliasError.New("%q is not of the form alias=bucket[/prefix]", entry)
+		}
+		name := strings.TrimSpace(parts[0])
+		if name == "" || strings.Contains(name, "/") {
+			return nil, AliasError.New("invalid alias name %q", name)
+		}
+		if _, ok := aliases[name]; ok {
+			return nil, AliasError.New("alias %q is defined more than once", name)
+		}
+
+		var alias Alias
+		target := strings.SplitN(strings.TrimSpace(parts[1]), "/", 2)
+		alias.Bucket = target[0]
+		if len(target) == 2 {
+			alias.Prefix = strings.TrimSuffix(target[1], "/")
+			if alias.Prefix != "" {
+				alias.Prefix += "/"
+			}
+		}
+		if alias.Bucket == "" {
+			return nil, AliasError.New("alias %q has no bucket", name)
+		}
+		if alias.Bucket == name && alias.Prefix == "" {
+			return nil, AliasError.New("alias %q maps to itself", name)
+		}
+
+		aliases[name] = alias
+	}
+	return aliases, nil
+}
+
+type gatewayAliasing struct {
+	minio.Gateway
+	aliases map[string]Alias
+}
+
+// Aliasing returns a wrapper of minio.Gateway that serves the aliases as
+// buckets, so that buckets can be renamed or moved into a prefix of another
+// bucket without changing the configuration of clients.
+func Aliasing(gateway minio.Gateway, aliases map[string]Alias) minio.Gateway {
+	if len(aliases) == 0 {
+		return gateway
+	}
+	return &gatewayAliasing{Gateway: gateway, aliases: aliases}
+}
+
+func (gateway *gatewayAliasing) NewGatewayLayer(creds auth.Credentials) (minio.ObjectLayer, error) {
+	layer, err := gateway.Gateway.NewGatewayLayer(creds)
+	return &layerAliasing{ObjectLayer: layer, aliases: gateway.aliases}, err
+}
+
+// layerAliasing maps aliases to their buckets. Methods that don't take a
+// bucket are served by the embedded layer.
+type layerAliasing struct {
+	minio.ObjectLayer
+	aliases map[string]Alias
+}
+
+// aliasedBucket is a client-visible bucket name and the alias it maps to.
+// Buckets without an alias map to themselves.
+type aliasedBucket struct {
+	name string
+	Alias
+}
+
+func (layer *layerAliasing) resolve(bucket string) aliasedBucket {
+	if alias, ok := layer.aliases[bucket]; ok {
+		return aliasedBucket{name: bucket, Alias: alias}
+	}
+	return aliasedBucket{name: bucket, Alias: Alias{Bucket: bucket}}
+}
+
+func (bucket aliasedBucket) aliased() bool {
+	return bucket.name != bucket.Bucket || bucket.Prefix != ""
+}
+
+// key returns the key in the bucket of a client-visible object key.
+func (bucket aliasedBucket) key(object string) string {
+	if object == "" {
+		return object
+	}
+	return bucket.Prefix + object
+}
+
+// object returns the client-visible object key of a key in the bucket.
+func (bucket aliasedBucket) object(key string) string {
+	return strings.TrimPrefix(key, bucket.Prefix)
+}
+
+func (bucket aliasedBucket) objects(keys []string) []string {
+	if bucket.Prefix == "" {
+		return keys
+	}
+	objects := make([]string, len(keys))
+	for i, key := range keys {
+		objects[i] = bucket.object(key)
+	}
+	return objects
+}
+
+func (bucket aliasedBucket) info(info minio.ObjectInfo) minio.ObjectInfo {
+	if info.Bucket == bucket.Bucket {
+		info.Bucket = bucket.name
+		info.Name = bucket.object(info.Name)
+	}
+	return info
+}
+
+func (bucket aliasedBucket) infos(infos []minio.ObjectInfo) []minio.ObjectInfo {
+	for i := range infos {
+		infos[i] = bucket.info(infos[i])
+	}
+	return infos
+}
+
+// error replaces the bucket and key of minio errors with the client-visible
+// ones, so that aliases don't leak in responses.
+func (bucket aliasedBucket) error(err error) error {
+	if !bucket.aliased() {
+		return err
+	}
+	switch e := err.(type) {
+	case minio.BucketNotFound:
+		e.Bucket = bucket.name
+		return e
+	case minio.BucketNameInvalid:
+		e.Bucket = bucket.name
+		return e
+	case minio.BucketNotEmpty:
+		e.Bucket = bucket.name
+		return e
+	case minio.ObjectNotFound:
+		e.Bucket, e.Object = bucket.name, bucket.object(e.Object)
+		return e
+	case minio.ObjectNameInvalid:
+		e.Bucket, e.Object = bucket.name, bucket.object(e.Object)
+		return e
+	}
+	return err
+}
+
+func (layer *layerAliasing) MakeBucketWithLocation(ctx context.Context, bucket string, opts minio.BucketOptions) error {
+	if _, ok := layer.aliases[bucket]; ok {
+		return minio.BucketAlreadyOwnedByYou{Bucket: bucket}
+	}
+	return layer.ObjectLayer.MakeBucketWithLocation(ctx, bucket, opts)
+}
+
+func (layer *layerAliasing) GetBucketInfo(ctx context.Context, bucket string) (bucketInfo minio.BucketInfo, err error) {
+	target := layer.resolve(bucket)
+	bucketInfo, err = layer.ObjectLayer.GetBucketInfo(ctx, target.Bucket)
+	if err != nil {
+		return bucketInfo, target.error(err)
+	}
+	bucketInfo.Name = bucket
+	return bucketInfo, nil
+}
+
+func (layer *layerAliasing) ListBuckets(ctx context.Context) (buckets []minio.BucketInfo, err error) {
+	buckets, err = layer.ObjectLayer.ListBuckets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// only list the aliases of buckets that exist in the project
+	byName := make(map[string]minio.BucketInfo, len(buckets))
+	for _, bucket := range buckets {
+		byName[bucket.Name] = bucket
+	}
+	for name, alias := range layer.aliases {
+		target, ok := byName[alias.Bucket]
+		if !ok {
+			continue
+		}
+		if _, ok := byName[name]; ok {
+			continue
+		}
+		buckets = append(buckets, minio.BucketInfo{Name: name, Created: target.Created})
+	}
+	sort.Slice(buckets, func(i, k int) bool { return buckets[i].Name < buckets[k].Name })
+	return buckets, nil
+}
+
+func (layer *layerAliasing) DeleteBucket(ctx context.Context, bucket string, forceDelete bool) error {
+	if _, ok := layer.aliases[bucket]; ok {
+		// the bucket of an alias is managed by the operator
+		return minio.PrefixAccessDenied{Bucket: bucket}
+	}
+	return layer.ObjectLayer.DeleteBucket(ctx, bucket, forceDelete)
+}
+
+func (layer *layerAliasing) ListObjects(ctx context.Context, bucket, prefix, marker, delimiter string, maxKeys int) (result minio.ListObjectsInfo, err error) {
+	target := layer.resolve(bucket)
+	result, err = layer.ObjectLayer.ListObjects(ctx, target.Bucket, target.Prefix+prefix, target.key(marker), delimiter, maxKeys)
+	if err != nil {
+		return result, target.error(err)
+	}
+	if result.NextMarker != "" {
+		result.NextMarker = target.object(result.NextMarker)
+	}
+	result.Objects = target.infos(result.Objects)
+	result.Prefixes = target.objects(result.Prefixes)
+	return result, nil
+}
+
+func (layer *layerAliasing) ListObjectsV2(ctx context.Context, bucket, prefix, continuationToken, delimiter string, maxKeys int, fetchOwner bool, startAfter string) (result minio.ListObjectsV2Info, err error) {
+	target := layer.resolve(bucket)
+	result, err = layer.ObjectLayer.ListObjectsV2(ctx, target.Bucket, target.Prefix+prefix,
+		target.key(continuationToken), delimiter, maxKeys, fetchOwner, target.key(startAfter))
+	if err != nil {
+		result.ContinuationToken = continuationToken
+		return result, target.error(err)
+	}
+	if result.ContinuationToken != "" {
+		result.ContinuationToken = target.object(result.ContinuationToken)
+	}
+	if result.NextContinuationToken != "" {
+		result.NextContinuationToken = target.object(result.NextContinuationToken)
+	}
+	result.Objects = target.infos(result.Objects)
+	result.Prefixes = target.objects(result.Prefixes)
+	return result, nil
+}
+
+func (layer *layerAliasing) GetObjectNInfo(ctx context.Context, bucket, object string, rs *minio.HTTPRangeSpec, h http.Header, lockType minio.LockType, opts minio.ObjectOptions) (reader *minio.GetObjectReader, err error) {
+	target := layer.resolve(bucket)
+	reader, err = layer.ObjectLayer.GetObjectNInfo(ctx, target.Bucket, target.key(object), rs, h, lockType, opts)
+	if err != nil {
+		return reader, target.error(err)
+	}
+	reader.ObjInfo = target.info(reader.ObjInfo)
+	return reader, nil
+}
+
+func (layer *layerAliasing) GetObject(ctx context.Context, bucket, object string, startOffset int64, length int64, writer io.Writer, etag string, opts minio.ObjectOptions) error {
+	target := layer.resolve(bucket)
+	return target.error(layer.ObjectLayer.GetObject(ctx, target.Bucket, target.key(object), startOffset, length, writer, etag, opts))
+}
+
+func (layer *layerAliasing) GetObjectInfo(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
+	target := layer.resolve(bucket)
+	objInfo, err = layer.ObjectLayer.GetObjectInfo(ctx, target.Bucket, target.key(object), opts)
+	return target.info(objInfo), target.error(err)
+}
+
+func (layer *layerAliasing) PutObject(ctx context.Context, bucket, object string, data *minio.PutObjReader, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
+	target := layer.resolve(bucket)
+	objInfo, err = layer.ObjectLayer.PutObject(ctx, target.Bucket, target.key(object), data, opts)
+	return target.info(objInfo), target.error(err)
+}
+
+func (layer *layerAliasing) CopyObject(ctx context.Context, srcBucket, srcObject, destBucket, destObject string, srcInfo minio.ObjectInfo, srcOpts, destOpts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
+	src, dest := layer.resolve(srcBucket), layer.resolve(destBucket)
+	srcInfo.Bucket, srcInfo.Name = src.Bucket, src.key(srcInfo.Name)
+	objInfo, err = layer.ObjectLayer.CopyObject(ctx, src.Bucket, src.key(srcObject), dest.Bucket, dest.key(destObject), srcInfo, srcOpts, destOpts)
+	if err != nil {
+		// errors about the object that is copied are the most likely
+		return objInfo, src.error(err)
+	}
+	return dest.info(objInfo), nil
+}
+
+func (layer *layerAliasing) DeleteObject(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
+	target := layer.resolve(bucket)
+	objInfo, err = layer.ObjectLayer.DeleteObject(ctx, target.Bucket, target.key(object), opts)
+	return target.info(objInfo), target.error(err)
+}
+
+func (layer *layerAliasing) DeleteObjects(ctx context.Context, bucket string, objects []minio.ObjectToDelete, opts minio.ObjectOptions) (deleted []minio.DeletedObject, errors []error) {
+	target := layer.resolve(bucket)
+	keys := make([]minio.ObjectToDelete, len(objects))
+	for i, object := range objects {
+		keys[i] = object
+		keys[i].ObjectName = target.key(object.ObjectName)
+	}
+	deleted, errors = layer.ObjectLayer.DeleteObjects(ctx, target.Bucket, keys, opts)
+	for i := range deleted {
+		if deleted[i].ObjectName != "" {
+			deleted[i].ObjectName = target.object(deleted[i].ObjectName)
+		}
+	}
+	for i := range errors {
+		errors[i] = target.error(errors[i])
+	}
+	return deleted, errors
+}
+
+func (layer *layerAliasing) ListMultipartUploads(ctx context.Context, bucket, prefix, keyMarker, uploadIDMarker, delimiter string, maxUploads int) (result minio.ListMultipartsInfo, err error) {
+	target := layer.resolve(bucket)
+	result, err = layer.ObjectLayer.ListMultipartUploads(ctx, target.Bucket, target.Prefix+prefix, target.key(keyMarker), uploadIDMarker, delimiter, maxUploads)
+	if err != nil {
+		return result, target.error(err)
+	}
+	result.Prefix = prefix
+	result.KeyMarker = keyMarker
+	if result.NextKeyMarker != "" {
+		result.NextKeyMarker = target.object(result.NextKeyMarker)
+	}
+	for i := range result.Uploads {
+		result.Uploads[i].Bucket = bucket
+		result.Uploads[i].Object = target.object(result.Uploads[i].Object)
+	}
+	result.CommonPrefixes = target.objects(result.CommonPrefixes)
+	return result, nil
+}
+
+func (layer *layerAliasing) NewMultipartUpload(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (uploadID string, err error) {
+	target := layer.resolve(bucket)
+	uploadID, err = layer.ObjectLayer.NewMultipartUpload(ctx, target.Bucket, target.key(object), opts)
+	return uploadID, target.error(err)
+}
+
+func (layer *layerAliasing) CopyObjectPart(ctx context.Context, srcBucket, srcObject, destBucket, destObject string, uploadID string, partID int, startOffset int64, length int64, srcInfo minio.ObjectInfo, srcOpts, destOpts minio.ObjectOptions) (info minio.PartInfo, err error) {
+	src, dest := layer.resolve(srcBucket), layer.resolve(destBucket)
+	srcInfo.Bucket, srcInfo.Name = src.Bucket, src.key(srcInfo.Name)
+	info, err = layer.ObjectLayer.CopyObjectPart(ctx, src.Bucket, src.key(srcObject), dest.Bucket, dest.key(destObject), uploadID, partID, startOffset, length, srcInfo, srcOpts, destOpts)
+	return info, src.error(err)
+}
+
+func (layer *layerAliasing) PutObjectPart(ctx context.Context, bucket, object, uploadID string, partID int, data *minio.PutObjReader, opts minio.ObjectOptions) (info minio.PartInfo, err error) {
+	target := layer.resolve(bucket)
+	info, err = layer.ObjectLayer.PutObjectPart(ctx, target.Bucket, target.key(object), uploadID, partID, data, opts)
+	return info, target.error(err)
+}
+
+func (layer *layerAliasing) GetMultipartInfo(ctx context.Context, bucket string, object string, uploadID string, opts minio.ObjectOptions) (info minio.MultipartInfo, err error) {
+	target := layer.resolve(bucket)
+	info, err = layer.ObjectLayer.GetMultipartInfo(ctx, target.Bucket, target.key(object), uploadID, opts)
+	if err != nil {
+		return info, target.error(err)
+	}
+	info.Bucket, info.Object = bucket, object
+	return info, nil
+}
+
+func (layer *layerAliasing) ListObjectParts(ctx context.Context, bucket, object, uploadID string, partNumberMarker int, maxParts int, opts minio.ObjectOptions) (result minio.ListPartsInfo, err error) {
+	target := layer.resolve(bucket)
+	result, err = layer.ObjectLayer.ListObjectParts(ctx, target.Bucket, target.key(object), uploadID, partNumberMarker, maxParts, opts)
+	if err != nil {
+		return result, target.error(err)
+	}
+	result.Bucket, result.Object = bucket, object
+	return result, nil
+}
+
+func (layer *layerAliasing) AbortMultipartUpload(ctx context.Context, bucket, object, uploadID string, opts minio.ObjectOptions) error {
+	target := layer.resolve(bucket)
+	return target.error(layer.ObjectLayer.AbortMultipartUpload(ctx, target.Bucket, target.key(object), uploadID, opts))
+}
+
+func (layer *layerAliasing) CompleteMultipartUpload(ctx context.Context, bucket, object, uploadID string, uploadedParts []minio.CompletePart, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
+	target := layer.resolve(bucket)
+	objInfo, err = layer.ObjectLayer.CompleteMultipartUpload(ctx, target.Bucket, target.key(object), uploadID, uploadedParts, opts)
+	return target.info(objInfo), target.error(err)
+}
+
+func (layer *layerAliasing) SetBucketPolicy(ctx context.Context, bucket string, p *policy.Policy) error {
+	if _, ok := layer.aliases[bucket]; ok {
+		// the policy would apply to the whole bucket of the alias
+		return minio.PrefixAccessDenied{Bucket: bucket}
+	}
+	return layer.ObjectLayer.SetBucketPolicy(ctx, bucket, p)
+}
+
+func (layer *layerAliasing) GetBucketPolicy(ctx context.Context, bucket string) (*policy.Policy, error) {
+	if _, ok := layer.aliases[bucket]; ok {
+		return nil, minio.BucketPolicyNotFound{Bucket: bucket}
+	}
+	return layer.ObjectLayer.GetBucketPolicy(ctx, bucket)
+}
+
+func (layer *layerAliasing) DeleteBucketPolicy(ctx context.Context, bucket string) error {
+	if _, ok := layer.aliases[bucket]; ok {
+		return minio.PrefixAccessDenied{Bucket: bucket}
+	}
+	return layer.ObjectLayer.DeleteBucketPolicy(ctx, bucket)
+}
@@ -0,0 +1,210 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"storj.io/stargate/internal/anomaly"
+	"storj.io/stargate/internal/plugin"
+)
+
+// DegradedConfig configures the degraded mode of the gateway, in which access
+// grants that the auth resolver plugins resolved before are served from cache
+// while the plugins are unavailable, like when the auth service they ask is
+// unreachable.
+type DegradedConfig struct {
+	MaxStaleness time.Duration `help:"how long after they were resolved access grants may be served from cache while the auth resolver plugins are unavailable; degraded mode is disabled when 0" default:"0s"`
+	MaxEntries   int           `help:"how many resolved access grants are cached for degraded mode" default:"100000"`
+}
+
+// AuthHealth is the state of the resolution of access keys.
+type AuthHealth struct {
+	Available    bool       `json:"available"`
+	Degraded     bool       `json:"degraded"`
+	FailingSince *time.Time `json:"failing_since,omitempty"`
+	Error        string     `json:"error,omitempty"`
+	ServedStale  int64      `json:"served_stale,omitempty"`
+}
+
+// cachedGrant is an access grant that an access key was resolved to.
+type cachedGrant struct {
+	accessGrant string
+	resolvedAt  time.Time
+}
+
+// ResolveFunc resolves an access key to an access grant, like the auth
+// resolver plugins do.
+type ResolveFunc func(ctx context.Context, request plugin.ResolveRequest) (accessGrant string, err error)
+
+// Credentials resolves access keys with the auth resolver plugins and, in
+// degraded mode, serves the access grants that they resolved before while
+// they are unavailable. Access keys that they deny, like revoked ones, are
+// never served from cache. Since access keys that are revoked during an
+// outage keep working from cache, a stale access grant is only served for up
+// to the max staleness after it was resolved.
+type Credentials struct {
+	log     *zap.Logger
+	config  DegradedConfig
+	resolve ResolveFunc
+
+	mu           sync.Mutex
+	cache        map[plugin.ResolveRequest]cachedGrant
+	failingSince time.Time
+	failed       plugin.ResolveRequest
+	lastErr      error
+	servedStale  int64
+}
+
+// NewCredentials constructs Credentials that resolve access keys with resolve,
+// with the degraded mode of config.
+func NewCredentials(log *zap.Logger, config DegradedConfig, resolve ResolveFunc) *Credentials {
+	return &Credentials{
+		log:     log,
+		config:  config,
+		resolve: resolve,
+		cache:   make(map[plugin.ResolveRequest]cachedGrant),
+	}
+}
+
+// Resolve returns the access grant that the request resolves to. If the
+// resolvers are unavailable, the access grant that the request resolved to
+// before is returned instead, unless it is older than the max staleness.
+func (credentials *Credentials) Resolve(ctx context.Context, request plugin.ResolveRequest) (_ string, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	accessGrant, err := credentials.resolve(ctx, request)
+	now := time.Now()
+
+	credentials.mu.Lock()
+	defer credentials.mu.Unlock()
+
+	credentials.record(request, accessGrant, err, now)
+	if err == nil {
+		return accessGrant, nil
+	}
+	if !plugin.Unavailable.Has(err) {
+		return "", err
+	}
+
+	cached, ok := credentials.cache[request]
+	if !ok || now.Sub(cached.resolvedAt) > credentials.config.MaxStaleness {
+		return "", err
+	}
+
+	mon.Event("auth_degraded_served")
+	credentials.servedStale++
+	credentials.log.Debug("serving cached access grant in degraded mode",
+		zap.String("access key", anomaly.Fingerprint(request.AccessKey)),
+		zap.Duration("staleness", now.Sub(cached.resolvedAt)))
+	return cached.accessGrant, nil
+}
+
+// Check resolves the last request that failed to resolve again while the
+// resolution fails, so that the gateway notices that it recovered even if it
+// doesn't get requests because it isn't ready. It is a no-op on nil
+// Credentials.
+func (credentials *Credentials) Check(ctx context.Context) {
+	if credentials == nil {
+		return
+	}
+
+	credentials.mu.Lock()
+	failing, request := !credentials.failingSince.IsZero(), credentials.failed
+	credentials.mu.Unlock()
+	if !failing {
+		return
+	}
+
+	accessGrant, err := credentials.resolve(ctx, request)
+
+	credentials.mu.Lock()
+	defer credentials.mu.Unlock()
+	credentials.record(request, accessGrant, err, time.Now())
+}
+
+// record records the result of resolving the request. Resolvers that deny the
+// request work, so only unavailable ones are failing. It must be called with
+// the mutex held.
+func (credentials *Credentials) record(request plugin.ResolveRequest, accessGrant string, err error, now time.Time) {
+	if plugin.Unavailable.Has(err) {
+		if credentials.failingSince.IsZero() {
+			credentials.failingSince = now
+			credentials.log.Warn("auth resolvers failing", zap.Bool("degraded mode", credentials.config.MaxStaleness > 0), zap.Error(err))
+		}
+		credentials.failed, credentials.lastErr = request, err
+		return
+	}
+
+	if !credentials.failingSince.IsZero() {
+		credentials.log.Info("auth resolvers recovered",
+			zap.Duration("failed for", now.Sub(credentials.failingSince)),
+			zap.Int64("served stale", credentials.servedStale))
+	}
+	credentials.failingSince, credentials.lastErr, credentials.servedStale = time.Time{}, nil, 0
+	if err != nil {
+		// the access key was denied, like because it was revoked, so its
+		// access grant must not be served from cache anymore
+		delete(credentials.cache, request)
+		return
+	}
+	credentials.store(request, accessGrant, now)
+}
+
+// store caches the access grant of the request. Expired entries make room
+// when the cache is full, and an arbitrary entry if none has expired.
+func (credentials *Credentials) store(request plugin.ResolveRequest, accessGrant string, now time.Time) {
+	if credentials.config.MaxStaleness <= 0 || credentials.config.MaxEntries <= 0 {
+		return
+	}
+
+	if _, ok := credentials.cache[request]; !ok && len(credentials.cache) >= credentials.config.MaxEntries {
+		for cachedRequest, cached := range credentials.cache {
+			if now.Sub(cached.resolvedAt) > credentials.config.MaxStaleness {
+				delete(credentials.cache, cachedRequest)
+			}
+		}
+		for cachedRequest := range credentials.cache {
+			if len(credentials.cache) < credentials.config.MaxEntries {
+				break
+			}
+			delete(credentials.cache, cachedRequest)
+		}
+	}
+	credentials.cache[request] = cachedGrant{accessGrant: accessGrant, resolvedAt: now}
+}
+
+// Health returns the state of the resolution of access keys. Access keys are
+// available unless the last resolution failed, and resolution is degraded
+// while it fails for less than the max staleness, since cached access grants
+// can be served for that long. Nil Credentials are always available.
+func (credentials *Credentials) Health() AuthHealth {
+	if credentials == nil {
+		return AuthHealth{Available: true}
+	}
+
+	credentials.mu.Lock()
+	defer credentials.mu.Unlock()
+
+	if credentials.failingSince.IsZero() {
+		return AuthHealth{Available: true}
+	}
+	failingSince := credentials.failingSince
+	return AuthHealth{
+		Degraded:     time.Since(failingSince) <= credentials.config.MaxStaleness,
+		FailingSince: &failingSince,
+		Error:        credentials.lastErr.Error(),
+		ServedStale:  credentials.servedStale,
+	}
+}
+
+// ready returns whether requests can be served according to the resolution of
+// access keys, which is when it is available or degraded.
+func (health AuthHealth) ready() bool {
+	return health.Available || health.Degraded
+}
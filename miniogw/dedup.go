@@ -0,0 +1,87 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"strings"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/pkg/auth"
+)
+
+type gatewayDeduplicating struct {
+	minio.Gateway
+	enabled  bool
+	features *Features
+}
+
+// Deduplicating returns a wrapper of minio.Gateway that skips the uploads of
+// objects that are the same as the objects that they would overwrite, like
+// when backup tools upload unchanged files again. An upload is the same when
+// the client sent its Content-MD5, and the object at the key has that etag,
+// the same size and the same metadata. Then the body of the upload isn't
+// stored, and the existing object is returned instead.
+//
+// The Content-MD5 of the client is trusted without reading the body, since
+// reading it would cost the bandwidth that skipping the upload saves. Objects
+// with changed metadata are uploaded again, since their metadata can't be
+// updated alone.
+//
+// When enabled is false, only the uploads that the feature flags enable
+// FeatureDedup for are deduplicated.
+func Deduplicating(gateway minio.Gateway, enabled bool, features *Features) minio.Gateway {
+	if !enabled && !features.Has(FeatureDedup) {
+		return gateway
+	}
+	return &gatewayDeduplicating{Gateway: gateway, enabled: enabled, features: features}
+}
+
+func (gateway *gatewayDeduplicating) NewGatewayLayer(creds auth.Credentials) (minio.ObjectLayer, error) {
+	layer, err := gateway.Gateway.NewGatewayLayer(creds)
+	return &layerDeduplicating{ObjectLayer: layer, enabled: gateway.enabled, features: gateway.features}, err
+}
+
+// layerDeduplicating skips the uploads of unchanged objects. Every other
+// request is served by the embedded layer.
+type layerDeduplicating struct {
+	minio.ObjectLayer
+	enabled  bool
+	features *Features
+}
+
+func (layer *layerDeduplicating) PutObject(ctx context.Context, bucket, object string, data *minio.PutObjReader, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	if data == nil || data.MD5HexString() == "" ||
+		!layer.enabled && !layer.features.Enabled(ctx, FeatureDedup, bucket) {
+		return layer.ObjectLayer.PutObject(ctx, bucket, object, data, opts)
+	}
+
+	existing, err := layer.ObjectLayer.GetObjectInfo(ctx, bucket, object, minio.ObjectOptions{})
+	if err == nil && strings.EqualFold(existing.ETag, data.MD5HexString()) &&
+		existing.Size == data.Size() && sameMetadata(existing.UserDefined, opts.UserDefined) {
+		mon.Event("dedup_skipped")
+		mon.IntVal("dedup_skipped_bytes").Observe(existing.Size)
+		return existing, nil
+	}
+	return layer.ObjectLayer.PutObject(ctx, bucket, object, data, opts)
+}
+
+// sameMetadata returns whether the metadata of an existing object is the
+// metadata of an upload, apart from what the gateway adds.
+func sameMetadata(existing, upload map[string]string) bool {
+	return equalWithout(existing, upload, "s3:etag") && equalWithout(upload, existing, "s3:etag")
+}
+
+// equalWithout returns whether every entry of a but the ignored one is in b.
+func equalWithout(a, b map[string]string, ignored string) bool {
+	for name, value := range a {
+		if name == ignored {
+			continue
+		}
+		if actual, ok := b[name]; !ok || actual != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,144 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// FeatureDedup is the feature that skips the uploads of unchanged objects.
+const FeatureDedup = "dedup"
+
+// knownFeatures are the features that can be enabled by feature flags.
+var knownFeatures = map[string]bool{
+	FeatureDedup: true,
+}
+
+// FeaturesConfig configures the feature flags.
+type FeaturesConfig struct {
+	File string `help:"path of a json file that maps experimental features to the access keys, buckets or percent of access keys that they are enabled for, in addition to whom their own settings enable them for" default:""`
+}
+
+// FeatureRule is whom a feature is enabled for. A request has the feature if
+// any part of the rule matches it.
+type FeatureRule struct {
+	// All enables the feature for every request.
+	All bool `json:"all,omitempty"`
+	// AccessKeys enables the feature for the requests of these access keys.
+	AccessKeys []string `json:"access_keys,omitempty"`
+	// Buckets enables the feature for the requests to these buckets.
+	Buckets []string `json:"buckets,omitempty"`
+	// Percent enables the feature for about this percent of the access keys,
+	// which are always the same ones, so that a feature can be rolled out to
+	// more and more of them.
+	Percent int `json:"percent,omitempty"`
+
+	accessKeys map[string]bool
+	buckets    map[string]bool
+}
+
+// compile validates the rule and indexes its access keys and buckets.
+func (rule *FeatureRule) compile() error {
+	if rule.Percent < 0 || rule.Percent > 100 {
+		return Error.New("percent must be between 0 and 100")
+	}
+	rule.accessKeys = make(map[string]bool, len(rule.AccessKeys))
+	for _, accessKey := range rule.AccessKeys {
+		rule.accessKeys[accessKey] = true
+	}
+	rule.buckets = make(map[string]bool, len(rule.Buckets))
+	for _, bucket := range rule.Buckets {
+		rule.buckets[bucket] = true
+	}
+	return nil
+}
+
+// matches returns whether the rule enables the feature for the request of the
+// access key to the bucket.
+func (rule *FeatureRule) matches(accessKey, bucket string) bool {
+	switch {
+	case rule.All:
+		return true
+	case accessKey != "" && rule.accessKeys[accessKey]:
+		return true
+	case bucket != "" && rule.buckets[bucket]:
+		return true
+	case accessKey != "" && rule.Percent > 0:
+		hash := fnv.New32a()
+		_, _ = hash.Write([]byte(accessKey))
+		return int(hash.Sum32()%100) < rule.Percent
+	}
+	return false
+}
+
+// Features are the feature flags, which enable experimental features for some
+// access keys or buckets, so that operators can roll them out gradually. A nil
+// Features enables no features.
+type Features struct {
+	rules map[string]*FeatureRule
+}
+
+// LoadFeatures reads and validates the feature flags of the json file at path,
+// which maps features to their rules.
+func LoadFeatures(path string) (*Features, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	var rules map[string]*FeatureRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, Error.New("invalid features file %q: %v", path, err)
+	}
+
+	for feature, rule := range rules {
+		if !knownFeatures[feature] {
+			return nil, Error.New("unknown feature %q, known features are %s", feature, strings.Join(featureNames(), ", "))
+		}
+		if rule == nil {
+			return nil, Error.New("feature %q has no rule", feature)
+		}
+		if err := rule.compile(); err != nil {
+			return nil, Error.New("feature %q: %v", feature, err)
+		}
+	}
+	return &Features{rules: rules}, nil
+}
+
+// featureNames returns the sorted names of the known features.
+func featureNames() []string {
+	names := make([]string, 0, len(knownFeatures))
+	for name := range knownFeatures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Has returns whether the feature flags enable the feature for anyone.
+func (features *Features) Has(feature string) bool {
+	if features == nil {
+		return false
+	}
+	_, ok := features.rules[feature]
+	return ok
+}
+
+// Enabled returns whether the feature flags enable the feature for the request
+// of ctx to the bucket.
+func (features *Features) Enabled(ctx context.Context, feature, bucket string) bool {
+	if features == nil {
+		return false
+	}
+	rule, ok := features.rules[feature]
+	if !ok {
+		return false
+	}
+	return rule.matches(getAccessKey(ctx), bucket)
+}
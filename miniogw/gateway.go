@@ -9,6 +9,7 @@ import (
 	"encoding/hex"
 	"errors"
 	"io"
+	"net"
 	"net/http"
 	"strings"
 
@@ -21,6 +22,9 @@ import (
 
 	"storj.io/common/storj"
 	"storj.io/private/version"
+	"storj.io/stargate/internal/anomaly"
+	"storj.io/stargate/internal/plugin"
+	"storj.io/stargate/internal/tracing"
 	"storj.io/uplink"
 )
 
@@ -34,13 +38,62 @@ var (
 // NewStorjGateway creates a new Storj S3 gateway.
 func NewStorjGateway(config uplink.Config) *Gateway {
 	return &Gateway{
-		config: config,
+		open:     openUplinkProject(config),
+		projects: DefaultProjectsConfig,
 	}
 }
 
 // Gateway is the implementation of a minio cmd.Gateway.
 type Gateway struct {
-	config uplink.Config
+	open     OpenProjectFunc
+	projects ProjectsConfig
+	detector *anomaly.Detector
+	router   Router
+	health   *Health
+	plugins  *plugin.Plugins
+
+	credentials *Credentials
+}
+
+// SetProjectsConfig configures how many projects the gateway keeps open.
+func (gateway *Gateway) SetProjectsConfig(config ProjectsConfig) {
+	gateway.projects = config
+}
+
+// SetProjectOpener makes the gateway open the projects of access grants with
+// open instead of with uplink, like unit tests do with mock projects.
+func (gateway *Gateway) SetProjectOpener(open OpenProjectFunc) {
+	gateway.open = open
+}
+
+// SetAnomalyDetector makes the gateway report every use of an access key to
+// detector so that unusual usage raises alerts.
+func (gateway *Gateway) SetAnomalyDetector(detector *anomaly.Detector) {
+	gateway.detector = detector
+}
+
+// SetRouter makes the gateway use the access grants of the routes of access
+// keys for the objects that match them.
+func (gateway *Gateway) SetRouter(router Router) {
+	gateway.router = router
+}
+
+// SetHealth makes the gateway report the satellite of every request to health
+// so that it is checked.
+func (gateway *Gateway) SetHealth(health *Health) {
+	gateway.health = health
+}
+
+// SetPlugins makes the gateway resolve access keys with the auth resolver
+// plugins when no route of the access key matches.
+func (gateway *Gateway) SetPlugins(plugins *plugin.Plugins) {
+	gateway.plugins = plugins
+}
+
+// SetCredentials makes the gateway resolve access keys with the auth resolver
+// plugins through credentials, which serves them from cache in degraded mode.
+func (gateway *Gateway) SetCredentials(credentials *Credentials) {
+	gateway.credentials = credentials
 }
 
 // Name implements cmd.Gateway.
@@ -50,9 +103,11 @@ func (gateway *Gateway) Name() string {
 
 // NewGatewayLayer implements cmd.Gateway.
 func (gateway *Gateway) NewGatewayLayer(creds auth.Credentials) (minio.ObjectLayer, error) {
+	projects := newProjectPool(gateway.open, gateway.projects)
+	projects.health = gateway.health
 	return &gatewayLayer{
 		gateway:  gateway,
-		projects: make(map[string]*uplink.Project),
+		projects: projects,
 	}, nil
 }
 
@@ -64,13 +119,13 @@ func (gateway *Gateway) Production() bool {
 type gatewayLayer struct {
 	minio.GatewayUnsupported
 	gateway  *Gateway
-	projects map[string]*uplink.Project // TODO: we need a better way to cache open projects
+	projects *projectPool
 }
 
 func (layer *gatewayLayer) DeleteBucket(ctx context.Context, bucketName string, forceDelete bool) (err error) {
 	defer mon.Task()(&ctx)(&err)
 
-	project, err := layer.openProject(ctx, getAccessKey(ctx))
+	project, err := layer.openProject(ctx, bucketName, "")
 	if err != nil {
 		return err
 	}
@@ -87,7 +142,7 @@ func (layer *gatewayLayer) DeleteBucket(ctx context.Context, bucketName string,
 func (layer *gatewayLayer) DeleteObject(ctx context.Context, bucketName, objectPath string, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
 	defer mon.Task()(&ctx)(&err)
 
-	project, err := layer.openProject(ctx, getAccessKey(ctx))
+	project, err := layer.openProject(ctx, bucketName, objectPath)
 	if err != nil {
 		return minio.ObjectInfo{}, err
 	}
@@ -124,7 +179,7 @@ func (layer *gatewayLayer) DeleteObjects(ctx context.Context, bucketName string,
 func (layer *gatewayLayer) GetBucketInfo(ctx context.Context, bucketName string) (bucketInfo minio.BucketInfo, err error) {
 	defer mon.Task()(&ctx)(&err)
 
-	project, err := layer.openProject(ctx, getAccessKey(ctx))
+	project, err := layer.openProject(ctx, bucketName, "")
 	if err != nil {
 		return minio.BucketInfo{}, err
 	}
@@ -143,7 +198,7 @@ func (layer *gatewayLayer) GetBucketInfo(ctx context.Context, bucketName string)
 func (layer *gatewayLayer) GetObjectNInfo(ctx context.Context, bucketName, objectPath string, rangeSpec *minio.HTTPRangeSpec, header http.Header, lockType minio.LockType, opts minio.ObjectOptions) (reader *minio.GetObjectReader, err error) {
 	defer mon.Task()(&ctx)(&err)
 
-	project, err := layer.openProject(ctx, getAccessKey(ctx))
+	project, err := layer.openProject(ctx, bucketName, objectPath)
 	if err != nil {
 		return nil, err
 	}
@@ -200,13 +255,13 @@ func (layer *gatewayLayer) GetObjectNInfo(ctx context.Context, bucketName, objec
 	objectInfo := minioObjectInfo(bucketName, "", object)
 	downloadCloser := func() { _ = download.Close() }
 
-	return minio.NewGetObjectReaderFromReader(download, objectInfo, opts, downloadCloser)
+	return minio.NewGetObjectReaderFromReader(timedReader{ctx, download}, objectInfo, opts, downloadCloser)
 }
 
 func (layer *gatewayLayer) GetObject(ctx context.Context, bucketName, objectPath string, startOffset int64, length int64, writer io.Writer, etag string, opts minio.ObjectOptions) (err error) {
 	defer mon.Task()(&ctx)(&err)
 
-	project, err := layer.openProject(ctx, getAccessKey(ctx))
+	project, err := layer.openProject(ctx, bucketName, objectPath)
 	if err != nil {
 		return err
 	}
@@ -235,7 +290,9 @@ func (layer *gatewayLayer) GetObject(ctx context.Context, bucketName, objectPath
 		}
 	}
 
+	transferred := measureTransfer(ctx)
 	_, err = io.Copy(writer, download)
+	transferred()
 
 	return err
 }
@@ -243,7 +300,7 @@ func (layer *gatewayLayer) GetObject(ctx context.Context, bucketName, objectPath
 func (layer *gatewayLayer) GetObjectInfo(ctx context.Context, bucketName, objectPath string, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
 	defer mon.Task()(&ctx)(&err)
 
-	project, err := layer.openProject(ctx, getAccessKey(ctx))
+	project, err := layer.openProject(ctx, bucketName, objectPath)
 	if err != nil {
 		return minio.ObjectInfo{}, err
 	}
@@ -265,7 +322,7 @@ func (layer *gatewayLayer) GetObjectInfo(ctx context.Context, bucketName, object
 func (layer *gatewayLayer) ListBuckets(ctx context.Context) (items []minio.BucketInfo, err error) {
 	defer mon.Task()(&ctx)(&err)
 
-	project, err := layer.openProject(ctx, getAccessKey(ctx))
+	project, err := layer.openProject(ctx, "", "")
 	if err != nil {
 		return nil, err
 	}
@@ -296,7 +353,11 @@ func (layer *gatewayLayer) ListObjects(ctx context.Context, bucketName, prefix,
 		return minio.ListObjectsInfo{}, minio.UnsupportedDelimiter{Delimiter: delimiter}
 	}
 
-	project, err := layer.openProject(ctx, getAccessKey(ctx))
+	if maxKeys < 0 {
+		return minio.ListObjectsInfo{}, minio.InvalidArgument{Bucket: bucketName, Err: errs.New("negative max keys: %d", maxKeys)}
+	}
+
+	project, err := layer.openProject(ctx, bucketName, prefix)
 	if err != nil {
 		return result, err
 	}
@@ -345,8 +406,8 @@ func (layer *gatewayLayer) ListObjects(ctx context.Context, bucketName, prefix,
 	var objects []minio.ObjectInfo
 	var prefixes []string
 
-	limit := maxKeys
-	for (limit > 0 || maxKeys == 0) && list.Next() {
+	limit := listLimit(maxKeys)
+	for limit > 0 && list.Next() {
 		limit--
 		object := list.Item()
 		if object.IsPrefix {
@@ -380,7 +441,22 @@ func (layer *gatewayLayer) ListObjects(ctx context.Context, bucketName, prefix,
 	return result, nil
 }
 
-func listSingleObject(ctx context.Context, project *uplink.Project, bucketName, key string, recursive bool) (result minio.ListObjectsInfo, err error) {
+// maxListKeys is the most keys that a page of a listing has, like in S3.
+const maxListKeys = 1000
+
+// listLimit returns how many keys a page of a listing with maxKeys may have.
+// Listings are never unbounded, since the whole page is buffered before minio
+// encodes the response; clients page through larger listings. Like in S3,
+// pages with a maxKeys of 0 are empty, but still tell whether there are more
+// keys.
+func listLimit(maxKeys int) int {
+	if maxKeys > maxListKeys {
+		return maxListKeys
+	}
+	return maxKeys
+}
+
+func listSingleObject(ctx context.Context, project Project, bucketName, key string, recursive bool) (result minio.ListObjectsInfo, err error) {
 	defer mon.Task()(&ctx)(&err)
 
 	var prefixes []string
@@ -422,7 +498,11 @@ func (layer *gatewayLayer) ListObjectsV2(ctx context.Context, bucketName, prefix
 		return minio.ListObjectsV2Info{ContinuationToken: continuationToken}, minio.UnsupportedDelimiter{Delimiter: delimiter}
 	}
 
-	project, err := layer.openProject(ctx, getAccessKey(ctx))
+	if maxKeys < 0 {
+		return minio.ListObjectsV2Info{ContinuationToken: continuationToken}, minio.InvalidArgument{Bucket: bucketName, Err: errs.New("negative max keys: %d", maxKeys)}
+	}
+
+	project, err := layer.openProject(ctx, bucketName, prefix)
 	if err != nil {
 		return result, err
 	}
@@ -465,6 +545,8 @@ func (layer *gatewayLayer) ListObjectsV2(ctx context.Context, bucketName, prefix
 	if startAfterPath == "" && startAfter != "" {
 		startAfterPath = startAfter
 	}
+	// pages without objects continue where they started
+	startAfter = startAfterPath
 
 	var objects []minio.ObjectInfo
 	var prefixes []string
@@ -478,8 +560,8 @@ func (layer *gatewayLayer) ListObjectsV2(ctx context.Context, bucketName, prefix
 		Custom: true,
 	})
 
-	limit := maxKeys
-	for (limit > 0 || maxKeys == 0) && list.Next() {
+	limit := listLimit(maxKeys)
+	for limit > 0 && list.Next() {
 		limit--
 		object := list.Item()
 		if object.IsPrefix {
@@ -513,7 +595,7 @@ func (layer *gatewayLayer) ListObjectsV2(ctx context.Context, bucketName, prefix
 	return result, nil
 }
 
-func listSingleObjectV2(ctx context.Context, project *uplink.Project, bucketName, key string, recursive, fetchOwner bool) (result minio.ListObjectsV2Info, err error) {
+func listSingleObjectV2(ctx context.Context, project Project, bucketName, key string, recursive, fetchOwner bool) (result minio.ListObjectsV2Info, err error) {
 	defer mon.Task()(&ctx)(&err)
 
 	var prefixes []string
@@ -551,7 +633,7 @@ func listSingleObjectV2(ctx context.Context, project *uplink.Project, bucketName
 func (layer *gatewayLayer) MakeBucketWithLocation(ctx context.Context, bucketName string, opts minio.BucketOptions) (err error) {
 	defer mon.Task()(&ctx)(&err)
 
-	project, err := layer.openProject(ctx, getAccessKey(ctx))
+	project, err := layer.openProject(ctx, bucketName, "")
 	if err != nil {
 		return err
 	}
@@ -574,7 +656,7 @@ func (layer *gatewayLayer) CopyObject(ctx context.Context, srcBucket, srcObject,
 	// 	return minio.ObjectInfo{}, minio.ObjectNameInvalid{Bucket: destBucket}
 	// }
 
-	// project, err := layer.openProject(ctx, getAccessKey(ctx))
+	// project, err := layer.openProject(ctx, srcBucket, srcObject)
 	// if err != nil {
 	// 	return minio.ObjectInfo{}, err
 	// }
@@ -647,7 +729,7 @@ func (layer *gatewayLayer) CopyObject(ctx context.Context, srcBucket, srcObject,
 func (layer *gatewayLayer) PutObject(ctx context.Context, bucketName, objectPath string, data *minio.PutObjReader, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
 	defer mon.Task()(&ctx)(&err)
 
-	project, err := layer.openProject(ctx, getAccessKey(ctx))
+	project, err := layer.openProject(ctx, bucketName, objectPath)
 	if err != nil {
 		return minio.ObjectInfo{}, err
 	}
@@ -671,13 +753,18 @@ func (layer *gatewayLayer) PutObject(ctx context.Context, bucketName, objectPath
 		return minio.ObjectInfo{}, convertError(err, bucketName, objectPath)
 	}
 
+	transferred := measureTransfer(ctx)
 	_, err = io.Copy(upload, data)
+	transferred()
 	if err != nil {
 		abortErr := upload.Abort()
 		err = errs.Combine(err, abortErr)
 		return minio.ObjectInfo{}, convertError(err, bucketName, objectPath)
 	}
 
+	if opts.UserDefined == nil {
+		opts.UserDefined = make(map[string]string)
+	}
 	opts.UserDefined["s3:etag"] = hex.EncodeToString(data.MD5Current())
 	err = upload.SetCustomMetadata(ctx, opts.UserDefined)
 	if err != nil {
@@ -697,11 +784,7 @@ func (layer *gatewayLayer) PutObject(ctx context.Context, bucketName, objectPath
 func (layer *gatewayLayer) Shutdown(ctx context.Context) (err error) {
 	defer mon.Task()(&ctx)(&err)
 
-	for _, project := range layer.projects {
-		err = errs.Combine(err, project.Close())
-	}
-
-	return err
+	return layer.projects.Close()
 }
 
 func (layer *gatewayLayer) StorageInfo(ctx context.Context, local bool) (minio.StorageInfo, []error) {
@@ -711,25 +794,25 @@ func (layer *gatewayLayer) StorageInfo(ctx context.Context, local bool) (minio.S
 	return info, nil
 }
 
-func (layer *gatewayLayer) openProject(ctx context.Context, accessKey string) (_ *uplink.Project, err error) {
+// openProject opens the project for the key in the bucket, which is the
+// project of the access key of the request unless a route of the access key
+// matches. Bucket operations pass an empty key, and operations that aren't for
+// a bucket an empty bucket.
+func (layer *gatewayLayer) openProject(ctx context.Context, bucket, key string) (_ Project, err error) {
 	defer mon.Task()(&ctx)(&err)
+	defer measureAuth(ctx)()
 
-	project, ok := layer.projects[accessKey]
-	if !ok {
-		access, err := uplink.ParseAccess(accessKey)
-		if err != nil {
-			return nil, err
-		}
-
-		project, err = uplink.OpenProject(ctx, access)
-		if err != nil {
-			return nil, err
-		}
-
-		layer.projects[accessKey] = project
+	accessKey := getAccessKey(ctx)
+	layer.observe(ctx, accessKey)
+	if tenant, err := tenantID(accessKey); err == nil {
+		tracing.SetTenant(ctx, tenant)
 	}
 
-	return project, nil
+	accessGrant, err := layer.route(ctx, accessKey, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return layer.projects.Get(ctx, accessGrant)
 }
 
 func convertError(err error, bucket, object string) error {
@@ -786,6 +869,26 @@ func minioObjectInfo(bucket, etag string, object *uplink.Object) minio.ObjectInf
 	}
 }
 
+// observe reports the use of accessKey by the current request to the anomaly
+// detector, if there is one.
+func (layer *gatewayLayer) observe(ctx context.Context, accessKey string) {
+	detector := layer.gateway.detector
+	if detector == nil {
+		return
+	}
+
+	event := anomaly.Event{AccessKey: accessKey}
+	if reqInfo := logger.GetReqInfo(ctx); reqInfo != nil {
+		event.Operation = reqInfo.API
+		host := reqInfo.RemoteHost
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		event.RemoteIP = net.ParseIP(host)
+	}
+	detector.Observe(ctx, event)
+}
+
 func getAccessKey(ctx context.Context) string {
 	reqInfo := logger.GetReqInfo(ctx)
 	if reqInfo == nil {
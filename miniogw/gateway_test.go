@@ -0,0 +1,450 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/cmd/logger"
+	"github.com/minio/minio/pkg/auth"
+	"github.com/minio/minio/pkg/hash"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/stargate/miniogw"
+	"storj.io/uplink"
+)
+
+// mockProject is an in-memory miniogw.Project. The errors scripted for a
+// method are returned by its next calls, before it does anything else.
+type mockProject struct {
+	buckets map[string]*uplink.Bucket
+	objects map[string]map[string]*uplink.Object
+	data    map[string]map[string][]byte
+	script  map[string][]error
+}
+
+func newMockProject() *mockProject {
+	return &mockProject{
+		buckets: make(map[string]*uplink.Bucket),
+		objects: make(map[string]map[string]*uplink.Object),
+		data:    make(map[string]map[string][]byte),
+		script:  make(map[string][]error),
+	}
+}
+
+// fail scripts err for the next call of method.
+func (project *mockProject) fail(method string, err error) {
+	project.script[method] = append(project.script[method], err)
+}
+
+// scripted returns the next error scripted for method.
+func (project *mockProject) scripted(method string) error {
+	errs := project.script[method]
+	if len(errs) == 0 {
+		return nil
+	}
+	project.script[method] = errs[1:]
+	return errs[0]
+}
+
+func (project *mockProject) StatBucket(ctx context.Context, bucket string) (*uplink.Bucket, error) {
+	if err := project.scripted("StatBucket"); err != nil {
+		return nil, err
+	}
+	if bucket == "" {
+		return nil, uplink.ErrBucketNameInvalid
+	}
+	info, ok := project.buckets[bucket]
+	if !ok {
+		return nil, uplink.ErrBucketNotFound
+	}
+	return info, nil
+}
+
+func (project *mockProject) CreateBucket(ctx context.Context, bucket string) (*uplink.Bucket, error) {
+	if err := project.scripted("CreateBucket"); err != nil {
+		return nil, err
+	}
+	if bucket == "" {
+		return nil, uplink.ErrBucketNameInvalid
+	}
+	if info, ok := project.buckets[bucket]; ok {
+		return info, uplink.ErrBucketAlreadyExists
+	}
+	info := &uplink.Bucket{Name: bucket, Created: time.Now()}
+	project.buckets[bucket] = info
+	project.objects[bucket] = make(map[string]*uplink.Object)
+	project.data[bucket] = make(map[string][]byte)
+	return info, nil
+}
+
+func (project *mockProject) DeleteBucket(ctx context.Context, bucket string) (*uplink.Bucket, error) {
+	if err := project.scripted("DeleteBucket"); err != nil {
+		return nil, err
+	}
+	info, err := project.StatBucket(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	if len(project.objects[bucket]) > 0 {
+		return nil, uplink.ErrBucketNotEmpty
+	}
+	delete(project.buckets, bucket)
+	return info, nil
+}
+
+func (project *mockProject) DeleteBucketWithObjects(ctx context.Context, bucket string) (*uplink.Bucket, error) {
+	if err := project.scripted("DeleteBucketWithObjects"); err != nil {
+		return nil, err
+	}
+	info, err := project.StatBucket(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	delete(project.buckets, bucket)
+	delete(project.objects, bucket)
+	delete(project.data, bucket)
+	return info, nil
+}
+
+func (project *mockProject) ListBuckets(ctx context.Context, options *uplink.ListBucketsOptions) miniogw.BucketIterator {
+	iterator := &mockBucketIterator{err: project.scripted("ListBuckets")}
+	for _, info := range project.buckets {
+		iterator.items = append(iterator.items, info)
+	}
+	sort.Slice(iterator.items, func(i, k int) bool { return iterator.items[i].Name < iterator.items[k].Name })
+	return iterator
+}
+
+func (project *mockProject) StatObject(ctx context.Context, bucket, key string) (*uplink.Object, error) {
+	if err := project.scripted("StatObject"); err != nil {
+		return nil, err
+	}
+	object, ok := project.objects[bucket][key]
+	if !ok {
+		return nil, uplink.ErrObjectNotFound
+	}
+	return object, nil
+}
+
+func (project *mockProject) DeleteObject(ctx context.Context, bucket, key string) (*uplink.Object, error) {
+	if err := project.scripted("DeleteObject"); err != nil {
+		return nil, err
+	}
+	object, ok := project.objects[bucket][key]
+	if !ok {
+		return nil, uplink.ErrObjectNotFound
+	}
+	delete(project.objects[bucket], key)
+	delete(project.data[bucket], key)
+	return object, nil
+}
+
+// ListObjects lists the objects after the cursor in the order of their keys.
+// Unless the listing is recursive, the keys below the prefix that have a
+// slash are listed as prefixes up to it.
+func (project *mockProject) ListObjects(ctx context.Context, bucket string, options *uplink.ListObjectsOptions) miniogw.ObjectIterator {
+	if options == nil {
+		options = &uplink.ListObjectsOptions{}
+	}
+	iterator := &mockObjectIterator{err: project.scripted("ListObjects")}
+
+	var keys []string
+	for key := range project.objects[bucket] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	listed := make(map[string]bool)
+	for _, key := range keys {
+		if !strings.HasPrefix(key, options.Prefix) || key <= options.Cursor {
+			continue
+		}
+		if !options.Recursive {
+			if slash := strings.IndexByte(key[len(options.Prefix):], '/'); slash >= 0 {
+				prefix := key[:len(options.Prefix)+slash+1]
+				if !listed[prefix] {
+					listed[prefix] = true
+					iterator.items = append(iterator.items, &uplink.Object{Key: prefix, IsPrefix: true})
+				}
+				continue
+			}
+		}
+		iterator.items = append(iterator.items, project.objects[bucket][key])
+	}
+	return iterator
+}
+
+func (project *mockProject) UploadObject(ctx context.Context, bucket, key string, options *uplink.UploadOptions) (miniogw.Upload, error) {
+	if err := project.scripted("UploadObject"); err != nil {
+		return nil, err
+	}
+	if _, err := project.StatBucket(ctx, bucket); err != nil {
+		return nil, err
+	}
+	if key == "" {
+		return nil, uplink.ErrObjectKeyInvalid
+	}
+	return &mockUpload{project: project, bucket: bucket, object: &uplink.Object{Key: key}}, nil
+}
+
+func (project *mockProject) DownloadObject(ctx context.Context, bucket, key string, options *uplink.DownloadOptions) (miniogw.Download, error) {
+	if err := project.scripted("DownloadObject"); err != nil {
+		return nil, err
+	}
+	object, err := project.StatObject(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	data := project.data[bucket][key]
+	if options != nil {
+		end := int64(len(data))
+		if options.Length >= 0 && options.Offset+options.Length < end {
+			end = options.Offset + options.Length
+		}
+		if options.Offset > end {
+			return nil, errors.New("offset out of range")
+		}
+		data = data[options.Offset:end]
+	}
+	return &mockDownload{Reader: bytes.NewReader(data), object: object}, nil
+}
+
+func (project *mockProject) Close() error { return nil }
+
+type mockBucketIterator struct {
+	items   []*uplink.Bucket
+	current *uplink.Bucket
+	err     error
+}
+
+func (iterator *mockBucketIterator) Next() bool {
+	if iterator.err != nil || len(iterator.items) == 0 {
+		return false
+	}
+	iterator.current, iterator.items = iterator.items[0], iterator.items[1:]
+	return true
+}
+
+func (iterator *mockBucketIterator) Item() *uplink.Bucket { return iterator.current }
+func (iterator *mockBucketIterator) Err() error           { return iterator.err }
+
+type mockObjectIterator struct {
+	items   []*uplink.Object
+	current *uplink.Object
+	err     error
+}
+
+func (iterator *mockObjectIterator) Next() bool {
+	if iterator.err != nil || len(iterator.items) == 0 {
+		return false
+	}
+	iterator.current, iterator.items = iterator.items[0], iterator.items[1:]
+	return true
+}
+
+func (iterator *mockObjectIterator) Item() *uplink.Object { return iterator.current }
+func (iterator *mockObjectIterator) Err() error           { return iterator.err }
+
+type mockUpload struct {
+	project *mockProject
+	bucket  string
+	object  *uplink.Object
+	data    bytes.Buffer
+}
+
+func (upload *mockUpload) Write(p []byte) (int, error) {
+	if err := upload.project.scripted("Upload.Write"); err != nil {
+		return 0, err
+	}
+	return upload.data.Write(p)
+}
+
+func (upload *mockUpload) SetCustomMetadata(ctx context.Context, custom uplink.CustomMetadata) error {
+	upload.object.Custom = custom.Clone()
+	return nil
+}
+
+func (upload *mockUpload) Commit() error {
+	if err := upload.project.scripted("Upload.Commit"); err != nil {
+		return err
+	}
+	upload.object.System = uplink.SystemMetadata{
+		Created:       time.Now(),
+		ContentLength: int64(upload.data.Len()),
+	}
+	upload.project.objects[upload.bucket][upload.object.Key] = upload.object
+	upload.project.data[upload.bucket][upload.object.Key] = upload.data.Bytes()
+	return nil
+}
+
+func (upload *mockUpload) Abort() error         { return nil }
+func (upload *mockUpload) Info() *uplink.Object { return upload.object }
+
+type mockDownload struct {
+	io.Reader
+	object *uplink.Object
+}
+
+func (download *mockDownload) Close() error         { return nil }
+func (download *mockDownload) Info() *uplink.Object { return download.object }
+
+// newLayer returns an object layer of the gateway on project, and the context
+// of its requests.
+func newLayer(t *testing.T, project *mockProject) (context.Context, minio.ObjectLayer) {
+	gateway := miniogw.NewStorjGateway(uplink.Config{})
+	gateway.SetProjectOpener(func(ctx context.Context, accessGrant string) (miniogw.Project, string, error) {
+		return project, "satellite", nil
+	})
+	layer, err := gateway.NewGatewayLayer(auth.Credentials{})
+	require.NoError(t, err)
+
+	ctx := logger.SetReqInfo(context.Background(), &logger.ReqInfo{AccessKey: "access"})
+	return ctx, layer
+}
+
+func putObject(ctx context.Context, t *testing.T, layer minio.ObjectLayer, bucket, key string, data []byte, metadata map[string]string) minio.ObjectInfo {
+	hashReader, err := hash.NewReader(bytes.NewReader(data), int64(len(data)), "", "", int64(len(data)), true)
+	require.NoError(t, err)
+	if metadata == nil {
+		metadata = make(map[string]string)
+	}
+	info, err := layer.PutObject(ctx, bucket, key, minio.NewPutObjReader(hashReader, nil, nil), minio.ObjectOptions{UserDefined: metadata})
+	require.NoError(t, err)
+	return info
+}
+
+func TestGateway_Errors(t *testing.T) {
+	project := newMockProject()
+	ctx, layer := newLayer(t, project)
+
+	_, err := layer.GetBucketInfo(ctx, "bucket")
+	require.Equal(t, minio.BucketNotFound{Bucket: "bucket"}, err)
+
+	require.Equal(t, minio.BucketNameInvalid{}, layer.MakeBucketWithLocation(ctx, "", minio.BucketOptions{}))
+	require.NoError(t, layer.MakeBucketWithLocation(ctx, "bucket", minio.BucketOptions{}))
+	require.Equal(t, minio.BucketAlreadyExists{Bucket: "bucket"}, layer.MakeBucketWithLocation(ctx, "bucket", minio.BucketOptions{}))
+
+	_, err = layer.GetObjectInfo(ctx, "bucket", "key", minio.ObjectOptions{})
+	require.Equal(t, minio.ObjectNotFound{Bucket: "bucket", Object: "key"}, err)
+
+	putObject(ctx, t, layer, "bucket", "key", []byte("data"), nil)
+	require.Equal(t, minio.BucketNotEmpty{Bucket: "bucket"}, layer.DeleteBucket(ctx, "bucket", false))
+
+	// the errors of uplink are mapped wherever they happen
+	project.fail("StatObject", uplink.ErrObjectKeyInvalid)
+	_, err = layer.GetObjectInfo(ctx, "bucket", "key", minio.ObjectOptions{})
+	require.Equal(t, minio.ObjectNameInvalid{Bucket: "bucket", Object: "key"}, err)
+
+	project.fail("ListObjects", uplink.ErrBucketNotFound)
+	_, err = layer.ListObjects(ctx, "bucket", "", "", "", 0)
+	require.Equal(t, minio.BucketNotFound{Bucket: "bucket"}, err)
+
+	// other errors are returned as they are
+	failure := errors.New("failure")
+	project.fail("Upload.Commit", failure)
+	hashReader, err := hash.NewReader(bytes.NewReader(nil), 0, "", "", 0, true)
+	require.NoError(t, err)
+	_, err = layer.PutObject(ctx, "bucket", "other", minio.NewPutObjReader(hashReader, nil, nil), minio.ObjectOptions{UserDefined: map[string]string{}})
+	require.Equal(t, failure, err)
+
+	// deleting objects that don't exist succeeds
+	deleted, errs := layer.DeleteObjects(ctx, "bucket", []minio.ObjectToDelete{{ObjectName: "key"}, {ObjectName: "missing"}}, minio.ObjectOptions{})
+	require.Equal(t, []error{nil, nil}, errs)
+	require.Equal(t, "missing", deleted[1].ObjectName)
+	require.NoError(t, layer.DeleteBucket(ctx, "bucket", false))
+}
+
+func TestGateway_Metadata(t *testing.T) {
+	project := newMockProject()
+	ctx, layer := newLayer(t, project)
+	require.NoError(t, layer.MakeBucketWithLocation(ctx, "bucket", minio.BucketOptions{}))
+
+	data := []byte("some data")
+	sum := md5.Sum(data)
+	etag := hex.EncodeToString(sum[:])
+
+	info := putObject(ctx, t, layer, "bucket", "key", data, map[string]string{
+		"Content-Type": "text/plain",
+		"color":        "blue",
+	})
+	require.Equal(t, etag, info.ETag)
+
+	info, err := layer.GetObjectInfo(ctx, "bucket", "key", minio.ObjectOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "key", info.Name)
+	require.Equal(t, int64(len(data)), info.Size)
+	require.Equal(t, etag, info.ETag)
+	require.Equal(t, "text/plain", info.ContentType)
+	require.Equal(t, "blue", info.UserDefined["color"])
+
+	var buffer bytes.Buffer
+	require.NoError(t, layer.GetObject(ctx, "bucket", "key", 5, 4, &buffer, "", minio.ObjectOptions{}))
+	require.Equal(t, "data", buffer.String())
+
+	err = layer.GetObject(ctx, "bucket", "key", 5, 10, &buffer, "", minio.ObjectOptions{})
+	require.True(t, errors.As(err, &minio.InvalidRange{}), err)
+}
+
+func TestGateway_ListPages(t *testing.T) {
+	project := newMockProject()
+	ctx, layer := newLayer(t, project)
+	require.NoError(t, layer.MakeBucketWithLocation(ctx, "bucket", minio.BucketOptions{}))
+
+	keys := []string{"a", "b", "c", "dir/d", "dir/e"}
+	for _, key := range keys {
+		putObject(ctx, t, layer, "bucket", key, []byte(key), nil)
+	}
+
+	// recursive listings are paged with markers
+	var listed []string
+	marker := ""
+	for pages := 0; ; pages++ {
+		require.True(t, pages < len(keys), "listing doesn't end")
+		result, err := layer.ListObjects(ctx, "bucket", "", marker, "", 2)
+		require.NoError(t, err)
+		require.True(t, len(result.Objects) <= 2)
+		for _, object := range result.Objects {
+			listed = append(listed, object.Name)
+		}
+		if !result.IsTruncated {
+			break
+		}
+		marker = result.NextMarker
+	}
+	require.Equal(t, keys, listed)
+
+	// listings with a delimiter list prefixes
+	result, err := layer.ListObjectsV2(ctx, "bucket", "", "", "/", 1000, false, "")
+	require.NoError(t, err)
+	require.False(t, result.IsTruncated)
+	require.Equal(t, []string{"dir/"}, result.Prefixes)
+	require.Len(t, result.Objects, 3)
+
+	// a prefix without a slash is listed like a stat
+	result, err = layer.ListObjectsV2(ctx, "bucket", "dir", "", "/", 1000, false, "")
+	require.NoError(t, err)
+	require.Equal(t, []string{"dir/"}, result.Prefixes)
+	require.Empty(t, result.Objects)
+
+	// pages with max keys of 0 are empty, but tell whether there are more
+	result, err = layer.ListObjectsV2(ctx, "bucket", "", "b", "", 0, false, "")
+	require.NoError(t, err)
+	require.True(t, result.IsTruncated)
+	require.Equal(t, "b", result.NextContinuationToken)
+	require.Empty(t, result.Objects)
+
+	_, err = layer.ListObjects(ctx, "bucket", "", "", "", -1)
+	require.True(t, errors.As(err, &minio.InvalidArgument{}), err)
+}
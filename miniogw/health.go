@@ -0,0 +1,265 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// HealthConfig configures the checks of whether the gateway can reach its
+// satellites.
+type HealthConfig struct {
+	Address    string        `help:"address to serve /health and /health/ready over for load balancers; disabled when empty" default:""`
+	Satellites string        `help:"comma separated addresses of the satellites that the gateway must reach to be ready" default:""`
+	Interval   time.Duration `help:"how often the satellites are checked" default:"30s"`
+	Timeout    time.Duration `help:"how long a satellite may take to accept a connection" default:"5s"`
+	Recent     time.Duration `help:"how long the satellite of a request keeps being checked after the request" default:"1h0m0s"`
+}
+
+// SatelliteHealth is the result of the last check of a satellite.
+type SatelliteHealth struct {
+	Address    string     `json:"address"`
+	Configured bool       `json:"configured"`
+	Reachable  bool       `json:"reachable"`
+	CheckedAt  *time.Time `json:"checked_at,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// satelliteState is what Health knows about a satellite.
+type satelliteState struct {
+	configured bool
+	lastUsed   time.Time
+	checkedAt  time.Time
+	err        error
+}
+
+// Health periodically checks that the gateway can connect to the configured
+// satellites and to the satellites of recent requests, and caches the results
+// for readiness checks.
+//
+// The gateway is ready when every configured satellite is reachable. Without
+// configured satellites it is ready unless none of the checked satellites is
+// reachable, since a single unreachable satellite of a request doesn't mean
+// that the gateway can't serve the others.
+type Health struct {
+	log    *zap.Logger
+	config HealthConfig
+
+	credentials *Credentials
+
+	mu         sync.Mutex
+	satellites map[string]*satelliteState
+}
+
+// NewHealth constructs a Health that checks the satellites configured by
+// config.
+func NewHealth(log *zap.Logger, config HealthConfig) *Health {
+	health := &Health{
+		log:        log,
+		config:     config,
+		satellites: make(map[string]*satelliteState),
+	}
+	for _, address := range ParseList(config.Satellites) {
+		health.satellites[address] = &satelliteState{configured: true}
+	}
+	return health
+}
+
+// Observe records that a request used the satellite, so that it is checked
+// for a while. It is a no-op on a nil Health.
+func (health *Health) Observe(satellite string) {
+	if health == nil || satellite == "" {
+		return
+	}
+
+	health.mu.Lock()
+	defer health.mu.Unlock()
+
+	state, ok := health.satellites[satellite]
+	if !ok {
+		state = &satelliteState{}
+		health.satellites[satellite] = state
+	}
+	state.lastUsed = time.Now()
+}
+
+// SetCredentials makes the gateway only ready while the resolution of access
+// keys by credentials is available or degraded, and reports its state. It is a
+// no-op on a nil Health.
+func (health *Health) SetCredentials(credentials *Credentials) {
+	if health == nil {
+		return
+	}
+	health.credentials = credentials
+}
+
+// Run checks the satellites every interval until ctx is canceled.
+func (health *Health) Run(ctx context.Context) error {
+	ticker := time.NewTicker(health.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		health.Check(ctx)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check connects to every satellite that is configured or that was used
+// recently, and forgets the satellites that weren't used recently. The
+// resolution of access keys is checked again if it failed.
+func (health *Health) Check(ctx context.Context) {
+	defer mon.Task()(&ctx)(nil)
+
+	health.credentials.Check(ctx)
+
+	now := time.Now()
+	var addresses []string
+
+	health.mu.Lock()
+	for address, state := range health.satellites {
+		if !state.configured && now.Sub(state.lastUsed) > health.config.Recent {
+			delete(health.satellites, address)
+			continue
+		}
+		addresses = append(addresses, address)
+	}
+	health.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, address := range addresses {
+		address := address
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := health.dial(ctx, address)
+			if err != nil {
+				mon.Event("satellite_unreachable")
+				health.log.Warn("satellite unreachable", zap.String("satellite", address), zap.Error(err))
+			}
+
+			health.mu.Lock()
+			defer health.mu.Unlock()
+			if state, ok := health.satellites[address]; ok {
+				state.checkedAt, state.err = time.Now(), err
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// dial checks that the satellite accepts connections. Satellite addresses may
+// be prefixed by the node id of the satellite and an @.
+func (health *Health) dial(ctx context.Context, address string) error {
+	if i := strings.LastIndexByte(address, '@'); i >= 0 {
+		address = address[i+1:]
+	}
+
+	dialer := net.Dialer{Timeout: health.config.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// Satellites returns the results of the last checks, sorted by address.
+// Satellites that haven't been checked yet are not reachable.
+func (health *Health) Satellites() []SatelliteHealth {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+
+	satellites := make([]SatelliteHealth, 0, len(health.satellites))
+	for address, state := range health.satellites {
+		satellite := SatelliteHealth{
+			Address:    address,
+			Configured: state.configured,
+			Reachable:  !state.checkedAt.IsZero() && state.err == nil,
+		}
+		if !state.checkedAt.IsZero() {
+			checkedAt := state.checkedAt
+			satellite.CheckedAt = &checkedAt
+		}
+		if state.err != nil {
+			satellite.Error = state.err.Error()
+		}
+		satellites = append(satellites, satellite)
+	}
+	sort.Slice(satellites, func(i, k int) bool { return satellites[i].Address < satellites[k].Address })
+	return satellites
+}
+
+// ready returns whether the gateway is ready according to the satellites.
+func ready(satellites []SatelliteHealth) bool {
+	var configured, checked, reachable int
+	for _, satellite := range satellites {
+		if satellite.Configured {
+			configured++
+			if !satellite.Reachable {
+				return false
+			}
+		}
+		if satellite.CheckedAt != nil {
+			checked++
+		}
+		if satellite.Reachable {
+			reachable++
+		}
+	}
+	return configured > 0 || checked == 0 || reachable > 0
+}
+
+// ServeHTTP implements http.Handler.
+//
+// GET /health/ready responds with 200 if the gateway is ready and with 503 if
+// it isn't, and GET /health does the same with the details of every satellite
+// and of the resolution of access keys, which flags degraded mode.
+func (health *Health) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/health" && req.URL.Path != "/health/ready" {
+		http.NotFound(w, req)
+		return
+	}
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var response struct {
+		Ready      bool              `json:"ready"`
+		Satellites []SatelliteHealth `json:"satellites,omitempty"`
+		Auth       AuthHealth        `json:"auth"`
+	}
+	response.Satellites = health.Satellites()
+	response.Auth = health.credentials.Health()
+	response.Ready = ready(response.Satellites) && response.Auth.ready()
+
+	status := http.StatusOK
+	if !response.Ready {
+		status = http.StatusServiceUnavailable
+	}
+
+	if req.URL.Path == "/health/ready" {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		health.log.Debug("unable to write response", zap.Error(err))
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"strings"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/pkg/auth"
+)
+
+// ParseList parses a comma separated list, ignoring whitespace and empty
+// entries.
+func ParseList(s string) []string {
+	var list []string
+	for _, entry := range strings.Split(s, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+type gatewayImmutable struct {
+	minio.Gateway
+	buckets map[string]struct{}
+	admins  map[string]struct{}
+}
+
+// Immutable returns a wrapper of minio.Gateway that makes the buckets append
+// only: objects in them can be uploaded but not overwritten or deleted,
+// regardless of what the access grant of the request allows. Requests with one
+// of the admin access keys are exempt.
+//
+// Whether an upload would overwrite an object is checked before the upload
+// starts, so concurrent uploads of a new object can't be told apart and the
+// last one wins.
+func Immutable(gateway minio.Gateway, buckets, admins []string) minio.Gateway {
+	if len(buckets) == 0 {
+		return gateway
+	}
+	return &gatewayImmutable{
+		Gateway: gateway,
+		buckets: toSet(buckets),
+		admins:  toSet(admins),
+	}
+}
+
+func toSet(list []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(list))
+	for _, entry := range list {
+		set[entry] = struct{}{}
+	}
+	return set
+}
+
+func (gateway *gatewayImmutable) NewGatewayLayer(creds auth.Credentials) (minio.ObjectLayer, error) {
+	layer, err := gateway.Gateway.NewGatewayLayer(creds)
+	return &layerImmutable{ObjectLayer: layer, gateway: gateway}, err
+}
+
+// layerImmutable rejects the requests that would overwrite or delete objects
+// in immutable buckets. Every other request is served by the embedded layer.
+type layerImmutable struct {
+	minio.ObjectLayer
+	gateway *gatewayImmutable
+}
+
+// immutable returns whether the objects of the bucket can't be changed by the
+// request.
+func (layer *layerImmutable) immutable(ctx context.Context, bucket string) bool {
+	if _, ok := layer.gateway.buckets[bucket]; !ok {
+		return false
+	}
+	if _, ok := layer.gateway.admins[getAccessKey(ctx)]; ok {
+		mon.Event("immutable_admin_override")
+		return false
+	}
+	return true
+}
+
+// checkNew returns an error if the object already exists in an immutable
+// bucket.
+func (layer *layerImmutable) checkNew(ctx context.Context, bucket, object string) error {
+	if !layer.immutable(ctx, bucket) {
+		return nil
+	}
+
+	_, err := layer.ObjectLayer.GetObjectInfo(ctx, bucket, object, minio.ObjectOptions{})
+	switch err.(type) {
+	case nil:
+		mon.Event("immutable_overwrite_rejected")
+		return minio.ObjectAlreadyExists{Bucket: bucket, Object: object}
+	case minio.ObjectNotFound:
+		return nil
+	default:
+		return err
+	}
+}
+
+func (layer *layerImmutable) DeleteBucket(ctx context.Context, bucket string, forceDelete bool) error {
+	if layer.immutable(ctx, bucket) {
+		return minio.PrefixAccessDenied{Bucket: bucket}
+	}
+	return layer.ObjectLayer.DeleteBucket(ctx, bucket, forceDelete)
+}
+
+func (layer *layerImmutable) PutObject(ctx context.Context, bucket, object string, data *minio.PutObjReader, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
+	if err := layer.checkNew(ctx, bucket, object); err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	return layer.ObjectLayer.PutObject(ctx, bucket, object, data, opts)
+}
+
+func (layer *layerImmutable) CopyObject(ctx context.Context, srcBucket, srcObject, destBucket, destObject string, srcInfo minio.ObjectInfo, srcOpts, destOpts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
+	if err := layer.checkNew(ctx, destBucket, destObject); err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	return layer.ObjectLayer.CopyObject(ctx, srcBucket, srcObject, destBucket, destObject, srcInfo, srcOpts, destOpts)
+}
+
+func (layer *layerImmutable) DeleteObject(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
+	if layer.immutable(ctx, bucket) {
+		mon.Event("immutable_delete_rejected")
+		return minio.ObjectInfo{}, minio.PrefixAccessDenied{Bucket: bucket, Object: object}
+	}
+	return layer.ObjectLayer.DeleteObject(ctx, bucket, object, opts)
+}
+
+func (layer *layerImmutable) DeleteObjects(ctx context.Context, bucket string, objects []minio.ObjectToDelete, opts minio.ObjectOptions) (deleted []minio.DeletedObject, errors []error) {
+	if !layer.immutable(ctx, bucket) {
+		return layer.ObjectLayer.DeleteObjects(ctx, bucket, objects, opts)
+	}
+
+	mon.Event("immutable_delete_rejected")
+	deleted, errors = make([]minio.DeletedObject, len(objects)), make([]error, len(objects))
+	for i, object := range objects {
+		errors[i] = minio.PrefixAccessDenied{Bucket: bucket, Object: object.ObjectName}
+	}
+	return deleted, errors
+}
+
+func (layer *layerImmutable) NewMultipartUpload(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (uploadID string, err error) {
+	if err := layer.checkNew(ctx, bucket, object); err != nil {
+		return "", err
+	}
+	return layer.ObjectLayer.NewMultipartUpload(ctx, bucket, object, opts)
+}
+
+func (layer *layerImmutable) CompleteMultipartUpload(ctx context.Context, bucket, object, uploadID string, uploadedParts []minio.CompletePart, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
+	// the object may have been uploaded since the multipart upload started
+	if err := layer.checkNew(ctx, bucket, object); err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	return layer.ObjectLayer.CompleteMultipartUpload(ctx, bucket, object, uploadID, uploadedParts, opts)
+}
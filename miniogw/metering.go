@@ -0,0 +1,159 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/pkg/auth"
+
+	"storj.io/stargate/internal/billing"
+)
+
+type gatewayMetering struct {
+	minio.Gateway
+	meter *billing.Meter
+}
+
+// Metering returns a wrapper of minio.Gateway that records the requests of
+// every access key, and how many bytes they upload and download, to meter.
+// Failed requests are counted without their bytes. Downloads are counted by
+// the size of what was requested, even if the client stops reading early.
+func Metering(gateway minio.Gateway, meter *billing.Meter) minio.Gateway {
+	if meter == nil {
+		return gateway
+	}
+	return &gatewayMetering{Gateway: gateway, meter: meter}
+}
+
+func (gateway *gatewayMetering) NewGatewayLayer(creds auth.Credentials) (minio.ObjectLayer, error) {
+	layer, err := gateway.Gateway.NewGatewayLayer(creds)
+	return &layerMetering{ObjectLayer: layer, meter: gateway.meter}, err
+}
+
+// layerMetering meters the requests of the embedded layer.
+type layerMetering struct {
+	minio.ObjectLayer
+	meter *billing.Meter
+}
+
+// record records a request of the operation on the bucket.
+func (layer *layerMetering) record(ctx context.Context, bucket, operation string, bytesIn, bytesOut int64) {
+	layer.meter.Record(getAccessKey(ctx), bucket, operation, bytesIn, bytesOut)
+}
+
+func (layer *layerMetering) DeleteBucket(ctx context.Context, bucket string, forceDelete bool) error {
+	layer.record(ctx, bucket, "DeleteBucket", 0, 0)
+	return layer.ObjectLayer.DeleteBucket(ctx, bucket, forceDelete)
+}
+
+func (layer *layerMetering) DeleteObject(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	layer.record(ctx, bucket, "DeleteObject", 0, 0)
+	return layer.ObjectLayer.DeleteObject(ctx, bucket, object, opts)
+}
+
+func (layer *layerMetering) DeleteObjects(ctx context.Context, bucket string, objects []minio.ObjectToDelete, opts minio.ObjectOptions) ([]minio.DeletedObject, []error) {
+	layer.record(ctx, bucket, "DeleteObjects", 0, 0)
+	return layer.ObjectLayer.DeleteObjects(ctx, bucket, objects, opts)
+}
+
+func (layer *layerMetering) GetBucketInfo(ctx context.Context, bucket string) (minio.BucketInfo, error) {
+	layer.record(ctx, bucket, "GetBucketInfo", 0, 0)
+	return layer.ObjectLayer.GetBucketInfo(ctx, bucket)
+}
+
+func (layer *layerMetering) GetObjectNInfo(ctx context.Context, bucket, object string, rangeSpec *minio.HTTPRangeSpec, header http.Header, lockType minio.LockType, opts minio.ObjectOptions) (_ *minio.GetObjectReader, err error) {
+	reader, err := layer.ObjectLayer.GetObjectNInfo(ctx, bucket, object, rangeSpec, header, lockType, opts)
+	if err != nil {
+		layer.record(ctx, bucket, "GetObject", 0, 0)
+		return nil, err
+	}
+
+	length := reader.ObjInfo.Size
+	if rangeSpec != nil {
+		if rangeLength, err := rangeSpec.GetLength(length); err == nil {
+			length = rangeLength
+		}
+	}
+	layer.record(ctx, bucket, "GetObject", 0, length)
+	return reader, nil
+}
+
+func (layer *layerMetering) GetObject(ctx context.Context, bucket, object string, startOffset int64, length int64, writer io.Writer, etag string, opts minio.ObjectOptions) error {
+	err := layer.ObjectLayer.GetObject(ctx, bucket, object, startOffset, length, writer, etag, opts)
+	if err != nil || length < 0 {
+		length = 0
+	}
+	layer.record(ctx, bucket, "GetObject", 0, length)
+	return err
+}
+
+func (layer *layerMetering) GetObjectInfo(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	layer.record(ctx, bucket, "HeadObject", 0, 0)
+	return layer.ObjectLayer.GetObjectInfo(ctx, bucket, object, opts)
+}
+
+func (layer *layerMetering) ListBuckets(ctx context.Context) ([]minio.BucketInfo, error) {
+	layer.record(ctx, "", "ListBuckets", 0, 0)
+	return layer.ObjectLayer.ListBuckets(ctx)
+}
+
+func (layer *layerMetering) ListObjects(ctx context.Context, bucket, prefix, marker, delimiter string, maxKeys int) (minio.ListObjectsInfo, error) {
+	layer.record(ctx, bucket, "ListObjects", 0, 0)
+	return layer.ObjectLayer.ListObjects(ctx, bucket, prefix, marker, delimiter, maxKeys)
+}
+
+func (layer *layerMetering) ListObjectsV2(ctx context.Context, bucket, prefix, continuationToken, delimiter string, maxKeys int, fetchOwner bool, startAfter string) (minio.ListObjectsV2Info, error) {
+	layer.record(ctx, bucket, "ListObjects", 0, 0)
+	return layer.ObjectLayer.ListObjectsV2(ctx, bucket, prefix, continuationToken, delimiter, maxKeys, fetchOwner, startAfter)
+}
+
+func (layer *layerMetering) MakeBucketWithLocation(ctx context.Context, bucket string, opts minio.BucketOptions) error {
+	layer.record(ctx, bucket, "CreateBucket", 0, 0)
+	return layer.ObjectLayer.MakeBucketWithLocation(ctx, bucket, opts)
+}
+
+func (layer *layerMetering) PutObject(ctx context.Context, bucket, object string, data *minio.PutObjReader, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	info, err := layer.ObjectLayer.PutObject(ctx, bucket, object, data, opts)
+	var size int64
+	if err == nil {
+		size = info.Size
+	}
+	layer.record(ctx, bucket, "PutObject", size, 0)
+	return info, err
+}
+
+func (layer *layerMetering) CopyObject(ctx context.Context, srcBucket, srcObject, destBucket, destObject string, srcInfo minio.ObjectInfo, srcOpts, destOpts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	info, err := layer.ObjectLayer.CopyObject(ctx, srcBucket, srcObject, destBucket, destObject, srcInfo, srcOpts, destOpts)
+	var size int64
+	if err == nil {
+		size = info.Size
+	}
+	// the gateway downloads the source and uploads the copy
+	layer.record(ctx, destBucket, "CopyObject", size, size)
+	return info, err
+}
+
+func (layer *layerMetering) NewMultipartUpload(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (string, error) {
+	layer.record(ctx, bucket, "CreateMultipartUpload", 0, 0)
+	return layer.ObjectLayer.NewMultipartUpload(ctx, bucket, object, opts)
+}
+
+func (layer *layerMetering) PutObjectPart(ctx context.Context, bucket, object, uploadID string, partID int, data *minio.PutObjReader, opts minio.ObjectOptions) (minio.PartInfo, error) {
+	info, err := layer.ObjectLayer.PutObjectPart(ctx, bucket, object, uploadID, partID, data, opts)
+	var size int64
+	if err == nil {
+		size = info.Size
+	}
+	layer.record(ctx, bucket, "UploadPart", size, 0)
+	return info, err
+}
+
+func (layer *layerMetering) CompleteMultipartUpload(ctx context.Context, bucket, object, uploadID string, uploadedParts []minio.CompletePart, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	layer.record(ctx, bucket, "CompleteMultipartUpload", 0, 0)
+	return layer.ObjectLayer.CompleteMultipartUpload(ctx, bucket, object, uploadID, uploadedParts, opts)
+}
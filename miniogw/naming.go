@@ -0,0 +1,154 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/pkg/auth"
+	"github.com/zeebo/errs"
+)
+
+// NamingError is the class of errors for keys that break a naming policy.
+var NamingError = errs.Class("naming policy")
+
+// NamingConfig configures the naming policies of buckets.
+type NamingConfig struct {
+	File string `help:"path of a json file that maps buckets to the naming policies for the keys of uploaded objects, each with an optional pattern that keys must match, maximum depth and forbidden characters" default:""`
+}
+
+// NamingPolicy restricts the keys of the objects that can be uploaded to a
+// bucket, so that downstream systems that can't handle exotic keys stay safe.
+// Objects that already exist are not affected.
+type NamingPolicy struct {
+	// Pattern is a regular expression that keys must match.
+	Pattern string `json:"pattern,omitempty"`
+	// MaxDepth is the maximum number of / separated segments of keys, or 0
+	// for no maximum.
+	MaxDepth int `json:"max_depth,omitempty"`
+	// ForbiddenCharacters are the characters that keys must not contain.
+	ForbiddenCharacters string `json:"forbidden_characters,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// compile validates the policy and compiles its pattern.
+func (policy *NamingPolicy) compile() (err error) {
+	if policy.MaxDepth < 0 {
+		return NamingError.New("max_depth must not be negative")
+	}
+	if policy.Pattern != "" {
+		if policy.pattern, err = regexp.Compile(policy.Pattern); err != nil {
+			return NamingError.New("invalid pattern: %v", err)
+		}
+	}
+	return nil
+}
+
+// Check returns a NamingError that explains why the key breaks the policy, or
+// nil if it doesn't.
+func (policy *NamingPolicy) Check(key string) error {
+	if i := strings.IndexAny(key, policy.ForbiddenCharacters); i >= 0 {
+		forbidden, _ := utf8.DecodeRuneInString(key[i:])
+		return NamingError.New("key contains the forbidden character %q", forbidden)
+	}
+	if depth := strings.Count(key, "/") + 1; policy.MaxDepth > 0 && depth > policy.MaxDepth {
+		return NamingError.New("key has %d segments, more than the maximum of %d", depth, policy.MaxDepth)
+	}
+	if policy.pattern != nil && !policy.pattern.MatchString(key) {
+		return NamingError.New("key does not match the pattern %q", policy.Pattern)
+	}
+	return nil
+}
+
+// LoadNamingPolicies reads and validates the naming policies of the json file
+// at path, which maps buckets to their policies.
+func LoadNamingPolicies(path string) (map[string]*NamingPolicy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	var policies map[string]*NamingPolicy
+	if err := json.Unmarshal(data, &policies); err != nil {
+		return nil, Error.New("invalid naming policies file %q: %v", path, err)
+	}
+
+	for bucket, policy := range policies {
+		if policy == nil {
+			return nil, NamingError.New("bucket %q has no policy", bucket)
+		}
+		if err := policy.compile(); err != nil {
+			return nil, NamingError.New("bucket %q: %v", bucket, err)
+		}
+	}
+	return policies, nil
+}
+
+type gatewayNaming struct {
+	minio.Gateway
+	policies map[string]*NamingPolicy
+}
+
+// Naming returns a wrapper of minio.Gateway that rejects uploads of objects
+// whose keys break the naming policy of their bucket with an invalid object
+// name error.
+func Naming(gateway minio.Gateway, policies map[string]*NamingPolicy) minio.Gateway {
+	if len(policies) == 0 {
+		return gateway
+	}
+	return &gatewayNaming{Gateway: gateway, policies: policies}
+}
+
+func (gateway *gatewayNaming) NewGatewayLayer(creds auth.Credentials) (minio.ObjectLayer, error) {
+	layer, err := gateway.Gateway.NewGatewayLayer(creds)
+	return &layerNaming{ObjectLayer: layer, policies: gateway.policies}, err
+}
+
+// layerNaming checks the keys of uploaded objects. Every other request is
+// served by the embedded layer.
+type layerNaming struct {
+	minio.ObjectLayer
+	policies map[string]*NamingPolicy
+}
+
+// check returns an error if the key breaks the naming policy of the bucket.
+func (layer *layerNaming) check(bucket, object string) error {
+	policy, ok := layer.policies[bucket]
+	if !ok {
+		return nil
+	}
+	if err := policy.Check(object); err != nil {
+		mon.Event("naming_policy_rejected")
+		return minio.ObjectNameInvalid{Bucket: bucket, Object: object}
+	}
+	return nil
+}
+
+func (layer *layerNaming) PutObject(ctx context.Context, bucket, object string, data *minio.PutObjReader, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
+	if err := layer.check(bucket, object); err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	return layer.ObjectLayer.PutObject(ctx, bucket, object, data, opts)
+}
+
+func (layer *layerNaming) CopyObject(ctx context.Context, srcBucket, srcObject, destBucket, destObject string, srcInfo minio.ObjectInfo, srcOpts, destOpts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
+	if err := layer.check(destBucket, destObject); err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	return layer.ObjectLayer.CopyObject(ctx, srcBucket, srcObject, destBucket, destObject, srcInfo, srcOpts, destOpts)
+}
+
+func (layer *layerNaming) NewMultipartUpload(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (uploadID string, err error) {
+	if err := layer.check(bucket, object); err != nil {
+		return "", err
+	}
+	return layer.ObjectLayer.NewMultipartUpload(ctx, bucket, object, opts)
+}
@@ -0,0 +1,81 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/pkg/auth"
+	"go.uber.org/zap"
+
+	"storj.io/stargate/internal/plugin"
+)
+
+type gatewayIntercepted struct {
+	minio.Gateway
+	log     *zap.Logger
+	plugins *plugin.Plugins
+}
+
+// Intercept returns a wrapper of minio.Gateway that asks the upload
+// interceptor plugins whether uploads may start, and stores the metadata that
+// they return. Denied uploads fail with access denied.
+func Intercept(gateway minio.Gateway, log *zap.Logger, plugins *plugin.Plugins) minio.Gateway {
+	if plugins == nil || !plugins.Implements(plugin.KindUploadInterceptor) {
+		return gateway
+	}
+	return &gatewayIntercepted{Gateway: gateway, log: log, plugins: plugins}
+}
+
+func (gateway *gatewayIntercepted) NewGatewayLayer(creds auth.Credentials) (minio.ObjectLayer, error) {
+	layer, err := gateway.Gateway.NewGatewayLayer(creds)
+	return &layerIntercepted{ObjectLayer: layer, log: gateway.log, plugins: gateway.plugins}, err
+}
+
+// layerIntercepted intercepts the uploads of the embedded layer.
+type layerIntercepted struct {
+	minio.ObjectLayer
+	log     *zap.Logger
+	plugins *plugin.Plugins
+}
+
+// intercept asks the plugins whether the upload may start, and returns the
+// metadata to store with it.
+func (layer *layerIntercepted) intercept(ctx context.Context, bucket, object string, size int64, opts minio.ObjectOptions) (map[string]string, error) {
+	response, err := layer.plugins.InterceptUpload(ctx, plugin.UploadRequest{
+		Bucket:      bucket,
+		Key:         object,
+		Size:        size,
+		ContentType: opts.UserDefined["content-type"],
+		Metadata:    opts.UserDefined,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if response.Deny {
+		layer.log.Info("upload denied by plugin",
+			zap.String("bucket", bucket), zap.String("reason", response.Reason))
+		return nil, minio.PrefixAccessDenied{Bucket: bucket, Object: object}
+	}
+	return response.Metadata, nil
+}
+
+func (layer *layerIntercepted) PutObject(ctx context.Context, bucket, object string, data *minio.PutObjReader, opts minio.ObjectOptions) (_ minio.ObjectInfo, err error) {
+	size := int64(0)
+	if data != nil {
+		size = data.Size()
+	}
+	if opts.UserDefined, err = layer.intercept(ctx, bucket, object, size, opts); err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	return layer.ObjectLayer.PutObject(ctx, bucket, object, data, opts)
+}
+
+func (layer *layerIntercepted) NewMultipartUpload(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (uploadID string, err error) {
+	if opts.UserDefined, err = layer.intercept(ctx, bucket, object, -1, opts); err != nil {
+		return "", err
+	}
+	return layer.ObjectLayer.NewMultipartUpload(ctx, bucket, object, opts)
+}
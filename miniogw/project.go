@@ -0,0 +1,116 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"io"
+
+	"storj.io/uplink"
+)
+
+// Project is the part of uplink.Project that the gateway uses. It lets the
+// object layer run against other implementations, like the mocks of unit
+// tests.
+type Project interface {
+	StatBucket(ctx context.Context, bucket string) (*uplink.Bucket, error)
+	CreateBucket(ctx context.Context, bucket string) (*uplink.Bucket, error)
+	DeleteBucket(ctx context.Context, bucket string) (*uplink.Bucket, error)
+	DeleteBucketWithObjects(ctx context.Context, bucket string) (*uplink.Bucket, error)
+	ListBuckets(ctx context.Context, options *uplink.ListBucketsOptions) BucketIterator
+
+	StatObject(ctx context.Context, bucket, key string) (*uplink.Object, error)
+	DeleteObject(ctx context.Context, bucket, key string) (*uplink.Object, error)
+	ListObjects(ctx context.Context, bucket string, options *uplink.ListObjectsOptions) ObjectIterator
+	UploadObject(ctx context.Context, bucket, key string, options *uplink.UploadOptions) (Upload, error)
+	DownloadObject(ctx context.Context, bucket, key string, options *uplink.DownloadOptions) (Download, error)
+
+	Close() error
+}
+
+// BucketIterator iterates over buckets, like uplink.BucketIterator.
+type BucketIterator interface {
+	Next() bool
+	Item() *uplink.Bucket
+	Err() error
+}
+
+// ObjectIterator iterates over objects, like uplink.ObjectIterator.
+type ObjectIterator interface {
+	Next() bool
+	Item() *uplink.Object
+	Err() error
+}
+
+// Upload is an upload of an object, like uplink.Upload.
+type Upload interface {
+	io.Writer
+	SetCustomMetadata(ctx context.Context, custom uplink.CustomMetadata) error
+	Commit() error
+	Abort() error
+	Info() *uplink.Object
+}
+
+// Download is a download of an object, like uplink.Download.
+type Download interface {
+	io.Reader
+	Close() error
+	Info() *uplink.Object
+}
+
+// OpenProjectFunc opens the project of an access grant, and returns it with
+// the address of its satellite.
+type OpenProjectFunc func(ctx context.Context, accessGrant string) (_ Project, satellite string, err error)
+
+// openUplinkProject returns an OpenProjectFunc that opens uplink projects with
+// config.
+func openUplinkProject(config uplink.Config) OpenProjectFunc {
+	return func(ctx context.Context, accessGrant string) (_ Project, satellite string, err error) {
+		defer mon.Task()(&ctx)(&err)
+
+		access, err := uplink.ParseAccess(accessGrant)
+		if err != nil {
+			return nil, "", err
+		}
+		satellite, err = satelliteAddress(accessGrant)
+		if err != nil {
+			return nil, "", err
+		}
+		project, err := config.OpenProject(ctx, access)
+		if err != nil {
+			return nil, "", err
+		}
+		return uplinkProject{project}, satellite, nil
+	}
+}
+
+// uplinkProject adapts uplink.Project, whose iterators, uploads and downloads
+// are concrete types, to Project.
+type uplinkProject struct {
+	*uplink.Project
+}
+
+func (project uplinkProject) ListBuckets(ctx context.Context, options *uplink.ListBucketsOptions) BucketIterator {
+	return project.Project.ListBuckets(ctx, options)
+}
+
+func (project uplinkProject) ListObjects(ctx context.Context, bucket string, options *uplink.ListObjectsOptions) ObjectIterator {
+	return project.Project.ListObjects(ctx, bucket, options)
+}
+
+func (project uplinkProject) UploadObject(ctx context.Context, bucket, key string, options *uplink.UploadOptions) (Upload, error) {
+	upload, err := project.Project.UploadObject(ctx, bucket, key, options)
+	if err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+func (project uplinkProject) DownloadObject(ctx context.Context, bucket, key string, options *uplink.DownloadOptions) (Download, error) {
+	download, err := project.Project.DownloadObject(ctx, bucket, key, options)
+	if err != nil {
+		return nil, err
+	}
+	return download, nil
+}
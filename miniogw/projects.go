@@ -0,0 +1,192 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcutil/base58"
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
+
+	"storj.io/common/macaroon"
+	"storj.io/common/pb"
+	"storj.io/stargate/internal/tagged"
+	"storj.io/stargate/internal/tracing"
+)
+
+// ProjectsConfig configures how many projects the gateway keeps open. Every
+// access grant gets its own project, with its own connections, so a single
+// gateway can serve access grants of any number of projects and satellites.
+type ProjectsConfig struct {
+	MaxOpen     int           `help:"number of open projects, one per access grant, above which idle projects are closed" default:"1000"`
+	IdleTimeout time.Duration `help:"how long a project counts as in use after its last request, and can't be closed" default:"10m0s"`
+}
+
+// DefaultProjectsConfig is the ProjectsConfig that is used when none is set.
+var DefaultProjectsConfig = ProjectsConfig{
+	MaxOpen:     1000,
+	IdleTimeout: 10 * time.Minute,
+}
+
+// projectPool keeps the projects of access grants open between requests.
+//
+// A project counts as in use until IdleTimeout after its last request, since
+// the readers of downloads keep using it after the request returns. Only
+// projects that aren't in use are closed, so MaxOpen can be exceeded while
+// more projects than that are in use.
+type projectPool struct {
+	open   OpenProjectFunc
+	limits ProjectsConfig
+	health *Health
+
+	mu       sync.Mutex
+	projects map[string]*pooledProject
+}
+
+type pooledProject struct {
+	project   Project
+	satellite string
+	lastUsed  time.Time
+}
+
+func newProjectPool(open OpenProjectFunc, limits ProjectsConfig) *projectPool {
+	return &projectPool{
+		open:     open,
+		limits:   limits,
+		projects: make(map[string]*pooledProject),
+	}
+}
+
+// Get returns the open project for accessKey, opening it if necessary.
+func (pool *projectPool) Get(ctx context.Context, accessKey string) (_ Project, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	now := time.Now()
+
+	pool.mu.Lock()
+	if pooled, ok := pool.projects[accessKey]; ok {
+		pooled.lastUsed = now
+		pool.mu.Unlock()
+		pool.health.Observe(pooled.satellite)
+		return pooled.project, nil
+	}
+	pool.mu.Unlock()
+
+	// the project is opened without holding the lock, since it dials the
+	// satellite
+	project, satellite, err := pool.open(ctx, accessKey)
+	if err != nil {
+		return nil, err
+	}
+	pool.health.Observe(satellite)
+	tagged.Counter(mon, "gateway_projects_opened", monkit.NewSeriesTag("satellite", satellite)).Inc(1)
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	// another request for the same access grant may have won the race
+	if pooled, ok := pool.projects[accessKey]; ok {
+		pooled.lastUsed = now
+		return pooled.project, project.Close()
+	}
+
+	pool.projects[accessKey] = &pooledProject{
+		project:   project,
+		satellite: satellite,
+		lastUsed:  now,
+	}
+	err = pool.evict(now)
+	mon.IntVal("gateway_projects_open").Observe(int64(len(pool.projects)))
+	return project, err
+}
+
+// parseScope returns the scope of the access grant, with the address of the
+// satellite and the API key, which uplink doesn't expose.
+func parseScope(accessGrant string) (*pb.Scope, error) {
+	data, version, err := base58.CheckDecode(accessGrant)
+	if err != nil || version != 0 {
+		return nil, errs.New("invalid access grant format")
+	}
+	var scope pb.Scope
+	if err := pb.Unmarshal(data, &scope); err != nil {
+		return nil, errs.New("invalid access grant: %v", err)
+	}
+	return &scope, nil
+}
+
+// satelliteAddress returns the address of the satellite of the access grant.
+func satelliteAddress(accessGrant string) (string, error) {
+	scope, err := parseScope(accessGrant)
+	if err != nil {
+		return "", err
+	}
+	return scope.SatelliteAddr, nil
+}
+
+// tenantID returns the tenant of the traces of the requests with the access
+// grant, which is derived from the macaroon head of its API key.
+func tenantID(accessGrant string) (string, error) {
+	scope, err := parseScope(accessGrant)
+	if err != nil {
+		return "", err
+	}
+	apiKey, err := macaroon.ParseRawAPIKey(scope.ApiKey)
+	if err != nil {
+		return "", errs.New("invalid api key: %v", err)
+	}
+	return tracing.TenantID(apiKey.Head()), nil
+}
+
+// evict closes the projects that are no longer in use if there are more open
+// projects than the limit, least recently used first. It must be called with
+// the lock held.
+func (pool *projectPool) evict(now time.Time) (err error) {
+	if len(pool.projects) <= pool.limits.MaxOpen {
+		return nil
+	}
+
+	type candidate struct {
+		accessKey string
+		lastUsed  time.Time
+	}
+	var idle []candidate
+	for accessKey, pooled := range pool.projects {
+		if now.Sub(pooled.lastUsed) >= pool.limits.IdleTimeout {
+			idle = append(idle, candidate{accessKey: accessKey, lastUsed: pooled.lastUsed})
+		}
+	}
+	sort.Slice(idle, func(i, k int) bool { return idle[i].lastUsed.Before(idle[k].lastUsed) })
+
+	for _, c := range idle {
+		if len(pool.projects) <= pool.limits.MaxOpen {
+			break
+		}
+		err = errs.Combine(err, pool.close(c.accessKey))
+	}
+	return err
+}
+
+// close closes and forgets the project of accessKey. It must be called with
+// the lock held.
+func (pool *projectPool) close(accessKey string) error {
+	pooled := pool.projects[accessKey]
+	delete(pool.projects, accessKey)
+	tagged.Counter(mon, "gateway_projects_closed", monkit.NewSeriesTag("satellite", pooled.satellite)).Inc(1)
+	return pooled.project.Close()
+}
+
+// Close closes every project.
+func (pool *projectPool) Close() (err error) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	for accessKey := range pool.projects {
+		err = errs.Combine(err, pool.close(accessKey))
+	}
+	return err
+}
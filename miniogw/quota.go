@@ -0,0 +1,419 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/cmd/logger"
+	"github.com/minio/minio/pkg/auth"
+	"github.com/zeebo/errs"
+
+	"storj.io/common/memory"
+)
+
+// QuotaError is the class of errors for invalid bucket quotas.
+var QuotaError = errs.Class("bucket quota")
+
+// anyBucket is the bucket name of the quota of buckets without their own.
+const anyBucket = "*"
+
+// QuotaConfig configures the quotas of buckets.
+type QuotaConfig struct {
+	Buckets           string        `help:"comma separated bucket quotas, like photos=10GiB/100000, which is the bucket, the maximum total size of its objects and the maximum number of objects, either of which may be left out; the bucket * is the quota of every bucket without its own; empty disables quotas" default:""`
+	ReconcileInterval time.Duration `help:"how often the usage of a bucket with a quota is recomputed by listing it; uploads and deletes are accounted for in between, and a listing has to finish within the interval" default:"10m0s"`
+}
+
+// Quota is the maximum usage of a bucket. Zero is unlimited.
+type Quota struct {
+	Bytes   int64
+	Objects int64
+}
+
+// ParseQuotas parses comma separated quotas of the form bucket=size/count,
+// where either the size or the count may be left out, like photos=10GiB or
+// logs=/1000.
+func ParseQuotas(s string) (map[string]Quota, error) {
+	quotas := make(map[string]Quota)
+	for _, entry := range ParseList(s) {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, QuotaError.New("%q is not of the form bucket=size/count", entry)
+		}
+		bucket := strings.TrimSpace(parts[0])
+		if bucket == "" {
+			return nil, QuotaError.New("quota %q has no bucket", entry)
+		}
+		if _, ok := quotas[bucket]; ok {
+			return nil, QuotaError.New("quota of bucket %q is defined more than once", bucket)
+		}
+
+		var quota Quota
+		limits := strings.SplitN(strings.TrimSpace(parts[1]), "/", 2)
+		if size := strings.TrimSpace(limits[0]); size != "" {
+			bytes, err := memory.ParseString(size)
+			if err != nil || bytes <= 0 {
+				return nil, QuotaError.New("quota of bucket %q has invalid size %q", bucket, size)
+			}
+			quota.Bytes = bytes
+		}
+		if len(limits) == 2 {
+			if count := strings.TrimSpace(limits[1]); count != "" {
+				objects, err := strconv.ParseInt(count, 10, 64)
+				if err != nil || objects <= 0 {
+					return nil, QuotaError.New("quota of bucket %q has invalid count %q", bucket, count)
+				}
+				quota.Objects = objects
+			}
+		}
+		if quota == (Quota{}) {
+			return nil, QuotaError.New("quota of bucket %q has no limit", bucket)
+		}
+
+		quotas[bucket] = quota
+	}
+	return quotas, nil
+}
+
+type gatewayQuota struct {
+	minio.Gateway
+	quotas   map[string]Quota
+	interval time.Duration
+
+	mu       sync.Mutex
+	accounts map[usageKey]*quotaAccount
+}
+
+// quotaAccount is the usage of a bucket that is accounted for by the gateway.
+type quotaAccount struct {
+	mu      sync.Mutex
+	known   bool // whether a listing succeeded, since the usage isn't known before
+	bytes   int64
+	objects int64
+	parts   map[string]int64 // the bytes of the parts of unfinished multipart uploads
+
+	reconciled  time.Time
+	reconciling bool
+}
+
+// Quotas returns a wrapper of minio.Gateway that rejects uploads to buckets
+// that would exceed their quotas with minio's bucket quota exceeded error.
+//
+// The usage of a bucket is computed in the background by listing it with the
+// access key of the request whenever it is older than the reconcile interval,
+// and the uploads and deletes through the gateway are accounted for in
+// between. Until a listing succeeds the usage isn't known, and uploads are
+// allowed. Overwritten objects and objects that are changed by other clients
+// are only accounted for by the next listing. Like the usage of the admin api,
+// usage is accounted for per access key.
+func Quotas(gateway minio.Gateway, quotas map[string]Quota, reconcileInterval time.Duration) minio.Gateway {
+	if len(quotas) == 0 {
+		return gateway
+	}
+	return &gatewayQuota{
+		Gateway:  gateway,
+		quotas:   quotas,
+		interval: reconcileInterval,
+		accounts: make(map[usageKey]*quotaAccount),
+	}
+}
+
+func (gateway *gatewayQuota) NewGatewayLayer(creds auth.Credentials) (minio.ObjectLayer, error) {
+	layer, err := gateway.Gateway.NewGatewayLayer(creds)
+	return &layerQuota{ObjectLayer: layer, gateway: gateway}, err
+}
+
+// quota returns the quota of the bucket, and whether it has one.
+func (gateway *gatewayQuota) quota(bucket string) (Quota, bool) {
+	if quota, ok := gateway.quotas[bucket]; ok {
+		return quota, true
+	}
+	quota, ok := gateway.quotas[anyBucket]
+	return quota, ok
+}
+
+// account returns the account of the bucket for the access key of the request.
+func (gateway *gatewayQuota) account(ctx context.Context, bucket string) *quotaAccount {
+	key := usageKey{accessKey: getAccessKey(ctx), bucket: bucket}
+
+	gateway.mu.Lock()
+	defer gateway.mu.Unlock()
+
+	account, ok := gateway.accounts[key]
+	if !ok {
+		account = &quotaAccount{parts: make(map[string]int64)}
+		gateway.accounts[key] = account
+	}
+	return account
+}
+
+// forget drops the account of the bucket, like when the bucket is deleted.
+func (gateway *gatewayQuota) forget(ctx context.Context, bucket string) {
+	gateway.mu.Lock()
+	defer gateway.mu.Unlock()
+
+	delete(gateway.accounts, usageKey{accessKey: getAccessKey(ctx), bucket: bucket})
+}
+
+// layerQuota enforces the quotas of buckets on the uploads of the embedded
+// layer.
+type layerQuota struct {
+	minio.ObjectLayer
+	gateway *gatewayQuota
+}
+
+// check returns an error if storing size more bytes in objects more objects
+// would exceed the quota of the bucket. A negative size is not known yet, so
+// only the number of objects is checked. A reconcile of the account is started
+// when its usage is older than the reconcile interval.
+func (layer *layerQuota) check(ctx context.Context, bucket string, size, objects int64) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	quota, ok := layer.gateway.quota(bucket)
+	if !ok {
+		return nil
+	}
+	if size < 0 {
+		size = 0
+	}
+
+	account := layer.gateway.account(ctx, bucket)
+	account.mu.Lock()
+	defer account.mu.Unlock()
+
+	if !account.reconciling && time.Since(account.reconciled) >= layer.gateway.interval {
+		account.reconciling = true
+		// the listing outlives the request, with the access key of the request
+		go layer.reconcile(logger.SetReqInfo(context.Background(), logger.GetReqInfo(ctx)), bucket, account)
+	}
+	if !account.known {
+		mon.Event("bucket_quota_unknown")
+		return nil
+	}
+
+	if quota.Bytes > 0 && account.bytes+size > quota.Bytes ||
+		quota.Objects > 0 && account.objects+objects > quota.Objects {
+		mon.Event("bucket_quota_exceeded")
+		return minio.BucketQuotaExceeded{Bucket: bucket}
+	}
+	return nil
+}
+
+// reconcile recomputes the usage of the account by listing the bucket, without
+// holding the mutex of the account while listing. The usage isn't known when
+// the listing fails, so that uploads aren't rejected for a usage that may be
+// wrong.
+func (layer *layerQuota) reconcile(ctx context.Context, bucket string, account *quotaAccount) {
+	var err error
+	defer mon.Task()(&ctx)(&err)
+
+	if layer.gateway.interval > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, layer.gateway.interval)
+		defer cancel()
+	}
+
+	bytes, objects, err := layer.usage(ctx, bucket)
+
+	account.mu.Lock()
+	defer account.mu.Unlock()
+
+	account.reconciling = false
+	account.reconciled = time.Now()
+	if err != nil {
+		account.known = false
+		return
+	}
+
+	// the parts of unfinished uploads aren't listed
+	for _, size := range account.parts {
+		bytes += size
+	}
+	account.known = true
+	account.bytes, account.objects = bytes, objects
+}
+
+// usage returns the total size and the number of the objects in the bucket.
+func (layer *layerQuota) usage(ctx context.Context, bucket string) (bytes, objects int64, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	continuationToken := ""
+	for {
+		result, err := layer.ObjectLayer.ListObjectsV2(ctx, bucket, "", continuationToken, "", 1000, false, "")
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, object := range result.Objects {
+			bytes += object.Size
+			objects++
+		}
+		if !result.IsTruncated {
+			return bytes, objects, nil
+		}
+		continuationToken = result.NextContinuationToken
+	}
+}
+
+// record accounts for a change of the usage of the bucket, if it has a quota.
+func (layer *layerQuota) record(ctx context.Context, bucket string, bytes, objects int64) {
+	if _, ok := layer.gateway.quota(bucket); !ok {
+		return
+	}
+
+	account := layer.gateway.account(ctx, bucket)
+	account.mu.Lock()
+	defer account.mu.Unlock()
+
+	account.add(bytes, objects)
+}
+
+// recordPart accounts for an uploaded part of the multipart upload, if the
+// bucket has a quota.
+func (layer *layerQuota) recordPart(ctx context.Context, bucket, uploadID string, bytes int64) {
+	if _, ok := layer.gateway.quota(bucket); !ok {
+		return
+	}
+
+	account := layer.gateway.account(ctx, bucket)
+	account.mu.Lock()
+	defer account.mu.Unlock()
+
+	account.parts[uploadID] += bytes
+	account.add(bytes, 0)
+}
+
+// finishUpload forgets the parts of the multipart upload, since they are
+// either accounted for as the completed object or gone with the aborted
+// upload, and returns their total size.
+func (layer *layerQuota) finishUpload(ctx context.Context, bucket, uploadID string) (bytes int64) {
+	if _, ok := layer.gateway.quota(bucket); !ok {
+		return 0
+	}
+
+	account := layer.gateway.account(ctx, bucket)
+	account.mu.Lock()
+	defer account.mu.Unlock()
+
+	bytes = account.parts[uploadID]
+	delete(account.parts, uploadID)
+	return bytes
+}
+
+// add changes the usage of the account, which must be locked.
+func (account *quotaAccount) add(bytes, objects int64) {
+	account.bytes += bytes
+	account.objects += objects
+	if account.bytes < 0 {
+		account.bytes = 0
+	}
+	if account.objects < 0 {
+		account.objects = 0
+	}
+}
+
+func (layer *layerQuota) PutObject(ctx context.Context, bucket, object string, data *minio.PutObjReader, opts minio.ObjectOptions) (_ minio.ObjectInfo, err error) {
+	size := int64(-1)
+	if data != nil {
+		size = data.Size()
+	}
+	if err := layer.check(ctx, bucket, size, 1); err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	info, err := layer.ObjectLayer.PutObject(ctx, bucket, object, data, opts)
+	if err == nil {
+		layer.record(ctx, bucket, info.Size, 1)
+	}
+	return info, err
+}
+
+func (layer *layerQuota) CopyObject(ctx context.Context, srcBucket, srcObject, destBucket, destObject string, srcInfo minio.ObjectInfo, srcOpts, destOpts minio.ObjectOptions) (_ minio.ObjectInfo, err error) {
+	if err := layer.check(ctx, destBucket, srcInfo.Size, 1); err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	info, err := layer.ObjectLayer.CopyObject(ctx, srcBucket, srcObject, destBucket, destObject, srcInfo, srcOpts, destOpts)
+	if err == nil {
+		layer.record(ctx, destBucket, info.Size, 1)
+	}
+	return info, err
+}
+
+func (layer *layerQuota) NewMultipartUpload(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (uploadID string, err error) {
+	if err := layer.check(ctx, bucket, -1, 1); err != nil {
+		return "", err
+	}
+	return layer.ObjectLayer.NewMultipartUpload(ctx, bucket, object, opts)
+}
+
+func (layer *layerQuota) PutObjectPart(ctx context.Context, bucket, object, uploadID string, partID int, data *minio.PutObjReader, opts minio.ObjectOptions) (_ minio.PartInfo, err error) {
+	size := int64(-1)
+	if data != nil {
+		size = data.Size()
+	}
+	// the object of the upload was checked by NewMultipartUpload
+	if err := layer.check(ctx, bucket, size, 0); err != nil {
+		return minio.PartInfo{}, err
+	}
+
+	info, err := layer.ObjectLayer.PutObjectPart(ctx, bucket, object, uploadID, partID, data, opts)
+	if err == nil {
+		layer.recordPart(ctx, bucket, uploadID, info.Size)
+	}
+	return info, err
+}
+
+func (layer *layerQuota) CompleteMultipartUpload(ctx context.Context, bucket, object, uploadID string, uploadedParts []minio.CompletePart, opts minio.ObjectOptions) (_ minio.ObjectInfo, err error) {
+	info, err := layer.ObjectLayer.CompleteMultipartUpload(ctx, bucket, object, uploadID, uploadedParts, opts)
+	if err == nil {
+		// the parts were accounted for when they were uploaded, and parts
+		// that were left out of the object are gone
+		layer.record(ctx, bucket, info.Size-layer.finishUpload(ctx, bucket, uploadID), 1)
+	}
+	return info, err
+}
+
+func (layer *layerQuota) AbortMultipartUpload(ctx context.Context, bucket, object, uploadID string, opts minio.ObjectOptions) (err error) {
+	err = layer.ObjectLayer.AbortMultipartUpload(ctx, bucket, object, uploadID, opts)
+	if err == nil {
+		layer.record(ctx, bucket, -layer.finishUpload(ctx, bucket, uploadID), 0)
+	}
+	return err
+}
+
+func (layer *layerQuota) DeleteObject(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (_ minio.ObjectInfo, err error) {
+	info, err := layer.ObjectLayer.DeleteObject(ctx, bucket, object, opts)
+	if err == nil {
+		layer.record(ctx, bucket, -info.Size, -1)
+	}
+	return info, err
+}
+
+func (layer *layerQuota) DeleteObjects(ctx context.Context, bucket string, objects []minio.ObjectToDelete, opts minio.ObjectOptions) ([]minio.DeletedObject, []error) {
+	deleted, errors := layer.ObjectLayer.DeleteObjects(ctx, bucket, objects, opts)
+
+	// the sizes of the deleted objects aren't known, so they are only
+	// accounted for by the next listing
+	var count int64
+	for _, err := range errors {
+		if err == nil {
+			count++
+		}
+	}
+	layer.record(ctx, bucket, 0, -count)
+	return deleted, errors
+}
+
+func (layer *layerQuota) DeleteBucket(ctx context.Context, bucket string, forceDelete bool) error {
+	err := layer.ObjectLayer.DeleteBucket(ctx, bucket, forceDelete)
+	if err == nil {
+		layer.gateway.forget(ctx, bucket)
+	}
+	return err
+}
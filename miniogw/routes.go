@@ -0,0 +1,94 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/zeebo/errs"
+
+	"storj.io/stargate/auth"
+	"storj.io/stargate/internal/anomaly"
+	"storj.io/stargate/internal/plugin"
+)
+
+// RoutesConfig configures the routes of access keys.
+type RoutesConfig struct {
+	File string `help:"path of a json file that maps access keys to lists of routes, each with a bucket, an optional prefix and the access grant to use for the objects under it" default:""`
+}
+
+// Router looks up the routes of access keys. Requests for objects that match
+// a route of their access key use the access grant of the route instead.
+type Router interface {
+	// Routes returns the routes of the access key. It is not an error if the
+	// access key has no routes.
+	Routes(ctx context.Context, accessKey string) ([]auth.Route, error)
+}
+
+// StaticRouter is a Router with a fixed set of routes per access key.
+type StaticRouter map[string][]auth.Route
+
+// Routes returns the routes of the access key.
+func (router StaticRouter) Routes(ctx context.Context, accessKey string) ([]auth.Route, error) {
+	return router[accessKey], nil
+}
+
+// LoadRoutes reads and validates the routes of the json file at path, which
+// maps access keys to their routes.
+func LoadRoutes(path string) (StaticRouter, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	var router StaticRouter
+	if err := json.Unmarshal(data, &router); err != nil {
+		return nil, Error.New("invalid routes file %q: %v", path, err)
+	}
+
+	for accessKey, routes := range router {
+		if err := auth.ValidateRoutes(routes); err != nil {
+			return nil, Error.New("invalid routes for access key %q: %v", anomaly.Fingerprint(accessKey), err)
+		}
+	}
+	return router, nil
+}
+
+// route returns the access grant for the key in the bucket: the access grant
+// of the most specific route of the access key, or the access key itself.
+// Lists pass their prefix as the key, so lists above the prefix of a route
+// don't use the route, see auth.Route.
+func (layer *gatewayLayer) route(ctx context.Context, accessKey, bucket, key string) (string, error) {
+	router := layer.gateway.router
+	if router == nil || bucket == "" {
+		return layer.resolve(ctx, accessKey, bucket, key)
+	}
+
+	routes, err := router.Routes(ctx, accessKey)
+	if err != nil {
+		return "", errs.Wrap(err)
+	}
+	if accessGrant, ok := auth.MatchRoute(routes, bucket, key); ok {
+		mon.Event("route_matched")
+		return accessGrant, nil
+	}
+	return layer.resolve(ctx, accessKey, bucket, key)
+}
+
+// resolve returns the access grant that the auth resolver plugins resolve the
+// access key to, or the access key itself. The access grant may come from the
+// cache of the credentials of the gateway if the plugins fail.
+func (layer *gatewayLayer) resolve(ctx context.Context, accessKey, bucket, key string) (string, error) {
+	plugins := layer.gateway.plugins
+	if plugins == nil || !plugins.Implements(plugin.KindAuthResolver) {
+		return accessKey, nil
+	}
+	request := plugin.ResolveRequest{AccessKey: accessKey, Bucket: bucket, Key: key}
+	if credentials := layer.gateway.credentials; credentials != nil {
+		return credentials.Resolve(ctx, request)
+	}
+	return plugins.ResolveAccess(ctx, request)
+}
@@ -0,0 +1,236 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/pkg/auth"
+	"go.uber.org/zap"
+
+	"storj.io/stargate/internal/script"
+)
+
+type gatewayScripted struct {
+	minio.Gateway
+	log  *zap.Logger
+	hook *script.Script
+}
+
+// Scripted returns a wrapper of minio.Gateway that runs the request script
+// for the requests of objects, which can deny them, rewrite their keys and
+// change the metadata of uploads. Denied requests fail with access denied.
+// Copies are passed on as they are, since the gateway doesn't implement them.
+func Scripted(gateway minio.Gateway, log *zap.Logger, hook *script.Script) minio.Gateway {
+	if hook == nil {
+		return gateway
+	}
+	return &gatewayScripted{Gateway: gateway, log: log, hook: hook}
+}
+
+func (gateway *gatewayScripted) NewGatewayLayer(creds auth.Credentials) (minio.ObjectLayer, error) {
+	layer, err := gateway.Gateway.NewGatewayLayer(creds)
+	return &layerScripted{ObjectLayer: layer, log: gateway.log, hook: gateway.hook}, err
+}
+
+// layerScripted runs the request script for the object requests of the
+// embedded layer.
+type layerScripted struct {
+	minio.ObjectLayer
+	log  *zap.Logger
+	hook *script.Script
+}
+
+// decide runs the script for a request of the object. metadata is nil unless
+// the request is an upload.
+func (layer *layerScripted) decide(ctx context.Context, operation, bucket, object string, metadata map[string]string) (script.Decision, error) {
+	tenant, _ := tenantID(getAccessKey(ctx))
+	decision, err := layer.hook.Decide(ctx, script.Request{
+		Operation: operation,
+		Bucket:    bucket,
+		Key:       object,
+		Tenant:    tenant,
+		Metadata:  metadata,
+	})
+	if err != nil {
+		layer.log.Error("request script failed",
+			zap.String("operation", operation), zap.String("bucket", bucket), zap.Error(err))
+		return script.Decision{}, err
+	}
+	if decision.Deny {
+		layer.log.Info("request denied by script",
+			zap.String("operation", operation), zap.String("bucket", bucket), zap.String("reason", decision.Reason))
+		return script.Decision{}, minio.PrefixAccessDenied{Bucket: bucket, Object: object}
+	}
+	return decision, nil
+}
+
+// uploadMetadata returns the metadata of an upload for the script.
+func uploadMetadata(opts minio.ObjectOptions) map[string]string {
+	if opts.UserDefined == nil {
+		return map[string]string{}
+	}
+	return opts.UserDefined
+}
+
+// scriptedError replaces the rewritten key in minio errors with the key of
+// the request.
+func scriptedError(err error, object string) error {
+	switch e := err.(type) {
+	case minio.ObjectNotFound:
+		e.Object = object
+		return e
+	case minio.ObjectNameInvalid:
+		e.Object = object
+		return e
+	case minio.ObjectAlreadyExists:
+		e.Object = object
+		return e
+	}
+	return err
+}
+
+func (layer *layerScripted) GetObjectNInfo(ctx context.Context, bucket, object string, rs *minio.HTTPRangeSpec, h http.Header, lockType minio.LockType, opts minio.ObjectOptions) (*minio.GetObjectReader, error) {
+	decision, err := layer.decide(ctx, "GetObjectNInfo", bucket, object, nil)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := layer.ObjectLayer.GetObjectNInfo(ctx, bucket, decision.Key, rs, h, lockType, opts)
+	if err != nil {
+		return nil, scriptedError(err, object)
+	}
+	reader.ObjInfo.Name = object
+	return reader, nil
+}
+
+func (layer *layerScripted) GetObject(ctx context.Context, bucket, object string, startOffset int64, length int64, writer io.Writer, etag string, opts minio.ObjectOptions) error {
+	decision, err := layer.decide(ctx, "GetObject", bucket, object, nil)
+	if err != nil {
+		return err
+	}
+	return scriptedError(layer.ObjectLayer.GetObject(ctx, bucket, decision.Key, startOffset, length, writer, etag, opts), object)
+}
+
+func (layer *layerScripted) GetObjectInfo(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	decision, err := layer.decide(ctx, "GetObjectInfo", bucket, object, nil)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	info, err := layer.ObjectLayer.GetObjectInfo(ctx, bucket, decision.Key, opts)
+	if err != nil {
+		return info, scriptedError(err, object)
+	}
+	info.Name = object
+	return info, nil
+}
+
+func (layer *layerScripted) PutObject(ctx context.Context, bucket, object string, data *minio.PutObjReader, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	decision, err := layer.decide(ctx, "PutObject", bucket, object, uploadMetadata(opts))
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	opts.UserDefined = decision.Metadata
+	info, err := layer.ObjectLayer.PutObject(ctx, bucket, decision.Key, data, opts)
+	if err != nil {
+		return info, scriptedError(err, object)
+	}
+	info.Name = object
+	return info, nil
+}
+
+func (layer *layerScripted) DeleteObject(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	decision, err := layer.decide(ctx, "DeleteObject", bucket, object, nil)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	info, err := layer.ObjectLayer.DeleteObject(ctx, bucket, decision.Key, opts)
+	if err != nil {
+		return info, scriptedError(err, object)
+	}
+	info.Name = object
+	return info, nil
+}
+
+func (layer *layerScripted) DeleteObjects(ctx context.Context, bucket string, objects []minio.ObjectToDelete, opts minio.ObjectOptions) ([]minio.DeletedObject, []error) {
+	deleted := make([]minio.DeletedObject, len(objects))
+	errors := make([]error, len(objects))
+	for i, object := range objects {
+		info, err := layer.DeleteObject(ctx, bucket, object.ObjectName, opts)
+		if err != nil {
+			errors[i] = err
+			continue
+		}
+		deleted[i] = minio.DeletedObject{ObjectName: info.Name}
+	}
+	return deleted, errors
+}
+
+func (layer *layerScripted) NewMultipartUpload(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (string, error) {
+	decision, err := layer.decide(ctx, "NewMultipartUpload", bucket, object, uploadMetadata(opts))
+	if err != nil {
+		return "", err
+	}
+	opts.UserDefined = decision.Metadata
+	uploadID, err := layer.ObjectLayer.NewMultipartUpload(ctx, bucket, decision.Key, opts)
+	return uploadID, scriptedError(err, object)
+}
+
+func (layer *layerScripted) PutObjectPart(ctx context.Context, bucket, object, uploadID string, partID int, data *minio.PutObjReader, opts minio.ObjectOptions) (minio.PartInfo, error) {
+	decision, err := layer.decide(ctx, "PutObjectPart", bucket, object, nil)
+	if err != nil {
+		return minio.PartInfo{}, err
+	}
+	info, err := layer.ObjectLayer.PutObjectPart(ctx, bucket, decision.Key, uploadID, partID, data, opts)
+	return info, scriptedError(err, object)
+}
+
+func (layer *layerScripted) GetMultipartInfo(ctx context.Context, bucket, object, uploadID string, opts minio.ObjectOptions) (minio.MultipartInfo, error) {
+	decision, err := layer.decide(ctx, "GetMultipartInfo", bucket, object, nil)
+	if err != nil {
+		return minio.MultipartInfo{}, err
+	}
+	info, err := layer.ObjectLayer.GetMultipartInfo(ctx, bucket, decision.Key, uploadID, opts)
+	if err != nil {
+		return info, scriptedError(err, object)
+	}
+	info.Object = object
+	return info, nil
+}
+
+func (layer *layerScripted) ListObjectParts(ctx context.Context, bucket, object, uploadID string, partNumberMarker int, maxParts int, opts minio.ObjectOptions) (minio.ListPartsInfo, error) {
+	decision, err := layer.decide(ctx, "ListObjectParts", bucket, object, nil)
+	if err != nil {
+		return minio.ListPartsInfo{}, err
+	}
+	result, err := layer.ObjectLayer.ListObjectParts(ctx, bucket, decision.Key, uploadID, partNumberMarker, maxParts, opts)
+	if err != nil {
+		return result, scriptedError(err, object)
+	}
+	result.Object = object
+	return result, nil
+}
+
+func (layer *layerScripted) AbortMultipartUpload(ctx context.Context, bucket, object, uploadID string, opts minio.ObjectOptions) error {
+	decision, err := layer.decide(ctx, "AbortMultipartUpload", bucket, object, nil)
+	if err != nil {
+		return err
+	}
+	return scriptedError(layer.ObjectLayer.AbortMultipartUpload(ctx, bucket, decision.Key, uploadID, opts), object)
+}
+
+func (layer *layerScripted) CompleteMultipartUpload(ctx context.Context, bucket, object, uploadID string, uploadedParts []minio.CompletePart, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	decision, err := layer.decide(ctx, "CompleteMultipartUpload", bucket, object, nil)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	info, err := layer.ObjectLayer.CompleteMultipartUpload(ctx, bucket, decision.Key, uploadID, uploadedParts, opts)
+	if err != nil {
+		return info, scriptedError(err, object)
+	}
+	info.Name = object
+	return info, nil
+}
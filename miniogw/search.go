@@ -0,0 +1,197 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"strings"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/pkg/auth"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+
+	"storj.io/stargate/internal/metaindex"
+	"storj.io/uplink"
+)
+
+// defaultSearchLimit and maxSearchLimit bound the objects of a search result.
+const (
+	defaultSearchLimit = 100
+	maxSearchLimit     = 1000
+)
+
+type gatewayIndexing struct {
+	minio.Gateway
+	log   *zap.Logger
+	index *metaindex.Index
+}
+
+// Indexing returns a wrapper of minio.Gateway that indexes the keys, tags and
+// user metadata of the objects that it writes, so that Search can find them.
+// Objects are indexed per project, which is the macaroon head of the API key
+// of the access grant, like for the tenants of traces. Objects that are
+// written in other ways, like with other gateways, are not indexed. Failures
+// to update the index are logged, and don't fail requests.
+func Indexing(gateway minio.Gateway, log *zap.Logger, index *metaindex.Index) minio.Gateway {
+	if index == nil {
+		return gateway
+	}
+	return &gatewayIndexing{Gateway: gateway, log: log, index: index}
+}
+
+func (gateway *gatewayIndexing) NewGatewayLayer(creds auth.Credentials) (minio.ObjectLayer, error) {
+	layer, err := gateway.Gateway.NewGatewayLayer(creds)
+	return &layerIndexing{ObjectLayer: layer, log: gateway.log, index: gateway.index}, err
+}
+
+// layerIndexing indexes the objects that the embedded layer writes.
+type layerIndexing struct {
+	minio.ObjectLayer
+	log   *zap.Logger
+	index *metaindex.Index
+}
+
+func (layer *layerIndexing) PutObject(ctx context.Context, bucket, object string, data *minio.PutObjReader, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	info, err := layer.ObjectLayer.PutObject(ctx, bucket, object, data, opts)
+	if err == nil {
+		layer.put(ctx, info)
+	}
+	return info, err
+}
+
+func (layer *layerIndexing) CopyObject(ctx context.Context, srcBucket, srcObject, destBucket, destObject string, srcInfo minio.ObjectInfo, srcOpts, destOpts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	info, err := layer.ObjectLayer.CopyObject(ctx, srcBucket, srcObject, destBucket, destObject, srcInfo, srcOpts, destOpts)
+	if err == nil {
+		layer.put(ctx, info)
+	}
+	return info, err
+}
+
+func (layer *layerIndexing) DeleteObject(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	info, err := layer.ObjectLayer.DeleteObject(ctx, bucket, object, opts)
+	if err == nil {
+		if project, ok := layer.project(ctx); ok {
+			layer.logError(layer.index.Delete(ctx, project, bucket, object))
+		}
+	}
+	return info, err
+}
+
+func (layer *layerIndexing) DeleteObjects(ctx context.Context, bucket string, objects []minio.ObjectToDelete, opts minio.ObjectOptions) ([]minio.DeletedObject, []error) {
+	deleted, deleteErrs := layer.ObjectLayer.DeleteObjects(ctx, bucket, objects, opts)
+	project, ok := layer.project(ctx)
+	if !ok {
+		return deleted, deleteErrs
+	}
+	for i, object := range objects {
+		if i < len(deleteErrs) && deleteErrs[i] == nil {
+			layer.logError(layer.index.Delete(ctx, project, bucket, object.ObjectName))
+		}
+	}
+	return deleted, deleteErrs
+}
+
+func (layer *layerIndexing) DeleteBucket(ctx context.Context, bucket string, forceDelete bool) error {
+	err := layer.ObjectLayer.DeleteBucket(ctx, bucket, forceDelete)
+	if err == nil {
+		if project, ok := layer.project(ctx); ok {
+			layer.logError(layer.index.DeleteBucket(ctx, project, bucket))
+		}
+	}
+	return err
+}
+
+// put indexes a written object.
+func (layer *layerIndexing) put(ctx context.Context, info minio.ObjectInfo) {
+	if project, ok := layer.project(ctx); ok {
+		layer.logError(layer.index.Put(ctx, metaindex.NewEntry(project, info.Bucket, info.Name, info.Size, info.ModTime, info.UserDefined)))
+	}
+}
+
+// project returns the project of the access grant of the request in the
+// index, and whether it has one.
+func (layer *layerIndexing) project(ctx context.Context) (string, bool) {
+	project, err := tenantID(getAccessKey(ctx))
+	if err != nil {
+		layer.logError(err)
+		return "", false
+	}
+	return project, true
+}
+
+func (layer *layerIndexing) logError(err error) {
+	if err != nil {
+		layer.log.Warn("unable to update the metadata index", zap.Error(err))
+	}
+}
+
+// SearchResult is a page of the objects that a search found.
+type SearchResult struct {
+	Objects []metaindex.Entry `json:"objects"`
+	// Next is the key to continue the search after, or empty if there are
+	// no more objects.
+	Next string `json:"next,omitempty"`
+}
+
+// Search searches the objects in the metadata index.
+type Search struct {
+	config uplink.Config
+	index  *metaindex.Index
+}
+
+// NewSearch constructs a Search that searches index, and opens projects with
+// config.
+func NewSearch(config uplink.Config, index *metaindex.Index) *Search {
+	return &Search{config: config, index: index}
+}
+
+// Search returns the objects of the query in a bucket of the project of the
+// access grant. The index has what the gateway wrote with any access grant of
+// the project, so the access grant has to be able to list the bucket under the
+// prefix of the query, which is checked with a single listing instead of every
+// object that the index finds. Objects that other clients deleted or changed
+// are found as they were last written through the gateway.
+func (search *Search) Search(ctx context.Context, accessKey string, query metaindex.Query) (_ SearchResult, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	query.Project, err = tenantID(accessKey)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	access, err := uplink.ParseAccess(accessKey)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	project, err := search.config.OpenProject(ctx, access)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	defer func() { err = errs.Combine(err, project.Close()) }()
+
+	// listings take prefixes that end with a slash
+	prefix := query.Prefix[:strings.LastIndexByte(query.Prefix, '/')+1]
+	objects := project.ListObjects(ctx, query.Bucket, &uplink.ListObjectsOptions{Prefix: prefix})
+	objects.Next()
+	if err := objects.Err(); err != nil {
+		return SearchResult{}, convertError(err, query.Bucket, "")
+	}
+
+	if query.Limit <= 0 {
+		query.Limit = defaultSearchLimit
+	}
+	if query.Limit > maxSearchLimit {
+		query.Limit = maxSearchLimit
+	}
+
+	entries, err := search.index.Search(ctx, query)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	result := SearchResult{Objects: entries}
+	if len(entries) == query.Limit {
+		result.Next = entries[len(entries)-1].Key
+	}
+	return result, nil
+}
@@ -0,0 +1,88 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+
+	"go.uber.org/zap"
+
+	"storj.io/stargate/auth"
+	"storj.io/stargate/auth/sigv4"
+)
+
+// SignatureConfig configures the checks of the signatures of requests with
+// the secret keys that the auth service derives from its secret key
+// derivation keys. minio doesn't check signatures, so without them, access key
+// ids work with any secret key.
+type SignatureConfig struct {
+	DerivationKeys  string        `help:"the secret key derivation keys of the auth service, in the format of its secret-key-derivation-key; when set, requests of access key ids are only passed on to minio if their signatures match the secret keys derived from them; disabled when empty" default:""`
+	InternalAddress string        `help:"address that minio listens on while signatures are checked, which only the gateway should reach; the checks serve plain http on server.address, so tls has to end before the gateway" default:"127.0.0.1:7778"`
+	MaxSkew         time.Duration `help:"how far the time of signatures may be off the clock of the gateway" default:"15m0s"`
+}
+
+// Signatures checks the AWS signature version 4 of requests of access key ids
+// before it passes them on to minio. Other requests, like anonymous requests
+// or requests with access grants as their access keys, are passed on as they
+// are.
+type Signatures struct {
+	log      *zap.Logger
+	verifier *sigv4.Verifier
+	next     http.Handler
+}
+
+// NewSignatures constructs Signatures that pass requests on to the minio that
+// listens on the internal address of config.
+func NewSignatures(log *zap.Logger, config SignatureConfig) (*Signatures, error) {
+	keys, err := auth.ParseDerivationKeys(config.DerivationKeys)
+	if err != nil {
+		return nil, err
+	}
+	return &Signatures{
+		log:      log,
+		verifier: sigv4.NewVerifier(keys, config.MaxSkew),
+		next:     httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: config.InternalAddress}),
+	}, nil
+}
+
+// ServeHTTP implements http.Handler.
+func (signatures *Signatures) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	_, _, err := signatures.verifier.Verify(req)
+	switch {
+	case err == nil, sigv4.Unverifiable.Has(err):
+		signatures.next.ServeHTTP(w, req)
+	case sigv4.Mismatch.Has(err):
+		mon.Event("signature_mismatch")
+		writeS3Error(w, http.StatusForbidden, "SignatureDoesNotMatch", err.Error())
+	case sigv4.Skewed.Has(err):
+		mon.Event("signature_skewed")
+		writeS3Error(w, http.StatusForbidden, "RequestTimeTooSkewed", err.Error())
+	default:
+		mon.Event("signature_invalid")
+		signatures.log.Debug("invalid signature", zap.Error(err))
+		writeS3Error(w, http.StatusBadRequest, "AuthorizationHeaderMalformed", err.Error())
+	}
+}
+
+// ServeSignatures serves the checks of signatures on address until ctx is
+// canceled.
+func ServeSignatures(ctx context.Context, log *zap.Logger, address string, signatures *Signatures) (err error) {
+	return serve(ctx, log, "signature checks", address, signatures)
+}
+
+// writeS3Error responds with an error in the format of S3.
+func writeS3Error(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_ = xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{Code: code, Message: message})
+}
@@ -0,0 +1,145 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/pkg/auth"
+
+	"storj.io/common/errs2"
+	"storj.io/stargate/internal/slo"
+)
+
+// Classes of operations for service level objectives.
+const (
+	classRead   = "read"
+	classWrite  = "write"
+	classList   = "list"
+	classDelete = "delete"
+)
+
+type gatewaySLO struct {
+	minio.Gateway
+	tracker *slo.Tracker
+}
+
+// ServiceLevels returns a wrapper of minio.Gateway that tracks its requests
+// against the service level objectives of tracker. Errors that minio returns
+// to the client as such, like a missing object, and canceled requests are
+// not failures. Downloads are timed until the object starts to be sent.
+// The burn rates of tracker are reported with the metrics of this package.
+func ServiceLevels(gateway minio.Gateway, tracker *slo.Tracker) minio.Gateway {
+	mon.Chain(tracker)
+	return &gatewaySLO{Gateway: gateway, tracker: tracker}
+}
+
+func (gateway *gatewaySLO) NewGatewayLayer(creds auth.Credentials) (minio.ObjectLayer, error) {
+	layer, err := gateway.Gateway.NewGatewayLayer(creds)
+	return &layerSLO{ObjectLayer: layer, tracker: gateway.tracker}, err
+}
+
+// layerSLO records the requests of the embedded layer.
+type layerSLO struct {
+	minio.ObjectLayer
+	tracker *slo.Tracker
+}
+
+// start starts a request of the class. The returned func records it.
+func (layer *layerSLO) start(class string) func(err error) {
+	start := time.Now()
+	return func(err error) {
+		failed := err != nil && !minioError(err) && !errs2.IsCanceled(err)
+		layer.tracker.Record(class, time.Now(), time.Since(start), failed)
+	}
+}
+
+func (layer *layerSLO) DeleteBucket(ctx context.Context, bucket string, forceDelete bool) (err error) {
+	done := layer.start(classDelete)
+	defer func() { done(err) }()
+	return layer.ObjectLayer.DeleteBucket(ctx, bucket, forceDelete)
+}
+
+func (layer *layerSLO) DeleteObject(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (_ minio.ObjectInfo, err error) {
+	done := layer.start(classDelete)
+	defer func() { done(err) }()
+	return layer.ObjectLayer.DeleteObject(ctx, bucket, object, opts)
+}
+
+func (layer *layerSLO) DeleteObjects(ctx context.Context, bucket string, objects []minio.ObjectToDelete, opts minio.ObjectOptions) (deleted []minio.DeletedObject, errors []error) {
+	done := layer.start(classDelete)
+	deleted, errors = layer.ObjectLayer.DeleteObjects(ctx, bucket, objects, opts)
+	for _, err := range errors {
+		if err != nil {
+			done(err)
+			return deleted, errors
+		}
+	}
+	done(nil)
+	return deleted, errors
+}
+
+func (layer *layerSLO) GetBucketInfo(ctx context.Context, bucket string) (_ minio.BucketInfo, err error) {
+	done := layer.start(classRead)
+	defer func() { done(err) }()
+	return layer.ObjectLayer.GetBucketInfo(ctx, bucket)
+}
+
+func (layer *layerSLO) GetObjectNInfo(ctx context.Context, bucket, object string, rangeSpec *minio.HTTPRangeSpec, header http.Header, lockType minio.LockType, opts minio.ObjectOptions) (_ *minio.GetObjectReader, err error) {
+	done := layer.start(classRead)
+	defer func() { done(err) }()
+	return layer.ObjectLayer.GetObjectNInfo(ctx, bucket, object, rangeSpec, header, lockType, opts)
+}
+
+func (layer *layerSLO) GetObject(ctx context.Context, bucket, object string, startOffset int64, length int64, writer io.Writer, etag string, opts minio.ObjectOptions) (err error) {
+	done := layer.start(classRead)
+	defer func() { done(err) }()
+	return layer.ObjectLayer.GetObject(ctx, bucket, object, startOffset, length, writer, etag, opts)
+}
+
+func (layer *layerSLO) GetObjectInfo(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (_ minio.ObjectInfo, err error) {
+	done := layer.start(classRead)
+	defer func() { done(err) }()
+	return layer.ObjectLayer.GetObjectInfo(ctx, bucket, object, opts)
+}
+
+func (layer *layerSLO) ListBuckets(ctx context.Context) (_ []minio.BucketInfo, err error) {
+	done := layer.start(classList)
+	defer func() { done(err) }()
+	return layer.ObjectLayer.ListBuckets(ctx)
+}
+
+func (layer *layerSLO) ListObjects(ctx context.Context, bucket, prefix, marker, delimiter string, maxKeys int) (_ minio.ListObjectsInfo, err error) {
+	done := layer.start(classList)
+	defer func() { done(err) }()
+	return layer.ObjectLayer.ListObjects(ctx, bucket, prefix, marker, delimiter, maxKeys)
+}
+
+func (layer *layerSLO) ListObjectsV2(ctx context.Context, bucket, prefix, continuationToken, delimiter string, maxKeys int, fetchOwner bool, startAfter string) (_ minio.ListObjectsV2Info, err error) {
+	done := layer.start(classList)
+	defer func() { done(err) }()
+	return layer.ObjectLayer.ListObjectsV2(ctx, bucket, prefix, continuationToken, delimiter, maxKeys, fetchOwner, startAfter)
+}
+
+func (layer *layerSLO) MakeBucketWithLocation(ctx context.Context, bucket string, opts minio.BucketOptions) (err error) {
+	done := layer.start(classWrite)
+	defer func() { done(err) }()
+	return layer.ObjectLayer.MakeBucketWithLocation(ctx, bucket, opts)
+}
+
+func (layer *layerSLO) PutObject(ctx context.Context, bucket, object string, data *minio.PutObjReader, opts minio.ObjectOptions) (_ minio.ObjectInfo, err error) {
+	done := layer.start(classWrite)
+	defer func() { done(err) }()
+	return layer.ObjectLayer.PutObject(ctx, bucket, object, data, opts)
+}
+
+func (layer *layerSLO) CopyObject(ctx context.Context, srcBucket, srcObject, destBucket, destObject string, srcInfo minio.ObjectInfo, srcOpts, destOpts minio.ObjectOptions) (_ minio.ObjectInfo, err error) {
+	done := layer.start(classWrite)
+	defer func() { done(err) }()
+	return layer.ObjectLayer.CopyObject(ctx, srcBucket, srcObject, destBucket, destObject, srcInfo, srcOpts, destOpts)
+}
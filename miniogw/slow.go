@@ -0,0 +1,222 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/pkg/auth"
+	"github.com/spacemonkeygo/monkit/v3"
+	"go.uber.org/zap"
+
+	"storj.io/stargate/internal/tagged"
+)
+
+// SlowConfig configures which requests are logged as slow.
+type SlowConfig struct {
+	Threshold         time.Duration `help:"duration above which requests are logged as slow with where the time was spent; 0 disables slow request logging" default:"0s"`
+	TransferThreshold time.Duration `help:"duration above which uploads and downloads are logged as slow" default:"5m0s"`
+}
+
+// requestTiming accumulates where the time of a request is spent. The time
+// that isn't spent resolving the access key or transferring object data is
+// spent waiting for the satellite, for the most part.
+type requestTiming struct {
+	start time.Time
+
+	mu       sync.Mutex
+	auth     time.Duration
+	transfer time.Duration
+}
+
+type timingKey struct{}
+
+// withTiming returns a context that accumulates the timing of a request.
+func withTiming(ctx context.Context) (context.Context, *requestTiming) {
+	timing := &requestTiming{start: time.Now()}
+	return context.WithValue(ctx, timingKey{}, timing), timing
+}
+
+// getTiming returns the timing of the request of ctx, which is nil unless slow
+// requests are logged.
+func getTiming(ctx context.Context) *requestTiming {
+	timing, _ := ctx.Value(timingKey{}).(*requestTiming)
+	return timing
+}
+
+// measureAuth adds the time until the returned func is called to the time
+// spent resolving the access key of the request of ctx.
+func measureAuth(ctx context.Context) func() {
+	timing, start := getTiming(ctx), time.Now()
+	return func() {
+		if timing != nil {
+			timing.mu.Lock()
+			timing.auth += time.Since(start)
+			timing.mu.Unlock()
+		}
+	}
+}
+
+// measureTransfer adds the time until the returned func is called to the time
+// spent transferring object data for the request of ctx.
+func measureTransfer(ctx context.Context) func() {
+	timing, start := getTiming(ctx), time.Now()
+	return func() {
+		if timing != nil {
+			timing.mu.Lock()
+			timing.transfer += time.Since(start)
+			timing.mu.Unlock()
+		}
+	}
+}
+
+// timedReader adds the time spent reading to the transfer time of a request.
+type timedReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (reader timedReader) Read(p []byte) (int, error) {
+	defer measureTransfer(reader.ctx)()
+	return reader.reader.Read(p)
+}
+
+type gatewaySlow struct {
+	minio.Gateway
+	log    *zap.Logger
+	config SlowConfig
+}
+
+// SlowRequests returns a wrapper of minio.Gateway that logs and counts the
+// requests that take longer than the thresholds of config, with how long they
+// spent resolving the access key, waiting for the satellite and transferring
+// object data.
+func SlowRequests(gateway minio.Gateway, log *zap.Logger, config SlowConfig) minio.Gateway {
+	if config.Threshold <= 0 {
+		return gateway
+	}
+	return &gatewaySlow{Gateway: gateway, log: log, config: config}
+}
+
+func (gateway *gatewaySlow) NewGatewayLayer(creds auth.Credentials) (minio.ObjectLayer, error) {
+	layer, err := gateway.Gateway.NewGatewayLayer(creds)
+	return &layerSlow{ObjectLayer: layer, gateway: gateway}, err
+}
+
+// layerSlow times the requests of the embedded layer.
+type layerSlow struct {
+	minio.ObjectLayer
+	gateway *gatewaySlow
+}
+
+// start starts timing a request. The returned func logs it if it was slow.
+func (layer *layerSlow) start(ctx context.Context, operation, bucket, object string, transfer bool) (context.Context, func(err error)) {
+	ctx, timing := withTiming(ctx)
+	return ctx, func(err error) {
+		threshold := layer.gateway.config.Threshold
+		if transfer && layer.gateway.config.TransferThreshold > threshold {
+			threshold = layer.gateway.config.TransferThreshold
+		}
+
+		total := time.Since(timing.start)
+		if total < threshold {
+			return
+		}
+
+		timing.mu.Lock()
+		authTime, transferTime := timing.auth, timing.transfer
+		timing.mu.Unlock()
+
+		tagged.Counter(mon, "gateway_slow_requests", monkit.NewSeriesTag("operation", operation)).Inc(1)
+		layer.gateway.log.Warn("slow request",
+			zap.String("operation", operation),
+			zap.String("bucket", bucket),
+			zap.String("object", object),
+			zap.Duration("total", total),
+			zap.Duration("auth", authTime),
+			zap.Duration("satellite", total-authTime-transferTime),
+			zap.Duration("transfer", transferTime),
+			zap.Error(err))
+	}
+}
+
+func (layer *layerSlow) DeleteBucket(ctx context.Context, bucket string, forceDelete bool) (err error) {
+	ctx, done := layer.start(ctx, "DeleteBucket", bucket, "", false)
+	defer func() { done(err) }()
+	return layer.ObjectLayer.DeleteBucket(ctx, bucket, forceDelete)
+}
+
+func (layer *layerSlow) DeleteObject(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (_ minio.ObjectInfo, err error) {
+	ctx, done := layer.start(ctx, "DeleteObject", bucket, object, false)
+	defer func() { done(err) }()
+	return layer.ObjectLayer.DeleteObject(ctx, bucket, object, opts)
+}
+
+func (layer *layerSlow) GetBucketInfo(ctx context.Context, bucket string) (_ minio.BucketInfo, err error) {
+	ctx, done := layer.start(ctx, "GetBucketInfo", bucket, "", false)
+	defer func() { done(err) }()
+	return layer.ObjectLayer.GetBucketInfo(ctx, bucket)
+}
+
+// GetObjectNInfo times the download until its reader is closed, since the
+// object data is read after it returns.
+func (layer *layerSlow) GetObjectNInfo(ctx context.Context, bucket, object string, rangeSpec *minio.HTTPRangeSpec, header http.Header, lockType minio.LockType, opts minio.ObjectOptions) (_ *minio.GetObjectReader, err error) {
+	ctx, done := layer.start(ctx, "GetObjectNInfo", bucket, object, true)
+	reader, err := layer.ObjectLayer.GetObjectNInfo(ctx, bucket, object, rangeSpec, header, lockType, opts)
+	if err != nil {
+		done(err)
+		return nil, err
+	}
+	// the preconditions of opts were checked by the embedded layer
+	return minio.NewGetObjectReaderFromReader(reader, reader.ObjInfo, minio.ObjectOptions{}, func() {
+		done(reader.Close())
+	})
+}
+
+func (layer *layerSlow) GetObject(ctx context.Context, bucket, object string, startOffset int64, length int64, writer io.Writer, etag string, opts minio.ObjectOptions) (err error) {
+	ctx, done := layer.start(ctx, "GetObject", bucket, object, true)
+	defer func() { done(err) }()
+	return layer.ObjectLayer.GetObject(ctx, bucket, object, startOffset, length, writer, etag, opts)
+}
+
+func (layer *layerSlow) GetObjectInfo(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (_ minio.ObjectInfo, err error) {
+	ctx, done := layer.start(ctx, "GetObjectInfo", bucket, object, false)
+	defer func() { done(err) }()
+	return layer.ObjectLayer.GetObjectInfo(ctx, bucket, object, opts)
+}
+
+func (layer *layerSlow) ListBuckets(ctx context.Context) (_ []minio.BucketInfo, err error) {
+	ctx, done := layer.start(ctx, "ListBuckets", "", "", false)
+	defer func() { done(err) }()
+	return layer.ObjectLayer.ListBuckets(ctx)
+}
+
+func (layer *layerSlow) ListObjects(ctx context.Context, bucket, prefix, marker, delimiter string, maxKeys int) (_ minio.ListObjectsInfo, err error) {
+	ctx, done := layer.start(ctx, "ListObjects", bucket, prefix, false)
+	defer func() { done(err) }()
+	return layer.ObjectLayer.ListObjects(ctx, bucket, prefix, marker, delimiter, maxKeys)
+}
+
+func (layer *layerSlow) ListObjectsV2(ctx context.Context, bucket, prefix, continuationToken, delimiter string, maxKeys int, fetchOwner bool, startAfter string) (_ minio.ListObjectsV2Info, err error) {
+	ctx, done := layer.start(ctx, "ListObjectsV2", bucket, prefix, false)
+	defer func() { done(err) }()
+	return layer.ObjectLayer.ListObjectsV2(ctx, bucket, prefix, continuationToken, delimiter, maxKeys, fetchOwner, startAfter)
+}
+
+func (layer *layerSlow) MakeBucketWithLocation(ctx context.Context, bucket string, opts minio.BucketOptions) (err error) {
+	ctx, done := layer.start(ctx, "MakeBucketWithLocation", bucket, "", false)
+	defer func() { done(err) }()
+	return layer.ObjectLayer.MakeBucketWithLocation(ctx, bucket, opts)
+}
+
+func (layer *layerSlow) PutObject(ctx context.Context, bucket, object string, data *minio.PutObjReader, opts minio.ObjectOptions) (_ minio.ObjectInfo, err error) {
+	ctx, done := layer.start(ctx, "PutObject", bucket, object, true)
+	defer func() { done(err) }()
+	return layer.ObjectLayer.PutObject(ctx, bucket, object, data, opts)
+}
@@ -0,0 +1,205 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/pkg/auth"
+
+	"storj.io/common/memory"
+)
+
+// StreamingConfig configures the streaming of media objects, like videos.
+type StreamingConfig struct {
+	ContentTypes      string      `help:"comma separated content types, or prefixes of them like video/, whose downloads are optimized for media players" default:""`
+	ChunkSize         memory.Size `help:"most bytes that are returned for an open-ended range request, like the bytes=0- probe of video players, for the streamed content types" default:"8MiB"`
+	ReadAhead         memory.Size `help:"how much of a streamed download is read ahead of the client, or 0 to read nothing ahead" default:"1MiB"`
+	TimingAllowOrigin string      `help:"Timing-Allow-Origin header of streamed downloads, so that players on other origins can see their resource timing" default:"*"`
+}
+
+type gatewayStreaming struct {
+	minio.Gateway
+	config       StreamingConfig
+	contentTypes []string
+}
+
+// Streaming returns a wrapper of minio.Gateway that optimizes the downloads
+// of objects of the configured content types for media players. HTML5 video
+// players probe objects with open-ended ranges, like bytes=0-, and then only
+// read what they play before they seek with another range, so the downloads
+// of open-ended ranges are limited to a chunk, that is returned as a partial
+// response whose Content-Range tells the player the size of the object. The
+// downloads are read ahead of the client, so that playback doesn't stall on
+// the latency of the network, and they advertise that ranges are accepted and
+// that their resource timing can be seen from other origins.
+func Streaming(gateway minio.Gateway, config StreamingConfig) minio.Gateway {
+	contentTypes := ParseList(config.ContentTypes)
+	if len(contentTypes) == 0 {
+		return gateway
+	}
+	return &gatewayStreaming{Gateway: gateway, config: config, contentTypes: contentTypes}
+}
+
+func (gateway *gatewayStreaming) NewGatewayLayer(creds auth.Credentials) (minio.ObjectLayer, error) {
+	layer, err := gateway.Gateway.NewGatewayLayer(creds)
+	return &layerStreaming{ObjectLayer: layer, config: gateway.config, contentTypes: gateway.contentTypes}, err
+}
+
+// layerStreaming optimizes the downloads of media objects of the embedded
+// layer.
+type layerStreaming struct {
+	minio.ObjectLayer
+	config       StreamingConfig
+	contentTypes []string
+}
+
+// streamed returns whether objects of the content type are streamed.
+func (layer *layerStreaming) streamed(contentType string) bool {
+	contentType = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	if contentType == "" {
+		return false
+	}
+	for _, streamed := range layer.contentTypes {
+		if strings.HasPrefix(contentType, strings.ToLower(streamed)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (layer *layerStreaming) GetObjectNInfo(ctx context.Context, bucket, object string, rangeSpec *minio.HTTPRangeSpec, header http.Header, lockType minio.LockType, opts minio.ObjectOptions) (_ *minio.GetObjectReader, err error) {
+	// only open-ended ranges are limited, which needs the size and the
+	// content type of the object before the download
+	if chunkSize := layer.config.ChunkSize.Int64(); chunkSize > 0 && rangeSpec != nil &&
+		!rangeSpec.IsSuffixLength && rangeSpec.Start >= 0 && rangeSpec.End == -1 {
+		info, err := layer.ObjectLayer.GetObjectInfo(ctx, bucket, object, opts)
+		if err != nil {
+			return nil, err
+		}
+		if layer.streamed(info.ContentType) && info.Size-rangeSpec.Start > chunkSize {
+			// minio sets the Content-Range of the response from the same range
+			// spec after the download starts, so it has to be changed in place
+			rangeSpec.End = rangeSpec.Start + chunkSize - 1
+			mon.Event("streaming_range_limited")
+		}
+	}
+
+	reader, err := layer.ObjectLayer.GetObjectNInfo(ctx, bucket, object, rangeSpec, header, lockType, opts)
+	if err != nil || !layer.streamed(reader.ObjInfo.ContentType) {
+		return reader, err
+	}
+
+	// minio sets headers for the user defined metadata that doesn't have the
+	// prefix of user metadata as they are
+	userDefined := make(map[string]string, len(reader.ObjInfo.UserDefined)+2)
+	for k, v := range reader.ObjInfo.UserDefined {
+		userDefined[k] = v
+	}
+	userDefined["Accept-Ranges"] = "bytes"
+	if layer.config.TimingAllowOrigin != "" {
+		userDefined["Timing-Allow-Origin"] = layer.config.TimingAllowOrigin
+	}
+	reader.ObjInfo.UserDefined = userDefined
+
+	readAhead := layer.config.ReadAhead.Int()
+	if readAhead <= 0 {
+		return reader, nil
+	}
+
+	ahead := newReadAheadReader(reader, readAhead)
+	streamed, err := minio.NewGetObjectReaderFromReader(ahead, reader.ObjInfo, opts, ahead.Close)
+	if err != nil {
+		ahead.Close()
+		return nil, err
+	}
+	return streamed, nil
+}
+
+// readAheadChunk is a chunk that a readAheadReader read ahead, and the error
+// that came after it.
+type readAheadChunk struct {
+	data []byte
+	err  error
+}
+
+// readAheadReader reads from a reader ahead of its client, so that the client
+// doesn't wait for the reader after every read.
+type readAheadReader struct {
+	source io.ReadCloser
+	chunks chan readAheadChunk
+	done   chan struct{}
+	closed sync.Once
+	wg     sync.WaitGroup
+
+	current []byte
+	err     error
+}
+
+// newReadAheadReader starts reading source ahead in chunks of size.
+func newReadAheadReader(source io.ReadCloser, size int) *readAheadReader {
+	reader := &readAheadReader{
+		source: source,
+		chunks: make(chan readAheadChunk, 1),
+		done:   make(chan struct{}),
+	}
+	reader.wg.Add(1)
+	go reader.run(size)
+	return reader
+}
+
+// run reads chunks until the source ends or the reader is closed.
+func (reader *readAheadReader) run(size int) {
+	defer reader.wg.Done()
+	defer close(reader.chunks)
+
+	for {
+		data := make([]byte, size)
+		n, err := io.ReadFull(reader.source, data)
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		select {
+		case reader.chunks <- readAheadChunk{data: data[:n], err: err}:
+		case <-reader.done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Read implements io.Reader.
+func (reader *readAheadReader) Read(p []byte) (int, error) {
+	for len(reader.current) == 0 {
+		if reader.err != nil {
+			return 0, reader.err
+		}
+		chunk, ok := <-reader.chunks
+		if !ok {
+			return 0, io.ErrUnexpectedEOF
+		}
+		reader.current, reader.err = chunk.data, chunk.err
+	}
+	n := copy(p, reader.current)
+	reader.current = reader.current[n:]
+	return n, nil
+}
+
+// Close stops reading ahead and closes the source. It is a func(), so that it
+// can clean up a minio.GetObjectReader.
+func (reader *readAheadReader) Close() {
+	reader.closed.Do(func() {
+		close(reader.done)
+		// closing the source unblocks the read ahead that waits for it
+		_ = reader.source.Close()
+		reader.wg.Wait()
+	})
+}
@@ -0,0 +1,96 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/pkg/auth"
+)
+
+// TimingConfig configures the Server-Timing headers that are returned for
+// debugging.
+type TimingConfig struct {
+	Enabled bool   `help:"return Server-Timing headers with where the time of a download was spent when the request has the debug header" default:"false"`
+	Header  string `help:"request header that asks for Server-Timing headers" default:"X-Stargate-Debug-Timing"`
+}
+
+type gatewayTiming struct {
+	minio.Gateway
+	header string
+}
+
+// ServerTiming returns a wrapper of minio.Gateway that returns Server-Timing
+// headers with how long downloads spent resolving the access key and waiting
+// for the satellite, when the request has the header of config.
+//
+// minio only passes the request headers of downloads to the gateway, so other
+// requests don't get Server-Timing headers. The headers are sent before the
+// object data, so the transfer of the data is not included either.
+func ServerTiming(gateway minio.Gateway, config TimingConfig) minio.Gateway {
+	if !config.Enabled || config.Header == "" {
+		return gateway
+	}
+	return &gatewayTiming{Gateway: gateway, header: config.Header}
+}
+
+func (gateway *gatewayTiming) NewGatewayLayer(creds auth.Credentials) (minio.ObjectLayer, error) {
+	layer, err := gateway.Gateway.NewGatewayLayer(creds)
+	return &layerTiming{ObjectLayer: layer, header: gateway.header}, err
+}
+
+// layerTiming adds Server-Timing headers to the downloads of the embedded
+// layer.
+type layerTiming struct {
+	minio.ObjectLayer
+	header string
+}
+
+func (layer *layerTiming) GetObjectNInfo(ctx context.Context, bucket, object string, rangeSpec *minio.HTTPRangeSpec, header http.Header, lockType minio.LockType, opts minio.ObjectOptions) (_ *minio.GetObjectReader, err error) {
+	if header.Get(layer.header) == "" {
+		return layer.ObjectLayer.GetObjectNInfo(ctx, bucket, object, rangeSpec, header, lockType, opts)
+	}
+
+	// reuse the timing of slow request logging, if it is enabled
+	timing := getTiming(ctx)
+	if timing == nil {
+		ctx, timing = withTiming(ctx)
+	}
+
+	reader, err := layer.ObjectLayer.GetObjectNInfo(ctx, bucket, object, rangeSpec, header, lockType, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// minio sets headers for the user defined metadata that doesn't have the
+	// prefix of user metadata as they are
+	userDefined := make(map[string]string, len(reader.ObjInfo.UserDefined)+1)
+	for k, v := range reader.ObjInfo.UserDefined {
+		userDefined[k] = v
+	}
+	userDefined["Server-Timing"] = timing.serverTiming()
+	reader.ObjInfo.UserDefined = userDefined
+
+	return reader, nil
+}
+
+// serverTiming formats the time spent so far as the value of a Server-Timing
+// header.
+func (timing *requestTiming) serverTiming() string {
+	total := time.Since(timing.start)
+
+	timing.mu.Lock()
+	authTime, transferTime := timing.auth, timing.transfer
+	timing.mu.Unlock()
+
+	milliseconds := func(d time.Duration) float64 {
+		return float64(d) / float64(time.Millisecond)
+	}
+	return fmt.Sprintf("auth;desc=\"access key\";dur=%.3f, satellite;desc=\"metadata\";dur=%.3f, total;dur=%.3f",
+		milliseconds(authTime), milliseconds(total-authTime-transferTime), milliseconds(total))
+}
@@ -0,0 +1,226 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/pkg/auth"
+)
+
+// TombstonesConfig configures the caching of deletes.
+type TombstonesConfig struct {
+	TTL        time.Duration `help:"how long deleted objects are remembered, so that retried deletes return without asking the satellite and listings don't return the deleted objects; 0 disables it" default:"0s"`
+	MaxEntries int           `help:"most deleted objects that are remembered" default:"100000"`
+}
+
+// tombstoneKey is a deleted object of the project of an access key.
+type tombstoneKey struct {
+	accessKey string
+	bucket    string
+	object    string
+}
+
+// tombstones are the recently deleted objects.
+type tombstones struct {
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	expires map[tombstoneKey]time.Time
+}
+
+// add remembers that the object was deleted. When there are too many deleted
+// objects, the object is only remembered if some of them have expired.
+func (tombstones *tombstones) add(key tombstoneKey) {
+	now := time.Now()
+
+	tombstones.mu.Lock()
+	defer tombstones.mu.Unlock()
+
+	if len(tombstones.expires) >= tombstones.maxEntries {
+		for other, expires := range tombstones.expires {
+			if !now.Before(expires) {
+				delete(tombstones.expires, other)
+			}
+		}
+		if len(tombstones.expires) >= tombstones.maxEntries {
+			mon.Event("tombstones_full")
+			return
+		}
+	}
+	tombstones.expires[key] = now.Add(tombstones.ttl)
+}
+
+// has returns whether the object was deleted recently.
+func (tombstones *tombstones) has(key tombstoneKey) bool {
+	tombstones.mu.Lock()
+	defer tombstones.mu.Unlock()
+
+	expires, ok := tombstones.expires[key]
+	if ok && !time.Now().Before(expires) {
+		delete(tombstones.expires, key)
+		return false
+	}
+	return ok
+}
+
+// remove forgets that the object was deleted, because it was uploaded again.
+func (tombstones *tombstones) remove(key tombstoneKey) {
+	tombstones.mu.Lock()
+	defer tombstones.mu.Unlock()
+
+	delete(tombstones.expires, key)
+}
+
+type gatewayTombstones struct {
+	minio.Gateway
+	tombstones *tombstones
+}
+
+// Tombstones returns a wrapper of minio.Gateway that remembers the objects
+// that were deleted for the ttl of config. SDKs with aggressive retry policies
+// retry deletes whose responses they didn't get, and those retries return
+// without asking the satellite again. Listings leave the deleted objects out,
+// so that they don't flicker back in while the deletion settles.
+//
+// The objects are remembered for the access key that deleted them. Uploads
+// and copies with the same access key forget them again, but an object that
+// is uploaded again with another access key isn't listed with the access key
+// that deleted it until the ttl expires.
+func Tombstones(gateway minio.Gateway, config TombstonesConfig) minio.Gateway {
+	if config.TTL <= 0 || config.MaxEntries <= 0 {
+		return gateway
+	}
+	return &gatewayTombstones{
+		Gateway: gateway,
+		tombstones: &tombstones{
+			ttl:        config.TTL,
+			maxEntries: config.MaxEntries,
+			expires:    make(map[tombstoneKey]time.Time),
+		},
+	}
+}
+
+func (gateway *gatewayTombstones) NewGatewayLayer(creds auth.Credentials) (minio.ObjectLayer, error) {
+	layer, err := gateway.Gateway.NewGatewayLayer(creds)
+	return &layerTombstones{ObjectLayer: layer, tombstones: gateway.tombstones}, err
+}
+
+// layerTombstones remembers the objects that the embedded layer deleted.
+type layerTombstones struct {
+	minio.ObjectLayer
+	tombstones *tombstones
+}
+
+// key returns the tombstone key of the object for the request of ctx.
+func (layer *layerTombstones) key(ctx context.Context, bucket, object string) tombstoneKey {
+	return tombstoneKey{accessKey: getAccessKey(ctx), bucket: bucket, object: object}
+}
+
+func (layer *layerTombstones) DeleteObject(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	key := layer.key(ctx, bucket, object)
+	if layer.tombstones.has(key) {
+		mon.Event("tombstone_delete_skipped")
+		return minio.ObjectInfo{Bucket: bucket, Name: object}, nil
+	}
+
+	info, err := layer.ObjectLayer.DeleteObject(ctx, bucket, object, opts)
+	if err == nil || errors.As(err, &minio.ObjectNotFound{}) {
+		layer.tombstones.add(key)
+	}
+	return info, err
+}
+
+func (layer *layerTombstones) DeleteObjects(ctx context.Context, bucket string, objects []minio.ObjectToDelete, opts minio.ObjectOptions) ([]minio.DeletedObject, []error) {
+	deleted := make([]minio.DeletedObject, len(objects))
+	errs := make([]error, len(objects))
+
+	// only the objects that weren't deleted recently are deleted
+	var remaining []minio.ObjectToDelete
+	var indexes []int
+	for i, object := range objects {
+		if layer.tombstones.has(layer.key(ctx, bucket, object.ObjectName)) {
+			mon.Event("tombstone_delete_skipped")
+			deleted[i].ObjectName = object.ObjectName
+			continue
+		}
+		remaining = append(remaining, object)
+		indexes = append(indexes, i)
+	}
+	if len(remaining) == 0 {
+		return deleted, errs
+	}
+
+	remainingDeleted, remainingErrs := layer.ObjectLayer.DeleteObjects(ctx, bucket, remaining, opts)
+	for k, i := range indexes {
+		if k < len(remainingDeleted) {
+			deleted[i] = remainingDeleted[k]
+		}
+		if k < len(remainingErrs) {
+			errs[i] = remainingErrs[k]
+		}
+		if errs[i] == nil {
+			layer.tombstones.add(layer.key(ctx, bucket, remaining[k].ObjectName))
+		}
+	}
+	return deleted, errs
+}
+
+func (layer *layerTombstones) PutObject(ctx context.Context, bucket, object string, data *minio.PutObjReader, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	info, err := layer.ObjectLayer.PutObject(ctx, bucket, object, data, opts)
+	if err == nil {
+		layer.tombstones.remove(layer.key(ctx, bucket, object))
+	}
+	return info, err
+}
+
+func (layer *layerTombstones) CopyObject(ctx context.Context, srcBucket, srcObject, destBucket, destObject string, srcInfo minio.ObjectInfo, srcOpts, destOpts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	info, err := layer.ObjectLayer.CopyObject(ctx, srcBucket, srcObject, destBucket, destObject, srcInfo, srcOpts, destOpts)
+	if err == nil {
+		layer.tombstones.remove(layer.key(ctx, destBucket, destObject))
+	}
+	return info, err
+}
+
+func (layer *layerTombstones) CompleteMultipartUpload(ctx context.Context, bucket, object, uploadID string, uploadedParts []minio.CompletePart, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	info, err := layer.ObjectLayer.CompleteMultipartUpload(ctx, bucket, object, uploadID, uploadedParts, opts)
+	if err == nil {
+		layer.tombstones.remove(layer.key(ctx, bucket, object))
+	}
+	return info, err
+}
+
+// withoutDeleted returns the objects that weren't deleted recently.
+func (layer *layerTombstones) withoutDeleted(ctx context.Context, bucket string, objects []minio.ObjectInfo) []minio.ObjectInfo {
+	kept := objects[:0]
+	for _, object := range objects {
+		if layer.tombstones.has(layer.key(ctx, bucket, object.Name)) {
+			mon.Event("tombstone_listing_hidden")
+			continue
+		}
+		kept = append(kept, object)
+	}
+	return kept
+}
+
+func (layer *layerTombstones) ListObjects(ctx context.Context, bucket, prefix, marker, delimiter string, maxKeys int) (minio.ListObjectsInfo, error) {
+	result, err := layer.ObjectLayer.ListObjects(ctx, bucket, prefix, marker, delimiter, maxKeys)
+	if err == nil {
+		result.Objects = layer.withoutDeleted(ctx, bucket, result.Objects)
+	}
+	return result, err
+}
+
+func (layer *layerTombstones) ListObjectsV2(ctx context.Context, bucket, prefix, continuationToken, delimiter string, maxKeys int, fetchOwner bool, startAfter string) (minio.ListObjectsV2Info, error) {
+	result, err := layer.ObjectLayer.ListObjectsV2(ctx, bucket, prefix, continuationToken, delimiter, maxKeys, fetchOwner, startAfter)
+	if err == nil {
+		result.Objects = layer.withoutDeleted(ctx, bucket, result.Objects)
+	}
+	return result, err
+}
@@ -0,0 +1,169 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/zeebo/errs"
+
+	"storj.io/uplink"
+)
+
+// BucketUsage is the number and total size of the objects in a bucket. It is
+// encoded like madmin.BucketUsageInfo.
+type BucketUsage struct {
+	Size         uint64 `json:"size"`
+	ObjectsCount uint64 `json:"objectsCount"`
+}
+
+// DataUsage is the usage of every bucket an access grant can list. It is
+// encoded like madmin.DataUsageInfo.
+type DataUsage struct {
+	LastUpdate       time.Time              `json:"lastUpdate"`
+	ObjectsCount     uint64                 `json:"objectsCount"`
+	ObjectsTotalSize uint64                 `json:"objectsTotalSize"`
+	BucketsCount     uint64                 `json:"bucketsCount"`
+	BucketsUsage     map[string]BucketUsage `json:"bucketsUsageInfo"`
+}
+
+// Usage computes the usage of buckets by listing them, and caches the results
+// because listing large buckets is expensive.
+type Usage struct {
+	config uplink.Config
+	ttl    time.Duration
+
+	mu    sync.Mutex
+	cache map[usageKey]usageEntry
+}
+
+type usageKey struct {
+	accessKey string
+	bucket    string
+}
+
+type usageEntry struct {
+	usage    BucketUsage
+	computed time.Time
+}
+
+// NewUsage constructs a Usage that caches results for ttl.
+func NewUsage(config uplink.Config, ttl time.Duration) *Usage {
+	return &Usage{
+		config: config,
+		ttl:    ttl,
+		cache:  make(map[usageKey]usageEntry),
+	}
+}
+
+// BucketUsage returns the usage of a bucket, and when it was computed.
+func (usage *Usage) BucketUsage(ctx context.Context, accessKey, bucket string) (_ BucketUsage, computed time.Time, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	project, err := usage.openProject(ctx, accessKey)
+	if err != nil {
+		return BucketUsage{}, time.Time{}, err
+	}
+	defer func() { err = errs.Combine(err, project.Close()) }()
+
+	if _, err := project.StatBucket(ctx, bucket); err != nil {
+		return BucketUsage{}, time.Time{}, convertError(err, bucket, "")
+	}
+
+	entry, err := usage.bucketUsage(ctx, project, accessKey, bucket)
+	return entry.usage, entry.computed, err
+}
+
+// DataUsage returns the usage of every bucket that the access grant can list.
+func (usage *Usage) DataUsage(ctx context.Context, accessKey string) (_ DataUsage, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	project, err := usage.openProject(ctx, accessKey)
+	if err != nil {
+		return DataUsage{}, err
+	}
+	defer func() { err = errs.Combine(err, project.Close()) }()
+
+	info := DataUsage{BucketsUsage: make(map[string]BucketUsage)}
+	buckets := project.ListBuckets(ctx, nil)
+	for buckets.Next() {
+		name := buckets.Item().Name
+		entry, err := usage.bucketUsage(ctx, project, accessKey, name)
+		if err != nil {
+			return DataUsage{}, err
+		}
+
+		info.BucketsUsage[name] = entry.usage
+		info.BucketsCount++
+		info.ObjectsCount += entry.usage.ObjectsCount
+		info.ObjectsTotalSize += entry.usage.Size
+		// the oldest result is when all of them were last up to date
+		if info.LastUpdate.IsZero() || entry.computed.Before(info.LastUpdate) {
+			info.LastUpdate = entry.computed
+		}
+	}
+	if err := buckets.Err(); err != nil {
+		return DataUsage{}, convertError(err, "", "")
+	}
+	if info.LastUpdate.IsZero() {
+		info.LastUpdate = time.Now()
+	}
+	return info, nil
+}
+
+// bucketUsage returns the cached usage of a bucket, or computes it if there is
+// no cached usage or it is older than the ttl.
+func (usage *Usage) bucketUsage(ctx context.Context, project *uplink.Project, accessKey, bucket string) (_ usageEntry, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	key := usageKey{accessKey: accessKey, bucket: bucket}
+	now := time.Now()
+
+	usage.mu.Lock()
+	entry, ok := usage.cache[key]
+	usage.mu.Unlock()
+	if ok && now.Sub(entry.computed) < usage.ttl {
+		mon.Event("usage_cache_hit")
+		return entry, nil
+	}
+
+	entry = usageEntry{computed: now}
+	objects := project.ListObjects(ctx, bucket, &uplink.ListObjectsOptions{
+		Recursive: true,
+		System:    true,
+	})
+	for objects.Next() {
+		item := objects.Item()
+		if item.IsPrefix {
+			continue
+		}
+		entry.usage.ObjectsCount++
+		entry.usage.Size += uint64(item.System.ContentLength)
+	}
+	if err := objects.Err(); err != nil {
+		return usageEntry{}, convertError(err, bucket, "")
+	}
+
+	usage.mu.Lock()
+	defer usage.mu.Unlock()
+	for key, cached := range usage.cache {
+		if now.Sub(cached.computed) >= usage.ttl {
+			delete(usage.cache, key)
+		}
+	}
+	usage.cache[key] = entry
+	return entry, nil
+}
+
+func (usage *Usage) openProject(ctx context.Context, accessKey string) (_ *uplink.Project, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	access, err := uplink.ParseAccess(accessKey)
+	if err != nil {
+		return nil, err
+	}
+	return usage.config.OpenProject(ctx, access)
+}
@@ -7,6 +7,7 @@ replace storj.io/stargate => ../
 require (
 	github.com/btcsuite/btcutil v1.0.2
 	github.com/minio/minio v0.0.0-20200808024306-2a9819aff876
+	github.com/minio/minio-go/v6 v6.0.58-0.20200612001654-a57fec8037ec
 	github.com/stretchr/testify v1.6.1
 	github.com/zeebo/errs v1.2.2
 	go.uber.org/zap v1.16.0
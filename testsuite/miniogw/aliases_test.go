@@ -0,0 +1,138 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/cmd/logger"
+	"github.com/minio/minio/pkg/auth"
+	"github.com/minio/minio/pkg/hash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+	"storj.io/stargate/miniogw"
+	"storj.io/storj/private/testplanet"
+	"storj.io/uplink"
+)
+
+func TestParseAliases(t *testing.T) {
+	aliases, err := miniogw.ParseAliases("")
+	require.NoError(t, err)
+	assert.Empty(t, aliases)
+
+	aliases, err = miniogw.ParseAliases("old=new, vanity=bucket/some/prefix/,nested=bucket/dir")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]miniogw.Alias{
+		"old":    {Bucket: "new"},
+		"vanity": {Bucket: "bucket", Prefix: "some/prefix/"},
+		"nested": {Bucket: "bucket", Prefix: "dir/"},
+	}, aliases)
+
+	for _, invalid := range []string{
+		"old",
+		"=bucket",
+		"a/b=bucket",
+		"old=",
+		"old=/prefix",
+		"same=same",
+		"old=a,old=b",
+	} {
+		_, err := miniogw.ParseAliases(invalid)
+		assert.True(t, miniogw.AliasError.Has(err), invalid)
+	}
+}
+
+func TestAliases(t *testing.T) {
+	testplanet.Run(t, testplanet.Config{
+		SatelliteCount: 1, StorageNodeCount: 4, UplinkCount: 1,
+		NonParallel: true,
+	}, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet) {
+		gateway := miniogw.Aliasing(miniogw.NewStorjGateway(uplink.Config{}), map[string]miniogw.Alias{
+			"renamed": {Bucket: TestBucket},
+			"vanity":  {Bucket: TestBucket, Prefix: "vanity/"},
+			"missing": {Bucket: DestBucket},
+		})
+		layer, err := gateway.NewGatewayLayer(auth.Credentials{})
+		require.NoError(t, err)
+		defer ctx.Check(func() error { return layer.Shutdown(ctx) })
+
+		access := planet.Uplinks[0].Access[planet.Satellites[0].ID()]
+		accessKey, err := access.Serialize()
+		require.NoError(t, err)
+		reqCtx := logger.SetReqInfo(ctx, &logger.ReqInfo{AccessKey: accessKey})
+
+		project, err := uplink.OpenProject(ctx, access)
+		require.NoError(t, err)
+		defer ctx.Check(project.Close)
+
+		_, err = project.CreateBucket(ctx, TestBucket)
+		require.NoError(t, err)
+		_, err = createFile(ctx, project, TestBucket, TestFile, []byte("test"), nil)
+		require.NoError(t, err)
+		_, err = createFile(ctx, project, TestBucket, "vanity/"+TestFile2, []byte("test"), nil)
+		require.NoError(t, err)
+
+		// aliases of existing buckets are listed like buckets
+		buckets, err := layer.ListBuckets(reqCtx)
+		require.NoError(t, err)
+		var names []string
+		for _, bucket := range buckets {
+			names = append(names, bucket.Name)
+		}
+		assert.Equal(t, []string{"renamed", TestBucket, "vanity"}, names)
+
+		info, err := layer.GetBucketInfo(reqCtx, "vanity")
+		require.NoError(t, err)
+		assert.Equal(t, "vanity", info.Name)
+
+		_, err = layer.GetBucketInfo(reqCtx, "missing")
+		assert.Equal(t, minio.BucketNotFound{Bucket: "missing"}, err)
+
+		// objects of an alias without a prefix are the objects of the bucket
+		object, err := layer.GetObjectInfo(reqCtx, "renamed", TestFile, minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "renamed", object.Bucket)
+		assert.Equal(t, TestFile, object.Name)
+
+		// objects of an alias with a prefix are the objects under the prefix
+		list, err := layer.ListObjectsV2(reqCtx, "vanity", "", "", "/", 100, false, "")
+		require.NoError(t, err)
+		require.Len(t, list.Objects, 1)
+		assert.Equal(t, "vanity", list.Objects[0].Bucket)
+		assert.Equal(t, TestFile2, list.Objects[0].Name)
+		assert.Empty(t, list.Prefixes)
+
+		_, err = layer.GetObjectInfo(reqCtx, "vanity", TestFile, minio.ObjectOptions{})
+		assert.Equal(t, minio.ObjectNotFound{Bucket: "vanity", Object: TestFile}, err)
+
+		hashReader, err := hash.NewReader(bytes.NewReader([]byte("test")), 4, "", "", 4, true)
+		require.NoError(t, err)
+		_, err = layer.PutObject(reqCtx, "vanity", TestFile3, minio.NewPutObjReader(hashReader, nil, nil), minio.ObjectOptions{})
+		require.NoError(t, err)
+		_, err = project.StatObject(ctx, TestBucket, "vanity/"+TestFile3)
+		require.NoError(t, err)
+
+		// copies are passed on to the gateway, which doesn't implement them
+		_, err = layer.CopyObject(reqCtx, "renamed", TestFile, "vanity", DestFile, minio.ObjectInfo{}, minio.ObjectOptions{}, minio.ObjectOptions{})
+		assert.Equal(t, minio.NotImplemented{}, err)
+
+		_, err = layer.DeleteObject(reqCtx, "vanity", TestFile3, minio.ObjectOptions{})
+		require.NoError(t, err)
+		_, err = project.StatObject(ctx, TestBucket, "vanity/"+TestFile3)
+		require.True(t, errors.Is(err, uplink.ErrObjectNotFound))
+
+		// the buckets of aliases are managed by the operator
+		err = layer.MakeBucketWithLocation(reqCtx, "vanity", minio.BucketOptions{})
+		assert.Equal(t, minio.BucketAlreadyOwnedByYou{Bucket: "vanity"}, err)
+		err = layer.DeleteBucket(reqCtx, "renamed", true)
+		assert.Equal(t, minio.PrefixAccessDenied{Bucket: "renamed"}, err)
+		_, err = project.StatBucket(ctx, TestBucket)
+		require.NoError(t, err)
+	})
+}
@@ -0,0 +1,163 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"storj.io/common/testcontext"
+	"storj.io/stargate/internal/plugin"
+	"storj.io/stargate/miniogw"
+)
+
+// fakeResolver resolves access keys to grants until it is made to fail.
+type fakeResolver struct {
+	err   error
+	calls int
+}
+
+func (resolver *fakeResolver) resolve(ctx context.Context, request plugin.ResolveRequest) (string, error) {
+	resolver.calls++
+	if resolver.err != nil {
+		return "", resolver.err
+	}
+	return "grant-" + request.AccessKey, nil
+}
+
+func TestCredentialsDegraded(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	resolver := &fakeResolver{}
+	credentials := miniogw.NewCredentials(zaptest.NewLogger(t), miniogw.DegradedConfig{
+		MaxStaleness: time.Hour,
+		MaxEntries:   10,
+	}, resolver.resolve)
+
+	first := plugin.ResolveRequest{AccessKey: "first"}
+	grant, err := credentials.Resolve(ctx, first)
+	require.NoError(t, err)
+	assert.Equal(t, "grant-first", grant)
+	assert.Equal(t, miniogw.AuthHealth{Available: true}, credentials.Health())
+
+	// resolved access keys are served from cache while the resolvers fail
+	resolver.err = plugin.Unavailable.New("auth service unreachable")
+	grant, err = credentials.Resolve(ctx, first)
+	require.NoError(t, err)
+	assert.Equal(t, "grant-first", grant)
+
+	_, err = credentials.Resolve(ctx, plugin.ResolveRequest{AccessKey: "second"})
+	assert.Equal(t, resolver.err, err)
+
+	health := credentials.Health()
+	assert.False(t, health.Available)
+	assert.True(t, health.Degraded)
+	assert.NotNil(t, health.FailingSince)
+	assert.Equal(t, "unavailable: auth service unreachable", health.Error)
+	assert.EqualValues(t, 1, health.ServedStale)
+
+	// Check retries the last failed request, and notices the recovery
+	calls := resolver.calls
+	credentials.Check(ctx)
+	assert.Equal(t, calls+1, resolver.calls)
+	assert.False(t, credentials.Health().Available)
+
+	resolver.err = nil
+	credentials.Check(ctx)
+	assert.Equal(t, miniogw.AuthHealth{Available: true}, credentials.Health())
+
+	// nothing is retried while the resolvers work
+	calls = resolver.calls
+	credentials.Check(ctx)
+	assert.Equal(t, calls, resolver.calls)
+}
+
+func TestCredentialsNotDegraded(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	// without a max staleness nothing is served from cache
+	resolver := &fakeResolver{}
+	credentials := miniogw.NewCredentials(zaptest.NewLogger(t), miniogw.DegradedConfig{MaxEntries: 10}, resolver.resolve)
+
+	request := plugin.ResolveRequest{AccessKey: "first"}
+	_, err := credentials.Resolve(ctx, request)
+	require.NoError(t, err)
+
+	resolver.err = plugin.Unavailable.New("auth service unreachable")
+	_, err = credentials.Resolve(ctx, request)
+	assert.Equal(t, resolver.err, err)
+
+	health := credentials.Health()
+	assert.False(t, health.Available)
+	assert.False(t, health.Degraded)
+}
+
+func TestCredentialsMaxEntries(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	resolver := &fakeResolver{}
+	credentials := miniogw.NewCredentials(zaptest.NewLogger(t), miniogw.DegradedConfig{
+		MaxStaleness: time.Hour,
+		MaxEntries:   2,
+	}, resolver.resolve)
+
+	requests := []plugin.ResolveRequest{{AccessKey: "a"}, {AccessKey: "b"}, {AccessKey: "c"}}
+	for _, request := range requests {
+		_, err := credentials.Resolve(ctx, request)
+		require.NoError(t, err)
+	}
+
+	// only two of the three access grants stay cached
+	resolver.err = plugin.Unavailable.New("auth service unreachable")
+	var served int
+	for _, request := range requests {
+		if _, err := credentials.Resolve(ctx, request); err == nil {
+			served++
+		}
+	}
+	assert.Equal(t, 2, served)
+}
+
+func TestCredentialsRevoked(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	resolver := &fakeResolver{}
+	credentials := miniogw.NewCredentials(zaptest.NewLogger(t), miniogw.DegradedConfig{
+		MaxStaleness: time.Hour,
+		MaxEntries:   10,
+	}, resolver.resolve)
+
+	request := plugin.ResolveRequest{AccessKey: "first"}
+	_, err := credentials.Resolve(ctx, request)
+	require.NoError(t, err)
+
+	// access keys that the resolvers deny aren't served from cache, and the
+	// resolvers still work
+	resolver.err = errors.New("access key revoked")
+	_, err = credentials.Resolve(ctx, request)
+	assert.Equal(t, resolver.err, err)
+	assert.Equal(t, miniogw.AuthHealth{Available: true}, credentials.Health())
+
+	// nor once the resolvers are unavailable afterwards
+	resolver.err = plugin.Unavailable.New("auth service unreachable")
+	_, err = credentials.Resolve(ctx, request)
+	assert.Equal(t, resolver.err, err)
+	assert.EqualValues(t, 0, credentials.Health().ServedStale)
+}
+
+func TestNilCredentials(t *testing.T) {
+	var credentials *miniogw.Credentials
+	credentials.Check(context.Background())
+	assert.Equal(t, miniogw.AuthHealth{Available: true}, credentials.Health())
+}
@@ -0,0 +1,115 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"testing"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/pkg/auth"
+	"github.com/minio/minio/pkg/hash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+	"storj.io/stargate/miniogw"
+	"storj.io/uplink"
+)
+
+func TestDeduplicating(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	// the gateway isn't wrapped unless deduplication is enabled
+	gateway := miniogw.NewStorjGateway(uplink.Config{})
+	assert.Equal(t, gateway, miniogw.Deduplicating(gateway, false, nil))
+
+	objects := &objectsLayer{objects: make(map[string]minio.ObjectInfo)}
+	layer, err := miniogw.Deduplicating(objectsGateway{layer: objects}, true, nil).NewGatewayLayer(auth.Credentials{})
+	require.NoError(t, err)
+	put := func(data *minio.PutObjReader, metadata map[string]string) minio.ObjectInfo {
+		info, err := layer.PutObject(ctx, TestBucket, TestFile, data, minio.ObjectOptions{UserDefined: metadata})
+		require.NoError(t, err)
+		return info
+	}
+
+	// the first upload is stored, and uploading it again is skipped
+	first := put(md5Reader(t, "test"), map[string]string{"content-type": "text/plain"})
+	again := put(md5Reader(t, "test"), map[string]string{"content-type": "text/plain"})
+	assert.Equal(t, first, again)
+	assert.Equal(t, 1, objects.puts[TestBucket])
+
+	// uploads of other data or with other metadata are stored
+	put(md5Reader(t, "other"), map[string]string{"content-type": "text/plain"})
+	assert.Equal(t, 2, objects.puts[TestBucket])
+	put(md5Reader(t, "other"), map[string]string{"content-type": "application/octet-stream"})
+	assert.Equal(t, 3, objects.puts[TestBucket])
+
+	// the etag that the gateway adds to the metadata isn't compared
+	objects.objects[TestBucket+"/"+TestFile].UserDefined["s3:etag"] = objects.objects[TestBucket+"/"+TestFile].ETag
+	put(md5Reader(t, "other"), map[string]string{"content-type": "application/octet-stream"})
+	assert.Equal(t, 3, objects.puts[TestBucket])
+
+	// uploads without a Content-MD5 can't be compared, so they are stored
+	hashReader, err := hash.NewReader(bytes.NewReader([]byte("other")), 5, "", "", 5, true)
+	require.NoError(t, err)
+	put(minio.NewPutObjReader(hashReader, nil, nil), map[string]string{"content-type": "application/octet-stream"})
+	assert.Equal(t, 4, objects.puts[TestBucket])
+}
+
+// md5Reader returns the reader of an upload of data with its Content-MD5.
+func md5Reader(t *testing.T, data string) *minio.PutObjReader {
+	sum := md5.Sum([]byte(data))
+	size := int64(len(data))
+	hashReader, err := hash.NewReader(bytes.NewReader([]byte(data)), size, hex.EncodeToString(sum[:]), "", size, true)
+	require.NoError(t, err)
+	return minio.NewPutObjReader(hashReader, nil, nil)
+}
+
+type objectsGateway struct {
+	minio.Gateway
+	layer *objectsLayer
+}
+
+func (gateway objectsGateway) NewGatewayLayer(creds auth.Credentials) (minio.ObjectLayer, error) {
+	return gateway.layer, nil
+}
+
+// objectsLayer keeps the infos of the objects of every bucket, and counts the
+// uploads to each bucket.
+type objectsLayer struct {
+	minio.ObjectLayer
+
+	objects map[string]minio.ObjectInfo
+	puts    map[string]int
+}
+
+func (layer *objectsLayer) GetObjectInfo(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	info, ok := layer.objects[bucket+"/"+object]
+	if !ok {
+		return minio.ObjectInfo{}, minio.ObjectNotFound{Bucket: bucket, Object: object}
+	}
+	return info, nil
+}
+
+func (layer *objectsLayer) PutObject(ctx context.Context, bucket, object string, data *minio.PutObjReader, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	if layer.puts == nil {
+		layer.puts = make(map[string]int)
+	}
+	layer.puts[bucket]++
+
+	info := minio.ObjectInfo{
+		Bucket:      bucket,
+		Name:        object,
+		Size:        data.Size(),
+		ETag:        data.MD5HexString(),
+		UserDefined: opts.UserDefined,
+	}
+	layer.objects[bucket+"/"+object] = info
+	return info, nil
+}
@@ -0,0 +1,88 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/cmd/logger"
+	"github.com/minio/minio/pkg/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+	"storj.io/stargate/miniogw"
+)
+
+func TestLoadFeatures(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	load := func(contents string) (*miniogw.Features, error) {
+		path := filepath.Join(ctx.Dir(), "features.json")
+		require.NoError(t, ioutil.WriteFile(path, []byte(contents), 0644))
+		return miniogw.LoadFeatures(path)
+	}
+
+	for _, invalid := range []string{
+		`not json`,
+		`{"unknown": {"all": true}}`,
+		`{"dedup": null}`,
+		`{"dedup": {"percent": 101}}`,
+	} {
+		_, err := load(invalid)
+		assert.Error(t, err, invalid)
+	}
+
+	features, err := load(`{"dedup": {"access_keys": ["key"], "buckets": ["bucket"]}}`)
+	require.NoError(t, err)
+	assert.True(t, features.Has(miniogw.FeatureDedup))
+
+	keyCtx := logger.SetReqInfo(ctx, &logger.ReqInfo{AccessKey: "key"})
+	otherCtx := logger.SetReqInfo(ctx, &logger.ReqInfo{AccessKey: "other"})
+	assert.True(t, features.Enabled(keyCtx, miniogw.FeatureDedup, "elsewhere"))
+	assert.True(t, features.Enabled(otherCtx, miniogw.FeatureDedup, "bucket"))
+	assert.False(t, features.Enabled(otherCtx, miniogw.FeatureDedup, "elsewhere"))
+
+	// percents enable the feature for the same access keys every time
+	features, err = load(`{"dedup": {"percent": 50}}`)
+	require.NoError(t, err)
+	assert.Equal(t,
+		features.Enabled(keyCtx, miniogw.FeatureDedup, "bucket"),
+		features.Enabled(keyCtx, miniogw.FeatureDedup, "other"))
+
+	// no features are enabled without flags
+	var none *miniogw.Features
+	assert.False(t, none.Has(miniogw.FeatureDedup))
+	assert.False(t, none.Enabled(keyCtx, miniogw.FeatureDedup, "bucket"))
+}
+
+func TestDeduplicatingFeature(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	path := filepath.Join(ctx.Dir(), "features.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"dedup": {"buckets": ["`+TestBucket+`"]}}`), 0644))
+	features, err := miniogw.LoadFeatures(path)
+	require.NoError(t, err)
+
+	objects := &objectsLayer{objects: make(map[string]minio.ObjectInfo)}
+	layer, err := miniogw.Deduplicating(objectsGateway{layer: objects}, false, features).NewGatewayLayer(auth.Credentials{})
+	require.NoError(t, err)
+	put := func(bucket string) {
+		_, err := layer.PutObject(ctx, bucket, TestFile, md5Reader(t, "test"), minio.ObjectOptions{})
+		require.NoError(t, err)
+	}
+
+	// only the uploads to the buckets of the flags are deduplicated
+	put(TestBucket)
+	put(TestBucket)
+	assert.Equal(t, 1, objects.puts[TestBucket])
+	put(DestBucket)
+	put(DestBucket)
+	assert.Equal(t, 2, objects.puts[DestBucket])
+}
@@ -661,6 +661,10 @@ func testListObjects(t *testing.T, listObjects func(*testing.T, context.Context,
 		_, err = layer.ListObjects(ctx, "", "", "", "/", 0)
 		assert.Equal(t, minio.BucketNameInvalid{}, err)
 
+		// Check the error when listing objects with negative max keys
+		_, _, _, err = listObjects(t, ctx, layer, TestBucket, "", "", "", -1)
+		assert.IsType(t, minio.InvalidArgument{}, err)
+
 		// Check the error when listing objects in a non-existing bucket
 		_, err = layer.ListObjects(ctx, TestBucket, "", "", "", 0)
 		assert.Equal(t, minio.BucketNotFound{Bucket: TestBucket}, err)
@@ -713,28 +717,33 @@ func testListObjects(t *testing.T, listObjects func(*testing.T, context.Context,
 			{
 				name:      "Basic non-recursive",
 				delimiter: "/",
+				maxKeys:   1000,
 				prefixes:  []string{"a/", "b/", "i/", "j/", "m/", "n/"},
 				objects:   []string{"a", "aa", "b", "bb", "c", "i", "ii", "j", "k", "kk", "l", "mm", "oo"},
 			}, {
 				name:      "Basic non-recursive with non-existing mark",
 				marker:    "`",
 				delimiter: "/",
+				maxKeys:   1000,
 				prefixes:  []string{"a/", "b/", "i/", "j/", "m/", "n/"},
 				objects:   []string{"a", "aa", "b", "bb", "c", "i", "ii", "j", "k", "kk", "l", "mm", "oo"},
 			}, {
 				name:      "Basic non-recursive with existing mark",
 				marker:    "b",
 				delimiter: "/",
+				maxKeys:   1000,
 				prefixes:  []string{"b/", "i/", "j/", "m/", "n/"},
 				objects:   []string{"bb", "c", "i", "ii", "j", "k", "kk", "l", "mm", "oo"},
 			}, {
 				name:      "Basic non-recursive with last mark",
 				marker:    "oo",
 				delimiter: "/",
+				maxKeys:   1000,
 			}, {
 				name:      "Basic non-recursive with past last mark",
 				marker:    "ooa",
 				delimiter: "/",
+				maxKeys:   1000,
 			}, {
 				name:      "Basic non-recursive with max key limit of 1",
 				delimiter: "/",
@@ -808,12 +817,14 @@ func testListObjects(t *testing.T, listObjects func(*testing.T, context.Context,
 				name:      "Prefix non-recursive",
 				prefix:    "a/",
 				delimiter: "/",
+				maxKeys:   1000,
 				objects:   []string{"xa", "xaa", "xb", "xbb", "xc"},
 			}, {
 				name:      "Prefix non-recursive with mark",
 				prefix:    "a/",
 				marker:    "xb",
 				delimiter: "/",
+				maxKeys:   1000,
 				objects:   []string{"xbb", "xc"},
 			}, {
 				name:      "Prefix non-recursive with mark and max keys",
@@ -825,6 +836,7 @@ func testListObjects(t *testing.T, listObjects func(*testing.T, context.Context,
 				objects:   []string{"xb", "xbb"},
 			}, {
 				name:    "Basic recursive",
+				maxKeys: 1000,
 				objects: filePaths,
 			}, {
 				name:    "Basic recursive with mark and max keys",
@@ -832,92 +844,121 @@ func testListObjects(t *testing.T, listObjects func(*testing.T, context.Context,
 				maxKeys: 5,
 				more:    true,
 				objects: []string{"a/xc", "aa", "b", "b/ya", "b/yaa"},
+			}, {
+				name:      "Basic non-recursive with max key limit of 0",
+				delimiter: "/",
+				maxKeys:   0,
+				more:      true,
+			}, {
+				name:    "Basic recursive with max key limit of 0 with last mark",
+				marker:  "oo",
+				maxKeys: 0,
+			}, {
+				name:    "Basic recursive with max key limit above 1000",
+				maxKeys: 5000,
+				objects: filePaths,
 			}, {
 				name:     "list as stat, recursive, object, prefix, and object-with-prefix exist",
 				prefix:   "i",
+				maxKeys:  1000,
 				prefixes: nil,
 				objects:  []string{"i"},
 			}, {
 				name:      "list as stat, nonrecursive, object, prefix, and object-with-prefix exist",
 				prefix:    "i",
 				delimiter: "/",
+				maxKeys:   1000,
 				prefixes:  []string{"i/"},
 				objects:   []string{"i"},
 			}, {
 				name:     "list as stat, recursive, object and prefix exist, no object-with-prefix",
 				prefix:   "j",
+				maxKeys:  1000,
 				prefixes: nil,
 				objects:  []string{"j"},
 			}, {
 				name:      "list as stat, nonrecursive, object and prefix exist, no object-with-prefix",
 				prefix:    "j",
 				delimiter: "/",
+				maxKeys:   1000,
 				prefixes:  []string{"j/"},
 				objects:   []string{"j"},
 			}, {
 				name:     "list as stat, recursive, object and object-with-prefix exist, no prefix",
 				prefix:   "k",
+				maxKeys:  1000,
 				prefixes: nil,
 				objects:  []string{"k"},
 			}, {
 				name:      "list as stat, nonrecursive, object and object-with-prefix exist, no prefix",
 				prefix:    "k",
 				delimiter: "/",
+				maxKeys:   1000,
 				prefixes:  nil,
 				objects:   []string{"k"},
 			}, {
 				name:     "list as stat, recursive, object exists, no object-with-prefix or prefix",
 				prefix:   "l",
+				maxKeys:  1000,
 				prefixes: nil,
 				objects:  []string{"l"},
 			}, {
 				name:      "list as stat, nonrecursive, object exists, no object-with-prefix or prefix",
 				prefix:    "l",
 				delimiter: "/",
+				maxKeys:   1000,
 				prefixes:  nil,
 				objects:   []string{"l"},
 			}, {
 				name:     "list as stat, recursive, prefix, and object-with-prefix exist, no object",
 				prefix:   "m",
+				maxKeys:  1000,
 				prefixes: nil,
 				objects:  nil,
 			}, {
 				name:      "list as stat, nonrecursive, prefix, and object-with-prefix exist, no object",
 				prefix:    "m",
 				delimiter: "/",
+				maxKeys:   1000,
 				prefixes:  []string{"m/"},
 				objects:   nil,
 			}, {
 				name:     "list as stat, recursive, prefix exists, no object-with-prefix, no object",
 				prefix:   "n",
+				maxKeys:  1000,
 				prefixes: nil,
 				objects:  nil,
 			}, {
 				name:      "list as stat, nonrecursive, prefix exists, no object-with-prefix, no object",
 				prefix:    "n",
 				delimiter: "/",
+				maxKeys:   1000,
 				prefixes:  []string{"n/"},
 				objects:   nil,
 			}, {
 				name:     "list as stat, recursive, object-with-prefix exists, no prefix, no object",
 				prefix:   "o",
+				maxKeys:  1000,
 				prefixes: nil,
 				objects:  nil,
 			}, {
 				name:      "list as stat, nonrecursive, object-with-prefix exists, no prefix, no object",
 				prefix:    "o",
 				delimiter: "/",
+				maxKeys:   1000,
 				prefixes:  nil,
 				objects:   nil,
 			}, {
 				name:     "list as stat, recursive, no object-with-prefix or prefix or object",
 				prefix:   "p",
+				maxKeys:  1000,
 				prefixes: nil,
 				objects:  nil,
 			}, {
 				name:      "list as stat, nonrecursive, no object-with-prefix or prefix or object",
 				prefix:    "p",
 				delimiter: "/",
+				maxKeys:   1000,
 				prefixes:  nil,
 				objects:   nil,
 			},
@@ -958,6 +999,58 @@ func testListObjects(t *testing.T, listObjects func(*testing.T, context.Context,
 				}
 			}
 		}
+
+		// Pages have at most 1000 keys, and listings continue after them
+		var manyPaths []string
+		for i := 0; i < 1001; i++ {
+			manyPaths = append(manyPaths, fmt.Sprintf("many/%04d", i))
+		}
+		for _, filePath := range manyPaths {
+			_, err := createFile(ctx, project, testBucketInfo.Name, filePath, []byte("test"), nil)
+			require.NoError(t, err)
+		}
+
+		for i, tt := range []struct {
+			name    string
+			marker  string
+			maxKeys int
+			more    bool
+			objects []string
+		}{
+			{
+				name:    "max key limit of 1000",
+				maxKeys: 1000,
+				more:    true,
+				objects: manyPaths[:1000],
+			}, {
+				name:    "max key limit above 1000",
+				maxKeys: 1001,
+				more:    true,
+				objects: manyPaths[:1000],
+			}, {
+				name:    "max key limit above 1000 continued",
+				marker:  strings.TrimPrefix(manyPaths[999], "many/"),
+				maxKeys: 1001,
+				objects: manyPaths[1000:],
+			}, {
+				name:    "max key limit of 0",
+				maxKeys: 0,
+				more:    true,
+			},
+		} {
+			errTag := fmt.Sprintf("%d. %s", i, tt.name)
+
+			prefixes, objects, isTruncated, err := listObjects(t, ctx, layer, TestBucket, "many/", tt.marker, "", tt.maxKeys)
+			if assert.NoError(t, err, errTag) {
+				assert.Equal(t, tt.more, isTruncated, errTag)
+				assert.Empty(t, prefixes, errTag)
+				names := make([]string, 0, len(objects))
+				for _, objectInfo := range objects {
+					names = append(names, objectInfo.Name)
+				}
+				assert.Equal(t, append([]string{}, tt.objects...), names, errTag)
+			}
+		}
 	})
 }
 
@@ -0,0 +1,70 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"storj.io/stargate/miniogw"
+)
+
+func TestHealth(t *testing.T) {
+	ctx := context.Background()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	satellite := "1SYXsAycDPUu4z2ZksJD5fh5nTDcH3vCFHnpcVye5XuL1NrYV@" + listener.Addr().String()
+
+	health := miniogw.NewHealth(zaptest.NewLogger(t), miniogw.HealthConfig{
+		Satellites: satellite,
+		Timeout:    time.Second,
+		Recent:     time.Hour,
+	})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		health.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec
+	}
+
+	// configured satellites must be checked before the gateway is ready
+	require.Equal(t, http.StatusServiceUnavailable, get("/health/ready").Code)
+	health.Check(ctx)
+	require.Equal(t, http.StatusOK, get("/health/ready").Code)
+
+	// an unreachable satellite of a request is reported, but doesn't make the
+	// gateway unready
+	health.Observe("127.0.0.1:1")
+	health.Check(ctx)
+	rec := get("/health")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var response struct {
+		Ready      bool                      `json:"ready"`
+		Satellites []miniogw.SatelliteHealth `json:"satellites"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	require.True(t, response.Ready)
+	require.Len(t, response.Satellites, 2)
+	require.False(t, response.Satellites[0].Reachable)
+	require.NotEmpty(t, response.Satellites[0].Error)
+	require.True(t, response.Satellites[1].Reachable)
+
+	// an unreachable configured satellite does
+	require.NoError(t, listener.Close())
+	health.Check(ctx)
+	require.Equal(t, http.StatusServiceUnavailable, get("/health/ready").Code)
+	require.Equal(t, http.StatusServiceUnavailable, get("/health").Code)
+
+	require.Equal(t, http.StatusNotFound, get("/other").Code)
+}
@@ -0,0 +1,96 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw_test
+
+import (
+	"bytes"
+	"testing"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/cmd/logger"
+	"github.com/minio/minio/pkg/auth"
+	"github.com/minio/minio/pkg/hash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+	"storj.io/stargate/miniogw"
+	"storj.io/storj/private/testplanet"
+	"storj.io/uplink"
+)
+
+func TestParseList(t *testing.T) {
+	assert.Empty(t, miniogw.ParseList(""))
+	assert.Equal(t, []string{"a", "b"}, miniogw.ParseList(" a,, b ,"))
+}
+
+func TestImmutable(t *testing.T) {
+	testplanet.Run(t, testplanet.Config{
+		SatelliteCount: 1, StorageNodeCount: 4, UplinkCount: 1,
+		NonParallel: true,
+	}, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet) {
+		access := planet.Uplinks[0].Access[planet.Satellites[0].ID()]
+		accessKey, err := access.Serialize()
+		require.NoError(t, err)
+		reqCtx := logger.SetReqInfo(ctx, &logger.ReqInfo{AccessKey: accessKey})
+
+		newLayer := func(admins ...string) minio.ObjectLayer {
+			gateway := miniogw.Immutable(miniogw.NewStorjGateway(uplink.Config{}), []string{TestBucket}, admins)
+			layer, err := gateway.NewGatewayLayer(auth.Credentials{})
+			require.NoError(t, err)
+			return layer
+		}
+		put := func(layer minio.ObjectLayer, bucket, object string) error {
+			hashReader, err := hash.NewReader(bytes.NewReader([]byte("test")), 4, "", "", 4, true)
+			require.NoError(t, err)
+			_, err = layer.PutObject(reqCtx, bucket, object, minio.NewPutObjReader(hashReader, nil, nil), minio.ObjectOptions{})
+			return err
+		}
+
+		layer := newLayer()
+		defer ctx.Check(func() error { return layer.Shutdown(ctx) })
+
+		require.NoError(t, layer.MakeBucketWithLocation(reqCtx, TestBucket, minio.BucketOptions{}))
+		require.NoError(t, layer.MakeBucketWithLocation(reqCtx, DestBucket, minio.BucketOptions{}))
+
+		// new objects can be uploaded to immutable buckets
+		require.NoError(t, put(layer, TestBucket, TestFile))
+
+		// but not overwritten
+		err = put(layer, TestBucket, TestFile)
+		assert.Equal(t, minio.ObjectAlreadyExists{Bucket: TestBucket, Object: TestFile}, err)
+
+		require.NoError(t, put(layer, DestBucket, DestFile))
+		_, err = layer.CopyObject(reqCtx, DestBucket, DestFile, TestBucket, TestFile, minio.ObjectInfo{}, minio.ObjectOptions{}, minio.ObjectOptions{})
+		assert.Equal(t, minio.ObjectAlreadyExists{Bucket: TestBucket, Object: TestFile}, err)
+		// copies to new keys are passed on to the gateway, which doesn't
+		// implement them
+		_, err = layer.CopyObject(reqCtx, DestBucket, DestFile, TestBucket, TestFile2, minio.ObjectInfo{}, minio.ObjectOptions{}, minio.ObjectOptions{})
+		assert.Equal(t, minio.NotImplemented{}, err)
+
+		// or deleted
+		_, err = layer.DeleteObject(reqCtx, TestBucket, TestFile, minio.ObjectOptions{})
+		assert.Equal(t, minio.PrefixAccessDenied{Bucket: TestBucket, Object: TestFile}, err)
+		_, errs := layer.DeleteObjects(reqCtx, TestBucket, []minio.ObjectToDelete{{ObjectName: TestFile}}, minio.ObjectOptions{})
+		assert.Equal(t, []error{minio.PrefixAccessDenied{Bucket: TestBucket, Object: TestFile}}, errs)
+		err = layer.DeleteBucket(reqCtx, TestBucket, true)
+		assert.Equal(t, minio.PrefixAccessDenied{Bucket: TestBucket}, err)
+
+		_, err = layer.GetObjectInfo(reqCtx, TestBucket, TestFile, minio.ObjectOptions{})
+		require.NoError(t, err)
+
+		// other buckets are not affected
+		require.NoError(t, put(layer, DestBucket, DestFile))
+		_, err = layer.DeleteObject(reqCtx, DestBucket, DestFile, minio.ObjectOptions{})
+		require.NoError(t, err)
+
+		// admins can still overwrite and delete objects
+		admin := newLayer(accessKey)
+		defer ctx.Check(func() error { return admin.Shutdown(ctx) })
+
+		require.NoError(t, put(admin, TestBucket, TestFile))
+		_, err = admin.DeleteObject(reqCtx, TestBucket, TestFile, minio.ObjectOptions{})
+		require.NoError(t, err)
+	})
+}
@@ -0,0 +1,100 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/cmd/logger"
+	"github.com/minio/minio/pkg/auth"
+	"github.com/minio/minio/pkg/hash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+	"storj.io/stargate/miniogw"
+	"storj.io/storj/private/testplanet"
+	"storj.io/uplink"
+)
+
+// loadNamingPolicies loads naming policies from a temporary file with the
+// json data.
+func loadNamingPolicies(t *testing.T, data string) (map[string]*miniogw.NamingPolicy, error) {
+	dir, err := ioutil.TempDir("", "naming")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "naming.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte(data), 0600))
+	return miniogw.LoadNamingPolicies(path)
+}
+
+func TestNamingPolicy(t *testing.T) {
+	policies, err := loadNamingPolicies(t, `{
+		"logs": {"pattern": "^[a-z0-9/._-]+$", "max_depth": 3, "forbidden_characters": "\\:*"}
+	}`)
+	require.NoError(t, err)
+	policy := policies["logs"]
+
+	for _, valid := range []string{"a", "app/2020/10.log", "a/b/"} {
+		assert.NoError(t, policy.Check(valid), valid)
+	}
+	for _, invalid := range []string{"Upper", "a/b/c/d", "a:b", "a*b", "ü"} {
+		assert.True(t, miniogw.NamingError.Has(policy.Check(invalid)), invalid)
+	}
+
+	for _, invalid := range []string{
+		`{"logs": {"pattern": "("}}`,
+		`{"logs": {"max_depth": -1}}`,
+		`{"logs": null}`,
+		`{"logs": `,
+	} {
+		_, err := loadNamingPolicies(t, invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestNaming(t *testing.T) {
+	testplanet.Run(t, testplanet.Config{
+		SatelliteCount: 1, StorageNodeCount: 4, UplinkCount: 1,
+		NonParallel: true,
+	}, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet) {
+		gateway := miniogw.Naming(miniogw.NewStorjGateway(uplink.Config{}), map[string]*miniogw.NamingPolicy{
+			TestBucket: {MaxDepth: 1},
+		})
+		layer, err := gateway.NewGatewayLayer(auth.Credentials{})
+		require.NoError(t, err)
+		defer ctx.Check(func() error { return layer.Shutdown(ctx) })
+
+		access := planet.Uplinks[0].Access[planet.Satellites[0].ID()]
+		accessKey, err := access.Serialize()
+		require.NoError(t, err)
+		reqCtx := logger.SetReqInfo(ctx, &logger.ReqInfo{AccessKey: accessKey})
+
+		put := func(bucket, object string) error {
+			hashReader, err := hash.NewReader(bytes.NewReader([]byte("test")), 4, "", "", 4, true)
+			require.NoError(t, err)
+			_, err = layer.PutObject(reqCtx, bucket, object, minio.NewPutObjReader(hashReader, nil, nil), minio.ObjectOptions{})
+			return err
+		}
+
+		require.NoError(t, layer.MakeBucketWithLocation(reqCtx, TestBucket, minio.BucketOptions{}))
+		require.NoError(t, layer.MakeBucketWithLocation(reqCtx, DestBucket, minio.BucketOptions{}))
+
+		require.NoError(t, put(TestBucket, TestFile))
+		err = put(TestBucket, "nested/"+TestFile)
+		assert.Equal(t, minio.ObjectNameInvalid{Bucket: TestBucket, Object: "nested/" + TestFile}, err)
+
+		_, err = layer.CopyObject(reqCtx, TestBucket, TestFile, TestBucket, "nested/"+TestFile, minio.ObjectInfo{}, minio.ObjectOptions{}, minio.ObjectOptions{})
+		assert.Equal(t, minio.ObjectNameInvalid{Bucket: TestBucket, Object: "nested/" + TestFile}, err)
+
+		// buckets without a policy accept any key
+		require.NoError(t, put(DestBucket, "nested/"+TestFile))
+	})
+}
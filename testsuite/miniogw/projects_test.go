@@ -0,0 +1,59 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw_test
+
+import (
+	"testing"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/cmd/logger"
+	"github.com/minio/minio/pkg/auth"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+	"storj.io/stargate/miniogw"
+	"storj.io/storj/private/testplanet"
+	"storj.io/uplink"
+)
+
+func TestMultipleProjects(t *testing.T) {
+	testplanet.Run(t, testplanet.Config{
+		SatelliteCount: 2, StorageNodeCount: 4, UplinkCount: 1,
+		NonParallel: true,
+	}, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet) {
+		gateway := miniogw.NewStorjGateway(uplink.Config{})
+		gateway.SetProjectsConfig(miniogw.ProjectsConfig{MaxOpen: 1})
+		layer, err := gateway.NewGatewayLayer(auth.Credentials{})
+		require.NoError(t, err)
+		defer ctx.Check(func() error { return layer.Shutdown(ctx) })
+
+		// a single gateway serves the projects of both satellites, keyed by the
+		// access grant of the request
+		for i, satellite := range planet.Satellites {
+			access := planet.Uplinks[0].Access[satellite.ID()]
+			accessKey, err := access.Serialize()
+			require.NoError(t, err)
+
+			reqCtx := logger.SetReqInfo(ctx, &logger.ReqInfo{AccessKey: accessKey})
+			bucket := []string{TestBucket, DestBucket}[i]
+			require.NoError(t, layer.MakeBucketWithLocation(reqCtx, bucket, minio.BucketOptions{}))
+
+			buckets, err := layer.ListBuckets(reqCtx)
+			require.NoError(t, err)
+			require.Len(t, buckets, 1)
+			require.Equal(t, bucket, buckets[0].Name)
+		}
+
+		// projects that are still in use are not closed to stay below MaxOpen
+		for _, satellite := range planet.Satellites {
+			access := planet.Uplinks[0].Access[satellite.ID()]
+			accessKey, err := access.Serialize()
+			require.NoError(t, err)
+
+			reqCtx := logger.SetReqInfo(ctx, &logger.ReqInfo{AccessKey: accessKey})
+			_, err = layer.ListBuckets(reqCtx)
+			require.NoError(t, err)
+		}
+	})
+}
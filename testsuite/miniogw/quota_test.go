@@ -0,0 +1,295 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/pkg/auth"
+	"github.com/minio/minio/pkg/hash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+	"storj.io/stargate/miniogw"
+	"storj.io/uplink"
+)
+
+func TestParseQuotas(t *testing.T) {
+	quotas, err := miniogw.ParseQuotas("")
+	require.NoError(t, err)
+	assert.Empty(t, quotas)
+
+	quotas, err = miniogw.ParseQuotas("photos=10KiB/100, logs=/1000, backups = 1GB, *=1MiB/")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]miniogw.Quota{
+		"photos":  {Bytes: 10 << 10, Objects: 100},
+		"logs":    {Objects: 1000},
+		"backups": {Bytes: 1e9},
+		"*":       {Bytes: 1 << 20},
+	}, quotas)
+
+	for _, invalid := range []string{
+		"photos",
+		"=1GB",
+		"photos=",
+		"photos=/",
+		"photos=1GB,photos=2GB",
+		"photos=big",
+		"photos=0",
+		"photos=/many",
+		"photos=/-1",
+	} {
+		_, err := miniogw.ParseQuotas(invalid)
+		assert.True(t, miniogw.QuotaError.Has(err), invalid)
+	}
+}
+
+func TestQuotasDisabled(t *testing.T) {
+	gateway := miniogw.NewStorjGateway(uplink.Config{})
+	assert.Equal(t, gateway, miniogw.Quotas(gateway, nil, time.Minute))
+}
+
+func TestQuotas(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	memory := newMemoryLayer()
+	memory.objects["a"] = 4
+	memory.objects["b"] = 4
+	layer := quotaLayer(t, memory, map[string]miniogw.Quota{TestBucket: {Bytes: 10, Objects: 3}}, time.Hour)
+
+	// the usage is known after the first listing
+	waitForQuota(t, ctx, layer, 3)
+	assert.Equal(t, 1, memory.listings())
+
+	// uploads are accounted for until the next listing
+	require.NoError(t, putSized(t, ctx, layer, "c", 1))
+	assert.Equal(t, minio.BucketQuotaExceeded{Bucket: TestBucket}, putSized(t, ctx, layer, "d", 0))
+	_, err := layer.DeleteObject(ctx, TestBucket, "c", minio.ObjectOptions{})
+	require.NoError(t, err)
+
+	// the parts of multipart uploads are accounted for as they are uploaded
+	uploadID, err := layer.NewMultipartUpload(ctx, TestBucket, "e", minio.ObjectOptions{})
+	require.NoError(t, err)
+	_, err = layer.PutObjectPart(ctx, TestBucket, "e", uploadID, 1, sizedReader(t, 2), minio.ObjectOptions{})
+	require.NoError(t, err)
+	_, err = layer.PutObjectPart(ctx, TestBucket, "e", uploadID, 2, sizedReader(t, 1), minio.ObjectOptions{})
+	assert.Equal(t, minio.BucketQuotaExceeded{Bucket: TestBucket}, err)
+	assert.Equal(t, minio.BucketQuotaExceeded{Bucket: TestBucket}, putSized(t, ctx, layer, "f", 1))
+
+	// aborted uploads free the size of their parts
+	require.NoError(t, layer.AbortMultipartUpload(ctx, TestBucket, "e", uploadID, minio.ObjectOptions{}))
+	require.NoError(t, putSized(t, ctx, layer, "f", 1))
+	_, err = layer.DeleteObject(ctx, TestBucket, "f", minio.ObjectOptions{})
+	require.NoError(t, err)
+
+	// completed uploads are one more object, and their parts are counted once
+	uploadID, err = layer.NewMultipartUpload(ctx, TestBucket, "e", minio.ObjectOptions{})
+	require.NoError(t, err)
+	_, err = layer.PutObjectPart(ctx, TestBucket, "e", uploadID, 1, sizedReader(t, 1), minio.ObjectOptions{})
+	require.NoError(t, err)
+	_, err = layer.CompleteMultipartUpload(ctx, TestBucket, "e", uploadID, nil, minio.ObjectOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, minio.BucketQuotaExceeded{Bucket: TestBucket}, putSized(t, ctx, layer, "f", 0))
+	_, err = layer.DeleteObject(ctx, TestBucket, "a", minio.ObjectOptions{})
+	require.NoError(t, err)
+	require.NoError(t, putSized(t, ctx, layer, "f", 5))
+
+	// buckets without a quota aren't listed
+	_, err = layer.PutObject(ctx, "other", "g", sizedReader(t, 100), minio.ObjectOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, memory.listings())
+}
+
+func TestQuotasReconcileInBackground(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	memory := newMemoryLayer()
+	memory.objects["a"] = 4
+	memory.release = make(chan struct{})
+	layer := quotaLayer(t, memory, map[string]miniogw.Quota{"*": {Objects: 1}}, time.Hour)
+
+	// uploads don't wait for the listing
+	require.NoError(t, putSized(t, ctx, layer, "b", 1))
+	require.NoError(t, putSized(t, ctx, layer, "c", 1))
+
+	close(memory.release)
+	waitForQuota(t, ctx, layer, 0)
+	assert.Equal(t, 1, memory.listings())
+}
+
+func TestQuotasUnknownUsage(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	memory := newMemoryLayer()
+	memory.objects["a"] = 4
+	memory.objects["b"] = 4
+	memory.listErr = minio.PrefixAccessDenied{Bucket: TestBucket}
+	layer := quotaLayer(t, memory, map[string]miniogw.Quota{"*": {Objects: 1}}, 0)
+
+	// the usage isn't known while listing fails, so uploads are allowed
+	for _, key := range []string{"c", "d", "e"} {
+		require.NoError(t, putSized(t, ctx, layer, key, 1))
+	}
+	require.Eventually(t, func() bool { return memory.listings() > 0 }, 10*time.Second, 10*time.Millisecond)
+	require.NoError(t, putSized(t, ctx, layer, "f", 1))
+}
+
+// quotaLayer returns the layer of the quotas for the memory layer.
+func quotaLayer(t *testing.T, memory *memoryLayer, quotas map[string]miniogw.Quota, interval time.Duration) minio.ObjectLayer {
+	layer, err := miniogw.Quotas(memoryGateway{layer: memory}, quotas, interval).NewGatewayLayer(auth.Credentials{})
+	require.NoError(t, err)
+	return layer
+}
+
+// errProbe is returned by the memory layer for uploads of probeKey.
+var errProbe = errors.New("probe")
+
+const probeKey = "probe"
+
+// waitForQuota waits until the usage of TestBucket is known, by probing with
+// uploads of size bytes that have to exceed the quota.
+func waitForQuota(t *testing.T, ctx context.Context, layer minio.ObjectLayer, size int64) {
+	require.Eventually(t, func() bool {
+		err := putSized(t, ctx, layer, probeKey, size)
+		if errors.Is(err, errProbe) {
+			return false
+		}
+		return assert.Equal(t, minio.BucketQuotaExceeded{Bucket: TestBucket}, err)
+	}, 10*time.Second, 10*time.Millisecond)
+}
+
+func sizedReader(t *testing.T, size int64) *minio.PutObjReader {
+	hashReader, err := hash.NewReader(bytes.NewReader(make([]byte, size)), size, "", "", size, true)
+	require.NoError(t, err)
+	return minio.NewPutObjReader(hashReader, nil, nil)
+}
+
+func putSized(t *testing.T, ctx context.Context, layer minio.ObjectLayer, key string, size int64) error {
+	_, err := layer.PutObject(ctx, TestBucket, key, sizedReader(t, size), minio.ObjectOptions{})
+	return err
+}
+
+type memoryGateway struct {
+	minio.Gateway
+	layer *memoryLayer
+}
+
+func (gateway memoryGateway) NewGatewayLayer(creds auth.Credentials) (minio.ObjectLayer, error) {
+	return gateway.layer, nil
+}
+
+// memoryLayer keeps the sizes of the objects of a bucket in memory, for the
+// requests that quotas account for.
+type memoryLayer struct {
+	minio.ObjectLayer
+
+	mu      sync.Mutex
+	objects map[string]int64
+	uploads map[string]int64
+	lists   int
+	listErr error
+	release chan struct{} // listings wait until it is closed, unless it is nil
+}
+
+func newMemoryLayer() *memoryLayer {
+	return &memoryLayer{
+		objects: make(map[string]int64),
+		uploads: make(map[string]int64),
+	}
+}
+
+func (layer *memoryLayer) listings() int {
+	layer.mu.Lock()
+	defer layer.mu.Unlock()
+	return layer.lists
+}
+
+func (layer *memoryLayer) ListObjectsV2(ctx context.Context, bucket, prefix, continuationToken, delimiter string, maxKeys int, fetchOwner bool, startAfter string) (minio.ListObjectsV2Info, error) {
+	if layer.release != nil {
+		<-layer.release
+	}
+
+	layer.mu.Lock()
+	defer layer.mu.Unlock()
+
+	layer.lists++
+	if layer.listErr != nil {
+		return minio.ListObjectsV2Info{}, layer.listErr
+	}
+	var result minio.ListObjectsV2Info
+	for name, size := range layer.objects {
+		result.Objects = append(result.Objects, minio.ObjectInfo{Bucket: bucket, Name: name, Size: size})
+	}
+	return result, nil
+}
+
+func (layer *memoryLayer) PutObject(ctx context.Context, bucket, object string, data *minio.PutObjReader, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	if object == probeKey {
+		return minio.ObjectInfo{}, errProbe
+	}
+
+	layer.mu.Lock()
+	defer layer.mu.Unlock()
+
+	if bucket == TestBucket {
+		layer.objects[object] = data.Size()
+	}
+	return minio.ObjectInfo{Bucket: bucket, Name: object, Size: data.Size()}, nil
+}
+
+func (layer *memoryLayer) DeleteObject(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	layer.mu.Lock()
+	defer layer.mu.Unlock()
+
+	size, ok := layer.objects[object]
+	if !ok {
+		return minio.ObjectInfo{}, minio.ObjectNotFound{Bucket: bucket, Object: object}
+	}
+	delete(layer.objects, object)
+	return minio.ObjectInfo{Bucket: bucket, Name: object, Size: size}, nil
+}
+
+func (layer *memoryLayer) NewMultipartUpload(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (string, error) {
+	layer.mu.Lock()
+	defer layer.mu.Unlock()
+
+	layer.uploads[object] = 0
+	return object, nil
+}
+
+func (layer *memoryLayer) PutObjectPart(ctx context.Context, bucket, object, uploadID string, partID int, data *minio.PutObjReader, opts minio.ObjectOptions) (minio.PartInfo, error) {
+	layer.mu.Lock()
+	defer layer.mu.Unlock()
+
+	layer.uploads[uploadID] += data.Size()
+	return minio.PartInfo{PartNumber: partID, Size: data.Size()}, nil
+}
+
+func (layer *memoryLayer) AbortMultipartUpload(ctx context.Context, bucket, object, uploadID string, opts minio.ObjectOptions) error {
+	layer.mu.Lock()
+	defer layer.mu.Unlock()
+
+	delete(layer.uploads, uploadID)
+	return nil
+}
+
+func (layer *memoryLayer) CompleteMultipartUpload(ctx context.Context, bucket, object, uploadID string, uploadedParts []minio.CompletePart, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	layer.mu.Lock()
+	defer layer.mu.Unlock()
+
+	size := layer.uploads[uploadID]
+	delete(layer.uploads, uploadID)
+	layer.objects[object] = size
+	return minio.ObjectInfo{Bucket: bucket, Name: object, Size: size}, nil
+}
@@ -0,0 +1,75 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw_test
+
+import (
+	"bytes"
+	"testing"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/cmd/logger"
+	"github.com/minio/minio/pkg/auth"
+	"github.com/minio/minio/pkg/hash"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+	"storj.io/stargate/miniogw"
+	"storj.io/storj/private/testplanet"
+	"storj.io/uplink"
+)
+
+func TestRoutes(t *testing.T) {
+	testplanet.Run(t, testplanet.Config{
+		SatelliteCount: 1, StorageNodeCount: 4, UplinkCount: 1,
+		NonParallel: true,
+	}, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet) {
+		access := planet.Uplinks[0].Access[planet.Satellites[0].ID()]
+		accessKey, err := access.Serialize()
+		require.NoError(t, err)
+
+		// objects under logs/ in the test bucket go to an upload-only access grant
+		appendOnly, err := access.Share(uplink.Permission{AllowUpload: true},
+			uplink.SharePrefix{Bucket: TestBucket, Prefix: "logs/"})
+		require.NoError(t, err)
+		appendOnlyGrant, err := appendOnly.Serialize()
+		require.NoError(t, err)
+
+		gateway := miniogw.NewStorjGateway(uplink.Config{})
+		gateway.SetRouter(miniogw.StaticRouter{
+			accessKey: {{Bucket: TestBucket, Prefix: "logs/", AccessGrant: appendOnlyGrant}},
+		})
+		layer, err := gateway.NewGatewayLayer(auth.Credentials{})
+		require.NoError(t, err)
+		defer ctx.Check(func() error { return layer.Shutdown(ctx) })
+
+		reqCtx := logger.SetReqInfo(ctx, &logger.ReqInfo{AccessKey: accessKey})
+		require.NoError(t, layer.MakeBucketWithLocation(reqCtx, TestBucket, minio.BucketOptions{}))
+
+		put := func(key string) error {
+			hashReader, err := hash.NewReader(bytes.NewReader([]byte("test")), 4, "", "", 4, true)
+			require.NoError(t, err)
+			_, err = layer.PutObject(reqCtx, TestBucket, key, minio.NewPutObjReader(hashReader, nil, nil), minio.ObjectOptions{})
+			return err
+		}
+
+		// objects outside of the route use the access key
+		require.NoError(t, put(TestFile))
+		_, err = layer.GetObjectInfo(reqCtx, TestBucket, TestFile, minio.ObjectOptions{})
+		require.NoError(t, err)
+
+		// objects under the route can be uploaded, but not read back
+		require.NoError(t, put("logs/"+TestFile))
+		_, err = layer.GetObjectInfo(reqCtx, TestBucket, "logs/"+TestFile, minio.ObjectOptions{})
+		require.Error(t, err)
+		_, err = layer.DeleteObject(reqCtx, TestBucket, "logs/"+TestFile, minio.ObjectOptions{})
+		require.Error(t, err)
+
+		// the object was uploaded with the access grant of the route
+		project, err := uplink.OpenProject(ctx, access)
+		require.NoError(t, err)
+		defer ctx.Check(project.Close)
+		_, err = project.StatObject(ctx, TestBucket, "logs/"+TestFile)
+		require.NoError(t, err)
+	})
+}
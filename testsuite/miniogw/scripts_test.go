@@ -0,0 +1,101 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/cmd/logger"
+	"github.com/minio/minio/pkg/auth"
+	"github.com/minio/minio/pkg/hash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"storj.io/common/testcontext"
+	"storj.io/stargate/internal/script"
+	"storj.io/stargate/miniogw"
+	"storj.io/storj/private/testplanet"
+	"storj.io/uplink"
+)
+
+func TestScripted(t *testing.T) {
+	gateway := miniogw.NewStorjGateway(uplink.Config{})
+	assert.Equal(t, gateway, miniogw.Scripted(gateway, zaptest.NewLogger(t), nil))
+
+	testplanet.Run(t, testplanet.Config{
+		SatelliteCount: 1, StorageNodeCount: 4, UplinkCount: 1,
+		NonParallel: true,
+	}, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet) {
+		hook, err := script.New("test.lua", `
+			function on_request(request)
+			  if string.find(request.key, "secret/", 1, true) == 1 then
+			    return false, "secret"
+			  end
+			  if request.metadata then
+			    request.metadata["operation"] = request.operation
+			  end
+			  request.key = string.lower(request.key)
+			end
+		`, time.Second)
+		require.NoError(t, err)
+
+		layer, err := miniogw.Scripted(gateway, zaptest.NewLogger(t), hook).NewGatewayLayer(auth.Credentials{})
+		require.NoError(t, err)
+		defer ctx.Check(func() error { return layer.Shutdown(ctx) })
+
+		access := planet.Uplinks[0].Access[planet.Satellites[0].ID()]
+		accessKey, err := access.Serialize()
+		require.NoError(t, err)
+		reqCtx := logger.SetReqInfo(ctx, &logger.ReqInfo{AccessKey: accessKey})
+
+		project, err := uplink.OpenProject(ctx, access)
+		require.NoError(t, err)
+		defer ctx.Check(project.Close)
+
+		_, err = project.CreateBucket(ctx, TestBucket)
+		require.NoError(t, err)
+
+		// the key is rewritten and the metadata changed
+		hashReader, err := hash.NewReader(bytes.NewReader([]byte("test")), 4, "", "", 4, true)
+		require.NoError(t, err)
+		info, err := layer.PutObject(reqCtx, TestBucket, "Dir/File", minio.NewPutObjReader(hashReader, nil, nil), minio.ObjectOptions{
+			UserDefined: map[string]string{"content-type": "text/plain"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "Dir/File", info.Name)
+
+		object, err := project.StatObject(ctx, TestBucket, "dir/file")
+		require.NoError(t, err)
+		assert.Equal(t, "PutObject", object.Custom["operation"])
+		assert.Equal(t, "text/plain", object.Custom["content-type"])
+
+		info, err = layer.GetObjectInfo(reqCtx, TestBucket, "DIR/FILE", minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "DIR/FILE", info.Name)
+
+		// errors have the key of the request
+		_, err = layer.GetObjectInfo(reqCtx, TestBucket, "Missing", minio.ObjectOptions{})
+		assert.Equal(t, minio.ObjectNotFound{Bucket: TestBucket, Object: "Missing"}, err)
+
+		// denied requests don't reach the project
+		_, err = createFile(ctx, project, TestBucket, "secret/file", []byte("test"), nil)
+		require.NoError(t, err)
+		_, err = layer.GetObjectInfo(reqCtx, TestBucket, "secret/file", minio.ObjectOptions{})
+		assert.Equal(t, minio.PrefixAccessDenied{Bucket: TestBucket, Object: "secret/file"}, err)
+		_, err = layer.DeleteObject(reqCtx, TestBucket, "secret/file", minio.ObjectOptions{})
+		assert.Equal(t, minio.PrefixAccessDenied{Bucket: TestBucket, Object: "secret/file"}, err)
+		_, err = project.StatObject(ctx, TestBucket, "secret/file")
+		require.NoError(t, err)
+
+		_, err = layer.DeleteObject(reqCtx, TestBucket, "DIR/File", minio.ObjectOptions{})
+		require.NoError(t, err)
+		_, err = project.StatObject(ctx, TestBucket, "dir/file")
+		assert.True(t, errors.Is(err, uplink.ErrObjectNotFound))
+	})
+}
@@ -0,0 +1,118 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw_test
+
+import (
+	"bytes"
+	"testing"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/cmd/logger"
+	"github.com/minio/minio/pkg/auth"
+	"github.com/minio/minio/pkg/hash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"storj.io/common/testcontext"
+	"storj.io/stargate/internal/metaindex"
+	"storj.io/stargate/miniogw"
+	"storj.io/storj/private/testplanet"
+	"storj.io/uplink"
+)
+
+func TestSearch(t *testing.T) {
+	testplanet.Run(t, testplanet.Config{
+		SatelliteCount: 1, StorageNodeCount: 4, UplinkCount: 2,
+		NonParallel: true,
+	}, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet) {
+		index, err := metaindex.Open(ctx, ctx.File("index.db"))
+		require.NoError(t, err)
+		defer ctx.Check(index.Close)
+
+		gateway := miniogw.Indexing(miniogw.NewStorjGateway(uplink.Config{}), zaptest.NewLogger(t), index)
+		layer, err := gateway.NewGatewayLayer(auth.Credentials{})
+		require.NoError(t, err)
+		defer ctx.Check(func() error { return layer.Shutdown(ctx) })
+
+		access := planet.Uplinks[0].Access[planet.Satellites[0].ID()]
+		accessKey, err := access.Serialize()
+		require.NoError(t, err)
+		reqCtx := logger.SetReqInfo(ctx, &logger.ReqInfo{AccessKey: accessKey})
+
+		project, err := uplink.OpenProject(ctx, access)
+		require.NoError(t, err)
+		defer ctx.Check(project.Close)
+		_, err = project.CreateBucket(ctx, TestBucket)
+		require.NoError(t, err)
+
+		for key, tagging := range map[string]string{
+			"logs/a":   "team=storage",
+			"logs/b":   "team=compute",
+			"photos/c": "team=storage",
+		} {
+			hashReader, err := hash.NewReader(bytes.NewReader([]byte("test")), 4, "", "", 4, true)
+			require.NoError(t, err)
+			_, err = layer.PutObject(reqCtx, TestBucket, key, minio.NewPutObjReader(hashReader, nil, nil), minio.ObjectOptions{
+				UserDefined: map[string]string{"X-Amz-Tagging": tagging, "x-amz-meta-mission": "apollo"},
+			})
+			require.NoError(t, err)
+		}
+
+		search := miniogw.NewSearch(uplink.Config{}, index)
+		keys := func(accessKey string, query metaindex.Query) ([]string, string) {
+			result, err := search.Search(ctx, accessKey, query)
+			require.NoError(t, err)
+			var keys []string
+			for _, object := range result.Objects {
+				keys = append(keys, object.Key)
+			}
+			return keys, result.Next
+		}
+
+		found, next := keys(accessKey, metaindex.Query{Bucket: TestBucket, Tags: map[string]string{"team": "storage"}})
+		assert.Equal(t, []string{"logs/a", "photos/c"}, found)
+		assert.Empty(t, next)
+
+		found, _ = keys(accessKey, metaindex.Query{Bucket: TestBucket, Prefix: "logs/", Metadata: map[string]string{"X-Amz-Meta-Mission": "apollo"}})
+		assert.Equal(t, []string{"logs/a", "logs/b"}, found)
+
+		// pages continue after the last key
+		found, next = keys(accessKey, metaindex.Query{Bucket: TestBucket, Limit: 2})
+		assert.Equal(t, []string{"logs/a", "logs/b"}, found)
+		assert.Equal(t, "logs/b", next)
+		found, _ = keys(accessKey, metaindex.Query{Bucket: TestBucket, After: next, Limit: 2})
+		assert.Equal(t, []string{"photos/c"}, found)
+
+		// deletes through the gateway are removed from the index
+		_, err = layer.DeleteObject(reqCtx, TestBucket, "logs/a", minio.ObjectOptions{})
+		require.NoError(t, err)
+		found, _ = keys(accessKey, metaindex.Query{Bucket: TestBucket, Tags: map[string]string{"team": "storage"}})
+		assert.Equal(t, []string{"photos/c"}, found)
+
+		// the bucket of the same name of another project has none of the
+		// entries
+		otherAccess := planet.Uplinks[1].Access[planet.Satellites[0].ID()]
+		otherKey, err := otherAccess.Serialize()
+		require.NoError(t, err)
+		otherProject, err := uplink.OpenProject(ctx, otherAccess)
+		require.NoError(t, err)
+		defer ctx.Check(otherProject.Close)
+		_, err = otherProject.EnsureBucket(ctx, TestBucket)
+		require.NoError(t, err)
+		found, _ = keys(otherKey, metaindex.Query{Bucket: TestBucket})
+		assert.Empty(t, found)
+
+		// access grants that can't list the bucket can't search it
+		restricted, err := access.Share(uplink.Permission{AllowDownload: true})
+		require.NoError(t, err)
+		restrictedKey, err := restricted.Serialize()
+		require.NoError(t, err)
+		_, err = search.Search(ctx, restrictedKey, metaindex.Query{Bucket: TestBucket})
+		require.Error(t, err)
+
+		_, err = search.Search(ctx, accessKey, metaindex.Query{Bucket: "missing"})
+		assert.Equal(t, minio.BucketNotFound{Bucket: "missing"}, err)
+	})
+}
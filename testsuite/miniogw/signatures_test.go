@@ -0,0 +1,79 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcutil/base58"
+	"github.com/minio/minio-go/v6/pkg/signer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"storj.io/stargate/auth"
+	"storj.io/stargate/miniogw"
+)
+
+func TestSignatures(t *testing.T) {
+	var passed int
+	minio := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		passed++
+	}))
+	defer minio.Close()
+
+	derivationKey := bytes.Repeat([]byte{1}, 32)
+	signatures, err := miniogw.NewSignatures(zaptest.NewLogger(t), miniogw.SignatureConfig{
+		DerivationKeys:  base64.StdEncoding.EncodeToString(derivationKey),
+		InternalAddress: strings.TrimPrefix(minio.URL, "http://"),
+		MaxSkew:         time.Minute,
+	})
+	require.NoError(t, err)
+
+	key := auth.EncryptionKey{1}
+	accessKeyID := base58.CheckEncode(key[:], auth.VersionAccessKeyID)
+	secretKey := base58.CheckEncode(auth.DeriveSecretKey(derivationKey, key.Hash()), auth.VersionSecretKey)
+
+	exec := func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		signatures.ServeHTTP(rec, req)
+		return rec
+	}
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "http://gateway.test/bucket/object", nil)
+		req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+		return req
+	}
+
+	// requests with the secret key of their access key id are passed on
+	rec := exec(signer.SignV4(*newRequest(), accessKeyID, secretKey, "", "us-east-1"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 1, passed)
+
+	// and so are those that the secret keys of access key ids don't sign
+	rec = exec(newRequest())
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = exec(signer.SignV4(*newRequest(), "13YqeAccessGrant", "anything", "", "us-east-1"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 3, passed)
+
+	// but access key ids with other secret keys are rejected
+	rec = exec(signer.SignV4(*newRequest(), accessKeyID, "anything", "", "us-east-1"))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), "<Code>SignatureDoesNotMatch</Code>")
+
+	// as are access key ids with signatures that can't be checked
+	rec = exec(signer.SignV2(*newRequest(), accessKeyID, secretKey, false))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, 3, passed)
+
+	_, err = miniogw.NewSignatures(zaptest.NewLogger(t), miniogw.SignatureConfig{DerivationKeys: "short"})
+	require.Error(t, err)
+}
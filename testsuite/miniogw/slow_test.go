@@ -0,0 +1,90 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/cmd/logger"
+	"github.com/minio/minio/pkg/auth"
+	"github.com/minio/minio/pkg/hash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest"
+	"go.uber.org/zap/zaptest/observer"
+
+	"storj.io/common/testcontext"
+	"storj.io/stargate/miniogw"
+	"storj.io/storj/private/testplanet"
+	"storj.io/uplink"
+)
+
+func TestSlowRequestsDisabled(t *testing.T) {
+	gateway := miniogw.NewStorjGateway(uplink.Config{})
+	assert.Equal(t, gateway, miniogw.SlowRequests(gateway, zaptest.NewLogger(t), miniogw.SlowConfig{}))
+}
+
+func TestSlowRequests(t *testing.T) {
+	testplanet.Run(t, testplanet.Config{
+		SatelliteCount: 1, StorageNodeCount: 4, UplinkCount: 1,
+		NonParallel: true,
+	}, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet) {
+		// every request is slow, except for uploads and downloads
+		core, logs := observer.New(zapcore.WarnLevel)
+		gateway := miniogw.SlowRequests(miniogw.NewStorjGateway(uplink.Config{}), zap.New(core), miniogw.SlowConfig{
+			Threshold:         time.Nanosecond,
+			TransferThreshold: time.Hour,
+		})
+		layer, err := gateway.NewGatewayLayer(auth.Credentials{})
+		require.NoError(t, err)
+		defer ctx.Check(func() error { return layer.Shutdown(ctx) })
+
+		access := planet.Uplinks[0].Access[planet.Satellites[0].ID()]
+		accessKey, err := access.Serialize()
+		require.NoError(t, err)
+		reqCtx := logger.SetReqInfo(ctx, &logger.ReqInfo{AccessKey: accessKey})
+
+		require.NoError(t, layer.MakeBucketWithLocation(reqCtx, TestBucket, minio.BucketOptions{}))
+		entries := logs.TakeAll()
+		require.Len(t, entries, 1)
+		assert.Equal(t, "slow request", entries[0].Message)
+		fields := entries[0].ContextMap()
+		assert.Equal(t, "MakeBucketWithLocation", fields["operation"])
+		assert.Equal(t, TestBucket, fields["bucket"])
+		for _, name := range []string{"total", "auth", "satellite", "transfer"} {
+			assert.Contains(t, fields, name)
+		}
+
+		hashReader, err := hash.NewReader(bytes.NewReader([]byte("test")), 4, "", "", 4, true)
+		require.NoError(t, err)
+		_, err = layer.PutObject(reqCtx, TestBucket, TestFile, minio.NewPutObjReader(hashReader, nil, nil), minio.ObjectOptions{
+			UserDefined: map[string]string{},
+		})
+		require.NoError(t, err)
+
+		reader, err := layer.GetObjectNInfo(reqCtx, TestBucket, TestFile, nil, nil, 0, minio.ObjectOptions{})
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+		assert.Equal(t, "test", string(data))
+		require.NoError(t, reader.Close())
+		assert.Empty(t, logs.TakeAll())
+
+		// failed requests are logged with their error
+		_, err = layer.GetObjectInfo(reqCtx, TestBucket, "missing", minio.ObjectOptions{})
+		require.Error(t, err)
+		entries = logs.TakeAll()
+		require.Len(t, entries, 1)
+		fields = entries[0].ContextMap()
+		assert.Equal(t, "GetObjectInfo", fields["operation"])
+		assert.Equal(t, "missing", fields["object"])
+		assert.Contains(t, fields, "error")
+	})
+}
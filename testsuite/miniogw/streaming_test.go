@@ -0,0 +1,124 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/pkg/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+	"storj.io/common/testrand"
+	"storj.io/stargate/miniogw"
+)
+
+func TestStreaming(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	media := &mediaLayer{objects: map[string]mediaObject{
+		"video.mp4": {contentType: "video/mp4", data: testrand.BytesInt(100)},
+		"notes.txt": {contentType: "text/plain", data: testrand.BytesInt(100)},
+	}}
+
+	// the gateway isn't wrapped without streamed content types
+	gateway := mediaGateway{layer: media}
+	assert.Equal(t, gateway, miniogw.Streaming(gateway, miniogw.StreamingConfig{}))
+
+	layer, err := miniogw.Streaming(gateway, miniogw.StreamingConfig{
+		ContentTypes:      "video/",
+		ChunkSize:         10,
+		ReadAhead:         4,
+		TimingAllowOrigin: "*",
+	}).NewGatewayLayer(auth.Credentials{})
+	require.NoError(t, err)
+
+	get := func(object string, rangeSpec *minio.HTTPRangeSpec) (*minio.HTTPRangeSpec, minio.ObjectInfo, []byte) {
+		reader, err := layer.GetObjectNInfo(ctx, TestBucket, object, rangeSpec, nil, 0, minio.ObjectOptions{})
+		require.NoError(t, err)
+		defer ctx.Check(reader.Close)
+		data, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+		return rangeSpec, reader.ObjInfo, data
+	}
+
+	// open-ended ranges of streamed objects are limited to a chunk
+	rangeSpec, info, data := get("video.mp4", &minio.HTTPRangeSpec{Start: 0, End: -1})
+	assert.Equal(t, int64(9), rangeSpec.End)
+	assert.Equal(t, media.objects["video.mp4"].data[:10], data)
+	assert.Equal(t, "bytes", info.UserDefined["Accept-Ranges"])
+	assert.Equal(t, "*", info.UserDefined["Timing-Allow-Origin"])
+
+	rangeSpec, _, data = get("video.mp4", &minio.HTTPRangeSpec{Start: 95, End: -1})
+	assert.Equal(t, int64(-1), rangeSpec.End)
+	assert.Equal(t, media.objects["video.mp4"].data[95:], data)
+
+	// whole downloads are read ahead completely
+	_, _, data = get("video.mp4", nil)
+	assert.Equal(t, media.objects["video.mp4"].data, data)
+
+	// other content types are left alone
+	rangeSpec, info, data = get("notes.txt", &minio.HTTPRangeSpec{Start: 0, End: -1})
+	assert.Equal(t, int64(-1), rangeSpec.End)
+	assert.Equal(t, media.objects["notes.txt"].data, data)
+	assert.NotContains(t, info.UserDefined, "Accept-Ranges")
+}
+
+type mediaGateway struct {
+	minio.Gateway
+	layer *mediaLayer
+}
+
+func (gateway mediaGateway) NewGatewayLayer(creds auth.Credentials) (minio.ObjectLayer, error) {
+	return gateway.layer, nil
+}
+
+type mediaObject struct {
+	contentType string
+	data        []byte
+}
+
+// mediaLayer serves the objects of every bucket by their names.
+type mediaLayer struct {
+	minio.ObjectLayer
+
+	objects map[string]mediaObject
+}
+
+func (layer *mediaLayer) GetObjectInfo(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	stored, ok := layer.objects[object]
+	if !ok {
+		return minio.ObjectInfo{}, minio.ObjectNotFound{Bucket: bucket, Object: object}
+	}
+	return minio.ObjectInfo{
+		Bucket:      bucket,
+		Name:        object,
+		Size:        int64(len(stored.data)),
+		ContentType: stored.contentType,
+		UserDefined: map[string]string{},
+	}, nil
+}
+
+func (layer *mediaLayer) GetObjectNInfo(ctx context.Context, bucket, object string, rangeSpec *minio.HTTPRangeSpec, header http.Header, lockType minio.LockType, opts minio.ObjectOptions) (*minio.GetObjectReader, error) {
+	info, err := layer.GetObjectInfo(ctx, bucket, object, opts)
+	if err != nil {
+		return nil, err
+	}
+	data := layer.objects[object].data
+	if rangeSpec != nil {
+		start, length, err := rangeSpec.GetOffsetLength(info.Size)
+		if err != nil {
+			return nil, err
+		}
+		data = data[start : start+length]
+	}
+	return minio.NewGetObjectReaderFromReader(bytes.NewReader(data), info, opts)
+}
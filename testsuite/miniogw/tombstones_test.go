@@ -0,0 +1,125 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw_test
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/pkg/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+	"storj.io/stargate/miniogw"
+	"storj.io/uplink"
+)
+
+func TestTombstones(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	// the gateway isn't wrapped unless deletes are remembered
+	gateway := miniogw.NewStorjGateway(uplink.Config{})
+	assert.Equal(t, gateway, miniogw.Tombstones(gateway, miniogw.TombstonesConfig{MaxEntries: 10}))
+
+	objects := &deletesLayer{objectsLayer: objectsLayer{objects: make(map[string]minio.ObjectInfo)}}
+	layer, err := miniogw.Tombstones(deletesGateway{layer: objects}, miniogw.TombstonesConfig{
+		TTL:        time.Hour,
+		MaxEntries: 10,
+	}).NewGatewayLayer(auth.Credentials{})
+	require.NoError(t, err)
+	listed := func() (names []string) {
+		result, err := layer.ListObjects(ctx, TestBucket, "", "", "", 100)
+		require.NoError(t, err)
+		for _, object := range result.Objects {
+			names = append(names, object.Name)
+		}
+		return names
+	}
+
+	_, err = layer.PutObject(ctx, TestBucket, "a", md5Reader(t, "a"), minio.ObjectOptions{})
+	require.NoError(t, err)
+	_, err = layer.PutObject(ctx, TestBucket, "b", md5Reader(t, "b"), minio.ObjectOptions{})
+	require.NoError(t, err)
+
+	// retried deletes don't reach the satellite
+	_, err = layer.DeleteObject(ctx, TestBucket, "a", minio.ObjectOptions{})
+	require.NoError(t, err)
+	_, err = layer.DeleteObject(ctx, TestBucket, "a", minio.ObjectOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, objects.deletes)
+
+	// listings leave out deleted objects that the satellite still lists
+	objects.objects[TestBucket+"/a"] = minio.ObjectInfo{Bucket: TestBucket, Name: "a"}
+	assert.Equal(t, []string{"b"}, listed())
+
+	// uploading the object again forgets that it was deleted
+	_, err = layer.PutObject(ctx, TestBucket, "a", md5Reader(t, "a"), minio.ObjectOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, listed())
+	_, err = layer.DeleteObject(ctx, TestBucket, "a", minio.ObjectOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, objects.deletes)
+
+	// batch deletes only delete the objects that weren't deleted recently
+	deleted, errs := layer.DeleteObjects(ctx, TestBucket, []minio.ObjectToDelete{{ObjectName: "a"}, {ObjectName: "b"}}, minio.ObjectOptions{})
+	require.Len(t, deleted, 2)
+	assert.Equal(t, "a", deleted[0].ObjectName)
+	assert.Equal(t, "b", deleted[1].ObjectName)
+	assert.Equal(t, []error{nil, nil}, errs)
+	assert.Equal(t, 3, objects.deletes)
+	assert.Empty(t, listed())
+}
+
+type deletesGateway struct {
+	minio.Gateway
+	layer *deletesLayer
+}
+
+func (gateway deletesGateway) NewGatewayLayer(creds auth.Credentials) (minio.ObjectLayer, error) {
+	return gateway.layer, nil
+}
+
+// deletesLayer is an objectsLayer that lists and deletes its objects, and
+// counts the deletes.
+type deletesLayer struct {
+	objectsLayer
+	deletes int
+}
+
+func (layer *deletesLayer) DeleteObject(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	layer.deletes++
+	info, ok := layer.objects[bucket+"/"+object]
+	if !ok {
+		return minio.ObjectInfo{}, minio.ObjectNotFound{Bucket: bucket, Object: object}
+	}
+	delete(layer.objects, bucket+"/"+object)
+	return info, nil
+}
+
+func (layer *deletesLayer) DeleteObjects(ctx context.Context, bucket string, objects []minio.ObjectToDelete, opts minio.ObjectOptions) ([]minio.DeletedObject, []error) {
+	deleted := make([]minio.DeletedObject, len(objects))
+	errs := make([]error, len(objects))
+	for i, object := range objects {
+		_, errs[i] = layer.DeleteObject(ctx, bucket, object.ObjectName, opts)
+		deleted[i].ObjectName = object.ObjectName
+	}
+	return deleted, errs
+}
+
+func (layer *deletesLayer) ListObjects(ctx context.Context, bucket, prefix, marker, delimiter string, maxKeys int) (result minio.ListObjectsInfo, err error) {
+	for _, info := range layer.objects {
+		if info.Bucket == bucket {
+			result.Objects = append(result.Objects, info)
+		}
+	}
+	sort.Slice(result.Objects, func(i, j int) bool {
+		return result.Objects[i].Name < result.Objects[j].Name
+	})
+	return result, nil
+}
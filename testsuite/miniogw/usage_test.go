@@ -0,0 +1,94 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"storj.io/common/testcontext"
+	"storj.io/common/testrand"
+	"storj.io/stargate/miniogw"
+	"storj.io/storj/private/testplanet"
+	"storj.io/uplink"
+)
+
+func TestUsage(t *testing.T) {
+	testplanet.Run(t, testplanet.Config{
+		SatelliteCount: 1, StorageNodeCount: 4, UplinkCount: 1,
+		NonParallel: true,
+	}, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet) {
+		access := planet.Uplinks[0].Access[planet.Satellites[0].ID()]
+		accessKey, err := access.Serialize()
+		require.NoError(t, err)
+
+		project, err := uplink.OpenProject(ctx, access)
+		require.NoError(t, err)
+		defer ctx.Check(project.Close)
+
+		_, err = project.CreateBucket(ctx, TestBucket)
+		require.NoError(t, err)
+		_, err = project.CreateBucket(ctx, DestBucket)
+		require.NoError(t, err)
+
+		_, err = createFile(ctx, project, TestBucket, TestFile, testrand.BytesInt(100), nil)
+		require.NoError(t, err)
+		_, err = createFile(ctx, project, TestBucket, "prefix/"+TestFile2, testrand.BytesInt(200), nil)
+		require.NoError(t, err)
+
+		usage := miniogw.NewUsage(uplink.Config{}, time.Hour)
+
+		bucketUsage, _, err := usage.BucketUsage(ctx, accessKey, TestBucket)
+		require.NoError(t, err)
+		require.Equal(t, miniogw.BucketUsage{Size: 300, ObjectsCount: 2}, bucketUsage)
+
+		// the usage is cached
+		_, err = createFile(ctx, project, TestBucket, TestFile3, testrand.BytesInt(50), nil)
+		require.NoError(t, err)
+
+		dataUsage, err := usage.DataUsage(ctx, accessKey)
+		require.NoError(t, err)
+		require.EqualValues(t, 2, dataUsage.BucketsCount)
+		require.EqualValues(t, 2, dataUsage.ObjectsCount)
+		require.EqualValues(t, 300, dataUsage.ObjectsTotalSize)
+		require.Equal(t, miniogw.BucketUsage{}, dataUsage.BucketsUsage[DestBucket])
+
+		// the admin api serves the usage of the bucket of the access grant
+		server := httptest.NewServer(miniogw.NewAdmin(zaptest.NewLogger(t), usage))
+		defer server.Close()
+
+		get := func(query, authorization string) *http.Response {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/minio/admin/v3/datausageinfo"+query, nil)
+			require.NoError(t, err)
+			if authorization != "" {
+				req.Header.Set("Authorization", authorization)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			return resp
+		}
+
+		resp := get("", "")
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		require.NoError(t, resp.Body.Close())
+
+		resp = get("?bucket=missing", "Bearer "+accessKey)
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+		require.NoError(t, resp.Body.Close())
+
+		resp = get("?bucket="+TestBucket, "AWS4-HMAC-SHA256 Credential="+accessKey+"/20200101/us-east-1/s3/aws4_request, SignedHeaders=host, Signature=abc")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var served miniogw.DataUsage
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&served))
+		require.NoError(t, resp.Body.Close())
+		require.EqualValues(t, 1, served.BucketsCount)
+		require.Equal(t, miniogw.BucketUsage{Size: 300, ObjectsCount: 2}, served.BucketsUsage[TestBucket])
+	})
+}